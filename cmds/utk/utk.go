@@ -7,6 +7,51 @@
 // Synopsis:
 //     utk BIOS OPERATIONS...
 //
+// BIOS is an image, a directory extracted by `extract`, a manifest ending in
+// .json or a directory with a layout.json manifest, see below. An image may
+// also be given by an http or https URL, it is downloaded into memory. With
+// -sha256, the image file or download must have that SHA-256 hash. The image
+// may be a flash image with a flash descriptor, a bare BIOS region, a single
+// FV or an EFI or vendor (Intel, Lenovo, Toshiba, AMI Aptio) update capsule,
+// which are told apart by their headers. FMP capsules, as shipped by fwupd
+// and Windows Update, are parsed down to their images, and capsule-on-disk
+// files with several capsules down to each capsule. Insyde H2O update files,
+// e.g. isflash.bin, are parsed down to the flash image after the
+// $_IFLASH_BIOSIMG header, the rest of the file is kept. The flash map of
+// Phoenix SCT images is parsed from their NVRAM FV. The CRC32 Apple keeps in
+// their volume headers is updated, the recovery structure of Apple images is
+// kept as it is and not parsed.
+// Signatures of modified capsules and images are not updated.
+//
+// A manifest describes an image to build from scratch, so the layout can be
+// kept under version control. It lists the FVs of the BIOS region with their
+// files and sections, given by the paths of their data, and optionally a
+// flash descriptor and the binaries of the other regions:
+//     {
+//       "Descriptor": "descriptor.bin",
+//       "Regions": {"Intel ME": "me.bin"},
+//       "FVs": [{
+//         "Name": "7CB8BDC9-F8EB-4F34-AAEA-3EE4AF6516A1",
+//         "FileSystem": "FFS2", "Size": 1048576, "BlockSize": 4096,
+//         "Files": [{
+//           "GUID": "7C04A583-9E3E-4F1C-AD65-E05268D0B4D1",
+//           "Type": "DRIVER", "Name": "Shell", "Compression": "LZMA",
+//           "Sections": [{"Type": "PE32", "Path": "Shell.efi"}]
+//         }]
+//       }]
+//     }
+// FVs follow each other unless they have an Offset in the BIOS region, they
+// are as large as their files unless they have a Size. Files without sections,
+// e.g. raw files, have a Path instead. FIRMWARE_VOLUME_IMAGE sections hold an
+// FV instead of a Path. Instead of listing the files, an FV may also take
+// them from a directory, given by "Dir": the complete FFS files ending in .ffs
+// as built by EDK2, and the PE32 images ending in .efi, which are named
+// GUID.efi or NAME_GUID.efi and put into DRIVER files. Without a Descriptor,
+// one is generated to hold the Regions, with the BIOS region at the top of the
+// smallest flash chip they fit into. To create an image from a directory of
+// such files, put the manifest into the directory as layout.json. See
+// visitors.Manifest for all fields.
+//
 // Examples:
 //     # Dump everything to JSON:
 //     utk winterfell.rom json
@@ -23,6 +68,12 @@
 //     # Re-assemble the directory into an image:
 //     utk winterfell/ save winterfell2.rom
 //
+//     # Build an image from a manifest:
+//     utk layout.json save winterfell.rom
+//
+//     # Create an image from a directory with layout.json and .ffs files:
+//     utk winterfell-src/ save winterfell.rom
+//
 //     # Remove two files by their GUID and replace shell with Linux:
 //     utk winterfell.rom \
 //       remove 12345678-9abc-def0-1234-567890abcdef \
@@ -31,82 +82,534 @@
 //       save winterfell2.rom
 //
 // Operations:
+//     The operations are listed with `utk -h`. Operation flags may also be
+//     given right after the operation, e.g. `find_name -ignore-case REGEX`.
 //     `json`: Dump the entire parsed image (excluding binary data) as JSON to
-//             stdout.
+//             stdout. Each node includes its offset in the image and its
+//             length. With `-filter PATH`, e.g. `json -filter /bios/1`, only
+//             the nodes at PATH are dumped, one document each. PATH is as in
+//             `ls`, but selects all matching children and "*" matches any.
+//             PE32 sections with HII resources include their setup forms,
+//             questions, variable offsets, options and strings.
 //     `table`: Dump GUIDs and sizes to a compact table. This is only for human
-//              consumption and the format may change without notice.
+//              consumption and the format may change without notice. With
+//              `-hide-deleted` deleted files are not shown.
+//     `ls PATH`: List the children of the node at PATH with their GUID, name,
+//                type, flash offset and size. PATH is a "/" separated list
+//                of child indices, GUIDs, names or types, e.g. `/bios/0`.
+//                With `-hide-deleted` deleted files are not listed, they
+//                are still kept in the image.
+//     `diff IMAGE`: Compare the image with IMAGE node by node. Added, removed
+//                   and changed nodes are printed with "+", "-" and "~",
+//                   along with their changed fields and content hashes.
+//                   Files are matched by GUID. With `-diff-json` the
+//                   differences are printed as JSON.
+//     `grep PATTERN`: Search the leaf nodes, including decompressed
+//                     sections, for PATTERN and print the path, file and
+//                     offset of each match. With `-grep-type hex` PATTERN
+//                     is hex bytes, with `-grep-type regex` a regex.
+//                     `-ignore-case` applies to strings and regexes.
+//     `strings`: Print the printable ASCII and UTF-16LE strings of the leaf
+//                nodes, including decompressed sections, with the path,
+//                file and offset of each. `-strings-min-len N` sets the
+//                minimum length (default 4).
+//     `report FILE`: Write an HTML report of the image to FILE, with the
+//                    region map, the free space of the FVs, the
+//                    verification results and the tree. It needs nothing
+//                    but a browser to be read.
+//     `flash_map FILE`: Write an SVG map of the physical layout of the image
+//                       to FILE, drawn to scale, with the regions, FVs,
+//                       files, free space and the locations of the FIT
+//                       entries, e.g. the microcode and the startup ACM.
+//     `sizes`: List the files of each FV ordered by size, largest first,
+//              with their uncompressed size and cumulative totals. With
+//              `-sizes-sort uncompressed` they are ordered by their
+//              uncompressed size. Files in a compressed FV have the size
+//              they take in the decompressed FV.
+//     `compression`: List the compressed sections of each FV with their
+//                    algorithm, size, expanded size and ratio, along with
+//                    totals per FV and per algorithm.
+//     `hashdb_create FILE`: Write the SHA-256 of every module of the image,
+//                           including those in compressed FVs, to the JSON
+//                           hash database FILE, e.g. from a golden image.
+//     `hashdb_check FILE`: Compare the modules of the image with the hash
+//                          database FILE and print the added (+), removed
+//                          (-) and modified (~) ones. The exit status is 4
+//                          if there are any.
+//     `lvfs_metainfo ID FILE`: Write the AppStream metainfo for publishing
+//                              the assembled image on the LVFS to FILE. The
+//                              device GUIDs are the image type IDs of an FMP
+//                              capsule, or -lvfs-guid. The version is the
+//                              most common one of the version sections, or
+//                              the ME version, or -lvfs-version.
+//     `uefitool_export DIR`: Write the image to DIR in the dump layout of
+//                            UEFIExtract, a directory per node with
+//                            header.bin, body.bin and info.txt, and the
+//                            report table to DIR.report.txt.
+//     `uefitool_import DIR`: Read back a dump in the layout of UEFIExtract
+//                            and replace the sections and raw files whose
+//                            body.bin was modified. Files are matched by
+//                            GUID, sections by their index in the file.
+//     `ufp_json`: Dump the image as JSON in the object structure of
+//                 uefi-firmware-parser, with the class, GUID, name, type,
+//                 offset in the parent and size of each object.
+//     `chipsec_whitelist FILE`: Write the EFI whitelist of chipsec's
+//                               tools.uefi.whitelist module to FILE, with
+//                               the hashes of each PE32, TE and PIC image.
+//     `create_fv (FFS2|FFS3) FVNAME SIZE BLOCKSIZE`: Create an empty FV with
+//                            the FVName GUID and place it into the first
+//                            erased free space of the BIOS region large
+//                            enough, aligned to the block size.
+//     `linuxboot KERNEL FILE`: Remove the DXE drivers matching
+//                              -linuxboot-remove from the FV holding the
+//                              DXE core, drop its deleted and pad files,
+//                              add KERNEL as the LinuxBoot application and
+//                              the optional -linuxboot-initramfs to it, and
+//                              save the image to FILE.
+//     `remove_list LIST`: Remove the DXE drivers whose name or GUID matches
+//                         a line of the LIST file. Each line is a regular
+//                         expression, blank lines and lines starting with
+//                         # are ignored.
+//     `keep_list LIST`: Remove all DXE drivers except those matching a line
+//                       of the LIST file. Other file types are kept.
+//     `patch_depex (GUID|NAME) (TRUE|GUID)`: Rewrite the dependency
+//                            expressions of the matching files. With TRUE,
+//                            they are replaced with TRUE. With a protocol
+//                            GUID, its terms are treated as satisfied and
+//                            the expression is simplified.
+//     `pcd`: Print the PEI and DXE PCD databases as JSON, with the token
+//            numbers, types and default values of the dynamic PCDs.
+//     `set_pcd (NAME|TOKEN) VALUE`: Set the default value of a DATA PCD of
+//                                   a fixed size in its PCD database. The
+//                                   PCD is given by its name, if the
+//                                   database has names, or token number.
+//     `setup_var (PROMPT|VARSTORE:OFFSET) VALUE`: Set a Setup question,
+//                            given by its prompt or its offset in the var
+//                            store, e.g. Setup:0x1a. The value is written
+//                            into the NVRAM variables of the var store,
+//                            also the AMI NVRAM and StdDefaults, and
+//                            becomes the default of the question in the
+//                            HII forms.
+//     `acpi`: Print the ACPI tables of the raw files and raw sections, with
+//             their signatures, OEM IDs and revisions, as JSON. The OEM
+//             activation tables, SLIC and MSDM, are found anywhere in them.
+//     `extract_acpi SIGNATURE FILE`: Write the ACPI table with the
+//                                    SIGNATURE, e.g. SLIC, to FILE. With
+//                                    `-acpi-index N` the Nth table with the
+//                                    SIGNATURE is written.
+//     `replace_acpi FILE`: Replace all copies of the ACPI table with the
+//                          signature of the table in FILE, or only the Nth
+//                          one with `-acpi-index N`. The length must not
+//                          change, the checksums are fixed.
+//     `smbios`: Print the default SMBIOS structures of the BIOS, the system,
+//               the baseboard and the chassis, with their strings, as JSON.
+//     `set_smbios TYPE.FIELD VALUE`: Set a string of the default SMBIOS
+//                                    structures, e.g. System.SerialNumber.
+//                                    The value may not be longer than the
+//                                    current string.
+//     `rom_layout`: Print the AMI ROM layouts, the ROM_AREA descriptors used
+//                   by the AMI flash tools, as JSON. FV areas which do not
+//                   describe an FV of the BIOS region anymore are Stale,
+//                   they are also warned about when the image is
+//                   assembled.
+//     `update_rom_layout`: Update the stale FV areas of the AMI ROM layouts
+//                          to the sizes of the resized FVs.
+//     `ec`: Print the EC firmware found in the BIOS region, in the padding,
+//           raw files and raw sections, as JSON. ITE, Microchip and Nuvoton
+//           firmware is identified by its signatures.
+//     `extract_ec FILE`: Write the EC firmware to FILE, the one selected with
+//                        `-ec-index N` if there are several.
+//     `replace_ec FILE`: Replace the EC firmware with the firmware in FILE,
+//                        of the same vendor. Firmware in the padding may not
+//                        grow.
+//     `smm`: List all SMM modules with their GUIDs, names, types and sizes.
 //     `find (GUID|NAME)`: Dump the JSON of one or more files. The file is
 //                         found by a regex match to its GUID or name in the UI
 //                         section.
+//     `find_type TYPE`: Dump the JSON of all files of the given type, e.g.
+//                       DRIVER, PEIM, APPLICATION, SMM or RAW.
+//     `find_name REGEX`: Dump the JSON of all files whose UI section name
+//                        matches REGEX. GUIDs are not matched.
 //     `remove (GUID|NAME)`: Remove the first file which matches the given GUID
 //                           or NAME. The same matching rules and exit status
 //                           are used as `find`.
-//     `replace (GUID|NAME) FILE`: Replace the first file which matches the
-//                                 given GUID or NAME with the contents of
-//                                 FILE. The same matching rules and exit
-//                                 status are used as `find`.
+//     `replace_pe32 (GUID|NAME) FILE`: Replace the PE32 section of the files
+//                                      which match the given GUID or NAME
+//                                      with the contents of FILE. The same
+//                                      matching rules and exit status are
+//                                      used as `find`.
+//     `rename_guid (GUID|NAME) NEWGUID`: Change the GUID of the files which
+//                                        match the given GUID or NAME. Apriori
+//                                        files are updated unless
+//                                        `-update-apriori=false` is passed.
+//     `set_name (GUID|NAME) NEWNAME`: Set the UI section of the files which
+//                                     match the given GUID or NAME, adding
+//                                     one if needed.
+//     `exec (GUID|NAME) COMMAND`: Run COMMAND, split at spaces, on the files
+//                                 which match the given GUID or NAME. It gets
+//                                 the JSON of the file on the first line of
+//                                 its stdin, followed by the file with its
+//                                 header. The file is replaced by the output
+//                                 of COMMAND, unless it is empty.
+//     `dump (GUID|NAME) FILE`: Write the one file which matches the given GUID
+//                              or NAME, including its header, to FILE.
+//     `dump_section (GUID|NAME) TYPE FILE`: Write the payload of the first
+//                                           section of TYPE (e.g. PE32) of the
+//                                           matching file to FILE.
+//                                           Compressed sections are searched.
+//     `replace_fv (INDEX|FVNAME) FILE`: Replace an FV of the BIOS region,
+//                                       selected by its index or the FVName
+//                                       GUID of its extended header, with the
+//                                       FV in FILE. The new FV must not be
+//                                       larger, the remainder is erased.
+//     `remove_fv (INDEX|FVNAME)`: Remove an FV of the BIOS region, selected as
+//                                 in `replace_fv`, and erase its extent.
+//     `tighten_fv (INDEX|FVNAME)`: Shrink an FV of the BIOS region, selected
+//                                  as in `replace_fv`, to the smallest block
+//                                  aligned size holding its files. The
+//                                  reclaimed space is erased.
+//     `extract_csm FILE`: Write the CSM16 legacy BIOS binary to FILE.
+//     `replace_csm FILE`: Replace the CSM16 legacy BIOS binary with FILE.
 //     `save FILE`: Save the current state of the image to the give file.
 //                  Remember that operations are applied left-to-right, so only
 //                  the operations to the left are included in the new image.
 //     `extract DIR`: Extract the BIOS to the given directory. Remember that
 //                    operations are applied left-to-right, so only the
 //                    operations to the left are included in the new image.
+//                    The SHA-256 of the extracted files is recorded in
+//                    summary.json and checked when reading the directory,
+//                    except with `-parse-mode permissive`.
+//     `nvram_gc`: Compact the NVRAM variable stores, dropping deleted
+//                 variables and resetting the free space.
+//     `rebase`: Rebase the PE32 and TE images of execute in place modules
+//               (SEC, PEI core and PEIMs) to their flash address, e.g. after
+//               `replace_fv` moved their FV.
+//     `strip_pe32`: Strip the debug data of all PE32 images and the
+//                   relocations of execute in place modules in flash.
+//     `add_vscc JEDECID VSCC`: Add an entry for a replacement SPI flash chip
+//                              to the VSCC table of the flash descriptor.
+//     `add_region REGION OFFSET SIZE`: Define the unused region REGION, by
+//                                      name or index, at OFFSET in the flash
+//                                      descriptor, e.g. a PD or EC region.
+//                                      It is erased, or holds the contents
+//                                      of -region-file.
+//     `remove_region REGION`: Mark the region REGION unused in the flash
+//                             descriptor and erase its space.
+//     `generate_fit ADDRESS`: Build a FIT from the microcode updates and the
+//                             startup ACM found in the image, write it to
+//                             the erased space at the memory ADDRESS and
+//                             set the FIT pointer. An ADDRESS of 0 replaces
+//                             the current FIT.
+//     `security`: Print the FIT and whether the signature of the startup ACM
+//                 is valid and production or debug, with the hash of its
+//                 key. Intel does not publish its key hashes, the keys to
+//                 trust are given in a JSON file with `-acm-keys`, see
+//                 uefi.LoadACMKeys. Known keys are named and tell whether
+//                 the ACM is production or debug signed, otherwise its
+//                 header does. Also print the Boot Guard manifests, their
+//                 key hashes and whether the image is set up for measured
+//                 or verified boot, by the manifests and the Boot Guard
+//                 profile of the straps, if it is known for the chipset.
+//     `resign_bootguard KMKEY BPMKEY`: Update the IBB hash of the Boot Guard
+//                                     boot policy manifest and re-sign it
+//                                     with the PEM RSA key BPMKEY, and
+//                                     re-sign the key manifest with KMKEY.
+//     `me_info`: Print the ME firmware version, SKU and partitions.
+//     `me_mfs`: List the files and configuration records of the ME file
+//               system.
+//     `extract_me_partition NAME FILE`: Write the ME partition NAME to FILE.
+//     `replace_me_partition NAME FILE`: Replace the ME partition NAME with the
+//                                       contents of FILE and update the FPT.
+//                                       The partition is not re-signed.
+//     `pd_info`: Print the blobs of the PD region, split at the erased
+//                blocks in between them, with their types: FV, CPD (a CSME
+//                code partition directory), EC firmware or data.
+//     `extract_pd_blob INDEX FILE`: Write the PD blob INDEX to FILE.
+//     `replace_pd_blob INDEX FILE`: Replace the PD blob INDEX with the
+//                                   contents of FILE. It may grow into the
+//                                   erased space up to the next blob.
+//
+// Scanning:
+//     `utk BLOB scan DIR` does not parse BLOB as an image, it searches any
+//     binary, e.g. a memory dump or an update payload, for FVs, microcode
+//     updates and ME regions (by their FPT) and writes each one found to DIR,
+//     named by its offset and type. They are listed on stdout. The exit
+//     status is 1 if nothing is found. Other operations cannot be combined
+//     with `scan`, but they can be run on the extracted FVs.
+//
+// Flags:
+//     `-ignore-case`: Match names case insensitively in `find_name`.
+//     `-update-apriori`: Update apriori files in `rename_guid` (default true).
+//     `-fv-allow LIST`: Comma separated FV filesystem GUIDs or names (e.g.
+//                       FFS1) whose files are parsed in addition to FFS2/3.
+//     `-fv-deny LIST`: Comma separated FV filesystem GUIDs or names which are
+//                      kept as opaque blobs.
+//     `-keep-relocs LIST`: Comma separated file GUIDs whose relocations are
+//                          kept by `strip_pe32`.
+//     `-guided-passthrough`: Keep GUID defined sections with an unknown
+//                           processing GUID as they are when assembling,
+//                           even if they have encapsulated sections.
+//     `-compact`: Drop deleted files and deleted variables when assembling.
+//                 The remaining files and variables are moved to the start
+//                 of their FV or variable store, the rest is free space.
+//     `-fv-size FV=SIZE,...`: Force FVs to a size when saving, e.g. to fit a
+//                             fixed flash map. The FV is its name GUID or
+//                             its offset in the BIOS region. Smaller FVs are
+//                             padded with the erase polarity, the volume top
+//                             file is kept at the top. Saving fails if the
+//                             files do not fit, listing the largest ones.
+//     `-linuxboot-remove LIST`: Comma separated regular expressions of the
+//                               names or GUIDs of the DXE drivers removed
+//                               by `linuxboot`.
+//     `-linuxboot-initramfs PATH`: Initramfs added by `linuxboot` as a
+//                                  FREEFORM file next to the kernel.
+//     `-lzma-backend go|xz`: LZMA implementation encoding the LZMA sections
+//                            when saving, the pure Go one (default) or the
+//                            `xz` program. They are always decoded by the
+//                            pure Go one, which bounds the decoded size.
+//     `-parse-mode strict|warn|permissive|recover`: Handling of anomalies
+//                                                   in the image, such as bad
+//                                                   checksums, truncated
+//                                                   sections and invalid file
+//                                                   states. They are fatal,
+//                                                   logged (default) or
+//                                                   ignored. Unless fatal,
+//                                                   unparsable sections are
+//                                                   kept as they are. With
+//                                                   recover, unparsable FVs
+//                                                   and files are kept as
+//                                                   they are too, with their
+//                                                   ParseError set. With
+//                                                   permissive, the hashes
+//                                                   of extracted files are
+//                                                   not checked either.
+//     `-erase-polarity attribute|infer|0x00|0xff`: Erase polarity of the FVs.
+//                                                  By default the
+//                                                  ERASE_POLARITY attribute
+//                                                  is trusted. With infer,
+//                                                  the dominant fill byte of
+//                                                  an FV is used if it
+//                                                  contradicts the
+//                                                  attribute. The attribute
+//                                                  in the header is kept.
+//     `-build-report PATH`: EDK2 build report, or source tree with the .inf
+//                           files of the modules. Files are annotated with
+//                           the name and .inf path of the module of their
+//                           GUID in the JSON output.
+//     `-depex-check off|warn|refuse`: Handling of remaining modules whose
+//                                     depex pushes a protocol only the
+//                                     files removed by `remove`,
+//                                     `remove_list`, `keep_list` or
+//                                     `linuxboot` were found to hold. They
+//                                     are listed as warnings (default), or
+//                                     the removal fails.
+//     `-protect LIST`: Comma separated GUIDs of protected files, such as
+//                      silicon init drivers. Operations which remove,
+//                      replace or patch files or FVs fail rather than
+//                      touch them.
+//     `-protect-file PATH`: File with the GUIDs of protected files, one per
+//                           line. Blank lines and lines starting with # are
+//                           ignored, text after the GUID is a comment.
+//     `-json-errors`: Report errors as a JSON object on stderr, with the
+//                     Category, ExitCode and Error fields.
+//
+// Exit status:
+//     0: Success.
+//     1: An operation failed.
+//     2: Usage error, e.g. an unknown operation or invalid argument.
+//     3: The image or directory could not be read or parsed.
+//     4: An integrity check failed, e.g. an extracted file was modified.
+//     5: An output file could not be written.
 package main
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"strings"
 
+	"github.com/linuxboot/fiano/pkg/fiano"
 	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
 	"github.com/linuxboot/fiano/pkg/visitors"
 )
 
+var (
+	fvAllow     = flag.String("fv-allow", "", "comma separated list of FV filesystem GUIDs or names to parse")
+	fvDeny      = flag.String("fv-deny", "", "comma separated list of FV filesystem GUIDs or names to keep opaque")
+	parseMode   = flag.String("parse-mode", uefi.ParseWarn.String(), "handling of anomalies in the image, strict, warn, permissive or recover")
+	polarity    = flag.String("erase-polarity", uefi.PolarityAttribute.String(), "erase polarity of FVs, attribute, infer, 0x00 or 0xff")
+	buildReport = flag.String("build-report", "", "EDK2 build report or source tree with .inf files, to annotate files with their modules")
+	jsonErrors  = flag.Bool("json-errors", false, "report errors as JSON objects on stderr")
+	protect     = flag.String("protect", "", "comma separated GUIDs of files which must not be removed, replaced or patched")
+	protectFile = flag.String("protect-file", "", "file with the GUIDs of protected files, one per line")
+	imageSHA256 = flag.String("sha256", "", "SHA-256 of the image file or URL, checked before it is parsed")
+)
+
+// applyFVList calls apply for every FV GUID in the comma separated list.
+func applyFVList(list string, apply func(uuid.UUID)) error {
+	if list == "" {
+		return nil
+	}
+	for _, s := range strings.Split(list, ",") {
+		g, err := uefi.ParseFVGUID(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		apply(*g)
+	}
+	return nil
+}
+
+// Exit codes, see the package documentation.
+const (
+	exitFailure = 1
+	exitUsage   = 2
+	exitParse   = 3
+	exitVerify  = 4
+	exitWrite   = 5
+)
+
+var exitCategories = map[int]string{
+	exitFailure: "failure",
+	exitUsage:   "usage",
+	exitParse:   "parse",
+	exitVerify:  "verify",
+	exitWrite:   "write",
+}
+
+// fail reports the error and exits with the code of its category. Integrity
+// check failures are always reported as such.
+func fail(code int, err error) {
+	if _, ok := err.(*visitors.VerifyError); ok {
+		code = exitVerify
+	}
+	if *jsonErrors {
+		json.NewEncoder(os.Stderr).Encode(struct {
+			Category string
+			ExitCode int
+			Error    string
+		}{exitCategories[code], code, err.Error()})
+	} else {
+		log.Print(err)
+	}
+	os.Exit(code)
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [flags] BIOS OPERATIONS...\n\nFlags:\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nOperations:\n")
+	visitors.Usage(out)
+	fmt.Fprintf(out, "  scan DIR\n    \tSearch any binary for FVs, microcode updates and ME regions and\n"+
+		"    \twrite them to DIR. It cannot be combined with other operations.\n")
+}
+
+// scan carves the firmware structures found in the blob at path into dir.
+func scan(path, dir string) {
+	blob, err := ioutil.ReadFile(path)
+	if err != nil {
+		fail(exitParse, err)
+	}
+	found := uefi.Scan(blob)
+	if len(found) == 0 {
+		fail(exitFailure, fmt.Errorf("no firmware structures found in %v", path))
+	}
+	for _, c := range found {
+		name := fmt.Sprintf("%#08x-%s.bin", c.Offset, strings.ToLower(c.Type))
+		fp, err := uefi.ExtractBinary(c.Buf(), dir, name)
+		if err != nil {
+			fail(exitWrite, err)
+		}
+		fmt.Printf("%#08x %#08x %-9s %s\n", c.Offset, c.Length, c.Type, fp)
+	}
+}
+
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 	if flag.NArg() == 0 {
-		log.Fatal("at least one argument is required")
+		usage()
+		fail(exitUsage, errors.New("at least one argument is required"))
+	}
+	if err := applyFVList(*fvAllow, uefi.AllowFV); err != nil {
+		fail(exitUsage, err)
+	}
+	if err := applyFVList(*fvDeny, uefi.DenyFV); err != nil {
+		fail(exitUsage, err)
+	}
+	mode, err := uefi.ParseParseMode(*parseMode)
+	if err != nil {
+		fail(exitUsage, err)
+	}
+	uefi.SetParseMode(mode)
+	pmode, err := uefi.ParsePolarityMode(*polarity)
+	if err != nil {
+		fail(exitUsage, err)
+	}
+	uefi.SetPolarityMode(pmode)
+	if *buildReport != "" {
+		sources, err := uefi.LoadModuleSources(*buildReport)
+		if err != nil {
+			fail(exitUsage, err)
+		}
+		uefi.SetModuleSources(sources)
+	}
+	protected, err := visitors.ParseGUIDList(*protect)
+	if err != nil {
+		fail(exitUsage, err)
+	}
+	if *protectFile != "" {
+		guids, err := visitors.ReadGUIDList(*protectFile)
+		if err != nil {
+			fail(exitUsage, err)
+		}
+		protected = append(protected, guids...)
+	}
+	visitors.SetProtected(protected)
+
+	if flag.NArg() >= 2 && flag.Arg(1) == "scan" {
+		if flag.NArg() != 3 {
+			fail(exitUsage, errors.New("scan takes a single DIR argument"))
+		}
+		scan(flag.Arg(0), flag.Arg(2))
+		return
 	}
 
 	v, err := visitors.ParseCLI(flag.Args()[1:])
 	if err != nil {
-		log.Fatal(err)
+		fail(exitUsage, err)
 	}
 
 	// Load and parse the image.
-	// TODO: if os.Args[1] is a directory, re-assemble it
-	path := flag.Args()[0]
-	f, err := os.Stat(path)
-	if err != nil {
-		log.Fatal(err)
-	}
-	var parsedRoot uefi.Firmware
-	if m := f.Mode(); m.IsDir() {
-		// Call ParseDir
-		pd := visitors.ParseDir{DirPath: path}
-		if parsedRoot, err = pd.Parse(); err != nil {
-			log.Fatal(err)
-		}
-		// Assemble the tree from the bottom up
-		a := visitors.Assemble{}
-		if err = a.Run(parsedRoot); err != nil {
-			log.Fatal(err)
+	var im *fiano.Image
+	if *imageSHA256 != "" {
+		sum, herr := hex.DecodeString(*imageSHA256)
+		if herr != nil {
+			fail(exitUsage, fmt.Errorf("invalid SHA-256 %q: %v", *imageSHA256, herr))
 		}
+		im, err = fiano.OpenPinned(flag.Args()[0], sum)
 	} else {
-		// Regular file
-		image, err := ioutil.ReadFile(path)
-		if err != nil {
-			log.Fatal(err)
-		}
-		parsedRoot, err = uefi.Parse(image)
-		if err != nil {
-			log.Fatal(err)
-		}
+		im, err = fiano.Open(flag.Args()[0])
 	}
+	if err != nil {
+		fail(exitParse, err)
+	}
+	parsedRoot := im.Root
 
-	// Execute the instructions from the command line.
+	// Execute the instructions from the command line. Failing to write
+	// the output files is reported separately, failing to read the input
+	// files of an operation is an operation failure.
 	if err := visitors.ExecuteCLI(parsedRoot, v); err != nil {
-		log.Fatal(err)
+		if _, ok := err.(*visitors.WriteError); ok {
+			fail(exitWrite, err)
+		}
+		fail(exitFailure, err)
 	}
 }
@@ -5,11 +5,14 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
+	"syscall"
 	"testing"
 )
 
@@ -139,3 +142,74 @@ func TestExtractAssembleExtract(t *testing.T) {
 		})
 	}
 }
+
+// TestExitCodes tests that the failure categories of UTK are reported with
+// their exit status and as JSON with -json-errors.
+func TestExitCodes(t *testing.T) {
+	// Build UTK.
+	tmpDir, utk := buildUTK(t)
+	defer os.RemoveAll(tmpDir)
+
+	rom := "roms/OVMF.rom"
+	dir := filepath.Join(tmpDir, "extracted")
+	if out, err := exec.Command(utk, rom, "extract", dir).CombinedOutput(); err != nil {
+		t.Fatalf("could not extract %v: %v\n%s", rom, err, out)
+	}
+	// Modify one of the extracted files.
+	fv := filepath.Join(dir, "bios", "0x0", "fv.bin")
+	if err := ioutil.WriteFile(fv, []byte{0}, 0666); err != nil {
+		t.Fatal(err)
+	}
+	notImage := filepath.Join(tmpDir, "not-an-image")
+	if err := os.Mkdir(notImage, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		args     []string
+		code     int
+		category string
+	}{
+		{"success", []string{rom, "table"}, 0, ""},
+		{"usage", []string{rom, "nonexistent"}, 2, "usage"},
+		{"parse", []string{notImage, "table"}, 3, "parse"},
+		{"pinned", []string{"-sha256", strings.Repeat("00", 32), rom, "table"}, 3, "parse"},
+		{"verify", []string{dir, "table"}, 4, "verify"},
+		{"write", []string{rom, "save", filepath.Join(tmpDir, "missing", "out.rom")}, 5, "write"},
+		{"input", []string{rom, "uefitool_import", filepath.Join(tmpDir, "missing")}, 1, "failure"},
+		{"missing image", []string{filepath.Join(tmpDir, "missing.rom"), "save", filepath.Join(tmpDir, "out.rom")}, 3, "parse"},
+		{"failure", []string{rom, "remove_fv", "9"}, 1, "failure"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := exec.Command(utk, append([]string{"-json-errors"}, tt.args...)...)
+			var stderr bytes.Buffer
+			cmd.Stderr = &stderr
+			err := cmd.Run()
+			code := 0
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				code = exitErr.Sys().(syscall.WaitStatus).ExitStatus()
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if code != tt.code {
+				t.Fatalf("got exit status %d, want %d: %s", code, tt.code, stderr.String())
+			}
+			if tt.code == 0 {
+				return
+			}
+			var report struct {
+				Category string
+				ExitCode int
+				Error    string
+			}
+			if err := json.Unmarshal(stderr.Bytes(), &report); err != nil {
+				t.Fatalf("invalid json error %q: %v", stderr.String(), err)
+			}
+			if report.Category != tt.category || report.ExitCode != tt.code || report.Error == "" {
+				t.Errorf("got %+v, want category %q and exit code %d", report, tt.category, tt.code)
+			}
+		})
+	}
+}
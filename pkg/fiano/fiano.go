@@ -0,0 +1,247 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fiano is a high level API for programs embedding fiano. An Image is
+// opened from a file, changed with a few methods and saved, without having to
+// run the visitors or keep the buffers of the tree in sync. Anything else is
+// available through the utk operations of Run, or the tree in Root.
+//
+//	im, err := fiano.Open("winterfell.rom")
+//	if err != nil {
+//		return err
+//	}
+//	if _, err := im.ReplacePE32("Shell", linux); err != nil {
+//		return err
+//	}
+//	return im.Save("winterfell2.rom")
+package fiano
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+// Image is a parsed firmware image.
+type Image struct {
+	// Root is the root of the firmware tree, e.g. a *uefi.FlashImage or a
+	// *uefi.BIOSRegion.
+	Root uefi.Firmware
+}
+
+// Open reads the image at path, the way utk does. A directory is either
+// built from its layout manifest or read back as written by the extract
+// operation, a .json file is a manifest, any other file is parsed as an
+// image. An http or https URL is downloaded, see OpenPinned.
+func Open(path string) (*Image, error) {
+	if isURL(path) {
+		return OpenPinned(path, nil)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var root uefi.Firmware
+	layout := filepath.Join(path, visitors.LayoutFile)
+	if _, err := os.Stat(layout); err == nil && fi.IsDir() {
+		m, err := visitors.ReadManifest(layout)
+		if err != nil {
+			return nil, err
+		}
+		if root, err = m.Build(); err != nil {
+			return nil, err
+		}
+	} else if fi.IsDir() {
+		pd := visitors.ParseDir{DirPath: path}
+		if root, err = pd.Parse(); err != nil {
+			return nil, err
+		}
+		// Assemble the tree from the bottom up
+		if err := (&visitors.Assemble{}).Run(root); err != nil {
+			return nil, err
+		}
+	} else if strings.HasSuffix(path, ".json") {
+		m, err := visitors.ReadManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		if root, err = m.Build(); err != nil {
+			return nil, err
+		}
+	} else {
+		return OpenPinned(path, nil)
+	}
+	return &Image{Root: root}, nil
+}
+
+// Parse parses an image from buf, which is used by the tree and must not be
+// changed afterwards.
+func Parse(buf []byte) (*Image, error) {
+	root, err := uefi.Parse(buf)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Root: root}, nil
+}
+
+// ParseReader parses an image read from r, e.g. a file, an HTTP body or the
+// output of a SPI programmer, see uefi.ParseReader.
+func ParseReader(r io.Reader) (*Image, error) {
+	root, err := uefi.ParseReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{Root: root}, nil
+}
+
+// Bytes assembles the image and returns it.
+func (im *Image) Bytes() ([]byte, error) {
+	if err := (&visitors.Assemble{}).Run(im.Root); err != nil {
+		return nil, err
+	}
+	return im.Root.Buf(), nil
+}
+
+// Save assembles the image and writes it to path.
+func (im *Image) Save(path string) error {
+	return (&visitors.Save{DirPath: path}).Run(im.Root)
+}
+
+// WriteTo assembles the image and writes it to w. It implements io.WriterTo.
+func (im *Image) WriteTo(w io.Writer) (int64, error) {
+	buf, err := im.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.Write(buf)
+	return int64(n), err
+}
+
+// WriteChanged assembles the image and writes the blocks of blockSize bytes
+// which differ from those in cur to w, e.g. to update a flash chip through a
+// SPI programmer, where cur and w are the chip. Blocks past the end of cur
+// are written. It returns the number of blocks written.
+func (im *Image) WriteChanged(w io.WriterAt, cur io.ReaderAt, blockSize int) (int, error) {
+	if blockSize <= 0 {
+		return 0, fmt.Errorf("invalid block size %d", blockSize)
+	}
+	buf, err := im.Bytes()
+	if err != nil {
+		return 0, err
+	}
+	old := make([]byte, blockSize)
+	written := 0
+	for off := 0; off < len(buf); off += blockSize {
+		end := off + blockSize
+		if end > len(buf) {
+			end = len(buf)
+		}
+		block := buf[off:end]
+		n, err := cur.ReadAt(old[:len(block)], int64(off))
+		if err != nil && err != io.EOF {
+			return written, err
+		}
+		if n == len(block) && bytes.Equal(old[:n], block) {
+			continue
+		}
+		if _, err := w.WriteAt(block, int64(off)); err != nil {
+			return written, err
+		}
+		written++
+	}
+	return written, nil
+}
+
+// Extract writes the image to the directory dir, from which Open reads it
+// back.
+func (im *Image) Extract(dir string) (err error) {
+	// The extract operation changes into dir, go back afterwards.
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := os.Chdir(wd); err == nil {
+			err = cerr
+		}
+	}()
+	return (&visitors.Extract{DirPath: dir}).Run(im.Root)
+}
+
+// matchPredicate returns the predicate of find, which matches the GUID or
+// the name of files against the regular expression pattern.
+func matchPredicate(pattern string) (func(f *uefi.File, name string) bool, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(f *uefi.File, name string) bool {
+		return re.MatchString(name) || re.MatchString(f.Header.UUID.String())
+	}, nil
+}
+
+// Find returns the files whose GUID or UI section name matches the regular
+// expression pattern, like the find operation.
+func (im *Image) Find(pattern string) ([]*uefi.File, error) {
+	pred, err := matchPredicate(pattern)
+	if err != nil {
+		return nil, err
+	}
+	find := &visitors.Find{Predicate: pred}
+	if err := find.Run(im.Root); err != nil {
+		return nil, err
+	}
+	return find.Matches, nil
+}
+
+// ReplacePE32 replaces the PE32 sections of the files matched by pattern, as
+// in Find, with pe32. It returns the files, it is an error if there are none.
+func (im *Image) ReplacePE32(pattern string, pe32 []byte) ([]*uefi.File, error) {
+	pred, err := matchPredicate(pattern)
+	if err != nil {
+		return nil, err
+	}
+	replace := &visitors.ReplacePE32{Predicate: pred, NewPE32: pe32}
+	if err := replace.Run(im.Root); err != nil {
+		return nil, err
+	}
+	if len(replace.Matches) == 0 {
+		return nil, fmt.Errorf("no file matches %q", pattern)
+	}
+	return replace.Matches, nil
+}
+
+// Remove removes the files matched by pattern, as in Find. It returns the
+// files, it is an error if there are none.
+func (im *Image) Remove(pattern string) ([]*uefi.File, error) {
+	pred, err := matchPredicate(pattern)
+	if err != nil {
+		return nil, err
+	}
+	remove := &visitors.Remove{Predicate: pred}
+	if err := remove.Run(im.Root); err != nil {
+		return nil, err
+	}
+	if len(remove.Matches) == 0 {
+		return nil, fmt.Errorf("no file matches %q", pattern)
+	}
+	return remove.Matches, nil
+}
+
+// Run applies utk operations with their arguments to the image, e.g.
+// Run("remove_fv", "1", "tighten_fv", "0").
+func (im *Image) Run(args ...string) error {
+	v, err := visitors.ParseCLI(args)
+	if err != nil {
+		return err
+	}
+	return visitors.ExecuteCLI(im.Root, v)
+}
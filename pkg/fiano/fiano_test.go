@@ -0,0 +1,153 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fiano
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const ovmf = "../../integration/roms/OVMF.rom"
+
+func TestOpenSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fiano")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	im, err := Open(ovmf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shell, err := im.Find("^Shell$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shell) != 1 {
+		t.Fatalf("expected one Shell, found %d", len(shell))
+	}
+	if _, err := im.Remove(shell[0].Header.UUID.String()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := im.Remove(shell[0].Header.UUID.String()); err == nil {
+		t.Error("expected an error removing a file which is gone")
+	}
+	if _, err := im.ReplacePE32("NoSuchFile", []byte("MZ")); err == nil {
+		t.Error("expected an error replacing a file which does not exist")
+	}
+
+	out := filepath.Join(dir, "OVMF.rom")
+	if err := im.Save(out); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := Open(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shell, err := saved.Find("^Shell$"); err != nil || len(shell) != 0 {
+		t.Errorf("expected the Shell to be removed, found %d (%v)", len(shell), err)
+	}
+	if _, err := saved.Find("("); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestOpenExtracted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fiano")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	im, err := Open(ovmf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig, err := im.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig = append([]byte{}, orig...)
+	if err := im.Extract(filepath.Join(dir, "ovmf")); err != nil {
+		t.Fatal(err)
+	}
+	extracted, err := Open(filepath.Join(dir, "ovmf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := extracted.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(buf) != len(orig) {
+		t.Errorf("expected the extracted image to have %#x bytes, got %#x", len(orig), len(buf))
+	}
+	if err := extracted.Run("no_such_op"); err == nil {
+		t.Error("expected an error for an unknown operation")
+	}
+	if err := extracted.Run("remove", "^Shell$"); err != nil {
+		t.Fatal(err)
+	}
+	if shell, err := extracted.Find("^Shell$"); err != nil || len(shell) != 0 {
+		t.Errorf("expected the Shell to be removed, found %d (%v)", len(shell), err)
+	}
+}
+
+func TestWriteChanged(t *testing.T) {
+	image, err := ioutil.ReadFile(ovmf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chip, err := ioutil.TempFile("", "fiano")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(chip.Name())
+	defer chip.Close()
+	if _, err := chip.Write(image); err != nil {
+		t.Fatal(err)
+	}
+
+	im, err := ParseReader(bytes.NewReader(image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if n, err := im.WriteTo(&out); err != nil || n != int64(len(image)) {
+		t.Fatalf("expected %#x bytes written, got %#x (%v)", len(image), n, err)
+	}
+	// Assembly may change the compressed sections, write those once.
+	if _, err := im.WriteChanged(chip, chip, 0x1000); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := im.WriteChanged(chip, chip, 0x1000); err != nil || n != 0 {
+		t.Errorf("expected no blocks written for an unchanged image, got %d (%v)", n, err)
+	}
+
+	if _, err := im.Remove("^Shell$"); err != nil {
+		t.Fatal(err)
+	}
+	n, err := im.WriteChanged(chip, chip, 0x1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Error("expected the changed blocks to be written")
+	}
+	if _, err := chip.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := ParseReader(chip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shell, err := updated.Find("^Shell$"); err != nil || len(shell) != 0 {
+		t.Errorf("expected the Shell to be removed from the chip, found %d (%v)", len(shell), err)
+	}
+}
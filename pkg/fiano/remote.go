@@ -0,0 +1,103 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fiano
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// httpClient downloads the images, it gives up on servers which stall.
+var httpClient = &http.Client{Timeout: 5 * time.Minute}
+
+// isURL returns whether path is an http or https URL.
+func isURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// openImage opens the image file or URL at path, and returns its size if it
+// is known. Pipes and devices, e.g. /dev/stdin, are read to their end.
+func openImage(path string) (io.ReadCloser, int64, error) {
+	if isURL(path) {
+		resp, err := httpClient.Get(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, 0, fmt.Errorf("fetching %v: %v", path, resp.Status)
+		}
+		return resp.Body, resp.ContentLength, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	if fi.IsDir() {
+		f.Close()
+		return nil, 0, fmt.Errorf("%v is a directory, not an image file", path)
+	}
+	if !fi.Mode().IsRegular() {
+		return f, -1, nil
+	}
+	return f, fi.Size(), nil
+}
+
+// pinnedReader reads an image of a known size, if size is positive, and
+// fails at its end if it does not have the SHA-256 sum, if sum is set.
+type pinnedReader struct {
+	r    io.Reader
+	size int64
+	h    hash.Hash
+	sum  []byte
+}
+
+func (p *pinnedReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.h.Write(b[:n])
+	if err == io.EOF && p.sum != nil {
+		if got := p.h.Sum(nil); !bytes.Equal(got, p.sum) {
+			return n, fmt.Errorf("image has SHA-256 %x, expected %x", got, p.sum)
+		}
+	}
+	return n, err
+}
+
+// Size returns the size of the image, so it is read into a buffer of the
+// right size.
+func (p *pinnedReader) Size() int64 {
+	if p.size < 0 {
+		return 0
+	}
+	return p.size
+}
+
+// OpenPinned opens the image file or http(s) URL at path like Open, and
+// checks that it has the SHA-256 sum, if sum is set, before it is parsed.
+// Downloads are read into memory as they arrive, without a temporary file.
+// Directories and manifests are not single images, they cannot be pinned.
+func OpenPinned(path string, sum []byte) (*Image, error) {
+	if sum != nil && len(sum) != sha256.Size {
+		return nil, fmt.Errorf("SHA-256 sum has %d bytes, expected %d", len(sum), sha256.Size)
+	}
+	r, size, err := openImage(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ParseReader(&pinnedReader{r: r, size: size, h: sha256.New(), sum: sum})
+}
@@ -0,0 +1,89 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fiano
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestOpenURL(t *testing.T) {
+	image, err := ioutil.ReadFile(ovmf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/OVMF.rom" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(image)
+	}))
+	defer srv.Close()
+	url := srv.URL + "/OVMF.rom"
+	sum := sha256.Sum256(image)
+
+	im, err := Open(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shell, err := im.Find("^Shell$"); err != nil || len(shell) != 1 {
+		t.Errorf("expected the Shell in the downloaded image, found %d (%v)", len(shell), err)
+	}
+	if _, err := OpenPinned(url, sum[:]); err != nil {
+		t.Errorf("expected the pinned download to match: %v", err)
+	}
+	if _, err := OpenPinned(ovmf, sum[:]); err != nil {
+		t.Errorf("expected the pinned file to match: %v", err)
+	}
+
+	wrong := sum
+	wrong[0]++
+	if _, err := OpenPinned(url, wrong[:]); err == nil {
+		t.Error("expected an error for a download with a different SHA-256")
+	}
+	if _, err := OpenPinned(url, sum[:4]); err == nil {
+		t.Error("expected an error for a short SHA-256")
+	}
+	if _, err := Open(srv.URL + "/missing.rom"); err == nil {
+		t.Error("expected an error for a missing download")
+	}
+	if _, err := OpenPinned("../../integration/roms", nil); err == nil {
+		t.Error("expected an error pinning a directory")
+	}
+}
+
+func TestOpenPipe(t *testing.T) {
+	image, err := ioutil.ReadFile(ovmf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("/dev/fd"); err != nil {
+		t.Skip("no /dev/fd to name a pipe")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	go func() {
+		w.Write(image)
+		w.Close()
+	}()
+	sum := sha256.Sum256(image)
+
+	im, err := OpenPinned(fmt.Sprintf("/dev/fd/%d", r.Fd()), sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shell, err := im.Find("^Shell$"); err != nil || len(shell) != 1 {
+		t.Errorf("expected the Shell in the piped image, found %d (%v)", len(shell), err)
+	}
+}
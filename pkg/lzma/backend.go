@@ -0,0 +1,97 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Backend is an implementation of LZMA used by a Coder.
+type Backend string
+
+// Supported backends.
+const (
+	// BackendGo is the pure Go implementation, it is the default.
+	BackendGo Backend = "go"
+	// BackendXZ runs the xz program, which is faster. Its output can be
+	// decoded by any LZMA decoder, but it is not byte for byte the output
+	// of the LZMA SDK.
+	BackendXZ Backend = "xz"
+)
+
+// XZPath is the xz program run by BackendXZ.
+var XZPath = "xz"
+
+// Coder encodes and decodes LZMA data with a backend. The zero Coder uses
+// BackendGo. Each Coder has its own backend, so the choice of one caller does
+// not affect the others.
+type Coder struct {
+	Backend Backend
+}
+
+// NewCoder returns a Coder using the backend. It fails if the backend is
+// unknown or not available, e.g. if xz is not installed.
+func NewCoder(b Backend) (Coder, error) {
+	switch b {
+	case "", BackendGo:
+	case BackendXZ:
+		if err := lookXZ(); err != nil {
+			return Coder{}, fmt.Errorf("lzma backend %v is not available: %v", b, err)
+		}
+	default:
+		return Coder{}, fmt.Errorf("unknown lzma backend %q, expected %v or %v", b, BackendGo, BackendXZ)
+	}
+	return Coder{Backend: b}, nil
+}
+
+// Decode decodes a byte slice of LZMA data, it fails if the data decodes to
+// more than MaxDecodedSize bytes.
+func (c Coder) Decode(encodedData []byte) ([]byte, error) {
+	if c.Backend == BackendXZ {
+		return xzDecode(encodedData)
+	}
+	return goDecode(encodedData)
+}
+
+// Encode encodes a byte slice with LZMA.
+func (c Coder) Encode(decodedData []byte) ([]byte, error) {
+	if c.Backend == BackendXZ {
+		return xzEncode(decodedData)
+	}
+	return goEncode(decodedData)
+}
+
+func xzDecode(encodedData []byte) ([]byte, error) {
+	if len(encodedData) < lzma.HeaderLen {
+		return nil, fmt.Errorf("lzma: %d bytes are too short for a header", len(encodedData))
+	}
+	if size := int64(binary.LittleEndian.Uint64(encodedData[5:])); size > MaxDecodedSize {
+		return nil, fmt.Errorf("lzma: decoded size %#x exceeds the maximum of %#x", size, MaxDecodedSize)
+	}
+	decodedData, err := runXZ(encodedData, MaxDecodedSize, "--decompress")
+	if err == errTooLarge {
+		return nil, fmt.Errorf("lzma: decoded data exceeds the maximum of %#x bytes", MaxDecodedSize)
+	}
+	return decodedData, err
+}
+
+func xzEncode(decodedData []byte) ([]byte, error) {
+	encodedData, err := runXZ(decodedData, math.MaxInt64,
+		fmt.Sprintf("--lzma1=preset=%d,lc=3,lp=0,pb=2", compressionLevel))
+	if err != nil {
+		return nil, err
+	}
+	// xz does not know the size when streaming and leaves it unset in the
+	// header, but EDK2 requires it.
+	if len(encodedData) < 13 {
+		return nil, fmt.Errorf("%v output is too short for an LZMA header", XZPath)
+	}
+	binary.LittleEndian.PutUint64(encodedData[5:13], uint64(len(decodedData)))
+	return encodedData, nil
+}
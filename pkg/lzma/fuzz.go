@@ -0,0 +1,25 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build gofuzz
+// +build gofuzz
+
+package lzma
+
+// Fuzz is the entry point for go-fuzz. It decodes data and returns 1 if it
+// is valid LZMA.
+func Fuzz(data []byte) int {
+	if _, err := Decode(data); err != nil {
+		return 0
+	}
+	return 1
+}
+
+// FuzzX86 is like Fuzz, but for the x86 variant, selected with -func.
+func FuzzX86(data []byte) int {
+	if _, err := DecodeX86(data); err != nil {
+		return 0
+	}
+	return 1
+}
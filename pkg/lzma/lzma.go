@@ -5,12 +5,14 @@
 // Package lzma implements reading and writing of LZMA compressed files.
 //
 // This package is specifically designed for the LZMA format used popular UEFI
-// implementations. It requires the `lzma` and `unlzma` programs to be
-// installed and on the path.
+// implementations. By default it uses a pure Go implementation, a Coder
+// with BackendXZ uses the `xz` program instead.
 package lzma
 
 import (
 	"bytes"
+	"encoding/binary"
+	"fmt"
 	"io"
 	"io/ioutil"
 
@@ -21,17 +23,57 @@ import (
 var lzmaDictCapExps = []uint{18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
 var compressionLevel = 7
 
-// Decode decodes a byte slice of LZMA data.
+// MaxDecodedSize is the maximum size of decoded data. The size in the header
+// is not trusted, a few bytes of LZMA data can decode to gigabytes.
+var MaxDecodedSize int64 = 256 << 20
+
+// Decode decodes a byte slice of LZMA data with the pure Go implementation.
 func Decode(encodedData []byte) ([]byte, error) {
-	r, err := lzma.NewReader(bytes.NewBuffer(encodedData))
+	return goDecode(encodedData)
+}
+
+// Encode encodes a byte slice with LZMA with the pure Go implementation.
+func Encode(decodedData []byte) ([]byte, error) {
+	return goEncode(decodedData)
+}
+
+func goDecode(encodedData []byte) ([]byte, error) {
+	if len(encodedData) < lzma.HeaderLen {
+		return nil, fmt.Errorf("lzma: %d bytes are too short for a header", len(encodedData))
+	}
+	header := append([]byte{}, encodedData[:lzma.HeaderLen]...)
+	size := int64(binary.LittleEndian.Uint64(header[5:]))
+	if size > MaxDecodedSize {
+		return nil, fmt.Errorf("lzma: decoded size %#x exceeds the maximum of %#x", size, MaxDecodedSize)
+	}
+	// The dictionary is allocated up front, but never holds more than the
+	// decoded data.
+	maxDictCap := size
+	if maxDictCap < 0 {
+		maxDictCap = MaxDecodedSize
+	}
+	if maxDictCap < lzma.MinDictCap {
+		maxDictCap = lzma.MinDictCap
+	}
+	if int64(binary.LittleEndian.Uint32(header[1:5])) > maxDictCap {
+		binary.LittleEndian.PutUint32(header[1:5], uint32(maxDictCap))
+	}
+	rc := lzma.ReaderConfig{DictCap: lzma.MinDictCap}
+	r, err := rc.NewReader(io.MultiReader(bytes.NewReader(header), bytes.NewReader(encodedData[lzma.HeaderLen:])))
+	if err != nil {
+		return nil, err
+	}
+	decodedData, err := ioutil.ReadAll(io.LimitReader(r, MaxDecodedSize+1))
 	if err != nil {
 		return nil, err
 	}
-	return ioutil.ReadAll(r)
+	if int64(len(decodedData)) > MaxDecodedSize {
+		return nil, fmt.Errorf("lzma: decoded data exceeds the maximum of %#x bytes", MaxDecodedSize)
+	}
+	return decodedData, nil
 }
 
-// Encode encodes a byte slice with LZMA.
-func Encode(decodedData []byte) ([]byte, error) {
+func goEncode(decodedData []byte) ([]byte, error) {
 	// These options are supported by the xz's LZMA command and EDK2's LZMA.
 	// TODO: This does not support the f86 feature used in EDK2.
 	wc := lzma.WriterConfig{
@@ -5,9 +5,14 @@
 package lzma
 
 import (
+	"bytes"
+	"encoding/binary"
 	"io/ioutil"
 	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
 )
 
 var tests = []struct {
@@ -82,3 +87,117 @@ func TestDecode(t *testing.T) {
 		})
 	}
 }
+
+func TestXZBackend(t *testing.T) {
+	xz, err := NewCoder(BackendXZ)
+	if err != nil {
+		t.Skip(err)
+	}
+	xzTests := []struct {
+		name            string
+		encodedFilename string
+		encode          func([]byte) ([]byte, error)
+		decode          func([]byte) ([]byte, error)
+		goDecode        func([]byte) ([]byte, error)
+	}{
+		{"random data", "testdata/random.bin.lzma", xz.Encode, xz.Decode, Decode},
+		{"random data x86", "testdata/random.bin.lzma86", xz.EncodeX86, xz.DecodeX86, DecodeX86},
+	}
+
+	want, err := ioutil.ReadFile("testdata/random.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range xzTests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, err := tt.encode(want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The data must be readable by the Go backend, which checks
+			// the size in the header.
+			got, err := tt.goDecode(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("decompressed image did not match, (got: %d bytes, want: %d bytes)", len(got), len(want))
+			}
+
+			fixture, err := ioutil.ReadFile(tt.encodedFilename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, err = tt.decode(fixture); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("xz decompressed image did not match, (got: %d bytes, want: %d bytes)", len(got), len(want))
+			}
+		})
+	}
+
+	// The xz backend is bound by MaxDecodedSize too, whether the size in
+	// the header tells it or not.
+	fixture, err := ioutil.ReadFile("testdata/random.bin.lzma")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(max int64) { MaxDecodedSize = max }(MaxDecodedSize)
+	MaxDecodedSize = 0x100
+	if _, err := xz.Decode(fixture); err == nil {
+		t.Error("expected an error for a decoded size above the maximum")
+	}
+	// Without the size, xz decodes up to the end of stream marker.
+	var unknownSize bytes.Buffer
+	w, err := lzma.WriterConfig{EOSMarker: true}.NewWriter(&unknownSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(make([]byte, 0x100000))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := xz.Decode(unknownSize.Bytes()); err == nil || !strings.Contains(err.Error(), "decoded data exceeds") {
+		t.Errorf("expected an error for decoded data above the maximum, got %v", err)
+	}
+}
+
+func TestNewCoder(t *testing.T) {
+	if _, err := NewCoder("zip"); err == nil {
+		t.Error("expected an error for an unknown backend")
+	}
+	c, err := NewCoder(BackendGo)
+	if err != nil || c.Backend != BackendGo {
+		t.Errorf("expected a Go coder, got %+v, %v", c, err)
+	}
+	// Another coder is not affected by the choice of this one.
+	if (Coder{}).Backend != "" {
+		t.Errorf("the zero coder does not use the Go backend")
+	}
+}
+
+func TestDecodeLimits(t *testing.T) {
+	encoded, err := Encode([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The dictionary size is not trusted.
+	bigDict := append([]byte{}, encoded...)
+	binary.LittleEndian.PutUint32(bigDict[1:5], 0xFFFFFFFF)
+	if decoded, err := Decode(bigDict); err != nil || string(decoded) != "hello" {
+		t.Errorf("expected %q for a huge dictionary, got %q, %v", "hello", decoded, err)
+	}
+
+	// Neither is the decoded size.
+	bigSize := append([]byte{}, encoded...)
+	binary.LittleEndian.PutUint64(bigSize[5:13], uint64(MaxDecodedSize+1))
+	if _, err := Decode(bigSize); err == nil {
+		t.Error("expected an error for a decoded size above the maximum")
+	}
+
+	if _, err := Decode(encoded[:12]); err == nil {
+		t.Error("expected an error for a truncated header")
+	}
+}
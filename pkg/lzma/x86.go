@@ -4,9 +4,21 @@
 
 package lzma
 
-// DecodeX86 decodes LZMA data with the x86 extension.
+// DecodeX86 decodes LZMA data with the x86 extension with the pure Go
+// implementation.
 func DecodeX86(encodedData []byte) ([]byte, error) {
-	decodedData, err := Decode(encodedData)
+	return Coder{}.DecodeX86(encodedData)
+}
+
+// EncodeX86 encodes LZMA data with the x86 extension with the pure Go
+// implementation.
+func EncodeX86(decodedData []byte) ([]byte, error) {
+	return Coder{}.EncodeX86(decodedData)
+}
+
+// DecodeX86 decodes LZMA data with the x86 extension.
+func (c Coder) DecodeX86(encodedData []byte) ([]byte, error) {
+	decodedData, err := c.Decode(encodedData)
 	if err != nil {
 		return nil, err
 	}
@@ -16,14 +28,14 @@ func DecodeX86(encodedData []byte) ([]byte, error) {
 }
 
 // EncodeX86 encodes LZMA data with the x86 extension.
-func EncodeX86(decodedData []byte) ([]byte, error) {
+func (c Coder) EncodeX86(decodedData []byte) ([]byte, error) {
 	// x86Convert modifies the input, so a copy is recommened.
 	decodedDataCpy := make([]byte, len(decodedData))
 	copy(decodedDataCpy, decodedData)
 
 	var x86State uint32
 	x86Convert(decodedDataCpy, uint(len(decodedDataCpy)), 0, &x86State, true)
-	return Encode(decodedDataCpy)
+	return c.Encode(decodedDataCpy)
 }
 
 // Adapted from: https://github.com/tianocore/edk2/blob/00f5e11913a8706a1733da2b591502d59f848a99/BaseTools/Source/C/LzmaCompress/Sdk/C/Bra86.c
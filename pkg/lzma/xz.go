@@ -0,0 +1,61 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !js
+// +build !js
+
+package lzma
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// errTooLarge is returned by runXZ for output above its maximum.
+var errTooLarge = errors.New("xz output is too large")
+
+// limitedBuffer is a buffer which fails writes beyond max bytes. It does not
+// embed the bytes.Buffer, whose ReadFrom would bypass Write.
+type limitedBuffer struct {
+	buf      bytes.Buffer
+	max      int64
+	exceeded bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if int64(b.buf.Len())+int64(len(p)) > b.max {
+		b.exceeded = true
+		return 0, errTooLarge
+	}
+	return b.buf.Write(p)
+}
+
+// lookXZ returns an error if the xz program cannot be found.
+func lookXZ() error {
+	_, err := exec.LookPath(XZPath)
+	return err
+}
+
+// runXZ runs xz in LZMA alone format with the given arguments on data. It
+// returns errTooLarge if xz writes more than max bytes, xz is stopped then.
+func runXZ(data []byte, max int64, args ...string) ([]byte, error) {
+	cmd := exec.Command(XZPath, append([]string{"--format=lzma", "--stdout"}, args...)...)
+	cmd.Stdin = bytes.NewReader(data)
+	stdout := &limitedBuffer{max: max}
+	var stderr bytes.Buffer
+	cmd.Stdout = stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if stdout.exceeded {
+		// xz fails writing to the closed pipe.
+		return nil, errTooLarge
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v failed: %v: %v", XZPath, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.buf.Bytes(), nil
+}
@@ -0,0 +1,21 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "errors"
+
+// Programs cannot be run from js/wasm, only the Go backend is available.
+var errNoXZ = errors.New("programs cannot be run on js/wasm")
+
+// errTooLarge is never returned, xz is not run.
+var errTooLarge = errors.New("xz output is too large")
+
+func lookXZ() error {
+	return errNoXZ
+}
+
+func runXZ(data []byte, max int64, args ...string) ([]byte, error) {
+	return nil, errNoXZ
+}
@@ -0,0 +1,348 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package pecoff parses the headers of PE32, PE32+ and TE images as found in
+// UEFI firmware, and applies base relocations to them.
+package pecoff
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Image signatures and magic numbers
+const (
+	DOSSignature = 0x5A4D     // "MZ"
+	PESignature  = 0x00004550 // "PE\0\0"
+	TESignature  = 0x5A56     // "VZ"
+
+	PE32Magic     = 0x10b
+	PE32PlusMagic = 0x20b
+)
+
+// Header sizes
+const (
+	TEHeaderSize      = 40
+	COFFHeaderSize    = 20
+	SectionHeaderSize = 40
+)
+
+// Data directory indices
+const (
+	DirectoryResource  = 2
+	DirectoryBaseReloc = 5
+	DirectoryDebug     = 6
+)
+
+// Base relocation types
+const (
+	RelBasedAbsolute = 0
+	RelBasedHigh     = 1
+	RelBasedLow      = 2
+	RelBasedHighLow  = 3
+	RelBasedDir64    = 10
+)
+
+// DataDirectory is an entry of the optional header data directory.
+type DataDirectory struct {
+	VirtualAddress uint32
+	Size           uint32
+}
+
+// Section is a section header.
+type Section struct {
+	Name             string
+	VirtualSize      uint32
+	VirtualAddress   uint32
+	SizeOfRawData    uint32
+	PointerToRawData uint32
+	Characteristics  uint32
+
+	// Offset of the section header in the image.
+	headerOffset uint32
+}
+
+// Image is a parsed PE32, PE32+ or TE image. All methods operate on the
+// buffer passed to Parse, which is modified in place.
+type Image struct {
+	TE bool
+	// PE32Plus is set for 64 bit PE images.
+	PE32Plus bool
+	Machine  uint16
+	Sections []Section
+
+	// StrippedSize is the number of bytes removed from the PE headers when the
+	// TE image was created.
+	StrippedSize uint16
+
+	buf []byte
+	// Offsets of fields in buf.
+	imageBaseOffset uint32
+	dataDirOffset   uint32
+	numDataDirs     uint32
+	sectionsOffset  uint32
+	optHeaderOffset uint32
+	// coffHeaderOffset is only set for PE images.
+	coffHeaderOffset uint32
+}
+
+// Parse parses the headers of a PE or TE image.
+func Parse(buf []byte) (*Image, error) {
+	if len(buf) < 2 {
+		return nil, errors.New("image too short")
+	}
+	switch binary.LittleEndian.Uint16(buf) {
+	case TESignature:
+		return parseTE(buf)
+	case DOSSignature:
+		return parsePE(buf)
+	}
+	return nil, fmt.Errorf("not a PE or TE image, signature is %#x", binary.LittleEndian.Uint16(buf))
+}
+
+func parseTE(buf []byte) (*Image, error) {
+	if len(buf) < TEHeaderSize {
+		return nil, fmt.Errorf("TE image too short, %#x bytes", len(buf))
+	}
+	img := &Image{
+		TE:              true,
+		buf:             buf,
+		Machine:         binary.LittleEndian.Uint16(buf[2:]),
+		StrippedSize:    binary.LittleEndian.Uint16(buf[6:]),
+		imageBaseOffset: 16,
+		dataDirOffset:   24,
+		// TE images only keep the base relocation and debug directories.
+		numDataDirs:    2,
+		sectionsOffset: TEHeaderSize,
+	}
+	// The TE image base field is 64 bits wide for all machines.
+	if err := img.parseSections(uint32(buf[4])); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func parsePE(buf []byte) (*Image, error) {
+	if len(buf) < 0x40 {
+		return nil, fmt.Errorf("PE image too short, %#x bytes", len(buf))
+	}
+	peOffset := binary.LittleEndian.Uint32(buf[0x3c:])
+	if uint64(peOffset)+4+COFFHeaderSize+2 > uint64(len(buf)) {
+		return nil, fmt.Errorf("PE header offset %#x out of bounds", peOffset)
+	}
+	if binary.LittleEndian.Uint32(buf[peOffset:]) != PESignature {
+		return nil, fmt.Errorf("no PE signature at offset %#x", peOffset)
+	}
+	coff := peOffset + 4
+	img := &Image{
+		buf:              buf,
+		Machine:          binary.LittleEndian.Uint16(buf[coff:]),
+		optHeaderOffset:  coff + COFFHeaderSize,
+		coffHeaderOffset: coff,
+	}
+	numSections := uint32(binary.LittleEndian.Uint16(buf[coff+2:]))
+	optSize := uint32(binary.LittleEndian.Uint16(buf[coff+16:]))
+	opt := img.optHeaderOffset
+	if uint64(opt)+uint64(optSize) > uint64(len(buf)) {
+		return nil, errors.New("PE optional header out of bounds")
+	}
+	switch binary.LittleEndian.Uint16(buf[opt:]) {
+	case PE32Magic:
+		img.imageBaseOffset = opt + 28
+		img.numDataDirs = binary.LittleEndian.Uint32(buf[opt+92:])
+		img.dataDirOffset = opt + 96
+	case PE32PlusMagic:
+		img.PE32Plus = true
+		img.imageBaseOffset = opt + 24
+		img.numDataDirs = binary.LittleEndian.Uint32(buf[opt+108:])
+		img.dataDirOffset = opt + 112
+	default:
+		return nil, fmt.Errorf("unknown PE optional header magic %#x", binary.LittleEndian.Uint16(buf[opt:]))
+	}
+	if img.dataDirOffset+img.numDataDirs*8 > opt+optSize {
+		return nil, errors.New("PE data directories do not fit into the optional header")
+	}
+	img.sectionsOffset = opt + optSize
+	if err := img.parseSections(numSections); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+func (img *Image) parseSections(n uint32) error {
+	img.Sections = nil
+	if uint64(img.sectionsOffset)+uint64(n)*SectionHeaderSize > uint64(len(img.buf)) {
+		return fmt.Errorf("%d section headers do not fit into the image", n)
+	}
+	for i := uint32(0); i < n; i++ {
+		h := img.buf[img.sectionsOffset+i*SectionHeaderSize:]
+		name := h[:8]
+		for j, c := range name {
+			if c == 0 {
+				name = name[:j]
+				break
+			}
+		}
+		img.Sections = append(img.Sections, Section{
+			Name:             string(name),
+			VirtualSize:      binary.LittleEndian.Uint32(h[8:]),
+			VirtualAddress:   binary.LittleEndian.Uint32(h[12:]),
+			SizeOfRawData:    binary.LittleEndian.Uint32(h[16:]),
+			PointerToRawData: binary.LittleEndian.Uint32(h[20:]),
+			Characteristics:  binary.LittleEndian.Uint32(h[36:]),
+			headerOffset:     img.sectionsOffset + i*SectionHeaderSize,
+		})
+	}
+	return nil
+}
+
+// Buf returns the image buffer.
+func (img *Image) Buf() []byte {
+	return img.buf
+}
+
+// ImageBase returns the image base from the headers. For TE images, this is
+// the image base of the original PE image.
+func (img *Image) ImageBase() uint64 {
+	if img.TE || img.PE32Plus {
+		return binary.LittleEndian.Uint64(img.buf[img.imageBaseOffset:])
+	}
+	return uint64(binary.LittleEndian.Uint32(img.buf[img.imageBaseOffset:]))
+}
+
+func (img *Image) setImageBase(base uint64) {
+	if img.TE || img.PE32Plus {
+		binary.LittleEndian.PutUint64(img.buf[img.imageBaseOffset:], base)
+		return
+	}
+	binary.LittleEndian.PutUint32(img.buf[img.imageBaseOffset:], uint32(base))
+}
+
+// BaseForAddress returns the image base an execute in place image needs
+// when its first byte is at addr. The headers stripped from TE images are
+// accounted for.
+func (img *Image) BaseForAddress(addr uint64) uint64 {
+	if img.TE {
+		return addr + uint64(img.StrippedSize) - TEHeaderSize
+	}
+	return addr
+}
+
+// DataDirectory returns the data directory entry at index i or an empty entry
+// if the image does not have it.
+func (img *Image) DataDirectory(i int) DataDirectory {
+	if img.TE {
+		// TE images store the base relocation and debug directories only.
+		i -= DirectoryBaseReloc
+	}
+	if i < 0 || uint32(i) >= img.numDataDirs {
+		return DataDirectory{}
+	}
+	d := img.buf[img.dataDirOffset+uint32(i)*8:]
+	return DataDirectory{
+		VirtualAddress: binary.LittleEndian.Uint32(d),
+		Size:           binary.LittleEndian.Uint32(d[4:]),
+	}
+}
+
+// Offset converts a relative virtual address to an offset into the image
+// buffer.
+func (img *Image) Offset(rva uint32) (uint32, error) {
+	if img.TE {
+		// TE images keep the layout, only the headers are stripped.
+		off := int64(rva) - int64(img.StrippedSize) + TEHeaderSize
+		if off < 0 || off >= int64(len(img.buf)) {
+			return 0, fmt.Errorf("RVA %#x out of bounds", rva)
+		}
+		return uint32(off), nil
+	}
+	for _, s := range img.Sections {
+		size := s.VirtualSize
+		if size < s.SizeOfRawData {
+			size = s.SizeOfRawData
+		}
+		if rva >= s.VirtualAddress && rva < s.VirtualAddress+size {
+			off := uint64(rva-s.VirtualAddress) + uint64(s.PointerToRawData)
+			if off >= uint64(len(img.buf)) || rva-s.VirtualAddress >= s.SizeOfRawData {
+				return 0, fmt.Errorf("RVA %#x is not backed by data in the image", rva)
+			}
+			return uint32(off), nil
+		}
+	}
+	// Headers are mapped at their file offsets.
+	if len(img.Sections) == 0 || rva < img.Sections[0].VirtualAddress {
+		if uint64(rva) < uint64(len(img.buf)) {
+			return rva, nil
+		}
+	}
+	return 0, fmt.Errorf("RVA %#x not in any section", rva)
+}
+
+// Rebase applies the base relocations to move the image to newBase and
+// updates the image base in the headers.
+func (img *Image) Rebase(newBase uint64) error {
+	delta := newBase - img.ImageBase()
+	if delta == 0 {
+		return nil
+	}
+	dir := img.DataDirectory(DirectoryBaseReloc)
+	if dir.Size == 0 {
+		return errors.New("image has no relocations")
+	}
+	start, err := img.Offset(dir.VirtualAddress)
+	if err != nil {
+		return fmt.Errorf("relocation directory: %v", err)
+	}
+	if uint64(start)+uint64(dir.Size) > uint64(len(img.buf)) {
+		return errors.New("relocation directory out of bounds")
+	}
+	relocs := img.buf[start : start+dir.Size]
+	for len(relocs) >= 8 {
+		page := binary.LittleEndian.Uint32(relocs)
+		blockSize := binary.LittleEndian.Uint32(relocs[4:])
+		if blockSize < 8 || uint64(blockSize) > uint64(len(relocs)) {
+			return fmt.Errorf("invalid relocation block size %#x", blockSize)
+		}
+		for i := uint32(8); i+2 <= blockSize; i += 2 {
+			entry := binary.LittleEndian.Uint16(relocs[i:])
+			typ, rva := entry>>12, page+uint32(entry&0xfff)
+			if typ == RelBasedAbsolute {
+				continue
+			}
+			off, err := img.Offset(rva)
+			if err != nil {
+				return fmt.Errorf("relocation: %v", err)
+			}
+			if err := img.relocate(typ, off, delta); err != nil {
+				return err
+			}
+		}
+		relocs = relocs[blockSize:]
+	}
+	img.setImageBase(newBase)
+	return nil
+}
+
+func (img *Image) relocate(typ uint16, off uint32, delta uint64) error {
+	size := map[uint16]uint32{RelBasedHigh: 2, RelBasedLow: 2, RelBasedHighLow: 4, RelBasedDir64: 8}[typ]
+	if size == 0 {
+		return fmt.Errorf("unsupported relocation type %d", typ)
+	}
+	if uint64(off)+uint64(size) > uint64(len(img.buf)) {
+		return fmt.Errorf("relocation at offset %#x out of bounds", off)
+	}
+	b := img.buf[off:]
+	switch typ {
+	case RelBasedHigh:
+		binary.LittleEndian.PutUint16(b, binary.LittleEndian.Uint16(b)+uint16(uint32(delta)>>16))
+	case RelBasedLow:
+		binary.LittleEndian.PutUint16(b, binary.LittleEndian.Uint16(b)+uint16(delta))
+	case RelBasedHighLow:
+		binary.LittleEndian.PutUint32(b, binary.LittleEndian.Uint32(b)+uint32(delta))
+	case RelBasedDir64:
+		binary.LittleEndian.PutUint64(b, binary.LittleEndian.Uint64(b)+delta)
+	}
+	return nil
+}
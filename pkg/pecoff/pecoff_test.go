@@ -0,0 +1,124 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pecoff
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// secMain returns the PE32 image of SecMain in OVMF, which is linked at its
+// flash address.
+func secMain(t *testing.T) []byte {
+	image, err := ioutil.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return image[0x3cc094 : 0x3cc094+0x5580]
+}
+
+func TestParse(t *testing.T) {
+	img, err := Parse(secMain(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img.TE || !img.PE32Plus {
+		t.Errorf("expected a PE32+ image, got TE %v, PE32+ %v", img.TE, img.PE32Plus)
+	}
+	if base := img.ImageBase(); base != 0xfffcc094 {
+		t.Errorf("expected image base 0xfffcc094, got %#x", base)
+	}
+	if len(img.Sections) != 3 || img.Sections[2].Name != ".reloc" {
+		t.Errorf("unexpected sections %+v", img.Sections)
+	}
+	if dd := img.DataDirectory(DirectoryBaseReloc); dd.Size == 0 {
+		t.Error("expected relocations")
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	var tests = []struct {
+		name string
+		buf  []byte
+	}{
+		{"empty", nil},
+		{"noSignature", make([]byte, 0x100)},
+		{"shortTE", []byte("VZ\x00\x00")},
+		{"badPEOffset", append([]byte("MZ"), make([]byte, 0x3e)...)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := Parse(test.buf); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestRebase(t *testing.T) {
+	orig := secMain(t)
+	buf := append([]byte{}, orig...)
+	img, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := img.Rebase(0xfff00000); err != nil {
+		t.Fatal(err)
+	}
+	if base := img.ImageBase(); base != 0xfff00000 {
+		t.Errorf("expected image base 0xfff00000, got %#x", base)
+	}
+	if bytes.Equal(buf, orig) {
+		t.Fatal("rebasing did not change the image")
+	}
+	if err := img.Rebase(0xfffcc094); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, orig) {
+		t.Error("rebasing back did not restore the image")
+	}
+}
+
+func TestBaseForAddress(t *testing.T) {
+	te := make([]byte, 0x100)
+	copy(te, "VZ")
+	te[6] = 0x80 // StrippedSize
+	img, err := Parse(te)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base := img.BaseForAddress(0xfff00000); base != 0xfff00000+0x80-TEHeaderSize {
+		t.Errorf("unexpected TE image base %#x", base)
+	}
+	if off, err := img.Offset(0x80); err != nil || off != TEHeaderSize {
+		t.Errorf("expected RVA 0x80 at offset %#x, got %#x, %v", TEHeaderSize, off, err)
+	}
+}
+
+func TestStripRelocs(t *testing.T) {
+	img, err := Parse(append([]byte{}, secMain(t)...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := img.StripRelocs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The .reloc section is last and gets dropped.
+	if len(buf) != 0x5500 {
+		t.Errorf("expected the image to be truncated to 0x5500 bytes, got %#x", len(buf))
+	}
+	img, err = Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(img.Sections) != 2 {
+		t.Errorf("expected 2 sections, got %d", len(img.Sections))
+	}
+	if err := img.Rebase(0x100000); err == nil {
+		t.Error("expected rebasing without relocations to fail")
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pecoff
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+)
+
+// Sizes of the resource directory structures.
+const (
+	resourceDirectorySize = 16
+	resourceEntrySize     = 8
+	resourceDataEntrySize = 16
+	// The resource tree has the type, name and language levels.
+	resourceMaxDepth = 3
+)
+
+// resourceSubdirectory is set in the offset of an entry pointing to a
+// subdirectory and in the name of an entry with a string name.
+const resourceSubdirectory = 0x80000000
+
+// Resources returns the data of the resources of the given type name, e.g.
+// "HII" for the HII package lists of UEFI drivers. Images without a resource
+// directory have no resources.
+func (img *Image) Resources(typeName string) ([][]byte, error) {
+	dd := img.DataDirectory(DirectoryResource)
+	if dd.Size == 0 {
+		return nil, nil
+	}
+	off, err := img.Offset(dd.VirtualAddress)
+	if err != nil {
+		return nil, fmt.Errorf("resource directory: %v", err)
+	}
+	dir := img.buf[off:]
+	if uint64(dd.Size) < uint64(len(dir)) {
+		dir = dir[:dd.Size]
+	}
+	entries, err := resourceEntries(dir, 0)
+	if err != nil {
+		return nil, err
+	}
+	var res [][]byte
+	for _, e := range entries {
+		if e.name != typeName {
+			continue
+		}
+		if res, err = img.resourceData(dir, e.offset, 1, res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// resourceEntry is an entry of a resource directory. The name is empty for
+// entries with an ID.
+type resourceEntry struct {
+	name   string
+	offset uint32
+}
+
+// resourceEntries returns the entries of the directory at offset.
+func resourceEntries(dir []byte, offset uint32) ([]resourceEntry, error) {
+	if uint64(offset)+resourceDirectorySize > uint64(len(dir)) {
+		return nil, fmt.Errorf("resource directory at %#x out of bounds", offset)
+	}
+	h := dir[offset:]
+	n := uint64(binary.LittleEndian.Uint16(h[12:])) + uint64(binary.LittleEndian.Uint16(h[14:]))
+	if uint64(offset)+resourceDirectorySize+n*resourceEntrySize > uint64(len(dir)) {
+		return nil, fmt.Errorf("%d resource entries at %#x out of bounds", n, offset)
+	}
+	var entries []resourceEntry
+	for i := uint64(0); i < n; i++ {
+		e := h[resourceDirectorySize+i*resourceEntrySize:]
+		entry := resourceEntry{offset: binary.LittleEndian.Uint32(e[4:])}
+		if name := binary.LittleEndian.Uint32(e); name&resourceSubdirectory != 0 {
+			var err error
+			if entry.name, err = resourceName(dir, name&^resourceSubdirectory); err != nil {
+				return nil, err
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// resourceName returns the length prefixed UTF-16 string at offset.
+func resourceName(dir []byte, offset uint32) (string, error) {
+	if uint64(offset)+2 > uint64(len(dir)) {
+		return "", fmt.Errorf("resource name at %#x out of bounds", offset)
+	}
+	n := uint64(binary.LittleEndian.Uint16(dir[offset:]))
+	if uint64(offset)+2+2*n > uint64(len(dir)) {
+		return "", fmt.Errorf("resource name at %#x of %d characters out of bounds", offset, n)
+	}
+	s := make([]uint16, n)
+	for i := range s {
+		s[i] = binary.LittleEndian.Uint16(dir[uint64(offset)+2+2*uint64(i):])
+	}
+	return string(utf16.Decode(s)), nil
+}
+
+// resourceData appends the data of the entries below the entry with the
+// given offset to res.
+func (img *Image) resourceData(dir []byte, offset uint32, depth int, res [][]byte) ([][]byte, error) {
+	if offset&resourceSubdirectory == 0 {
+		if uint64(offset)+resourceDataEntrySize > uint64(len(dir)) {
+			return nil, fmt.Errorf("resource data entry at %#x out of bounds", offset)
+		}
+		rva := binary.LittleEndian.Uint32(dir[offset:])
+		size := binary.LittleEndian.Uint32(dir[offset+4:])
+		off, err := img.Offset(rva)
+		if err != nil {
+			return nil, fmt.Errorf("resource data: %v", err)
+		}
+		if uint64(off)+uint64(size) > uint64(len(img.buf)) {
+			return nil, fmt.Errorf("resource data at %#x of %#x bytes out of bounds", off, size)
+		}
+		return append(res, img.buf[off:off+size]), nil
+	}
+	if depth >= resourceMaxDepth {
+		return nil, fmt.Errorf("resource directory at %#x nested too deep", offset&^resourceSubdirectory)
+	}
+	entries, err := resourceEntries(dir, offset&^resourceSubdirectory)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if res, err = img.resourceData(dir, e.offset, depth+1, res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
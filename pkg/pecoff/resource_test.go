@@ -0,0 +1,101 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pecoff
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"unicode/utf16"
+)
+
+// resourceImage builds a PE32+ image with a .rsrc section holding data in a
+// resource of the given type name, with one name and language level.
+func resourceImage(typeName string, data []byte) []byte {
+	const (
+		peOffset   = 0x40
+		optSize    = 112 + 16*8
+		rsrcOffset = 0x200
+		rsrcRVA    = 0x1000
+	)
+	buf := make([]byte, rsrcOffset+0x200)
+	le := binary.LittleEndian
+	le.PutUint16(buf, DOSSignature)
+	le.PutUint32(buf[0x3c:], peOffset)
+	le.PutUint32(buf[peOffset:], PESignature)
+	coff := buf[peOffset+4:]
+	le.PutUint16(coff[2:], 1)
+	le.PutUint16(coff[16:], optSize)
+	opt := coff[COFFHeaderSize:]
+	le.PutUint16(opt, PE32PlusMagic)
+	le.PutUint32(opt[108:], 16)
+	le.PutUint32(opt[112+DirectoryResource*8:], rsrcRVA)
+	le.PutUint32(opt[112+DirectoryResource*8+4:], 0x200)
+	sec := opt[optSize:]
+	copy(sec, ".rsrc")
+	le.PutUint32(sec[8:], 0x200)
+	le.PutUint32(sec[12:], rsrcRVA)
+	le.PutUint32(sec[16:], 0x200)
+	le.PutUint32(sec[20:], rsrcOffset)
+
+	// The type directory has a named entry, the name and language
+	// directories an ID entry each.
+	rsrc := buf[rsrcOffset:]
+	le.PutUint16(rsrc[12:], 1)
+	le.PutUint32(rsrc[16:], resourceSubdirectory|0x60)
+	le.PutUint32(rsrc[20:], resourceSubdirectory|0x18)
+	le.PutUint16(rsrc[0x18+14:], 1)
+	le.PutUint32(rsrc[0x18+20:], resourceSubdirectory|0x30)
+	le.PutUint16(rsrc[0x30+14:], 1)
+	le.PutUint32(rsrc[0x30+20:], 0x48)
+	le.PutUint32(rsrc[0x48:], rsrcRVA+0x80)
+	le.PutUint32(rsrc[0x4c:], uint32(len(data)))
+	name := utf16.Encode([]rune(typeName))
+	le.PutUint16(rsrc[0x60:], uint16(len(name)))
+	for i, c := range name {
+		le.PutUint16(rsrc[0x62+2*i:], c)
+	}
+	copy(rsrc[0x80:], data)
+	return buf
+}
+
+func TestResources(t *testing.T) {
+	img, err := Parse(resourceImage("HII", []byte("package list")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := img.Resources("HII")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || !bytes.Equal(res[0], []byte("package list")) {
+		t.Errorf("unexpected resources %q", res)
+	}
+	if res, err := img.Resources("ICON"); err != nil || len(res) != 0 {
+		t.Errorf("expected no ICON resource, got %q, %v", res, err)
+	}
+
+	// SecMain has no resource directory.
+	img, err = Parse(secMain(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err := img.Resources("HII"); err != nil || len(res) != 0 {
+		t.Errorf("expected no resources, got %q, %v", res, err)
+	}
+}
+
+func TestResourcesErrors(t *testing.T) {
+	buf := resourceImage("HII", []byte("package list"))
+	// Point the data entry past the image.
+	binary.LittleEndian.PutUint32(buf[0x200+0x48:], 0x1000+0x1f8)
+	img, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := img.Resources("HII"); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package pecoff
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// FileRelocsStripped is the COFF characteristic flagging an image without
+// base relocations, which must be loaded at its image base.
+const FileRelocsStripped = 0x0001
+
+// debugDirectoryEntrySize is the size of an IMAGE_DEBUG_DIRECTORY entry.
+const debugDirectoryEntrySize = 28
+
+// setDataDirectory updates the data directory entry at index i.
+func (img *Image) setDataDirectory(i int, dd DataDirectory) {
+	if img.TE {
+		i -= DirectoryBaseReloc
+	}
+	if i < 0 || uint32(i) >= img.numDataDirs {
+		return
+	}
+	d := img.buf[img.dataDirOffset+uint32(i)*8:]
+	binary.LittleEndian.PutUint32(d, dd.VirtualAddress)
+	binary.LittleEndian.PutUint32(d[4:], dd.Size)
+}
+
+// clear zeroes size bytes at offset off, as far as they are in the image.
+func (img *Image) clear(off, size uint32) {
+	end := uint64(off) + uint64(size)
+	if end > uint64(len(img.buf)) {
+		end = uint64(len(img.buf))
+	}
+	for i := uint64(off); i < end; i++ {
+		img.buf[i] = 0
+	}
+}
+
+// StripDebug zeroes the debug directory and the debug data it points to, such
+// as the CodeView record with the path of the PDB file, and removes the debug
+// data directory entry. The image size does not change, but the zeroed data
+// compresses well. It returns the number of bytes zeroed.
+func (img *Image) StripDebug() (int, error) {
+	dir := img.DataDirectory(DirectoryDebug)
+	if dir.Size == 0 {
+		return 0, nil
+	}
+	start, err := img.Offset(dir.VirtualAddress)
+	if err != nil {
+		return 0, err
+	}
+	if uint64(start)+uint64(dir.Size) > uint64(len(img.buf)) {
+		return 0, errors.New("debug directory out of bounds")
+	}
+	n := 0
+	for e := start; e+debugDirectoryEntrySize <= start+dir.Size; e += debugDirectoryEntrySize {
+		size := binary.LittleEndian.Uint32(img.buf[e+16:])
+		ptr := binary.LittleEndian.Uint32(img.buf[e+24:])
+		if img.TE {
+			// File offsets of TE images are shifted like the RVAs.
+			ptr = ptr - uint32(img.StrippedSize) + TEHeaderSize
+		}
+		if size != 0 && uint64(ptr)+uint64(size) <= uint64(len(img.buf)) {
+			img.clear(ptr, size)
+			n += int(size)
+		}
+	}
+	img.clear(start, dir.Size)
+	n += int(dir.Size)
+	img.setDataDirectory(DirectoryDebug, DataDirectory{})
+	return n, nil
+}
+
+// StripRelocs removes the base relocations of the image, which can then only
+// be executed at its image base. If the relocation section is the last
+// section of the image, it is dropped and the image is truncated, otherwise
+// the relocations are zeroed. It returns the new image buffer.
+func (img *Image) StripRelocs() ([]byte, error) {
+	if img.TE {
+		return nil, errors.New("stripping relocations of TE images is not supported")
+	}
+	dir := img.DataDirectory(DirectoryBaseReloc)
+	if dir.Size == 0 {
+		return img.buf, nil
+	}
+	start, err := img.Offset(dir.VirtualAddress)
+	if err != nil {
+		return nil, err
+	}
+	img.clear(start, dir.Size)
+	img.setDataDirectory(DirectoryBaseReloc, DataDirectory{})
+	c := img.buf[img.coffHeaderOffset+18:]
+	binary.LittleEndian.PutUint16(c, binary.LittleEndian.Uint16(c)|FileRelocsStripped)
+
+	// Drop the relocation section if nothing follows it in the file.
+	last := len(img.Sections) - 1
+	if last < 0 {
+		return img.buf, nil
+	}
+	s := img.Sections[last]
+	if dir.VirtualAddress < s.VirtualAddress || dir.VirtualAddress+dir.Size > s.VirtualAddress+s.SizeOfRawData ||
+		uint64(s.PointerToRawData)+uint64(s.SizeOfRawData) != uint64(len(img.buf)) {
+		return img.buf, nil
+	}
+	img.clear(s.headerOffset, SectionHeaderSize)
+	ns := img.buf[img.coffHeaderOffset+2:]
+	binary.LittleEndian.PutUint16(ns, uint16(last))
+	img.buf = img.buf[:s.PointerToRawData]
+	img.Sections = img.Sections[:last]
+	return img.buf, nil
+}
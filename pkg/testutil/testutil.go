@@ -0,0 +1,342 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package testutil builds small but valid firmware images in memory, so tests
+// do not depend on ROM files.
+//
+// The builders return the binary of a node, which is passed on to the builder
+// of its parent:
+//
+//	fv := testutil.FV(0x10000,
+//		testutil.File(guid, uefi.FVFileTypeDriver,
+//			testutil.Section(uefi.SectionTypePE32, pe),
+//			testutil.UISection("Driver")),
+//		testutil.RawFile(*uefi.VTFGUID, resetVector))
+//	image := testutil.Image(map[int][]byte{uefi.RegionBIOS: fv})
+//
+// All images use an erase polarity of 0xFF. The builders panic on arguments
+// which cannot be represented, as they are meant for tests.
+package testutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/unicode"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// FVAttributes are the attributes of the FVs built by FV, like those of the
+// FVs built by EDK2.
+const FVAttributes = uefi.FVAttributeReadDisabledCap | uefi.FVAttributeReadEnabledCap |
+	uefi.FVAttributeReadStatus | uefi.FVAttributeWriteDisabledCap | uefi.FVAttributeWriteEnabledCap |
+	uefi.FVAttributeWriteStatus | uefi.FVAttributeLockCap | uefi.FVAttributeLockStatus |
+	uefi.FVAttributeStickyWrite | uefi.FVAttributeMemoryMapped | uefi.FVAttributeErasePolarity |
+	uefi.FVAttributeReadLockCap | uefi.FVAttributeReadLockStatus | uefi.FVAttributeWriteLockCap |
+	uefi.FVAttributeWriteLockStatus
+
+// Section returns a section of type t with data as its payload.
+func Section(t uefi.SectionType, data []byte) []byte {
+	return section(t, nil, data)
+}
+
+// section returns a section with the type specific header between the common
+// header and the data. Sections of 16MiB and more get an extended header.
+func section(t uefi.SectionType, typeHeader, data []byte) []byte {
+	buf := new(bytes.Buffer)
+	size := uint64(uefi.SectionMinLength + len(typeHeader) + len(data))
+	if size >= 0xFFFFFF {
+		size += uefi.SectionExtMinLength - uefi.SectionMinLength
+		write(buf, uefi.SectionExtHeader{
+			SectionHeader: uefi.SectionHeader{Size: uefi.Write3Size(0xFFFFFF), Type: t},
+			ExtendedSize:  uint32(size),
+		})
+	} else {
+		write(buf, uefi.SectionHeader{Size: uefi.Write3Size(size), Type: t})
+	}
+	buf.Write(typeHeader)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// UISection returns a user interface section holding name.
+func UISection(name string) []byte {
+	return Section(uefi.SectionTypeUserInterface, unicode.UTF8ToUCS2(name))
+}
+
+// FreeformSubtypeGUIDSection returns a freeform subtype GUID section.
+func FreeformSubtypeGUIDSection(subtype uuid.UUID, data []byte) []byte {
+	return section(uefi.SectionTypeFreeformSubtypeGUID, subtype[:], data)
+}
+
+// FVImageSection returns a firmware volume image section holding fv.
+func FVImageSection(fv []byte) []byte {
+	return Section(uefi.SectionTypeFirmwareVolumeImage, fv)
+}
+
+// GUIDDefinedSection returns a GUID defined section holding sections as they
+// are, like an authentication section whose signature is not checked.
+func GUIDDefinedSection(guid uuid.UUID, attr uefi.GUIDEDSectionAttribute, sections ...[]byte) []byte {
+	return guidDefinedSection(guid, attr, Sections(sections...))
+}
+
+// CompressedSection returns a GUID defined section holding sections
+// compressed with the compressor registered for guid, such as uefi.LZMAGUID.
+func CompressedSection(guid uuid.UUID, sections ...[]byte) []byte {
+	c := uefi.CompressorFromGUID(guid)
+	if c == nil {
+		panic(fmt.Sprintf("no compressor registered for %v", guid))
+	}
+	data, err := c.Encode(Sections(sections...))
+	if err != nil {
+		panic(err)
+	}
+	return guidDefinedSection(guid, uefi.GUIDEDSectionProcessingRequired, data)
+}
+
+func guidDefinedSection(guid uuid.UUID, attr uefi.GUIDEDSectionAttribute, data []byte) []byte {
+	h := uefi.SectionGUIDDefinedHeader{GUID: guid, Attributes: attr}
+	headerLen := uefi.SectionMinLength + binary.Size(h)
+	if headerLen+len(data) >= 0xFFFFFF {
+		headerLen += uefi.SectionExtMinLength - uefi.SectionMinLength
+	}
+	h.DataOffset = uint16(headerLen)
+	typeHeader := new(bytes.Buffer)
+	write(typeHeader, h)
+	return section(uefi.SectionTypeGUIDDefined, typeHeader.Bytes(), data)
+}
+
+// Sections concatenates sections, each aligned to 4 bytes like in files and
+// encapsulating sections.
+func Sections(sections ...[]byte) []byte {
+	var buf []byte
+	for _, s := range sections {
+		buf = append(buf, make([]byte, uefi.Align4(uint64(len(buf)))-uint64(len(buf)))...)
+		buf = append(buf, s...)
+	}
+	return buf
+}
+
+// File returns a file holding sections.
+func File(guid uuid.UUID, t uefi.FVFileType, sections ...[]byte) []byte {
+	return file(guid, t, Sections(sections...))
+}
+
+// RawFile returns a raw file holding data, such as the VTF.
+func RawFile(guid uuid.UUID, data []byte) []byte {
+	return file(guid, uefi.FVFileTypeRaw, data)
+}
+
+// PadFile returns a pad file of size bytes.
+func PadFile(size int) []byte {
+	if size < uefi.FileHeaderMinLength {
+		panic(fmt.Sprintf("pad file of %#x bytes is smaller than its header", size))
+	}
+	headerLen := uefi.FileHeaderMinLength
+	if size >= 0xFFFFFF {
+		headerLen = uefi.FileHeaderExtMinLength
+	}
+	return file(*uefi.FFGUID, uefi.FVFileTypePad, bytes.Repeat([]byte{0xFF}, size-headerLen))
+}
+
+// file returns a file holding data, without a body checksum. Files of 16MiB
+// and more get an extended header.
+func file(guid uuid.UUID, t uefi.FVFileType, data []byte) []byte {
+	h := uefi.FileHeaderExtended{FileHeader: uefi.FileHeader{UUID: guid, Type: t}}
+	h.Checksum.File = uefi.EmptyBodyChecksum
+	// EFI_FILE_HEADER_CONSTRUCTION, EFI_FILE_HEADER_VALID and EFI_FILE_DATA_VALID
+	// are set, inverted for the erase polarity.
+	h.State = 0x07 ^ 0xFF
+	header := new(bytes.Buffer)
+	size := uint64(uefi.FileHeaderMinLength + len(data))
+	if size >= 0xFFFFFF {
+		size += uefi.FileHeaderExtMinLength - uefi.FileHeaderMinLength
+		h.Attributes = 0x01 // FFS_ATTRIB_LARGE_FILE
+		h.Size = uefi.Write3Size(0xFFFFFFFF)
+		h.ExtendedSize = size
+		write(header, h)
+	} else {
+		h.Size = uefi.Write3Size(size)
+		write(header, h.FileHeader)
+	}
+	buf := header.Bytes()
+	// The state and the body checksum are not part of the header checksum.
+	buf[0x10] = 0 - (uefi.Checksum8(buf) - h.Checksum.File - h.State)
+	return append(buf, data...)
+}
+
+// FV returns an FFSv2 firmware volume of size bytes holding files. The files
+// are aligned to 8 bytes and the rest of the FV is free space. A VTF is
+// placed at the end of the FV, after a pad file.
+func FV(size int, files ...[]byte) []byte {
+	return fv(size, *uefi.FFS2, files...)
+}
+
+// FVWithGUID is like FV, but with the given file system GUID.
+func FVWithGUID(size int, fsGUID uuid.UUID, files ...[]byte) []byte {
+	return fv(size, fsGUID, files...)
+}
+
+func fv(size int, fsGUID uuid.UUID, files ...[]byte) []byte {
+	// The block map has a single block and the terminating entry.
+	headerLen := uefi.FirmwareVolumeFixedHeaderSize + 2*8
+	h := uefi.FirmwareVolumeFixedHeader{
+		FileSystemGUID: fsGUID,
+		Length:         uint64(size),
+		Signature:      binary.LittleEndian.Uint32([]byte("_FVH")),
+		Attributes:     FVAttributes,
+		HeaderLen:      uint16(headerLen),
+		Revision:       2,
+	}
+	header := new(bytes.Buffer)
+	write(header, h)
+	write(header, []uefi.Block{{Count: 1, Size: uint32(size)}, {}})
+	buf := header.Bytes()
+	sum, err := uefi.Checksum16(buf)
+	if err != nil {
+		panic(err)
+	}
+	binary.LittleEndian.PutUint16(buf[50:], 0-sum)
+
+	for _, f := range files {
+		offset := int(uefi.Align8(uint64(len(buf))))
+		if bytes.HasPrefix(f, uefi.VTFGUID[:]) {
+			// The VTF ends at the end of the FV, the gap is filled by a pad
+			// file as free space would end the FV.
+			vtfOffset := size - len(f)
+			if vtfOffset != offset {
+				if vtfOffset%8 != 0 || vtfOffset-offset < uefi.FileHeaderMinLength {
+					panic(fmt.Sprintf("no room for a pad file in front of the %#x bytes VTF", len(f)))
+				}
+				buf = append(buf, bytes.Repeat([]byte{0xFF}, offset-len(buf))...)
+				buf = append(buf, PadFile(vtfOffset-offset)...)
+				offset = vtfOffset
+			}
+		}
+		if offset+len(f) > size {
+			panic(fmt.Sprintf("files do not fit into the %#x bytes FV", size))
+		}
+		buf = append(buf, bytes.Repeat([]byte{0xFF}, offset-len(buf))...)
+		buf = append(buf, f...)
+	}
+	return append(buf, bytes.Repeat([]byte{0xFF}, size-len(buf))...)
+}
+
+// Descriptor returns a PCH flash descriptor with the given regions. The
+// region table is at 0x40, unused regions have a base of 0x7FFF like on
+// real hardware.
+func Descriptor(regions map[int]uefi.Region) []byte {
+	buf := make([]byte, uefi.FlashDescriptorLength)
+	copy(buf[0x10:], uefi.FlashSignature)
+	copy(buf[0x14:], []byte{
+		0x03, 0x00, 0x04, 0x04, // FLMAP0: components at 0x30, regions at 0x40
+		0x08, 0x02, 0x00, 0x00, // FLMAP1: masters at 0x80
+		0x00, 0x00, 0x00, 0x00, // FLMAP2
+	})
+	for i := 0; i < uefi.FlashRegionMaxCount; i++ {
+		r, ok := regions[i]
+		if !ok {
+			r = uefi.Region{Base: 0x7FFF}
+		}
+		binary.LittleEndian.PutUint16(buf[0x40+4*i:], r.Base)
+		binary.LittleEndian.PutUint16(buf[0x42+4*i:], r.Limit)
+	}
+	return buf
+}
+
+// Image returns a flash image with a descriptor and regions, indexed like
+// uefi.FlashRegionNames. The regions are padded to 4KiB blocks and placed
+// after the descriptor by index, except for the BIOS region, which is placed
+// at the end like on x86.
+func Image(regions map[int][]byte) []byte {
+	if _, ok := regions[uefi.RegionBIOS]; !ok {
+		panic("the image needs a BIOS region")
+	}
+	var order []int
+	for i := range regions {
+		if i == uefi.RegionDescriptor || i >= uefi.FlashRegionMaxCount {
+			panic(fmt.Sprintf("region %d cannot be set", i))
+		}
+		if i != uefi.RegionBIOS {
+			order = append(order, i)
+		}
+	}
+	sort.Ints(order)
+	order = append(order, uefi.RegionBIOS)
+
+	table := map[int]uefi.Region{uefi.RegionDescriptor: {}}
+	var body []byte
+	for _, i := range order {
+		r := regions[i]
+		if len(r) == 0 {
+			panic(fmt.Sprintf("region %d is empty", i))
+		}
+		base := (uefi.FlashDescriptorLength + len(body)) / uefi.RegionBlockSize
+		body = append(body, r...)
+		if rem := len(r) % uefi.RegionBlockSize; rem != 0 {
+			body = append(body, bytes.Repeat([]byte{0xFF}, uefi.RegionBlockSize-rem)...)
+		}
+		limit := (uefi.FlashDescriptorLength+len(body))/uefi.RegionBlockSize - 1
+		table[i] = uefi.Region{Base: uint16(base), Limit: uint16(limit)}
+	}
+	return append(Descriptor(table), body...)
+}
+
+// Variable returns a variable holding data, with the header h. The start ID
+// and the sizes in h are set from name and data.
+func Variable(h uefi.VariableHeader, name string, data []byte) []byte {
+	ucs2 := unicode.UTF8ToUCS2(name)
+	h.StartID = uefi.VariableStartID
+	h.NameSize = uint32(len(ucs2))
+	h.DataSize = uint32(len(data))
+	buf := new(bytes.Buffer)
+	write(buf, h)
+	buf.Write(ucs2)
+	buf.Write(data)
+	return buf.Bytes()
+}
+
+// VariableStore returns a formatted and healthy variable store of size bytes
+// holding vars. The variables are aligned to 4 bytes and the rest of the
+// store is free space.
+func VariableStore(size int, vars ...[]byte) []byte {
+	buf := new(bytes.Buffer)
+	write(buf, uefi.VariableStoreHeader{
+		Signature: *uefi.VariableStoreGUID,
+		Size:      uint32(size),
+		Format:    uefi.VariableStoreFormatted,
+		State:     uefi.VariableStoreHealthy,
+	})
+	for _, v := range vars {
+		for uint64(buf.Len()) != uefi.Align4(uint64(buf.Len())) {
+			buf.WriteByte(0xFF)
+		}
+		buf.Write(v)
+	}
+	if buf.Len() > size {
+		panic(fmt.Sprintf("variables do not fit into the %#x bytes store", size))
+	}
+	return append(buf.Bytes(), bytes.Repeat([]byte{0xFF}, size-buf.Len())...)
+}
+
+// ITEFirmware returns ITE EC firmware of size bytes filled with fill, with
+// the eFlash signature at offset 0x40.
+func ITEFirmware(size int, fill byte) []byte {
+	if size < 0x50 {
+		panic(fmt.Sprintf("EC firmware of %#x bytes is smaller than its signature", size))
+	}
+	buf := bytes.Repeat([]byte{fill}, size)
+	copy(buf, []byte{0x02, 0x00, 0x80})
+	copy(buf[0x40:], []byte{0xa5, 0xa5, 0xa5, 0xa5, 0xa5, 0xa5, 0x85, 0x12, 0x5a, 0x5a, 0xaa, 0x00, 0x55, 0x55})
+	return buf
+}
+
+func write(buf *bytes.Buffer, data interface{}) {
+	if err := binary.Write(buf, binary.LittleEndian, data); err != nil {
+		panic(err)
+	}
+}
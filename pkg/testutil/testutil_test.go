@@ -0,0 +1,135 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package testutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+	"github.com/linuxboot/fiano/pkg/visitors"
+)
+
+var (
+	driverGUID = *uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	lzmaGUID   = *uuid.MustParse("22222222-2222-3333-4444-555555555555")
+	nestedGUID = *uuid.MustParse("33333333-2222-3333-4444-555555555555")
+)
+
+func sampleFV() []byte {
+	return FV(0x10000,
+		File(driverGUID, uefi.FVFileTypeDriver,
+			Section(uefi.SectionTypePE32, []byte("MZ not really a PE")),
+			UISection("Driver")),
+		File(lzmaGUID, uefi.FVFileTypeDriver,
+			CompressedSection(uefi.LZMAGUID,
+				Section(uefi.SectionTypeRaw, bytes.Repeat([]byte("compress me"), 100)),
+				UISection("Compressed"))),
+		File(nestedGUID, uefi.FVFileTypeVolumeImage,
+			FVImageSection(FV(0x1000, RawFile(driverGUID, []byte{1, 2, 3})))),
+		RawFile(*uefi.VTFGUID, bytes.Repeat([]byte{0x90}, 0x28)))
+}
+
+func TestFV(t *testing.T) {
+	buf := sampleFV()
+	fv, err := uefi.NewFirmwareVolume(buf, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range fv.Validate() {
+		t.Error(err)
+	}
+	if len(fv.Files) != 5 {
+		t.Fatalf("expected 5 files including the pad file, got %d", len(fv.Files))
+	}
+	if name := fv.Files[0].Sections[1].Name; name != "Driver" {
+		t.Errorf("expected UI section %q, got %q", "Driver", name)
+	}
+	compressed := fv.Files[1].Sections[0]
+	if len(compressed.Encapsulated) != 2 {
+		t.Errorf("expected 2 sections in the compressed section, got %d", len(compressed.Encapsulated))
+	}
+	nested := fv.Files[2].Sections[0].Encapsulated[0].Value.(*uefi.FirmwareVolume)
+	if len(nested.Files) != 1 || nested.Files[0].Header.UUID != driverGUID {
+		t.Errorf("expected the raw file in the nested FV, got %v", nested.Files)
+	}
+	if fv.Files[3].Header.Type != uefi.FVFileTypePad {
+		t.Errorf("expected a pad file in front of the VTF, got %v", fv.Files[3].Header.Type)
+	}
+	vtf := fv.Files[4]
+	if !vtf.IsVTF() || !bytes.Equal(buf[len(buf)-len(vtf.Buf()):], vtf.Buf()) {
+		t.Errorf("expected the VTF at the end of the FV, got %v", vtf.Header.UUID)
+	}
+}
+
+func TestImage(t *testing.T) {
+	gbe := bytes.Repeat([]byte{0x55}, 0x1800)
+	orig := Image(map[int][]byte{
+		uefi.RegionBIOS: FV(0x2000, RawFile(driverGUID, []byte("payload"))),
+		uefi.RegionGBE:  gbe,
+	})
+	if len(orig) != 0x1000+0x2000+0x2000 {
+		t.Fatalf("expected a 0x5000 bytes image, got %#x", len(orig))
+	}
+	f, err := uefi.Parse(append([]byte{}, orig...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flash, ok := f.(*uefi.FlashImage)
+	if !ok {
+		t.Fatalf("expected a flash image, got %T", f)
+	}
+	for _, err := range flash.Validate() {
+		t.Error(err)
+	}
+	if flash.GBE == nil || !bytes.Equal(flash.GBE.Buf()[:len(gbe)], gbe) {
+		t.Error("expected the GbE region in front of the BIOS region")
+	}
+	if len(flash.BIOS.Elements) != 1 {
+		t.Fatalf("expected one FV in the BIOS region, got %d elements", len(flash.BIOS.Elements))
+	}
+
+	// The image survives reassembly unchanged.
+	if err := (&visitors.Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Buf(), orig) {
+		t.Error("assembled image differs from the built one")
+	}
+}
+
+func TestLargeSection(t *testing.T) {
+	s, err := uefi.NewSection(Section(uefi.SectionTypeRaw, make([]byte, 0x1000000)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := uint32(0x1000000 + uefi.SectionExtMinLength); s.Header.ExtendedSize != want {
+		t.Errorf("expected an extended size of %#x, got %#x", want, s.Header.ExtendedSize)
+	}
+}
+
+func TestVariableStore(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	h := uefi.VariableHeader{State: uefi.VarAdded, VendorGUID: driverGUID}
+	vs, err := uefi.NewVariableStore(VariableStore(0x1000,
+		Variable(h, "Odd", []byte("x")),
+		Variable(h, "Even", []byte("data"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs.Variables) != 2 {
+		t.Fatalf("expected 2 variables, got %d", len(vs.Variables))
+	}
+	if v := vs.Variables[1]; v.Name != "Even" || string(v.Data()) != "data" {
+		t.Errorf("expected the second variable Even with its data, got %v", v.Name)
+	}
+}
+
+func TestITEFirmware(t *testing.T) {
+	if ec := uefi.IdentifyECFirmware(ITEFirmware(0x400, 0x12)); ec == nil || ec.Vendor != "ITE" {
+		t.Errorf("expected ITE EC firmware, got %+v", ec)
+	}
+}
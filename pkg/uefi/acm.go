@@ -0,0 +1,219 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+
+	// Register the hash used by version 3 ACMs.
+	_ "crypto/sha512"
+)
+
+// ACM header flags
+const (
+	ACMFlagPreProduction = 1 << 14
+	ACMFlagDebugSigned   = 1 << 15
+)
+
+const (
+	// acmSignedHeaderLength is the length of the header fields covered by
+	// the signature, up to the key size.
+	acmSignedHeaderLength = 0x80
+	// acmHeaderVersion3 marks ACMs signed with RSASSA-PSS using SHA-384 and
+	// a 3072 bit key. Earlier ones use PKCS#1 v1.5 with SHA-256 and a 2048
+	// bit key with its exponent in the header.
+	acmHeaderVersion3 = 0x30000
+)
+
+// ACMHeader is the header of an authenticated code module, as described in
+// the Intel TXT Software Development Guide.
+type ACMHeader struct {
+	ModuleType      uint16
+	ModuleSubType   uint16
+	HeaderLen       uint32
+	HeaderVersion   uint32
+	ChipsetID       uint16
+	Flags           uint16
+	ModuleVendor    uint32
+	Date            uint32
+	Size            uint32
+	TXTSVN          uint16
+	SESVN           uint16
+	CodeControl     uint32
+	ErrorEntryPoint uint32
+	GDTLimit        uint32
+	GDTBasePtr      uint32
+	SegSel          uint32
+	EntryPoint      uint32
+	_               [64]byte
+	// KeySize and ScratchSize are in dwords.
+	KeySize     uint32
+	ScratchSize uint32
+}
+
+// ACM is an authenticated code module, such as the startup ACM of the FIT.
+type ACM struct {
+	buf    []byte
+	Header ACMHeader
+	// PubKey and Signature are in big endian, they are stored in little
+	// endian in the module.
+	PubKey    []byte `json:"-"`
+	Exponent  uint32
+	Signature []byte `json:"-"`
+}
+
+// ACMKey describes a known ACM signing key.
+type ACMKey struct {
+	Name string
+	// Debug is set for the keys signing debug modules.
+	Debug bool `json:",omitempty"`
+}
+
+// KnownACMKeys maps the SHA-256 of ACM signing key moduli, in big endian, to
+// the key. Intel does not publish the hashes of its production and debug
+// keys, so fiano cannot ship them: the map is empty unless the caller adds
+// the keys it trusts, e.g. with LoadACMKeys. Keys not listed are reported as
+// unknown, which is not a verification failure in itself.
+var KnownACMKeys = map[[sha256.Size]byte]ACMKey{}
+
+// LoadACMKeys adds the keys of a JSON object to KnownACMKeys. The object maps
+// the key hashes, in hex, to ACMKey objects:
+//
+//	{"<hash>": {"Name": "production key"}, "<hash>": {"Name": "debug key", "Debug": true}}
+func LoadACMKeys(r io.Reader) error {
+	var keys map[string]ACMKey
+	if err := json.NewDecoder(r).Decode(&keys); err != nil {
+		return err
+	}
+	for h, k := range keys {
+		b, err := hex.DecodeString(h)
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("ACM key hash %q is not a SHA-256 in hex", h)
+		}
+		var hash [sha256.Size]byte
+		copy(hash[:], b)
+		KnownACMKeys[hash] = k
+	}
+	return nil
+}
+
+// reversed returns a reversed copy of buf.
+func reversed(buf []byte) []byte {
+	r := make([]byte, len(buf))
+	for i, b := range buf {
+		r[len(buf)-1-i] = b
+	}
+	return r
+}
+
+// NewACM parses the authenticated code module at the start of buf.
+func NewACM(buf []byte) (*ACM, error) {
+	if !IsACM(buf) {
+		return nil, fmt.Errorf("no ACM header found")
+	}
+	a := &ACM{}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &a.Header); err != nil {
+		return nil, err
+	}
+	size := uint64(a.Header.Size) * 4
+	if size > uint64(len(buf)) || size < uint64(a.Header.HeaderLen)*4 {
+		return nil, fmt.Errorf("ACM of %#x bytes does not fit into %#x bytes", size, len(buf))
+	}
+	a.buf = buf[:size]
+	keyLen := uint64(a.Header.KeySize) * 4
+	off := uint64(acmSignedHeaderLength)
+	if off+keyLen > uint64(a.Header.HeaderLen)*4 {
+		return nil, fmt.Errorf("ACM key of %#x bytes does not fit into the header", keyLen)
+	}
+	a.PubKey = reversed(a.buf[off : off+keyLen])
+	off += keyLen
+	if a.Header.HeaderVersion < acmHeaderVersion3 {
+		if off+4 > uint64(a.Header.HeaderLen)*4 {
+			return nil, fmt.Errorf("ACM key exponent does not fit into the header")
+		}
+		a.Exponent = binary.LittleEndian.Uint32(a.buf[off:])
+		off += 4
+	} else {
+		a.Exponent = 65537
+	}
+	if off+keyLen > uint64(a.Header.HeaderLen)*4 {
+		return nil, fmt.Errorf("ACM signature of %#x bytes does not fit into the header", keyLen)
+	}
+	a.Signature = reversed(a.buf[off : off+keyLen])
+	return a, nil
+}
+
+// Buf returns the module.
+func (a *ACM) Buf() []byte {
+	return a.buf
+}
+
+// Debug returns whether the module is debug signed, i.e. not meant for
+// production platforms.
+func (a *ACM) Debug() bool {
+	return a.Header.Flags&ACMFlagDebugSigned != 0
+}
+
+// KeyHash returns the SHA-256 of the public key modulus.
+func (a *ACM) KeyHash() [sha256.Size]byte {
+	return sha256.Sum256(a.PubKey)
+}
+
+// KeyName returns the name of the signing key in KnownACMKeys, or "unknown".
+func (a *ACM) KeyName() string {
+	if k, ok := KnownACMKeys[a.KeyHash()]; ok {
+		return k.Name
+	}
+	return "unknown"
+}
+
+// Signing returns whether the module is "production" or "debug" signed. A
+// known key decides it. Otherwise it is told by the debug flag of the
+// header, which can only be trusted as far as the unknown key, and known is
+// false.
+func (a *ACM) Signing() (signing string, known bool) {
+	debug := a.Debug()
+	k, known := KnownACMKeys[a.KeyHash()]
+	if known {
+		debug = k.Debug
+	}
+	if debug {
+		return "debug", known
+	}
+	return "production", known
+}
+
+// signedData returns the data covered by the signature: the start of the
+// header, and the module body after the header and scratch area.
+func (a *ACM) signedData() []byte {
+	start := (uint64(a.Header.HeaderLen) + uint64(a.Header.ScratchSize)) * 4
+	if start > uint64(len(a.buf)) {
+		start = uint64(len(a.buf))
+	}
+	body := a.buf[start:]
+	return append(append([]byte{}, a.buf[:acmSignedHeaderLength]...), body...)
+}
+
+// Verify checks the RSA signature of the module against its public key. It
+// does not tell whether the key is trusted, see KeyName for that.
+func (a *ACM) Verify() error {
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(a.PubKey), E: int(a.Exponent)}
+	if a.Header.HeaderVersion < acmHeaderVersion3 {
+		h := sha256.Sum256(a.signedData())
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], a.Signature)
+	}
+	h := crypto.SHA384.New()
+	h.Write(a.signedData())
+	return rsa.VerifyPSS(pub, crypto.SHA384, h.Sum(nil), a.Signature, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto})
+}
@@ -0,0 +1,155 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// makeACM returns an ACM with a 0x100 byte body signed with a new key. Version
+// 3 modules are signed with RSASSA-PSS, others with PKCS#1 v1.5.
+func makeACM(t *testing.T, version uint32, flags uint16) []byte {
+	bits, keyLen, expLen := 2048, 256, 4
+	if version >= acmHeaderVersion3 {
+		bits, keyLen, expLen = 3072, 384, 0
+	}
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headerLen := acmSignedHeaderLength + 2*keyLen + expLen
+	const scratch = 0x40
+	size := headerLen + scratch + 0x100
+	buf := make([]byte, size)
+	binary.LittleEndian.PutUint16(buf[0:], acmModuleType)
+	binary.LittleEndian.PutUint32(buf[0x04:], uint32(headerLen/4))
+	binary.LittleEndian.PutUint32(buf[0x08:], version)
+	binary.LittleEndian.PutUint16(buf[0x0e:], flags)
+	binary.LittleEndian.PutUint32(buf[0x10:], acmModuleVendor)
+	binary.LittleEndian.PutUint32(buf[0x18:], uint32(size/4))
+	binary.LittleEndian.PutUint32(buf[0x78:], uint32(keyLen/4))
+	binary.LittleEndian.PutUint32(buf[0x7c:], scratch/4)
+	copy(buf[0x80:], reversed(key.N.Bytes()))
+	if expLen != 0 {
+		binary.LittleEndian.PutUint32(buf[0x80+keyLen:], uint32(key.E))
+	}
+	for i := headerLen + scratch; i < size; i++ {
+		buf[i] = byte(i)
+	}
+
+	a := ACM{buf: buf}
+	a.Header.HeaderLen = uint32(headerLen / 4)
+	a.Header.ScratchSize = scratch / 4
+	var sig []byte
+	if expLen != 0 {
+		h := sha256.Sum256(a.signedData())
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	} else {
+		h := crypto.SHA384.New()
+		h.Write(a.signedData())
+		sig, err = rsa.SignPSS(rand.Reader, key, crypto.SHA384, h.Sum(nil), nil)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(buf[0x80+keyLen+expLen:], reversed(sig))
+	return buf
+}
+
+func TestACMVerify(t *testing.T) {
+	var tests = []struct {
+		name    string
+		version uint32
+		flags   uint16
+	}{
+		{"v1 production", 0, 0},
+		{"v1 debug", 0, ACMFlagDebugSigned},
+		{"v3 production", acmHeaderVersion3, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := makeACM(t, test.version, test.flags)
+			a, err := NewACM(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := a.Verify(); err != nil {
+				t.Errorf("expected a valid signature, got %v", err)
+			}
+			if debug := test.flags&ACMFlagDebugSigned != 0; a.Debug() != debug {
+				t.Errorf("expected debug %v, got %v", debug, a.Debug())
+			}
+			if a.KeyName() != "unknown" {
+				t.Errorf("expected an unknown key, got %q", a.KeyName())
+			}
+			want := "production"
+			if test.flags&ACMFlagDebugSigned != 0 {
+				want = "debug"
+			}
+			if signing, known := a.Signing(); signing != want || known {
+				t.Errorf("expected %s signing by the header, got %s (known %v)", want, signing, known)
+			}
+
+			// Tamper with the body.
+			buf[len(buf)-1]++
+			if err := a.Verify(); err == nil {
+				t.Error("expected an invalid signature after changing the body")
+			}
+		})
+	}
+}
+
+func TestNewACMErrors(t *testing.T) {
+	if _, err := NewACM(make([]byte, 0x100)); err == nil {
+		t.Error("expected an error without an ACM header")
+	}
+	buf := makeACM(t, 0, 0)
+	if _, err := NewACM(buf[:len(buf)-4]); err == nil {
+		t.Error("expected an error for a truncated ACM")
+	}
+
+	// A key filling the whole header leaves no room for the exponent.
+	headerLen := binary.LittleEndian.Uint32(buf[0x04:]) * 4
+	binary.LittleEndian.PutUint32(buf[0x18:], headerLen/4)
+	binary.LittleEndian.PutUint32(buf[0x78:], (headerLen-acmSignedHeaderLength)/4)
+	if _, err := NewACM(buf[:headerLen]); err == nil {
+		t.Error("expected an error for a key without room for its exponent")
+	}
+}
+
+func TestKnownACMKeys(t *testing.T) {
+	defer func(keys map[[sha256.Size]byte]ACMKey) { KnownACMKeys = keys }(KnownACMKeys)
+	KnownACMKeys = map[[sha256.Size]byte]ACMKey{}
+
+	// A debug key signing a module which claims to be production signed.
+	a, err := NewACM(makeACM(t, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := a.KeyHash()
+	keys := fmt.Sprintf(`{"%x": {"Name": "test debug key", "Debug": true}}`, h)
+	if err := LoadACMKeys(strings.NewReader(keys)); err != nil {
+		t.Fatal(err)
+	}
+	if a.KeyName() != "test debug key" {
+		t.Errorf("expected the key to resolve to its name, got %q", a.KeyName())
+	}
+	if signing, known := a.Signing(); signing != "debug" || !known {
+		t.Errorf("expected debug signing by the known key, got %s (known %v)", signing, known)
+	}
+
+	for _, bad := range []string{`{"12": {"Name": "short"}}`, `{"xyz": {}}`, `[]`} {
+		if err := LoadACMKeys(strings.NewReader(bad)); err == nil {
+			t.Errorf("expected an error loading %s", bad)
+		}
+	}
+}
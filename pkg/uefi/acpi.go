@@ -0,0 +1,193 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// The ACPI tables of EDK2 images are stored, one per raw section, in the ACPI
+// table storage file. The OEM activation tables, SLIC and MSDM, are often
+// stored apart, in a raw or freeform file of the OEM.
+
+// ACPITableStorageFileGUID is the file of the ACPI tables of EDK2 images.
+var ACPITableStorageFileGUID = uuid.MustParse("7E374E25-8E01-4FEE-87F2-390C23C606CD")
+
+// OEM activation table signatures.
+const (
+	ACPISignatureSLIC = "SLIC"
+	ACPISignatureMSDM = "MSDM"
+)
+
+// acpiSignatureFACS is the only table without the common header, it has no
+// checksum and no OEM IDs.
+const acpiSignatureFACS = "FACS"
+
+// ACPITableHeaderSize is the size of an ACPI table header.
+const ACPITableHeaderSize = 36
+
+// ACPITableHeader represents an EFI_ACPI_DESCRIPTION_HEADER.
+type ACPITableHeader struct {
+	Signature       [4]uint8
+	Length          uint32
+	Revision        uint8
+	Checksum        uint8
+	OEMID           [6]uint8
+	OEMTableID      [8]uint8
+	OEMRevision     uint32
+	CreatorID       [4]uint8
+	CreatorRevision uint32
+}
+
+// ACPITable is an ACPI table found in a buffer.
+type ACPITable struct {
+	Header ACPITableHeader `json:"-"`
+	// The fields of the header, for the JSON.
+	Signature       string
+	Length          uint32
+	Revision        uint8
+	OEMID           string `json:",omitempty"`
+	OEMTableID      string `json:",omitempty"`
+	OEMRevision     uint32 `json:",omitempty"`
+	CreatorID       string `json:",omitempty"`
+	CreatorRevision uint32 `json:",omitempty"`
+	// Offset of the table in the buffer it was found in.
+	Offset uint64
+
+	buf []byte
+}
+
+// Buf returns the table, including the header.
+func (t *ACPITable) Buf() []byte {
+	return t.buf
+}
+
+// acpiChecksum returns the sum of the bytes of a table, 0 for a valid table.
+func acpiChecksum(buf []byte) uint8 {
+	var sum uint8
+	for _, c := range buf {
+		sum += c
+	}
+	return sum
+}
+
+// NewACPITable parses an ACPI table, buf may be longer than the table.
+func NewACPITable(buf []byte) (*ACPITable, error) {
+	t := &ACPITable{}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &t.Header); err != nil {
+		return nil, err
+	}
+	t.Length = t.Header.Length
+	if t.Length < ACPITableHeaderSize || uint64(t.Length) > uint64(len(buf)) {
+		return nil, fmt.Errorf("ACPI table of %#x bytes out of bounds, buffer is %#x bytes", t.Length, len(buf))
+	}
+	t.buf = buf[:t.Length]
+	t.Signature = string(t.Header.Signature[:])
+	t.Revision = t.Header.Revision
+	if t.Signature == acpiSignatureFACS {
+		// The version of the FACS is at offset 32.
+		t.Revision = t.buf[32]
+		return t, nil
+	}
+	// The tables of the EDK2 storage file have a zero checksum, it is
+	// computed when they are installed.
+	if sum := acpiChecksum(t.buf); sum != 0 && t.Header.Checksum != 0 {
+		return nil, fmt.Errorf("ACPI table %q has a bad checksum, the bytes add up to %#02x", t.Header.Signature[:], sum)
+	}
+	trim := func(b []uint8) string {
+		return strings.TrimRight(string(b), "\x00 ")
+	}
+	t.OEMID = trim(t.Header.OEMID[:])
+	t.OEMTableID = trim(t.Header.OEMTableID[:])
+	t.OEMRevision = t.Header.OEMRevision
+	t.CreatorID = trim(t.Header.CreatorID[:])
+	t.CreatorRevision = t.Header.CreatorRevision
+	return t, nil
+}
+
+// FindACPITables returns the valid ACPI tables of buf with one of the
+// signatures.
+func FindACPITables(buf []byte, signatures ...string) []*ACPITable {
+	var tables []*ACPITable
+	for i := 0; i+ACPITableHeaderSize <= len(buf); i++ {
+		for _, sig := range signatures {
+			if string(buf[i:i+4]) != sig {
+				continue
+			}
+			if t, err := NewACPITable(buf[i:]); err == nil {
+				t.Offset = uint64(i)
+				tables = append(tables, t)
+				// Tables do not overlap.
+				i += int(t.Length) - 1
+			}
+			break
+		}
+	}
+	return tables
+}
+
+// isACPISignature returns whether b starts with a table signature, four
+// upper case letters, digits or underscores.
+func isACPISignature(b []byte) bool {
+	if len(b) < 4 {
+		return false
+	}
+	for _, c := range b[:4] {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseACPITables returns the ACPI tables of the data of a raw file or
+// section: the tables stored back to back from its start, like in the ACPI
+// table storage file, and the OEM activation tables found anywhere.
+func ParseACPITables(buf []byte) []*ACPITable {
+	var tables []*ACPITable
+	offset := 0
+	for isACPISignature(buf[offset:]) {
+		t, err := NewACPITable(buf[offset:])
+		if err != nil {
+			break
+		}
+		t.Offset = uint64(offset)
+		tables = append(tables, t)
+		offset += int(t.Length)
+	}
+	for _, t := range FindACPITables(buf[offset:], ACPISignatureSLIC, ACPISignatureMSDM) {
+		t.Offset += uint64(offset)
+		tables = append(tables, t)
+	}
+	return tables
+}
+
+// Replace overwrites the table with b, which must be a table of the same
+// signature and length. The checksum of b is fixed if needed.
+func (t *ACPITable) Replace(b []byte) error {
+	if len(b) < ACPITableHeaderSize || string(b[:4]) != t.Signature {
+		return fmt.Errorf("replacement of ACPI table %v is not a %v table", t.Signature, t.Signature)
+	}
+	if len(b) != len(t.buf) || binary.LittleEndian.Uint32(b[4:]) != t.Length {
+		return fmt.Errorf("replacement of ACPI table %v has %#x bytes, expected %#x", t.Signature, len(b), t.Length)
+	}
+	copy(t.buf, b)
+	if t.Signature != acpiSignatureFACS {
+		// The checksum is at offset 9 of the header.
+		t.buf[9] -= acpiChecksum(t.buf)
+	}
+	n, err := NewACPITable(t.buf)
+	if err != nil {
+		return err
+	}
+	n.Offset = t.Offset
+	*t = *n
+	return nil
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// acpiTable builds a table of length bytes with a valid checksum.
+func acpiTable(signature, oemTableID string, length int) []byte {
+	b := make([]byte, length)
+	copy(b, signature)
+	binary.LittleEndian.PutUint32(b[4:], uint32(length))
+	b[8] = 1
+	copy(b[10:], "OEMID ")
+	copy(b[16:], oemTableID)
+	for i := ACPITableHeaderSize; i < length; i++ {
+		b[i] = byte(i)
+	}
+	b[9] = -acpiChecksum(b)
+	return b
+}
+
+func TestFindACPITables(t *testing.T) {
+	slic := acpiTable(ACPISignatureSLIC, "SLIC-MPC", 0x176)
+	msdm := acpiTable(ACPISignatureMSDM, "A M I", 0x55)
+	badSum := acpiTable(ACPISignatureMSDM, "BAD", 0x55)
+	badSum[0x40]++
+	zeroSum := acpiTable(ACPISignatureSLIC, "EDK2", 0x40)
+	zeroSum[9] = 0
+	buf := bytes.Join([][]byte{[]byte("SLIC"), slic, []byte{0xff, 0xff, 0xff}, badSum, msdm, zeroSum, []byte("MSDM")}, nil)
+
+	tables := FindACPITables(buf, ACPISignatureSLIC, ACPISignatureMSDM)
+	want := []struct {
+		signature, oemTableID string
+		offset                uint64
+	}{
+		{"SLIC", "SLIC-MPC", 4},
+		{"MSDM", "A M I", uint64(4 + len(slic) + 3 + len(badSum))},
+		{"SLIC", "EDK2", uint64(4 + len(slic) + 3 + len(badSum) + len(msdm))},
+	}
+	if len(tables) != len(want) {
+		t.Fatalf("expected %d tables, got %d", len(want), len(tables))
+	}
+	for i, w := range want {
+		tb := tables[i]
+		if tb.Signature != w.signature || tb.Revision != 1 || tb.OEMID != "OEMID" || tb.OEMTableID != w.oemTableID || tb.Offset != w.offset {
+			t.Errorf("table %d: expected %v %v at %#x, got %v %v %v at %#x", i, w.signature, w.oemTableID, w.offset, tb.Signature, tb.OEMID, tb.OEMTableID, tb.Offset)
+		}
+	}
+	if !bytes.Equal(tables[0].Buf(), slic) {
+		t.Errorf("unexpected SLIC %v", tables[0].Buf())
+	}
+	if tables := FindACPITables(buf, "DSDT"); len(tables) != 0 {
+		t.Errorf("expected no DSDT, got %v", tables)
+	}
+}
+
+func TestACPITableReplace(t *testing.T) {
+	buf := append([]byte{0xff}, acpiTable(ACPISignatureSLIC, "OLD", 0x176)...)
+	tables := FindACPITables(buf, ACPISignatureSLIC)
+	if len(tables) != 1 {
+		t.Fatalf("expected 1 table, got %d", len(tables))
+	}
+	tb := tables[0]
+
+	for name, b := range map[string][]byte{
+		"signature": acpiTable(ACPISignatureMSDM, "NEW", 0x176),
+		"length":    acpiTable(ACPISignatureSLIC, "NEW", 0x177),
+		"header":    []byte("SLIC"),
+	} {
+		if err := tb.Replace(b); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+
+	// The checksum of the replacement is fixed.
+	slic := acpiTable(ACPISignatureSLIC, "NEW", 0x176)
+	slic[0x100]++
+	if err := tb.Replace(slic); err != nil {
+		t.Fatal(err)
+	}
+	if tb.OEMTableID != "NEW" || tb.Offset != 1 || acpiChecksum(buf[1:]) != 0 || buf[0] != 0xff {
+		t.Errorf("unexpected table %+v", tb)
+	}
+}
+
+func TestParseACPITables(t *testing.T) {
+	facs := make([]byte, 64)
+	copy(facs, acpiSignatureFACS)
+	facs[4], facs[8], facs[32] = 64, 0xab, 2
+	dsdt := acpiTable("DSDT", "DSDT", 0x40)
+	slic := acpiTable(ACPISignatureSLIC, "SLIC-MPC", 0x176)
+	// The SLIC follows padding, a table of the storage file would not.
+	buf := bytes.Join([][]byte{dsdt, facs, []byte("\xff\xffSSDT"), slic}, nil)
+
+	tables := ParseACPITables(buf)
+	want := []struct {
+		signature string
+		revision  uint8
+		offset    uint64
+	}{
+		{"DSDT", 1, 0},
+		{"FACS", 2, 0x40},
+		{"SLIC", 1, 0x40 + 64 + 6},
+	}
+	if len(tables) != len(want) {
+		t.Fatalf("expected %d tables, got %d", len(want), len(tables))
+	}
+	for i, w := range want {
+		tb := tables[i]
+		if tb.Signature != w.signature || tb.Revision != w.revision || tb.Offset != w.offset {
+			t.Errorf("table %d: expected %v revision %d at %#x, got %v revision %d at %#x", i, w.signature, w.revision, w.offset, tb.Signature, tb.Revision, tb.Offset)
+		}
+	}
+	if tables[1].OEMID != "" {
+		t.Errorf("expected no OEM ID for the FACS, got %q", tables[1].OEMID)
+	}
+
+	// The FACS has no checksum to fix.
+	if err := tables[1].Replace(facs); err != nil {
+		t.Fatal(err)
+	}
+	if buf[0x40+9] != 0 {
+		t.Errorf("expected the FACS to be unchanged, got %v", buf[0x40:0x80])
+	}
+
+	if tables := ParseACPITables([]byte("DSDT\xff\xff\xff\xff")); len(tables) != 0 {
+		t.Errorf("expected no tables, got %v", tables)
+	}
+}
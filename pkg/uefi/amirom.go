@@ -0,0 +1,104 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// AMI Aptio images carry a ROM layout, an array of ROM_AREA descriptors
+// giving the address, offset and size of each FV and raw area of the ROM.
+// The AMI flash tools and the recovery code rely on it, so it has to match
+// the FVs of the BIOS region. Offsets are from the start of the BIOS region,
+// which is mapped right below 4GiB.
+
+// ROMAreaSize is the size of a ROM_AREA descriptor.
+const ROMAreaSize = 24
+
+// ROM area types.
+const (
+	ROMAreaTypeFV  = 0
+	ROMAreaTypeRaw = 1
+)
+
+// ROMArea is an AMI ROM_AREA descriptor.
+type ROMArea struct {
+	Address    uint64
+	Offset     uint32
+	Size       uint32
+	Type       uint32
+	Attributes uint32
+}
+
+// ROMLayout is a ROM layout found in a buffer.
+type ROMLayout struct {
+	Areas []ROMArea
+	// Offset of the layout in the buffer it was found in.
+	Offset uint64
+
+	buf []byte
+}
+
+// Buf returns the descriptors of the layout, without the terminating one.
+func (l *ROMLayout) Buf() []byte {
+	return l.buf
+}
+
+// NewROMLayout parses the ROM layout at the start of buf, for a BIOS region
+// of regionSize bytes. The descriptors must describe areas of the region at
+// their addresses below 4GiB, the layout ends with the first one which does
+// not. buf may be longer than the layout.
+func NewROMLayout(buf []byte, regionSize uint64) (*ROMLayout, error) {
+	l := ROMLayout{}
+	r := bytes.NewReader(buf)
+	for {
+		var a ROMArea
+		if err := binary.Read(r, binary.LittleEndian, &a); err != nil {
+			break
+		}
+		if a.Size == 0 || a.Type > ROMAreaTypeRaw || uint64(a.Offset)+uint64(a.Size) > regionSize ||
+			a.Address != OffsetToAddress(uint64(a.Offset), regionSize) {
+			break
+		}
+		l.Areas = append(l.Areas, a)
+	}
+	// A single descriptor is too likely to be a coincidence.
+	if len(l.Areas) < 2 {
+		return nil, fmt.Errorf("no ROM layout for a BIOS region of %#x bytes", regionSize)
+	}
+	l.buf = buf[:len(l.Areas)*ROMAreaSize]
+	return &l, nil
+}
+
+// FindROMLayouts returns the ROM layouts of buf for a BIOS region of
+// regionSize bytes.
+func FindROMLayouts(buf []byte, regionSize uint64) []*ROMLayout {
+	var layouts []*ROMLayout
+	for i := 0; i+2*ROMAreaSize <= len(buf); i += 4 {
+		// Skip the offsets not holding the address of an area cheaply, as
+		// whole images are searched when they are assembled.
+		if binary.LittleEndian.Uint64(buf[i:]) != OffsetToAddress(uint64(binary.LittleEndian.Uint32(buf[i+8:])), regionSize) {
+			continue
+		}
+		l, err := NewROMLayout(buf[i:], regionSize)
+		if err != nil {
+			continue
+		}
+		l.Offset = uint64(i)
+		layouts = append(layouts, l)
+		i += len(l.buf) - 4
+	}
+	return layouts
+}
+
+// SetArea sets descriptor i of the layout, in place.
+func (l *ROMLayout) SetArea(i int, a ROMArea) {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, a)
+	copy(l.buf[i*ROMAreaSize:], b.Bytes())
+	l.Areas[i] = a
+}
@@ -0,0 +1,45 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestROMLayout(t *testing.T) {
+	const size = 0x800000
+	areas := []ROMArea{
+		{Address: 0xff800000, Offset: 0, Size: 0x10000, Type: ROMAreaTypeRaw},
+		{Address: 0xff810000, Offset: 0x10000, Size: 0x7f0000, Type: ROMAreaTypeFV, Attributes: 0x40},
+	}
+	var b bytes.Buffer
+	b.Write(bytes.Repeat([]byte{0xaa}, 0x14))
+	binary.Write(&b, binary.LittleEndian, areas)
+	binary.Write(&b, binary.LittleEndian, ROMArea{})
+	buf := b.Bytes()
+
+	layouts := FindROMLayouts(buf, size)
+	if len(layouts) != 1 {
+		t.Fatalf("expected a layout, got %d", len(layouts))
+	}
+	l := layouts[0]
+	if l.Offset != 0x14 || len(l.Buf()) != 2*ROMAreaSize || len(l.Areas) != 2 || l.Areas[1] != areas[1] {
+		t.Errorf("unexpected layout %+v", l)
+	}
+
+	// The areas have to be at their addresses in a region of that size.
+	if layouts := FindROMLayouts(buf, 2*size); len(layouts) != 0 {
+		t.Errorf("expected no layout for another region size, got %+v", layouts)
+	}
+
+	a := l.Areas[1]
+	a.Size = 0x400000
+	l.SetArea(1, a)
+	if got := binary.LittleEndian.Uint32(buf[0x14+ROMAreaSize+12:]); got != 0x400000 {
+		t.Errorf("expected the size to be set in the buffer, got %#x", got)
+	}
+}
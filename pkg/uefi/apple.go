@@ -0,0 +1,93 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+
+	"github.com/linuxboot/fiano/pkg/unicode"
+)
+
+// Apple firmware images deviate from the PI spec in a few places:
+//
+// - Apple boot volumes have their own filesystem GUIDs, but use the FFS2 layout.
+// - Apple stores a CRC32 of the volume body at offset 8 of the FV zero vector,
+//   followed by the number of bytes used in the volume. Both have to be kept
+//   in sync with the body when the volume is modified.
+// - The BIOS ID is stored as "$IBIOSI$" followed by a UCS-2 string.
+//
+// The embedded recovery structure of Apple images is not parsed, its format
+// is not documented. It is kept byte for byte as the data of the file or
+// padding holding it, like any other unknown data.
+
+const (
+	appleCRC32Offset     = 8
+	appleUsedSpaceOffset = 12
+)
+
+// BIOSIDSignature precedes the BIOS ID string in Apple and AMI images.
+var BIOSIDSignature = []byte("$IBIOSI$")
+
+// maxBIOSIDLen limits how far we look for the end of the BIOS ID.
+const maxBIOSIDLen = 256
+
+// hasAppleCRC32 checks if the zero vector holds a CRC32 of the volume body.
+func (fv *FirmwareVolume) hasAppleCRC32() bool {
+	crc := binary.LittleEndian.Uint32(fv.ZeroVector[appleCRC32Offset:])
+	if crc == 0 || uint64(len(fv.buf)) < uint64(fv.HeaderLen) {
+		return false
+	}
+	return crc32.ChecksumIEEE(fv.buf[fv.HeaderLen:]) == crc
+}
+
+// FixAppleCRC32 recomputes the Apple CRC32 and used space in the zero vector
+// of a fully assembled FV buffer. usedSpace is the number of bytes from the
+// start of the volume which are not free space. This must be done after the
+// extended header is written, since it is part of the body, and before the
+// header checksum is computed, since the zero vector is part of the header.
+func (fv *FirmwareVolume) FixAppleCRC32(usedSpace uint64) {
+	if !fv.AppleCRC32 {
+		return
+	}
+	crc := crc32.ChecksumIEEE(fv.buf[fv.HeaderLen:])
+	binary.LittleEndian.PutUint32(fv.ZeroVector[appleCRC32Offset:], crc)
+	binary.LittleEndian.PutUint32(fv.buf[appleCRC32Offset:], crc)
+	if binary.LittleEndian.Uint32(fv.ZeroVector[appleUsedSpaceOffset:]) != 0 {
+		binary.LittleEndian.PutUint32(fv.ZeroVector[appleUsedSpaceOffset:], uint32(usedSpace))
+		binary.LittleEndian.PutUint32(fv.buf[appleUsedSpaceOffset:], uint32(usedSpace))
+	}
+}
+
+// FindBIOSID searches the buffer for a BIOS ID and returns it, or an empty
+// string if there is none. Apple stores the ID as a UCS-2 string, AMI uses
+// the same signature followed by an ASCII string.
+func FindBIOSID(buf []byte) string {
+	i := bytes.Index(buf, BIOSIDSignature)
+	if i < 0 {
+		return ""
+	}
+	id := buf[i+len(BIOSIDSignature):]
+	if len(id) > maxBIOSIDLen {
+		id = id[:maxBIOSIDLen]
+	}
+	if len(id) > 1 && id[1] == 0 {
+		// Null terminated UCS-2 string.
+		for j := 0; j+1 < len(id); j += 2 {
+			if id[j] == 0 && id[j+1] == 0 {
+				if j == 0 {
+					return ""
+				}
+				return unicode.UCS2ToUTF8(id[:j])
+			}
+		}
+		return ""
+	}
+	if j := bytes.IndexByte(id, 0); j >= 0 {
+		return string(id[:j])
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/unicode"
+)
+
+func TestAppleCRC32(t *testing.T) {
+	buf := append([]byte{}, sampleFV...)
+	fv, err := NewFirmwareVolume(buf, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fv.AppleCRC32 {
+		t.Fatal("OVMF FV should not have an Apple CRC32")
+	}
+
+	// Put a valid CRC32 into the zero vector and reparse.
+	binary.LittleEndian.PutUint32(buf[appleCRC32Offset:], crc32.ChecksumIEEE(buf[fv.HeaderLen:]))
+	binary.LittleEndian.PutUint16(buf[fvChecksumOffset:], 0)
+	sum, err := Checksum16(buf[:fv.HeaderLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint16(buf[fvChecksumOffset:], 0-sum)
+	if fv, err = NewFirmwareVolume(buf, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if !fv.AppleCRC32 {
+		t.Fatal("Apple CRC32 was not detected")
+	}
+
+	// Modify the body and make sure the CRC32 gets fixed up.
+	buf[len(buf)-1] ^= 0xFF
+	fv.FixAppleCRC32(0)
+	if got, want := binary.LittleEndian.Uint32(buf[appleCRC32Offset:]), crc32.ChecksumIEEE(buf[fv.HeaderLen:]); got != want {
+		t.Errorf("Apple CRC32 was %#x, expected %#x", got, want)
+	}
+}
+
+func TestFindBIOSID(t *testing.T) {
+	var tests = []struct {
+		name string
+		buf  []byte
+		id   string
+	}{
+		{"none", []byte("no id in here"), ""},
+		{"apple", append(append([]byte("junk$IBIOSI$"), unicode.UTF8ToUCS2("MBP121.88Z.0167.B17")...), 0xFF, 0xFF),
+			"MBP121.88Z.0167.B17"},
+		{"ami", []byte("junk$IBIOSI$X570AORUS\x00\xFF"), "X570AORUS"},
+		{"unterminated", []byte("$IBIOSI$abc"), ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if id := FindBIOSID(test.buf); id != test.id {
+				t.Errorf("FindBIOSID() = %q; want %q", id, test.id)
+			}
+		})
+	}
+}
+
+func TestAppleBoot2(t *testing.T) {
+	buf := append([]byte{}, sampleFV...)
+	// The filesystem GUID as it is stored in the header of newer Macs.
+	copy(buf[16:], []byte{0x8c, 0x1b, 0x00, 0xbd, 0x71, 0x6a, 0x7b, 0x48,
+		0xa1, 0x4f, 0x0c, 0x2a, 0x2d, 0xcf, 0x7a, 0x5d})
+	binary.LittleEndian.PutUint16(buf[fvChecksumOffset:], 0)
+	sum, err := Checksum16(buf[:binary.LittleEndian.Uint16(buf[0x30:])])
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint16(buf[fvChecksumOffset:], 0-sum)
+	fv, err := NewFirmwareVolume(buf, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fv.FileSystemGUID != *AppleBoot2 {
+		t.Fatalf("filesystem GUID is %v, expected %v", fv.FileSystemGUID, AppleBoot2)
+	}
+	if len(fv.Files) == 0 {
+		t.Error("files of the APPLE_BOOT2 FV were not parsed")
+	}
+}
@@ -18,12 +18,21 @@ type BIOSPadding struct {
 
 	// Metadata
 	ExtractPath string
+	Location
+
+	// ParseError is set if the padding is an FV which could not be parsed.
+	ParseError string `json:",omitempty"`
+	// EC is the EC firmware held by the padding, if any.
+	EC *ECFirmware `json:",omitempty"`
 }
 
 // NewBIOSPadding parses a sequence of bytes and returns a BIOSPadding
 // object.
 func NewBIOSPadding(buf []byte, offset uint64) (*BIOSPadding, error) {
 	bp := &BIOSPadding{buf: buf, Offset: offset}
+	if !IsErased(buf, Attributes.ErasePolarity) {
+		bp.EC = FindECFirmware(buf)
+	}
 	return bp, nil
 }
 
@@ -38,8 +47,12 @@ func (bp *BIOSPadding) SetBuf(buf []byte) {
 }
 
 // Validate validates the BIOSPadding.
-// There's really nothing to validate since this isn't a proper UEFI data structure
+// There's really nothing to validate since this isn't a proper UEFI data
+// structure, unless it is an FV which could not be parsed.
 func (bp *BIOSPadding) Validate() []error {
+	if bp.ParseError != "" {
+		return []error{errors.New(bp.ParseError)}
+	}
 	return nil
 }
 
@@ -63,15 +76,19 @@ type BIOSRegion struct {
 	//Metadata for extraction and recovery
 	ExtractPath string
 	Length      uint64
+	Location
 	// This is a pointer to the Region struct laid out in the ifd
 	Position *Region `json:",omitempty"`
+
+	// BIOSID is the vendor BIOS version string, if one was found.
+	BIOSID string `json:",omitempty"`
 }
 
 // NewBIOSRegion parses a sequence of bytes and returns a BIOSRegion
 // object, if a valid one is passed, or an error. It also points to the
 // Region struct uncovered in the ifd.
 func NewBIOSRegion(buf []byte, r *Region) (*BIOSRegion, error) {
-	br := BIOSRegion{buf: buf, Position: r, Length: uint64(len(buf))}
+	br := BIOSRegion{buf: buf, Position: r, Length: uint64(len(buf)), BIOSID: FindBIOSID(buf)}
 	var absOffset uint64
 	for {
 		offset := FindFirmwareVolumeOffset(buf)
@@ -99,7 +116,27 @@ func NewBIOSRegion(buf []byte, r *Region) (*BIOSRegion, error) {
 		absOffset += uint64(offset)                                  // Find start of volume relative to bios region.
 		fv, err := NewFirmwareVolume(buf[offset:], absOffset, false) // False as top level FVs are not resizable
 		if err != nil {
-			return nil, err
+			parseErr := fmt.Errorf("unable to parse FV at offset %#x: %v", absOffset, err)
+			if err := recoverNode(parseErr); err != nil {
+				return nil, err
+			}
+			// Keep everything up to the next FV as padding. The search
+			// starts past the signature of the broken FV.
+			end := uint64(len(buf))
+			if start := offset + 48; start < int64(len(buf)) {
+				if next := FindFirmwareVolumeOffset(buf[start:]); next >= 0 {
+					end = uint64(start + next)
+				}
+			}
+			bp, err := NewBIOSPadding(buf[offset:end], absOffset)
+			if err != nil {
+				return nil, err
+			}
+			bp.ParseError = parseErr.Error()
+			br.Elements = append(br.Elements, MakeTyped(bp))
+			absOffset += end - uint64(offset)
+			buf = buf[end:]
+			continue
 		}
 		absOffset += fv.Length
 		buf = buf[uint64(offset)+fv.Length:]
@@ -144,6 +181,19 @@ func (br *BIOSRegion) ApplyChildren(v Visitor) error {
 	return nil
 }
 
+// ElementOffset returns the offset of a BIOS region element relative to the
+// start of the region. Only firmware volumes and padding can be elements of
+// the BIOS region.
+func ElementOffset(f Firmware) (uint64, error) {
+	switch f := f.(type) {
+	case *FirmwareVolume:
+		return f.FVOffset, nil
+	case *BIOSPadding:
+		return f.Offset, nil
+	}
+	return 0, fmt.Errorf("%T is not a BIOS region element", f)
+}
+
 // FirstFV finds the first firmware volume in the BIOSRegion.
 func (br *BIOSRegion) FirstFV() (*FirmwareVolume, error) {
 	for _, e := range br.Elements {
@@ -166,6 +216,26 @@ func (br *BIOSRegion) Validate() []error {
 		errs = append(errs, err)
 	}
 
+	// Elements are expected to cover the region contiguously, anything else means
+	// data will be lost or overwritten when the region is assembled.
+	var expected uint64
+	for _, e := range br.Elements {
+		offset, err := ElementOffset(e.Value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if offset != expected {
+			errs = append(errs, fmt.Errorf("BIOS region element %s at offset %#x, expected %#x",
+				e.Type, offset, expected))
+		}
+		expected = offset + uint64(len(e.Value.Buf()))
+	}
+	if expected != br.Length {
+		errs = append(errs, fmt.Errorf("BIOS region elements end at %#x, region is %#x bytes long",
+			expected, br.Length))
+	}
+
 	for i, e := range br.Elements {
 		errs = append(errs, e.Value.Validate()...)
 		f, ok := e.Value.(*FirmwareVolume)
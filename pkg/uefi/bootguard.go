@@ -0,0 +1,385 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// Structure IDs of the Boot Guard 1.0 key manifest (KM), boot policy
+// manifest (BPM) and its elements.
+var (
+	KMStructureID   = []byte("__KEYM__")
+	BPMStructureID  = []byte("__ACBP__")
+	IBBStructureID  = []byte("__IBBS__")
+	PMSGStructureID = []byte("__PMSG__")
+)
+
+// Boot Guard algorithm identifiers, as in the TPM 2.0 specification.
+const (
+	BGAlgRSA         = 0x0001
+	BGAlgSHA256      = 0x000B
+	BGAlgRSASSA      = 0x0014
+	bgStructureIDLen = 8
+)
+
+// BGHash is a digest along with its algorithm.
+type BGHash struct {
+	Alg    uint16
+	Digest []byte
+}
+
+// parseBGHash reads a hash structure and returns it with its length.
+func parseBGHash(buf []byte) (BGHash, int, error) {
+	if len(buf) < 4 {
+		return BGHash{}, 0, fmt.Errorf("hash structure out of bounds")
+	}
+	n := int(binary.LittleEndian.Uint16(buf[2:]))
+	if 4+n > len(buf) {
+		return BGHash{}, 0, fmt.Errorf("digest of %d bytes out of bounds", n)
+	}
+	return BGHash{Alg: binary.LittleEndian.Uint16(buf), Digest: buf[4 : 4+n]}, 4 + n, nil
+}
+
+// BGKeySignature is the public key and signature of a manifest. The modulus
+// and signature are in little endian, as stored.
+type BGKeySignature struct {
+	Version    uint8
+	KeyAlg     uint16
+	KeyVersion uint8
+	KeyBits    uint16
+	Exponent   uint32
+	Modulus    []byte `json:"-"`
+	SigScheme  uint16
+	SigVersion uint8
+	SigBits    uint16
+	HashAlg    uint16
+	Signature  []byte `json:"-"`
+
+	// Offsets of the modulus and signature in the manifest.
+	modulusOffset   int
+	signatureOffset int
+}
+
+// parseBGKeySignature reads the key signature structure at off in the
+// manifest.
+func parseBGKeySignature(buf []byte, off int) (*BGKeySignature, error) {
+	k := &BGKeySignature{}
+	r := buf[off:]
+	if len(r) < 12 {
+		return nil, fmt.Errorf("key signature out of bounds")
+	}
+	k.Version = r[0]
+	k.KeyAlg = binary.LittleEndian.Uint16(r[1:])
+	k.KeyVersion = r[3]
+	k.KeyBits = binary.LittleEndian.Uint16(r[4:])
+	k.Exponent = binary.LittleEndian.Uint32(r[6:])
+	if k.KeyAlg != BGAlgRSA {
+		return nil, fmt.Errorf("unsupported key algorithm %#x", k.KeyAlg)
+	}
+	n := int(k.KeyBits) / 8
+	k.modulusOffset = off + 10
+	if 10+n+7 > len(r) {
+		return nil, fmt.Errorf("%d bit key out of bounds", k.KeyBits)
+	}
+	k.Modulus = r[10 : 10+n]
+	s := r[10+n:]
+	k.SigScheme = binary.LittleEndian.Uint16(s)
+	k.SigVersion = s[2]
+	k.SigBits = binary.LittleEndian.Uint16(s[3:])
+	k.HashAlg = binary.LittleEndian.Uint16(s[5:])
+	k.signatureOffset = k.modulusOffset + n + 7
+	if 7+int(k.SigBits)/8 > len(s) {
+		return nil, fmt.Errorf("%d bit signature out of bounds", k.SigBits)
+	}
+	k.Signature = s[7 : 7+int(k.SigBits)/8]
+	return k, nil
+}
+
+// KeyHash returns the SHA-256 of the exponent and modulus, as stored. This is
+// what the key manifest holds for the boot policy manifest key, and what is
+// fused into the chipset for the key manifest key.
+func (k *BGKeySignature) KeyHash() [sha256.Size]byte {
+	e := make([]byte, 4)
+	binary.LittleEndian.PutUint32(e, k.Exponent)
+	return sha256.Sum256(append(e, k.Modulus...))
+}
+
+// setKey replaces the public key in the manifest buffer. The key has to be of
+// the same size as the current one, since the manifest layout depends on it.
+func (k *BGKeySignature) setKey(buf []byte, key *rsa.PublicKey) error {
+	n := len(k.Modulus)
+	if (key.N.BitLen()+7)/8 != n {
+		return fmt.Errorf("%d bit key does not replace the %d bit key of the manifest", key.N.BitLen(), k.KeyBits)
+	}
+	binary.LittleEndian.PutUint32(buf[k.modulusOffset-4:], uint32(key.E))
+	copy(buf[k.modulusOffset:], reversed(key.N.Bytes()))
+	k.Exponent = uint32(key.E)
+	k.Modulus = buf[k.modulusOffset : k.modulusOffset+n]
+	return nil
+}
+
+// sign replaces the public key in the manifest buffer and signs the signed
+// data with the key.
+func (k *BGKeySignature) sign(buf []byte, signed func() []byte, key *rsa.PrivateKey) error {
+	if err := k.setKey(buf, &key.PublicKey); err != nil {
+		return err
+	}
+	if k.SigScheme != BGAlgRSASSA || k.HashAlg != BGAlgSHA256 {
+		return fmt.Errorf("unsupported signature scheme %#x with hash %#x", k.SigScheme, k.HashAlg)
+	}
+	h := sha256.Sum256(signed())
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h[:])
+	if err != nil {
+		return err
+	}
+	if len(sig) != len(k.Signature) {
+		return fmt.Errorf("signature of %d bytes does not replace the %d bytes one", len(sig), len(k.Signature))
+	}
+	copy(buf[k.signatureOffset:], reversed(sig))
+	return nil
+}
+
+// Verify checks the signature of the signed data.
+func (k *BGKeySignature) Verify(signed []byte) error {
+	if k.SigScheme != BGAlgRSASSA || k.HashAlg != BGAlgSHA256 {
+		return fmt.Errorf("unsupported signature scheme %#x with hash %#x", k.SigScheme, k.HashAlg)
+	}
+	pub := &rsa.PublicKey{N: new(big.Int).SetBytes(reversed(k.Modulus)), E: int(k.Exponent)}
+	h := sha256.Sum256(signed)
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, h[:], reversed(k.Signature))
+}
+
+// KeyManifest is a Boot Guard key manifest. It is signed with the OEM key
+// whose hash is fused into the chipset, and holds the hash of the key of the
+// boot policy manifest.
+type KeyManifest struct {
+	buf          []byte
+	Version      uint8
+	KMVersion    uint8
+	SVN          uint8
+	ID           uint8
+	BPKeyHash    BGHash
+	KeySignature *BGKeySignature
+}
+
+// NewKeyManifest parses the key manifest at the start of buf.
+func NewKeyManifest(buf []byte) (*KeyManifest, error) {
+	if len(buf) < bgStructureIDLen+4 || !bytes.Equal(buf[:bgStructureIDLen], KMStructureID) {
+		return nil, fmt.Errorf("no %s key manifest found", KMStructureID)
+	}
+	km := &KeyManifest{
+		Version:   buf[8],
+		KMVersion: buf[9],
+		SVN:       buf[10],
+		ID:        buf[11],
+	}
+	h, n, err := parseBGHash(buf[12:])
+	if err != nil {
+		return nil, err
+	}
+	km.BPKeyHash = h
+	if km.KeySignature, err = parseBGKeySignature(buf, 12+n); err != nil {
+		return nil, err
+	}
+	km.buf = buf[:km.KeySignature.signatureOffset+len(km.KeySignature.Signature)]
+	return km, nil
+}
+
+// Buf returns the manifest.
+func (km *KeyManifest) Buf() []byte {
+	return km.buf
+}
+
+// signedData returns the manifest up to the key signature.
+func (km *KeyManifest) signedData() []byte {
+	return km.buf[:km.KeySignature.modulusOffset-10]
+}
+
+// Verify checks the signature of the manifest.
+func (km *KeyManifest) Verify() error {
+	return km.KeySignature.Verify(km.signedData())
+}
+
+// SetBPKeyHash replaces the hash of the boot policy manifest key. The
+// manifest has to be signed again afterwards.
+func (km *KeyManifest) SetBPKeyHash(hash []byte) error {
+	if len(hash) != len(km.BPKeyHash.Digest) {
+		return fmt.Errorf("%d bytes hash does not replace the %d bytes one", len(hash), len(km.BPKeyHash.Digest))
+	}
+	copy(km.BPKeyHash.Digest, hash)
+	return nil
+}
+
+// Sign replaces the key of the manifest with the key and signs it.
+func (km *KeyManifest) Sign(key *rsa.PrivateKey) error {
+	return km.KeySignature.sign(km.buf, km.signedData, key)
+}
+
+// ibbSegmentNotHashed is the flag of segments excluded from the IBB hash.
+const ibbSegmentNotHashed = 1
+
+// IBBSegment is a range of the initial boot block hashed by the ACM.
+type IBBSegment struct {
+	_     uint16
+	Flags uint16
+	Base  uint32
+	Size  uint32
+}
+
+// ibbElementHeader is the fixed start of the IBB element.
+type ibbElementHeader struct {
+	StructureID   [8]byte
+	Version       uint8
+	_             [2]uint8
+	Flags         uint32
+	MCHBAR        uint64
+	VTdBAR        uint64
+	DMAProtBase0  uint32
+	DMAProtLimit0 uint32
+	DMAProtBase1  uint64
+	DMAProtLimit1 uint64
+}
+
+// BootPolicyManifest is a Boot Guard boot policy manifest. It describes the
+// initial boot block (IBB) the ACM measures and verifies.
+type BootPolicyManifest struct {
+	buf          []byte
+	Version      uint8
+	PMBPMVersion uint8
+	SVN          uint8
+	ACMSVN       uint8
+	IBBFlags     uint32
+	PostIBBHash  BGHash
+	EntryPoint   uint32
+	IBBHash      BGHash
+	IBBSegments  []IBBSegment
+	KeySignature *BGKeySignature
+
+	// pmsgOffset is the offset of the signature element, ibbHashOffset the
+	// one of the IBB digest.
+	pmsgOffset    int
+	ibbHashOffset int
+}
+
+// NewBootPolicyManifest parses the boot policy manifest at the start of buf.
+func NewBootPolicyManifest(buf []byte) (*BootPolicyManifest, error) {
+	if len(buf) < 16 || !bytes.Equal(buf[:bgStructureIDLen], BPMStructureID) {
+		return nil, fmt.Errorf("no %s boot policy manifest found", BPMStructureID)
+	}
+	bpm := &BootPolicyManifest{
+		Version:      buf[8],
+		PMBPMVersion: buf[10],
+		SVN:          buf[11],
+		ACMSVN:       buf[12],
+	}
+	// The elements follow the 16 byte header.
+	ibb := bytes.Index(buf[16:], IBBStructureID)
+	if ibb < 0 {
+		return nil, fmt.Errorf("no %s element in the boot policy manifest", IBBStructureID)
+	}
+	off := 16 + ibb
+	var h ibbElementHeader
+	if err := binary.Read(bytes.NewReader(buf[off:]), binary.LittleEndian, &h); err != nil {
+		return nil, fmt.Errorf("IBB element out of bounds: %v", err)
+	}
+	bpm.IBBFlags = h.Flags
+	off += binary.Size(h)
+	hash, n, err := parseBGHash(buf[off:])
+	if err != nil {
+		return nil, err
+	}
+	bpm.PostIBBHash = hash
+	off += n
+	if off+4 > len(buf) {
+		return nil, fmt.Errorf("IBB entry point out of bounds")
+	}
+	bpm.EntryPoint = binary.LittleEndian.Uint32(buf[off:])
+	off += 4
+	if hash, n, err = parseBGHash(buf[off:]); err != nil {
+		return nil, err
+	}
+	bpm.IBBHash = hash
+	bpm.ibbHashOffset = off + 4
+	off += n
+	if off >= len(buf) {
+		return nil, fmt.Errorf("IBB segment count out of bounds")
+	}
+	bpm.IBBSegments = make([]IBBSegment, buf[off])
+	if err := binary.Read(bytes.NewReader(buf[off+1:]), binary.LittleEndian, bpm.IBBSegments); err != nil {
+		return nil, fmt.Errorf("IBB segments out of bounds: %v", err)
+	}
+	off += 1 + binary.Size(bpm.IBBSegments)
+
+	pmsg := bytes.Index(buf[off:], PMSGStructureID)
+	if pmsg < 0 {
+		return nil, fmt.Errorf("no %s element in the boot policy manifest", PMSGStructureID)
+	}
+	bpm.pmsgOffset = off + pmsg
+	// The key signature follows the structure ID and version.
+	if bpm.KeySignature, err = parseBGKeySignature(buf, bpm.pmsgOffset+bgStructureIDLen+1); err != nil {
+		return nil, err
+	}
+	bpm.buf = buf[:bpm.KeySignature.signatureOffset+len(bpm.KeySignature.Signature)]
+	return bpm, nil
+}
+
+// Buf returns the manifest.
+func (bpm *BootPolicyManifest) Buf() []byte {
+	return bpm.buf
+}
+
+// signedData returns the manifest up to and including the header of the
+// signature element.
+func (bpm *BootPolicyManifest) signedData() []byte {
+	return bpm.buf[:bpm.pmsgOffset+bgStructureIDLen+1]
+}
+
+// Verify checks the signature of the manifest.
+func (bpm *BootPolicyManifest) Verify() error {
+	return bpm.KeySignature.Verify(bpm.signedData())
+}
+
+// IBBDigest computes the SHA-256 over the hashed IBB segments of the image,
+// which is mapped right below 4GiB.
+func (bpm *BootPolicyManifest) IBBDigest(image []byte) ([]byte, error) {
+	h := sha256.New()
+	for _, s := range bpm.IBBSegments {
+		if s.Flags&ibbSegmentNotHashed != 0 {
+			continue
+		}
+		offset, err := AddressToOffset(uint64(s.Base), uint64(len(image)))
+		if err != nil {
+			return nil, err
+		}
+		if offset+uint64(s.Size) > uint64(len(image)) {
+			return nil, fmt.Errorf("IBB segment at %#x of %#x bytes is beyond the end of the image", s.Base, s.Size)
+		}
+		h.Write(image[offset : offset+uint64(s.Size)])
+	}
+	return h.Sum(nil), nil
+}
+
+// SetIBBHash replaces the IBB digest. The manifest has to be signed again
+// afterwards.
+func (bpm *BootPolicyManifest) SetIBBHash(hash []byte) error {
+	if len(hash) != len(bpm.IBBHash.Digest) {
+		return fmt.Errorf("%d bytes hash does not replace the %d bytes one", len(hash), len(bpm.IBBHash.Digest))
+	}
+	copy(bpm.buf[bpm.ibbHashOffset:], hash)
+	return nil
+}
+
+// Sign replaces the key of the manifest with the key and signs it.
+func (bpm *BootPolicyManifest) Sign(key *rsa.PrivateKey) error {
+	return bpm.KeySignature.sign(bpm.buf, bpm.signedData, key)
+}
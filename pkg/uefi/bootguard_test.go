@@ -0,0 +1,194 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/binary"
+	"testing"
+)
+
+// makeBGKeySignature returns a key signature structure for the key with an
+// empty signature.
+func makeBGKeySignature(key *rsa.PrivateKey) []byte {
+	b := new(bytes.Buffer)
+	bits := uint16(key.N.BitLen())
+	binary.Write(b, binary.LittleEndian, struct {
+		Version    uint8
+		KeyAlg     uint16
+		KeyVersion uint8
+		KeyBits    uint16
+		Exponent   uint32
+	}{0x10, BGAlgRSA, 0x10, bits, uint32(key.E)})
+	b.Write(reversed(key.N.Bytes()))
+	binary.Write(b, binary.LittleEndian, struct {
+		SigScheme  uint16
+		SigVersion uint8
+		SigBits    uint16
+		HashAlg    uint16
+	}{BGAlgRSASSA, 0x10, bits, BGAlgSHA256})
+	b.Write(make([]byte, bits/8))
+	return b.Bytes()
+}
+
+// makeKM returns a key manifest signed with kmKey, authorizing the boot
+// policy manifest key with the given hash.
+func makeKM(t *testing.T, kmKey *rsa.PrivateKey, bpKeyHash []byte) []byte {
+	b := new(bytes.Buffer)
+	b.Write(KMStructureID)
+	b.Write([]byte{0x10, 0x10, 1, 2})
+	binary.Write(b, binary.LittleEndian, []uint16{BGAlgSHA256, uint16(len(bpKeyHash))})
+	b.Write(bpKeyHash)
+	b.Write(makeBGKeySignature(kmKey))
+	buf := b.Bytes()
+	km, err := NewKeyManifest(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := km.Sign(kmKey); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+// makeBPM returns a boot policy manifest signed with bpmKey, with a single
+// IBB segment.
+func makeBPM(t *testing.T, bpmKey *rsa.PrivateKey, ibbHash []byte) []byte {
+	b := new(bytes.Buffer)
+	b.Write(BPMStructureID)
+	b.Write([]byte{0x10, 0x01, 1, 3, 2, 0, 0, 0})
+	h := ibbElementHeader{Version: 0x10, Flags: 1}
+	copy(h.StructureID[:], IBBStructureID)
+	binary.Write(b, binary.LittleEndian, &h)
+	binary.Write(b, binary.LittleEndian, []uint16{BGAlgSHA256, 0})
+	binary.Write(b, binary.LittleEndian, uint32(0xfffffff0))
+	binary.Write(b, binary.LittleEndian, []uint16{BGAlgSHA256, uint16(len(ibbHash))})
+	b.Write(ibbHash)
+	b.WriteByte(1)
+	binary.Write(b, binary.LittleEndian, IBBSegment{Base: 0xffff0000, Size: 0x10000})
+	b.Write(PMSGStructureID)
+	b.WriteByte(0x10)
+	b.Write(makeBGKeySignature(bpmKey))
+	buf := b.Bytes()
+	bpm, err := NewBootPolicyManifest(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bpm.Sign(bpmKey); err != nil {
+		t.Fatal(err)
+	}
+	return buf
+}
+
+func TestBootGuardManifests(t *testing.T) {
+	kmKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bpmKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ibbHash := bytes.Repeat([]byte{0x5a}, sha256.Size)
+	bpmBuf := makeBPM(t, bpmKey, ibbHash)
+	bpm, err := NewBootPolicyManifest(bpmBuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := bpm.Verify(); err != nil {
+		t.Errorf("expected a valid BPM signature, got %v", err)
+	}
+	if bpm.SVN != 3 || bpm.ACMSVN != 2 || bpm.EntryPoint != 0xfffffff0 || bpm.IBBFlags != 1 {
+		t.Errorf("unexpected BPM %+v", bpm)
+	}
+	if !bytes.Equal(bpm.IBBHash.Digest, ibbHash) || len(bpm.IBBSegments) != 1 || bpm.IBBSegments[0].Size != 0x10000 {
+		t.Errorf("unexpected IBB hash %x and segments %+v", bpm.IBBHash.Digest, bpm.IBBSegments)
+	}
+
+	bpKeyHash := bpm.KeySignature.KeyHash()
+	km, err := NewKeyManifest(makeKM(t, kmKey, bpKeyHash[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := km.Verify(); err != nil {
+		t.Errorf("expected a valid KM signature, got %v", err)
+	}
+	if km.ID != 2 || !bytes.Equal(km.BPKeyHash.Digest, bpKeyHash[:]) {
+		t.Errorf("unexpected KM %+v", km)
+	}
+
+	// Tamper with the IBB hash.
+	bpmBuf[bytes.Index(bpmBuf, ibbHash)]++
+	if err := bpm.Verify(); err == nil {
+		t.Error("expected an invalid BPM signature after changing the IBB hash")
+	}
+}
+
+func TestBootGuardManifestErrors(t *testing.T) {
+	if _, err := NewKeyManifest([]byte("__ACBP__xxxx")); err == nil {
+		t.Error("expected an error for a BPM parsed as KM")
+	}
+	if _, err := NewBootPolicyManifest([]byte("__ACBP__\x10\x01\x01\x03\x02\x00\x00\x00")); err == nil {
+		t.Error("expected an error for a BPM without elements")
+	}
+}
+
+func TestBootGuardResign(t *testing.T) {
+	keys := make([]*rsa.PrivateKey, 3)
+	for i := range keys {
+		bits := 2048
+		if i == 2 {
+			bits = 3072
+		}
+		var err error
+		if keys[i], err = rsa.GenerateKey(rand.Reader, bits); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bpm, err := NewBootPolicyManifest(makeBPM(t, keys[0], make([]byte, sha256.Size)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hash the last 64KiB of an image.
+	image := bytes.Repeat([]byte{0xa5}, 0x20000)
+	digest, err := bpm.IBBDigest(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := sha256.Sum256(image[0x10000:]); !bytes.Equal(digest, want[:]) {
+		t.Errorf("expected IBB digest %x, got %x", want, digest)
+	}
+	if err := bpm.SetIBBHash(digest); err != nil {
+		t.Fatal(err)
+	}
+	oldKey := bpm.KeySignature.KeyHash()
+	if err := bpm.Sign(keys[1]); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := NewBootPolicyManifest(bpm.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := parsed.Verify(); err != nil {
+		t.Errorf("expected a valid signature after re-signing, got %v", err)
+	}
+	if !bytes.Equal(parsed.IBBHash.Digest, digest) {
+		t.Errorf("expected IBB digest %x, got %x", digest, parsed.IBBHash.Digest)
+	}
+	if parsed.KeySignature.KeyHash() == oldKey {
+		t.Error("expected the key to be replaced")
+	}
+
+	if err := bpm.Sign(keys[2]); err == nil {
+		t.Error("expected an error signing with a key of a different size")
+	}
+	if err := bpm.SetIBBHash(digest[:20]); err == nil {
+		t.Error("expected an error for a hash of a different size")
+	}
+}
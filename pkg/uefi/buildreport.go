@@ -0,0 +1,158 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// ModuleSource is the EDK2 module a file was built from.
+type ModuleSource struct {
+	// Name is the BASE_NAME of the module.
+	Name string
+	// Path is the path of the module's .inf file in the source tree.
+	Path string
+}
+
+// moduleSources maps file GUIDs to their modules, see SetModuleSources.
+var moduleSources = map[uuid.UUID]ModuleSource{}
+
+// SetModuleSources sets the modules of file GUIDs. Files parsed afterwards
+// are annotated with the module of their GUID.
+func SetModuleSources(m map[uuid.UUID]ModuleSource) {
+	moduleSources = m
+}
+
+// ParseBuildReport reads the module summaries of an EDK2 build report, as
+// written by build -y, and returns the modules by file GUID.
+func ParseBuildReport(r io.Reader) (map[uuid.UUID]ModuleSource, error) {
+	m := make(map[uuid.UUID]ModuleSource)
+	var cur ModuleSource
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if line == "Module Summary" {
+			cur = ModuleSource{}
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i < 0 {
+			continue
+		}
+		key, value := line[:i], strings.TrimSpace(line[i+1:])
+		switch key {
+		case "Module Name":
+			cur.Name = value
+		case "Module INF Path":
+			// Reports written on Windows have backslashes.
+			cur.Path = strings.Replace(value, `\`, "/", -1)
+		case "File GUID":
+			if cur.Name == "" {
+				continue
+			}
+			g, err := uuid.Parse(value)
+			if err != nil {
+				return nil, fmt.Errorf("module %v: %v", cur.Name, err)
+			}
+			m[*g] = cur
+		}
+	}
+	return m, s.Err()
+}
+
+// ParseINF reads the BASE_NAME and FILE_GUID of an EDK2 module .inf file.
+// path is the path of the file in the source tree.
+func ParseINF(r io.Reader, path string) (uuid.UUID, ModuleSource, error) {
+	src := ModuleSource{Path: filepath.ToSlash(path)}
+	var guid *uuid.UUID
+	var defines bool
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			defines = strings.EqualFold(strings.Trim(line, "[] "), "Defines")
+			continue
+		}
+		i := strings.Index(line, "=")
+		if !defines || i < 0 {
+			continue
+		}
+		key, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch key {
+		case "BASE_NAME":
+			src.Name = value
+		case "FILE_GUID":
+			g, err := uuid.Parse(value)
+			if err != nil {
+				return uuid.UUID{}, src, fmt.Errorf("%v: %v", path, err)
+			}
+			guid = g
+		}
+	}
+	if err := s.Err(); err != nil {
+		return uuid.UUID{}, src, err
+	}
+	if guid == nil || src.Name == "" {
+		return uuid.UUID{}, src, fmt.Errorf("%v: no BASE_NAME or FILE_GUID", path)
+	}
+	return *guid, src, nil
+}
+
+// LoadModuleSources reads the modules from path, which is either an EDK2
+// build report or a source tree, whose .inf files are read. The paths of the
+// .inf files are relative to the tree.
+func LoadModuleSources(path string) (map[uuid.UUID]ModuleSource, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return ParseBuildReport(file)
+	}
+	m := make(map[uuid.UUID]ModuleSource)
+	err = filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.EqualFold(filepath.Ext(p), ".inf") {
+			return nil
+		}
+		file, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		rel, err := filepath.Rel(path, p)
+		if err != nil {
+			return err
+		}
+		// Library instances and the like have no FILE_GUID of their own
+		// in an image, they are skipped.
+		g, src, err := ParseINF(file, rel)
+		if err != nil {
+			return nil
+		}
+		m[g] = src
+		return nil
+	})
+	return m, err
+}
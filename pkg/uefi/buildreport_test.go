@@ -0,0 +1,106 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+const sampleBuildReport = `Platform Summary
+Platform Name:        Ovmf
+>======================================================================<
+Module Summary
+Module Name:          PeiCore
+Module INF Path:      MdeModulePkg\Core\Pei\PeiMain.inf
+File GUID:            52C05B14-0B98-496c-BC3B-04B50211D680
+Size:                 0xBE00 (47.50K)
+>======================================================================<
+Module Summary
+Module Name:          PlatformPei
+Module INF Path:      OvmfPkg/PlatformPei/PlatformPei.inf
+File GUID:            222c386d-5abc-4fb4-b124-fbb82488acf4
+`
+
+const sampleINF = `## @file
+#  The shell.
+##
+
+[Defines]
+  INF_VERSION    = 0x00010006
+  BASE_NAME      = Shell   # the UI name
+  FILE_GUID      = 7C04A583-9E3E-4f1c-AD65-E05268D0B4D1
+  MODULE_TYPE    = UEFI_APPLICATION
+
+[Sources]
+  Shell.c
+`
+
+func TestParseBuildReport(t *testing.T) {
+	m, err := ParseBuildReport(strings.NewReader(sampleBuildReport))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[uuid.UUID]ModuleSource{
+		*uuid.MustParse("52C05B14-0B98-496C-BC3B-04B50211D680"): {"PeiCore", "MdeModulePkg/Core/Pei/PeiMain.inf"},
+		*uuid.MustParse("222C386D-5ABC-4FB4-B124-FBB82488ACF4"): {"PlatformPei", "OvmfPkg/PlatformPei/PlatformPei.inf"},
+	}
+	if len(m) != len(want) {
+		t.Fatalf("expected %d modules, got %v", len(want), m)
+	}
+	for g, src := range want {
+		if m[g] != src {
+			t.Errorf("%v: expected %+v, got %+v", g, src, m[g])
+		}
+	}
+}
+
+func TestLoadModuleSources(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buildreport")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.MkdirAll(filepath.Join(dir, "ShellPkg", "Application", "Shell"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"ShellPkg/Application/Shell/Shell.inf": sampleINF,
+		// Library instances without a FILE_GUID are skipped.
+		"ShellPkg/Lib.inf":   "[Defines]\n  BASE_NAME = Lib\n",
+		"ShellPkg/README.md": "FILE_GUID = 00000000-0000-0000-0000-000000000000",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := LoadModuleSources(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := *uuid.MustParse("7C04A583-9E3E-4F1C-AD65-E05268D0B4D1")
+	if len(m) != 1 || m[g] != (ModuleSource{"Shell", "ShellPkg/Application/Shell/Shell.inf"}) {
+		t.Errorf("unexpected modules %v", m)
+	}
+
+	// Files are annotated with their module when parsed.
+	var fileGUID uuid.UUID
+	copy(fileGUID[:], goodFreeFormFile)
+	SetModuleSources(map[uuid.UUID]ModuleSource{fileGUID: {"Linux", "LinuxPkg/Linux.inf"}})
+	defer SetModuleSources(map[uuid.UUID]ModuleSource{})
+	f, err := NewFile(goodFreeFormFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Source == nil || f.Source.Name != "Linux" {
+		t.Errorf("expected the file to be annotated, got %+v", f.Source)
+	}
+}
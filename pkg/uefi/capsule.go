@@ -0,0 +1,317 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// CapsuleHeaderLength is the length of the EFI_CAPSULE_HEADER.
+const CapsuleHeaderLength = 28
+
+// aptioRomImageOffset is the offset of the image offset in the header of
+// AMI Aptio capsules, which follows the EFI_CAPSULE_HEADER.
+const aptioRomImageOffset = CapsuleHeaderLength
+
+// Capsule GUIDs, which are followed by the rest of the capsule header.
+var (
+	EFICapsuleGUID           = uuid.MustParse("3B6686BD-0D76-4030-B70E-B5519E2FC5A0")
+	FMPCapsuleGUID           = uuid.MustParse("6DCBD5ED-E82D-4C44-BDA1-7194199AD92A")
+	IntelCapsuleGUID         = uuid.MustParse("539182B9-ABB5-4391-B69A-E3A943F72FCC")
+	LenovoCapsuleGUID        = uuid.MustParse("E20BAFD3-9914-4F4F-9537-3129E090EB3C")
+	Lenovo2CapsuleGUID       = uuid.MustParse("25B5FE76-8243-4A5C-A9BD-7EE3246198B5")
+	ToshibaCapsuleGUID       = uuid.MustParse("3BE07062-1D51-45D2-832B-F093257ED461")
+	AptioSignedCapsuleGUID   = uuid.MustParse("4A3CA68B-7723-48FB-803D-578CC1FEC44D")
+	AptioUnsignedCapsuleGUID = uuid.MustParse("14EEBB90-890A-43DB-AED1-5D3C4588A418")
+)
+
+// CapsuleGUIDs maps the known capsule GUIDs to the capsule vendor.
+var CapsuleGUIDs = map[uuid.UUID]string{
+	*EFICapsuleGUID:           "EFI",
+	*FMPCapsuleGUID:           "FMP",
+	*IntelCapsuleGUID:         "Intel",
+	*LenovoCapsuleGUID:        "Lenovo",
+	*Lenovo2CapsuleGUID:       "Lenovo",
+	*ToshibaCapsuleGUID:       "Toshiba",
+	*AptioSignedCapsuleGUID:   "Aptio",
+	*AptioUnsignedCapsuleGUID: "Aptio",
+}
+
+// CapsuleHeader is the EFI_CAPSULE_HEADER. Toshiba capsules have the same
+// fields, but the image size comes before the flags.
+type CapsuleHeader struct {
+	GUID             uuid.UUID
+	HeaderSize       uint32
+	Flags            uint32
+	CapsuleImageSize uint32
+}
+
+// Capsule is an update capsule wrapping a firmware image. The image is parsed
+// as the Payload if possible, otherwise the capsule is kept as it is. FMP
+// capsules hold any number of images instead, see FMPImages.
+type Capsule struct {
+	Header CapsuleHeader
+	// Vendor is the format of the capsule, see CapsuleGUIDs.
+	Vendor string
+	// Offset is the offset of the capsule in its capsule file.
+	Offset uint64
+	// PayloadOffset and PayloadLength locate the image in the capsule.
+	// Anything after the image, e.g. a signature, is kept as it is.
+	PayloadOffset uint64
+	PayloadLength uint64
+	Payload       *TypedFirmware `json:",omitempty"`
+
+	// FMPHeader and FMPItemOffsets are set for FMP capsules. The offsets
+	// of the embedded drivers and the images are relative to the FMP
+	// header. The drivers are kept as they are.
+	FMPHeader      *FMPCapsuleHeader `json:",omitempty"`
+	FMPItemOffsets []uint64          `json:",omitempty"`
+	FMPImages      []*FMPImage       `json:",omitempty"`
+
+	// Metadata
+	ExtractPath string
+	Location
+
+	buf []byte
+}
+
+// IsCapsule returns whether buf starts with a known capsule header.
+func IsCapsule(buf []byte) bool {
+	if len(buf) < CapsuleHeaderLength {
+		return false
+	}
+	var g uuid.UUID
+	copy(g[:], buf)
+	_, ok := CapsuleGUIDs[g]
+	return ok
+}
+
+// NewCapsule parses a capsule. The payload is parsed like an image, failing
+// which it is kept opaque.
+func NewCapsule(buf []byte) (*Capsule, error) {
+	if !IsCapsule(buf) {
+		return nil, fmt.Errorf("no known capsule header")
+	}
+	c := Capsule{buf: buf}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &c.Header); err != nil {
+		return nil, err
+	}
+	c.Vendor = CapsuleGUIDs[c.Header.GUID]
+	if c.Vendor == "Toshiba" {
+		c.Header.Flags, c.Header.CapsuleImageSize = c.Header.CapsuleImageSize, c.Header.Flags
+	}
+	c.PayloadOffset = uint64(c.Header.HeaderSize)
+	if c.Vendor == "Aptio" {
+		if len(buf) < aptioRomImageOffset+2 {
+			return nil, fmt.Errorf("Aptio capsule header too short")
+		}
+		c.PayloadOffset = uint64(binary.LittleEndian.Uint16(buf[aptioRomImageOffset:]))
+	}
+	end := uint64(c.Header.CapsuleImageSize)
+	if end > uint64(len(buf)) || end == 0 {
+		end = uint64(len(buf))
+	}
+	if c.PayloadOffset < CapsuleHeaderLength || c.PayloadOffset > end {
+		return nil, fmt.Errorf("capsule image at %#x is out of range, the capsule is %#x bytes", c.PayloadOffset, end)
+	}
+	c.PayloadLength = end - c.PayloadOffset
+
+	if c.Vendor == "FMP" {
+		if err := c.parseFMP(); err != nil {
+			log.Printf("unable to parse the FMP capsule, keeping it as it is: %v", err)
+		}
+		return &c, nil
+	}
+	payload, err := Parse(buf[c.PayloadOffset:end])
+	if err != nil {
+		log.Printf("unable to parse the %v capsule image, keeping it as it is: %v", c.Vendor, err)
+		return &c, nil
+	}
+	c.Payload = MakeTyped(payload)
+	return &c, nil
+}
+
+// SetPayloadBuf replaces the image in the capsule buffer with buf, and
+// updates the image size in the header.
+func (c *Capsule) SetPayloadBuf(buf []byte) {
+	nb := make([]byte, 0, uint64(len(c.buf))-c.PayloadLength+uint64(len(buf)))
+	nb = append(nb, c.buf[:c.PayloadOffset]...)
+	nb = append(nb, buf...)
+	nb = append(nb, c.buf[c.PayloadOffset+c.PayloadLength:]...)
+	if uint64(c.Header.CapsuleImageSize) == c.PayloadOffset+c.PayloadLength {
+		c.Header.CapsuleImageSize = uint32(c.PayloadOffset) + uint32(len(buf))
+		sizeOffset := 24
+		if c.Vendor == "Toshiba" {
+			sizeOffset = 20
+		}
+		binary.LittleEndian.PutUint32(nb[sizeOffset:], c.Header.CapsuleImageSize)
+	}
+	c.PayloadLength = uint64(len(buf))
+	c.buf = nb
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (c *Capsule) Buf() []byte {
+	return c.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (c *Capsule) SetBuf(buf []byte) {
+	c.buf = buf
+}
+
+// Apply calls the visitor on the Capsule.
+func (c *Capsule) Apply(v Visitor) error {
+	return v.Visit(c)
+}
+
+// ApplyChildren calls the visitor on the payload or the FMP images of the
+// Capsule.
+func (c *Capsule) ApplyChildren(v Visitor) error {
+	if c.Payload != nil {
+		if err := c.Payload.Value.Apply(v); err != nil {
+			return err
+		}
+	}
+	for _, img := range c.FMPImages {
+		if err := img.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the capsule header and the payload.
+func (c *Capsule) Validate() []error {
+	var errs []error
+	if c.PayloadOffset+c.PayloadLength > uint64(len(c.buf)) {
+		errs = append(errs, fmt.Errorf("capsule image ends at %#x, past the end of the %#x bytes capsule",
+			c.PayloadOffset+c.PayloadLength, len(c.buf)))
+	}
+	if c.Payload != nil {
+		errs = append(errs, c.Payload.Value.Validate()...)
+	}
+	for _, img := range c.FMPImages {
+		errs = append(errs, img.Validate()...)
+	}
+	return errs
+}
+
+// capsuleSize returns the size of the capsule at the start of buf, or
+// len(buf) if it has no valid size.
+func capsuleSize(buf []byte) uint64 {
+	sizeOffset := 24
+	if bytes.Equal(buf[:16], ToshibaCapsuleGUID[:]) {
+		sizeOffset = 20
+	}
+	size := uint64(binary.LittleEndian.Uint32(buf[sizeOffset:]))
+	if size < CapsuleHeaderLength || size > uint64(len(buf)) {
+		return uint64(len(buf))
+	}
+	return size
+}
+
+// isCapsuleFile returns whether buf holds more than one capsule.
+func isCapsuleFile(buf []byte) bool {
+	if !IsCapsule(buf) {
+		return false
+	}
+	return IsCapsule(buf[capsuleSize(buf):])
+}
+
+// CapsuleFile is a capsule-on-disk file, as staged in \EFI\UpdateCapsule of
+// the ESP, which holds several capsules back to back.
+type CapsuleFile struct {
+	Capsules []*Capsule
+	// TrailerOffset is where the data after the last capsule starts, it is
+	// kept as it is.
+	TrailerOffset uint64
+
+	// Metadata
+	ExtractPath string
+	Location
+
+	buf []byte
+}
+
+// NewCapsuleFile parses the capsules in a capsule-on-disk file.
+func NewCapsuleFile(buf []byte) (*CapsuleFile, error) {
+	cf := CapsuleFile{buf: buf}
+	var offset uint64
+	for offset < uint64(len(buf)) && IsCapsule(buf[offset:]) {
+		size := capsuleSize(buf[offset:])
+		c, err := NewCapsule(buf[offset : offset+size])
+		if err != nil {
+			return nil, fmt.Errorf("capsule at %#x: %v", offset, err)
+		}
+		c.Offset = offset
+		cf.Capsules = append(cf.Capsules, c)
+		offset += size
+	}
+	if len(cf.Capsules) == 0 {
+		return nil, fmt.Errorf("no known capsule header")
+	}
+	cf.TrailerOffset = offset
+	return &cf, nil
+}
+
+// AssembleCapsules rebuilds the file from the buffers of its capsules.
+func (cf *CapsuleFile) AssembleCapsules() {
+	var nb []byte
+	for _, c := range cf.Capsules {
+		c.Offset = uint64(len(nb))
+		nb = append(nb, c.Buf()...)
+	}
+	trailer := cf.buf[cf.TrailerOffset:]
+	cf.TrailerOffset = uint64(len(nb))
+	cf.buf = append(nb, trailer...)
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (cf *CapsuleFile) Buf() []byte {
+	return cf.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (cf *CapsuleFile) SetBuf(buf []byte) {
+	cf.buf = buf
+}
+
+// Apply calls the visitor on the CapsuleFile.
+func (cf *CapsuleFile) Apply(v Visitor) error {
+	return v.Visit(cf)
+}
+
+// ApplyChildren calls the visitor on each capsule of the CapsuleFile.
+func (cf *CapsuleFile) ApplyChildren(v Visitor) error {
+	for _, c := range cf.Capsules {
+		if err := c.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the capsules.
+func (cf *CapsuleFile) Validate() []error {
+	var errs []error
+	if cf.TrailerOffset > uint64(len(cf.buf)) {
+		errs = append(errs, fmt.Errorf("capsules end at %#x, past the end of the %#x bytes file",
+			cf.TrailerOffset, len(cf.buf)))
+	}
+	for _, c := range cf.Capsules {
+		errs = append(errs, c.Validate()...)
+	}
+	return errs
+}
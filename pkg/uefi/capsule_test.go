@@ -0,0 +1,226 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// makeCapsule wraps payload in a capsule header of the given GUID, followed
+// by a fake signature.
+func makeCapsule(guid *uuid.UUID, payload []byte) []byte {
+	const headerSize = 0x40
+	buf := make([]byte, headerSize)
+	copy(buf, guid[:])
+	size := uint32(headerSize + len(payload))
+	binary.LittleEndian.PutUint32(buf[16:], headerSize)
+	if *guid == *ToshibaCapsuleGUID {
+		binary.LittleEndian.PutUint32(buf[20:], size)
+		binary.LittleEndian.PutUint32(buf[24:], 0x10000)
+	} else {
+		binary.LittleEndian.PutUint32(buf[20:], 0x10000)
+		binary.LittleEndian.PutUint32(buf[24:], size)
+	}
+	// Aptio capsules point to the image separately.
+	binary.LittleEndian.PutUint16(buf[aptioRomImageOffset:], headerSize)
+	buf = append(buf, payload...)
+	return append(buf, "signature"...)
+}
+
+func TestDetectContainer(t *testing.T) {
+	padded := append(bytes.Repeat([]byte{0xff}, 0x1000), sampleFV...)
+	var tests = []struct {
+		name string
+		buf  []byte
+		want Container
+	}{
+		{"capsule", makeCapsule(EFICapsuleGUID, sampleFV), ContainerCapsule},
+		{"flash image", makeDescriptor(false), ContainerFlashImage},
+		{"FV", sampleFV, ContainerFV},
+		{"BIOS region", padded, ContainerBIOSRegion},
+		{"truncated FV", sampleFV[:len(sampleFV)-8], ContainerBIOSRegion},
+		{"junk", bytes.Repeat([]byte("junk"), 0x100), ContainerUnknown},
+		{"short", []byte{1, 2, 3}, ContainerUnknown},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DetectContainer(test.buf); got != test.want {
+				t.Errorf("expected %v, got %v", test.want, got)
+			}
+		})
+	}
+}
+
+func TestParseContainers(t *testing.T) {
+	f, err := Parse(sampleFV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fv, ok := f.(*FirmwareVolume); !ok || len(fv.Files) != 3 {
+		t.Errorf("expected a standalone FV, got %T", f)
+	}
+
+	if _, err := Parse(bytes.Repeat([]byte("junk"), 0x100)); err == nil {
+		t.Error("expected an error for junk")
+	}
+}
+
+func TestCapsule(t *testing.T) {
+	for _, guid := range []*uuid.UUID{EFICapsuleGUID, ToshibaCapsuleGUID, AptioSignedCapsuleGUID} {
+		t.Run(CapsuleGUIDs[*guid], func(t *testing.T) {
+			buf := makeCapsule(guid, sampleFV)
+			f, err := Parse(buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			c, ok := f.(*Capsule)
+			if !ok {
+				t.Fatalf("expected a capsule, got %T", f)
+			}
+			if c.PayloadOffset != 0x40 || c.PayloadLength != uint64(len(sampleFV)) || c.Header.Flags != 0x10000 {
+				t.Errorf("unexpected capsule %+v", c)
+			}
+			fv, ok := c.Payload.Value.(*FirmwareVolume)
+			if !ok || len(fv.Files) != 3 {
+				t.Fatalf("expected the FV as the payload, got %v", c.Payload)
+			}
+			for _, err := range c.Validate() {
+				t.Error(err)
+			}
+
+			// The header is updated when the payload grows, the signature
+			// is kept.
+			grown := append(append([]byte{}, sampleFV...), 0xff, 0xff, 0xff, 0xff)
+			c.SetPayloadBuf(grown)
+			if !bytes.Equal(c.Buf(), makeCapsule(guid, grown)) {
+				t.Error("unexpected capsule after replacing the payload")
+			}
+		})
+	}
+
+	// Payloads which are not images are kept opaque.
+	c, err := NewCapsule(makeCapsule(FMPCapsuleGUID, []byte("not an image")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Payload != nil || c.PayloadLength != uint64(len("not an image")) {
+		t.Errorf("expected an opaque payload, got %+v", c)
+	}
+}
+
+// makeFMPImage returns an FMP capsule image of header version 2 holding
+// payload, signed if auth is set.
+func makeFMPImage(guid *uuid.UUID, payload []byte, auth bool) []byte {
+	buf := make([]byte, 40)
+	binary.LittleEndian.PutUint32(buf, 2)
+	copy(buf[4:], guid[:])
+	buf[20] = 1
+	if auth {
+		a := make([]byte, 8+24+0x10)
+		binary.LittleEndian.PutUint32(a[8:], 24+0x10)
+		binary.LittleEndian.PutUint16(a[12:], winCertRevision)
+		binary.LittleEndian.PutUint16(a[14:], winCertTypeEFIGUID)
+		payload = append(a, payload...)
+	}
+	binary.LittleEndian.PutUint32(buf[fmpImageSizeOffset:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(buf[28:], 4)
+	return append(append(buf, payload...), "vend"...)
+}
+
+// makeFMPCapsule returns an FMP capsule with an embedded driver and the
+// images.
+func makeFMPCapsule(images ...[]byte) []byte {
+	driver := []byte("driver")
+	n := 1 + len(images)
+	body := make([]byte, fmpCapsuleHeaderLength+8*n)
+	binary.LittleEndian.PutUint32(body, 1)
+	binary.LittleEndian.PutUint16(body[4:], 1)
+	binary.LittleEndian.PutUint16(body[6:], uint16(len(images)))
+	for i, item := range append([][]byte{driver}, images...) {
+		binary.LittleEndian.PutUint64(body[fmpCapsuleHeaderLength+8*i:], uint64(len(body)))
+		body = append(body, item...)
+	}
+	c := makeCapsule(FMPCapsuleGUID, body)
+	// No signature after FMP capsules.
+	return c[:len(c)-len("signature")]
+}
+
+func TestFMPCapsule(t *testing.T) {
+	guid := uuid.MustParse("11111111-2222-3333-4444-555555555555")
+	buf := makeFMPCapsule(makeFMPImage(guid, sampleFV, true), makeFMPImage(guid, []byte("opaque"), false))
+	f, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, ok := f.(*Capsule)
+	if !ok {
+		t.Fatalf("expected a capsule, got %T", f)
+	}
+	if len(c.FMPImages) != 2 || len(c.FMPItemOffsets) != 3 {
+		t.Fatalf("expected 2 FMP images, got %+v", c)
+	}
+	signed, opaque := c.FMPImages[0], c.FMPImages[1]
+	if signed.Header.UpdateImageTypeID != *guid || signed.HeaderLen != 40 || signed.AuthLen != 8+24+0x10 {
+		t.Errorf("unexpected FMP image header %+v", signed)
+	}
+	if fv, ok := signed.Payload.Value.(*FirmwareVolume); !ok || len(fv.Files) != 3 {
+		t.Errorf("expected the FV in the signed image, got %v", signed.Payload)
+	}
+	if opaque.Payload != nil || opaque.AuthLen != 0 {
+		t.Errorf("expected an opaque image, got %+v", opaque)
+	}
+	for _, err := range c.Validate() {
+		t.Error(err)
+	}
+
+	// Growing the first image moves the second one.
+	grown := append(append([]byte{}, sampleFV...), 0xff, 0xff, 0xff, 0xff)
+	signed.SetPayloadBuf(grown)
+	c.AssembleFMP()
+	want := makeFMPCapsule(makeFMPImage(guid, grown, true), makeFMPImage(guid, []byte("opaque"), false))
+	if !bytes.Equal(c.Buf(), want) {
+		t.Error("unexpected capsule after growing the first image")
+	}
+}
+
+func TestCapsuleFile(t *testing.T) {
+	first := makeCapsule(EFICapsuleGUID, sampleFV)
+	first = first[:len(first)-len("signature")]
+	second := makeCapsule(ToshibaCapsuleGUID, []byte("opaque"))
+	second = second[:len(second)-len("signature")]
+	buf := append(append(append([]byte{}, first...), second...), "trailer"...)
+
+	if got := DetectContainer(buf); got != ContainerCapsuleFile {
+		t.Fatalf("expected a capsule file, got %v", got)
+	}
+	f, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, ok := f.(*CapsuleFile)
+	if !ok {
+		t.Fatalf("expected a capsule file, got %T", f)
+	}
+	if len(cf.Capsules) != 2 || cf.Capsules[1].Offset != uint64(len(first)) || cf.TrailerOffset != uint64(len(first)+len(second)) {
+		t.Fatalf("unexpected capsule file %+v", cf)
+	}
+	if cf.Capsules[0].Payload == nil || cf.Capsules[1].Payload != nil {
+		t.Error("expected only the first capsule to have a parsed payload")
+	}
+
+	grown := append(append([]byte{}, sampleFV...), 0xff, 0xff, 0xff, 0xff)
+	cf.Capsules[0].SetPayloadBuf(grown)
+	cf.AssembleCapsules()
+	first = makeCapsule(EFICapsuleGUID, grown)
+	first = first[:len(first)-len("signature")]
+	want := append(append(append([]byte{}, first...), second...), "trailer"...)
+	if !bytes.Equal(cf.Buf(), want) || cf.Capsules[1].Offset != uint64(len(first)) {
+		t.Error("unexpected capsule file after growing the first capsule")
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/lzma"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// Compressor decodes and encodes the data of GUID defined sections which
+// require processing.
+type Compressor interface {
+	// Name is shown in the Compression field of the section.
+	Name() string
+	Decode(encodedData []byte) ([]byte, error)
+	Encode(decodedData []byte) ([]byte, error)
+}
+
+// compressors maps the section GUIDs to their Compressor.
+var compressors = map[uuid.UUID]Compressor{}
+
+// RegisterCompressor registers the Compressor for the GUID defined sections
+// with the given GUID. Sections with a GUID that has no Compressor are kept as
+// they are and cannot be modified.
+func RegisterCompressor(guid uuid.UUID, c Compressor) {
+	if _, ok := compressors[guid]; ok {
+		panic(fmt.Sprintf("two compressors registered for the same GUID: %v", guid))
+	}
+	compressors[guid] = c
+}
+
+// CompressorFromGUID returns the Compressor registered for the GUID, or nil.
+func CompressorFromGUID(guid uuid.UUID) Compressor {
+	return compressors[guid]
+}
+
+// LZMACompressor returns the Compressor of the LZMA or LZMAX86 GUID defined
+// sections which uses the coder, e.g. to encode with the xz backend, or nil
+// for other GUIDs. The registered ones use the pure Go implementation.
+func LZMACompressor(guid uuid.UUID, coder lzma.Coder) Compressor {
+	switch guid {
+	case LZMAGUID:
+		return lzmaCompressor{coder}
+	case LZMAX86GUID:
+		return lzmaX86Compressor{coder}
+	}
+	return nil
+}
+
+// lzmaCompressor implements the LZMA compression of EDK2.
+type lzmaCompressor struct {
+	coder lzma.Coder
+}
+
+// Name implements Compressor.
+func (lzmaCompressor) Name() string {
+	return "LZMA"
+}
+
+// Decode implements Compressor.
+func (c lzmaCompressor) Decode(encodedData []byte) ([]byte, error) {
+	return c.coder.Decode(encodedData)
+}
+
+// Encode implements Compressor.
+func (c lzmaCompressor) Encode(decodedData []byte) ([]byte, error) {
+	return c.coder.Encode(decodedData)
+}
+
+// lzmaX86Compressor implements the LZMA compression with the x86 branch
+// filter of EDK2.
+type lzmaX86Compressor struct {
+	coder lzma.Coder
+}
+
+// Name implements Compressor.
+func (lzmaX86Compressor) Name() string {
+	return "LZMAX86"
+}
+
+// Decode implements Compressor.
+func (c lzmaX86Compressor) Decode(encodedData []byte) ([]byte, error) {
+	return c.coder.DecodeX86(encodedData)
+}
+
+// Encode implements Compressor.
+func (c lzmaX86Compressor) Encode(decodedData []byte) ([]byte, error) {
+	return c.coder.EncodeX86(decodedData)
+}
+
+func init() {
+	RegisterCompressor(LZMAGUID, lzmaCompressor{})
+	RegisterCompressor(LZMAX86GUID, lzmaX86Compressor{})
+}
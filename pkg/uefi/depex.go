@@ -0,0 +1,163 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// EncodeDepEx encodes the dependency expression, which has to end with END.
+func EncodeDepEx(ops []DepExOp) ([]byte, error) {
+	codes := make(map[DepExOpCode]byte, len(DepExOpCodes))
+	for b, op := range DepExOpCodes {
+		codes[op] = b
+	}
+	var buf []byte
+	for i, op := range ops {
+		b, ok := codes[op.OpCode]
+		if !ok {
+			return nil, fmt.Errorf("invalid DEPEX opcode %q", op.OpCode)
+		}
+		buf = append(buf, b)
+		switch op.OpCode {
+		case "BEFORE", "AFTER", "PUSH":
+			if op.GUID == nil {
+				return nil, fmt.Errorf("DEPEX %v without a GUID", op.OpCode)
+			}
+			buf = append(buf, op.GUID[:]...)
+		case "END":
+			if i != len(ops)-1 {
+				return nil, fmt.Errorf("DEPEX END at %d of %d opcodes", i, len(ops))
+			}
+			return buf, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid DEPEX, no END")
+}
+
+// depExNode is a node of a dependency expression tree, args are the operands
+// of AND, OR and NOT.
+type depExNode struct {
+	op   DepExOp
+	args []*depExNode
+}
+
+var (
+	depExTrue  = &depExNode{op: DepExOp{OpCode: "TRUE"}}
+	depExFalse = &depExNode{op: DepExOp{OpCode: "FALSE"}}
+)
+
+// postfix appends the opcodes of the tree to ops.
+func (n *depExNode) postfix(ops []DepExOp) []DepExOp {
+	for _, a := range n.args {
+		ops = a.postfix(ops)
+	}
+	return append(ops, n.op)
+}
+
+// DepExWithout returns the dependency expression with the terms pushing the
+// GUID assumed to be satisfied, e.g. `A AND B` becomes `B` when removing A.
+// BEFORE and AFTER the GUID become TRUE. The expression is simplified, so
+// removing the only term leaves TRUE.
+func DepExWithout(ops []DepExOp, g uuid.UUID) ([]DepExOp, error) {
+	var prefix []DepExOp
+	var stack []*depExNode
+	pop := func() (*depExNode, error) {
+		if len(stack) == 0 {
+			return nil, fmt.Errorf("invalid DEPEX, stack underflow")
+		}
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		return n, nil
+	}
+	for i, op := range ops {
+		switch op.OpCode {
+		case "SOR":
+			if i != 0 {
+				return nil, fmt.Errorf("invalid DEPEX, SOR at %d", i)
+			}
+			prefix = append(prefix, op)
+		case "BEFORE", "AFTER":
+			if op.GUID != nil && *op.GUID == g {
+				return []DepExOp{{OpCode: "TRUE"}, {OpCode: "END"}}, nil
+			}
+			return ops, nil
+		case "PUSH":
+			if op.GUID != nil && *op.GUID == g {
+				stack = append(stack, depExTrue)
+			} else {
+				stack = append(stack, &depExNode{op: op})
+			}
+		case "TRUE":
+			stack = append(stack, depExTrue)
+		case "FALSE":
+			stack = append(stack, depExFalse)
+		case "NOT":
+			a, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			switch a {
+			case depExTrue:
+				stack = append(stack, depExFalse)
+			case depExFalse:
+				stack = append(stack, depExTrue)
+			default:
+				stack = append(stack, &depExNode{op: op, args: []*depExNode{a}})
+			}
+		case "AND", "OR":
+			b, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			a, err := pop()
+			if err != nil {
+				return nil, err
+			}
+			// The neutral operand is dropped, the absorbing one wins.
+			neutral, absorbing := depExTrue, depExFalse
+			if op.OpCode == "OR" {
+				neutral, absorbing = depExFalse, depExTrue
+			}
+			switch {
+			case a == absorbing || b == absorbing:
+				stack = append(stack, absorbing)
+			case a == neutral:
+				stack = append(stack, b)
+			case b == neutral:
+				stack = append(stack, a)
+			default:
+				stack = append(stack, &depExNode{op: op, args: []*depExNode{a, b}})
+			}
+		case "END":
+			if len(stack) != 1 {
+				return nil, fmt.Errorf("invalid DEPEX, %d values left at END", len(stack))
+			}
+			return append(stack[0].postfix(prefix), op), nil
+		default:
+			return nil, fmt.Errorf("invalid DEPEX opcode %q", op.OpCode)
+		}
+	}
+	return nil, fmt.Errorf("invalid DEPEX, no END")
+}
+
+// SetDepEx replaces the dependency expression of a depex section and
+// regenerates its header.
+func (s *Section) SetDepEx(ops []DepExOp) error {
+	switch s.Header.Type {
+	case SectionTypeDXEDepEx, SectionTypePEIDepEx, SectionMMDepEx:
+	default:
+		return fmt.Errorf("%v is not a DEPEX section", s.Header.Type)
+	}
+	buf, err := EncodeDepEx(ops)
+	if err != nil {
+		return err
+	}
+	s.buf = buf
+	s.DepEx = ops
+	return s.GenSecHeader()
+}
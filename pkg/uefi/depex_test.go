@@ -0,0 +1,117 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var (
+	depExA = uuid.MustParse("665E3FF6-46CC-11D4-9A38-0090273FC14D")
+	depExB = uuid.MustParse("26BACCB1-6F42-11D4-BCE7-0080C73C8881")
+	depExC = uuid.MustParse("1DA97072-BDDC-4B30-99F1-72A0B56FFF2A")
+)
+
+// depEx parses a dependency expression written as space separated opcodes,
+// where A, B and C push the GUIDs above and BEFORE and AFTER take A.
+func depEx(s string) []DepExOp {
+	guids := map[string]*uuid.UUID{"A": depExA, "B": depExB, "C": depExC}
+	var ops []DepExOp
+	for _, w := range strings.Fields(s) {
+		switch w {
+		case "A", "B", "C":
+			ops = append(ops, DepExOp{OpCode: "PUSH", GUID: guids[w]})
+		case "BEFORE", "AFTER":
+			ops = append(ops, DepExOp{OpCode: DepExOpCode(w), GUID: depExA})
+		default:
+			ops = append(ops, DepExOp{OpCode: DepExOpCode(w)})
+		}
+	}
+	return ops
+}
+
+func TestEncodeDepEx(t *testing.T) {
+	for _, s := range []string{"END", "TRUE END", "A B AND C NOT OR END", "SOR A END", "BEFORE END"} {
+		buf, err := EncodeDepEx(depEx(s))
+		if err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+		ops, err := parseDepEx(buf)
+		if err != nil {
+			t.Fatalf("%v: %v", s, err)
+		}
+		if !reflect.DeepEqual(ops, depEx(s)) {
+			t.Errorf("%v: round trip gave %v", s, ops)
+		}
+	}
+	if buf, _ := EncodeDepEx(depEx("TRUE END")); !bytes.Equal(buf, []byte{0x06, 0x08}) {
+		t.Errorf("unexpected encoding % x", buf)
+	}
+	for _, s := range []string{"TRUE", "END TRUE END", "BOGUS END"} {
+		if _, err := EncodeDepEx(depEx(s)); err == nil {
+			t.Errorf("%v: expected an error", s)
+		}
+	}
+}
+
+func TestDepExWithout(t *testing.T) {
+	var tests = []struct {
+		in, out string
+	}{
+		{"A END", "TRUE END"},
+		{"A B AND END", "B END"},
+		{"B A AND END", "B END"},
+		{"A B OR END", "TRUE END"},
+		{"A NOT END", "FALSE END"},
+		{"A B AND C AND END", "B C AND END"},
+		{"B C AND A OR END", "TRUE END"},
+		{"B C OR END", "B C OR END"},
+		{"SOR A B AND END", "SOR B END"},
+		{"BEFORE END", "TRUE END"},
+		{"TRUE END", "TRUE END"},
+	}
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			ops, err := DepExWithout(depEx(test.in), *depExA)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ops, depEx(test.out)) {
+				t.Errorf("expected %v, got %v", depEx(test.out), ops)
+			}
+		})
+	}
+	for _, s := range []string{"AND END", "A B END", "A"} {
+		if _, err := DepExWithout(depEx(s), *depExA); err == nil {
+			t.Errorf("%v: expected an error", s)
+		}
+	}
+}
+
+func TestSetDepEx(t *testing.T) {
+	s := &Section{}
+	s.Header.Type = SectionTypeDXEDepEx
+	if err := s.SetDepEx(depEx("B END")); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := NewSection(s.Buf(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed.DepEx, depEx("B END")) {
+		t.Errorf("unexpected DEPEX %v", parsed.DepEx)
+	}
+
+	raw := &Section{}
+	raw.Header.Type = SectionTypeRaw
+	if err := raw.SetDepEx(depEx("TRUE END")); err == nil {
+		t.Error("expected an error for a raw section")
+	}
+}
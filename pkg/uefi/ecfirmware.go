@@ -0,0 +1,88 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Laptops often keep the firmware of their embedded controller inside the
+// BIOS region, in a raw file or section or in the padding between the FVs,
+// rather than in an EC region of the flash descriptor. The BIOS loads it into
+// the EC on updates. It is found by the signatures the EC boot code looks
+// for, which is a heuristic: blobs of other vendors are not found.
+
+// ecSignature describes how the firmware of an EC vendor starts.
+type ecSignature struct {
+	vendor string
+	match  func(buf []byte) bool
+}
+
+// hasAligned returns whether buf holds sig at an offset aligned to align,
+// below limit.
+func hasAligned(buf []byte, sig []byte, align, limit int) bool {
+	for i := 0; i < limit && i+len(sig) <= len(buf); i += align {
+		if bytes.Equal(buf[i:i+len(sig)], sig) {
+			return true
+		}
+	}
+	return false
+}
+
+var ecSignatures = []ecSignature{
+	// The ITE eFlash signature, six 0xA5 bytes followed by the flash
+	// settings, is on a 16 byte boundary in the first 256 bytes.
+	{"ITE", func(buf []byte) bool {
+		return hasAligned(buf, bytes.Repeat([]byte{0xa5}, 6), 16, 0x100)
+	}},
+	// The firmware header of Microchip MEC controllers starts with PHCM, it
+	// is on a 256 byte boundary pointed to by the tag at the start.
+	{"Microchip", func(buf []byte) bool {
+		return hasAligned(buf, []byte("PHCM"), 0x100, 0x1000)
+	}},
+	// The firmware header of Nuvoton NPCX controllers starts with its
+	// anchor.
+	{"Nuvoton", func(buf []byte) bool {
+		return len(buf) >= 4 && binary.LittleEndian.Uint32(buf) == 0x2A3B4D5E
+	}},
+}
+
+// ECFirmwareAlign is the alignment of EC firmware in the BIOS padding.
+const ECFirmwareAlign = 0x1000
+
+// ECFirmware is EC firmware found in the BIOS region.
+type ECFirmware struct {
+	Vendor string
+	// Offset of the firmware in the data of its node, the firmware extends
+	// to the end of the data.
+	Offset uint64
+}
+
+// IdentifyECFirmware returns the EC firmware starting at the start of buf, if
+// it has a known signature.
+func IdentifyECFirmware(buf []byte) *ECFirmware {
+	for _, s := range ecSignatures {
+		if s.match(buf) {
+			return &ECFirmware{Vendor: s.vendor}
+		}
+	}
+	return nil
+}
+
+// FindECFirmware returns the first EC firmware of buf, which starts at an
+// offset aligned to ECFirmwareAlign.
+func FindECFirmware(buf []byte) *ECFirmware {
+	for i := 0; i < len(buf); i += ECFirmwareAlign {
+		if IsErased(buf[i:i+1], Attributes.ErasePolarity) {
+			continue
+		}
+		if ec := IdentifyECFirmware(buf[i:]); ec != nil {
+			ec.Offset = uint64(i)
+			return ec
+		}
+	}
+	return nil
+}
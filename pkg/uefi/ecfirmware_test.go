@@ -0,0 +1,65 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"testing"
+)
+
+// makeITEFirmware returns ITE EC firmware of size bytes, with the eFlash
+// signature at offset 0x40.
+func makeITEFirmware(size int) []byte {
+	buf := bytes.Repeat([]byte{0x12}, size)
+	copy(buf, []byte{0x02, 0x00, 0x80})
+	copy(buf[0x40:], []byte{0xa5, 0xa5, 0xa5, 0xa5, 0xa5, 0xa5, 0x85, 0x12, 0x5a, 0x5a, 0xaa, 0x00, 0x55, 0x55})
+	return buf
+}
+
+func TestIdentifyECFirmware(t *testing.T) {
+	mec := make([]byte, 0x400)
+	copy(mec[0x100:], "PHCM")
+	var tests = []struct {
+		name   string
+		buf    []byte
+		vendor string
+	}{
+		{"ITE", makeITEFirmware(0x400), "ITE"},
+		{"Microchip", mec, "Microchip"},
+		{"Nuvoton", []byte{0x5e, 0x4d, 0x3b, 0x2a, 0, 0, 0, 0}, "Nuvoton"},
+		{"ITEUnaligned", append([]byte{0}, makeITEFirmware(0x400)...), ""},
+		{"sampleFV", sampleFV, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ec := IdentifyECFirmware(test.buf)
+			if test.vendor == "" {
+				if ec != nil {
+					t.Errorf("expected no EC firmware, got %+v", ec)
+				}
+				return
+			}
+			if ec == nil || ec.Vendor != test.vendor {
+				t.Errorf("expected %v EC firmware, got %+v", test.vendor, ec)
+			}
+		})
+	}
+}
+
+func TestBIOSPaddingECFirmware(t *testing.T) {
+	padding := bytes.Repeat([]byte{0xff}, 0x3000)
+	copy(padding[0x1000:], makeITEFirmware(0x800))
+	br, err := NewBIOSRegion(append(append([]byte{}, sampleFV...), padding...), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(br.Elements) != 2 {
+		t.Fatalf("expected an FV and padding, got %d elements", len(br.Elements))
+	}
+	bp, ok := br.Elements[1].Value.(*BIOSPadding)
+	if !ok || bp.EC == nil || bp.EC.Vendor != "ITE" || bp.EC.Offset != 0x1000 {
+		t.Errorf("expected ITE EC firmware at offset 0x1000 of the padding, got %+v", br.Elements[1].Value)
+	}
+}
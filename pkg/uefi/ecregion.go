@@ -0,0 +1,65 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ECRegion represents the EC Region in the firmware.
+type ECRegion struct {
+	// holds the raw data
+	buf []byte
+	//Metadata for extraction and recovery
+	ExtractPath string
+	Location
+	// This is a pointer to the Region struct laid out in the ifd
+	Position *Region
+}
+
+// NewECRegion parses a sequence of bytes and returns an ECRegion
+// object, if a valid one is passed, or an error. It also points to the
+// Region struct uncovered in the ifd.
+func NewECRegion(buf []byte, r *Region) (*ECRegion, error) {
+	ecr := ECRegion{buf: buf, Position: r}
+	return &ecr, nil
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (ec *ECRegion) Buf() []byte {
+	return ec.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (ec *ECRegion) SetBuf(buf []byte) {
+	ec.buf = buf
+}
+
+// Apply calls the visitor on the ECRegion.
+func (ec *ECRegion) Apply(v Visitor) error {
+	return v.Visit(ec)
+}
+
+// ApplyChildren calls the visitor on each child node of ECRegion.
+func (ec *ECRegion) ApplyChildren(v Visitor) error {
+	return nil
+}
+
+// Validate Region
+func (ec *ECRegion) Validate() []error {
+	// TODO: Add more verification if needed.
+	errs := make([]error, 0)
+	if ec.Position == nil {
+		errs = append(errs, errors.New("ECRegion position is nil"))
+		return errs
+	}
+	if !ec.Position.Valid() {
+		errs = append(errs, fmt.Errorf("ECRegion is not valid, region was %v", *ec.Position))
+	}
+	return errs
+}
@@ -7,7 +7,11 @@ package uefi
 import (
 	"bytes"
 	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
+	"unsafe"
 
 	"github.com/linuxboot/fiano/pkg/uuid"
 )
@@ -102,10 +106,51 @@ func (f FVFileType) String() string {
 	return "UNKNOWN"
 }
 
+// fileTypeAliases are short names accepted by ParseFVFileType in addition to
+// the names without the EFI_FV_FILETYPE_ prefix.
+var fileTypeAliases = map[string]FVFileType{
+	"SEC":              FVFileTypeSECCore,
+	"PEI":              FVFileTypePEICore,
+	"DXE":              FVFileTypeDXECore,
+	"SMM":              FVFileTypeSMM,
+	"SMM_CORE":         FVFileTypeSMMCore,
+	"COMBINED_SMM_DXE": FVFileTypeCombinedSMMDXE,
+	"FV":               FVFileTypeVolumeImage,
+	"PAD":              FVFileTypePad,
+	"FFS_PAD":          FVFileTypePad,
+}
+
+// ParseFVFileType parses a file type given by its name, with or without the
+// EFI_FV_FILETYPE_ prefix, a short alias such as SMM or SEC, or its number.
+// Names are not case sensitive.
+func ParseFVFileType(s string) (FVFileType, error) {
+	name := strings.TrimPrefix(strings.ToUpper(s), "EFI_FV_FILETYPE_")
+	if t, ok := fileTypeAliases[name]; ok {
+		return t, nil
+	}
+	for t, n := range fileTypeNames {
+		if strings.TrimPrefix(n, "EFI_FV_FILETYPE_") == name {
+			return t, nil
+		}
+	}
+	if n, err := strconv.ParseUint(s, 0, 8); err == nil {
+		return FVFileType(n), nil
+	}
+	return 0, fmt.Errorf("unknown file type %q", s)
+}
+
 // Stock GUIDS
 var (
 	ZeroGUID = uuid.MustParse("00000000-0000-0000-0000-000000000000")
 	FFGUID   = uuid.MustParse("FFFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF")
+	// VTFGUID is the GUID of the Volume Top File. The VTF contains the reset
+	// vector and must end exactly at the top of the FV, which in turn must be
+	// at the top of the flash (4GB).
+	VTFGUID = uuid.MustParse("1BA0062E-C779-4582-8566-336AE8F78F09")
+	// PEIAprioriGUID and DXEAprioriGUID are the GUIDs of the apriori files,
+	// whose raw section lists the GUIDs of the modules to dispatch first.
+	PEIAprioriGUID = uuid.MustParse("1B45CC0A-156A-428A-AF62-49864DA0E6E6")
+	DXEAprioriGUID = uuid.MustParse("FC510EE7-FFDC-11D4-BD41-0080C73C8881")
 )
 
 // FileAlignments specifies the correct alignments based on the field in the file header.
@@ -136,6 +181,9 @@ const (
 	FileHeaderExtMinLength = 0x20
 	// EmptyBodyChecksum is the value placed in the File IntegrityCheck field if the body checksum bit isn't set.
 	EmptyBodyChecksum uint8 = 0xAA
+	// FileTailLength is the length of the tail of FFS1 and FFS2 files with
+	// the tail present attribute.
+	FileTailLength = 2
 )
 
 // IntegrityCheck holds the two 8 bit checksums for the file header and body separately.
@@ -144,6 +192,41 @@ type IntegrityCheck struct {
 	File   uint8
 }
 
+// File state bits, EFI_FILE_STATE. They are set by flipping them away from
+// the erase polarity, the highest one flipped is the current state.
+const (
+	FileStateHeaderConstruction uint8 = 0x01
+	FileStateHeaderValid        uint8 = 0x02
+	FileStateDataValid          uint8 = 0x04
+	FileStateMarkedForUpdate    uint8 = 0x08
+	FileStateDeleted            uint8 = 0x10
+	FileStateHeaderInvalid      uint8 = 0x20
+)
+
+var fileStateNames = map[uint8]string{
+	FileStateHeaderConstruction: "EFI_FILE_HEADER_CONSTRUCTION",
+	FileStateHeaderValid:        "EFI_FILE_HEADER_VALID",
+	FileStateDataValid:          "EFI_FILE_DATA_VALID",
+	FileStateMarkedForUpdate:    "EFI_FILE_MARKED_FOR_UPDATE",
+	FileStateDeleted:            "EFI_FILE_DELETED",
+	FileStateHeaderInvalid:      "EFI_FILE_HEADER_INVALID",
+}
+
+// FileStateName returns the name of the current state of the state byte,
+// relative to the erase polarity.
+func FileStateName(state, polarity uint8) string {
+	s := state ^ polarity
+	for bit := FileStateHeaderInvalid; bit != 0; bit >>= 1 {
+		if s&bit != 0 {
+			if s >= bit<<1 {
+				break
+			}
+			return fileStateNames[bit]
+		}
+	}
+	return fmt.Sprintf("UNKNOWN (%#02x)", state)
+}
+
 type fileAttr uint8
 
 // FileHeader represents an EFI File header.
@@ -182,19 +265,140 @@ func (a fileAttr) hasChecksum() bool {
 	return a&0x40 != 0
 }
 
+// File attribute bits, UEFI PI spec volume 3.2.3.
+const (
+	fileAttrLargeFile      fileAttr = 0x01
+	fileAttrDataAlignment2 fileAttr = 0x02
+	fileAttrFixed          fileAttr = 0x04
+	fileAttrDataAlignment  fileAttr = 0x38
+	fileAttrChecksum       fileAttr = 0x40
+)
+
+// fileAttrJSON is the JSON form of the file attributes. LARGE_FILE is set
+// by Assemble from the size of the file, edits to it have no effect. In FFS1
+// and FFS2 volumes the bit is FFS_ATTRIB_TAIL_PRESENT instead, which is kept.
+// Bits without a name are kept in Reserved.
+type fileAttrJSON struct {
+	LargeFile bool   `json:"LARGE_FILE"`
+	Fixed     bool   `json:"FIXED"`
+	Checksum  bool   `json:"CHECKSUM"`
+	Alignment uint64 `json:"ALIGNMENT"`
+	Reserved  uint8  `json:",omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a fileAttr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(fileAttrJSON{
+		LargeFile: a&fileAttrLargeFile != 0,
+		Fixed:     a&fileAttrFixed != 0,
+		Checksum:  a.hasChecksum(),
+		Alignment: a.GetAlignment(),
+		Reserved:  uint8(a &^ (fileAttrLargeFile | fileAttrDataAlignment2 | fileAttrFixed | fileAttrDataAlignment | fileAttrChecksum)),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The attributes may also be given
+// as a number, as in JSON written by older versions.
+func (a *fileAttr) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] != '{' {
+		var n uint8
+		if err := json.Unmarshal(b, &n); err != nil {
+			return err
+		}
+		*a = fileAttr(n)
+		return nil
+	}
+	j := fileAttrJSON{Alignment: 1}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	align := -1
+	for i, v := range fileAlignments {
+		if v == j.Alignment {
+			align = i
+		}
+	}
+	if align < 0 {
+		return fmt.Errorf("file alignment %#x is not one of %v", j.Alignment, fileAlignments)
+	}
+	attr := fileAttr(j.Reserved) | fileAttr(align&7)<<3 | fileAttr(align>>3)<<1
+	if j.LargeFile {
+		attr |= fileAttrLargeFile
+	}
+	if j.Fixed {
+		attr |= fileAttrFixed
+	}
+	if j.Checksum {
+		attr |= fileAttrChecksum
+	}
+	*a = attr
+	return nil
+}
+
+// SetAlignment sets the data alignment of the file in its attributes, it must
+// be one of the alignments of the PI spec.
+func (f *File) SetAlignment(align uint64) error {
+	for i, v := range fileAlignments {
+		if v == align {
+			a := &f.Header.Attributes
+			*a &^= fileAttrDataAlignment | fileAttrDataAlignment2
+			*a |= fileAttr(i&7)<<3 | fileAttr(i>>3)<<1
+			return nil
+		}
+	}
+	return fmt.Errorf("file alignment %#x is not one of %v", align, fileAlignments)
+}
+
+// IsVTF returns whether the file is the Volume Top File.
+func (f *File) IsVTF() bool {
+	return f.Header.UUID == *VTFGUID
+}
+
+// legacyAttributes returns whether the file is in an FFS1 or FFS2 volume,
+// whose files have no large file attribute. The bit marks a tail instead.
+func (f *File) legacyAttributes() bool {
+	return f.FFSVersion == 1 || f.FFSVersion == 2
+}
+
+// IsLarge returns whether the file has the large file attribute and an
+// extended header. Only FFS3 files can be large.
+func (f *File) IsLarge() bool {
+	return !f.legacyAttributes() && f.Header.Attributes.isLarge()
+}
+
+// HasTail returns whether the file has the tail of the Framework spec, a
+// copy of the inverted IntegrityCheck following the data. Only FFS1 and FFS2
+// files have tails.
+func (f *File) HasTail() bool {
+	return f.legacyAttributes() && f.Header.Attributes&fileAttrLargeFile != 0
+}
+
+// tailLen returns the length of the tail of the file.
+func (f *File) tailLen() uint64 {
+	if f.HasTail() {
+		return FileTailLength
+	}
+	return 0
+}
+
 // HeaderLen is a helper function to return the length of the file header
 // depending on the file size
 func (f *File) HeaderLen() uint64 {
-	if f.Header.Attributes.isLarge() {
+	if f.IsLarge() {
 		return FileHeaderExtMinLength
 	}
 	return FileHeaderMinLength
 }
 
+// Data returns the data of the file, between its header and its tail.
+func (f *File) Data() []byte {
+	return f.buf[f.DataOffset:f.dataEnd()]
+}
+
 func (f *File) checksumHeader() uint8 {
 	fh := f.Header
 	headerSize := FileHeaderMinLength
-	if fh.Attributes.isLarge() {
+	if f.IsLarge() {
 		headerSize = FileHeaderExtMinLength
 	}
 	// Sum over header without State and IntegrityCheck.File.
@@ -226,6 +430,32 @@ type File struct {
 	buf         []byte
 	ExtractPath string
 	DataOffset  uint64
+	Location
+
+	// ParseError is set if the sections could not be parsed, the file is
+	// kept as it is.
+	ParseError string `json:",omitempty"`
+	// PadData is set for pad files which hold data other than erase
+	// polarity bytes. Some vendors hide data there, it is kept as it is.
+	PadData bool `json:",omitempty"`
+	// State is the name of the state of the file in its FV, e.g.
+	// EFI_FILE_DELETED. Files are written as EFI_FILE_DATA_VALID if it is
+	// not set.
+	State string `json:",omitempty"`
+	// Source is the EDK2 module the file was built from, if known, see
+	// SetModuleSources.
+	Source *ModuleSource `json:",omitempty"`
+	// NVarStore holds the entries of the AMI NVRAM and StdDefaults files.
+	NVarStore *NVarStore `json:",omitempty"`
+	// ACPI holds the ACPI tables of raw files, see ParseACPITables.
+	ACPI []*ACPITable `json:",omitempty"`
+	// EC is the EC firmware of a raw file, see IdentifyECFirmware.
+	EC *ECFirmware `json:",omitempty"`
+	// FFSVersion is the FFS generation of the FV holding the file, see
+	// FirmwareVolume.FFSVersion. Files of FFS1 and FFS2 volumes may have a
+	// tail instead of the large file attribute, files of other volumes are
+	// treated as FFS3 files.
+	FFSVersion uint8 `json:",omitempty"`
 }
 
 // Buf returns the buffer.
@@ -257,12 +487,17 @@ func (f *File) ApplyChildren(v Visitor) error {
 
 // SetSize sets the size into the File struct.
 // If resizeFile is true, if the file is too large the file will be enlarged to make space
-// for the ExtendedHeader
+// for the ExtendedHeader. FFS1 and FFS2 files have no extended header, their
+// attributes are kept.
 func (f *File) SetSize(size uint64, resizeFile bool) {
 	fh := &f.Header
 	// See if we need the extended size
 	// Check if size > 3 bytes size field
 	fh.ExtendedSize = size
+	if f.legacyAttributes() {
+		fh.Size = Write3Size(fh.ExtendedSize)
+		return
+	}
 	fh.Attributes.setLarge(false)
 	if fh.ExtendedSize > 0xFFFFFF {
 		// Can't fit, need extended header
@@ -277,11 +512,40 @@ func (f *File) SetSize(size uint64, resizeFile bool) {
 	fh.Size = Write3Size(fh.ExtendedSize)
 }
 
-// ChecksumAndAssemble takes in the fileData and assembles the file binary
+// IsDeleted returns whether the file is deleted or has an invalid header,
+// firmware ignores such files.
+func (f *File) IsDeleted() bool {
+	return f.State == fileStateNames[FileStateDeleted] || f.State == fileStateNames[FileStateHeaderInvalid]
+}
+
+// StateByte returns the state byte for the State of the file. The state
+// byte the file was parsed with is kept if it matches, otherwise all the
+// bits up to the state are set.
+func (f *File) StateByte(polarity uint8) uint8 {
+	if f.State == "" {
+		return 0x07 ^ polarity
+	}
+	if FileStateName(f.Header.State, polarity) == f.State {
+		return f.Header.State
+	}
+	for bit := FileStateHeaderInvalid; bit != 0; bit >>= 1 {
+		if fileStateNames[bit] == f.State {
+			return (bit<<1 - 1) ^ polarity
+		}
+	}
+	return 0x07 ^ polarity
+}
+
+// ChecksumAndAssemble takes in the fileData and assembles the file binary.
+// The tail of files with the tail present attribute is appended to the data.
 func (f *File) ChecksumAndAssemble(fileData []byte) error {
 	// Checksum the header and body, then write out the header.
 	// To checksum the header we write the temporary header to the file buffer first.
 	fh := &f.Header
+	if f.legacyAttributes() && fh.ExtendedSize > 0xFFFFFF {
+		return fmt.Errorf("file %v of %#x bytes is too large for an FFS%d volume, only FFS3 files may exceed %#x bytes",
+			fh.UUID, fh.ExtendedSize, f.FFSVersion, 0xFFFFFF)
+	}
 
 	header := new(bytes.Buffer)
 	err := binary.Write(header, binary.LittleEndian, fh)
@@ -305,7 +569,7 @@ func (f *File) ChecksumAndAssemble(fileData []byte) error {
 	// Write out the updated header to the buffer with the new checksums.
 	// Write the extended header only if the large attribute flag is set.
 	header = new(bytes.Buffer)
-	if fh.Attributes.isLarge() {
+	if f.IsLarge() {
 		err = binary.Write(header, binary.LittleEndian, fh)
 	} else {
 		err = binary.Write(header, binary.LittleEndian, fh.FileHeader)
@@ -316,6 +580,10 @@ func (f *File) ChecksumAndAssemble(fileData []byte) error {
 	f.buf = header.Bytes()
 
 	f.buf = append(f.buf, fileData...)
+	if f.HasTail() {
+		// The tail is the inverted IntegrityCheck.
+		f.buf = append(f.buf, ^fh.Checksum.Header, ^fh.Checksum.File)
+	}
 	return nil
 }
 
@@ -337,7 +605,7 @@ func (f *File) Validate() []error {
 				fh.UUID, buflen))
 			return errs
 		}
-		if !fh.Attributes.isLarge() {
+		if !f.IsLarge() {
 			errs = append(errs, fmt.Errorf("file %v using extended header, but large attribute is not set",
 				fh.UUID))
 			return errs
@@ -352,6 +620,11 @@ func (f *File) Validate() []error {
 			fh.UUID, fh.ExtendedSize, buflen))
 		return errs
 	}
+	if f.IsLarge() && buflen < FileHeaderExtMinLength {
+		errs = append(errs, fmt.Errorf("file %v has the large attribute set, but is only %#x bytes long",
+			fh.UUID, buflen))
+		return errs
+	}
 
 	// Header Checksums
 	if sum := f.checksumHeader(); sum != 0 {
@@ -364,16 +637,19 @@ func (f *File) Validate() []error {
 		errs = append(errs, fmt.Errorf("file %v body checksum failure! Attribute was not set, but sum was %v instead of %v",
 			fh.UUID, fh.Checksum.File, EmptyBodyChecksum))
 	} else if fh.Attributes.hasChecksum() {
-		headerSize := FileHeaderMinLength
-		if fh.Attributes.isLarge() {
-			headerSize = FileHeaderExtMinLength
-		}
-		if sum := Checksum8(f.buf[headerSize:]); sum != 0 {
+		// The data and its checksum sum up to zero.
+		if sum := Checksum8(f.buf[f.HeaderLen():buflen-f.tailLen()]) + fh.Checksum.File; sum != 0 {
 			errs = append(errs, fmt.Errorf("file %v body checksum failure! sum was %v",
 				fh.UUID, sum))
 		}
 	}
+	if err := f.checkTail(); err != nil {
+		errs = append(errs, err)
+	}
 
+	if f.ParseError != "" {
+		errs = append(errs, fmt.Errorf("file %v: %v", fh.UUID, f.ParseError))
+	}
 	for _, s := range f.Sections {
 		errs = append(errs, s.Validate()...)
 	}
@@ -428,11 +704,73 @@ func CreatePadFile(size uint64) (*File, error) {
 	return &f, nil
 }
 
+// checkFile reports anomalies in the state and checksums of a parsed file.
+// The erase polarity is that of its FV.
+func (f *File) checkFile(polarity uint8) error {
+	fh := &f.Header
+	// The state bits are set by clearing them, the highest one set is the
+	// current state. Files in FVs are at least constructed and valid,
+	// EFI_FILE_HEADER_CONSTRUCTION and EFI_FILE_HEADER_VALID.
+	if state := fh.State ^ polarity; state&0x03 != 0x03 || state&0xC0 != 0 {
+		if err := anomaly("file %v has an invalid state %#02x", fh.UUID, fh.State); err != nil {
+			return err
+		}
+	}
+	if sum := f.checksumHeader(); sum != 0 {
+		if err := anomaly("file %v header checksum failure, sum was %#02x", fh.UUID, sum); err != nil {
+			return err
+		}
+	}
+	if !fh.Attributes.hasChecksum() {
+		if fh.Checksum.File != EmptyBodyChecksum {
+			return anomaly("file %v body checksum is %#02x, but the checksum attribute is not set",
+				fh.UUID, fh.Checksum.File)
+		}
+	} else if sum := Checksum8(f.Data()) + fh.Checksum.File; sum != 0 {
+		return anomaly("file %v body checksum failure, sum was %#02x", fh.UUID, sum)
+	}
+	if err := f.checkTail(); err != nil {
+		return anomaly("%v", err)
+	}
+	return nil
+}
+
+// dataEnd returns the end of the data of the file, before its tail.
+func (f *File) dataEnd() uint64 {
+	end := uint64(len(f.buf)) - f.tailLen()
+	if end < f.DataOffset || end > uint64(len(f.buf)) {
+		return uint64(len(f.buf))
+	}
+	return end
+}
+
+// checkTail returns an error if the file has a tail which is not the
+// inverted IntegrityCheck.
+func (f *File) checkTail() error {
+	if !f.HasTail() || uint64(len(f.buf)) < f.DataOffset+FileTailLength {
+		return nil
+	}
+	fh := &f.Header
+	tail := f.buf[len(f.buf)-FileTailLength:]
+	if tail[0] != ^fh.Checksum.Header || tail[1] != ^fh.Checksum.File {
+		return fmt.Errorf("file %v tail is %#02x %#02x, expected the inverted checksums %#02x %#02x",
+			fh.UUID, tail[0], tail[1], ^fh.Checksum.Header, ^fh.Checksum.File)
+	}
+	return nil
+}
+
 // NewFile parses a sequence of bytes and returns a File
 // object, if a valid one is passed, or an error. If no error is returned and the File
 // pointer is nil, it means we've reached the volume free space at the end of the FV.
+// The file is parsed as an FFS3 file, see NewFFSFile.
 func NewFile(buf []byte) (*File, error) {
-	f := File{}
+	return NewFFSFile(buf, 0)
+}
+
+// NewFFSFile parses a file like NewFile, with the attributes of the FFS
+// generation ffsVersion of its FV, see FirmwareVolume.FFSVersion.
+func NewFFSFile(buf []byte, ffsVersion uint8) (*File, error) {
+	f := File{FFSVersion: ffsVersion}
 	f.DataOffset = FileHeaderMinLength
 	// Read in standard header.
 	r := bytes.NewReader(buf)
@@ -442,6 +780,9 @@ func NewFile(buf []byte) (*File, error) {
 
 	// Map type to string.
 	f.Type = f.Header.Type.String()
+	if src, ok := moduleSources[f.Header.UUID]; ok {
+		f.Source = &src
+	}
 
 	// TODO: Check Attribute flag as well. How important is the attribute flag? we already
 	// have FFFFFF in the size
@@ -455,6 +796,10 @@ func NewFile(buf []byte) (*File, error) {
 			// Note: this is not a pad file. Pad files also have valid headers.
 			return nil, nil
 		}
+		if f.legacyAttributes() {
+			return nil, fmt.Errorf("file %v has an extended header, which files in FFS%d volumes do not have",
+				f.Header.UUID, ffsVersion)
+		}
 		f.DataOffset = FileHeaderExtMinLength
 	} else {
 		// Copy small size into big for easier handling.
@@ -462,6 +807,10 @@ func NewFile(buf []byte) (*File, error) {
 		f.Header.ExtendedSize = Read3Size(f.Header.Size)
 	}
 
+	if f.Header.ExtendedSize < f.DataOffset+f.tailLen() {
+		return nil, fmt.Errorf("File size too small! File with GUID: %v has length %v, less than its header",
+			f.Header.UUID, f.Header.ExtendedSize)
+	}
 	if buflen := len(buf); f.Header.ExtendedSize > uint64(buflen) {
 		return nil, fmt.Errorf("File size too big! File with GUID: %v has length %v, but is only %v bytes big",
 			f.Header.UUID, f.Header.ExtendedSize, buflen)
@@ -469,14 +818,27 @@ func NewFile(buf []byte) (*File, error) {
 	// Slice buffer to the correct size.
 	f.buf = buf[:f.Header.ExtendedSize]
 
+	// Raw files have no sections, their data may hold ACPI tables or EC
+	// firmware.
+	if f.Header.Type == FVFileTypeRaw {
+		f.ACPI = ParseACPITables(f.Data())
+		f.EC = IdentifyECFirmware(f.Data())
+	}
+
 	// Parse sections
 	if _, ok := SupportedFiles[f.Header.Type]; !ok {
 		return &f, nil
 	}
-	for i, offset := 0, f.DataOffset; offset < f.Header.ExtendedSize; i++ {
-		s, err := NewSection(f.buf[offset:], i)
+	for i, offset := 0, f.DataOffset; offset < f.dataEnd(); i++ {
+		s, err := NewSection(f.buf[offset:f.dataEnd()], i)
 		if err != nil {
-			return nil, fmt.Errorf("error parsing sections of file %v: %v", f.Header.UUID, err)
+			// The file is kept as it is.
+			f.Sections = nil
+			if err := anomaly("error parsing sections of file %v: %v", f.Header.UUID, err); err != nil {
+				return nil, err
+			}
+			f.ParseError = err.Error()
+			break
 		}
 		offset += uint64(s.Header.ExtendedSize)
 		// Align to 4 bytes for now. The PI Spec doesn't say what alignment it should be
@@ -484,6 +846,34 @@ func NewFile(buf []byte) (*File, error) {
 		offset = Align4(offset)
 		f.Sections = append(f.Sections, s)
 	}
+	if err := f.parseNVarStore(); err != nil {
+		if err := anomaly("error parsing NVAR store of file %v: %v", f.Header.UUID, err); err != nil {
+			return nil, err
+		}
+	}
 
 	return &f, nil
 }
+
+// parseNVarStore parses the NVAR entries of the AMI NVRAM and StdDefaults
+// files, in the file data or in its raw section.
+func (f *File) parseNVarStore() error {
+	if f.Header.UUID != *NVarStoreFileGUID && f.Header.UUID != *NVarDefaultsFileGUID {
+		return nil
+	}
+	data := f.Data()
+	for _, s := range f.Sections {
+		if s.Header.Type == SectionTypeRaw {
+			data = s.buf[unsafe.Sizeof(SectionHeader{}):]
+			if s.Header.Size == [3]uint8{0xFF, 0xFF, 0xFF} {
+				data = s.buf[unsafe.Sizeof(SectionExtHeader{}):]
+			}
+		}
+	}
+	if !IsNVarStore(data) {
+		return nil
+	}
+	var err error
+	f.NVarStore, err = NewNVarStore(data)
+	return err
+}
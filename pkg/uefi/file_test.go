@@ -5,6 +5,8 @@
 package uefi
 
 import (
+	"bytes"
+	"encoding/json"
 	"testing"
 )
 
@@ -16,6 +18,8 @@ var (
 		[]byte{8, EmptyBodyChecksum, byte(FVFileTypePad), 0, FileHeaderMinLength, 0x00, 0x00, 0xF8}...) // Empty pad file header with no data
 	goodFreeFormHeader = append(FFGUID[:],
 		[]byte{202, EmptyBodyChecksum, byte(FVFileTypeFreeForm), 0, FileHeaderMinLength, 0x00, 0x00, 0xF8}...) // Empty freeform file header with no data
+	tinyPadHeader = append(FFGUID[:],
+		[]byte{28, EmptyBodyChecksum, byte(FVFileTypePad), 0, 4, 0x00, 0x00, 0xF8}...) // Pad file smaller than its header
 )
 
 var (
@@ -78,6 +82,8 @@ func TestNewFile(t *testing.T) {
 		{"emptyPadFile", emptyPadFile, ""},
 		{"badFreeFormFile", badFreeFormFile, ""},
 		{"goodFreeFormFile", goodFreeFormFile, ""},
+		{"tinyPadFile", tinyPadHeader,
+			"File size too small! File with GUID: FFFFFFFF-FFFF-FFFF-FFFF-FFFFFFFFFFFF has length 4, less than its header"},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -90,3 +96,192 @@ func TestNewFile(t *testing.T) {
 		})
 	}
 }
+
+func TestParseFVFileType(t *testing.T) {
+	var tests = []struct {
+		s        string
+		fileType FVFileType
+		ok       bool
+	}{
+		{"EFI_FV_FILETYPE_DRIVER", FVFileTypeDriver, true},
+		{"driver", FVFileTypeDriver, true},
+		{"PEIM", FVFileTypePEIM, true},
+		{"SMM", FVFileTypeSMM, true},
+		{"mm", FVFileTypeSMM, true},
+		{"pad", FVFileTypePad, true},
+		{"0x0b", FVFileTypeVolumeImage, true},
+		{"bogus", 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			ft, err := ParseFVFileType(test.s)
+			if test.ok != (err == nil) {
+				t.Fatalf("expected ok %v, got err %v", test.ok, err)
+			}
+			if ft != test.fileType {
+				t.Errorf("expected %v, got %v", test.fileType, ft)
+			}
+		})
+	}
+}
+
+func TestFileStateName(t *testing.T) {
+	var tests = []struct {
+		state, polarity uint8
+		name            string
+	}{
+		{0xf8, 0xff, "EFI_FILE_DATA_VALID"},
+		{0x07, 0x00, "EFI_FILE_DATA_VALID"},
+		{0xf0, 0xff, "EFI_FILE_MARKED_FOR_UPDATE"},
+		{0xe0, 0xff, "EFI_FILE_DELETED"},
+		{0x1f, 0x00, "EFI_FILE_DELETED"},
+		{0xc0, 0xff, "EFI_FILE_HEADER_INVALID"},
+		{0xfe, 0xff, "EFI_FILE_HEADER_CONSTRUCTION"},
+		{0xff, 0xff, "UNKNOWN (0xff)"},
+		{0x40, 0x00, "UNKNOWN (0x40)"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if name := FileStateName(test.state, test.polarity); name != test.name {
+				t.Errorf("expected %v, got %v", test.name, name)
+			}
+		})
+	}
+
+	// The state byte is kept if it matches, otherwise it is rebuilt.
+	f := File{State: "EFI_FILE_DELETED"}
+	f.Header.State = 0xe4
+	if b := f.StateByte(0xff); b != 0xe4 {
+		t.Errorf("expected the original state byte 0xe4, got %#x", b)
+	}
+	if !f.IsDeleted() {
+		t.Error("expected the file to be deleted")
+	}
+	f.State = "EFI_FILE_MARKED_FOR_UPDATE"
+	if b := f.StateByte(0xff); b != 0xf0 {
+		t.Errorf("expected 0xf0, got %#x", b)
+	}
+	f.State = ""
+	if b := f.StateByte(0xff); b != 0xf8 {
+		t.Errorf("expected 0xf8, got %#x", b)
+	}
+}
+
+func TestFileAttrJSON(t *testing.T) {
+	var tests = []struct {
+		name string
+		attr fileAttr
+		json string
+	}{
+		{"none", 0, `{"LARGE_FILE":false,"FIXED":false,"CHECKSUM":false,"ALIGNMENT":1}`},
+		{"checksum4K", 0x68, `{"LARGE_FILE":false,"FIXED":false,"CHECKSUM":true,"ALIGNMENT":4096}`},
+		{"large1M", 0x1b, `{"LARGE_FILE":true,"FIXED":false,"CHECKSUM":false,"ALIGNMENT":1048576}`},
+		{"reserved", 0x84, `{"LARGE_FILE":false,"FIXED":true,"CHECKSUM":false,"ALIGNMENT":1,"Reserved":128}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			b, err := json.Marshal(test.attr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != test.json {
+				t.Errorf("got %s, want %s", b, test.json)
+			}
+			var a fileAttr
+			if err := json.Unmarshal(b, &a); err != nil {
+				t.Fatal(err)
+			}
+			if a != test.attr {
+				t.Errorf("attributes changed from %#x to %#x", test.attr, a)
+			}
+		})
+	}
+
+	var a fileAttr
+	if err := json.Unmarshal([]byte(`64`), &a); err != nil || a != fileAttrChecksum {
+		t.Errorf("numeric attributes not accepted, got %#x: %v", a, err)
+	}
+	if err := json.Unmarshal([]byte(`{"ALIGNMENT":8}`), &a); err == nil {
+		t.Errorf("expected an error for an unsupported alignment")
+	}
+}
+
+func TestFileAttrChecksum(t *testing.T) {
+	f, err := NewFile(goodFreeFormFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte{}, f.Buf()[f.DataOffset:]...)
+	f.Header.Attributes |= fileAttrChecksum
+	if err := f.ChecksumAndAssemble(data); err != nil {
+		t.Fatal(err)
+	}
+	if errs := f.Validate(); len(errs) != 0 {
+		t.Errorf("file with data checksum does not validate: %v", errs)
+	}
+	if f.Header.Checksum.File == EmptyBodyChecksum {
+		t.Errorf("data checksum was not computed")
+	}
+}
+
+func TestFileTail(t *testing.T) {
+	f, err := NewFile(goodFreeFormFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := append([]byte{}, f.Data()...)
+
+	// In an FFS2 volume the large file bit marks a tail.
+	f.FFSVersion = 2
+	f.Header.Attributes |= fileAttrLargeFile | fileAttrChecksum
+	f.SetSize(uint64(len(goodFreeFormFile))+FileTailLength, true)
+	if err := f.ChecksumAndAssemble(data); err != nil {
+		t.Fatal(err)
+	}
+	buf := f.Buf()
+	if uint64(len(buf)) != f.Header.ExtendedSize || len(buf) != len(goodFreeFormFile)+FileTailLength {
+		t.Fatalf("file with tail is %#x bytes, expected %#x", len(buf), len(goodFreeFormFile)+FileTailLength)
+	}
+	if f.IsLarge() || f.HeaderLen() != FileHeaderMinLength {
+		t.Errorf("FFS2 file with a tail is treated as a large file")
+	}
+	if errs := f.Validate(); len(errs) != 0 {
+		t.Errorf("file with tail does not validate: %v", errs)
+	}
+
+	nf, err := NewFFSFile(buf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !nf.HasTail() || !bytes.Equal(nf.Data(), data) {
+		t.Errorf("tail is not separated from the data")
+	}
+	if len(nf.Sections) != 3 || nf.ParseError != "" {
+		t.Errorf("expected 3 sections before the tail, got %d (%v)", len(nf.Sections), nf.ParseError)
+	}
+	if err := nf.checkFile(0xFF); err != nil {
+		t.Errorf("file with tail reported anomalies: %v", err)
+	}
+
+	// The same file in an FFS3 volume is a large file without the extended
+	// header, which is an invalid file.
+	if f3, err := NewFFSFile(buf, 3); err == nil && len(f3.Validate()) == 0 {
+		t.Errorf("expected an FFS3 file with a bad large file header to be invalid")
+	}
+
+	bad := append([]byte{}, buf...)
+	bad[len(bad)-1] ^= 0xFF
+	nf, err = NewFFSFile(bad, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nf.checkTail() == nil {
+		t.Errorf("expected an error for a wrong tail")
+	}
+
+	// FFS2 files have no extended header.
+	f.SetSize(0x1000000, false)
+	if err := f.ChecksumAndAssemble(data); err == nil {
+		t.Errorf("expected an error for an FFS2 file of 16MiB")
+	}
+}
@@ -7,8 +7,11 @@ package uefi
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"strings"
 
 	"github.com/linuxboot/fiano/pkg/uuid"
 )
@@ -18,6 +21,9 @@ const (
 	FirmwareVolumeFixedHeaderSize  = 56
 	FirmwareVolumeMinSize          = FirmwareVolumeFixedHeaderSize + 8 // +8 for the null block that terminates the block list
 	FirmwareVolumeExtHeaderMinSize = 20
+
+	fvChecksumOffset = 50
+	fvReservedOffset = 54
 )
 
 // Valid FV GUIDs
@@ -29,28 +35,69 @@ var (
 	NVAR      = uuid.MustParse("cef5b9a3-476d-497f-9fdc-e98143e0422c")
 	EVSA2     = uuid.MustParse("00504624-8a59-4eeb-bd0f-6b36e96128e0")
 	AppleBoot = uuid.MustParse("04adeead-61ff-4d31-b6ba-64f8bf901f5a")
-	PFH1      = uuid.MustParse("16b45da2-7d70-4aea-a58d-760e9ecb841d")
-	PFH2      = uuid.MustParse("e360bdba-c3ce-46be-8f37-b231e5cb9f35")
+	// AppleBoot2 is used by newer Macs, it has the same layout as AppleBoot.
+	AppleBoot2 = uuid.MustParse("bd001b8c-6a71-487b-a14f-0c2a2dcf7a5d")
+	PFH1       = uuid.MustParse("16b45da2-7d70-4aea-a58d-760e9ecb841d")
+	PFH2       = uuid.MustParse("e360bdba-c3ce-46be-8f37-b231e5cb9f35")
 )
 
 // FVGUIDs holds common FV type names
 var FVGUIDs = map[uuid.UUID]string{
-	*FFS1:      "FFS1",
-	*FFS2:      "FFS2",
-	*FFS3:      "FFS3",
-	*EVSA:      "NVRAM_EVSA",
-	*NVAR:      "NVRAM_NVAR",
-	*EVSA2:     "NVRAM_EVSA2",
-	*AppleBoot: "APPLE_BOOT",
-	*PFH1:      "PFH1",
-	*PFH2:      "PFH2",
+	*FFS1:       "FFS1",
+	*FFS2:       "FFS2",
+	*FFS3:       "FFS3",
+	*EVSA:       "NVRAM_EVSA",
+	*NVAR:       "NVRAM_NVAR",
+	*EVSA2:      "NVRAM_EVSA2",
+	*AppleBoot:  "APPLE_BOOT",
+	*AppleBoot2: "APPLE_BOOT2",
+	*PFH1:       "PFH1",
+	*PFH2:       "PFH2",
 }
 
 // These are the FVs we actually try to parse beyond the header
-// We don't parse anything except FFS2 and FFS3
+// By default we don't parse anything except FFS2, FFS3 and the Apple boot
+// volumes (which use the FFS2 layout), use AllowFV and DenyFV to change that.
 var supportedFVs = map[uuid.UUID]bool{
-	*FFS2: true,
-	*FFS3: true,
+	*FFS2:       true,
+	*FFS3:       true,
+	*AppleBoot:  true,
+	*AppleBoot2: true,
+}
+
+// deniedFVs holds the FV types which are kept opaque, no matter what
+// supportedFVs says.
+var deniedFVs = map[uuid.UUID]bool{}
+
+// AllowFV forces the files of FVs with the given filesystem GUID to be parsed.
+// This is useful for vendor specific FVs which use the FFS2 file layout.
+func AllowFV(guid uuid.UUID) {
+	supportedFVs[guid] = true
+	delete(deniedFVs, guid)
+}
+
+// DenyFV keeps FVs with the given filesystem GUID opaque, even if they are
+// parsed by default. The FV is then handled as a single binary blob.
+func DenyFV(guid uuid.UUID) {
+	deniedFVs[guid] = true
+}
+
+// IsSupportedFV returns whether FVs with the given filesystem GUID are parsed
+// beyond the header.
+func IsSupportedFV(guid uuid.UUID) bool {
+	return supportedFVs[guid] && !deniedFVs[guid]
+}
+
+// ParseFVGUID parses either an FV filesystem GUID or one of the names in
+// FVGUIDs, such as "FFS2".
+func ParseFVGUID(s string) (*uuid.UUID, error) {
+	for g, name := range FVGUIDs {
+		if strings.EqualFold(s, name) {
+			guid := g
+			return &guid, nil
+		}
+	}
+	return uuid.Parse(s)
 }
 
 // Block describes number and size of the firmware volume blocks
@@ -62,11 +109,13 @@ type Block struct {
 // FirmwareVolumeFixedHeader contains the fixed fields of a firmware volume
 // header
 type FirmwareVolumeFixedHeader struct {
-	_               [16]uint8
+	// The zero vector is reserved by the spec, but Apple stores a CRC32 of
+	// the volume body and the used space in there.
+	ZeroVector      [16]uint8 `json:"-"`
 	FileSystemGUID  uuid.UUID
 	Length          uint64
 	Signature       uint32
-	Attributes      uint32 // UEFI PI spec volume 3.2.1 EFI_FIRMWARE_VOLUME_HEADER
+	Attributes      FVAttributes // UEFI PI spec volume 3.2.1 EFI_FIRMWARE_VOLUME_HEADER
 	HeaderLen       uint16
 	Checksum        uint16
 	ExtHeaderOffset uint16
@@ -82,6 +131,100 @@ type FirmwareVolumeExtHeader struct {
 	ExtHeaderSize uint32
 }
 
+// FVExtEntryType is the type of an extended header entry.
+type FVExtEntryType uint16
+
+// Extended header entry types, UEFI PI spec volume 3.2.1.
+const (
+	FVExtEntryTypeOEM      FVExtEntryType = 0x01
+	FVExtEntryTypeGUID     FVExtEntryType = 0x02
+	FVExtEntryTypeUsedSize FVExtEntryType = 0x03
+)
+
+// fvExtEntryHeaderSize is the size of the ExtEntrySize and ExtEntryType fields.
+const fvExtEntryHeaderSize = 4
+
+// FVExtEntry is an entry of the list following the extended header. Only the
+// fields of its type are set. Data holds the bytes following them, i.e. the
+// data of a GUID type entry or the whole body of an unknown type of entry.
+type FVExtEntry struct {
+	Type FVExtEntryType
+
+	// OEM type entry
+	TypeMask uint32      `json:",omitempty"`
+	Types    []uuid.UUID `json:",omitempty"`
+	// GUID type entry
+	FormatType *uuid.UUID `json:",omitempty"`
+	// Used size entry
+	UsedSize uint32 `json:",omitempty"`
+
+	Data []byte `json:",omitempty"`
+}
+
+// parseFVExtEntries parses the list of extended header entries.
+func parseFVExtEntries(buf []byte) ([]FVExtEntry, error) {
+	entries := []FVExtEntry{}
+	for len(buf) > 0 {
+		if len(buf) < fvExtEntryHeaderSize {
+			return nil, fmt.Errorf("%#x bytes left are too small for an extended header entry", len(buf))
+		}
+		size := binary.LittleEndian.Uint16(buf)
+		if size < fvExtEntryHeaderSize || int(size) > len(buf) {
+			return nil, fmt.Errorf("extended header entry of %#x bytes does not fit into %#x bytes", size, len(buf))
+		}
+		e := FVExtEntry{Type: FVExtEntryType(binary.LittleEndian.Uint16(buf[2:]))}
+		body := buf[fvExtEntryHeaderSize:size]
+		switch {
+		case e.Type == FVExtEntryTypeOEM && len(body) >= 4:
+			e.TypeMask = binary.LittleEndian.Uint32(body)
+			body = body[4:]
+			for len(body) >= 16 {
+				var g uuid.UUID
+				copy(g[:], body)
+				e.Types = append(e.Types, g)
+				body = body[16:]
+			}
+		case e.Type == FVExtEntryTypeGUID && len(body) >= 16:
+			e.FormatType = &uuid.UUID{}
+			copy(e.FormatType[:], body)
+			body = body[16:]
+		case e.Type == FVExtEntryTypeUsedSize && len(body) >= 4:
+			e.UsedSize = binary.LittleEndian.Uint32(body)
+			body = body[4:]
+		}
+		if len(body) != 0 {
+			e.Data = append([]byte{}, body...)
+		}
+		entries = append(entries, e)
+		buf = buf[size:]
+	}
+	return entries, nil
+}
+
+// Bytes returns the binary form of the entry, including its size and type.
+func (e *FVExtEntry) Bytes() []byte {
+	buf := make([]byte, fvExtEntryHeaderSize)
+	switch e.Type {
+	case FVExtEntryTypeOEM:
+		buf = append(buf, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(buf[fvExtEntryHeaderSize:], e.TypeMask)
+		for _, g := range e.Types {
+			buf = append(buf, g[:]...)
+		}
+	case FVExtEntryTypeGUID:
+		if e.FormatType != nil {
+			buf = append(buf, e.FormatType[:]...)
+		}
+	case FVExtEntryTypeUsedSize:
+		buf = append(buf, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(buf[fvExtEntryHeaderSize:], e.UsedSize)
+	}
+	buf = append(buf, e.Data...)
+	binary.LittleEndian.PutUint16(buf, uint16(len(buf)))
+	binary.LittleEndian.PutUint16(buf[2:], uint16(e.Type))
+	return buf
+}
+
 // FirmwareVolume represents a firmware volume. It combines the fixed header and
 // a variable list of blocks
 type FirmwareVolume struct {
@@ -91,7 +234,23 @@ type FirmwareVolume struct {
 	// We don't really have to care about blocks because we just read everything in.
 	Blocks []Block
 	FirmwareVolumeExtHeader
-	Files []*File `json:",omitempty"`
+	// ExtEntries are the entries following the extended header.
+	ExtEntries []FVExtEntry `json:",omitempty"`
+	Files      []*File      `json:",omitempty"`
+
+	// VariableStore is the parsed variable store of an NVRAM FV, if any.
+	VariableStore *VariableStore `json:",omitempty"`
+	// FTWWorkingBlock is the FTW working block following the variable
+	// store, if any.
+	FTWWorkingBlock *FTWWorkingBlock `json:",omitempty"`
+	// PhoenixFlashMap is the Phoenix SCT flash map of an NVRAM FV, if any.
+	PhoenixFlashMap *PhoenixFlashMap `json:",omitempty"`
+
+	// FFSVersion is the generation of the filesystem of the FV, 1 to 3 for
+	// FFS1 to FFS3, 2 for vendor volumes using the FFS2 layout, and 0 for
+	// volumes which do not hold FFS files. It selects the meaning of the
+	// attributes of the files, see File.FFSVersion.
+	FFSVersion uint8 `json:",omitempty"`
 
 	// Variables not in the binary for us to keep track of stuff/print
 	DataOffset  uint64
@@ -100,6 +259,18 @@ type FirmwareVolume struct {
 	FVOffset    uint64 // Byte offset from start of BIOS region.
 	ExtractPath string
 	Resizable   bool // Determines if this FV is resizable.
+	Location
+
+	// ParseError is set if the files could not be parsed, the volume is kept
+	// as it is.
+	ParseError string `json:",omitempty"`
+
+	// Apple specific metadata, see apple.go.
+	AppleCRC32 bool `json:",omitempty"` // The zero vector holds a valid CRC32 of the volume body.
+
+	// ErasePolarityOverride is used instead of the ERASE_POLARITY attribute
+	// if set, see PolarityMode. The attribute in the header is kept.
+	ErasePolarityOverride *uint8 `json:",omitempty"`
 }
 
 // Buf returns the buffer.
@@ -126,12 +297,43 @@ func (fv *FirmwareVolume) ApplyChildren(v Visitor) error {
 			return err
 		}
 	}
+	if fv.VariableStore != nil {
+		if err := fv.VariableStore.Apply(v); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// ffsVersion returns the FFS generation of the filesystem GUID, see
+// FirmwareVolume.FFSVersion.
+func ffsVersion(fs uuid.UUID) uint8 {
+	switch {
+	case fs == *FFS1:
+		return 1
+	case fs == *FFS3:
+		return 3
+	case supportedFVs[fs]:
+		// FFS2 and the vendor volumes with its layout.
+		return 2
+	}
+	return 0
+}
+
+// HasExtHeader returns whether the FV has an extended header. The revision 1
+// header of the Framework spec has no ExtHeaderOffset, its bytes are
+// reserved.
+func (fv *FirmwareVolume) HasExtHeader() bool {
+	return fv.Revision > 1 && fv.ExtHeaderOffset != 0 &&
+		uint64(fv.ExtHeaderOffset) < fv.Length-FirmwareVolumeExtHeaderMinSize
+}
+
 // GetErasePolarity gets the erase polarity
 func (fv *FirmwareVolume) GetErasePolarity() uint8 {
-	if fv.Attributes&0x800 != 0 {
+	if fv.ErasePolarityOverride != nil {
+		return *fv.ErasePolarityOverride
+	}
+	if fv.Attributes&FVAttributeErasePolarity != 0 {
 		return 0xFF
 	}
 	return 0
@@ -163,9 +365,10 @@ func (fv *FirmwareVolume) Validate() []error {
 	if FVGUIDs[fv.FileSystemGUID] == "" {
 		errs = append(errs, fmt.Errorf("unknown FV type! Guid was %v", fv.FileSystemGUID))
 	}
-	// UEFI PI spec says version should always be 2
-	if fv.Revision != 2 {
-		errs = append(errs, fmt.Errorf("revision should be 2, was %v", fv.Revision))
+	// UEFI PI spec says version should always be 2, Framework volumes have
+	// revision 1.
+	if fv.Revision != 1 && fv.Revision != 2 {
+		errs = append(errs, fmt.Errorf("revision should be 1 or 2, was %v", fv.Revision))
 	}
 	// Check Signature
 	fvSigInt := binary.LittleEndian.Uint32([]byte("_FVH"))
@@ -184,12 +387,97 @@ func (fv *FirmwareVolume) Validate() []error {
 		errs = append(errs, fmt.Errorf("header did not sum to 0, got: %#x", sum))
 	}
 
-	for _, f := range fv.Files {
+	for i, f := range fv.Files {
+		if f.IsVTF() && i != len(fv.Files)-1 {
+			errs = append(errs, fmt.Errorf("volume top file is file %d of %d, it must be the last file in the FV",
+				i, len(fv.Files)))
+		}
 		errs = append(errs, f.Validate()...)
 	}
+	if fv.FTWWorkingBlock != nil {
+		errs = append(errs, fv.FTWWorkingBlock.Validate()...)
+	}
+	if fv.ParseError != "" {
+		errs = append(errs, errors.New(fv.ParseError))
+	}
 	return errs
 }
 
+// HasVTF returns whether the FV contains the Volume Top File.
+func (fv *FirmwareVolume) HasVTF() bool {
+	for _, f := range fv.Files {
+		if f.IsVTF() {
+			return true
+		}
+	}
+	return false
+}
+
+// GenFVHeader rebuilds the header at the start of the buffer from the parsed
+// fields, including the block map and the extended header with its entries,
+// and checksums it.
+// The zero vector and the reserved byte are not part of the JSON, they are
+// taken from the buffer. The buffer must already hold the whole header.
+func (fv *FirmwareVolume) GenFVHeader() error {
+	blockMapEnd := uint64(FirmwareVolumeFixedHeaderSize) + uint64(len(fv.Blocks)+1)*8
+	if blockMapEnd > uint64(fv.HeaderLen) {
+		return fmt.Errorf("block map of %d entries does not fit into the %#x bytes FV header",
+			len(fv.Blocks), fv.HeaderLen)
+	}
+	if uint64(fv.HeaderLen) > uint64(len(fv.buf)) {
+		return fmt.Errorf("FV header of %#x bytes does not fit into the %#x bytes buffer", fv.HeaderLen, len(fv.buf))
+	}
+	copy(fv.ZeroVector[:], fv.buf)
+	fv.Reserved = fv.buf[fvReservedOffset]
+	fv.Checksum = 0
+
+	header := new(bytes.Buffer)
+	if err := binary.Write(header, binary.LittleEndian, fv.FirmwareVolumeFixedHeader); err != nil {
+		return err
+	}
+	// The block map is terminated by an empty entry.
+	if err := binary.Write(header, binary.LittleEndian, append(append([]Block{}, fv.Blocks...), Block{})); err != nil {
+		return err
+	}
+	copy(fv.buf, header.Bytes())
+
+	if fv.HasExtHeader() {
+		extHeader := new(bytes.Buffer)
+		if err := binary.Write(extHeader, binary.LittleEndian, fv.FirmwareVolumeExtHeader); err != nil {
+			return err
+		}
+		for _, e := range fv.ExtEntries {
+			extHeader.Write(e.Bytes())
+		}
+		if fv.ExtEntries != nil && uint64(extHeader.Len()) != uint64(fv.ExtHeaderSize) {
+			return fmt.Errorf("FV extended header with its entries is %#x bytes, ExtHeaderSize is %#x",
+				extHeader.Len(), fv.ExtHeaderSize)
+		}
+		if uint64(fv.ExtHeaderOffset)+uint64(extHeader.Len()) > uint64(len(fv.buf)) {
+			return fmt.Errorf("FV extended header of %#x bytes at %#x does not fit into the %#x bytes buffer",
+				extHeader.Len(), fv.ExtHeaderOffset, len(fv.buf))
+		}
+		copy(fv.buf[fv.ExtHeaderOffset:], extHeader.Bytes())
+	}
+
+	sum, err := Checksum16(fv.buf[:fv.HeaderLen])
+	if err != nil {
+		return err
+	}
+	fv.Checksum = 0 - sum
+	binary.LittleEndian.PutUint16(fv.buf[fvChecksumOffset:], fv.Checksum)
+	return nil
+}
+
+// SetUsedSize updates the used size entries of the extended header.
+func (fv *FirmwareVolume) SetUsedSize(used uint64) {
+	for i := range fv.ExtEntries {
+		if fv.ExtEntries[i].Type == FVExtEntryTypeUsedSize {
+			fv.ExtEntries[i].UsedSize = uint32(used)
+		}
+	}
+}
+
 func fillFFs(b []byte) {
 	for i := range b {
 		b[i] = 0xFF
@@ -243,7 +531,7 @@ func FindFirmwareVolumeOffset(data []byte) int64 {
 		offset int64
 		fvSig  = []byte("_FVH")
 	)
-	for offset = 32; offset < int64(len(data)); offset += 8 {
+	for offset = 32; offset+4 <= int64(len(data)); offset += 8 {
 		if bytes.Equal(data[offset:offset+4], fvSig) {
 			return offset - 40 // the actual volume starts 40 bytes before the signature
 		}
@@ -251,6 +539,50 @@ func FindFirmwareVolumeOffset(data []byte) int64 {
 	return -1
 }
 
+// CreateFirmwareVolume creates an empty FV of the given filesystem, e.g.
+// FFS2 or FFS3, with an extended header holding its name. The FV has the
+// attributes EDK2 uses for flash FVs, with 16 byte alignment, and holds
+// erase polarity bytes.
+func CreateFirmwareVolume(fileSystem, name uuid.UUID, size, blockSize uint64, polarity uint8) (*FirmwareVolume, error) {
+	if polarity != 0x00 && polarity != 0xFF {
+		return nil, fmt.Errorf("erase polarity not 0x00 or 0xFF, got %#x", polarity)
+	}
+	if blockSize == 0 || blockSize > math.MaxUint32 || size%blockSize != 0 || size/blockSize > math.MaxUint32 {
+		return nil, fmt.Errorf("FV size %#x is not a multiple of the block size %#x", size, blockSize)
+	}
+	headerLen := uint64(FirmwareVolumeMinSize + 8)
+	if size < Align8(headerLen+FirmwareVolumeExtHeaderMinSize) {
+		return nil, fmt.Errorf("FV size %#x is too small for its header", size)
+	}
+	fv := FirmwareVolume{
+		FirmwareVolumeFixedHeader: FirmwareVolumeFixedHeader{
+			FileSystemGUID:  fileSystem,
+			Length:          size,
+			Signature:       binary.LittleEndian.Uint32([]byte("_FVH")),
+			Attributes:      0x0004FEFF,
+			HeaderLen:       uint16(headerLen),
+			ExtHeaderOffset: uint16(headerLen),
+			Revision:        2,
+		},
+		Blocks:                  []Block{{Count: uint32(size / blockSize), Size: uint32(blockSize)}},
+		FirmwareVolumeExtHeader: FirmwareVolumeExtHeader{FVName: name, ExtHeaderSize: FirmwareVolumeExtHeaderMinSize},
+		buf:                     make([]byte, size),
+	}
+	if polarity == 0 {
+		fv.Attributes &^= FVAttributeErasePolarity
+	}
+	Erase(fv.buf, polarity)
+	// The zero vector and the reserved byte are zero.
+	for i := 0; i < 16; i++ {
+		fv.buf[i] = 0
+	}
+	fv.buf[fvReservedOffset] = 0
+	if err := fv.GenFVHeader(); err != nil {
+		return nil, err
+	}
+	return NewFirmwareVolume(fv.buf, 0, false)
+}
+
 // NewFirmwareVolume parses a sequence of bytes and returns a FirmwareVolume
 // object, if a valid one is passed, or an error
 func NewFirmwareVolume(data []byte, fvOffset uint64, resizable bool) (*FirmwareVolume, error) {
@@ -281,9 +613,25 @@ func NewFirmwareVolume(data []byte, fvOffset uint64, resizable bool) (*FirmwareV
 	}
 	fv.Blocks = blocks
 
+	// The length is trusted below, check it first.
+	if fv.Length < FirmwareVolumeMinSize || fv.Length > uint64(len(data)) {
+		return nil, fmt.Errorf("FV length %#x is out of range, the buffer is %#x bytes", fv.Length, len(data))
+	}
+	if uint64(fv.HeaderLen) <= fv.Length {
+		sum, err := Checksum16(data[:fv.HeaderLen])
+		if err == nil && sum != 0 {
+			err = fmt.Errorf("sum was %#04x", sum)
+		}
+		if err != nil {
+			if err := anomaly("FV at offset %#x header checksum failure: %v", fvOffset, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+
 	// Parse the extended header and figure out the start of data
 	fv.DataOffset = uint64(fv.HeaderLen)
-	if fv.ExtHeaderOffset != 0 && uint64(fv.ExtHeaderOffset) < fv.Length-FirmwareVolumeExtHeaderMinSize {
+	if fv.HasExtHeader() {
 		// jump to ext header offset.
 		r := bytes.NewReader(data[fv.ExtHeaderOffset:])
 		if err := binary.Read(r, binary.LittleEndian, &fv.FirmwareVolumeExtHeader); err != nil {
@@ -291,36 +639,87 @@ func NewFirmwareVolume(data []byte, fvOffset uint64, resizable bool) (*FirmwareV
 		}
 		// TODO: will the ext header ever end before the regular header? I don't believe so. Add a check?
 		fv.DataOffset = uint64(fv.ExtHeaderOffset) + uint64(fv.ExtHeaderSize)
+		if start, end := uint64(fv.ExtHeaderOffset)+FirmwareVolumeExtHeaderMinSize, fv.DataOffset; start < end && end <= uint64(len(data)) {
+			entries, err := parseFVExtEntries(data[start:end])
+			if err != nil {
+				if err := anomaly("unable to parse the extended header entries of the FV at offset %#x: %v", fvOffset, err); err != nil {
+					return nil, err
+				}
+			} else {
+				fv.ExtEntries = entries
+			}
+		}
 	}
 	// Make sure DataOffset is 8 byte aligned at least.
 	// TODO: handle alignment field in header.
 	fv.DataOffset = Align8(fv.DataOffset)
 
 	fv.fvType = FVGUIDs[fv.FileSystemGUID]
+	fv.FFSVersion = ffsVersion(fv.FileSystemGUID)
 	fv.FVOffset = fvOffset
 
 	// slice the buffer
 	fv.buf = data[:fv.Length]
+	fv.AppleCRC32 = fv.hasAppleCRC32()
+	if err := fv.applyPolarityMode(); err != nil {
+		return nil, err
+	}
+
+	// Phoenix SCT NVRAM volumes hold the flash map along with their stores.
+	if fv.FileSystemGUID == *EVSA {
+		if err := fv.ParsePhoenixFlashMap(); err != nil {
+			return nil, err
+		}
+	}
+
+	// NVRAM volumes hold a variable store instead of files.
+	if fv.FileSystemGUID == *EVSA && fv.DataOffset < fv.Length && IsVariableStore(fv.buf[fv.DataOffset:]) {
+		vs, err := NewVariableStore(fv.buf[fv.DataOffset:])
+		if err != nil {
+			if err := anomaly("unable to parse variable store in FV at offset %#x: %v", fvOffset, err); err != nil {
+				return nil, err
+			}
+		} else {
+			fv.VariableStore = vs
+		}
+		if err := fv.ParseFTW(); err != nil {
+			return nil, err
+		}
+		return &fv, nil
+	}
 
 	// Parse the files.
 	// TODO: handle fv data alignment.
 	// Start from the end of the fv header.
 	// Test if the fv type is supported.
-	if _, ok := supportedFVs[fv.FileSystemGUID]; !ok {
+	if !IsSupportedFV(fv.FileSystemGUID) {
 		return &fv, nil
 	}
 	lh := fv.Length - FileHeaderMinLength
 	var prevLen uint64
 	for offset := fv.DataOffset; offset < lh; offset += prevLen {
 		offset = Align8(offset)
-		file, err := NewFile(data[offset:])
+		file, err := NewFFSFile(fv.buf[offset:], fv.FFSVersion)
 		if err != nil {
-			return nil, fmt.Errorf("unable to construct firmware file at offset %#x into FV: %v", offset, err)
+			err = fmt.Errorf("unable to construct firmware file at offset %#x into FV: %v", offset, err)
+			if err := recoverNode(err); err != nil {
+				return nil, err
+			}
+			fv.Files = nil
+			fv.ParseError = err.Error()
+			break
 		}
 		if file == nil {
 			// We've reached free space. Terminate
 			break
 		}
+		if err := file.checkFile(fv.GetErasePolarity()); err != nil {
+			return nil, err
+		}
+		file.State = FileStateName(file.Header.State, fv.GetErasePolarity())
+		if file.Header.Type == FVFileTypePad && !IsErased(file.Data(), fv.GetErasePolarity()) {
+			file.PadData = true
+		}
 		fv.Files = append(fv.Files, file)
 		prevLen = file.Header.ExtendedSize
 	}
@@ -5,10 +5,13 @@
 package uefi
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
 )
 
 var (
@@ -62,6 +65,8 @@ func TestNewFirmwareVolume(t *testing.T) {
 		{"emptyFV", emptyFV, fmt.Sprintf("Firmware Volume size too small: expected %d bytes, got %d",
 			FirmwareVolumeMinSize, len(emptyFV))},
 		{"sampleFV", sampleFV, ""},
+		{"truncatedFV", sampleFV[:0x1000], fmt.Sprintf("FV length %#x is out of range, the buffer is 0x1000 bytes",
+			len(sampleFV))},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -74,3 +79,194 @@ func TestNewFirmwareVolume(t *testing.T) {
 		})
 	}
 }
+
+func TestAllowDenyFV(t *testing.T) {
+	defer AllowFV(*FFS2)
+
+	DenyFV(*FFS2)
+	fv, err := NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fv.Files) != 0 {
+		t.Errorf("denied FV was parsed into %d files", len(fv.Files))
+	}
+
+	AllowFV(*FFS2)
+	if fv, err = NewFirmwareVolume(sampleFV, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if len(fv.Files) == 0 {
+		t.Errorf("allowed FV was not parsed")
+	}
+}
+
+func TestParseFVGUID(t *testing.T) {
+	var tests = []struct {
+		in   string
+		want string
+	}{
+		{"FFS2", FFS2.String()},
+		{"nvram_evsa", EVSA.String()},
+		{"5473C07A-3DCB-4DCA-BD6F-1E9689E7349A", FFS3.String()},
+	}
+	for _, test := range tests {
+		g, err := ParseFVGUID(test.in)
+		if err != nil {
+			t.Errorf("ParseFVGUID(%q) failed: %v", test.in, err)
+			continue
+		}
+		if g.String() != test.want {
+			t.Errorf("ParseFVGUID(%q) = %v; want %v", test.in, g, test.want)
+		}
+	}
+}
+
+func TestGenFVHeader(t *testing.T) {
+	fv, err := NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fv.GenFVHeader(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fv.Buf(), sampleFV) {
+		t.Errorf("unchanged header was not rebuilt identically")
+	}
+
+	// Header fields changed in the JSON must end up in the buffer.
+	fv.Attributes ^= 0x1
+	fv.Revision = 1
+	if err := fv.GenFVHeader(); err != nil {
+		t.Fatal(err)
+	}
+	nfv, err := NewFirmwareVolume(fv.Buf(), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nfv.Attributes != fv.Attributes || nfv.Revision != 1 {
+		t.Errorf("header fields not updated, got attributes %#x revision %d", nfv.Attributes, nfv.Revision)
+	}
+	if sum, err := Checksum16(fv.Buf()[:fv.HeaderLen]); err != nil || sum != 0 {
+		t.Errorf("header checksum is wrong, sum was %#x: %v", sum, err)
+	}
+
+	// There is no room for another block map entry.
+	fv.Blocks = append(fv.Blocks, Block{Count: 1, Size: 0x1000})
+	if err := fv.GenFVHeader(); err == nil {
+		t.Errorf("expected an error for a block map larger than the header")
+	}
+}
+
+func TestFVExtEntries(t *testing.T) {
+	buf := []byte{
+		// OEM type entry with one type
+		0x18, 0x00, 0x01, 0x00, 0x01, 0x00, 0x00, 0x00,
+		0xd9, 0x54, 0x93, 0x7a, 0x68, 0x04, 0x4a, 0x44, 0x81, 0xce, 0x0b, 0xf6, 0x17, 0xd8, 0x90, 0xdf,
+		// GUID type entry with 2 bytes of data
+		0x16, 0x00, 0x02, 0x00,
+		0x78, 0xe5, 0x8c, 0x8c, 0x3d, 0x8a, 0x1c, 0x4f, 0x99, 0x35, 0x89, 0x61, 0x85, 0xc3, 0x2d, 0xd3,
+		0xaa, 0xbb,
+		// Used size entry
+		0x08, 0x00, 0x03, 0x00, 0x00, 0x10, 0x00, 0x00,
+		// Unknown entry
+		0x06, 0x00, 0x42, 0x00, 0x01, 0x02,
+	}
+	entries, err := parseFVExtEntries(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	if e := entries[0]; e.TypeMask != 1 || len(e.Types) != 1 || e.Types[0] != *FFS1 {
+		t.Errorf("wrong OEM type entry %+v", e)
+	}
+	if e := entries[1]; e.FormatType == nil || *e.FormatType != *FFS2 || !bytes.Equal(e.Data, []byte{0xaa, 0xbb}) {
+		t.Errorf("wrong GUID type entry %+v", e)
+	}
+	if e := entries[2]; e.UsedSize != 0x1000 {
+		t.Errorf("wrong used size entry %+v", e)
+	}
+	if e := entries[3]; e.Type != 0x42 || !bytes.Equal(e.Data, []byte{1, 2}) {
+		t.Errorf("wrong unknown entry %+v", e)
+	}
+	var nb []byte
+	for _, e := range entries {
+		nb = append(nb, e.Bytes()...)
+	}
+	if !bytes.Equal(nb, buf) {
+		t.Errorf("entries not reassembled identically, got\n%x\nwant\n%x", nb, buf)
+	}
+
+	if _, err := parseFVExtEntries([]byte{0x10, 0x00, 0x03, 0x00}); err == nil {
+		t.Errorf("expected an error for an entry larger than the buffer")
+	}
+
+	// The entries are written after the extended header, and must fill it.
+	fv, err := NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fv.ExtEntries = []FVExtEntry{{Type: FVExtEntryTypeUsedSize}}
+	fv.SetUsedSize(0x2000)
+	if err := fv.GenFVHeader(); err == nil {
+		t.Errorf("expected an error for entries larger than the extended header")
+	}
+	fv.ExtHeaderSize += 8
+	if err := fv.GenFVHeader(); err != nil {
+		t.Fatal(err)
+	}
+	start := uint64(fv.ExtHeaderOffset) + FirmwareVolumeExtHeaderMinSize
+	entries, err = parseFVExtEntries(fv.Buf()[start : start+8])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].UsedSize != 0x2000 {
+		t.Errorf("used size entry not written, got %+v", entries)
+	}
+}
+
+func TestFFSVersion(t *testing.T) {
+	for _, test := range []struct {
+		fs      *uuid.UUID
+		version uint8
+	}{
+		{FFS2, 2},
+		{FFS3, 3},
+		{AppleBoot, 2},
+		{EVSA, 0},
+	} {
+		fv, err := CreateFirmwareVolume(*test.fs, *FFGUID, 0x1000, 0x1000, 0xFF)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fv.FFSVersion != test.version {
+			t.Errorf("FV of filesystem %v has FFS version %d, expected %d", test.fs, fv.FFSVersion, test.version)
+		}
+	}
+
+	// Revision 1 volumes of the Framework spec have no extended header,
+	// their ExtHeaderOffset bytes are reserved.
+	fv, err := CreateFirmwareVolume(*FFS1, *FFGUID, 0x1000, 0x1000, 0xFF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fv.Revision = 1
+	if err := fv.GenFVHeader(); err != nil {
+		t.Fatal(err)
+	}
+	AllowFV(*FFS1)
+	defer delete(supportedFVs, *FFS1)
+	nfv, err := NewFirmwareVolume(fv.Buf(), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nfv.FFSVersion != 1 || nfv.HasExtHeader() || nfv.DataOffset != uint64(nfv.HeaderLen) {
+		t.Errorf("revision 1 FV parsed with FFS version %d, extended header %v and data at %#x",
+			nfv.FFSVersion, nfv.HasExtHeader(), nfv.DataOffset)
+	}
+	if errs := nfv.Validate(); len(errs) != 0 {
+		t.Errorf("revision 1 FV does not validate: %v", errs)
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// FIT layout constants, as described in the Firmware Interface Table BIOS
+// Specification.
+const (
+	// FITPointerAddress is where the CPU looks for the address of the FIT.
+	FITPointerAddress = 0xFFFFFFC0
+	// FITPointerOffset is the offset of the FIT pointer from the end of the
+	// image, which is mapped right below 4GiB.
+	FITPointerOffset = 0x40
+	// FITEntryLength is the length of a FIT entry.
+	FITEntryLength = 16
+	// FITVersion is the version of all entries generated here.
+	FITVersion = 0x0100
+
+	// FITAlignment is the required alignment of the FIT and of microcode
+	// updates, ACMAlignment the one of the startup ACM.
+	FITAlignment = 16
+	ACMAlignment = 0x1000
+
+	// fitChecksumValid is the C_V bit of the type field.
+	fitChecksumValid = 0x80
+)
+
+// FITSignature is the address field of the FIT header entry.
+var FITSignature = []byte("_FIT_   ")
+
+// FITEntryType is the type of a FIT entry.
+type FITEntryType uint8
+
+// FIT entry types
+const (
+	FITTypeHeader      FITEntryType = 0x00
+	FITTypeMicrocode   FITEntryType = 0x01
+	FITTypeStartupACM  FITEntryType = 0x02
+	FITTypeBIOSModule  FITEntryType = 0x07
+	FITTypeTPMPolicy   FITEntryType = 0x08
+	FITTypeBIOSPolicy  FITEntryType = 0x09
+	FITTypeTXTPolicy   FITEntryType = 0x0A
+	FITTypeKeyManifest FITEntryType = 0x0B
+	FITTypeBootPolicy  FITEntryType = 0x0C
+	FITTypeSkip        FITEntryType = 0x7F
+)
+
+var fitEntryTypeNames = map[FITEntryType]string{
+	FITTypeHeader:      "Header",
+	FITTypeMicrocode:   "Microcode",
+	FITTypeStartupACM:  "StartupACM",
+	FITTypeBIOSModule:  "BIOSModule",
+	FITTypeTPMPolicy:   "TPMPolicy",
+	FITTypeBIOSPolicy:  "BIOSPolicy",
+	FITTypeTXTPolicy:   "TXTPolicy",
+	FITTypeKeyManifest: "KeyManifest",
+	FITTypeBootPolicy:  "BootPolicy",
+	FITTypeSkip:        "Skip",
+}
+
+func (t FITEntryType) String() string {
+	if s, ok := fitEntryTypeNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("FITEntryType(%#x)", uint8(t))
+}
+
+// FITEntry is an entry of the FIT.
+type FITEntry struct {
+	Address uint64
+	// Size is in units of 16 bytes, except for the header entry, where it
+	// is the number of entries.
+	Size     [3]uint8
+	_        uint8
+	Version  uint16
+	TypeCV   uint8
+	Checksum uint8
+}
+
+// Type returns the type of the entry.
+func (e *FITEntry) Type() FITEntryType {
+	return FITEntryType(e.TypeCV &^ fitChecksumValid)
+}
+
+// NumEntries returns the number of entries of the table, for header entries.
+func (e *FITEntry) NumEntries() int {
+	return int(Read3Size(e.Size))
+}
+
+// FIT is a firmware interface table.
+type FIT struct {
+	// Address of the table in memory.
+	Address uint64
+	Entries []FITEntry
+}
+
+// AddressToOffset converts a memory address right below 4GiB to an offset in
+// an image of the given size.
+func AddressToOffset(addr uint64, size uint64) (uint64, error) {
+	const top = 1 << 32
+	if addr >= top || addr < top-size {
+		return 0, fmt.Errorf("address %#x is not in the %#x bytes image below 4GiB", addr, size)
+	}
+	return addr - (top - size), nil
+}
+
+// OffsetToAddress converts an offset in an image of the given size to its
+// memory address right below 4GiB.
+func OffsetToAddress(offset uint64, size uint64) uint64 {
+	return 1<<32 - size + offset
+}
+
+// FindFIT reads the FIT pointer of the image and parses the table.
+func FindFIT(image []byte) (*FIT, error) {
+	size := uint64(len(image))
+	if size < FITPointerOffset {
+		return nil, fmt.Errorf("image of %#x bytes is too small for a FIT pointer", size)
+	}
+	addr := binary.LittleEndian.Uint64(image[size-FITPointerOffset:])
+	offset, err := AddressToOffset(addr, size)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FIT pointer: %v", err)
+	}
+	return NewFIT(image[offset:], addr)
+}
+
+// NewFIT parses a FIT at the start of buf, which is at addr in memory.
+func NewFIT(buf []byte, addr uint64) (*FIT, error) {
+	var h FITEntry
+	if len(buf) < FITEntryLength {
+		return nil, fmt.Errorf("FIT at %#x is beyond the end of the image", addr)
+	}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(buf[:len(FITSignature)], FITSignature) || h.Type() != FITTypeHeader {
+		return nil, fmt.Errorf("no FIT header at %#x", addr)
+	}
+	n := h.NumEntries()
+	if n == 0 || n*FITEntryLength > len(buf) {
+		return nil, fmt.Errorf("FIT of %d entries at %#x does not fit into the image", n, addr)
+	}
+	fit := &FIT{Address: addr, Entries: make([]FITEntry, n)}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, fit.Entries); err != nil {
+		return nil, err
+	}
+	if h.TypeCV&fitChecksumValid != 0 {
+		if sum := Checksum8(buf[:n*FITEntryLength]); sum != 0 {
+			return nil, fmt.Errorf("FIT checksum is %#x, expected 0", sum)
+		}
+	}
+	return fit, nil
+}
+
+// NewFITFromParts builds a FIT with the header, an entry for each
+// microcode update, sorted by address, and the startup ACM entry if acm is
+// not 0. Addresses are checked for the required alignments.
+func NewFITFromParts(addr uint64, microcode []uint64, acm uint64) (*FIT, error) {
+	if addr%FITAlignment != 0 {
+		return nil, fmt.Errorf("FIT address %#x is not %d byte aligned", addr, FITAlignment)
+	}
+	fit := &FIT{Address: addr}
+	fit.Entries = append(fit.Entries, FITEntry{
+		Address: binary.LittleEndian.Uint64(FITSignature),
+		Version: FITVersion,
+		TypeCV:  uint8(FITTypeHeader) | fitChecksumValid,
+	})
+
+	microcode = append([]uint64{}, microcode...)
+	sort.Slice(microcode, func(i, j int) bool { return microcode[i] < microcode[j] })
+	for _, m := range microcode {
+		if m%FITAlignment != 0 {
+			return nil, fmt.Errorf("microcode update at %#x is not %d byte aligned", m, FITAlignment)
+		}
+		fit.Entries = append(fit.Entries, FITEntry{Address: m, Version: FITVersion, TypeCV: uint8(FITTypeMicrocode)})
+	}
+	if acm != 0 {
+		if acm%ACMAlignment != 0 {
+			return nil, fmt.Errorf("startup ACM at %#x is not %#x byte aligned", acm, ACMAlignment)
+		}
+		fit.Entries = append(fit.Entries, FITEntry{Address: acm, Version: FITVersion, TypeCV: uint8(FITTypeStartupACM)})
+	}
+	fit.Entries[0].Size = Write3Size(uint64(len(fit.Entries)))
+	return fit, nil
+}
+
+// Bytes returns the table with the header checksum updated.
+func (fit *FIT) Bytes() []byte {
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, fit.Entries)
+	b := buf.Bytes()
+	if fit.Entries[0].TypeCV&fitChecksumValid != 0 {
+		b[15] = 0
+		b[15] = 0 - Checksum8(b)
+		fit.Entries[0].Checksum = b[15]
+	}
+	return b
+}
+
+// MicrocodeHeader is the header of an Intel microcode update.
+type MicrocodeHeader struct {
+	HeaderVersion      uint32
+	UpdateRevision     uint32
+	Date               uint32
+	ProcessorSignature uint32
+	Checksum           uint32
+	LoaderRevision     uint32
+	ProcessorFlags     uint32
+	// DataSize and TotalSize are 0 for updates of 2000 bytes of data.
+	DataSize  uint32
+	TotalSize uint32
+	_         [12]byte
+}
+
+// MicrocodeSize returns the size of the microcode update at the start of buf,
+// or an error if there is none. The header is checked along with the
+// checksum of the whole update.
+func MicrocodeSize(buf []byte) (uint64, error) {
+	var h MicrocodeHeader
+	hl := binary.Size(h)
+	if len(buf) < hl {
+		return 0, fmt.Errorf("%#x bytes are too small for a microcode update", len(buf))
+	}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &h); err != nil {
+		return 0, err
+	}
+	if h.HeaderVersion != 1 || h.LoaderRevision != 1 {
+		return 0, fmt.Errorf("no microcode update header")
+	}
+	data, total := uint64(h.DataSize), uint64(h.TotalSize)
+	if data == 0 {
+		data, total = 2000, 2048
+	}
+	if total < data+uint64(hl) || total%4 != 0 || total > uint64(len(buf)) {
+		return 0, fmt.Errorf("invalid microcode update size %#x", total)
+	}
+	var sum uint32
+	for i := uint64(0); i < total; i += 4 {
+		sum += binary.LittleEndian.Uint32(buf[i:])
+	}
+	if sum != 0 {
+		return 0, fmt.Errorf("microcode update checksum is %#x, expected 0", sum)
+	}
+	return total, nil
+}
+
+// ACM header values identifying an Intel authenticated code module.
+const (
+	acmModuleType   = 2
+	acmModuleVendor = 0x8086
+)
+
+// IsACM returns whether buf starts with an authenticated code module header.
+func IsACM(buf []byte) bool {
+	if len(buf) < 0x20 {
+		return false
+	}
+	return binary.LittleEndian.Uint16(buf) == acmModuleType &&
+		binary.LittleEndian.Uint32(buf[0x10:]) == acmModuleVendor
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"testing"
+)
+
+func TestNewFITFromParts(t *testing.T) {
+	fit, err := NewFITFromParts(0xfffd0000, []uint64{0xfffe0800, 0xfffe0000}, 0xfffc0000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := NewFIT(fit.Bytes(), fit.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parsed.Entries) != 4 || parsed.Entries[0].NumEntries() != 4 {
+		t.Fatalf("expected 4 entries, got %+v", parsed.Entries)
+	}
+	if e := parsed.Entries[1]; e.Type() != FITTypeMicrocode || e.Address != 0xfffe0000 {
+		t.Errorf("expected the first microcode update at 0xfffe0000, got %v at %#x", e.Type(), e.Address)
+	}
+	if e := parsed.Entries[3]; e.Type() != FITTypeStartupACM || e.Address != 0xfffc0000 {
+		t.Errorf("expected the startup ACM at 0xfffc0000, got %v at %#x", e.Type(), e.Address)
+	}
+
+	buf := fit.Bytes()
+	buf[FITEntryLength]++
+	if _, err := NewFIT(buf, fit.Address); err == nil {
+		t.Error("expected a checksum error")
+	}
+}
+
+func TestNewFITFromPartsAlignment(t *testing.T) {
+	var tests = []struct {
+		name      string
+		addr      uint64
+		microcode []uint64
+		acm       uint64
+	}{
+		{"table", 0xfffd0008, nil, 0},
+		{"microcode", 0xfffd0000, []uint64{0xfffe0004}, 0},
+		{"ACM", 0xfffd0000, nil, 0xfffc0100},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := NewFITFromParts(test.addr, test.microcode, test.acm); err == nil {
+				t.Error("expected an alignment error")
+			}
+		})
+	}
+}
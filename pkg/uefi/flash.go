@@ -6,8 +6,11 @@ package uefi
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"log"
+	"sort"
 )
 
 // FlashSignature is the sequence of bytes that a Flash image is expected to
@@ -28,14 +31,20 @@ type FlashDescriptor struct {
 	// Holds the raw buffer
 	buf                []byte
 	DescriptorMapStart uint
+	ComponentStart     uint
 	RegionStart        uint
 	MasterStart        uint
+	Version            IFDVersion
 	DescriptorMap      *FlashDescriptorMap
+	Component          *FlashComponentSection
 	Region             *FlashRegionSection
 	Master             *FlashMasterSection
+	Straps             *FlashStraps `json:",omitempty"`
+	VSCC               []VSCCEntry  `json:",omitempty"`
 
 	//Metadata for extraction and recovery
 	ExtractPath string
+	Location
 }
 
 // FindSignature searches for an Intel flash signature.
@@ -87,28 +96,116 @@ func (fd *FlashDescriptor) ParseFlashDescriptor() error {
 	fd.DescriptorMapStart = uint(descriptorMapStart)
 
 	// Descriptor Map
-	desc, err := NewFlashDescriptorMap(fd.buf[fd.DescriptorMapStart : fd.DescriptorMapStart+FlashDescriptorMapSize])
+	desc, err := NewFlashDescriptorMap(fd.buf[fd.DescriptorMapStart:])
 	if err != nil {
 		return err
 	}
 	fd.DescriptorMap = desc
 
+	// Component
+	fd.ComponentStart = uint(fd.DescriptorMap.ComponentBase) * 0x10
+	if fd.ComponentStart+FlashComponentSectionSize > uint(len(fd.buf)) {
+		return fmt.Errorf("component section at %#x is out of bounds", fd.ComponentStart)
+	}
+	fd.Version = DetectIFDVersion(binary.LittleEndian.Uint32(fd.buf[fd.ComponentStart:]))
+	component, err := NewFlashComponentSection(fd.buf[fd.ComponentStart:], fd.Version)
+	if err != nil {
+		return err
+	}
+	fd.Component = component
+
 	// Region
 	fd.RegionStart = uint(fd.DescriptorMap.RegionBase) * 0x10
-	region, err := NewFlashRegionSection(fd.buf[fd.RegionStart : fd.RegionStart+uint(FlashRegionSectionSize)])
+	fd.MasterStart = uint(fd.DescriptorMap.MasterBase) * 0x10
+	regionEnd := fd.regionEnd()
+	if fd.RegionStart > regionEnd {
+		return fmt.Errorf("region section at %#x is out of bounds", fd.RegionStart)
+	}
+	region, err := NewFlashRegionSection(fd.buf[fd.RegionStart:regionEnd])
 	if err != nil {
 		return err
 	}
+	region.maskReserved(fd.Version)
 	fd.Region = region
 
 	// Master
-	fd.MasterStart = uint(fd.DescriptorMap.MasterBase) * 0x10
-	master, err := NewFlashMasterSection(fd.buf[fd.MasterStart : fd.MasterStart+uint(FlashMasterSectionSize)])
+	master, err := NewFlashMasterSection(fd.buf[fd.MasterStart:], fd.Version)
 	if err != nil {
 		return err
 	}
 	fd.Master = master
 
+	// Soft straps
+	family := ChipsetPCH
+	if fd.DescriptorMapStart == FlashSignatureLength {
+		family = ChipsetICH
+	} else if fd.Version == IFDVersion2 {
+		family = ChipsetPCH2
+	}
+	straps, err := NewFlashStraps(fd.buf, fd.DescriptorMap, family)
+	if err != nil {
+		// The straps are informational, the image can be handled without them.
+		log.Printf("unable to read soft straps: %v", err)
+	} else {
+		straps.setFrequencies(fd.Component)
+	}
+	fd.Straps = straps
+
+	// VSCC table
+	vscc, err := fd.parseVSCC()
+	if err != nil {
+		log.Printf("unable to read VSCC table: %v", err)
+	}
+	fd.VSCC = vscc
+
+	return nil
+}
+
+// regionEnd returns the end of the region section. Older descriptors define
+// fewer regions and may have the master section right after them.
+func (fd *FlashDescriptor) regionEnd() uint {
+	end := fd.RegionStart + FlashRegionSectionSize
+	if fd.MasterStart > fd.RegionStart && fd.MasterStart < end {
+		end = fd.MasterStart
+	}
+	if end > uint(len(fd.buf)) {
+		end = uint(len(fd.buf))
+	}
+	return end
+}
+
+// SetRegion sets the base and limit of region i in the region section. An
+// invalid region, e.g. Region{}, marks region i unused. If the descriptor map
+// counts the regions and region i is beyond them, the count is raised.
+func (fd *FlashDescriptor) SetRegion(i int, r Region) error {
+	p := fd.Region.Region(i)
+	if p == nil {
+		return fmt.Errorf("invalid region index %d", i)
+	}
+	off := fd.RegionStart + 4*uint(i)
+	if off+4 > fd.regionEnd() {
+		return fmt.Errorf("region %d (%v) is beyond the region section of the descriptor", i, FlashRegionNames[i])
+	}
+	if !r.Valid() {
+		// Unused regions have the largest base the descriptor can hold.
+		r = Region{Base: 0x1fff}
+		if fd.Version == IFDVersion2 {
+			r.Base = 0x7fff
+		}
+	}
+	buf := append([]byte{}, fd.buf...)
+	binary.LittleEndian.PutUint16(buf[off:], r.Base)
+	binary.LittleEndian.PutUint16(buf[off+2:], r.Limit)
+	// NR, bits 26:24 of FLMAP0, is zero based and only counts up to 8
+	// regions. Newer descriptors leave it zero.
+	nr := fd.DescriptorMap.NumberOfRegions & 7
+	if nr != 0 && i > int(nr) && i <= 7 {
+		n := fd.DescriptorMap.NumberOfRegions&^7 | uint8(i)
+		buf[fd.DescriptorMapStart+3] = n
+		fd.DescriptorMap.NumberOfRegions = n
+	}
+	fd.buf = buf
+	*p = r
 	return nil
 }
 
@@ -118,6 +215,46 @@ func (fd *FlashDescriptor) Validate() []error {
 	return fd.DescriptorMap.Validate()
 }
 
+// CreateFlashDescriptor creates a minimal version 1 flash descriptor for a
+// single flash chip of the given size, with the regions by index, e.g. to
+// build an image from scratch. The descriptor region is the first 4KiB,
+// regions which are not given are unused. The BIOS, ME and GbE masters may
+// read and write all regions, and there are no soft straps.
+func CreateFlashDescriptor(size uint64, regions map[int]Region) ([]byte, error) {
+	d := -1
+	for i := 0; i <= 5; i++ {
+		if 512*1024<<uint(i) == size {
+			d = i
+		}
+	}
+	if d < 0 {
+		return nil, fmt.Errorf("flash size %#x is not a power of two between 512KiB and 16MiB", size)
+	}
+	buf := make([]byte, FlashDescriptorLength)
+	copy(buf[0x10:], FlashSignature)
+	// FLMAP0: components at 0x30, regions at 0x40. FLMAP1: 3 masters at 0x80.
+	copy(buf[0x14:], []byte{0x03, 0x00, 0x04, 0x04, 0x08, 0x02, 0x00, 0x00})
+	// FLCOMP: the density of the chip, read at 20MHz.
+	binary.LittleEndian.PutUint32(buf[0x30:], uint32(d))
+
+	const regionStart, masterStart = 0x40, 0x80
+	for i := 1; i < FlashRegionMaxCount; i++ {
+		r, ok := regions[i]
+		if !ok {
+			r = Region{Base: 0x1fff}
+		} else if !r.Valid() || uint64(r.EndOffset()) > size || r.BaseOffset() < FlashDescriptorLength {
+			return nil, fmt.Errorf("region %d (%v) %v is not within the %#x bytes flash after the descriptor",
+				i, FlashRegionNames[i], &r, size)
+		}
+		binary.LittleEndian.PutUint16(buf[regionStart+4*i:], r.Base)
+		binary.LittleEndian.PutUint16(buf[regionStart+4*i+2:], r.Limit)
+	}
+	for i := 0; i < 3; i++ {
+		binary.LittleEndian.PutUint32(buf[masterStart+4*i:], 0xffff0000)
+	}
+	return buf, nil
+}
+
 // FlashImage is the main structure that represents an Intel Flash image. It
 // implements the Firmware interface.
 type FlashImage struct {
@@ -130,10 +267,14 @@ type FlashImage struct {
 	ME   *MERegion   `json:",omitempty"`
 	GBE  *GBERegion  `json:",omitempty"`
 	PD   *PDRegion   `json:",omitempty"`
+	EC   *ECRegion   `json:",omitempty"`
+	// Regions without a dedicated type
+	Regions []*RawRegion `json:",omitempty"`
 
 	// Metadata for extraction and recovery
 	ExtractPath string
 	regions     []Firmware
+	Location
 }
 
 // Buf returns the buffer.
@@ -178,6 +319,16 @@ func (f *FlashImage) ApplyChildren(v Visitor) error {
 			return err
 		}
 	}
+	if f.EC != nil {
+		if err := f.EC.Apply(v); err != nil {
+			return err
+		}
+	}
+	for _, r := range f.Regions {
+		if err := r.Apply(v); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -227,9 +378,9 @@ func (f *FlashImage) String() string {
 // and an error if any. This only works with images that operate in Descriptor
 // mode.
 func NewFlashImage(buf []byte) (*FlashImage, error) {
-	if len(buf) < FlashDescriptorMapSize {
-		return nil, fmt.Errorf("Flash Descriptor Map size too small: expected %v bytes, got %v",
-			FlashDescriptorMapSize,
+	if len(buf) < FlashDescriptorLength {
+		return nil, fmt.Errorf("Flash Descriptor size too small: expected %v bytes, got %v",
+			FlashDescriptorLength,
 			len(buf),
 		)
 	}
@@ -246,7 +397,11 @@ func NewFlashImage(buf []byte) (*FlashImage, error) {
 	if !f.IFD.Region.BIOS.Valid() {
 		return nil, fmt.Errorf("no BIOS region: invalid region parameters %v", f.IFD.Region.BIOS)
 	}
-	br, err := NewBIOSRegion(buf[f.IFD.Region.BIOS.BaseOffset():f.IFD.Region.BIOS.EndOffset()], &f.IFD.Region.BIOS)
+	rbuf, err := regionBuf(buf, &f.IFD.Region.BIOS, RegionBIOS)
+	if err != nil {
+		return nil, err
+	}
+	br, err := NewBIOSRegion(rbuf, &f.IFD.Region.BIOS)
 	if err != nil {
 		return nil, err
 	}
@@ -256,7 +411,11 @@ func NewFlashImage(buf []byte) (*FlashImage, error) {
 
 	// ME region
 	if f.IFD.Region.ME.Valid() {
-		mer, err := NewMERegion(buf[f.IFD.Region.ME.BaseOffset():f.IFD.Region.ME.EndOffset()], &f.IFD.Region.ME)
+		rbuf, err := regionBuf(buf, &f.IFD.Region.ME, RegionME)
+		if err != nil {
+			return nil, err
+		}
+		mer, err := NewMERegion(rbuf, &f.IFD.Region.ME)
 		if err != nil {
 			return nil, err
 		}
@@ -267,7 +426,11 @@ func NewFlashImage(buf []byte) (*FlashImage, error) {
 
 	// GBE region
 	if f.IFD.Region.GBE.Valid() {
-		gber, err := NewGBERegion(buf[f.IFD.Region.GBE.BaseOffset():f.IFD.Region.GBE.EndOffset()], &f.IFD.Region.GBE)
+		rbuf, err := regionBuf(buf, &f.IFD.Region.GBE, RegionGBE)
+		if err != nil {
+			return nil, err
+		}
+		gber, err := NewGBERegion(rbuf, &f.IFD.Region.GBE)
 		if err != nil {
 			return nil, err
 		}
@@ -278,7 +441,11 @@ func NewFlashImage(buf []byte) (*FlashImage, error) {
 
 	// PD region
 	if f.IFD.Region.PD.Valid() {
-		pdr, err := NewPDRegion(buf[f.IFD.Region.PD.BaseOffset():f.IFD.Region.PD.EndOffset()], &f.IFD.Region.PD)
+		rbuf, err := regionBuf(buf, &f.IFD.Region.PD, RegionPD)
+		if err != nil {
+			return nil, err
+		}
+		pdr, err := NewPDRegion(rbuf, &f.IFD.Region.PD)
 		if err != nil {
 			return nil, err
 		}
@@ -287,5 +454,164 @@ func NewFlashImage(buf []byte) (*FlashImage, error) {
 		f.regions = append(f.regions, f.PD)
 	}
 
+	// EC region
+	if f.IFD.Region.EC.Valid() {
+		rbuf, err := regionBuf(buf, &f.IFD.Region.EC, RegionEC)
+		if err != nil {
+			return nil, err
+		}
+		ecr, err := NewECRegion(rbuf, &f.IFD.Region.EC)
+		if err != nil {
+			return nil, err
+		}
+		f.EC = ecr
+		// Add to extractable regions
+		f.regions = append(f.regions, f.EC)
+	}
+
+	// Other regions
+	for i := range FlashRegionNames {
+		if i == RegionBIOS || i == RegionME || i == RegionGBE || i == RegionPD || i == RegionEC {
+			continue
+		}
+		r := f.IFD.Region.Region(i)
+		if r == nil || !r.Valid() {
+			continue
+		}
+		rbuf, err := regionBuf(buf, r, i)
+		if err != nil {
+			return nil, err
+		}
+		rr, err := NewRawRegion(rbuf, r, i)
+		if err != nil {
+			return nil, err
+		}
+		f.Regions = append(f.Regions, rr)
+		// Add to extractable regions
+		f.regions = append(f.regions, rr)
+	}
+
 	return &f, nil
 }
+
+// regionBuf returns the part of buf covered by the region at index i, the
+// region is read from the descriptor and not trusted.
+func regionBuf(buf []byte, r *Region, i int) ([]byte, error) {
+	if r.EndOffset() > uint32(len(buf)) {
+		return nil, fmt.Errorf("region %d (%v) %v extends past the end of the %#x bytes image",
+			i, FlashRegionNames[i], r, len(buf))
+	}
+	return buf[r.BaseOffset():r.EndOffset()], nil
+}
+
+// AddRegion defines region i, which has to be unused, at r in the descriptor
+// and adds it to the image with the contents buf, padded with erased bytes.
+// r has to be within the image after the descriptor and must not overlap the
+// other regions. The image has to be assembled afterwards.
+func (f *FlashImage) AddRegion(i int, r Region, buf []byte) error {
+	if i == RegionDescriptor || i == RegionBIOS {
+		return fmt.Errorf("the %v region cannot be added or removed", FlashRegionNames[i])
+	}
+	p := f.IFD.Region.Region(i)
+	if p == nil {
+		return fmt.Errorf("invalid region index %d", i)
+	}
+	if p.Valid() {
+		return fmt.Errorf("region %d (%v) is already defined at %v", i, FlashRegionNames[i], p)
+	}
+	if !r.Valid() || r.BaseOffset() < FlashDescriptorLength || uint64(r.EndOffset()) > uint64(len(f.buf)) {
+		return fmt.Errorf("region %d (%v) %v is not within the %#x bytes flash after the descriptor",
+			i, FlashRegionNames[i], &r, len(f.buf))
+	}
+	for j := range FlashRegionNames {
+		if q := f.IFD.Region.Region(j); q != nil && q.Valid() && q.Base <= r.Limit && r.Base <= q.Limit {
+			return fmt.Errorf("region %d (%v) %v overlaps region %d (%v) %v",
+				i, FlashRegionNames[i], &r, j, FlashRegionNames[j], q)
+		}
+	}
+	size := int(r.EndOffset() - r.BaseOffset())
+	if len(buf) > size {
+		return fmt.Errorf("%#x bytes of data do not fit into the %#x bytes region %d (%v)",
+			len(buf), size, i, FlashRegionNames[i])
+	}
+	rbuf := append(append([]byte{}, buf...), bytes.Repeat([]byte{0xff}, size-len(buf))...)
+	if err := f.IFD.SetRegion(i, r); err != nil {
+		return err
+	}
+
+	var region Firmware
+	var err error
+	switch i {
+	case RegionME:
+		f.ME, err = NewMERegion(rbuf, p)
+		region = f.ME
+	case RegionGBE:
+		f.GBE, err = NewGBERegion(rbuf, p)
+		region = f.GBE
+	case RegionPD:
+		f.PD, err = NewPDRegion(rbuf, p)
+		region = f.PD
+	case RegionEC:
+		f.EC, err = NewECRegion(rbuf, p)
+		region = f.EC
+	default:
+		var rr *RawRegion
+		rr, err = NewRawRegion(rbuf, p, i)
+		f.Regions = append(f.Regions, rr)
+		sort.Slice(f.Regions, func(a, b int) bool { return f.Regions[a].Index < f.Regions[b].Index })
+		region = rr
+	}
+	if err != nil {
+		return err
+	}
+	f.regions = append(f.regions, region)
+	return nil
+}
+
+// RemoveRegion marks region i unused in the descriptor and removes it from
+// the image. Its space is erased when the image is assembled.
+func (f *FlashImage) RemoveRegion(i int) error {
+	if i == RegionDescriptor || i == RegionBIOS {
+		return fmt.Errorf("the %v region cannot be added or removed", FlashRegionNames[i])
+	}
+	p := f.IFD.Region.Region(i)
+	if p == nil {
+		return fmt.Errorf("invalid region index %d", i)
+	}
+	if !p.Valid() {
+		return fmt.Errorf("region %d (%v) is not defined", i, FlashRegionNames[i])
+	}
+	if err := f.IFD.SetRegion(i, Region{}); err != nil {
+		return err
+	}
+
+	var region Firmware
+	switch i {
+	case RegionME:
+		region, f.ME = f.ME, nil
+	case RegionGBE:
+		region, f.GBE = f.GBE, nil
+	case RegionPD:
+		region, f.PD = f.PD, nil
+	case RegionEC:
+		region, f.EC = f.EC, nil
+	default:
+		var kept []*RawRegion
+		for _, rr := range f.Regions {
+			if rr.Index == i {
+				region = rr
+				continue
+			}
+			kept = append(kept, rr)
+		}
+		f.Regions = kept
+	}
+	var kept []Firmware
+	for _, r := range f.regions {
+		if r != region {
+			kept = append(kept, r)
+		}
+	}
+	f.regions = kept
+	return nil
+}
@@ -5,8 +5,11 @@
 package uefi
 
 import (
+	"encoding/binary"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -64,3 +67,325 @@ func TestIsPCH(t *testing.T) {
 		}
 	}
 }
+
+// makeDescriptor returns a flash descriptor with 18 PCH straps at 0x100 and
+// a single processor strap at 0x200. ICH descriptors have the signature at
+// the start.
+func makeDescriptor(ich bool) []byte {
+	buf := make([]byte, FlashDescriptorLength)
+	m := 0x14
+	if ich {
+		m = 4
+	}
+	copy(buf[m-4:], FlashSignature)
+	copy(buf[m:], []byte{
+		0x03, 0x00, 0x04, 0x04, // FLMAP0: components at 0x30, regions at 0x40
+		0x06, 0x02, 0x10, 18, // FLMAP1: masters at 0x60, PCH straps at 0x100
+		0x20, 1, 0x00, 0x00, // FLMAP2: processor straps at 0x200
+	})
+	return buf
+}
+
+func TestFlashStraps(t *testing.T) {
+	var tests = []struct {
+		name   string
+		ich    bool
+		family ChipsetFamily
+		field  string
+	}{
+		{"pch", false, ChipsetPCH, "AltMeDisable"},
+		{"ich", true, ChipsetICH, "ICH_MeDisable"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := makeDescriptor(test.ich)
+			binary.LittleEndian.PutUint32(buf[0x100:], 1)     // PCHSTRP0
+			binary.LittleEndian.PutUint32(buf[0x128:], 1<<7)  // PCHSTRP10
+			binary.LittleEndian.PutUint32(buf[0x200:], 0xabc) // PROCSTRP0
+			fd := FlashDescriptor{buf: buf}
+			if err := fd.ParseFlashDescriptor(); err != nil {
+				t.Fatal(err)
+			}
+			s := fd.Straps
+			if s == nil {
+				t.Fatal("no straps parsed")
+			}
+			if s.Family != test.family {
+				t.Errorf("expected chipset family %v, got %v", test.family, s.Family)
+			}
+			if len(s.PCH) != 18 || len(s.Proc) != 1 || s.Proc[0] != 0xabc {
+				t.Errorf("unexpected straps PCH %#x, processor %#x", s.PCH, s.Proc)
+			}
+			f, ok := s.Field(test.field)
+			if !ok {
+				t.Fatalf("no %v field", test.field)
+			}
+			if f.Value != 1 {
+				t.Errorf("expected %v to be set, got %v", test.field, f)
+			}
+		})
+	}
+}
+
+func TestFlashStrapsAudit(t *testing.T) {
+	// The Boot Guard profile field is not public, pretend to know it.
+	defer func(fields []StrapField) { strapFields[ChipsetPCH] = fields }(strapFields[ChipsetPCH])
+	AddStrapField(ChipsetPCH, StrapField{Name: StrapBootGuardProfile, Strap: 15, Shift: 4, Width: 3})
+
+	buf := makeDescriptor(false)
+	// FLCOMP: 20MHz reads, fast reads at 33MHz.
+	binary.LittleEndian.PutUint32(buf[0x30:], 1<<20|SPIFrequency33MHz<<21)
+	binary.LittleEndian.PutUint32(buf[0x13c:], uint32(BootGuardProfileFVME)<<4) // PCHSTRP15
+	fd := FlashDescriptor{buf: buf}
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		t.Fatal(err)
+	}
+	s := fd.Straps
+	if s.ReadFrequency == nil || s.ReadFrequency.Name != "20MHz" {
+		t.Errorf("expected 20MHz reads, got %v", s.ReadFrequency)
+	}
+	if s.FastReadFrequency == nil || s.FastReadFrequency.Name != "33MHz" {
+		t.Errorf("expected 33MHz fast reads, got %v", s.FastReadFrequency)
+	}
+	if s.BootGuardProfile == nil || *s.BootGuardProfile != BootGuardProfileFVME {
+		t.Fatalf("expected the FVME Boot Guard profile, got %v", s.BootGuardProfile)
+	}
+	if !s.BootGuardProfile.Verified() || !s.BootGuardProfile.Measured() {
+		t.Errorf("expected FVME to enable verified and measured boot")
+	}
+	j, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"BootGuardProfile":"FVME"`, `"FastReadFrequency":{"Value":1,"Name":"33MHz"}`} {
+		if !strings.Contains(string(j), want) {
+			t.Errorf("expected %s in the JSON, got %s", want, j)
+		}
+	}
+
+	// Without fast read support there is no fast read frequency.
+	binary.LittleEndian.PutUint32(buf[0x30:], SPIFrequency33MHz<<21)
+	fd = FlashDescriptor{buf: buf}
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		t.Fatal(err)
+	}
+	if fd.Straps.FastReadFrequency != nil {
+		t.Errorf("expected no fast read frequency, got %v", fd.Straps.FastReadFrequency)
+	}
+}
+
+func TestBootGuardProfile(t *testing.T) {
+	var tests = []struct {
+		p                  BootGuardProfile
+		verified, measured bool
+	}{
+		{BootGuardProfileNoFVME, false, false},
+		{BootGuardProfileVE, true, false},
+		{BootGuardProfileVME, true, true},
+		{BootGuardProfileVM, true, true},
+		{BootGuardProfileFVE, true, false},
+		{BootGuardProfileFVME, true, true},
+		{BootGuardProfile(7), false, false},
+	}
+	for _, test := range tests {
+		if test.p.Verified() != test.verified || test.p.Measured() != test.measured {
+			t.Errorf("%v: expected verified %v and measured %v", test.p, test.verified, test.measured)
+		}
+	}
+}
+
+func TestFlashStrapsOutOfBounds(t *testing.T) {
+	m := &FlashDescriptorMap{PchStrapsBase: 0xff, NumberOfPchStraps: 8}
+	if _, err := NewFlashStraps(make([]byte, FlashDescriptorLength), m, ChipsetPCH); err == nil {
+		t.Error("expected an error for straps past the end of the descriptor")
+	}
+}
+
+func TestFlashRegionOutOfBounds(t *testing.T) {
+	buf := append(makeDescriptor(false), make([]byte, RegionBlockSize)...)
+	// The BIOS region claims 16 blocks, the image only has one after the
+	// descriptor.
+	copy(buf[0x44:], []byte{0x01, 0x00, 0x10, 0x00})
+	if _, err := NewFlashImage(buf); err == nil {
+		t.Error("expected an error for a region past the end of the image")
+	}
+}
+
+func TestVSCC(t *testing.T) {
+	buf := makeDescriptor(false)
+	// One entry at 0xdf0.
+	binary.LittleEndian.PutUint32(buf[FlashUpperMap1Offset:], 0x2df)
+	binary.LittleEndian.PutUint32(buf[0xdf0:], 0x1740ef) // Winbond W25Q64
+	binary.LittleEndian.PutUint32(buf[0xdf4:], 0x20052005)
+	fd := FlashDescriptor{buf: buf}
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fd.VSCC) != 1 {
+		t.Fatalf("expected one VSCC entry, got %v", fd.VSCC)
+	}
+	want := VSCCConfig{BlockEraseSize: 1, WriteGranularity64: true, EraseOpcode: 0x20}
+	if e := fd.VSCC[0]; e.VendorID != 0xef || e.DeviceID != 0x1740 || e.Lower != want || e.Upper != want {
+		t.Errorf("unexpected VSCC entry %+v", e)
+	}
+
+	if err := fd.AddVSCCEntry(NewVSCCEntry(0x1840c2, 0x20052005)); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.AddVSCCEntry(NewVSCCEntry(0x1840c2, 0x20052005)); err == nil {
+		t.Error("expected an error adding a duplicate entry")
+	}
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fd.VSCC) != 2 || fd.VSCC[1].VendorID != 0xc2 {
+		t.Errorf("expected the added entry to be parsed, got %v", fd.VSCC)
+	}
+}
+
+func TestFlashRegionCount(t *testing.T) {
+	var tests = []struct {
+		name       string
+		masterBase uint8
+		ec         bool
+	}{
+		// The master section follows the first 8 regions.
+		{"v1", 0x06, false},
+		{"v2", 0x08, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := makeDescriptor(false)
+			buf[0x18] = test.masterBase
+			// FLREG8 or FLMSTR1
+			binary.LittleEndian.PutUint32(buf[0x60:], 0x00200010)
+			fd := FlashDescriptor{buf: buf}
+			if err := fd.ParseFlashDescriptor(); err != nil {
+				t.Fatal(err)
+			}
+			if ec := fd.Region.EC.Valid(); ec != test.ec {
+				t.Errorf("expected EC region %v, got %v", test.ec, fd.Region.EC)
+			}
+		})
+	}
+}
+
+func TestSetRegion(t *testing.T) {
+	fd := FlashDescriptor{buf: makeDescriptor(false)}
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.SetRegion(5, Region{Base: 2, Limit: 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		t.Fatal(err)
+	}
+	if fd.Region.DevExp1 != (Region{Base: 2, Limit: 3}) {
+		t.Errorf("expected region 5 at [0x2, 0x3), got %v", &fd.Region.DevExp1)
+	}
+	if n := fd.DescriptorMap.NumberOfRegions & 7; n != 5 {
+		t.Errorf("expected the descriptor map to count 6 regions, got NR %d", n)
+	}
+
+	if err := fd.SetRegion(5, Region{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		t.Fatal(err)
+	}
+	if fd.Region.DevExp1.Valid() {
+		t.Errorf("expected region 5 to be unused, got %v", &fd.Region.DevExp1)
+	}
+
+	if err := fd.SetRegion(RegionDescriptor, Region{Base: 2, Limit: 3}); err == nil {
+		t.Error("expected an error setting the descriptor region")
+	}
+	// The master section follows the first 8 regions.
+	fd.buf[0x18] = 0x06
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		t.Fatal(err)
+	}
+	if err := fd.SetRegion(RegionEC, Region{Base: 2, Limit: 3}); err == nil {
+		t.Error("expected an error for a region beyond the region section")
+	}
+}
+
+func TestIFDVersion(t *testing.T) {
+	var tests = []struct {
+		name     string
+		flcomp   uint32
+		flmstr1  uint32
+		version  IFDVersion
+		density  uint64
+		readFreq string
+		family   ChipsetFamily
+	}{
+		// 20MHz read clock, 8MiB component
+		{"v1", 0x00000004, 0x0a0b0000, IFDVersion1, 8 << 20, "20MHz", ChipsetPCH},
+		// 17MHz read clock, 32MiB component
+		{"v2", 0x000c0006, 0x00a00b00, IFDVersion2, 32 << 20, "17MHz", ChipsetPCH2},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf := makeDescriptor(false)
+			binary.LittleEndian.PutUint32(buf[0x30:], test.flcomp)
+			binary.LittleEndian.PutUint32(buf[0x60:], test.flmstr1)
+			fd := FlashDescriptor{buf: buf}
+			if err := fd.ParseFlashDescriptor(); err != nil {
+				t.Fatal(err)
+			}
+			if fd.Version != test.version {
+				t.Errorf("expected version %d, got %d", test.version, fd.Version)
+			}
+			if c := fd.Component; c.Density[0] != test.density || c.ReadClockFrequency.Name != test.readFreq {
+				t.Errorf("expected density %#x at %v, got %#x at %v", test.density, test.readFreq, c.Density[0], c.ReadClockFrequency.Name)
+			}
+			if fd.Straps.Family != test.family {
+				t.Errorf("expected chipset family %v, got %v", test.family, fd.Straps.Family)
+			}
+			// The BIOS may read the descriptor, BIOS and GbE regions and write
+			// BIOS and GbE in both layouts.
+			bios := fd.Master.BIOS
+			for i, want := range []bool{true, true, false, true} {
+				if bios.CanRead(i) != want || bios.CanWrite(i) != (want && i != 0) {
+					t.Errorf("unexpected access to region %d: %+v", i, bios)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateFlashDescriptor(t *testing.T) {
+	regions := map[int]Region{
+		RegionME:   {Base: 0x01, Limit: 0x7f},
+		RegionBIOS: {Base: 0x80, Limit: 0xff},
+	}
+	buf, err := CreateFlashDescriptor(1<<20, regions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd := FlashDescriptor{buf: buf}
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		t.Fatal(err)
+	}
+	if fd.Version != IFDVersion1 || fd.Component.Density[0] != 1<<20 {
+		t.Errorf("expected a version 1 descriptor of a 1MiB chip, got version %d of %#x bytes", fd.Version, fd.Component.Density[0])
+	}
+	if got := fd.Region.ValidRegions(); fmt.Sprint(got) != "[BIOS Intel ME]" {
+		t.Errorf("expected the BIOS and ME regions, got %v", got)
+	}
+	if fd.Region.BIOS != regions[RegionBIOS] || fd.Region.ME != regions[RegionME] {
+		t.Errorf("unexpected regions %v", fd.Region)
+	}
+	if !fd.Master.BIOS.CanWrite(RegionME) {
+		t.Error("expected the BIOS to have access to all regions")
+	}
+
+	if _, err := CreateFlashDescriptor(3<<20, regions); err == nil {
+		t.Error("expected an error for a 3MiB chip")
+	}
+	if _, err := CreateFlashDescriptor(512*1024, regions); err == nil {
+		t.Error("expected an error for regions past the end of the chip")
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+)
+
+// FlashComponentSectionSize is the size of the component section, made up of
+// the FLCOMP, FLILL and FLPB registers.
+const FlashComponentSectionSize = 12
+
+// IFDVersion is the layout version of the flash descriptor. Version 2 was
+// introduced with Skylake and changed the encoding of densities, frequencies,
+// master access and region limits.
+type IFDVersion uint8
+
+// Descriptor versions
+const (
+	IFDVersion1 IFDVersion = 1
+	IFDVersion2 IFDVersion = 2
+)
+
+// SPI frequency encodings
+const (
+	SPIFrequency20MHz      = 0
+	SPIFrequency33MHz      = 1
+	SPIFrequency48MHz      = 2
+	SPIFrequency50MHz30MHz = 4
+	SPIFrequency17MHz      = 6
+)
+
+// SPIFrequency is an SPI clock frequency field of FLCOMP.
+type SPIFrequency struct {
+	Value uint8
+	// Name is the frequency, which depends on the descriptor version.
+	Name string
+}
+
+func newSPIFrequency(v uint8, version IFDVersion) SPIFrequency {
+	f := SPIFrequency{Value: v}
+	switch v {
+	case SPIFrequency20MHz:
+		f.Name = "20MHz"
+	case SPIFrequency33MHz:
+		f.Name = "33MHz"
+	case SPIFrequency48MHz:
+		f.Name = "48MHz"
+	case SPIFrequency50MHz30MHz:
+		f.Name = "50MHz"
+		if version == IFDVersion2 {
+			f.Name = "30MHz"
+		}
+	case SPIFrequency17MHz:
+		f.Name = "17MHz"
+	default:
+		f.Name = fmt.Sprintf("unknown (%d)", v)
+	}
+	return f
+}
+
+// FlashComponentSection holds the flash component parameters of the descriptor.
+type FlashComponentSection struct {
+	FLCOMP uint32
+	FLILL  uint32
+	FLPB   uint32
+
+	// Decoded from FLCOMP. Densities are in bytes, 0 if the component is not
+	// present or the encoding is unknown.
+	Density               [2]uint64
+	ReadClockFrequency    SPIFrequency
+	FastReadSupport       bool
+	FastReadFrequency     SPIFrequency
+	WriteEraseFrequency   SPIFrequency
+	ReadIDStatusFrequency SPIFrequency
+	DualOutputFastRead    bool
+}
+
+// DetectIFDVersion tells the descriptor version from the read clock
+// frequency, which is 20MHz on all version 1 descriptors and 17MHz or 30MHz
+// on version 2. Unknown frequencies are treated as version 1.
+func DetectIFDVersion(flcomp uint32) IFDVersion {
+	switch (flcomp >> 17) & 7 {
+	case SPIFrequency20MHz:
+		return IFDVersion1
+	case SPIFrequency50MHz30MHz, SPIFrequency17MHz:
+		return IFDVersion2
+	}
+	log.Printf("unknown read clock frequency %d in FLCOMP %#08x, assuming IFD version 1", (flcomp>>17)&7, flcomp)
+	return IFDVersion1
+}
+
+// density decodes a component density field.
+func density(v uint32, version IFDVersion) uint64 {
+	max := uint32(5) // 16MiB
+	if version == IFDVersion2 {
+		max = 7 // 64MiB
+	}
+	if v > max {
+		return 0
+	}
+	return 512 * 1024 << v
+}
+
+// NewFlashComponentSection parses the component section of a descriptor of
+// the given version.
+func NewFlashComponentSection(buf []byte, version IFDVersion) (*FlashComponentSection, error) {
+	if len(buf) < FlashComponentSectionSize {
+		return nil, fmt.Errorf("Flash Component Section size too small: expected %v bytes, got %v",
+			FlashComponentSectionSize,
+			len(buf),
+		)
+	}
+	c := FlashComponentSection{
+		FLCOMP: binary.LittleEndian.Uint32(buf),
+		FLILL:  binary.LittleEndian.Uint32(buf[4:]),
+		FLPB:   binary.LittleEndian.Uint32(buf[8:]),
+	}
+	flcomp := c.FLCOMP
+	// Version 2 uses 4 bits per component instead of 3.
+	bits, mask := uint(3), uint32(7)
+	if version == IFDVersion2 {
+		bits, mask = 4, 0xf
+	}
+	c.Density[0] = density(flcomp&mask, version)
+	c.Density[1] = density((flcomp>>bits)&mask, version)
+	c.ReadClockFrequency = newSPIFrequency(uint8(flcomp>>17)&7, version)
+	c.FastReadSupport = flcomp&(1<<20) != 0
+	c.FastReadFrequency = newSPIFrequency(uint8(flcomp>>21)&7, version)
+	c.WriteEraseFrequency = newSPIFrequency(uint8(flcomp>>24)&7, version)
+	c.ReadIDStatusFrequency = newSPIFrequency(uint8(flcomp>>27)&7, version)
+	c.DualOutputFastRead = flcomp&(1<<30) != 0
+	return &c, nil
+}
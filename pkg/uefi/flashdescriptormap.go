@@ -45,14 +45,14 @@ type FlashDescriptorMap struct {
 
 // NewFlashDescriptorMap initializes a FlashDescriptor from a slice of bytes.
 func NewFlashDescriptorMap(buf []byte) (*FlashDescriptorMap, error) {
-	if len(buf) < FlashDescriptorMapSize {
+	var descriptor FlashDescriptorMap
+	if len(buf) < binary.Size(descriptor) {
 		return nil, fmt.Errorf("Flash Descriptor Map size too small: expected %v bytes, got %v",
-			FlashDescriptorMapSize,
+			binary.Size(descriptor),
 			len(buf),
 		)
 	}
 	r := bytes.NewReader(buf)
-	var descriptor FlashDescriptorMap
 	if err := binary.Read(r, binary.LittleEndian, &descriptor); err != nil {
 		return nil, err
 	}
@@ -5,7 +5,6 @@
 package uefi
 
 import (
-	"bytes"
 	"encoding/binary"
 	"fmt"
 )
@@ -14,10 +13,44 @@ import (
 const FlashMasterSectionSize = 12
 
 // RegionPermissions holds the read/write permissions for other regions.
+// ID, Read and Write are the fields of the version 1 layout.
 type RegionPermissions struct {
 	ID    uint16
 	Read  uint8
 	Write uint8
+
+	// ReadAccess and WriteAccess have bit i set if the master may access
+	// region i, decoded according to the descriptor version.
+	ReadAccess  uint16
+	WriteAccess uint16
+}
+
+func newRegionPermissions(v uint32, version IFDVersion) RegionPermissions {
+	r := RegionPermissions{
+		ID:    uint16(v),
+		Read:  uint8(v >> 16),
+		Write: uint8(v >> 24),
+	}
+	if version == IFDVersion2 {
+		// Regions 0-11 are in the upper 24 bits, the extended access bits for
+		// regions 12-15 are in the lowest byte.
+		r.ReadAccess = uint16((v>>8)&0xfff) | uint16(v&0xf)<<12
+		r.WriteAccess = uint16((v>>20)&0xfff) | uint16((v>>4)&0xf)<<12
+	} else {
+		r.ReadAccess = uint16(r.Read)
+		r.WriteAccess = uint16(r.Write)
+	}
+	return r
+}
+
+// CanRead returns whether the master may read region i.
+func (r *RegionPermissions) CanRead(i int) bool {
+	return i >= 0 && i < 16 && r.ReadAccess&(1<<uint(i)) != 0
+}
+
+// CanWrite returns whether the master may write region i.
+func (r *RegionPermissions) CanWrite(i int) bool {
+	return i >= 0 && i < 16 && r.WriteAccess&(1<<uint(i)) != 0
 }
 
 func (r *RegionPermissions) String() string {
@@ -39,18 +72,19 @@ func (m *FlashMasterSection) String() string {
 }
 
 // NewFlashMasterSection parses a sequence of bytes and returns a FlashMasterSection
-// object, if a valid one is passed, or an error
-func NewFlashMasterSection(buf []byte) (*FlashMasterSection, error) {
+// object, if a valid one is passed, or an error. The access fields are decoded
+// according to the descriptor version.
+func NewFlashMasterSection(buf []byte, version IFDVersion) (*FlashMasterSection, error) {
 	if len(buf) < FlashMasterSectionSize {
 		return nil, fmt.Errorf("Flash Master Section size too small: expected %v bytes, got %v",
 			FlashMasterSectionSize,
 			len(buf),
 		)
 	}
-	var master FlashMasterSection
-	reader := bytes.NewReader(buf)
-	if err := binary.Read(reader, binary.LittleEndian, &master); err != nil {
-		return nil, err
+	master := FlashMasterSection{
+		BIOS: newRegionPermissions(binary.LittleEndian.Uint32(buf), version),
+		ME:   newRegionPermissions(binary.LittleEndian.Uint32(buf[4:]), version),
+		GBE:  newRegionPermissions(binary.LittleEndian.Uint32(buf[8:]), version),
 	}
 	return &master, nil
 }
@@ -11,8 +11,42 @@ import (
 	"strings"
 )
 
-// FlashRegionSectionSize is the size of the Region descriptor. It is made up by 18 fields, each 16-bits large.
-const FlashRegionSectionSize = 36
+// FlashRegionSectionSize is the size of the Region descriptor. It is made up by 32 fields, each 16-bits large.
+const FlashRegionSectionSize = 64
+
+// FlashRegionMaxCount is the maximum number of regions of a descriptor.
+const FlashRegionMaxCount = FlashRegionSectionSize / 4
+
+// FlashRegionNames are the names of the regions by index, as used by
+// coreboot's ifdtool.
+var FlashRegionNames = [FlashRegionMaxCount]string{
+	"Flash Descriptor",
+	"BIOS",
+	"Intel ME",
+	"GbE",
+	"Platform Data",
+	"Device Exp1",
+	"Secondary BIOS",
+	"Reserved",
+	"EC",
+	"Device Exp2",
+	"IE",
+	"10GbE_0",
+	"10GbE_1",
+	"Reserved",
+	"Reserved",
+	"PTT",
+}
+
+// Indices of the regions with dedicated types.
+const (
+	RegionDescriptor = 0
+	RegionBIOS       = 1
+	RegionME         = 2
+	RegionGBE        = 3
+	RegionPD         = 4
+	RegionEC         = 8
+)
 
 // FlashRegionSection holds the metadata of all the different flash regions like PDR, Gbe and the Bios region.
 type FlashRegionSection struct {
@@ -22,22 +56,79 @@ type FlashRegionSection struct {
 	ME                  Region
 	GBE                 Region
 	PD                  Region
+	DevExp1             Region
+	BIOS2               Region
+	Reserved1           Region
+	EC                  Region
+	DevExp2             Region
+	IE                  Region
+	TenGBE0             Region
+	TenGBE1             Region
+	Reserved2           Region
+	Reserved3           Region
+	PTT                 Region
 }
 
-// ValidRegions returns a list of names of the regions with non-zero size.
-func (f *FlashRegionSection) ValidRegions() []string {
-	var regions []string
-	if f.BIOS.Valid() {
-		regions = append(regions, "BIOS")
+// Region returns the region at index i of the region section, or nil for the
+// descriptor region and indices out of range.
+func (f *FlashRegionSection) Region(i int) *Region {
+	switch i {
+	case RegionBIOS:
+		return &f.BIOS
+	case RegionME:
+		return &f.ME
+	case RegionGBE:
+		return &f.GBE
+	case RegionPD:
+		return &f.PD
+	case 5:
+		return &f.DevExp1
+	case 6:
+		return &f.BIOS2
+	case 7:
+		return &f.Reserved1
+	case RegionEC:
+		return &f.EC
+	case 9:
+		return &f.DevExp2
+	case 10:
+		return &f.IE
+	case 11:
+		return &f.TenGBE0
+	case 12:
+		return &f.TenGBE1
+	case 13:
+		return &f.Reserved2
+	case 14:
+		return &f.Reserved3
+	case 15:
+		return &f.PTT
 	}
-	if f.ME.Valid() {
-		regions = append(regions, "ME")
+	return nil
+}
+
+// maskReserved clears the reserved upper bits of the region base and limit
+// fields. Version 1 descriptors use 13 bits, version 2 descriptors 15 bits.
+func (f *FlashRegionSection) maskReserved(version IFDVersion) {
+	mask := uint16(0x1fff)
+	if version == IFDVersion2 {
+		mask = 0x7fff
 	}
-	if f.GBE.Valid() {
-		regions = append(regions, "GbE")
+	for i := range FlashRegionNames {
+		if r := f.Region(i); r != nil {
+			r.Base &= mask
+			r.Limit &= mask
+		}
 	}
-	if f.PD.Valid() {
-		regions = append(regions, "PDR")
+}
+
+// ValidRegions returns a list of names of the regions with non-zero size.
+func (f *FlashRegionSection) ValidRegions() []string {
+	var regions []string
+	for i := range FlashRegionNames {
+		if r := f.Region(i); r != nil && r.Valid() {
+			regions = append(regions, FlashRegionNames[i])
+		}
 	}
 	return regions
 }
@@ -48,16 +139,20 @@ func (f *FlashRegionSection) String() string {
 	)
 }
 
-// NewFlashRegionSection initializes a FlashRegionSection from a slice of bytes
+// NewFlashRegionSection initializes a FlashRegionSection from a slice of bytes.
+// Only as many regions as fit into the slice are read, the others are left
+// unused.
 func NewFlashRegionSection(data []byte) (*FlashRegionSection, error) {
-	if len(data) < FlashRegionSectionSize {
-		return nil, fmt.Errorf("Flash Region Section size too small: expected %v bytes, got %v",
-			FlashRegionSectionSize,
+	if len(data) < 4*(RegionPD+1) {
+		return nil, fmt.Errorf("Flash Region Section size too small: expected at least %v bytes, got %v",
+			4*(RegionPD+1),
 			len(data),
 		)
 	}
+	buf := make([]byte, FlashRegionSectionSize)
+	copy(buf, data)
 	var region FlashRegionSection
-	reader := bytes.NewReader(data)
+	reader := bytes.NewReader(buf)
 	if err := binary.Read(reader, binary.LittleEndian, &region); err != nil {
 		return nil, err
 	}
@@ -0,0 +1,257 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// ChipsetFamily is the chipset family a flash descriptor was made for, as far
+// as it can be told from the descriptor itself. The meaning of the soft straps
+// depends on it.
+type ChipsetFamily uint8
+
+// Chipset families
+const (
+	ChipsetUnknown ChipsetFamily = iota
+	// ChipsetICH covers ICH8, ICH9 and ICH10, which have the flash signature
+	// at the very start of the descriptor.
+	ChipsetICH
+	// ChipsetPCH covers the 5 series to 9 series PCHs, which use version 1
+	// descriptors.
+	ChipsetPCH
+	// ChipsetPCH2 covers the 100 series (Skylake) and later PCHs, which use
+	// version 2 descriptors.
+	ChipsetPCH2
+)
+
+var chipsetFamilyNames = map[ChipsetFamily]string{
+	ChipsetUnknown: "Unknown",
+	ChipsetICH:     "ICH",
+	ChipsetPCH:     "PCH",
+	ChipsetPCH2:    "PCH2",
+}
+
+func (c ChipsetFamily) String() string {
+	if s, ok := chipsetFamilyNames[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("ChipsetFamily(%d)", uint8(c))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (c ChipsetFamily) MarshalText() ([]byte, error) {
+	return []byte(c.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (c *ChipsetFamily) UnmarshalText(text []byte) error {
+	for k, v := range chipsetFamilyNames {
+		if v == string(text) {
+			*c = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown chipset family %q", text)
+}
+
+// StrapField is a named field of a soft strap.
+type StrapField struct {
+	Name string
+	// Proc is set for processor (or MCH) straps, otherwise Strap indexes the
+	// PCH (or ICH) straps.
+	Proc  bool `json:",omitempty"`
+	Strap int
+	Shift uint
+	Width uint
+	Value uint32
+}
+
+func (s StrapField) String() string {
+	prefix := "PCHSTRP"
+	if s.Proc {
+		prefix = "PROCSTRP"
+	}
+	return fmt.Sprintf("%s%d[%d:%d] %s=%#x", prefix, s.Strap, s.Shift+s.Width-1, s.Shift, s.Name, s.Value)
+}
+
+// Names of strap fields with a meaning beyond their value.
+const (
+	// StrapBootGuardProfile is the field selecting the Boot Guard profile
+	// before it is fused, its value is a BootGuardProfile.
+	StrapBootGuardProfile = "BootGuardProfile"
+)
+
+// BootGuardProfile is a Boot Guard profile, numbered as in Intel's flash
+// image tool. The letters tell what it enables: Force the Boot Guard ACM,
+// Verified boot, Measured boot and Enforcement of the verification.
+type BootGuardProfile uint8
+
+// Boot Guard profiles
+const (
+	BootGuardProfileNoFVME BootGuardProfile = iota
+	BootGuardProfileVE
+	BootGuardProfileVME
+	BootGuardProfileVM
+	BootGuardProfileFVE
+	BootGuardProfileFVME
+)
+
+var bootGuardProfileNames = map[BootGuardProfile]string{
+	BootGuardProfileNoFVME: "No_FVME",
+	BootGuardProfileVE:     "VE",
+	BootGuardProfileVME:    "VME",
+	BootGuardProfileVM:     "VM",
+	BootGuardProfileFVE:    "FVE",
+	BootGuardProfileFVME:   "FVME",
+}
+
+func (p BootGuardProfile) String() string {
+	if s, ok := bootGuardProfileNames[p]; ok {
+		return s
+	}
+	return fmt.Sprintf("BootGuardProfile(%d)", uint8(p))
+}
+
+// MarshalText implements the encoding.TextMarshaler interface.
+func (p BootGuardProfile) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (p *BootGuardProfile) UnmarshalText(text []byte) error {
+	for k, v := range bootGuardProfileNames {
+		if v == string(text) {
+			*p = k
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown Boot Guard profile %q", text)
+}
+
+// Verified returns whether the profile enables verified boot.
+func (p BootGuardProfile) Verified() bool {
+	return p >= BootGuardProfileVE && p <= BootGuardProfileFVME
+}
+
+// Measured returns whether the profile enables measured boot.
+func (p BootGuardProfile) Measured() bool {
+	return p == BootGuardProfileVME || p == BootGuardProfileVM || p == BootGuardProfileFVME
+}
+
+// strapFields lists the fields of the soft straps with a publicly documented
+// meaning, by chipset family. These are the ME disable straps also used by
+// me_cleaner. The other fields, including the Boot Guard profile straps,
+// are only described in Intel's SPI programming guides, which are not
+// public. They are kept as the raw dwords of FlashStraps.PCH and
+// FlashStraps.Proc, unless the caller adds them with AddStrapField.
+var strapFields = map[ChipsetFamily][]StrapField{
+	ChipsetICH: {
+		{Name: "ICH_MeDisable", Strap: 0, Shift: 0, Width: 1},
+		{Name: "MCH_MeDisable", Proc: true, Strap: 0, Shift: 0, Width: 1},
+	},
+	ChipsetPCH: {
+		{Name: "AltMeDisable", Strap: 10, Shift: 7, Width: 1},
+	},
+	ChipsetPCH2: {
+		{Name: "HAP", Strap: 0, Shift: 16, Width: 1},
+	},
+}
+
+// AddStrapField adds a field to the straps decoded for a chipset family, e.g.
+// the StrapBootGuardProfile field from the SPI programming guide of the
+// platform. The Name, Proc, Strap, Shift and Width of f are used. Descriptors
+// parsed afterwards decode it.
+func AddStrapField(family ChipsetFamily, f StrapField) {
+	f.Value = 0
+	strapFields[family] = append(strapFields[family], f)
+}
+
+// FlashStraps holds the PCH and processor soft straps of a flash descriptor.
+// The straps configure the chipset before any firmware runs.
+type FlashStraps struct {
+	Family ChipsetFamily
+	// PCH and Proc are the raw strap dwords, all of them, whether a field
+	// of them is decoded or not.
+	PCH  []uint32
+	Proc []uint32
+	// Fields are the decoded fields with known meaning, only a few of the
+	// straps have one, see strapFields.
+	Fields []StrapField `json:",omitempty"`
+	// BootGuardProfile is decoded from the StrapBootGuardProfile field, it
+	// is nil if the field is not known for the family.
+	BootGuardProfile *BootGuardProfile `json:",omitempty"`
+
+	// ReadFrequency and FastReadFrequency are the SPI clock frequencies of
+	// FLCOMP. They are not straps, but configure the SPI controller along
+	// with them, so they are audited together. FastReadFrequency is nil if
+	// the flash does not support fast reads.
+	ReadFrequency     *SPIFrequency `json:",omitempty"`
+	FastReadFrequency *SPIFrequency `json:",omitempty"`
+}
+
+// readStraps reads n strap dwords starting at base.
+func readStraps(buf []byte, base uint, n uint, name string) ([]uint32, error) {
+	end := base + n*4
+	if end > uint(len(buf)) {
+		return nil, fmt.Errorf("%d %s straps at %#x do not fit into the %#x bytes flash descriptor", n, name, base, len(buf))
+	}
+	straps := make([]uint32, n)
+	for i := range straps {
+		straps[i] = binary.LittleEndian.Uint32(buf[base+uint(i)*4:])
+	}
+	return straps, nil
+}
+
+// NewFlashStraps reads the soft straps of a flash descriptor and decodes the
+// fields known for the chipset family.
+func NewFlashStraps(buf []byte, m *FlashDescriptorMap, family ChipsetFamily) (*FlashStraps, error) {
+	pch, err := readStraps(buf, uint(m.PchStrapsBase)*0x10, uint(m.NumberOfPchStraps), "PCH")
+	if err != nil {
+		return nil, err
+	}
+	proc, err := readStraps(buf, uint(m.ProcStrapsBase)*0x10, uint(m.NumberOfProcStraps), "processor")
+	if err != nil {
+		return nil, err
+	}
+	s := &FlashStraps{Family: family, PCH: pch, Proc: proc}
+	for _, f := range strapFields[family] {
+		straps := s.PCH
+		if f.Proc {
+			straps = s.Proc
+		}
+		if f.Strap >= len(straps) {
+			continue
+		}
+		f.Value = (straps[f.Strap] >> f.Shift) & (1<<f.Width - 1)
+		s.Fields = append(s.Fields, f)
+		if f.Name == StrapBootGuardProfile {
+			p := BootGuardProfile(f.Value)
+			s.BootGuardProfile = &p
+		}
+	}
+	return s, nil
+}
+
+// setFrequencies sets the SPI frequencies from the component section.
+func (s *FlashStraps) setFrequencies(c *FlashComponentSection) {
+	read := c.ReadClockFrequency
+	s.ReadFrequency = &read
+	if c.FastReadSupport {
+		fast := c.FastReadFrequency
+		s.FastReadFrequency = &fast
+	}
+}
+
+// Field returns the decoded field with the given name.
+func (s *FlashStraps) Field(name string) (StrapField, bool) {
+	for _, f := range s.Fields {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return StrapField{}, false
+}
@@ -0,0 +1,136 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// FlashUpperMap1Offset is the offset of FLUMAP1 in the descriptor, which
+	// locates the VSCC table.
+	FlashUpperMap1Offset = 0xefc
+	// VSCCEntrySize is the size of a VSCC table entry, a JEDEC ID and VSCC
+	// dword pair.
+	VSCCEntrySize = 8
+)
+
+// VSCCConfig holds the vendor specific component capabilities of a flash chip
+// for the lower or upper flash partition, telling the controller how to erase
+// and write it.
+type VSCCConfig struct {
+	// BlockEraseSize encodes the erase size: 0 is 256 bytes, 1 is 4KiB and 3
+	// is 64KiB.
+	BlockEraseSize uint8
+	// WriteGranularity64 is set if the chip writes 64 byte pages, otherwise
+	// single bytes are written.
+	WriteGranularity64       bool
+	WriteStatusRequired      bool
+	WriteEnableOnWriteStatus bool
+	EraseOpcode              uint8
+}
+
+func decodeVSCC(v uint16) VSCCConfig {
+	return VSCCConfig{
+		BlockEraseSize:           uint8(v & 3),
+		WriteGranularity64:       v&(1<<2) != 0,
+		WriteStatusRequired:      v&(1<<3) != 0,
+		WriteEnableOnWriteStatus: v&(1<<4) != 0,
+		EraseOpcode:              uint8(v >> 8),
+	}
+}
+
+// VSCCEntry is an entry of the VSCC table, which lists the SPI flash chips
+// the ME firmware supports.
+type VSCCEntry struct {
+	JEDECID  uint32
+	VSCC     uint32
+	VendorID uint8
+	DeviceID uint16
+	Lower    VSCCConfig
+	Upper    VSCCConfig
+}
+
+// NewVSCCEntry decodes a JEDEC ID and VSCC dword pair.
+func NewVSCCEntry(jedecID, vscc uint32) VSCCEntry {
+	return VSCCEntry{
+		JEDECID:  jedecID,
+		VSCC:     vscc,
+		VendorID: uint8(jedecID),
+		DeviceID: uint16(jedecID >> 8),
+		Lower:    decodeVSCC(uint16(vscc)),
+		Upper:    decodeVSCC(uint16(vscc >> 16)),
+	}
+}
+
+func (e VSCCEntry) String() string {
+	return fmt.Sprintf("VSCCEntry{Vendor=%#02x, Device=%#04x, VSCC=%#08x}", e.VendorID, e.DeviceID, e.VSCC)
+}
+
+// vsccTable returns the offset and the number of entries of the VSCC table.
+func (fd *FlashDescriptor) vsccTable() (uint, uint, error) {
+	if len(fd.buf) < FlashUpperMap1Offset+4 {
+		return 0, 0, fmt.Errorf("flash descriptor too short for FLUMAP1: %#x bytes", len(fd.buf))
+	}
+	flumap1 := binary.LittleEndian.Uint32(fd.buf[FlashUpperMap1Offset:])
+	base := uint(flumap1&0xff) * 0x10
+	// The length counts dwords.
+	n := uint((flumap1>>8)&0xff) / 2
+	if base+n*VSCCEntrySize > FlashUpperMap1Offset {
+		return 0, 0, fmt.Errorf("VSCC table with %d entries at %#x overlaps FLUMAP1", n, base)
+	}
+	return base, n, nil
+}
+
+// parseVSCC reads the VSCC table.
+func (fd *FlashDescriptor) parseVSCC() ([]VSCCEntry, error) {
+	base, n, err := fd.vsccTable()
+	if err != nil {
+		return nil, err
+	}
+	var entries []VSCCEntry
+	for i := uint(0); i < n; i++ {
+		e := fd.buf[base+i*VSCCEntrySize:]
+		entries = append(entries, NewVSCCEntry(binary.LittleEndian.Uint32(e), binary.LittleEndian.Uint32(e[4:])))
+	}
+	return entries, nil
+}
+
+// AddVSCCEntry appends an entry for a new flash chip to the VSCC table. The
+// space after the table has to be unused.
+func (fd *FlashDescriptor) AddVSCCEntry(e VSCCEntry) error {
+	base, n, err := fd.vsccTable()
+	if err != nil {
+		return err
+	}
+	if base == 0 {
+		return fmt.Errorf("flash descriptor has no VSCC table")
+	}
+	for _, old := range fd.VSCC {
+		if old.JEDECID == e.JEDECID {
+			return fmt.Errorf("VSCC table already has an entry for JEDEC ID %#06x", e.JEDECID)
+		}
+	}
+	if n >= 0x7f {
+		return fmt.Errorf("VSCC table is full")
+	}
+	off := base + n*VSCCEntrySize
+	if off+VSCCEntrySize > FlashUpperMap1Offset {
+		return fmt.Errorf("no space for another VSCC entry at %#x", off)
+	}
+	for _, b := range fd.buf[off : off+VSCCEntrySize] {
+		if b != 0xff && b != 0 {
+			return fmt.Errorf("space after the VSCC table at %#x is in use", off)
+		}
+	}
+	buf := append([]byte{}, fd.buf...)
+	binary.LittleEndian.PutUint32(buf[off:], e.JEDECID)
+	binary.LittleEndian.PutUint32(buf[off+4:], e.VSCC)
+	buf[FlashUpperMap1Offset+1] = uint8((n + 1) * 2)
+	fd.buf = buf
+	fd.VSCC = append(fd.VSCC, NewVSCCEntry(e.JEDECID, e.VSCC))
+	return nil
+}
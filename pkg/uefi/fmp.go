@@ -0,0 +1,246 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// FMP capsules, as shipped by fwupd and Windows Update, hold the
+// EFI_FIRMWARE_MANAGEMENT_CAPSULE_HEADER after the capsule header. It is
+// followed by the offsets of any embedded drivers and of the images, each of
+// which has an EFI_FIRMWARE_MANAGEMENT_CAPSULE_IMAGE_HEADER. Signed images
+// start with an EFI_FIRMWARE_IMAGE_AUTHENTICATION.
+
+const (
+	fmpCapsuleHeaderLength = 8
+	// fmpImageSizeOffset is the offset of UpdateImageSize in the image header.
+	fmpImageSizeOffset = 24
+	// WIN_CERTIFICATE values of the EFI_FIRMWARE_IMAGE_AUTHENTICATION.
+	winCertRevision    = 0x0200
+	winCertTypeEFIGUID = 0x0EF1
+)
+
+// FMPCapsuleHeader is the EFI_FIRMWARE_MANAGEMENT_CAPSULE_HEADER.
+type FMPCapsuleHeader struct {
+	Version             uint32
+	EmbeddedDriverCount uint16
+	PayloadItemCount    uint16
+}
+
+// FMPImageHeader is the EFI_FIRMWARE_MANAGEMENT_CAPSULE_IMAGE_HEADER. The
+// last fields only exist in later versions of the header.
+type FMPImageHeader struct {
+	Version                uint32
+	UpdateImageTypeID      uuid.UUID
+	UpdateImageIndex       uint8
+	_                      [3]uint8
+	UpdateImageSize        uint32
+	UpdateVendorCodeSize   uint32
+	UpdateHardwareInstance uint64 `json:",omitempty"`
+	ImageCapsuleSupport    uint64 `json:",omitempty"`
+}
+
+// fmpImageHeaderLen returns the length of the image header of the version.
+func fmpImageHeaderLen(version uint32) uint64 {
+	switch version {
+	case 1:
+		return 32
+	case 2:
+		return 40
+	}
+	return 48
+}
+
+// FMPImage is an image in an FMP capsule. The image is parsed as the Payload
+// if possible, otherwise it is kept as it is.
+type FMPImage struct {
+	Header FMPImageHeader
+	// Index is the index of the image in the capsule.
+	Index int
+	// Offset is the offset of the image in the capsule.
+	Offset    uint64
+	HeaderLen uint64
+	// AuthLen is the length of the EFI_FIRMWARE_IMAGE_AUTHENTICATION in
+	// front of the image, it is 0 if the image is not signed. The
+	// signature is not updated when the image is modified.
+	AuthLen uint64
+	Payload *TypedFirmware `json:",omitempty"`
+
+	// Metadata
+	ExtractPath string
+	Location
+
+	buf []byte
+}
+
+// fmpAuthLen returns the length of the EFI_FIRMWARE_IMAGE_AUTHENTICATION at
+// the start of image, or 0 if there is none.
+func fmpAuthLen(image []byte) uint64 {
+	// MonotonicCount followed by WIN_CERTIFICATE_UEFI_GUID.
+	if len(image) < 8+24 {
+		return 0
+	}
+	length := uint64(binary.LittleEndian.Uint32(image[8:]))
+	if binary.LittleEndian.Uint16(image[12:]) != winCertRevision ||
+		binary.LittleEndian.Uint16(image[14:]) != winCertTypeEFIGUID ||
+		length < 24 || 8+length > uint64(len(image)) {
+		return 0
+	}
+	return 8 + length
+}
+
+// NewFMPImage parses an FMP capsule image, buf may extend past its vendor code.
+func NewFMPImage(buf []byte) (*FMPImage, error) {
+	img := FMPImage{buf: buf}
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("FMP image too small")
+	}
+	img.HeaderLen = fmpImageHeaderLen(binary.LittleEndian.Uint32(buf))
+	if uint64(len(buf)) < img.HeaderLen {
+		return nil, fmt.Errorf("FMP image of %#x bytes is smaller than its header", len(buf))
+	}
+	// Only the fields of the header version are read.
+	h := make([]byte, binary.Size(img.Header))
+	copy(h, buf[:img.HeaderLen])
+	if err := binary.Read(bytes.NewReader(h), binary.LittleEndian, &img.Header); err != nil {
+		return nil, err
+	}
+	end := img.HeaderLen + uint64(img.Header.UpdateImageSize)
+	if end+uint64(img.Header.UpdateVendorCodeSize) > uint64(len(buf)) {
+		return nil, fmt.Errorf("FMP image of %#x bytes with %#x bytes vendor code does not fit into %#x bytes",
+			img.Header.UpdateImageSize, img.Header.UpdateVendorCodeSize, len(buf)-int(img.HeaderLen))
+	}
+	image := buf[img.HeaderLen:end]
+	img.AuthLen = fmpAuthLen(image)
+	payload, err := Parse(image[img.AuthLen:])
+	if err != nil {
+		log.Printf("unable to parse FMP image %v, keeping it as it is: %v", img.Header.UpdateImageTypeID, err)
+		return &img, nil
+	}
+	img.Payload = MakeTyped(payload)
+	return &img, nil
+}
+
+// SetPayloadBuf replaces the image, after the authentication, with buf and
+// updates the image size in the header.
+func (img *FMPImage) SetPayloadBuf(buf []byte) {
+	start := img.HeaderLen + img.AuthLen
+	end := img.HeaderLen + uint64(img.Header.UpdateImageSize)
+	nb := append(append(append([]byte{}, img.buf[:start]...), buf...), img.buf[end:]...)
+	img.Header.UpdateImageSize = uint32(img.AuthLen) + uint32(len(buf))
+	binary.LittleEndian.PutUint32(nb[fmpImageSizeOffset:], img.Header.UpdateImageSize)
+	img.buf = nb
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (img *FMPImage) Buf() []byte {
+	return img.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (img *FMPImage) SetBuf(buf []byte) {
+	img.buf = buf
+}
+
+// Apply calls the visitor on the FMPImage.
+func (img *FMPImage) Apply(v Visitor) error {
+	return v.Visit(img)
+}
+
+// ApplyChildren calls the visitor on the payload of the FMPImage.
+func (img *FMPImage) ApplyChildren(v Visitor) error {
+	if img.Payload == nil {
+		return nil
+	}
+	return img.Payload.Value.Apply(v)
+}
+
+// Validate checks the image size and the payload.
+func (img *FMPImage) Validate() []error {
+	var errs []error
+	if end := img.HeaderLen + uint64(img.Header.UpdateImageSize) + uint64(img.Header.UpdateVendorCodeSize); end > uint64(len(img.buf)) {
+		errs = append(errs, fmt.Errorf("FMP image %v ends at %#x, past the end of its %#x bytes",
+			img.Header.UpdateImageTypeID, end, len(img.buf)))
+	}
+	if img.Payload != nil {
+		errs = append(errs, img.Payload.Value.Validate()...)
+	}
+	return errs
+}
+
+// parseFMP parses the FMP header and the images of an FMP capsule.
+func (c *Capsule) parseFMP() error {
+	body := c.buf[c.PayloadOffset : c.PayloadOffset+c.PayloadLength]
+	var h FMPCapsuleHeader
+	if len(body) < fmpCapsuleHeaderLength {
+		return fmt.Errorf("FMP capsule header too short")
+	}
+	if err := binary.Read(bytes.NewReader(body), binary.LittleEndian, &h); err != nil {
+		return err
+	}
+	n := int(h.EmbeddedDriverCount) + int(h.PayloadItemCount)
+	listEnd := uint64(fmpCapsuleHeaderLength + 8*n)
+	if listEnd > uint64(len(body)) {
+		return fmt.Errorf("%d FMP item offsets do not fit into the %#x bytes capsule", n, len(body))
+	}
+	offsets := make([]uint64, n)
+	prev := listEnd
+	for i := range offsets {
+		offsets[i] = binary.LittleEndian.Uint64(body[fmpCapsuleHeaderLength+8*i:])
+		if offsets[i] < prev || offsets[i] > uint64(len(body)) {
+			return fmt.Errorf("FMP item %d at %#x is out of order or out of range", i, offsets[i])
+		}
+		prev = offsets[i]
+	}
+	var images []*FMPImage
+	for i := int(h.EmbeddedDriverCount); i < n; i++ {
+		end := uint64(len(body))
+		if i+1 < n {
+			end = offsets[i+1]
+		}
+		img, err := NewFMPImage(body[offsets[i]:end])
+		if err != nil {
+			return fmt.Errorf("FMP image %d: %v", i-int(h.EmbeddedDriverCount), err)
+		}
+		img.Index = len(images)
+		img.Offset = c.PayloadOffset + offsets[i]
+		images = append(images, img)
+	}
+	c.FMPHeader = &h
+	c.FMPItemOffsets = offsets
+	c.FMPImages = images
+	return nil
+}
+
+// AssembleFMP rebuilds the body of an FMP capsule from the buffers of its
+// images, which are placed back to back after the embedded drivers.
+func (c *Capsule) AssembleFMP() {
+	if c.FMPHeader == nil {
+		return
+	}
+	body := c.buf[c.PayloadOffset : c.PayloadOffset+c.PayloadLength]
+	drivers := int(c.FMPHeader.EmbeddedDriverCount)
+	imagesStart := uint64(len(body))
+	if len(c.FMPImages) != 0 {
+		imagesStart = c.FMPItemOffsets[drivers]
+	}
+	nb := append([]byte{}, body[:imagesStart]...)
+	for i, img := range c.FMPImages {
+		off := uint64(len(nb))
+		c.FMPItemOffsets[drivers+i] = off
+		binary.LittleEndian.PutUint64(nb[fmpCapsuleHeaderLength+8*(drivers+i):], off)
+		img.Offset = c.PayloadOffset + off
+		nb = append(nb, img.Buf()...)
+	}
+	c.SetPayloadBuf(nb)
+}
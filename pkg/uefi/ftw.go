@@ -0,0 +1,249 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// The fault tolerant write (FTW) driver of EDK2 keeps a working block in the
+// NVRAM FV, after the variable store, followed by a spare block. Writes to the
+// variable store are first staged in the spare block and logged in the write
+// queue of the working block, so they can be completed after a reset. See
+// EDK2 MdeModulePkg/Include/Guid/SystemNvDataGuid.h and
+// MdeModulePkg/Universal/FaultTolerantWriteDxe/FaultTolerantWrite.h.
+
+// FTW structure sizes.
+const (
+	// FTWWorkingBlockHeaderSize is the size of the
+	// EFI_FAULT_TOLERANT_WORKING_BLOCK_HEADER.
+	FTWWorkingBlockHeaderSize = 32
+	// FTWWriteHeaderSize is the size of the EFI_FAULT_TOLERANT_WRITE_HEADER.
+	FTWWriteHeaderSize = 40
+	// FTWWriteRecordSize is the size of the EFI_FAULT_TOLERANT_WRITE_RECORD,
+	// without its private data.
+	FTWWriteRecordSize = 40
+)
+
+// FTW working block signatures. Older EDK2 versions use the NVRAM FV GUID.
+var (
+	FTWWorkingBlockGUID = uuid.MustParse("9E58292B-7C68-497D-A0CE-6500FD9F1B95")
+)
+
+// FTWWorkingBlockHeader is the EFI_FAULT_TOLERANT_WORKING_BLOCK_HEADER. State
+// holds the WorkingBlockValid and WorkingBlockInvalid bits.
+type FTWWorkingBlockHeader struct {
+	Signature      uuid.UUID
+	Crc            uint32
+	State          uint8
+	Reserved       [3]uint8 `json:"-"`
+	WriteQueueSize uint64
+}
+
+// FTWWriteHeader is the EFI_FAULT_TOLERANT_WRITE_HEADER. State holds the
+// HeaderAllocated, WritesAllocated and Complete bits.
+type FTWWriteHeader struct {
+	State           uint8
+	Reserved        [3]uint8 `json:"-"`
+	CallerID        uuid.UUID
+	NumberOfWrites  uint64
+	PrivateDataSize uint64
+}
+
+// FTWWriteRecordHeader is the EFI_FAULT_TOLERANT_WRITE_RECORD. State holds
+// the BootBlockUpdate, SpareComplete and DestinationComplete bits.
+type FTWWriteRecordHeader struct {
+	State          uint8
+	Reserved       [3]uint8 `json:"-"`
+	Lba            uint64
+	Offset         uint64
+	Length         uint64
+	RelativeOffset int64
+}
+
+// FTWWrite is a write in the queue of the working block, with its records.
+// The state bits are set when they differ from the erase polarity.
+type FTWWrite struct {
+	Header          FTWWriteHeader
+	HeaderAllocated bool
+	WritesAllocated bool
+	Complete        bool
+	Records         []*FTWWriteRecord `json:",omitempty"`
+}
+
+// FTWWriteRecord is a record of an FTWWrite.
+type FTWWriteRecord struct {
+	Header              FTWWriteRecordHeader
+	BootBlockUpdate     bool
+	SpareComplete       bool
+	DestinationComplete bool
+}
+
+// FTWWorkingBlock is the FTW working block of an NVRAM FV along with the spare
+// block following it. It is kept as it is when the FV is assembled.
+type FTWWorkingBlock struct {
+	Header FTWWorkingBlockHeader
+	// Valid is set if the working block is valid and not invalidated.
+	Valid  bool
+	Writes []*FTWWrite `json:",omitempty"`
+	// Offset is the offset of the working block in the FV.
+	Offset uint64
+	// SpareOffset and SpareLength locate the spare block in the FV, from
+	// the block after the working block to the end of the FV. SpareErased
+	// is set if it only holds erase polarity bytes.
+	SpareOffset uint64
+	SpareLength uint64
+	SpareErased bool
+
+	polarity uint8
+	buf      []byte
+}
+
+// ftwBit returns whether bit of state is set, i.e. differs from the erase
+// polarity.
+func ftwBit(state uint8, bit uint, polarity uint8) bool {
+	return (state^polarity)&(1<<bit) != 0
+}
+
+// isFTWWorkingBlock checks if buf starts with a working block header.
+func isFTWWorkingBlock(buf []byte) bool {
+	if len(buf) < FTWWorkingBlockHeaderSize {
+		return false
+	}
+	var g uuid.UUID
+	copy(g[:], buf)
+	return g == *FTWWorkingBlockGUID || g == *EVSA
+}
+
+// NewFTWWorkingBlock parses the working block at the start of buf, which
+// extends to the end of the write queue. polarity is the erase polarity of
+// its FV.
+func NewFTWWorkingBlock(buf []byte, polarity uint8) (*FTWWorkingBlock, error) {
+	if !isFTWWorkingBlock(buf) {
+		return nil, fmt.Errorf("not an FTW working block")
+	}
+	wb := FTWWorkingBlock{polarity: polarity}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &wb.Header); err != nil {
+		return nil, err
+	}
+	end := FTWWorkingBlockHeaderSize + wb.Header.WriteQueueSize
+	if wb.Header.WriteQueueSize > uint64(len(buf)) || end > uint64(len(buf)) {
+		return nil, fmt.Errorf("FTW write queue of %#x bytes does not fit into %#x bytes",
+			wb.Header.WriteQueueSize, len(buf)-FTWWorkingBlockHeaderSize)
+	}
+	wb.buf = buf[:end]
+	// WorkingBlockValid is set and WorkingBlockInvalid is not.
+	wb.Valid = ftwBit(wb.Header.State, 0, polarity) && !ftwBit(wb.Header.State, 1, polarity)
+
+	for offset := uint64(FTWWorkingBlockHeaderSize); offset+FTWWriteHeaderSize <= end; {
+		var w FTWWrite
+		if err := binary.Read(bytes.NewReader(wb.buf[offset:]), binary.LittleEndian, &w.Header); err != nil {
+			return nil, err
+		}
+		w.HeaderAllocated = ftwBit(w.Header.State, 0, polarity)
+		if !w.HeaderAllocated {
+			// Reached the free part of the queue.
+			break
+		}
+		w.WritesAllocated = ftwBit(w.Header.State, 1, polarity)
+		w.Complete = ftwBit(w.Header.State, 2, polarity)
+		offset += FTWWriteHeaderSize
+		// Check the private data size first, the record size could wrap.
+		if w.Header.PrivateDataSize > end-offset {
+			return nil, fmt.Errorf("FTW private data of %#x bytes does not fit into the write queue",
+				w.Header.PrivateDataSize)
+		}
+		recordSize := FTWWriteRecordSize + w.Header.PrivateDataSize
+		if w.Header.NumberOfWrites > (end-offset)/recordSize {
+			return nil, fmt.Errorf("%d FTW records of %#x bytes do not fit into the write queue",
+				w.Header.NumberOfWrites, recordSize)
+		}
+		if w.WritesAllocated {
+			for i := uint64(0); i < w.Header.NumberOfWrites; i++ {
+				var r FTWWriteRecord
+				if err := binary.Read(bytes.NewReader(wb.buf[offset:]), binary.LittleEndian, &r.Header); err != nil {
+					return nil, err
+				}
+				r.BootBlockUpdate = ftwBit(r.Header.State, 0, polarity)
+				r.SpareComplete = ftwBit(r.Header.State, 1, polarity)
+				r.DestinationComplete = ftwBit(r.Header.State, 2, polarity)
+				w.Records = append(w.Records, &r)
+				offset += recordSize
+			}
+		}
+		wb.Writes = append(wb.Writes, &w)
+		if !w.WritesAllocated {
+			break
+		}
+	}
+	return &wb, nil
+}
+
+// Buf returns the working block, up to the end of the write queue.
+func (wb *FTWWorkingBlock) Buf() []byte {
+	return wb.buf
+}
+
+// crc returns the CRC32 of the header as the FTW driver computes it, with the
+// Crc and the state bits erased.
+func (wb *FTWWorkingBlock) crc() uint32 {
+	h := append([]byte{}, wb.buf[:FTWWorkingBlockHeaderSize]...)
+	for i := 16; i < 20; i++ {
+		h[i] = 0xFF
+	}
+	h[20] |= 0x03
+	return crc32.ChecksumIEEE(h)
+}
+
+// Validate checks the CRC of a valid working block.
+func (wb *FTWWorkingBlock) Validate() []error {
+	var errs []error
+	if wb.Valid {
+		if crc := wb.crc(); crc != wb.Header.Crc {
+			errs = append(errs, fmt.Errorf("FTW working block CRC is %#08x, expected %#08x", wb.Header.Crc, crc))
+		}
+	}
+	return errs
+}
+
+// ParseFTW looks for the FTW working block in an NVRAM FV, at the block
+// boundaries after the variable store, and sets FTWWorkingBlock.
+func (fv *FirmwareVolume) ParseFTW() error {
+	fv.FTWWorkingBlock = nil
+	blockSize := uint64(0x1000)
+	if len(fv.Blocks) != 0 && fv.Blocks[0].Size != 0 {
+		blockSize = uint64(fv.Blocks[0].Size)
+	}
+	start := fv.DataOffset
+	if fv.VariableStore != nil {
+		start += uint64(len(fv.VariableStore.Buf()))
+	}
+	for offset := Align(start, blockSize); offset < uint64(len(fv.buf)); offset += blockSize {
+		if !isFTWWorkingBlock(fv.buf[offset:]) {
+			continue
+		}
+		wb, err := NewFTWWorkingBlock(fv.buf[offset:], fv.GetErasePolarity())
+		if err != nil {
+			return anomaly("unable to parse FTW working block at offset %#x into FV: %v", offset, err)
+		}
+		wb.Offset = offset
+		wb.SpareOffset = Align(offset+uint64(len(wb.buf)), blockSize)
+		if wb.SpareOffset < uint64(len(fv.buf)) {
+			spare := fv.buf[wb.SpareOffset:]
+			wb.SpareLength = uint64(len(spare))
+			wb.SpareErased = IsErased(spare, fv.GetErasePolarity())
+		} else {
+			wb.SpareOffset = 0
+		}
+		fv.FTWWorkingBlock = wb
+		return nil
+	}
+	return nil
+}
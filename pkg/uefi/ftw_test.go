@@ -0,0 +1,86 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// makeFTWWorkingBlock returns a valid working block with a queue of queueSize
+// bytes, holding a complete write of two records with 8 bytes of private data
+// each, followed by a write in progress.
+func makeFTWWorkingBlock(queueSize int) []byte {
+	buf := bytes.Repeat([]byte{0xff}, FTWWorkingBlockHeaderSize+queueSize)
+	copy(buf, FTWWorkingBlockGUID[:])
+	binary.LittleEndian.PutUint64(buf[24:], uint64(queueSize))
+	buf[21], buf[22], buf[23] = 0, 0, 0
+	wb := FTWWorkingBlock{buf: buf}
+	binary.LittleEndian.PutUint32(buf[16:], wb.crc())
+	buf[20] = 0xfe // WorkingBlockValid
+
+	off := FTWWorkingBlockHeaderSize
+	write := func(state uint8, records int) {
+		buf[off] = state
+		copy(buf[off+4:], uuid.MustParse("11111111-2222-3333-4444-555555555555")[:])
+		binary.LittleEndian.PutUint64(buf[off+20:], uint64(records))
+		binary.LittleEndian.PutUint64(buf[off+28:], 8)
+		off += FTWWriteHeaderSize
+		for i := 0; i < records; i++ {
+			buf[off] = 0xf9 // SpareComplete, DestinationComplete
+			binary.LittleEndian.PutUint64(buf[off+4:], uint64(i))
+			binary.LittleEndian.PutUint64(buf[off+20:], 0x10)
+			off += FTWWriteRecordSize + 8
+		}
+	}
+	write(0xf8, 2) // HeaderAllocated, WritesAllocated, Complete
+	write(0xfe, 1) // HeaderAllocated only
+	return buf
+}
+
+func TestFTWWorkingBlock(t *testing.T) {
+	buf := append(makeFTWWorkingBlock(0x200), bytes.Repeat([]byte{0xff}, 0x100)...)
+	wb, err := NewFTWWorkingBlock(buf, 0xff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !wb.Valid || wb.Header.WriteQueueSize != 0x200 || len(wb.Buf()) != FTWWorkingBlockHeaderSize+0x200 {
+		t.Errorf("unexpected working block %+v", wb)
+	}
+	if errs := wb.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected errors %v", errs)
+	}
+	if len(wb.Writes) != 2 {
+		t.Fatalf("expected 2 writes, got %d", len(wb.Writes))
+	}
+	w := wb.Writes[0]
+	if !w.HeaderAllocated || !w.WritesAllocated || !w.Complete || len(w.Records) != 2 {
+		t.Errorf("unexpected first write %+v", w)
+	}
+	if r := w.Records[1]; r.Header.Lba != 1 || r.Header.Length != 0x10 || r.BootBlockUpdate || !r.SpareComplete || !r.DestinationComplete {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if w := wb.Writes[1]; !w.HeaderAllocated || w.WritesAllocated || len(w.Records) != 0 {
+		t.Errorf("unexpected second write %+v", w)
+	}
+
+	wb.Header.Crc++
+	if errs := wb.Validate(); len(errs) != 1 {
+		t.Errorf("expected a CRC error, got %v", errs)
+	}
+	if _, err := NewFTWWorkingBlock(buf[:0x100], 0xff); err == nil {
+		t.Error("expected an error for a truncated queue")
+	}
+
+	// A private data size which wraps the record size to 0.
+	binary.LittleEndian.PutUint64(buf[FTWWorkingBlockHeaderSize+28:], math.MaxUint64-FTWWriteRecordSize+1)
+	if _, err := NewFTWWorkingBlock(buf, 0xff); err == nil {
+		t.Error("expected an error for a private data size larger than the queue")
+	}
+}
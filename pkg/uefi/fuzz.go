@@ -0,0 +1,78 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build gofuzz
+// +build gofuzz
+
+package uefi
+
+import (
+	"io/ioutil"
+	"log"
+)
+
+// The Fuzz functions are entry points for go-fuzz, selected with -func:
+//
+//	go-fuzz-build -func FuzzFirmwareVolume github.com/linuxboot/fiano/pkg/uefi
+//	go-fuzz -bin uefi-fuzz.zip -workdir fuzz/fv
+//
+// They return 1 for inputs which parse, so go-fuzz prefers them, and 0
+// otherwise. Parsed inputs are validated too, as the validation reads the
+// same length fields.
+
+func init() {
+	// The parsers log warnings for most of the inputs.
+	log.SetOutput(ioutil.Discard)
+}
+
+// FuzzFirmwareVolume fuzzes NewFirmwareVolume and the files and sections
+// inside.
+func FuzzFirmwareVolume(data []byte) int {
+	fv, err := NewFirmwareVolume(data, 0, false)
+	if err != nil {
+		return 0
+	}
+	fv.Validate()
+	return 1
+}
+
+// FuzzFile fuzzes NewFile and the sections inside.
+func FuzzFile(data []byte) int {
+	f, err := NewFile(data)
+	if err != nil || f == nil {
+		return 0
+	}
+	f.Validate()
+	return 1
+}
+
+// FuzzSection fuzzes NewSection, including decompression.
+func FuzzSection(data []byte) int {
+	s, err := NewSection(data, 0)
+	if err != nil {
+		return 0
+	}
+	s.Validate()
+	return 1
+}
+
+// FuzzFlashImage fuzzes the IFD parser and the regions of the image.
+func FuzzFlashImage(data []byte) int {
+	f, err := NewFlashImage(data)
+	if err != nil {
+		return 0
+	}
+	f.Validate()
+	return 1
+}
+
+// FuzzParse fuzzes Parse, which also parses BIOS regions without an IFD.
+func FuzzParse(data []byte) int {
+	f, err := Parse(data)
+	if err != nil {
+		return 0
+	}
+	f.Validate()
+	return 1
+}
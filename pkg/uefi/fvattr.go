@@ -0,0 +1,139 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// FVAttributes are the EFI_FVB_ATTRIBUTES_2 of a firmware volume. In JSON
+// they are decoded into named flags, see fvAttributesJSON.
+type FVAttributes uint32
+
+// FV attribute flags, UEFI PI spec volume 3.2.1.
+const (
+	FVAttributeReadDisabledCap  FVAttributes = 0x00000001
+	FVAttributeReadEnabledCap   FVAttributes = 0x00000002
+	FVAttributeReadStatus       FVAttributes = 0x00000004
+	FVAttributeWriteDisabledCap FVAttributes = 0x00000008
+	FVAttributeWriteEnabledCap  FVAttributes = 0x00000010
+	FVAttributeWriteStatus      FVAttributes = 0x00000020
+	FVAttributeLockCap          FVAttributes = 0x00000040
+	FVAttributeLockStatus       FVAttributes = 0x00000080
+	FVAttributeStickyWrite      FVAttributes = 0x00000200
+	FVAttributeMemoryMapped     FVAttributes = 0x00000400
+	FVAttributeErasePolarity    FVAttributes = 0x00000800
+	FVAttributeReadLockCap      FVAttributes = 0x00001000
+	FVAttributeReadLockStatus   FVAttributes = 0x00002000
+	FVAttributeWriteLockCap     FVAttributes = 0x00004000
+	FVAttributeWriteLockStatus  FVAttributes = 0x00008000
+	FVAttributeAlignment        FVAttributes = 0x001F0000
+	FVAttributeWeakAlignment    FVAttributes = 0x80000000
+
+	fvAttributeAlignmentShift = 16
+)
+
+// Alignment returns the required alignment of the FV in bytes.
+func (a FVAttributes) Alignment() uint64 {
+	return 1 << uint((a&FVAttributeAlignment)>>fvAttributeAlignmentShift)
+}
+
+// fvAttributesJSON is the JSON form of FVAttributes. Bits without a name are
+// kept in Reserved.
+type fvAttributesJSON struct {
+	ReadDisabledCap  bool   `json:"READ_DISABLED_CAP"`
+	ReadEnabledCap   bool   `json:"READ_ENABLED_CAP"`
+	ReadStatus       bool   `json:"READ_STATUS"`
+	WriteDisabledCap bool   `json:"WRITE_DISABLED_CAP"`
+	WriteEnabledCap  bool   `json:"WRITE_ENABLED_CAP"`
+	WriteStatus      bool   `json:"WRITE_STATUS"`
+	LockCap          bool   `json:"LOCK_CAP"`
+	LockStatus       bool   `json:"LOCK_STATUS"`
+	StickyWrite      bool   `json:"STICKY_WRITE"`
+	MemoryMapped     bool   `json:"MEMORY_MAPPED"`
+	ErasePolarity    bool   `json:"ERASE_POLARITY"`
+	ReadLockCap      bool   `json:"READ_LOCK_CAP"`
+	ReadLockStatus   bool   `json:"READ_LOCK_STATUS"`
+	WriteLockCap     bool   `json:"WRITE_LOCK_CAP"`
+	WriteLockStatus  bool   `json:"WRITE_LOCK_STATUS"`
+	Alignment        uint64 `json:"ALIGNMENT"`
+	WeakAlignment    bool   `json:"WEAK_ALIGNMENT"`
+	Reserved         uint32 `json:",omitempty"`
+}
+
+// flags pairs the flag fields with their bits.
+func (j *fvAttributesJSON) flags() []struct {
+	set *bool
+	bit FVAttributes
+} {
+	return []struct {
+		set *bool
+		bit FVAttributes
+	}{
+		{&j.ReadDisabledCap, FVAttributeReadDisabledCap},
+		{&j.ReadEnabledCap, FVAttributeReadEnabledCap},
+		{&j.ReadStatus, FVAttributeReadStatus},
+		{&j.WriteDisabledCap, FVAttributeWriteDisabledCap},
+		{&j.WriteEnabledCap, FVAttributeWriteEnabledCap},
+		{&j.WriteStatus, FVAttributeWriteStatus},
+		{&j.LockCap, FVAttributeLockCap},
+		{&j.LockStatus, FVAttributeLockStatus},
+		{&j.StickyWrite, FVAttributeStickyWrite},
+		{&j.MemoryMapped, FVAttributeMemoryMapped},
+		{&j.ErasePolarity, FVAttributeErasePolarity},
+		{&j.ReadLockCap, FVAttributeReadLockCap},
+		{&j.ReadLockStatus, FVAttributeReadLockStatus},
+		{&j.WriteLockCap, FVAttributeWriteLockCap},
+		{&j.WriteLockStatus, FVAttributeWriteLockStatus},
+		{&j.WeakAlignment, FVAttributeWeakAlignment},
+	}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a FVAttributes) MarshalJSON() ([]byte, error) {
+	j := fvAttributesJSON{Alignment: a.Alignment()}
+	known := FVAttributeAlignment
+	for _, f := range j.flags() {
+		*f.set = a&f.bit != 0
+		known |= f.bit
+	}
+	j.Reserved = uint32(a &^ known)
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The attributes may also be given
+// as a number, as in JSON written by older versions.
+func (a *FVAttributes) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] != '{' {
+		var n uint32
+		if err := json.Unmarshal(b, &n); err != nil {
+			return err
+		}
+		*a = FVAttributes(n)
+		return nil
+	}
+	j := fvAttributesJSON{Alignment: 1}
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	if j.Alignment == 0 || j.Alignment&(j.Alignment-1) != 0 || j.Alignment > 1<<31 {
+		return fmt.Errorf("FV alignment %#x is not a power of 2 up to 2GiB", j.Alignment)
+	}
+	attr := FVAttributes(j.Reserved)
+	for _, f := range j.flags() {
+		if *f.set {
+			attr |= f.bit
+		}
+	}
+	var shift uint
+	for 1<<shift < j.Alignment {
+		shift++
+	}
+	attr |= FVAttributes(shift) << fvAttributeAlignmentShift
+	*a = attr
+	return nil
+}
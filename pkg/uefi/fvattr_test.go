@@ -0,0 +1,65 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestFVAttributesJSON(t *testing.T) {
+	// Attributes of the OVMF FVs, 16 byte alignment, with a reserved bit set.
+	attr := FVAttributes(0x0004feff | 0x100)
+	b, err := json.Marshal(attr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"ERASE_POLARITY":true`, `"ALIGNMENT":16`, `"WEAK_ALIGNMENT":false`, `"Reserved":256`} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("%s missing in %s", s, b)
+		}
+	}
+	var na FVAttributes
+	if err := json.Unmarshal(b, &na); err != nil {
+		t.Fatal(err)
+	}
+	if na != attr {
+		t.Errorf("attributes changed from %#x to %#x", uint32(attr), uint32(na))
+	}
+}
+
+func TestFVAttributesUnmarshal(t *testing.T) {
+	var tests = []struct {
+		name string
+		json string
+		attr FVAttributes
+		ok   bool
+	}{
+		{"number", `2048`, FVAttributeErasePolarity, true},
+		{"flags", `{"MEMORY_MAPPED":true,"WEAK_ALIGNMENT":true,"ALIGNMENT":4096}`,
+			FVAttributeMemoryMapped | FVAttributeWeakAlignment | 12<<fvAttributeAlignmentShift, true},
+		{"noAlignment", `{"ERASE_POLARITY":true}`, FVAttributeErasePolarity, true},
+		{"badAlignment", `{"ALIGNMENT":24}`, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var a FVAttributes
+			err := json.Unmarshal([]byte(test.json), &a)
+			if !test.ok {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if a != test.attr {
+				t.Errorf("got %#x, want %#x", uint32(a), uint32(test.attr))
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ type GBERegion struct {
 	buf []byte
 	//Metadata for extraction and recovery
 	ExtractPath string
+	Location
 	// This is a pointer to the Region struct laid out in the ifd
 	Position *Region
 }
@@ -0,0 +1,725 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"unicode/utf16"
+
+	"github.com/linuxboot/fiano/pkg/pecoff"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// The HII package lists of a driver hold the setup forms in IFR and the
+// strings they reference. EDK2 drivers store them in a PE resource of type
+// HII, or as arrays in their data, which is also what the AMI Setup and
+// AMITSE drivers of Aptio images do.
+
+// HII package types.
+const (
+	HIIPackageTypeGUID        = 0x01
+	HIIPackageTypeForms       = 0x02
+	HIIPackageTypeStrings     = 0x04
+	HIIPackageTypeFonts       = 0x05
+	HIIPackageTypeImages      = 0x06
+	HIIPackageTypeSimpleFonts = 0x07
+	HIIPackageTypeDevicePath  = 0x08
+	HIIPackageTypeKeyboard    = 0x09
+	HIIPackageTypeAnimations  = 0x0A
+	HIIPackageTypeEnd         = 0xDF
+)
+
+// Header sizes, the language of a string package follows its header.
+const (
+	hiiPackageListHeaderSize   = 20
+	hiiPackageHeaderSize       = 4
+	hiiStringPackageHeaderSize = 46
+)
+
+var hiiPackageTypeNames = map[uint8]string{
+	HIIPackageTypeGUID:        "GUID",
+	HIIPackageTypeForms:       "FORMS",
+	HIIPackageTypeStrings:     "STRINGS",
+	HIIPackageTypeFonts:       "FONTS",
+	HIIPackageTypeImages:      "IMAGES",
+	HIIPackageTypeSimpleFonts: "SIMPLE_FONTS",
+	HIIPackageTypeDevicePath:  "DEVICE_PATH",
+	HIIPackageTypeKeyboard:    "KEYBOARD_LAYOUT",
+	HIIPackageTypeAnimations:  "ANIMATIONS",
+}
+
+// HIIPackageList is an EFI_HII_PACKAGE_LIST with its form and string
+// packages decoded. The string IDs of the forms are resolved with the
+// English strings, or the first language.
+type HIIPackageList struct {
+	GUID     uuid.UUID
+	FormSets []*HIIFormSet `json:",omitempty"`
+	Strings  []*HIIStrings `json:",omitempty"`
+	// Packages are the types of the packages which are not decoded.
+	Packages []string `json:",omitempty"`
+}
+
+// HIIStrings are the strings of a string package by their IDs.
+type HIIStrings struct {
+	Language string
+	Strings  map[uint16]string
+}
+
+// HIIFormSet is an IFR form set, the root of a setup menu.
+type HIIFormSet struct {
+	GUID      uuid.UUID
+	Title     string
+	Help      string         `json:",omitempty"`
+	VarStores []*HIIVarStore `json:",omitempty"`
+	Forms     []*HIIForm     `json:",omitempty"`
+}
+
+// HIIVarStore is the storage of the questions, usually a UEFI variable.
+type HIIVarStore struct {
+	ID uint16
+	// Type is BUFFER, EFI or NAME_VALUE.
+	Type string
+	GUID uuid.UUID
+	Name string `json:",omitempty"`
+	Size uint16 `json:",omitempty"`
+}
+
+// HIIForm is a page of a form set.
+type HIIForm struct {
+	ID        uint16
+	Title     string
+	Questions []*HIIQuestion `json:",omitempty"`
+}
+
+// HIIQuestion is a setup option, or a reference to another form.
+type HIIQuestion struct {
+	// Type is the IFR opcode, e.g. ONE_OF, CHECKBOX or NUMERIC.
+	Type       string
+	Prompt     string
+	Help       string `json:",omitempty"`
+	QuestionID uint16
+	VarStoreID uint16
+	// VarOffset is the offset of the value in a buffer or EFI var store,
+	// the string ID of its name in a name/value var store.
+	VarOffset uint16
+	// Size is the size of the value of numeric questions.
+	Size    int          `json:",omitempty"`
+	Min     *uint64      `json:",omitempty"`
+	Max     *uint64      `json:",omitempty"`
+	Step    *uint64      `json:",omitempty"`
+	Default *uint64      `json:",omitempty"`
+	Options []*HIIOption `json:",omitempty"`
+	// FormID is the target of REF questions.
+	FormID uint16 `json:",omitempty"`
+
+	// The IFR bytes holding the defaults, set when parsed from an image.
+	flags    []byte
+	defaults [][]byte
+}
+
+// HIIOption is an option of a ONE_OF or ORDERED_LIST question.
+type HIIOption struct {
+	Text    string
+	Value   uint64
+	Default bool `json:",omitempty"`
+
+	flags []byte
+}
+
+// IFR opcodes
+const (
+	ifrForm               = 0x01
+	ifrOneOf              = 0x05
+	ifrCheckBox           = 0x06
+	ifrNumeric            = 0x07
+	ifrPassword           = 0x08
+	ifrOneOfOption        = 0x09
+	ifrAction             = 0x0C
+	ifrFormSet            = 0x0E
+	ifrRef                = 0x0F
+	ifrDate               = 0x1A
+	ifrTime               = 0x1B
+	ifrString             = 0x1C
+	ifrOrderedList        = 0x23
+	ifrVarStore           = 0x24
+	ifrVarStoreNameValue  = 0x25
+	ifrVarStoreEFI        = 0x26
+	ifrEnd                = 0x29
+	ifrDefault            = 0x5B
+	ifrFormMap            = 0x5D
+	ifrQuestionHeaderSize = 13
+)
+
+var ifrQuestionNames = map[uint8]string{
+	ifrOneOf:       "ONE_OF",
+	ifrCheckBox:    "CHECKBOX",
+	ifrNumeric:     "NUMERIC",
+	ifrPassword:    "PASSWORD",
+	ifrAction:      "ACTION",
+	ifrRef:         "REF",
+	ifrDate:        "DATE",
+	ifrTime:        "TIME",
+	ifrString:      "STRING",
+	ifrOrderedList: "ORDERED_LIST",
+}
+
+// Flags of ONE_OF_OPTION and CHECKBOX
+const (
+	ifrOptionDefault      = 0x10
+	ifrOptionDefaultMfg   = 0x20
+	ifrCheckBoxDefault    = 0x01
+	ifrCheckBoxDefaultMfg = 0x02
+)
+
+// ifrTypeSizes are the sizes of the numeric EFI_IFR_TYPE values.
+var ifrTypeSizes = map[uint8]int{0: 1, 1: 2, 2: 4, 3: 8, 4: 1}
+
+// PE32HIIPackageLists returns the HII package lists of the HII resources of
+// a PE32 image. Without resources, the data is scanned for packages, see
+// ScanHIIPackages. Data which is not a PE32 or TE image has no packages, the
+// images are not checked by the parser.
+func PE32HIIPackageLists(buf []byte) ([]*HIIPackageList, error) {
+	img, err := pecoff.Parse(buf)
+	if err != nil {
+		return nil, nil
+	}
+	res, err := img.Resources("HII")
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return ScanHIIPackages(buf), nil
+	}
+	var lists []*HIIPackageList
+	for _, r := range res {
+		l, err := NewHIIPackageList(r)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, l)
+	}
+	return lists, nil
+}
+
+// hiiArray is an array of packages found in the data of an image.
+type hiiArray struct {
+	start, end int
+	forms      []byte
+	strings    []*HIIStrings
+	list       *HIIPackageList
+}
+
+// gap returns the number of bytes between the arrays.
+func (a *hiiArray) gap(b *hiiArray) int {
+	if a.start > b.start {
+		return a.start - b.end
+	}
+	return b.start - a.end
+}
+
+// ScanHIIPackages finds the form and string packages stored as arrays, as
+// generated by VfrCompile and the string tools: a UINT32 with the size of the
+// array precedes a form package or the string packages of all languages.
+// Each form package uses the closest array of strings, the linker keeps the
+// arrays of a module together. The package lists have a zero GUID.
+func ScanHIIPackages(buf []byte) []*HIIPackageList {
+	var forms, strs []*hiiArray
+	// The arrays are aligned by the compilers.
+	for i := 0; i+8 <= len(buf); i += 4 {
+		n := uint64(binary.LittleEndian.Uint32(buf[i:]))
+		if n <= 4+hiiPackageHeaderSize || uint64(i)+n > uint64(len(buf)) {
+			continue
+		}
+		a := &hiiArray{start: i, end: i + int(n)}
+		array := buf[i+4 : a.end]
+		h := binary.LittleEndian.Uint32(array)
+		size, typ := uint64(h&0xffffff), uint8(h>>24)
+		switch {
+		case typ == HIIPackageTypeForms && size == uint64(len(array)) && array[hiiPackageHeaderSize] == ifrFormSet:
+			a.forms = array[hiiPackageHeaderSize:]
+			forms = append(forms, a)
+		case typ == HIIPackageTypeStrings:
+			if a.strings = scanHIIStrings(array); a.strings != nil {
+				strs = append(strs, a)
+				i += len(array) &^ 3
+			}
+		}
+	}
+
+	var lists []*HIIPackageList
+	for _, f := range forms {
+		var closest *hiiArray
+		for _, s := range strs {
+			if closest == nil || f.gap(s) < f.gap(closest) {
+				closest = s
+			}
+		}
+		l := &HIIPackageList{}
+		if closest != nil {
+			if closest.list == nil {
+				closest.list = &HIIPackageList{Strings: closest.strings}
+				lists = append(lists, closest.list)
+			}
+			l = closest.list
+		} else {
+			lists = append(lists, l)
+		}
+		// A false match stops the parsing, the forms parsed so far are
+		// kept.
+		l.parseForms(f.forms)
+	}
+	return lists
+}
+
+// scanHIIStrings parses an array of string packages, it returns nil if the
+// array does not consist of valid string packages.
+func scanHIIStrings(array []byte) []*HIIStrings {
+	var strs []*HIIStrings
+	for len(array) > 0 {
+		if len(array) < hiiPackageHeaderSize {
+			return nil
+		}
+		h := binary.LittleEndian.Uint32(array)
+		size, typ := uint64(h&0xffffff), uint8(h>>24)
+		if typ != HIIPackageTypeStrings || size > uint64(len(array)) {
+			return nil
+		}
+		s, err := parseHIIStrings(array[:size])
+		if err != nil {
+			return nil
+		}
+		strs = append(strs, s)
+		array = array[size:]
+	}
+	return strs
+}
+
+// NewHIIPackageList parses an HII package list.
+func NewHIIPackageList(buf []byte) (*HIIPackageList, error) {
+	if len(buf) < hiiPackageListHeaderSize {
+		return nil, fmt.Errorf("HII package list of %#x bytes is too short", len(buf))
+	}
+	l := &HIIPackageList{}
+	copy(l.GUID[:], buf)
+	length := binary.LittleEndian.Uint32(buf[16:])
+	if uint64(length) > uint64(len(buf)) || length < hiiPackageListHeaderSize {
+		return nil, fmt.Errorf("HII package list length %#x out of bounds, buffer is %#x bytes", length, len(buf))
+	}
+
+	var forms [][]byte
+	for offset := uint32(hiiPackageListHeaderSize); offset < length; {
+		if uint64(offset)+hiiPackageHeaderSize > uint64(length) {
+			return nil, fmt.Errorf("HII package header at %#x out of bounds", offset)
+		}
+		h := binary.LittleEndian.Uint32(buf[offset:])
+		size, typ := h&0xffffff, uint8(h>>24)
+		if size < hiiPackageHeaderSize || uint64(offset)+uint64(size) > uint64(length) {
+			return nil, fmt.Errorf("HII package at %#x of %#x bytes out of bounds", offset, size)
+		}
+		p := buf[offset : offset+size]
+		offset += size
+		switch typ {
+		case HIIPackageTypeEnd:
+			offset = length
+		case HIIPackageTypeForms:
+			forms = append(forms, p[hiiPackageHeaderSize:])
+		case HIIPackageTypeStrings:
+			s, err := parseHIIStrings(p)
+			if err != nil {
+				return nil, err
+			}
+			l.Strings = append(l.Strings, s)
+		default:
+			name, ok := hiiPackageTypeNames[typ]
+			if !ok {
+				name = fmt.Sprintf("%#02x", typ)
+			}
+			l.Packages = append(l.Packages, name)
+		}
+	}
+
+	for _, f := range forms {
+		if err := l.parseForms(f); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// readUCS2 returns the NUL terminated UCS-2 string at the start of buf and
+// its size with the terminator.
+func readUCS2(buf []byte) (string, int, error) {
+	var s []uint16
+	for i := 0; i+1 < len(buf); i += 2 {
+		c := binary.LittleEndian.Uint16(buf[i:])
+		if c == 0 {
+			return string(utf16.Decode(s)), i + 2, nil
+		}
+		s = append(s, c)
+	}
+	return "", 0, fmt.Errorf("UCS-2 string is not terminated")
+}
+
+// readASCII returns the NUL terminated string at the start of buf and its
+// size with the terminator.
+func readASCII(buf []byte) (string, int, error) {
+	i := bytes.IndexByte(buf, 0)
+	if i < 0 {
+		return "", 0, fmt.Errorf("string is not terminated")
+	}
+	return string(buf[:i]), i + 1, nil
+}
+
+// parseHIIStrings parses a string package. Strings in SCSU are kept as they
+// are, which is right for ASCII.
+func parseHIIStrings(p []byte) (*HIIStrings, error) {
+	if len(p) < hiiStringPackageHeaderSize {
+		return nil, fmt.Errorf("HII string package of %#x bytes is too short", len(p))
+	}
+	offset := binary.LittleEndian.Uint32(p[8:])
+	if offset > uint32(len(p)) || offset < hiiStringPackageHeaderSize {
+		return nil, fmt.Errorf("HII string package string info offset %#x out of bounds", offset)
+	}
+	lang, _, err := readASCII(p[hiiStringPackageHeaderSize:offset])
+	if err != nil {
+		return nil, fmt.Errorf("HII string package language: %v", err)
+	}
+	s := &HIIStrings{Language: lang, Strings: make(map[uint16]string)}
+
+	b := p[offset:]
+	id := uint16(1)
+	// read consumes n bytes of the block.
+	read := func(n int) ([]byte, error) {
+		if n > len(b) {
+			return nil, fmt.Errorf("HII string block of string %d out of bounds", id)
+		}
+		r := b[:n]
+		b = b[n:]
+		return r, nil
+	}
+	for len(b) > 0 {
+		typ := b[0]
+		b = b[1:]
+		var count uint16 = 1
+		switch typ {
+		case 0x00: // END
+			return s, nil
+		case 0x11, 0x15: // STRING_SCSU_FONT, STRING_UCS2_FONT
+			if _, err := read(1); err != nil {
+				return nil, err
+			}
+		case 0x12, 0x16, 0x13, 0x17: // STRINGS_SCSU, STRINGS_UCS2 and their _FONT
+			if typ == 0x13 || typ == 0x17 {
+				if _, err := read(1); err != nil {
+					return nil, err
+				}
+			}
+			c, err := read(2)
+			if err != nil {
+				return nil, err
+			}
+			count = binary.LittleEndian.Uint16(c)
+		}
+		switch typ {
+		case 0x10, 0x11, 0x12, 0x13: // SCSU
+			for i := uint16(0); i < count; i++ {
+				str, n, err := readASCII(b)
+				if err != nil {
+					return nil, fmt.Errorf("HII string %d: %v", id, err)
+				}
+				s.Strings[id] = str
+				b = b[n:]
+				id++
+			}
+		case 0x14, 0x15, 0x16, 0x17: // UCS2
+			for i := uint16(0); i < count; i++ {
+				str, n, err := readUCS2(b)
+				if err != nil {
+					return nil, fmt.Errorf("HII string %d: %v", id, err)
+				}
+				s.Strings[id] = str
+				b = b[n:]
+				id++
+			}
+		case 0x20: // DUPLICATE
+			d, err := read(2)
+			if err != nil {
+				return nil, err
+			}
+			s.Strings[id] = s.Strings[binary.LittleEndian.Uint16(d)]
+			id++
+		case 0x21: // SKIP2
+			d, err := read(2)
+			if err != nil {
+				return nil, err
+			}
+			id += binary.LittleEndian.Uint16(d)
+		case 0x22: // SKIP1
+			d, err := read(1)
+			if err != nil {
+				return nil, err
+			}
+			id += uint16(d[0])
+		case 0x30, 0x31, 0x32: // EXT1, EXT2, EXT4, the length includes the block type
+			sizes := map[uint8]int{0x30: 1, 0x31: 2, 0x32: 4}
+			h, err := read(1 + sizes[typ])
+			if err != nil {
+				return nil, err
+			}
+			var n uint64
+			for i := len(h) - 1; i > 0; i-- {
+				n = n<<8 | uint64(h[i])
+			}
+			if n < uint64(2+sizes[typ]) || n-uint64(2+sizes[typ]) > uint64(len(b)) {
+				return nil, fmt.Errorf("HII extended string block of %#x bytes out of bounds", n)
+			}
+			b = b[n-uint64(2+sizes[typ]):]
+		default:
+			return nil, fmt.Errorf("unknown HII string block type %#02x", typ)
+		}
+	}
+	return nil, fmt.Errorf("HII string package has no end block")
+}
+
+// str resolves a string ID with the English strings, or the first language.
+func (l *HIIPackageList) str(id uint16) string {
+	for _, s := range l.Strings {
+		if s.Language == "en-US" || s.Language == "en" || s.Language == "eng" {
+			return s.Strings[id]
+		}
+	}
+	if len(l.Strings) > 0 {
+		return l.Strings[0].Strings[id]
+	}
+	return ""
+}
+
+// ifrValue reads a little endian number of size bytes.
+func ifrValue(buf []byte, size int) *uint64 {
+	if size > len(buf) {
+		return nil
+	}
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(buf[i])
+	}
+	return &v
+}
+
+// parseForms decodes the IFR of a form package into form sets.
+func (l *HIIPackageList) parseForms(ifr []byte) error {
+	var fs *HIIFormSet
+	var form *HIIForm
+	// scopes holds the question of each open scope, or nil.
+	var scopes []*HIIQuestion
+	question := func() *HIIQuestion {
+		for i := len(scopes) - 1; i >= 0; i-- {
+			if scopes[i] != nil {
+				return scopes[i]
+			}
+		}
+		return nil
+	}
+
+	for offset := 0; offset < len(ifr); {
+		if offset+2 > len(ifr) {
+			return fmt.Errorf("IFR opcode header at %#x out of bounds", offset)
+		}
+		op, size, scope := ifr[offset], int(ifr[offset+1]&0x7f), ifr[offset+1]&0x80 != 0
+		if size < 2 || offset+size > len(ifr) {
+			return fmt.Errorf("IFR opcode %#02x at %#x of %#x bytes out of bounds", op, offset, size)
+		}
+		b := ifr[offset : offset+size]
+		offset += size
+
+		var q *HIIQuestion
+		switch op {
+		case ifrFormSet:
+			if size < 23 {
+				return fmt.Errorf("IFR FORM_SET of %#x bytes is too short", size)
+			}
+			fs = &HIIFormSet{
+				Title: l.str(binary.LittleEndian.Uint16(b[18:])),
+				Help:  l.str(binary.LittleEndian.Uint16(b[20:])),
+			}
+			copy(fs.GUID[:], b[2:])
+			l.FormSets = append(l.FormSets, fs)
+			form = nil
+		case ifrForm, ifrFormMap:
+			if fs == nil || size < 4 {
+				return fmt.Errorf("IFR FORM at %#x outside of a form set", offset-size)
+			}
+			form = &HIIForm{ID: binary.LittleEndian.Uint16(b[2:])}
+			if op == ifrForm && size >= 6 {
+				form.Title = l.str(binary.LittleEndian.Uint16(b[4:]))
+			}
+			fs.Forms = append(fs.Forms, form)
+		case ifrVarStore, ifrVarStoreEFI, ifrVarStoreNameValue:
+			if fs == nil {
+				return fmt.Errorf("IFR var store at %#x outside of a form set", offset-size)
+			}
+			vs := &HIIVarStore{}
+			var name []byte
+			switch {
+			case op == ifrVarStore && size >= 22:
+				vs.Type = "BUFFER"
+				copy(vs.GUID[:], b[2:])
+				vs.ID = binary.LittleEndian.Uint16(b[18:])
+				vs.Size = binary.LittleEndian.Uint16(b[20:])
+				name = b[22:]
+			case op == ifrVarStoreEFI && size >= 20:
+				vs.Type = "EFI"
+				vs.ID = binary.LittleEndian.Uint16(b[2:])
+				copy(vs.GUID[:], b[4:])
+				// Older IFR has no size and name.
+				if size >= 26 {
+					vs.Size = binary.LittleEndian.Uint16(b[24:])
+					name = b[26:]
+				}
+			case op == ifrVarStoreNameValue && size >= 20:
+				vs.Type = "NAME_VALUE"
+				vs.ID = binary.LittleEndian.Uint16(b[2:])
+				copy(vs.GUID[:], b[4:])
+			default:
+				return fmt.Errorf("IFR var store %#02x of %#x bytes is too short", op, size)
+			}
+			if len(name) > 0 {
+				vs.Name, _, _ = readASCII(name)
+			}
+			fs.VarStores = append(fs.VarStores, vs)
+		case ifrOneOf, ifrCheckBox, ifrNumeric, ifrPassword, ifrAction, ifrRef, ifrDate, ifrTime, ifrString, ifrOrderedList:
+			if form == nil || size < ifrQuestionHeaderSize {
+				return fmt.Errorf("IFR question %#02x at %#x outside of a form", op, offset-size)
+			}
+			q = &HIIQuestion{
+				Type:       ifrQuestionNames[op],
+				Prompt:     l.str(binary.LittleEndian.Uint16(b[2:])),
+				Help:       l.str(binary.LittleEndian.Uint16(b[4:])),
+				QuestionID: binary.LittleEndian.Uint16(b[6:]),
+				VarStoreID: binary.LittleEndian.Uint16(b[8:]),
+				VarOffset:  binary.LittleEndian.Uint16(b[10:]),
+			}
+			data := b[ifrQuestionHeaderSize:]
+			switch op {
+			case ifrOneOf, ifrNumeric:
+				if len(data) > 0 {
+					q.Size = 1 << (data[0] & 0x3)
+					q.Min = ifrValue(data[1:], q.Size)
+					q.Max = ifrValue(data[1+q.Size:], q.Size)
+					q.Step = ifrValue(data[1+2*q.Size:], q.Size)
+				}
+			case ifrCheckBox:
+				q.Size = 1
+				if len(data) > 0 {
+					q.flags = data[:1]
+					v := uint64(data[0] & ifrCheckBoxDefault)
+					q.Default = &v
+				}
+			case ifrRef:
+				if len(data) >= 2 {
+					q.FormID = binary.LittleEndian.Uint16(data)
+				}
+			}
+			form.Questions = append(form.Questions, q)
+		case ifrOneOfOption:
+			if size < 6 {
+				return fmt.Errorf("IFR ONE_OF_OPTION of %#x bytes is too short", size)
+			}
+			o := &HIIOption{
+				Text:    l.str(binary.LittleEndian.Uint16(b[2:])),
+				Default: b[4]&(ifrOptionDefault|ifrOptionDefaultMfg) != 0,
+				flags:   b[4:5],
+			}
+			if v := ifrValue(b[6:], ifrTypeSizes[b[5]]); v != nil {
+				o.Value = *v
+			}
+			if q := question(); q != nil {
+				q.Options = append(q.Options, o)
+				if o.Default && q.Default == nil {
+					v := o.Value
+					q.Default = &v
+				}
+			}
+		case ifrDefault:
+			if size < 5 {
+				return fmt.Errorf("IFR DEFAULT of %#x bytes is too short", size)
+			}
+			// Only the standard default is kept.
+			if q := question(); q != nil && binary.LittleEndian.Uint16(b[2:]) == 0 {
+				if n, ok := ifrTypeSizes[b[4]]; ok {
+					if q.Default = ifrValue(b[5:], n); q.Default != nil {
+						q.defaults = append(q.defaults, b[5:5+n])
+					}
+				}
+			}
+		case ifrEnd:
+			if len(scopes) > 0 {
+				scopes = scopes[:len(scopes)-1]
+			}
+		}
+		if scope {
+			scopes = append(scopes, q)
+		}
+	}
+	return nil
+}
+
+// SetDefault sets the default value of the question in the IFR it was parsed
+// from: the DEFAULT opcodes, the default flag of the options or of the
+// checkbox. Questions without a default in the IFR cannot be set.
+func (q *HIIQuestion) SetDefault(value uint64) error {
+	if q.Size > 0 && q.Size < 8 && value>>(8*uint(q.Size)) != 0 {
+		return fmt.Errorf("value %#x does not fit into the %d bytes of question %q", value, q.Size, q.Prompt)
+	}
+	for _, d := range q.defaults {
+		if len(d) < 8 && value>>(8*uint(len(d))) != 0 {
+			return fmt.Errorf("value %#x does not fit into the %d bytes default of question %q", value, len(d), q.Prompt)
+		}
+	}
+	set := false
+	if q.flags != nil {
+		if value > 1 {
+			return fmt.Errorf("checkbox %q cannot be set to %#x", q.Prompt, value)
+		}
+		q.flags[0] &^= ifrCheckBoxDefault | ifrCheckBoxDefaultMfg
+		if value == 1 {
+			q.flags[0] |= ifrCheckBoxDefault | ifrCheckBoxDefaultMfg
+		}
+		set = true
+	}
+	if len(q.Options) > 0 && q.Options[0].flags != nil {
+		var match *HIIOption
+		for _, o := range q.Options {
+			if o.Value == value {
+				match = o
+				break
+			}
+		}
+		if match == nil {
+			return fmt.Errorf("question %q has no option with value %#x", q.Prompt, value)
+		}
+		// The option becomes the standard and manufacturing default.
+		for _, o := range q.Options {
+			o.flags[0] &^= ifrOptionDefault | ifrOptionDefaultMfg
+			o.Default = false
+		}
+		match.flags[0] |= ifrOptionDefault | ifrOptionDefaultMfg
+		match.Default = true
+		set = true
+	}
+	for _, d := range q.defaults {
+		for i := range d {
+			d[i] = byte(value >> (8 * uint(i)))
+		}
+		set = true
+	}
+	if !set {
+		return fmt.Errorf("question %q has no default value in the IFR", q.Prompt)
+	}
+	q.Default = &value
+	return nil
+}
@@ -0,0 +1,227 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var (
+	hiiListGUID    = uuid.MustParse("D9DCC5DF-4007-435E-9098-8970935504B2")
+	hiiFormSetGUID = uuid.MustParse("7235C51C-0C80-4CAB-87AC-3B084A6304B1")
+)
+
+// hiiPackage prepends the package header to data.
+func hiiPackage(typ uint8, data ...[]byte) []byte {
+	b := bytes.Join(data, nil)
+	h := make([]byte, 4)
+	binary.LittleEndian.PutUint32(h, uint32(len(b)+4)|uint32(typ)<<24)
+	return append(h, b...)
+}
+
+// ifrOp encodes an IFR opcode with its fields in little endian.
+func ifrOp(op uint8, scope bool, fields ...interface{}) []byte {
+	var b bytes.Buffer
+	for _, f := range fields {
+		binary.Write(&b, binary.LittleEndian, f)
+	}
+	l := uint8(b.Len() + 2)
+	if scope {
+		l |= 0x80
+	}
+	return append([]byte{op, l}, b.Bytes()...)
+}
+
+// hiiPackageList builds a package list with a form set of a ONE_OF and a
+// NUMERIC question, and their strings.
+func hiiPackageList() []byte {
+	// Strings 1 to 7, 3 is skipped and 6 is a duplicate of 5.
+	var strs bytes.Buffer
+	strs.Write([]byte{0x14})
+	strs.Write(utf16z("Setup"))
+	strs.Write([]byte{0x12, 1, 0})
+	strs.Write([]byte("Main\x00"))
+	strs.Write([]byte{0x22, 1})
+	strs.Write([]byte{0x16, 2, 0})
+	strs.Write(utf16z("Boot Mode"))
+	strs.Write(utf16z("Legacy"))
+	strs.Write([]byte{0x20, 5, 0})
+	strs.Write([]byte{0x30, 0x40, 3})
+	strs.Write([]byte{0x14})
+	strs.Write(utf16z("Timeout"))
+	strs.Write([]byte{0x00})
+	header := make([]byte, hiiStringPackageHeaderSize-4)
+	lang := []byte("en-US\x00")
+	binary.LittleEndian.PutUint32(header, hiiStringPackageHeaderSize)
+	binary.LittleEndian.PutUint32(header[4:], hiiStringPackageHeaderSize+uint32(len(lang)))
+	strings := hiiPackage(HIIPackageTypeStrings, header, lang, strs.Bytes())
+
+	question := func(prompt, id, offset uint16) []interface{} {
+		return []interface{}{prompt, uint16(0), id, uint16(1), offset, uint8(0)}
+	}
+	forms := hiiPackage(HIIPackageTypeForms,
+		ifrOp(ifrFormSet, true, hiiFormSetGUID, uint16(1), uint16(0), uint8(0)),
+		ifrOp(ifrVarStore, false, hiiFormSetGUID, uint16(1), uint16(8), []byte("Setup\x00")),
+		ifrOp(ifrForm, true, uint16(1), uint16(2)),
+		ifrOp(ifrOneOf, true, append(question(4, 1, 2), uint8(0), uint8(0), uint8(1), uint8(1))...),
+		ifrOp(ifrOneOfOption, false, uint16(5), uint8(0), uint8(0), uint8(0)),
+		ifrOp(ifrOneOfOption, false, uint16(6), uint8(ifrOptionDefault), uint8(0), uint8(1)),
+		ifrOp(ifrEnd, false),
+		ifrOp(ifrNumeric, true, append(question(7, 2, 4), uint8(1), uint16(0), uint16(60), uint16(1))...),
+		ifrOp(ifrDefault, false, uint16(0), uint8(1), uint16(5)),
+		ifrOp(ifrEnd, false),
+		ifrOp(ifrEnd, false),
+		ifrOp(ifrEnd, false),
+	)
+
+	packages := bytes.Join([][]byte{strings, forms, hiiPackage(HIIPackageTypeImages), hiiPackage(HIIPackageTypeEnd)}, nil)
+	list := make([]byte, hiiPackageListHeaderSize)
+	copy(list, hiiListGUID[:])
+	binary.LittleEndian.PutUint32(list[16:], uint32(len(list)+len(packages)))
+	return append(list, packages...)
+}
+
+// utf16z encodes an ASCII string in NUL terminated UCS-2.
+func utf16z(s string) []byte {
+	var b []byte
+	for _, c := range s + "\x00" {
+		b = append(b, byte(c), 0)
+	}
+	return b
+}
+
+func TestNewHIIPackageList(t *testing.T) {
+	l, err := NewHIIPackageList(hiiPackageList())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if l.GUID != *hiiListGUID || !reflect.DeepEqual(l.Packages, []string{"IMAGES"}) {
+		t.Errorf("unexpected package list %v with packages %v", l.GUID, l.Packages)
+	}
+
+	wantStrings := map[uint16]string{1: "Setup", 2: "Main", 4: "Boot Mode", 5: "Legacy", 6: "Legacy", 7: "Timeout"}
+	if len(l.Strings) != 1 || l.Strings[0].Language != "en-US" || !reflect.DeepEqual(l.Strings[0].Strings, wantStrings) {
+		t.Fatalf("unexpected strings %+v", l.Strings)
+	}
+
+	u := func(v uint64) *uint64 { return &v }
+	want := []*HIIFormSet{{
+		GUID:      *hiiFormSetGUID,
+		Title:     "Setup",
+		VarStores: []*HIIVarStore{{ID: 1, Type: "BUFFER", GUID: *hiiFormSetGUID, Name: "Setup", Size: 8}},
+		Forms: []*HIIForm{{
+			ID:    1,
+			Title: "Main",
+			Questions: []*HIIQuestion{
+				{Type: "ONE_OF", Prompt: "Boot Mode", QuestionID: 1, VarStoreID: 1, VarOffset: 2,
+					Size: 1, Min: u(0), Max: u(1), Step: u(1), Default: u(1),
+					Options: []*HIIOption{{Text: "Legacy", Value: 0}, {Text: "Legacy", Value: 1, Default: true}}},
+				{Type: "NUMERIC", Prompt: "Timeout", QuestionID: 2, VarStoreID: 1, VarOffset: 4,
+					Size: 2, Min: u(0), Max: u(60), Step: u(1), Default: u(5)},
+			},
+		}},
+	}}
+	// The IFR bytes of the defaults are not compared.
+	got, err := json.Marshal(l.FormSets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp, _ := json.Marshal(want); !bytes.Equal(got, exp) {
+		t.Errorf("expected %s, got %s", exp, got)
+	}
+}
+
+func TestHIIQuestionSetDefault(t *testing.T) {
+	buf := hiiPackageList()
+	l, err := NewHIIPackageList(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	questions := l.FormSets[0].Forms[0].Questions
+	if err := questions[0].SetDefault(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := questions[1].SetDefault(30); err != nil {
+		t.Fatal(err)
+	}
+	for _, err := range []error{
+		questions[0].SetDefault(2),
+		questions[1].SetDefault(0x10000),
+		(&HIIQuestion{Size: 1}).SetDefault(1),
+	} {
+		if err == nil {
+			t.Error("expected an error")
+		}
+	}
+
+	// The defaults are changed in the buffer.
+	l, err = NewHIIPackageList(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	questions = l.FormSets[0].Forms[0].Questions
+	if d := questions[0].Default; d == nil || *d != 0 || !questions[0].Options[0].Default || questions[0].Options[1].Default {
+		t.Errorf("expected the first option to be the default, got %v", questions[0].Options)
+	}
+	if d := questions[1].Default; d == nil || *d != 30 {
+		t.Errorf("expected the default 30, got %v", d)
+	}
+}
+
+func TestNewHIIPackageListErrors(t *testing.T) {
+	buf := hiiPackageList()
+	short := append([]byte{}, buf...)
+	binary.LittleEndian.PutUint32(short[16:], uint32(len(buf)+1))
+	// The first package is the string package, break its end block.
+	noEnd := append([]byte{}, buf...)
+	size := binary.LittleEndian.Uint32(noEnd[hiiPackageListHeaderSize:]) & 0xffffff
+	noEnd[hiiPackageListHeaderSize+size-1] = 0xff
+
+	for name, b := range map[string][]byte{"header": buf[:10], "length": short, "strings": noEnd} {
+		if _, err := NewHIIPackageList(b); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
+
+func TestScanHIIPackages(t *testing.T) {
+	list := hiiPackageList()
+	size := func(b []byte) int { return int(binary.LittleEndian.Uint32(b) & 0xffffff) }
+	strs := list[hiiPackageListHeaderSize:]
+	strs = strs[:size(strs)]
+	forms := list[hiiPackageListHeaderSize+len(strs):]
+	forms = forms[:size(forms)]
+
+	// The arrays are between other data, and aligned.
+	array := func(b []byte) []byte {
+		a := make([]byte, 4, 4+len(b)+3)
+		binary.LittleEndian.PutUint32(a, uint32(4+len(b)))
+		a = append(a, b...)
+		return append(a, make([]byte, -len(a)&3)...)
+	}
+	buf := bytes.Join([][]byte{make([]byte, 16), array(forms), make([]byte, 8), array(strs), make([]byte, 16)}, nil)
+
+	lists := ScanHIIPackages(buf)
+	if len(lists) != 1 {
+		t.Fatalf("expected 1 package list, got %d", len(lists))
+	}
+	l := lists[0]
+	if len(l.Strings) != 1 || l.Strings[0].Language != "en-US" {
+		t.Errorf("unexpected strings %+v", l.Strings)
+	}
+	if len(l.FormSets) != 1 || l.FormSets[0].Title != "Setup" || len(l.FormSets[0].Forms[0].Questions) != 2 {
+		t.Errorf("unexpected form sets %+v", l.FormSets)
+	}
+
+	if lists := ScanHIIPackages(make([]byte, 64)); len(lists) != 0 {
+		t.Errorf("expected no package lists, got %d", len(lists))
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Insyde H2O update files, e.g. isflash.bin or the H2OFFT executables, hold
+// the images written by the flash tool after $_IFLASH headers, which are
+// tagged with the kind of image, e.g. _BIOSIMG for the flash image. Anything
+// else, like the code of the tool, is kept as it is.
+
+// IFlashSignature starts each IFlash header.
+const IFlashSignature = "$_IFLASH"
+
+// IFlashHeaderSize is the size of an IFlash header.
+const IFlashHeaderSize = 24
+
+// IFlashTagBIOSImage tags the flash image, which is parsed.
+const IFlashTagBIOSImage = "_BIOSIMG"
+
+// iflashTotalSizeOffset is the offset of TotalSize in the IFlash header.
+const iflashTotalSizeOffset = 16
+
+// isIFlashTag returns whether tag is an image tag, e.g. _BIOSIMG or _INI_IMG.
+// Other occurrences of the signature, e.g. in the code of the flash tool, are
+// not headers.
+func isIFlashTag(tag []byte) bool {
+	if tag[0] != '_' {
+		return false
+	}
+	for _, c := range tag {
+		if (c < 'A' || c > 'Z') && (c < '0' || c > '9') && c != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// IFlashHeader precedes an image in an Insyde update file. The sizes do not
+// include the header, the image may be followed by padding up to TotalSize.
+type IFlashHeader struct {
+	Signature [8]uint8 `json:"-"`
+	Tag       [8]uint8 `json:"-"`
+	TotalSize uint32
+	ImageSize uint32
+}
+
+// IFlashImage is an image of an Insyde update file. The flash image is
+// parsed as the Payload if possible, otherwise it is kept as it is.
+type IFlashImage struct {
+	Header IFlashHeader
+	Tag    string
+	// Offset is the offset of the header in the update file.
+	Offset  uint64
+	Payload *TypedFirmware `json:",omitempty"`
+
+	// Metadata
+	ExtractPath string
+	Location
+
+	buf []byte
+}
+
+// NewIFlashImage parses the IFlash header and the image at the start of buf,
+// buf may be longer than the image.
+func NewIFlashImage(buf []byte) (*IFlashImage, error) {
+	img := IFlashImage{}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &img.Header); err != nil {
+		return nil, err
+	}
+	if string(img.Header.Signature[:]) != IFlashSignature {
+		return nil, fmt.Errorf("no %v signature", IFlashSignature)
+	}
+	img.Tag = string(img.Header.Tag[:])
+	if !isIFlashTag(img.Header.Tag[:]) {
+		return nil, fmt.Errorf("invalid IFlash image tag %q", img.Tag)
+	}
+	total := uint64(img.Header.TotalSize)
+	if uint64(img.Header.ImageSize) > total || IFlashHeaderSize+total > uint64(len(buf)) {
+		return nil, fmt.Errorf("IFlash image %v of %#x bytes, %#x with padding, does not fit into %#x bytes",
+			img.Tag, img.Header.ImageSize, total, len(buf)-IFlashHeaderSize)
+	}
+	img.buf = buf[:IFlashHeaderSize+total]
+	if img.Tag != IFlashTagBIOSImage {
+		return &img, nil
+	}
+	payload, err := Parse(img.buf[IFlashHeaderSize : IFlashHeaderSize+uint64(img.Header.ImageSize)])
+	if err != nil {
+		log.Printf("unable to parse the IFlash flash image, keeping it as it is: %v", err)
+		return &img, nil
+	}
+	img.Payload = MakeTyped(payload)
+	return &img, nil
+}
+
+// SetPayloadBuf replaces the image with buf, the padding after it is kept,
+// and updates the sizes in the header.
+func (img *IFlashImage) SetPayloadBuf(buf []byte) {
+	end := IFlashHeaderSize + uint64(img.Header.ImageSize)
+	nb := append(append(append([]byte{}, img.buf[:IFlashHeaderSize]...), buf...), img.buf[end:]...)
+	img.Header.TotalSize = uint32(len(nb) - IFlashHeaderSize)
+	img.Header.ImageSize = uint32(len(buf))
+	binary.LittleEndian.PutUint32(nb[iflashTotalSizeOffset:], img.Header.TotalSize)
+	binary.LittleEndian.PutUint32(nb[iflashTotalSizeOffset+4:], img.Header.ImageSize)
+	img.buf = nb
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (img *IFlashImage) Buf() []byte {
+	return img.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (img *IFlashImage) SetBuf(buf []byte) {
+	img.buf = buf
+}
+
+// Apply calls the visitor on the IFlashImage.
+func (img *IFlashImage) Apply(v Visitor) error {
+	return v.Visit(img)
+}
+
+// ApplyChildren calls the visitor on the payload of the IFlashImage.
+func (img *IFlashImage) ApplyChildren(v Visitor) error {
+	if img.Payload == nil {
+		return nil
+	}
+	return img.Payload.Value.Apply(v)
+}
+
+// Validate checks the image sizes and the payload.
+func (img *IFlashImage) Validate() []error {
+	var errs []error
+	if img.Header.ImageSize > img.Header.TotalSize {
+		errs = append(errs, fmt.Errorf("IFlash image %v of %#x bytes is larger than its total size %#x",
+			img.Tag, img.Header.ImageSize, img.Header.TotalSize))
+	}
+	if end := IFlashHeaderSize + uint64(img.Header.TotalSize); end > uint64(len(img.buf)) {
+		errs = append(errs, fmt.Errorf("IFlash image %v ends at %#x, past the end of its %#x bytes",
+			img.Tag, end, len(img.buf)))
+	}
+	if img.Payload != nil {
+		errs = append(errs, img.Payload.Value.Validate()...)
+	}
+	return errs
+}
+
+// findIFlashImages returns the images of an Insyde update file.
+func findIFlashImages(buf []byte) []*IFlashImage {
+	var images []*IFlashImage
+	for offset := 0; ; {
+		i := bytes.Index(buf[offset:], []byte(IFlashSignature))
+		if i < 0 {
+			return images
+		}
+		offset += i
+		img, err := NewIFlashImage(buf[offset:])
+		if err != nil {
+			offset += len(IFlashSignature)
+			continue
+		}
+		img.Offset = uint64(offset)
+		images = append(images, img)
+		offset += len(img.buf)
+	}
+}
+
+// isIFlashFile returns whether buf is an Insyde update file with a flash
+// image. Flash images hold the signature in the code of their drivers, but
+// their FVs come first.
+func isIFlashFile(buf []byte) bool {
+	i := bytes.Index(buf, []byte(IFlashSignature+IFlashTagBIOSImage))
+	if i < 0 {
+		return false
+	}
+	fv := FindFirmwareVolumeOffset(buf)
+	return fv < 0 || int(fv) > i
+}
+
+// IFlashFile is an Insyde H2O update file.
+type IFlashFile struct {
+	Images []*IFlashImage
+
+	// Metadata
+	ExtractPath string
+	Location
+
+	buf []byte
+}
+
+// NewIFlashFile parses the images of an Insyde update file.
+func NewIFlashFile(buf []byte) (*IFlashFile, error) {
+	f := IFlashFile{buf: buf, Images: findIFlashImages(buf)}
+	var tags []string
+	for _, img := range f.Images {
+		if img.Tag == IFlashTagBIOSImage {
+			return &f, nil
+		}
+		tags = append(tags, img.Tag)
+	}
+	return nil, fmt.Errorf("no %v image in the IFlash file, found %v", IFlashTagBIOSImage, strings.Join(tags, ", "))
+}
+
+// AssembleImages rebuilds the file from the buffers of its images, the data
+// around them is kept.
+func (f *IFlashFile) AssembleImages() {
+	var nb []byte
+	var prev uint64
+	for _, img := range f.Images {
+		nb = append(nb, f.buf[prev:img.Offset]...)
+		prev = img.Offset + IFlashHeaderSize + uint64(binary.LittleEndian.Uint32(f.buf[img.Offset+iflashTotalSizeOffset:]))
+		img.Offset = uint64(len(nb))
+		nb = append(nb, img.Buf()...)
+	}
+	f.buf = append(nb, f.buf[prev:]...)
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (f *IFlashFile) Buf() []byte {
+	return f.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (f *IFlashFile) SetBuf(buf []byte) {
+	f.buf = buf
+}
+
+// Apply calls the visitor on the IFlashFile.
+func (f *IFlashFile) Apply(v Visitor) error {
+	return v.Visit(f)
+}
+
+// ApplyChildren calls the visitor on each image of the IFlashFile.
+func (f *IFlashFile) ApplyChildren(v Visitor) error {
+	for _, img := range f.Images {
+		if err := img.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the images.
+func (f *IFlashFile) Validate() []error {
+	var errs []error
+	for _, img := range f.Images {
+		errs = append(errs, img.Validate()...)
+	}
+	return errs
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// makeIFlashImage returns an IFlash header with the tag and the image,
+// followed by padding.
+func makeIFlashImage(tag string, image []byte, padding int) []byte {
+	h := make([]byte, IFlashHeaderSize)
+	copy(h, IFlashSignature)
+	copy(h[8:], tag)
+	binary.LittleEndian.PutUint32(h[iflashTotalSizeOffset:], uint32(len(image)+padding))
+	binary.LittleEndian.PutUint32(h[iflashTotalSizeOffset+4:], uint32(len(image)))
+	return append(append(h, image...), bytes.Repeat([]byte{0xff}, padding)...)
+}
+
+// makeIFlashFile returns an update file with the code of the flash tool, an
+// INI image and the flash image.
+func makeIFlashFile(image []byte) []byte {
+	return bytes.Join([][]byte{
+		[]byte("MZ flash tool, looking for $_IFLASH%s"),
+		makeIFlashImage("_INI_IMG", []byte("[Platform]\r\n"), 4),
+		makeIFlashImage(IFlashTagBIOSImage, image, 0x10),
+		[]byte("trailer"),
+	}, nil)
+}
+
+func TestIFlashFile(t *testing.T) {
+	buf := makeIFlashFile(sampleFV)
+	if c := DetectContainer(buf); c != ContainerIFlashFile {
+		t.Fatalf("expected an Insyde update file, got %v", c)
+	}
+	fw, err := Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, ok := fw.(*IFlashFile)
+	if !ok || len(f.Images) != 2 {
+		t.Fatalf("expected an IFlash file with 2 images, got %T %+v", fw, fw)
+	}
+	ini, bios := f.Images[0], f.Images[1]
+	if ini.Tag != "_INI_IMG" || ini.Payload != nil || ini.Offset != uint64(bytes.Index(buf, []byte("$_IFLASH_INI"))) {
+		t.Errorf("unexpected INI image %+v", ini)
+	}
+	if fv, ok := bios.Payload.Value.(*FirmwareVolume); !ok || len(fv.Files) != 3 {
+		t.Fatalf("expected the FV as the payload, got %v", bios.Payload)
+	}
+	for _, err := range f.Validate() {
+		t.Error(err)
+	}
+
+	// The sizes are updated when the image grows, the padding and the data
+	// around the images are kept.
+	grown := append(append([]byte{}, sampleFV...), 0xff, 0xff, 0xff, 0xff)
+	bios.SetPayloadBuf(grown)
+	f.AssembleImages()
+	if !bytes.Equal(f.Buf(), makeIFlashFile(grown)) {
+		t.Error("unexpected IFlash file after replacing the flash image")
+	}
+}
+
+func TestIFlashFileDetection(t *testing.T) {
+	// Flash images have the tag in their drivers, after their first FV.
+	region := append(append([]byte{}, sampleFV...), IFlashSignature+IFlashTagBIOSImage...)
+	if c := DetectContainer(region); c == ContainerIFlashFile {
+		t.Errorf("expected an FV, got %v", c)
+	}
+
+	// Update files need a flash image.
+	for name, buf := range map[string][]byte{
+		"noBIOS":    makeIFlashImage("_INI_IMG", []byte("[Platform]"), 0),
+		"truncated": makeIFlashFile(sampleFV)[:0x200],
+	} {
+		if _, err := NewIFlashFile(buf); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
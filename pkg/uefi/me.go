@@ -0,0 +1,352 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Signatures found in the ME region
+var (
+	FPTSignature      = []byte("$FPT")
+	CPDSignature      = []byte("$CPD")
+	ManifestSignature = []byte("$MN2")
+	SKUSignature      = []byte("$SKU")
+)
+
+const (
+	// FPTHeaderLength is the length of the FPT header following the
+	// signature, including it.
+	FPTHeaderLength = 0x20
+	// FPTEntryLength is the length of an FPT partition entry.
+	FPTEntryLength = 0x20
+	// fptSearchLimit is how far into the ME region the FPT is searched. It is
+	// at 0 or after the 16 byte ROM bypass vector.
+	fptSearchLimit = 0x20
+)
+
+// FPTHeader is the header of the flash partition table of the ME region.
+type FPTHeader struct {
+	Signature       [4]byte
+	NumFPTEntries   uint32
+	HeaderVersion   uint8
+	EntryVersion    uint8
+	HeaderLength    uint8
+	HeaderChecksum  uint8
+	FlashCycleLife  uint16
+	FlashCycleLimit uint16
+	UMASize         uint32
+	Flags           uint32
+	FitMajor        uint16
+	FitMinor        uint16
+	FitHotfix       uint16
+	FitBuild        uint16
+}
+
+// FPTEntry describes an ME partition. Offsets are relative to the start of
+// the ME region.
+type FPTEntry struct {
+	Name           [4]byte
+	Owner          [4]byte
+	Offset         uint32
+	Length         uint32
+	StartTokens    uint32
+	MaxTokens      uint32
+	ScratchSectors uint32
+	Flags          uint32
+}
+
+// PartitionName returns the name of the partition.
+func (e *FPTEntry) PartitionName() string {
+	return strings.TrimRight(string(e.Name[:]), "\x00 ")
+}
+
+// Valid returns whether the partition has data in the region.
+func (e *FPTEntry) Valid() bool {
+	return e.Offset != 0 && e.Offset != 0xffffffff && e.Length != 0 && e.Length != 0xffffffff
+}
+
+// FPT is the flash partition table of the ME region.
+type FPT struct {
+	// Offset of the signature in the ME region.
+	Offset  uint32
+	Header  FPTHeader
+	Entries []FPTEntry
+}
+
+// FindFPTSignature returns the offset of the FPT in the ME region.
+func FindFPTSignature(buf []byte) (int, error) {
+	limit := fptSearchLimit
+	if len(buf) < limit+len(FPTSignature) {
+		limit = len(buf) - len(FPTSignature)
+	}
+	for off := 0; off <= limit; off += 0x10 {
+		if bytes.Equal(buf[off:off+len(FPTSignature)], FPTSignature) {
+			return off, nil
+		}
+	}
+	return -1, fmt.Errorf("no %s signature found in the ME region", FPTSignature)
+}
+
+// NewFPT parses the flash partition table at the start of the ME region.
+func NewFPT(buf []byte) (*FPT, error) {
+	off, err := FindFPTSignature(buf)
+	if err != nil {
+		return nil, err
+	}
+	fpt := FPT{Offset: uint32(off)}
+	r := bytes.NewReader(buf[off:])
+	if err := binary.Read(r, binary.LittleEndian, &fpt.Header); err != nil {
+		return nil, err
+	}
+	n := uint64(fpt.Header.NumFPTEntries)
+	if uint64(off)+FPTHeaderLength+n*FPTEntryLength > uint64(len(buf)) {
+		return nil, fmt.Errorf("%d FPT entries do not fit into the %#x bytes ME region", n, len(buf))
+	}
+	fpt.Entries = make([]FPTEntry, n)
+	if err := binary.Read(r, binary.LittleEndian, fpt.Entries); err != nil {
+		return nil, err
+	}
+	return &fpt, nil
+}
+
+// Entry returns the entry of the partition with the given name.
+func (fpt *FPT) Entry(name string) (*FPTEntry, error) {
+	for i := range fpt.Entries {
+		if fpt.Entries[i].PartitionName() == name {
+			return &fpt.Entries[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no partition %q in the FPT", name)
+}
+
+// ManifestHeader is the start of an ME code partition manifest.
+type ManifestHeader struct {
+	ModuleType    uint16
+	ModuleSubType uint16
+	HeaderLength  uint32
+	HeaderVersion uint32
+	Flags         uint32
+	Vendor        uint32
+	Date          uint32
+	Size          uint32
+	Tag           [4]byte
+	NumModules    uint32
+	Major         uint16
+	Minor         uint16
+	Hotfix        uint16
+	Build         uint16
+}
+
+// manifestDebug is the manifest flag set on pre-production firmware.
+const manifestDebug = 1 << 31
+
+// MEVersion is the version of the ME firmware, read from the manifest of the
+// FTPR partition.
+type MEVersion struct {
+	Major  uint16
+	Minor  uint16
+	Hotfix uint16
+	Build  uint16
+	// Date is the manifest date, encoded as BCD yyyymmdd.
+	Date uint32
+	// Debug is set for pre-production firmware.
+	Debug bool
+	// SKU holds the raw SKU attributes, if the manifest has them. Their
+	// meaning depends on the firmware generation.
+	SKU []byte `json:",omitempty"`
+}
+
+func (v *MEVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d.%d", v.Major, v.Minor, v.Hotfix, v.Build)
+}
+
+// findManifest returns the offset of the manifest in the code partition.
+// CSME 11 and later partitions start with a code partition directory, which
+// lists the manifest as a file ending in ".man".
+func findManifest(part []byte) (int, error) {
+	if len(part) >= 16 && bytes.Equal(part[:4], CPDSignature) {
+		n := int(binary.LittleEndian.Uint32(part[4:]))
+		hl := int(part[10])
+		for i := 0; i < n; i++ {
+			e := hl + i*24
+			if e+24 > len(part) {
+				break
+			}
+			name := strings.TrimRight(string(part[e:e+12]), "\x00")
+			if strings.HasSuffix(name, ".man") {
+				return int(binary.LittleEndian.Uint32(part[e+12:]) & 0x1ffffff), nil
+			}
+		}
+		return -1, fmt.Errorf("no manifest in the code partition directory")
+	}
+	// Older partitions start with the manifest.
+	return 0, nil
+}
+
+// NewMEVersion reads the firmware version from the FTPR (or on older
+// firmware, the single code) partition.
+func NewMEVersion(part []byte) (*MEVersion, error) {
+	off, err := findManifest(part)
+	if err != nil {
+		return nil, err
+	}
+	var h ManifestHeader
+	if off < 0 || off+binary.Size(h) > len(part) {
+		return nil, fmt.Errorf("manifest at %#x out of bounds", off)
+	}
+	if err := binary.Read(bytes.NewReader(part[off:]), binary.LittleEndian, &h); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(h.Tag[:], ManifestSignature) {
+		return nil, fmt.Errorf("no %s manifest at %#x, tag is %q", ManifestSignature, off, h.Tag)
+	}
+	v := &MEVersion{
+		Major:  h.Major,
+		Minor:  h.Minor,
+		Hotfix: h.Hotfix,
+		Build:  h.Build,
+		Date:   h.Date,
+		Debug:  h.Flags&manifestDebug != 0,
+	}
+	// The SKU attributes follow their tag in the manifest.
+	end := off + int(h.Size)*4
+	if end > len(part) || end < off {
+		end = len(part)
+	}
+	if i := bytes.Index(part[off:end], SKUSignature); i >= 0 && off+i+16 <= end {
+		v.SKU = append([]byte{}, part[off+i+8:off+i+16]...)
+	}
+	return v, nil
+}
+
+// codePartitions are the partitions holding the main ME firmware, by
+// preference.
+var codePartitions = []string{"FTPR", "CODE"}
+
+// parse reads the partition table, firmware version and file system. The ME
+// region stays usable as an opaque blob if they cannot be parsed.
+func (me *MERegion) parse() error {
+	fpt, err := NewFPT(me.buf)
+	if err != nil {
+		return err
+	}
+	me.FPT = fpt
+	me.MFS = nil
+	if part, err := me.Partition(MFSPartitionName); err == nil {
+		if me.MFS, err = NewMFS(part); err != nil {
+			log.Printf("unable to parse MFS: %v", err)
+		}
+	}
+	for _, name := range codePartitions {
+		e, err := fpt.Entry(name)
+		if err != nil || !e.Valid() {
+			continue
+		}
+		if uint64(e.Offset)+uint64(e.Length) > uint64(len(me.buf)) {
+			return fmt.Errorf("partition %v at %#x is beyond the end of the ME region", name, e.Offset)
+		}
+		me.Version, err = NewMEVersion(me.buf[e.Offset : e.Offset+e.Length])
+		return err
+	}
+	return fmt.Errorf("no code partition in the FPT")
+}
+
+// Partition returns the data of the named partition.
+func (me *MERegion) Partition(name string) ([]byte, error) {
+	if me.FPT == nil {
+		return nil, fmt.Errorf("ME region has no partition table")
+	}
+	e, err := me.FPT.Entry(name)
+	if err != nil {
+		return nil, err
+	}
+	if !e.Valid() {
+		return nil, fmt.Errorf("partition %v has no data in the ME region", name)
+	}
+	if uint64(e.Offset)+uint64(e.Length) > uint64(len(me.buf)) {
+		return nil, fmt.Errorf("partition %v at %#x is beyond the end of the ME region", name, e.Offset)
+	}
+	return me.buf[e.Offset : e.Offset+e.Length], nil
+}
+
+// partitionLimit returns the offset at which the space available to the
+// partition at offset ends, i.e. the start of the next partition.
+func (me *MERegion) partitionLimit(offset uint32) uint32 {
+	limit := uint32(len(me.buf))
+	for _, e := range me.FPT.Entries {
+		if e.Valid() && e.Offset > offset && e.Offset < limit {
+			limit = e.Offset
+		}
+	}
+	return limit
+}
+
+// fptChecksumRange returns the bytes covered by the 8 bit header checksum
+// of version 1 and 2.0 partition tables, which includes the ROM bypass
+// vector, and the offset of the checksum in them.
+func fptChecksumRange(buf []byte, fpt *FPT) ([]byte, int, error) {
+	switch fpt.Header.HeaderVersion {
+	case 0x10, 0x20:
+	default:
+		return nil, 0, fmt.Errorf("unsupported FPT header version %#x", fpt.Header.HeaderVersion)
+	}
+	start := fpt.Offset
+	if start >= 0x10 && fpt.Header.HeaderLength == 0x30 {
+		start -= 0x10
+	}
+	end := uint64(start) + uint64(fpt.Header.HeaderLength)
+	csum := int(fpt.Offset-start) + 11
+	if end > uint64(len(buf)) || csum >= int(end)-int(start) {
+		return nil, 0, fmt.Errorf("FPT header out of bounds")
+	}
+	return buf[start:end], csum, nil
+}
+
+// ReplacePartition replaces the data of the named partition. The partition
+// may grow into the space up to the next partition. The FPT entry and, for
+// known header versions, the header checksum are updated. The partitions
+// themselves are not re-signed, so replacing a signed partition with
+// anything but a validly signed one leaves an image the ME refuses to boot.
+func (me *MERegion) ReplacePartition(name string, data []byte) error {
+	if _, err := me.Partition(name); err != nil {
+		return err
+	}
+	i := 0
+	for ; me.FPT.Entries[i].PartitionName() != name; i++ {
+	}
+	e := me.FPT.Entries[i]
+	limit := me.partitionLimit(e.Offset)
+	if uint64(e.Offset)+uint64(len(data)) > uint64(limit) {
+		return fmt.Errorf("partition %v of %#x bytes does not fit into the %#x bytes at %#x",
+			name, len(data), limit-e.Offset, e.Offset)
+	}
+	buf := append([]byte{}, me.buf...)
+	// Any old data beyond the new length is erased.
+	for j := e.Offset + uint32(len(data)); j < e.Offset+e.Length; j++ {
+		buf[j] = 0xff
+	}
+	copy(buf[e.Offset:], data)
+
+	e.Length = uint32(len(data))
+	entry := new(bytes.Buffer)
+	if err := binary.Write(entry, binary.LittleEndian, &e); err != nil {
+		return err
+	}
+	copy(buf[me.FPT.Offset+FPTHeaderLength+uint32(i)*FPTEntryLength:], entry.Bytes())
+
+	if r, csum, err := fptChecksumRange(buf, me.FPT); err != nil {
+		log.Printf("not updating the FPT checksum: %v", err)
+	} else {
+		r[csum] = 0
+		r[csum] = 0 - Checksum8(r)
+	}
+	me.buf = buf
+	return me.parse()
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// makeMERegion returns an ME region with the FPT after the ROM bypass vector
+// and the FTPR partition at 0x1000. With cpd set, the partition starts with a
+// code partition directory and the manifest is at 0x100 in the partition.
+func makeMERegion(cpd bool) []byte {
+	buf := bytes.Repeat([]byte{0xff}, 0x3000)
+	copy(buf[:0x10], make([]byte, 0x10))
+	fpt := buf[0x10:]
+	copy(fpt, FPTSignature)
+	binary.LittleEndian.PutUint32(fpt[4:], 2)
+	fpt[8], fpt[9], fpt[10] = 0x20, 0x10, 0x30
+	entries := []struct {
+		name           string
+		offset, length uint32
+	}{
+		{"FTPR", 0x1000, 0x1000},
+		{"NFTP", 0x2000, 0x1000},
+	}
+	for i, e := range entries {
+		b := fpt[FPTHeaderLength+i*FPTEntryLength:]
+		copy(b, make([]byte, FPTEntryLength))
+		copy(b, e.name)
+		binary.LittleEndian.PutUint32(b[8:], e.offset)
+		binary.LittleEndian.PutUint32(b[12:], e.length)
+	}
+
+	part := buf[0x1000:0x2000]
+	man := part
+	if cpd {
+		copy(part, CPDSignature)
+		binary.LittleEndian.PutUint32(part[4:], 1)
+		part[10] = 0x10 // header length
+		copy(part[0x10:], "FTPR.man\x00\x00\x00\x00")
+		binary.LittleEndian.PutUint32(part[0x1c:], 0x100)
+		man = part[0x100:]
+	}
+	binary.LittleEndian.PutUint32(man[0x0c:], manifestDebug)
+	binary.LittleEndian.PutUint32(man[0x14:], 0x20180510)
+	binary.LittleEndian.PutUint32(man[0x18:], 0x40) // size in dwords
+	copy(man[0x1c:], ManifestSignature)
+	for i, v := range []uint16{11, 8, 50, 3425} {
+		binary.LittleEndian.PutUint16(man[0x24+2*i:], v)
+	}
+	copy(man[0x80:], SKUSignature)
+	copy(man[0x88:], []byte{1, 2, 3, 4, 5, 6, 7, 8})
+	return buf
+}
+
+func TestMEVersion(t *testing.T) {
+	for _, cpd := range []bool{false, true} {
+		me, err := NewMERegion(makeMERegion(cpd), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if me.FPT == nil || len(me.FPT.Entries) != 2 || me.FPT.Offset != 0x10 {
+			t.Fatalf("unexpected FPT %+v", me.FPT)
+		}
+		if name := me.FPT.Entries[1].PartitionName(); name != "NFTP" {
+			t.Errorf("expected the second partition to be NFTP, got %q", name)
+		}
+		v := me.Version
+		if v == nil {
+			t.Fatalf("no version found with CPD %v", cpd)
+		}
+		if v.String() != "11.8.50.3425" || !v.Debug || v.Date != 0x20180510 {
+			t.Errorf("unexpected version %v, debug %v, date %#x", v, v.Debug, v.Date)
+		}
+		if !bytes.Equal(v.SKU, []byte{1, 2, 3, 4, 5, 6, 7, 8}) {
+			t.Errorf("unexpected SKU attributes %x", v.SKU)
+		}
+	}
+}
+
+func TestMERegionWithoutFPT(t *testing.T) {
+	me, err := NewMERegion(bytes.Repeat([]byte{0xff}, 0x1000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if me.FPT != nil || me.Version != nil {
+		t.Errorf("expected an opaque ME region, got FPT %v, version %v", me.FPT, me.Version)
+	}
+}
+
+func TestMEReplacePartition(t *testing.T) {
+	buf := makeMERegion(false)
+	copy(buf[0x2000:], bytes.Repeat([]byte{0xaa}, 0x1000))
+	me, err := NewMERegion(buf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := me.Partition("FTPR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Shrink the NFTP partition, the rest of its space is erased.
+	if err := me.ReplacePartition("NFTP", []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	part, err := me.Partition("NFTP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(part, []byte{1, 2, 3}) {
+		t.Errorf("unexpected partition %x", part)
+	}
+	buf = me.Buf()
+	if buf[0x2003] != 0xff || buf[0x2fff] != 0xff {
+		t.Errorf("old partition data not erased")
+	}
+	if sum := Checksum8(buf[:0x30]); sum != 0 {
+		t.Errorf("FPT checksum is off by %#x", sum)
+	}
+	// The version is parsed again and the FTPR partition is unchanged.
+	if p, _ := me.Partition("FTPR"); !bytes.Equal(p, old) || me.Version == nil {
+		t.Errorf("FTPR partition changed")
+	}
+	// Partitions cannot grow into the next one.
+	if err := me.ReplacePartition("FTPR", make([]byte, 0x1001)); err == nil {
+		t.Errorf("expected an error growing FTPR over NFTP")
+	}
+	if err := me.ReplacePartition("NONE", nil); err == nil {
+		t.Errorf("expected an error replacing a missing partition")
+	}
+}
@@ -7,6 +7,7 @@ package uefi
 import (
 	"errors"
 	"fmt"
+	"log"
 )
 
 // MERegion represents the ME Region in the firmware.
@@ -15,8 +16,14 @@ type MERegion struct {
 	buf []byte
 	//Metadata for extraction and recovery
 	ExtractPath string
+	Location
 	// This is a pointer to the Region struct laid out in the ifd
 	Position *Region
+
+	// Parsed from the region, if possible.
+	FPT     *FPT       `json:",omitempty"`
+	Version *MEVersion `json:",omitempty"`
+	MFS     *MFS       `json:",omitempty"`
 }
 
 // NewMERegion parses a sequence of bytes and returns a MERegion
@@ -24,6 +31,9 @@ type MERegion struct {
 // Region struct uncovered in the ifd.
 func NewMERegion(buf []byte, r *Region) (*MERegion, error) {
 	me := MERegion{buf: buf, Position: r}
+	if err := me.parse(); err != nil {
+		log.Printf("unable to parse ME region: %v", err)
+	}
 	return &me, nil
 }
 
@@ -0,0 +1,427 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// MFS layout constants, as described in "Intel ME: Flash File System
+// Explained" by Dmitry Sklyarov.
+const (
+	// MFSPartitionName is the name of the FPT entry of the MFS.
+	MFSPartitionName = "MFS"
+	// MFSPageSignature starts every page in use.
+	MFSPageSignature = 0xAA557887
+	MFSPageSize      = 0x2000
+	// MFSChunkSize is the size of the chunk payload. Each chunk is followed
+	// by a 16 bit CRC.
+	MFSChunkSize = 64
+	// MFSSystemChunks and MFSDataChunks are the number of chunks in system
+	// and data pages.
+	MFSSystemChunks = 120
+	MFSDataChunks   = 122
+
+	// MFSVolumeSignature starts the system area.
+	MFSVolumeSignature = 0x724F6201
+	// mfsPagesPerSystemPage is the ratio of all pages to system pages.
+	mfsPagesPerSystemPage = 12
+
+	mfsChunkCRCSize = 2
+)
+
+// MFSPageHeader is the header at the start of an MFS page.
+type MFSPageHeader struct {
+	Signature  uint32
+	USN        uint32
+	NErase     uint32
+	INextErase uint16
+	// FirstChunk is the index of the first chunk of a data page, and 0 for
+	// system pages.
+	FirstChunk uint16
+	Checksum   uint8
+	_          uint8
+}
+
+// MFSPageKind tells system, data and spare pages apart.
+type MFSPageKind uint8
+
+// MFS page kinds
+const (
+	MFSPageSpare MFSPageKind = iota
+	MFSPageSystem
+	MFSPageData
+)
+
+func (k MFSPageKind) String() string {
+	switch k {
+	case MFSPageSpare:
+		return "Spare"
+	case MFSPageSystem:
+		return "System"
+	case MFSPageData:
+		return "Data"
+	}
+	return fmt.Sprintf("MFSPageKind(%d)", uint8(k))
+}
+
+// MFSPage is a page of the MFS partition.
+type MFSPage struct {
+	// Offset of the page in the partition.
+	Offset uint32
+	Header MFSPageHeader
+	Kind   MFSPageKind
+	// UsedChunks is the number of chunks of a data page in use.
+	UsedChunks int `json:",omitempty"`
+}
+
+// MFS is the ME file system. The system pages hold the volume header and
+// the file allocation table, the data pages hold the file data.
+type MFS struct {
+	buf   []byte
+	Pages []MFSPage
+
+	// Parsed from the system area, if possible.
+	Volume *MFSVolumeHeader  `json:",omitempty"`
+	Files  []MFSFile         `json:",omitempty"`
+	Config []MFSConfigRecord `json:",omitempty"`
+
+	// fat links each file to its first data chunk and each data chunk to
+	// the next one.
+	fat []uint16
+	// sysChunks is the number of chunks in the system area, which come
+	// before the data chunks in the chunk numbering.
+	sysChunks int
+}
+
+// NewMFS parses the pages of an MFS partition and, if possible, the file
+// table and configuration records.
+func NewMFS(buf []byte) (*MFS, error) {
+	if len(buf) < MFSPageSize || len(buf)%MFSPageSize != 0 {
+		return nil, fmt.Errorf("MFS size %#x is not a multiple of the %#x bytes page size", len(buf), MFSPageSize)
+	}
+	m := &MFS{buf: buf}
+	for off := 0; off < len(buf); off += MFSPageSize {
+		p := MFSPage{Offset: uint32(off)}
+		if err := binary.Read(bytes.NewReader(buf[off:]), binary.LittleEndian, &p.Header); err != nil {
+			return nil, err
+		}
+		switch {
+		case p.Header.Signature != MFSPageSignature:
+			p.Kind = MFSPageSpare
+		case p.Header.FirstChunk == 0:
+			p.Kind = MFSPageSystem
+		default:
+			p.Kind = MFSPageData
+			// The free chunk map follows the header, 0xff marks a free chunk.
+			free := buf[off+binary.Size(p.Header):][:MFSDataChunks]
+			for _, f := range free {
+				if f != 0xff {
+					p.UsedChunks++
+				}
+			}
+		}
+		m.Pages = append(m.Pages, p)
+	}
+	if m.count(MFSPageSystem) == 0 {
+		return nil, fmt.Errorf("no MFS system pages found")
+	}
+	if err := m.parseSystem(); err != nil {
+		log.Printf("unable to parse MFS file table: %v", err)
+	}
+	return m, nil
+}
+
+// count returns the number of pages of the given kind.
+func (m *MFS) count(kind MFSPageKind) int {
+	n := 0
+	for _, p := range m.Pages {
+		if p.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Count returns the number of system, data and spare pages.
+func (m *MFS) Count() (system, data, spare int) {
+	return m.count(MFSPageSystem), m.count(MFSPageData), m.count(MFSPageSpare)
+}
+
+// DataChunk returns the payload of the data chunk with index i.
+func (m *MFS) DataChunk(i int) ([]byte, error) {
+	for _, p := range m.Pages {
+		first := int(p.Header.FirstChunk)
+		if p.Kind != MFSPageData || i < first || i >= first+MFSDataChunks {
+			continue
+		}
+		off := int(p.Offset) + binary.Size(p.Header) + MFSDataChunks +
+			(i-first)*(MFSChunkSize+mfsChunkCRCSize)
+		return m.buf[off : off+MFSChunkSize], nil
+	}
+	return nil, fmt.Errorf("no MFS data chunk %d", i)
+}
+
+// MFSVolumeHeader starts the system area. It is followed by the file
+// allocation table, with one entry per file and then one per data chunk.
+type MFSVolumeHeader struct {
+	Signature uint32
+	Version   uint32
+	Size      uint32
+	NumFiles  uint16
+}
+
+// Special values of the allocation table.
+const (
+	mfsFATUnused = 0x0000
+	mfsFATErased = 0xfffe
+	mfsFATEmpty  = 0xffff
+)
+
+// MFSFile is a file of the MFS. Files have no names, only indices.
+type MFSFile struct {
+	Index int
+	Size  int
+}
+
+// mfsCRC16 is the CRC-16 (polynomial 0x1021) used by the MFS for the chunk
+// CRCs and the obfuscation of system chunk indices.
+func mfsCRC16(crc uint16, data []byte) uint16 {
+	for _, b := range data {
+		crc ^= uint16(b) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// mfsCRC16Init is the initial value of the MFS CRC.
+const mfsCRC16Init = 0x3fff
+
+// mfsIndexKey returns the value the index of a system chunk is XORed with,
+// which depends on the index of the previous chunk of the page.
+func mfsIndexKey(prev uint16) uint16 {
+	return mfsCRC16(mfsCRC16Init, []byte{byte(prev), byte(prev >> 8)})
+}
+
+// systemChunks returns the chunks of the system area by index. Chunks are
+// rewritten to new pages, so the chunk from the page with the highest update
+// sequence number wins.
+func (m *MFS) systemChunks() map[int][]byte {
+	var pages []MFSPage
+	for _, p := range m.Pages {
+		if p.Kind == MFSPageSystem {
+			pages = append(pages, p)
+		}
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return pages[i].Header.USN < pages[j].Header.USN })
+
+	chunks := make(map[int][]byte)
+	for _, p := range pages {
+		page := m.buf[p.Offset : p.Offset+MFSPageSize]
+		idx := page[binary.Size(p.Header):]
+		data := idx[(MFSSystemChunks+1)*2:]
+		prev := uint16(0)
+		for i := 0; i < MFSSystemChunks; i++ {
+			x := binary.LittleEndian.Uint16(idx[2*i:])
+			if x == 0xffff {
+				break
+			}
+			c := x ^ mfsIndexKey(prev)
+			off := i * (MFSChunkSize + mfsChunkCRCSize)
+			chunks[int(c)] = data[off : off+MFSChunkSize]
+			prev = c
+		}
+	}
+	return chunks
+}
+
+// parseSystem reads the volume header and allocation table from the system
+// area, and the files and configuration records from the data area.
+func (m *MFS) parseSystem() error {
+	chunks := m.systemChunks()
+	var sys []byte
+	for i := 0; chunks[i] != nil; i++ {
+		sys = append(sys, chunks[i]...)
+	}
+	var vh MFSVolumeHeader
+	if len(sys) < binary.Size(vh) {
+		return fmt.Errorf("MFS system area of %#x bytes is too small", len(sys))
+	}
+	if err := binary.Read(bytes.NewReader(sys), binary.LittleEndian, &vh); err != nil {
+		return err
+	}
+	if vh.Signature != MFSVolumeSignature {
+		return fmt.Errorf("MFS volume signature %#x, expected %#x", vh.Signature, MFSVolumeSignature)
+	}
+	nSys := len(m.Pages) / mfsPagesPerSystemPage
+	nData := len(m.Pages) - nSys - 1
+	if nSys <= 0 || nData <= 0 {
+		return fmt.Errorf("%d MFS pages are not enough for system and data pages", len(m.Pages))
+	}
+	n := int(vh.NumFiles) + nData*MFSDataChunks
+	start := binary.Size(vh)
+	if start+n*2 > len(sys) {
+		return fmt.Errorf("MFS allocation table of %d entries does not fit into the system area", n)
+	}
+	m.fat = make([]uint16, n)
+	if err := binary.Read(bytes.NewReader(sys[start:]), binary.LittleEndian, m.fat); err != nil {
+		return err
+	}
+	m.sysChunks = nSys * MFSSystemChunks
+	m.Volume = &vh
+
+	m.Files = nil
+	for i := 0; i < int(vh.NumFiles); i++ {
+		data, err := m.File(i)
+		if err != nil {
+			continue
+		}
+		m.Files = append(m.Files, MFSFile{Index: i, Size: len(data)})
+	}
+	for _, i := range mfsConfigFiles {
+		data, err := m.File(i)
+		if err != nil {
+			continue
+		}
+		if m.Config, err = NewMFSConfig(data); err != nil {
+			return fmt.Errorf("MFS file %d: %v", i, err)
+		}
+		break
+	}
+	return nil
+}
+
+// File returns the data of the file with the given index.
+func (m *MFS) File(i int) ([]byte, error) {
+	if m.Volume == nil {
+		return nil, fmt.Errorf("MFS has no file table")
+	}
+	nFiles := int(m.Volume.NumFiles)
+	if i < 0 || i >= nFiles {
+		return nil, fmt.Errorf("MFS file %d out of range, there are %d files", i, nFiles)
+	}
+	ind := m.fat[i]
+	switch ind {
+	case mfsFATUnused, mfsFATErased:
+		return nil, fmt.Errorf("MFS file %d is not in use", i)
+	case mfsFATEmpty:
+		return []byte{}, nil
+	}
+	var data []byte
+	// Guard against loops in the chain.
+	for n := 0; n < len(m.fat); n++ {
+		if int(ind) < nFiles || int(ind) >= len(m.fat) {
+			return nil, fmt.Errorf("MFS file %d links to invalid chunk %#x", i, ind)
+		}
+		chunk, err := m.DataChunk(m.sysChunks + int(ind) - nFiles)
+		if err != nil {
+			return nil, err
+		}
+		ind = m.fat[ind]
+		// The entry of the last chunk is the number of bytes used in it.
+		if ind <= MFSChunkSize {
+			return append(data, chunk[:ind]...), nil
+		}
+		data = append(data, chunk...)
+	}
+	return nil, fmt.Errorf("MFS file %d has a loop in its chunk chain", i)
+}
+
+// mfsConfigFiles are the files holding configuration records, by preference:
+// fitc.cfg with the OEM settings and intel.cfg with the defaults.
+var mfsConfigFiles = []int{7, 6}
+
+// MFSConfigRecordHeader describes a file or directory in a configuration
+// file.
+type MFSConfigRecordHeader struct {
+	Name    [12]byte
+	_       uint16
+	Mode    uint16
+	Options uint16
+	Size    uint16
+	UID     uint16
+	GID     uint16
+	// Offset of the data from the start of the configuration file.
+	Offset uint32
+}
+
+// mfsModeDir is the mode bit of directories.
+const mfsModeDir = 0x1000
+
+// MFSConfigRecord is a file or directory in a configuration file, such as
+// the settings of the platform (including the HAP related ones) provisioned
+// with the FITC tool.
+type MFSConfigRecord struct {
+	Header MFSConfigRecordHeader
+	// Path of the record, with its parent directories.
+	Path string
+	Data []byte `json:"-"`
+}
+
+// IsDir returns whether the record is a directory.
+func (r *MFSConfigRecord) IsDir() bool {
+	return r.Header.Mode&mfsModeDir != 0
+}
+
+// NewMFSConfig parses the records of a configuration file. A directory
+// record is followed by its children and a ".." record.
+func NewMFSConfig(buf []byte) ([]MFSConfigRecord, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("configuration file of %d bytes is too small", len(buf))
+	}
+	n := uint64(binary.LittleEndian.Uint32(buf))
+	hs := uint64(binary.Size(MFSConfigRecordHeader{}))
+	if 4+n*hs > uint64(len(buf)) {
+		return nil, fmt.Errorf("%d configuration records do not fit into %#x bytes", n, len(buf))
+	}
+	headers := make([]MFSConfigRecordHeader, n)
+	if err := binary.Read(bytes.NewReader(buf[4:]), binary.LittleEndian, headers); err != nil {
+		return nil, err
+	}
+	var records []MFSConfigRecord
+	var dirs []string
+	for _, h := range headers {
+		name := strings.TrimRight(string(h.Name[:]), "\x00")
+		if name == ".." {
+			if len(dirs) > 0 {
+				dirs = dirs[:len(dirs)-1]
+			}
+			continue
+		}
+		r := MFSConfigRecord{Header: h, Path: "/" + strings.Join(append(dirs, name), "/")}
+		if r.IsDir() {
+			dirs = append(dirs, name)
+		} else {
+			end := uint64(h.Offset) + uint64(h.Size)
+			if end > uint64(len(buf)) {
+				return nil, fmt.Errorf("data of configuration record %v is beyond the end of the file", r.Path)
+			}
+			r.Data = buf[h.Offset:end]
+		}
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+// ConfigRecord returns the configuration record with the given path.
+func (m *MFS) ConfigRecord(path string) (*MFSConfigRecord, error) {
+	for i := range m.Config {
+		if m.Config[i].Path == path {
+			return &m.Config[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no MFS configuration record %v", path)
+}
@@ -0,0 +1,141 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// makeMFS returns an MFS of one system page, eleven data pages and a spare
+// page. There are more files than bytes in a chunk, as in real images, so
+// chunk links and the byte counts of last chunks can be told apart. File 2 holds "hello", file 3 is empty and file 7 is a configuration
+// file spanning two chunks.
+func makeMFS() []byte {
+	const nFiles, nPages = 100, 13
+	buf := bytes.Repeat([]byte{0xff}, nPages*MFSPageSize)
+	hs := binary.Size(MFSPageHeader{})
+	for i := 0; i < nPages-1; i++ {
+		page := buf[i*MFSPageSize:]
+		binary.LittleEndian.PutUint32(page, MFSPageSignature)
+		binary.LittleEndian.PutUint32(page[4:], uint32(i))
+		first := uint16(0)
+		if i > 0 {
+			first = uint16(MFSSystemChunks + (i-1)*MFSDataChunks)
+		}
+		binary.LittleEndian.PutUint16(page[14:], first)
+	}
+
+	// Data chunks, all in the first data page.
+	cfg := new(bytes.Buffer)
+	binary.Write(cfg, binary.LittleEndian, uint32(4))
+	records := []struct {
+		name       string
+		mode, size uint16
+		offset     uint32
+	}{
+		{"home", mfsModeDir | 0755, 0, 0},
+		{"hap", 0644, 1, 116},
+		{"..", 0, 0, 0},
+		{"top", 0644, 2, 117},
+	}
+	for _, r := range records {
+		h := MFSConfigRecordHeader{Mode: r.mode, Size: r.size, Offset: r.offset}
+		copy(h.Name[:], r.name)
+		binary.Write(cfg, binary.LittleEndian, &h)
+	}
+	cfg.Write([]byte{1, 0xaa, 0xbb})
+	data := buf[MFSPageSize:]
+	free := data[hs:]
+	copy(free, []byte{0, 0, 0})
+	chunks := data[hs+MFSDataChunks:]
+	copy(chunks, cfg.Bytes()[:MFSChunkSize])
+	copy(chunks[MFSChunkSize+mfsChunkCRCSize:], cfg.Bytes()[MFSChunkSize:])
+	copy(chunks[2*(MFSChunkSize+mfsChunkCRCSize):], "hello")
+
+	// System area: the volume header and allocation table.
+	fat := make([]uint16, nFiles+11*MFSDataChunks)
+	fat[7], fat[nFiles], fat[nFiles+1] = nFiles, nFiles+1, uint16(cfg.Len()-MFSChunkSize)
+	fat[2], fat[nFiles+2] = nFiles+2, 5
+	fat[3] = mfsFATEmpty
+	sys := new(bytes.Buffer)
+	binary.Write(sys, binary.LittleEndian, &MFSVolumeHeader{
+		Signature: MFSVolumeSignature,
+		Version:   1,
+		NumFiles:  nFiles,
+	})
+	binary.Write(sys, binary.LittleEndian, fat)
+	page := buf[hs:]
+	chunks = page[(MFSSystemChunks+1)*2:]
+	prev := uint16(0)
+	// Store the chunks in reverse order to exercise the index decoding.
+	n := (sys.Len() + MFSChunkSize - 1) / MFSChunkSize
+	for i := 0; i < n; i++ {
+		c := uint16(n - 1 - i)
+		binary.LittleEndian.PutUint16(page[2*i:], c^mfsIndexKey(prev))
+		prev = c
+		end := int(c+1) * MFSChunkSize
+		if end > sys.Len() {
+			end = sys.Len()
+		}
+		copy(chunks[i*(MFSChunkSize+mfsChunkCRCSize):][:MFSChunkSize], sys.Bytes()[int(c)*MFSChunkSize:end])
+	}
+	return buf
+}
+
+func TestNewMFS(t *testing.T) {
+	m, err := NewMFS(makeMFS())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if system, data, spare := m.Count(); system != 1 || data != 11 || spare != 1 {
+		t.Errorf("expected 1 system, 11 data and 1 spare page, got %d, %d and %d", system, data, spare)
+	}
+	if m.Pages[1].UsedChunks != 3 {
+		t.Errorf("expected 3 used chunks in the first data page, got %d", m.Pages[1].UsedChunks)
+	}
+	if m.Volume == nil {
+		t.Fatal("no volume header parsed")
+	}
+	if len(m.Files) != 3 {
+		t.Fatalf("expected 3 files, got %+v", m.Files)
+	}
+	if data, err := m.File(2); err != nil || string(data) != "hello" {
+		t.Errorf("expected file 2 to be %q, got %q (%v)", "hello", data, err)
+	}
+	if data, err := m.File(3); err != nil || len(data) != 0 {
+		t.Errorf("expected file 3 to be empty, got %q (%v)", data, err)
+	}
+	if _, err := m.File(4); err == nil {
+		t.Error("expected an error for unused file 4")
+	}
+
+	if len(m.Config) != 3 {
+		t.Fatalf("expected 3 configuration records, got %+v", m.Config)
+	}
+	r, err := m.ConfigRecord("/home/hap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.IsDir() || !bytes.Equal(r.Data, []byte{1}) {
+		t.Errorf("unexpected record %+v", r)
+	}
+	if r, err := m.ConfigRecord("/top"); err != nil || !bytes.Equal(r.Data, []byte{0xaa, 0xbb}) {
+		t.Errorf("unexpected record %+v (%v)", r, err)
+	}
+	if r, err := m.ConfigRecord("/home"); err != nil || !r.IsDir() {
+		t.Errorf("expected /home to be a directory, got %+v (%v)", r, err)
+	}
+}
+
+func TestNewMFSErrors(t *testing.T) {
+	if _, err := NewMFS(make([]byte, MFSPageSize+1)); err == nil {
+		t.Error("expected an error for a partial page")
+	}
+	if _, err := NewMFS(bytes.Repeat([]byte{0xff}, 2*MFSPageSize)); err == nil {
+		t.Error("expected an error without system pages")
+	}
+}
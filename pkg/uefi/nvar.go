@@ -0,0 +1,217 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// AMI Aptio images store their variables as NVAR entries, in the NVRAM file
+// instead of an EDK2 variable store, and the defaults of the Setup variables
+// in the StdDefaults file. An update of a variable is a new entry, linked
+// from the previous one through Next.
+
+// NVAR store files
+var (
+	NVarStoreFileGUID    = uuid.MustParse("CEF5B9A3-476D-497F-9FDC-E98143E0422C")
+	NVarDefaultsFileGUID = uuid.MustParse("9221315B-30BB-46B5-813E-1B1BF4712BD3")
+)
+
+// NVarSignature starts each NVAR entry.
+const NVarSignature = "NVAR"
+
+// NVarHeaderSize is the size of an NVAR entry header.
+const NVarHeaderSize = 10
+
+// NVAR attributes
+const (
+	NVarRuntime       = 0x01
+	NVarASCIIName     = 0x02
+	NVarGUID          = 0x04
+	NVarDataOnly      = 0x08
+	NVarExtHeader     = 0x10
+	NVarHWErrorRecord = 0x20
+	NVarAuthWrite     = 0x40
+	NVarValid         = 0x80
+)
+
+// nvarExtChecksum is set in the extended attributes of entries with a
+// checksum.
+const nvarExtChecksum = 0x01
+
+// NVarHeader represents an NVAR_ENTRY_HEADER.
+type NVarHeader struct {
+	Signature  [4]uint8 `json:"-"`
+	Size       uint16
+	Next       [3]uint8 `json:"-"`
+	Attributes uint8
+}
+
+// NVar is an NVAR entry.
+type NVar struct {
+	Header NVarHeader
+	// Offset from the start of the store.
+	Offset uint64
+	// Next is the offset of the update of the entry, if any.
+	Next uint64 `json:",omitempty"`
+	// GUID and Name are inherited from the previous entry for data only
+	// entries.
+	GUID uuid.UUID
+	Name string
+
+	buf        []byte
+	dataOffset int
+	dataEnd    int
+	// checksum is the offset of the checksum in buf, 0 if there is none.
+	checksum int
+}
+
+// Valid returns whether the entry is valid.
+func (v *NVar) Valid() bool {
+	return v.Header.Attributes&NVarValid != 0
+}
+
+// Buf returns the raw entry, including the header.
+func (v *NVar) Buf() []byte {
+	return v.buf
+}
+
+// Data returns the data of the entry.
+func (v *NVar) Data() []byte {
+	return v.buf[v.dataOffset:v.dataEnd]
+}
+
+// SetData writes b at offset in the data of the entry and updates its
+// checksum.
+func (v *NVar) SetData(offset int, b []byte) error {
+	data := v.Data()
+	if offset < 0 || offset+len(b) > len(data) {
+		return fmt.Errorf("%#x bytes at %#x do not fit into the %#x bytes of NVAR %v", len(b), offset, len(data), v.Name)
+	}
+	var delta uint8
+	for i, c := range b {
+		delta += c - data[offset+i]
+		data[offset+i] = c
+	}
+	if v.checksum != 0 {
+		v.buf[v.checksum] -= delta
+	}
+	return nil
+}
+
+// NVarStore is a sequence of NVAR entries, followed by free space and the
+// GUIDs referenced by index, from the end of the store.
+type NVarStore struct {
+	Entries []*NVar `json:",omitempty"`
+
+	buf []byte
+}
+
+// Buf returns the buffer of the store.
+func (s *NVarStore) Buf() []byte {
+	return s.buf
+}
+
+// IsNVarStore returns whether buf starts with an NVAR entry.
+func IsNVarStore(buf []byte) bool {
+	return len(buf) >= NVarHeaderSize && string(buf[:4]) == NVarSignature
+}
+
+// NewNVarStore parses the NVAR entries of buf.
+func NewNVarStore(buf []byte) (*NVarStore, error) {
+	if !IsNVarStore(buf) {
+		return nil, fmt.Errorf("no NVAR signature")
+	}
+	s := &NVarStore{buf: buf}
+	// prev maps the offsets of updates to the entries they update.
+	prev := make(map[uint64]*NVar)
+	for offset := uint64(0); IsNVarStore(buf[offset:]); {
+		v, err := s.newNVar(offset)
+		if err != nil {
+			return nil, err
+		}
+		if p, ok := prev[offset]; ok && v.Header.Attributes&NVarDataOnly != 0 {
+			v.GUID, v.Name = p.GUID, p.Name
+		}
+		if v.Next != 0 {
+			prev[v.Next] = v
+		}
+		s.Entries = append(s.Entries, v)
+		offset += uint64(v.Header.Size)
+	}
+	return s, nil
+}
+
+// newNVar parses the entry at offset.
+func (s *NVarStore) newNVar(offset uint64) (*NVar, error) {
+	v := &NVar{Offset: offset}
+	if err := binary.Read(bytes.NewReader(s.buf[offset:]), binary.LittleEndian, &v.Header); err != nil {
+		return nil, err
+	}
+	size := uint64(v.Header.Size)
+	if size < NVarHeaderSize || offset+size > uint64(len(s.buf)) {
+		return nil, fmt.Errorf("NVAR at %#x of %#x bytes out of bounds, store is %#x bytes", offset, size, len(s.buf))
+	}
+	v.buf = s.buf[offset : offset+size]
+	if next := Read3Size(v.Header.Next); next != 0 && next != 0xffffff {
+		v.Next = offset + next
+	}
+	v.dataOffset, v.dataEnd = NVarHeaderSize, len(v.buf)
+
+	attr := v.Header.Attributes
+	if attr&NVarExtHeader != 0 {
+		if len(v.buf) < NVarHeaderSize+2 {
+			return nil, fmt.Errorf("NVAR at %#x is too short for its extended header", offset)
+		}
+		ext := int(binary.LittleEndian.Uint16(v.buf[len(v.buf)-2:]))
+		if ext < 2 || ext > len(v.buf)-NVarHeaderSize {
+			return nil, fmt.Errorf("NVAR at %#x has an extended header of %#x bytes", offset, ext)
+		}
+		v.dataEnd = len(v.buf) - ext
+		if v.buf[v.dataEnd]&nvarExtChecksum != 0 && ext >= 4 {
+			v.checksum = len(v.buf) - 3
+		}
+	}
+	if attr&NVarDataOnly != 0 {
+		return v, nil
+	}
+
+	b := v.buf[:v.dataEnd]
+	if attr&NVarGUID != 0 {
+		if len(b) < v.dataOffset+16 {
+			return nil, fmt.Errorf("NVAR at %#x is too short for its GUID", offset)
+		}
+		copy(v.GUID[:], b[v.dataOffset:])
+		v.dataOffset += 16
+	} else {
+		if len(b) < v.dataOffset+1 {
+			return nil, fmt.Errorf("NVAR at %#x is too short for its GUID index", offset)
+		}
+		// The GUIDs are stored from the end of the store.
+		end := uint64(len(s.buf)) - 16*(uint64(b[v.dataOffset])+1)
+		if end > uint64(len(s.buf)) {
+			return nil, fmt.Errorf("NVAR at %#x has GUID index %d past the store", offset, b[v.dataOffset])
+		}
+		copy(v.GUID[:], s.buf[end:])
+		v.dataOffset++
+	}
+
+	var n int
+	var err error
+	if attr&NVarASCIIName != 0 {
+		v.Name, n, err = readASCII(b[v.dataOffset:])
+	} else {
+		v.Name, n, err = readUCS2(b[v.dataOffset:])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("NVAR at %#x name: %v", offset, err)
+	}
+	v.dataOffset += n
+	return v, nil
+}
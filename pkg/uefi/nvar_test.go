@@ -0,0 +1,113 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var nvarTestGUID = uuid.MustParse("EC87D643-EBA4-4BB5-A1E5-3F3E36B20DA9")
+
+// nvarEntry encodes an NVAR entry, next is relative to the entry.
+func nvarEntry(attr uint8, next uint32, body ...[]byte) []byte {
+	b := bytes.Join(body, nil)
+	h := make([]byte, NVarHeaderSize)
+	copy(h, NVarSignature)
+	binary.LittleEndian.PutUint16(h[4:], uint16(NVarHeaderSize+len(b)))
+	next3 := Write3Size(uint64(next))
+	copy(h[6:], next3[:])
+	h[9] = attr
+	return append(h, b...)
+}
+
+// nvarStore builds a store with a Setup variable with an inline GUID, which
+// is updated by a data only entry with a checksum, and a variable with a
+// UCS-2 name and a GUID index.
+func nvarStore() []byte {
+	setup := nvarEntry(NVarValid|NVarASCIIName|NVarGUID, 0x24, nvarTestGUID[:], []byte("Setup\x00"), []byte{1, 2, 3, 4})
+	// The update is followed by its extended header: the attributes, the
+	// checksum and the size of the extended header.
+	update := nvarEntry(NVarValid|NVarDataOnly|NVarExtHeader, 0xffffff, []byte{5, 6, 7, 8}, []byte{nvarExtChecksum, 0, 4, 0})
+	var sum uint8
+	for _, c := range update[NVarHeaderSize : len(update)-3] {
+		sum += c
+	}
+	update[len(update)-3] = -sum
+	lang := nvarEntry(NVarValid, 0xffffff, []byte{0}, utf16z("Lang"), []byte("en"))
+
+	buf := bytes.Join([][]byte{setup, update, lang}, nil)
+	buf = append(buf, bytes.Repeat([]byte{0xff}, 32)...)
+	return append(buf, nvarTestGUID[:]...)
+}
+
+func TestNewNVarStore(t *testing.T) {
+	s, err := NewNVarStore(nvarStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		name string
+		next uint64
+		data string
+	}{
+		{"Setup", 0x24, "\x01\x02\x03\x04"},
+		{"Setup", 0, "\x05\x06\x07\x08"},
+		{"Lang", 0, "en"},
+	}
+	if len(s.Entries) != len(want) {
+		t.Fatalf("expected %d entries, got %d", len(want), len(s.Entries))
+	}
+	for i, w := range want {
+		v := s.Entries[i]
+		if v.Name != w.name || v.GUID != *nvarTestGUID || v.Next != w.next || string(v.Data()) != w.data || !v.Valid() {
+			t.Errorf("entry %d: expected %v %v at %#x with %q, got %v %v at %#x with %q",
+				i, w.name, nvarTestGUID, w.next, w.data, v.Name, v.GUID, v.Next, v.Data())
+		}
+	}
+}
+
+func TestNVarSetData(t *testing.T) {
+	s, err := NewNVarStore(nvarStore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := s.Entries[1]
+	if err := update.SetData(1, []byte{0x60, 0x70}); err != nil {
+		t.Fatal(err)
+	}
+	if err := update.SetData(3, []byte{0, 0}); err == nil {
+		t.Error("expected an error")
+	}
+	if got := update.Data(); !bytes.Equal(got, []byte{5, 0x60, 0x70, 8}) {
+		t.Errorf("unexpected data %v", got)
+	}
+	// The data and the checksum add up to zero.
+	var sum uint8
+	for _, c := range update.Buf()[NVarHeaderSize : len(update.Buf())-3] {
+		sum += c
+	}
+	if sum += update.Buf()[len(update.Buf())-3]; sum != 0 {
+		t.Errorf("checksum is off by %#x", sum)
+	}
+}
+
+func TestNewNVarStoreErrors(t *testing.T) {
+	buf := nvarStore()
+	long := append([]byte{}, buf...)
+	binary.LittleEndian.PutUint16(long[4:], uint16(len(buf)+1))
+	index := append([]byte{}, buf...)
+	// The GUID index of the last entry.
+	index[0x24+NVarHeaderSize+8+NVarHeaderSize] = 0xf0
+
+	for name, b := range map[string][]byte{"signature": buf[1:], "size": long, "index": index} {
+		if _, err := NewNVarStore(b); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+}
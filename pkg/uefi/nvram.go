@@ -0,0 +1,372 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+
+	"github.com/linuxboot/fiano/pkg/unicode"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// Variable store constants, see EDK2 MdeModulePkg/Include/Guid/VariableFormat.h
+const (
+	// VariableStoreHeaderSize is the size of the VARIABLE_STORE_HEADER.
+	VariableStoreHeaderSize = 28
+	// VariableHeaderSize is the size of the VARIABLE_HEADER.
+	VariableHeaderSize = 32
+	// AuthVariableHeaderSize is the size of the AUTHENTICATED_VARIABLE_HEADER.
+	AuthVariableHeaderSize = 60
+	// VariableStartID marks the start of a variable.
+	VariableStartID = 0x55AA
+	// VariableStoreFormatted is the Format value of a formatted store.
+	VariableStoreFormatted = 0x5A
+	// VariableStoreHealthy is the State value of a healthy store.
+	VariableStoreHealthy = 0xFE
+)
+
+// Variable store signatures
+var (
+	VariableStoreGUID     = uuid.MustParse("DDCF3616-3275-4164-98B6-FE85707FFE7D")
+	AuthVariableStoreGUID = uuid.MustParse("AAF32C78-947B-439A-A180-2E144EC37792")
+)
+
+// VariableState is the state of a variable. The state bits are cleared one at
+// a time as the variable goes through its lifecycle, so these values assume an
+// erase polarity of 0xFF.
+type VariableState uint8
+
+// Variable states
+const (
+	VarInDeletedTransition VariableState = 0xFE // Variable is in obsolete transition.
+	VarDeleted             VariableState = 0xFD // Variable is obsolete.
+	VarHeaderValidOnly     VariableState = 0x7F // Variable header has been valid.
+	VarAdded               VariableState = 0x3F // Variable has been completely added.
+)
+
+// Valid returns whether the variable holds live data. Variables in deleted
+// transition are still valid until the new copy is added.
+func (s VariableState) Valid() bool {
+	return s == VarAdded || s == VarAdded&VarInDeletedTransition
+}
+
+// String creates a string representation for the variable state.
+func (s VariableState) String() string {
+	switch s {
+	case VarAdded:
+		return "VAR_ADDED"
+	case VarAdded & VarInDeletedTransition:
+		return "VAR_IN_DELETED_TRANSITION"
+	case VarHeaderValidOnly:
+		return "VAR_HEADER_VALID_ONLY"
+	}
+	if s&^VarDeleted == 0 {
+		// The deleted bit is cleared.
+		return "VAR_DELETED"
+	}
+	return fmt.Sprintf("UNKNOWN (%#x)", uint8(s))
+}
+
+// MarshalText marshals the state as its name.
+func (s VariableState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText parses a state name as written by MarshalText. Deleted
+// variables map to a single state, the exact bits are restored when the
+// variable store is parsed from its buffer.
+func (s *VariableState) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "VAR_ADDED":
+		*s = VarAdded
+	case "VAR_IN_DELETED_TRANSITION":
+		*s = VarAdded & VarInDeletedTransition
+	case "VAR_HEADER_VALID_ONLY":
+		*s = VarHeaderValidOnly
+	case "VAR_DELETED":
+		*s = VarAdded & VarDeleted
+	default:
+		var v uint8
+		if _, err := fmt.Sscanf(string(text), "UNKNOWN (%v)", &v); err != nil {
+			return fmt.Errorf("unknown variable state %q", text)
+		}
+		*s = VariableState(v)
+	}
+	return nil
+}
+
+// VariableStoreHeader represents a VARIABLE_STORE_HEADER.
+type VariableStoreHeader struct {
+	Signature uuid.UUID
+	Size      uint32
+	Format    uint8
+	State     uint8
+	Reserved  uint16 `json:"-"`
+	Reserved1 uint32 `json:"-"`
+}
+
+// VariableHeader represents a VARIABLE_HEADER.
+type VariableHeader struct {
+	StartID    uint16 `json:"-"`
+	State      VariableState
+	Reserved   uint8 `json:"-"`
+	Attributes uint32
+	NameSize   uint32
+	DataSize   uint32
+	VendorGUID uuid.UUID
+}
+
+// EFITime represents an EFI_TIME.
+type EFITime struct {
+	Year       uint16
+	Month      uint8
+	Day        uint8
+	Hour       uint8
+	Minute     uint8
+	Second     uint8
+	Pad1       uint8 `json:"-"`
+	Nanosecond uint32
+	TimeZone   int16
+	Daylight   uint8
+	Pad2       uint8 `json:"-"`
+}
+
+// AuthInfo holds the fields only found in authenticated variable headers.
+type AuthInfo struct {
+	MonotonicCount uint64
+	TimeStamp      EFITime
+	PubKeyIndex    uint32
+}
+
+// AuthVariableHeader represents an AUTHENTICATED_VARIABLE_HEADER.
+type AuthVariableHeader struct {
+	StartID    uint16
+	State      VariableState
+	Reserved   uint8
+	Attributes uint32
+	AuthInfo
+	NameSize   uint32
+	DataSize   uint32
+	VendorGUID uuid.UUID
+}
+
+// Variable is a single variable in a variable store.
+type Variable struct {
+	Header VariableHeader
+	// AuthInfo is only set for variables in authenticated stores.
+	AuthInfo *AuthInfo `json:",omitempty"`
+	Name     string
+	// Signatures are the parsed signature lists of secure boot variables.
+	Signatures []*SignatureList `json:",omitempty"`
+	// Offset from the start of the variable store.
+	Offset uint64
+
+	buf []byte
+}
+
+// Buf returns the raw variable, including the header.
+func (v *Variable) Buf() []byte {
+	return v.buf
+}
+
+// headerLen returns the length of the variable header.
+func (v *Variable) headerLen() uint64 {
+	if v.AuthInfo != nil {
+		return AuthVariableHeaderSize
+	}
+	return VariableHeaderSize
+}
+
+// Data returns the variable data.
+func (v *Variable) Data() []byte {
+	start := v.headerLen() + uint64(v.Header.NameSize)
+	return v.buf[start : start+uint64(v.Header.DataSize)]
+}
+
+// VariableStore represents a variable store found in an NVRAM FV.
+type VariableStore struct {
+	Header VariableStoreHeader
+	// Authenticated is set for stores using authenticated variable headers.
+	Authenticated bool        `json:",omitempty"`
+	Variables     []*Variable `json:",omitempty"`
+
+	// Offset of the free space from the start of the store.
+	FreeSpaceOffset uint64
+	Location
+
+	buf []byte
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (vs *VariableStore) Buf() []byte {
+	return vs.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (vs *VariableStore) SetBuf(buf []byte) {
+	vs.buf = buf
+}
+
+// Apply calls the visitor on the VariableStore.
+func (vs *VariableStore) Apply(v Visitor) error {
+	return v.Visit(vs)
+}
+
+// ApplyChildren calls the visitor on each child node of VariableStore.
+func (vs *VariableStore) ApplyChildren(v Visitor) error {
+	return nil
+}
+
+// Validate the variable store.
+func (vs *VariableStore) Validate() []error {
+	errs := make([]error, 0)
+	if vs.Header.Format != VariableStoreFormatted {
+		errs = append(errs, fmt.Errorf("variable store not formatted, format is %#x", vs.Header.Format))
+	}
+	if vs.Header.State != VariableStoreHealthy {
+		errs = append(errs, fmt.Errorf("variable store not healthy, state is %#x", vs.Header.State))
+	}
+	if uint64(vs.Header.Size) != uint64(len(vs.buf)) {
+		errs = append(errs, fmt.Errorf("variable store size mismatch! Size is %#x, buf length is %#x",
+			vs.Header.Size, len(vs.buf)))
+	}
+	return errs
+}
+
+// IsVariableStore checks if the buffer starts with a variable store header
+// we know how to parse.
+func IsVariableStore(buf []byte) bool {
+	if len(buf) < VariableStoreHeaderSize {
+		return false
+	}
+	var g uuid.UUID
+	copy(g[:], buf)
+	return g == *VariableStoreGUID || g == *AuthVariableStoreGUID
+}
+
+// NewVariableStore parses a sequence of bytes and returns a VariableStore
+// object, if a valid one is passed, or an error.
+func NewVariableStore(buf []byte) (*VariableStore, error) {
+	if !IsVariableStore(buf) {
+		return nil, fmt.Errorf("not a variable store, first %d bytes are %x", VariableStoreHeaderSize,
+			buf[:VariableStoreHeaderSize])
+	}
+	vs := VariableStore{}
+	r := bytes.NewReader(buf)
+	if err := binary.Read(r, binary.LittleEndian, &vs.Header); err != nil {
+		return nil, err
+	}
+	if size := uint64(vs.Header.Size); size > uint64(len(buf)) || size < VariableStoreHeaderSize {
+		return nil, fmt.Errorf("variable store size %#x invalid, buffer is %#x bytes long", size, len(buf))
+	}
+	vs.buf = buf[:vs.Header.Size]
+	vs.Authenticated = vs.Header.Signature == *AuthVariableStoreGUID
+
+	offset := Align4(VariableStoreHeaderSize)
+	for offset < uint64(len(vs.buf)) {
+		v, err := newVariable(vs.buf[offset:], offset, vs.Authenticated)
+		if err != nil {
+			return nil, err
+		}
+		if v == nil {
+			// Reached free space.
+			break
+		}
+		vs.Variables = append(vs.Variables, v)
+		offset = Align4(offset + uint64(len(v.buf)))
+	}
+	vs.FreeSpaceOffset = offset
+	return &vs, nil
+}
+
+// newVariable parses a variable. It returns nil, nil if the buffer does not
+// start with a variable, which means the free space has been reached.
+func newVariable(buf []byte, offset uint64, authenticated bool) (*Variable, error) {
+	v := Variable{Offset: offset}
+	if authenticated {
+		v.AuthInfo = &AuthInfo{}
+	}
+	if uint64(len(buf)) < v.headerLen() || binary.LittleEndian.Uint16(buf) != VariableStartID {
+		return nil, nil
+	}
+	r := bytes.NewReader(buf)
+	if authenticated {
+		var h AuthVariableHeader
+		if err := binary.Read(r, binary.LittleEndian, &h); err != nil {
+			return nil, err
+		}
+		v.Header = VariableHeader{
+			StartID:    h.StartID,
+			State:      h.State,
+			Reserved:   h.Reserved,
+			Attributes: h.Attributes,
+			NameSize:   h.NameSize,
+			DataSize:   h.DataSize,
+			VendorGUID: h.VendorGUID,
+		}
+		*v.AuthInfo = h.AuthInfo
+	} else if err := binary.Read(r, binary.LittleEndian, &v.Header); err != nil {
+		return nil, err
+	}
+	end := v.headerLen() + uint64(v.Header.NameSize) + uint64(v.Header.DataSize)
+	if end > uint64(len(buf)) {
+		return nil, fmt.Errorf("variable at offset %#x is %#x bytes long, but only %#x bytes are left in the store",
+			offset, end, len(buf))
+	}
+	v.buf = buf[:end]
+	if v.Header.NameSize >= 2 {
+		v.Name = unicode.UCS2ToUTF8(v.buf[v.headerLen() : v.headerLen()+uint64(v.Header.NameSize)])
+	}
+	if v.Header.State.Valid() && IsSignatureDatabase(v.Header.VendorGUID, v.Name) {
+		sigs, err := ParseSignatureLists(v.Data())
+		if err != nil {
+			log.Printf("unable to parse signatures of variable %v: %v", v.Name, err)
+		} else {
+			v.Signatures = sigs
+		}
+	}
+	return &v, nil
+}
+
+// Rebuild serializes the header and the variables back into the store
+// buffer. The variables are packed at the start of the store and everything
+// after the last one becomes free space.
+func (vs *VariableStore) Rebuild() error {
+	size := uint64(vs.Header.Size)
+	buf := make([]byte, size)
+	Erase(buf, Attributes.ErasePolarity)
+
+	h := new(bytes.Buffer)
+	if err := binary.Write(h, binary.LittleEndian, &vs.Header); err != nil {
+		return err
+	}
+	copy(buf, h.Bytes())
+
+	offset := Align4(VariableStoreHeaderSize)
+	for _, v := range vs.Variables {
+		end := offset + uint64(len(v.buf))
+		if end > size {
+			return fmt.Errorf("variables do not fit into the %#x bytes store", size)
+		}
+		copy(buf[offset:end], v.buf)
+		v.buf = buf[offset:end]
+		v.Offset = offset
+		offset = Align4(end)
+	}
+	vs.FreeSpaceOffset = offset
+	vs.buf = buf
+	return nil
+}
+
+// SetState sets the state of the variable, both in the header and the buffer.
+func (v *Variable) SetState(s VariableState) {
+	v.Header.State = s
+	v.buf[2] = uint8(s)
+}
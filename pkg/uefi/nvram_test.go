@@ -0,0 +1,145 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/linuxboot/fiano/pkg/unicode"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+func makeTestCert(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fiano test db"},
+		NotBefore:    time.Unix(0, 0),
+		NotAfter:     time.Unix(0, 0).Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestAuthVariableStore(t *testing.T) {
+	owner := uuid.MustParse("77FA9ABD-0359-4D32-BD60-28F4E78F784B")
+	cert := makeTestCert(t)
+	hash := bytes.Repeat([]byte{0xab}, 32)
+
+	// db holds one certificate and one hash.
+	data := new(bytes.Buffer)
+	for _, e := range []struct {
+		sigType *uuid.UUID
+		sig     []byte
+	}{{CertX509GUID, cert}, {CertSHA256GUID, hash}} {
+		size := uint32(16 + len(e.sig))
+		binary.Write(data, binary.LittleEndian, SignatureListHeader{
+			SignatureType:     *e.sigType,
+			SignatureListSize: SignatureListHeaderSize + size,
+			SignatureSize:     size,
+		})
+		data.Write(owner[:])
+		data.Write(e.sig)
+	}
+
+	name := unicode.UTF8ToUCS2("db")
+	h := AuthVariableHeader{
+		StartID:    VariableStartID,
+		State:      VarAdded,
+		Attributes: 0x27,
+		AuthInfo: AuthInfo{
+			MonotonicCount: 1,
+			TimeStamp:      EFITime{Year: 2018, Month: 6, Day: 1},
+		},
+		NameSize:   uint32(len(name)),
+		DataSize:   uint32(data.Len()),
+		VendorGUID: *ImageSecurityDatabaseGUID,
+	}
+	buf := new(bytes.Buffer)
+	binary.Write(buf, binary.LittleEndian, VariableStoreHeader{
+		Signature: *AuthVariableStoreGUID,
+		Size:      0x1000,
+		Format:    VariableStoreFormatted,
+		State:     VariableStoreHealthy,
+	})
+	binary.Write(buf, binary.LittleEndian, h)
+	buf.Write(name)
+	buf.Write(data.Bytes())
+	for buf.Len() < 0x1000 {
+		buf.WriteByte(0xff)
+	}
+
+	vs, err := NewVariableStore(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !vs.Authenticated {
+		t.Errorf("store not detected as authenticated")
+	}
+	if errs := vs.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected validation errors: %v", errs)
+	}
+	if len(vs.Variables) != 1 {
+		t.Fatalf("expected 1 variable, got %d", len(vs.Variables))
+	}
+	v := vs.Variables[0]
+	if v.Name != "db" || v.AuthInfo == nil || *v.AuthInfo != h.AuthInfo {
+		t.Errorf("variable mismatch, got name %q and auth info %+v", v.Name, v.AuthInfo)
+	}
+	if !bytes.Equal(v.Data(), data.Bytes()) {
+		t.Errorf("variable data mismatch")
+	}
+	if len(v.Signatures) != 2 {
+		t.Fatalf("expected 2 signature lists, got %d", len(v.Signatures))
+	}
+	if s := v.Signatures[0]; s.Type != "EFI_CERT_X509" || s.Signatures[0].Subject != "CN=fiano test db" {
+		t.Errorf("certificate not parsed, got %+v", s.Signatures[0])
+	}
+	if s := v.Signatures[1]; s.Type != "EFI_CERT_SHA256" || s.Signatures[0].Owner != *owner ||
+		s.Signatures[0].Hash != "abababababababababababababababababababababababababababababababab" {
+		t.Errorf("hash not parsed, got %+v", s.Signatures[0])
+	}
+
+	// Rebuilding the store must not change it.
+	nb := append([]byte{}, vs.Buf()...)
+	Attributes.ErasePolarity = 0xFF
+	if err := vs.Rebuild(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(vs.Buf(), nb) {
+		t.Errorf("authenticated store did not round trip")
+	}
+}
+
+func TestVariableStateText(t *testing.T) {
+	for _, s := range []VariableState{VarAdded, VarAdded & VarInDeletedTransition, VarHeaderValidOnly, VarAdded & VarDeleted, 0x12} {
+		text, err := s.MarshalText()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got VariableState
+		if err := got.UnmarshalText(text); err != nil {
+			t.Fatal(err)
+		}
+		if got != s {
+			t.Errorf("state %#x round-tripped as %#x through %q", uint8(s), uint8(got), text)
+		}
+	}
+}
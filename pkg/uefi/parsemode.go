@@ -0,0 +1,92 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// ParseMode controls how the parsers handle anomalies in the image, such as
+// bad checksums, truncated sections and invalid file states.
+type ParseMode int
+
+// Parse modes.
+const (
+	// ParseStrict fails on the first anomaly, e.g. for build pipelines. This
+	// is the default.
+	ParseStrict ParseMode = iota
+	// ParseWarn logs anomalies and goes on. Sections which cannot be parsed
+	// are kept as opaque blobs.
+	ParseWarn
+	// ParsePermissive is like ParseWarn without logging, e.g. for forensic
+	// work on damaged images.
+	ParsePermissive
+	// ParseRecover is like ParseWarn, but FVs and files which cannot be
+	// parsed are kept as opaque blobs too, instead of failing the whole
+	// image. Such nodes have their ParseError set.
+	ParseRecover
+)
+
+var parseModeNames = map[ParseMode]string{
+	ParseWarn:       "warn",
+	ParseStrict:     "strict",
+	ParsePermissive: "permissive",
+	ParseRecover:    "recover",
+}
+
+// String returns the name of the parse mode.
+func (m ParseMode) String() string {
+	if s, ok := parseModeNames[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("ParseMode(%d)", int(m))
+}
+
+// ParseParseMode parses a parse mode by its name.
+func ParseParseMode(s string) (ParseMode, error) {
+	for m, name := range parseModeNames {
+		if strings.EqualFold(s, name) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown parse mode %q, expected strict, warn, permissive or recover", s)
+}
+
+var parseMode = ParseStrict
+
+// SetParseMode sets the parse mode of all subsequent parsing.
+func SetParseMode(m ParseMode) {
+	parseMode = m
+}
+
+// CurrentParseMode returns the parse mode set by SetParseMode.
+func CurrentParseMode() ParseMode {
+	return parseMode
+}
+
+// anomaly reports an anomaly according to the parse mode. It only returns an
+// error in strict mode, otherwise the caller works around the anomaly.
+func anomaly(format string, a ...interface{}) error {
+	switch parseMode {
+	case ParseStrict:
+		return fmt.Errorf(format, a...)
+	case ParseWarn, ParseRecover:
+		log.Printf("warning: "+format, a...)
+	}
+	return nil
+}
+
+// recoverNode decides whether a node which failed to parse with err is kept
+// as an opaque blob, which is only done in recover mode. Otherwise it returns
+// err.
+func recoverNode(err error) error {
+	if parseMode != ParseRecover {
+		return err
+	}
+	log.Printf("warning: %v, keeping it as it is", err)
+	return nil
+}
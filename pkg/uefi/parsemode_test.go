@@ -0,0 +1,130 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestParseParseMode(t *testing.T) {
+	for _, m := range []ParseMode{ParseWarn, ParseStrict, ParsePermissive, ParseRecover} {
+		got, err := ParseParseMode(m.String())
+		if err != nil || got != m {
+			t.Errorf("expected %v, got %v, %v", m, got, err)
+		}
+	}
+	if _, err := ParseParseMode("lenient"); err == nil {
+		t.Error("expected an error for an unknown parse mode")
+	}
+}
+
+func TestDefaultParseMode(t *testing.T) {
+	if m := CurrentParseMode(); m != ParseStrict {
+		t.Errorf("expected the library to default to strict, got %v", m)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	defer SetParseMode(ParseStrict)
+
+	fv, err := NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileOffset := Align8(fv.DataOffset)
+
+	badChecksum := append([]byte{}, sampleFV...)
+	badChecksum[fileOffset+0x10]++
+	// The first section of the first file claims to be larger than the file.
+	truncated := append([]byte{}, sampleFV...)
+	copy(truncated[fileOffset+FileHeaderMinLength:], []byte{0xFE, 0xFF, 0xFF})
+
+	var tests = []struct {
+		name     string
+		mode     ParseMode
+		buf      []byte
+		fail     bool
+		sections bool
+	}{
+		{"strict checksum", ParseStrict, badChecksum, true, true},
+		{"warn checksum", ParseWarn, badChecksum, false, true},
+		{"permissive checksum", ParsePermissive, badChecksum, false, true},
+		{"strict truncated", ParseStrict, truncated, true, false},
+		{"warn truncated", ParseWarn, truncated, false, false},
+		{"permissive truncated", ParsePermissive, truncated, false, false},
+		{"strict good", ParseStrict, sampleFV, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetParseMode(test.mode)
+			fv, err := NewFirmwareVolume(test.buf, 0, false)
+			if test.fail {
+				if err == nil {
+					t.Error("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(fv.Files) != 3 {
+				t.Fatalf("expected 3 files, got %d", len(fv.Files))
+			}
+			// Unparsable files are kept without sections.
+			if hasSections := len(fv.Files[0].Sections) != 0; hasSections != test.sections {
+				t.Errorf("expected sections %v, got %v", test.sections, hasSections)
+			}
+		})
+	}
+}
+
+func TestParseRecover(t *testing.T) {
+	defer SetParseMode(ParseStrict)
+
+	fv, err := NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fileOffset := Align8(fv.DataOffset)
+
+	// The first file of the first FV is larger than the FV.
+	badFile := append([]byte{}, sampleFV...)
+	copy(badFile[fileOffset+0x14:], []byte{0xFE, 0xFF, 0xFF})
+	// The second FV is larger than the region.
+	badFV := append([]byte{}, sampleFV...)
+	binary.LittleEndian.PutUint64(badFV[32:], 1<<32)
+	region := append(append(append([]byte{}, badFile...), badFV...), sampleFV...)
+
+	SetParseMode(ParseWarn)
+	if _, err := NewBIOSRegion(region, nil); err == nil {
+		t.Fatal("expected an error without recovery")
+	}
+
+	SetParseMode(ParseRecover)
+	br, err := NewBIOSRegion(region, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(br.Elements) != 3 {
+		t.Fatalf("expected 3 elements, got %d", len(br.Elements))
+	}
+	opaqueFV, ok := br.Elements[0].Value.(*FirmwareVolume)
+	if !ok || len(opaqueFV.Files) != 0 || opaqueFV.ParseError == "" || !bytes.Equal(opaqueFV.Buf(), badFile) {
+		t.Errorf("expected the first FV without files and with a parse error, got %v", br.Elements[0])
+	}
+	if errs := opaqueFV.Validate(); len(errs) == 0 {
+		t.Error("expected the parse error when validating the first FV")
+	}
+	padding, ok := br.Elements[1].Value.(*BIOSPadding)
+	if !ok || padding.ParseError == "" || !bytes.Equal(padding.Buf(), badFV) {
+		t.Errorf("expected the second FV as padding with a parse error, got %v", br.Elements[1])
+	}
+	goodFV, ok := br.Elements[2].Value.(*FirmwareVolume)
+	if !ok || len(goodFV.Files) != 3 || goodFV.FVOffset != uint64(2*len(sampleFV)) {
+		t.Errorf("expected the third FV to be parsed, got %v", br.Elements[2])
+	}
+}
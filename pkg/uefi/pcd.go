@@ -0,0 +1,412 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/unicode"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// The PCD databases of the dynamic PCDs are stored in a raw section of the
+// PcdPeim and PcdDxe drivers. They start with PCD_DATABASE_INIT, followed by
+// the default values of the initialized PCDs and the tables. Uninitialized
+// PCDs, which default to 0, are not stored.
+
+// PCDDatabaseGUID is the signature of a PCD database.
+var PCDDatabaseGUID = uuid.MustParse("3C7D193C-682C-4C14-A68F-552DEA4F437E")
+
+// PCDDatabaseVersion is the only supported PCD_SERVICE_DRIVER_VERSION.
+const PCDDatabaseVersion = 7
+
+// Bits of the local token numbers, the low bits are the offset of the PCD in
+// the database.
+const (
+	pcdTypeShift       = 28
+	pcdDatumTypeShift  = 24
+	pcdBooleanBit      = 1 << 20
+	pcdDatabaseOffMask = pcdBooleanBit - 1
+)
+
+// PCD types of the local token numbers.
+var pcdTypes = map[uint32]string{
+	0x0: "DATA",
+	0x1: "STRING",
+	0x4: "VPD",
+	0x8: "HII",
+}
+
+// PCDDatabaseHeader is the PCD_DATABASE_INIT of version 7.
+type PCDDatabaseHeader struct {
+	Signature                   uuid.UUID
+	BuildVersion                uint32
+	Length                      uint32
+	SystemSkuID                 uint64
+	LengthForAllSkus            uint32
+	UninitDataBaseSize          uint32
+	LocalTokenNumberTableOffset uint32
+	ExMapTableOffset            uint32
+	GUIDTableOffset             uint32
+	StringTableOffset           uint32
+	SizeTableOffset             uint32
+	SkuIDTableOffset            uint32
+	PcdNameTableOffset          uint32
+	LocalTokenCount             uint16
+	ExTokenCount                uint16
+	GUIDTableCount              uint16
+	_                           [6]uint8
+}
+
+// pcdExMapping is a DYNAMICEX_MAPPING.
+type pcdExMapping struct {
+	ExTokenNumber uint32
+	TokenNumber   uint16
+	ExGUIDIndex   uint16
+}
+
+// pcdVariableHead is the VARIABLE_HEAD of HII PCDs.
+type pcdVariableHead struct {
+	GUIDTableIndex     uint16
+	StringIndex        uint16
+	DefaultValueOffset uint16
+	Offset             uint16
+	Attributes         uint32
+	Property           uint16
+	_                  uint16
+}
+
+// PCDToken is a PCD of a database.
+type PCDToken struct {
+	// Index is the 1-based index of the PCD in the local token number
+	// table. TokenNumber is the token number used by the PCD services,
+	// the tokens of the DXE database follow those of the PEI database.
+	Index       int
+	TokenNumber int
+	// Name is TokenSpaceGuidCName.PcdCName, if the database has names.
+	Name string `json:",omitempty"`
+	// Type is DATA, STRING, HII or VPD.
+	Type string
+	// DatumType is UINT8, BOOLEAN, UINT16, UINT32, UINT64 or POINTER.
+	DatumType string
+	// Offset is the offset of the value, or the head of the PCD type, in
+	// the database.
+	Offset uint32
+	// Size is the size of the value, the maximum size for POINTER PCDs.
+	Size int
+	// Value is the default value, a number or a byte array as in DSC
+	// files. Uninitialized PCDs are 0.
+	Value         string `json:",omitempty"`
+	Uninitialized bool   `json:",omitempty"`
+	// DynamicEx PCDs are also accessed by their token space GUID and
+	// ExTokenNumber.
+	ExTokenNumber *uint32    `json:",omitempty"`
+	ExGUID        *uuid.UUID `json:",omitempty"`
+	// HII PCDs are stored in the Offset of a variable.
+	VariableName   string     `json:",omitempty"`
+	VariableGUID   *uuid.UUID `json:",omitempty"`
+	VariableOffset uint16     `json:",omitempty"`
+	// VPD PCDs are stored at the VPDOffset of the VPD region.
+	VPDOffset *uint32 `json:",omitempty"`
+
+	localToken uint32
+}
+
+// PCDDatabase is a PEI or DXE PCD database.
+type PCDDatabase struct {
+	Header PCDDatabaseHeader
+	Tokens []*PCDToken
+
+	buf []byte
+}
+
+// IsPCDDatabase returns whether buf starts with the PCD database signature.
+func IsPCDDatabase(buf []byte) bool {
+	return len(buf) >= 16 && bytes.Equal(buf[:16], PCDDatabaseGUID[:])
+}
+
+// NewPCDDatabase parses a PCD database, buf may extend past its end.
+func NewPCDDatabase(buf []byte) (*PCDDatabase, error) {
+	if !IsPCDDatabase(buf) {
+		return nil, fmt.Errorf("no PCD database signature")
+	}
+	db := PCDDatabase{}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &db.Header); err != nil {
+		return nil, err
+	}
+	h := &db.Header
+	if h.BuildVersion != PCDDatabaseVersion {
+		return nil, fmt.Errorf("PCD database version %d is not supported, expected %d", h.BuildVersion, PCDDatabaseVersion)
+	}
+	if uint64(h.Length) > uint64(len(buf)) || uint64(h.Length) < uint64(binary.Size(h)) {
+		return nil, fmt.Errorf("PCD database of %#x bytes does not fit into %#x bytes", h.Length, len(buf))
+	}
+	db.buf = append([]byte{}, buf[:h.Length]...)
+
+	tokens, err := db.table(h.LocalTokenNumberTableOffset, 4*int(h.LocalTokenCount), "local token number")
+	if err != nil {
+		return nil, err
+	}
+	sizes, err := db.sizeTable()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(h.LocalTokenCount); i++ {
+		t := &PCDToken{Index: i + 1, TokenNumber: i + 1, localToken: binary.LittleEndian.Uint32(tokens[4*i:])}
+		if err := db.parseToken(t, sizes); err != nil {
+			return nil, fmt.Errorf("PCD %d: %v", t.Index, err)
+		}
+		db.Tokens = append(db.Tokens, t)
+	}
+	if err := db.parseNames(); err != nil {
+		return nil, err
+	}
+	return &db, nil
+}
+
+// table returns size bytes at offset.
+func (db *PCDDatabase) table(offset uint32, size int, name string) ([]byte, error) {
+	if uint64(offset)+uint64(size) > uint64(len(db.buf)) {
+		return nil, fmt.Errorf("%s table at %#x of %#x bytes past the end of the PCD database", name, offset, size)
+	}
+	return db.buf[offset : int(offset)+size], nil
+}
+
+// sizeTable returns the maximum and current sizes of the POINTER PCDs.
+func (db *PCDDatabase) sizeTable() ([]uint16, error) {
+	h := &db.Header
+	n := 0
+	tokens := db.buf[h.LocalTokenNumberTableOffset:]
+	for i := 0; i < int(h.LocalTokenCount); i++ {
+		if (binary.LittleEndian.Uint32(tokens[4*i:])>>pcdDatumTypeShift)&0xf == 0 {
+			n += 2
+		}
+	}
+	buf, err := db.table(h.SizeTableOffset, 2*n, "size")
+	if err != nil {
+		return nil, err
+	}
+	sizes := make([]uint16, n)
+	for i := range sizes {
+		sizes[i] = binary.LittleEndian.Uint16(buf[2*i:])
+	}
+	return sizes, nil
+}
+
+// str returns the NUL terminated ASCII string at offset in the string table.
+func (db *PCDDatabase) str(offset uint32) string {
+	start := uint64(db.Header.StringTableOffset) + uint64(offset)
+	if start >= uint64(len(db.buf)) {
+		return ""
+	}
+	s := db.buf[start:]
+	if i := bytes.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+	return string(s)
+}
+
+// guid returns the GUID at index in the GUID table.
+func (db *PCDDatabase) guid(index uint16) (*uuid.UUID, error) {
+	if index >= db.Header.GUIDTableCount {
+		return nil, fmt.Errorf("GUID index %d out of %d", index, db.Header.GUIDTableCount)
+	}
+	buf, err := db.table(db.Header.GUIDTableOffset+16*uint32(index), 16, "GUID")
+	if err != nil {
+		return nil, err
+	}
+	var g uuid.UUID
+	copy(g[:], buf)
+	return &g, nil
+}
+
+// parseToken decodes the local token number and the default value.
+func (db *PCDDatabase) parseToken(t *PCDToken, sizes []uint16) error {
+	t.Offset = t.localToken & pcdDatabaseOffMask
+	typ := t.localToken >> pcdTypeShift
+	var ok bool
+	if t.Type, ok = pcdTypes[typ]; !ok {
+		return fmt.Errorf("unknown PCD type %#x", typ)
+	}
+	switch datum := (t.localToken >> pcdDatumTypeShift) & 0xf; datum {
+	case 0:
+		t.DatumType = "POINTER"
+		// The sizes of the POINTER PCDs are stored in token order.
+		i := 0
+		for _, o := range db.Tokens {
+			if o.DatumType == "POINTER" {
+				i += 2
+			}
+		}
+		t.Size = int(sizes[i])
+	case 1, 2, 4, 8:
+		t.DatumType = fmt.Sprintf("UINT%d", 8*datum)
+		if datum == 1 && t.localToken&pcdBooleanBit != 0 {
+			t.DatumType = "BOOLEAN"
+		}
+		t.Size = int(datum)
+	default:
+		return fmt.Errorf("unknown PCD datum type %#x", datum)
+	}
+
+	switch t.Type {
+	case "DATA":
+		return db.parseValue(t, t.Offset)
+	case "STRING":
+		head, err := db.table(t.Offset, 4, "string head")
+		if err != nil {
+			return err
+		}
+		return db.parseValue(t, db.Header.StringTableOffset+binary.LittleEndian.Uint32(head))
+	case "HII":
+		var head pcdVariableHead
+		buf, err := db.table(t.Offset, binary.Size(head), "variable head")
+		if err != nil {
+			return err
+		}
+		if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &head); err != nil {
+			return err
+		}
+		if t.VariableGUID, err = db.guid(head.GUIDTableIndex); err != nil {
+			return err
+		}
+		start := int(db.Header.StringTableOffset) + int(head.StringIndex)
+		if start < len(db.buf) {
+			t.VariableName = unicode.UCS2ToUTF8(db.buf[start:])
+		}
+		t.VariableOffset = head.Offset
+		return db.parseValue(t, uint32(head.DefaultValueOffset))
+	case "VPD":
+		buf, err := db.table(t.Offset, 4, "VPD head")
+		if err != nil {
+			return err
+		}
+		offset := binary.LittleEndian.Uint32(buf)
+		t.VPDOffset = &offset
+	}
+	return nil
+}
+
+// parseValue sets the value of the PCD stored at offset. Values past the
+// initialized data are 0.
+func (db *PCDDatabase) parseValue(t *PCDToken, offset uint32) error {
+	if offset >= db.Header.Length {
+		t.Uninitialized = true
+		t.Value = formatPCDValue(t.DatumType, make([]byte, t.Size))
+		return nil
+	}
+	buf, err := db.table(offset, t.Size, "value")
+	if err != nil {
+		return err
+	}
+	t.Value = formatPCDValue(t.DatumType, buf)
+	return nil
+}
+
+// formatPCDValue formats a value like in DSC files.
+func formatPCDValue(datumType string, buf []byte) string {
+	switch datumType {
+	case "BOOLEAN":
+		if buf[0] != 0 {
+			return "TRUE"
+		}
+		return "FALSE"
+	case "POINTER":
+		var b []string
+		for _, c := range buf {
+			b = append(b, fmt.Sprintf("0x%02X", c))
+		}
+		return "{" + strings.Join(b, ", ") + "}"
+	}
+	var v uint64
+	for i := len(buf) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(buf[i])
+	}
+	return fmt.Sprintf("%#x", v)
+}
+
+// parseNames sets the names of the PCDs from the name table, if any.
+func (db *PCDDatabase) parseNames() error {
+	h := &db.Header
+	if h.PcdNameTableOffset == 0 {
+		return nil
+	}
+	buf, err := db.table(h.PcdNameTableOffset, 8*int(h.LocalTokenCount), "name")
+	if err != nil {
+		return err
+	}
+	for i, t := range db.Tokens {
+		space := db.str(binary.LittleEndian.Uint32(buf[8*i:]))
+		name := db.str(binary.LittleEndian.Uint32(buf[8*i+4:]))
+		if space != "" && name != "" {
+			t.Name = space + "." + name
+		}
+	}
+	return nil
+}
+
+// SetTokenBase sets the token numbers of the PCDs, following the base tokens
+// of the earlier databases, and maps the DynamicEx PCDs to them.
+func (db *PCDDatabase) SetTokenBase(base int) error {
+	for _, t := range db.Tokens {
+		t.TokenNumber = base + t.Index
+	}
+	h := &db.Header
+	var m pcdExMapping
+	buf, err := db.table(h.ExMapTableOffset, binary.Size(m)*int(h.ExTokenCount), "DynamicEx map")
+	if err != nil {
+		return err
+	}
+	r := bytes.NewReader(buf)
+	for i := 0; i < int(h.ExTokenCount); i++ {
+		if err := binary.Read(r, binary.LittleEndian, &m); err != nil {
+			return err
+		}
+		index := int(m.TokenNumber) - base
+		if index < 1 || index > len(db.Tokens) {
+			continue
+		}
+		t := db.Tokens[index-1]
+		ex := m.ExTokenNumber
+		t.ExTokenNumber = &ex
+		if t.ExGUID, err = db.guid(m.ExGUIDIndex); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetValue sets the default value of an initialized DATA PCD of a fixed size.
+func (db *PCDDatabase) SetValue(t *PCDToken, value uint64) error {
+	if t.Type != "DATA" || t.DatumType == "POINTER" {
+		return fmt.Errorf("PCD %d is a %v %v PCD, only DATA PCDs of a fixed size can be set", t.TokenNumber, t.Type, t.DatumType)
+	}
+	if t.Uninitialized {
+		return fmt.Errorf("PCD %d is uninitialized, its value is not stored in the database", t.TokenNumber)
+	}
+	if t.DatumType == "BOOLEAN" && value > 1 {
+		return fmt.Errorf("BOOLEAN PCD %d cannot be set to %d", t.TokenNumber, value)
+	}
+	if t.Size < 8 && value>>(8*uint(t.Size)) != 0 {
+		return fmt.Errorf("value %#x does not fit into the %v PCD %d", value, t.DatumType, t.TokenNumber)
+	}
+	buf, err := db.table(t.Offset, t.Size, "value")
+	if err != nil {
+		return err
+	}
+	for i := range buf {
+		buf[i] = byte(value >> (8 * uint(i)))
+	}
+	t.Value = formatPCDValue(t.DatumType, buf)
+	return nil
+}
+
+// Buf returns the buffer of the database.
+func (db *PCDDatabase) Buf() []byte {
+	return db.buf
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// pcdDatabase builds a database with a UINT32 of 0x1234 and a BOOLEAN set to
+// TRUE, followed by an uninitialized UINT16.
+func pcdDatabase(t *testing.T) []byte {
+	h := PCDDatabaseHeader{
+		Signature:       *PCDDatabaseGUID,
+		BuildVersion:    PCDDatabaseVersion,
+		LocalTokenCount: 3,
+	}
+	hlen := uint32(binary.Size(h))
+	h.LocalTokenNumberTableOffset = hlen + 8
+	h.ExMapTableOffset = h.LocalTokenNumberTableOffset + 12
+	h.GUIDTableOffset = h.ExMapTableOffset
+	h.StringTableOffset = h.ExMapTableOffset
+	h.SizeTableOffset = h.ExMapTableOffset
+	h.SkuIDTableOffset = h.ExMapTableOffset
+	h.Length = h.ExMapTableOffset
+	h.UninitDataBaseSize = 2
+
+	buf := new(bytes.Buffer)
+	for _, v := range []interface{}{
+		h,
+		uint32(0x1234), uint8(1), [3]uint8{},
+		uint32(4<<pcdDatumTypeShift | hlen),
+		uint32(1<<pcdDatumTypeShift | pcdBooleanBit | (hlen + 4)),
+		uint32(2<<pcdDatumTypeShift | h.Length),
+	} {
+		if err := binary.Write(buf, binary.LittleEndian, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestNewPCDDatabase(t *testing.T) {
+	buf := pcdDatabase(t)
+	if !IsPCDDatabase(buf) {
+		t.Fatal("expected a PCD database")
+	}
+	db, err := NewPCDDatabase(append(buf, 0xff, 0xff))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		datumType, value string
+		uninitialized    bool
+	}{
+		{"UINT32", "0x1234", false},
+		{"BOOLEAN", "TRUE", false},
+		{"UINT16", "0x0", true},
+	}
+	if len(db.Tokens) != len(tests) {
+		t.Fatalf("expected %d tokens, got %d", len(tests), len(db.Tokens))
+	}
+	for i, test := range tests {
+		tok := db.Tokens[i]
+		if tok.Type != "DATA" || tok.DatumType != test.datumType || tok.Value != test.value || tok.Uninitialized != test.uninitialized {
+			t.Errorf("token %d: expected DATA %v %v, got %v %v %v", i+1, test.datumType, test.value, tok.Type, tok.DatumType, tok.Value)
+		}
+	}
+	if err := db.SetTokenBase(10); err != nil {
+		t.Fatal(err)
+	}
+	if db.Tokens[0].TokenNumber != 11 {
+		t.Errorf("expected token number 11, got %d", db.Tokens[0].TokenNumber)
+	}
+
+	buf[20] = 6
+	if _, err := NewPCDDatabase(buf); err == nil {
+		t.Error("expected an error for version 6")
+	}
+}
+
+func TestPCDDatabaseSetValue(t *testing.T) {
+	buf := pcdDatabase(t)
+	db, err := NewPCDDatabase(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetValue(db.Tokens[0], 0xcafe); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetValue(db.Tokens[1], 0); err != nil {
+		t.Fatal(err)
+	}
+	for i, err := range []error{
+		db.SetValue(db.Tokens[0], 0x100000000),
+		db.SetValue(db.Tokens[1], 2),
+		db.SetValue(db.Tokens[2], 1),
+	} {
+		if err == nil {
+			t.Errorf("%d: expected an error", i)
+		}
+	}
+	if !bytes.Equal(buf, pcdDatabase(t)) {
+		t.Error("SetValue modified the parsed buffer")
+	}
+
+	parsed, err := NewPCDDatabase(db.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Tokens[0].Value != "0xcafe" || parsed.Tokens[1].Value != "FALSE" {
+		t.Errorf("unexpected values %v and %v", parsed.Tokens[0].Value, parsed.Tokens[1].Value)
+	}
+}
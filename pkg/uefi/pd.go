@@ -0,0 +1,125 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The PD region has no common layout, platforms keep what they like in it,
+// e.g. FVs of the OEM, code partitions of the CSME or the firmware of
+// controllers. It is split into blobs at the erased blocks in between them,
+// and the blobs are identified by their signatures.
+
+// PDBlockSize is the alignment of the blobs of the PD region.
+const PDBlockSize = 0x1000
+
+// pdErased is the value of erased bytes of the PD region, which is not
+// described by an FV.
+const pdErased = 0xFF
+
+// PD blob types.
+const (
+	PDBlobFV   = "FV"
+	PDBlobCPD  = "CPD"
+	PDBlobEC   = "EC"
+	PDBlobData = "Data"
+)
+
+// PDBlob is a blob of the PD region.
+type PDBlob struct {
+	Offset uint32
+	Length uint32
+	Type   string
+	// Name is the filesystem of an FV, the partition name of a code
+	// partition directory or the vendor of EC firmware.
+	Name string `json:",omitempty"`
+}
+
+// identifyPDBlob returns the type, name and, if known, the length of the blob
+// at the start of buf.
+func identifyPDBlob(buf []byte) (string, string, uint64) {
+	if FindFirmwareVolumeOffset(buf) == 0 {
+		fv, err := NewFirmwareVolume(buf, 0, false)
+		if err == nil {
+			name, ok := FVGUIDs[fv.FileSystemGUID]
+			if !ok {
+				name = fv.FileSystemGUID.String()
+			}
+			return PDBlobFV, name, fv.Length
+		}
+	}
+	if len(buf) >= 16 && string(buf[:4]) == string(CPDSignature) {
+		return PDBlobCPD, strings.TrimRight(string(buf[12:16]), "\x00"), 0
+	}
+	if ec := IdentifyECFirmware(buf); ec != nil {
+		return PDBlobEC, ec.Vendor, 0
+	}
+	return PDBlobData, "", 0
+}
+
+// parse splits the PD region into blobs. Blobs start on a block boundary,
+// FVs have the length of their header, other blobs extend up to the next
+// erased block.
+func (pd *PDRegion) parse() {
+	pd.Blobs = nil
+	size := uint64(len(pd.buf))
+	block := func(offset uint64) []byte {
+		end := offset + PDBlockSize
+		if end > size {
+			end = size
+		}
+		return pd.buf[offset:end]
+	}
+	for offset := uint64(0); offset < size; {
+		if IsErased(block(offset), pdErased) {
+			offset += PDBlockSize
+			continue
+		}
+		typ, name, length := identifyPDBlob(pd.buf[offset:])
+		if length == 0 {
+			for length = PDBlockSize; offset+length < size && !IsErased(block(offset+length), pdErased); length += PDBlockSize {
+			}
+		}
+		if offset+length > size {
+			length = size - offset
+		}
+		pd.Blobs = append(pd.Blobs, &PDBlob{Offset: uint32(offset), Length: uint32(length), Type: typ, Name: name})
+		offset = Align(offset+length, PDBlockSize)
+	}
+}
+
+// Blob returns the data of blob i.
+func (pd *PDRegion) Blob(i int) ([]byte, error) {
+	if i < 0 || i >= len(pd.Blobs) {
+		return nil, fmt.Errorf("no PD blob %d, the PD region has %d", i, len(pd.Blobs))
+	}
+	b := pd.Blobs[i]
+	return pd.buf[b.Offset : b.Offset+b.Length], nil
+}
+
+// ReplaceBlob replaces the data of blob i. The blob may grow into the erased
+// space up to the next blob, any old data beyond the new length is erased.
+func (pd *PDRegion) ReplaceBlob(i int, data []byte) error {
+	if _, err := pd.Blob(i); err != nil {
+		return err
+	}
+	b := pd.Blobs[i]
+	limit := uint64(len(pd.buf))
+	if i+1 < len(pd.Blobs) {
+		limit = uint64(pd.Blobs[i+1].Offset)
+	}
+	if uint64(b.Offset)+uint64(len(data)) > limit {
+		return fmt.Errorf("PD blob %d of %#x bytes does not fit into the %#x bytes at %#x",
+			i, len(data), limit-uint64(b.Offset), b.Offset)
+	}
+	buf := append([]byte{}, pd.buf...)
+	Erase(buf[b.Offset:uint64(b.Offset)+uint64(b.Length)], pdErased)
+	copy(buf[b.Offset:], data)
+	pd.buf = buf
+	pd.parse()
+	return nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"testing"
+)
+
+// makePDRegion returns a PD region with an FV, a code partition directory,
+// EC firmware and other data, separated by erased blocks.
+func makePDRegion() ([]byte, []uint64) {
+	fvEnd := Align(uint64(len(sampleFV)), PDBlockSize)
+	offsets := []uint64{0, fvEnd + PDBlockSize, fvEnd + 4*PDBlockSize, fvEnd + 7*PDBlockSize}
+	buf := bytes.Repeat([]byte{0xff}, int(fvEnd+10*PDBlockSize))
+	copy(buf, sampleFV)
+	cpd := make([]byte, 0x800)
+	copy(cpd, "$CPD")
+	copy(cpd[12:], "ISHC")
+	copy(buf[offsets[1]:], cpd)
+	copy(buf[offsets[2]:], makeITEFirmware(0x1800))
+	copy(buf[offsets[3]:], bytes.Repeat([]byte{0x5a}, 0x1100))
+	return buf, offsets
+}
+
+func TestPDRegion(t *testing.T) {
+	buf, offsets := makePDRegion()
+	pd, err := NewPDRegion(buf, &Region{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []PDBlob{
+		{uint32(offsets[0]), uint32(len(sampleFV)), PDBlobFV, "FFS2"},
+		{uint32(offsets[1]), PDBlockSize, PDBlobCPD, "ISHC"},
+		{uint32(offsets[2]), 2 * PDBlockSize, PDBlobEC, "ITE"},
+		{uint32(offsets[3]), 2 * PDBlockSize, PDBlobData, ""},
+	}
+	if len(pd.Blobs) != len(want) {
+		t.Fatalf("expected %d blobs, got %d", len(want), len(pd.Blobs))
+	}
+	for i, b := range pd.Blobs {
+		if *b != want[i] {
+			t.Errorf("blob %d: expected %+v, got %+v", i, want[i], *b)
+		}
+	}
+
+	// Blobs may grow into the erased space up to the next blob.
+	if err := pd.ReplaceBlob(1, make([]byte, 3*PDBlockSize+1)); err == nil {
+		t.Error("expected an error for a blob overlapping the next one")
+	}
+	cpd := make([]byte, PDBlockSize+0x800)
+	copy(cpd, "$CPD")
+	copy(cpd[12:], "IOMP")
+	if err := pd.ReplaceBlob(1, cpd); err != nil {
+		t.Fatal(err)
+	}
+	if b := pd.Blobs[1]; b.Length != 2*PDBlockSize || b.Name != "IOMP" {
+		t.Errorf("unexpected blob after the replacement %+v", b)
+	}
+	if blob, err := pd.Blob(1); err != nil || !bytes.Equal(blob[:len(cpd)], cpd) {
+		t.Errorf("unexpected data of the replaced blob, %v", err)
+	}
+	if _, err := pd.Blob(4); err == nil {
+		t.Error("expected an error for a missing blob")
+	}
+}
@@ -15,8 +15,12 @@ type PDRegion struct {
 	buf []byte
 	//Metadata for extraction and recovery
 	ExtractPath string
+	Location
 	// This is a pointer to the Region struct laid out in the ifd
 	Position *Region
+
+	// Blobs are the blobs found in the region, see parse.
+	Blobs []*PDBlob `json:",omitempty"`
 }
 
 // NewPDRegion parses a sequence of bytes and returns a PDRegion
@@ -24,6 +28,7 @@ type PDRegion struct {
 // Region struct uncovered in the ifd.
 func NewPDRegion(buf []byte, r *Region) (*PDRegion, error) {
 	pdr := PDRegion{buf: buf, Position: r}
+	pdr.parse()
 	return &pdr, nil
 }
 
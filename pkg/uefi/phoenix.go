@@ -0,0 +1,113 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// Phoenix SCT images keep a flash map in their NVRAM FV, which lists the
+// volumes and the data blocks of the flash, like the NVRAM stores, with their
+// addresses. The volumes themselves use the FFS2 layout.
+
+// PhoenixFlashMapSignature starts the Phoenix SCT flash map.
+const PhoenixFlashMapSignature = "_FLASH_MAP"
+
+// Phoenix SCT flash map sizes.
+const (
+	// PhoenixFlashMapHeaderSize is the size of the flash map header.
+	PhoenixFlashMapHeaderSize = 16
+	// PhoenixFlashMapEntrySize is the size of a flash map entry.
+	PhoenixFlashMapEntrySize = 36
+)
+
+// Phoenix SCT flash map data types.
+const (
+	PhoenixFlashMapVolume    = 0
+	PhoenixFlashMapDataBlock = 1
+)
+
+// PhoenixFlashMapHeader is the header of the Phoenix SCT flash map.
+type PhoenixFlashMapHeader struct {
+	Signature  [10]uint8 `json:"-"`
+	NumEntries uint16
+	Reserved   uint32 `json:"-"`
+}
+
+// PhoenixFlashMapEntry describes an area of the flash. GUID is the filesystem
+// GUID of a volume or the GUID of a data block.
+type PhoenixFlashMapEntry struct {
+	GUID            uuid.UUID
+	DataType        uint16
+	EntryType       uint16
+	PhysicalAddress uint64
+	Size            uint32
+	Offset          uint32
+}
+
+// PhoenixFlashMap is the flash map of an NVRAM FV. It is kept as it is when
+// the FV is assembled.
+type PhoenixFlashMap struct {
+	Header  PhoenixFlashMapHeader
+	Entries []PhoenixFlashMapEntry
+	// Offset is the offset of the flash map in the FV.
+	Offset uint64
+
+	buf []byte
+}
+
+// NewPhoenixFlashMap parses the flash map at the start of buf, buf may be
+// longer than the flash map.
+func NewPhoenixFlashMap(buf []byte) (*PhoenixFlashMap, error) {
+	if !bytes.HasPrefix(buf, []byte(PhoenixFlashMapSignature)) {
+		return nil, fmt.Errorf("no %v signature", PhoenixFlashMapSignature)
+	}
+	m := PhoenixFlashMap{}
+	r := bytes.NewReader(buf)
+	if err := binary.Read(r, binary.LittleEndian, &m.Header); err != nil {
+		return nil, err
+	}
+	end := PhoenixFlashMapHeaderSize + uint64(m.Header.NumEntries)*PhoenixFlashMapEntrySize
+	if end > uint64(len(buf)) {
+		return nil, fmt.Errorf("Phoenix flash map of %d entries does not fit into %#x bytes",
+			m.Header.NumEntries, len(buf)-PhoenixFlashMapHeaderSize)
+	}
+	m.Entries = make([]PhoenixFlashMapEntry, m.Header.NumEntries)
+	if err := binary.Read(r, binary.LittleEndian, m.Entries); err != nil {
+		return nil, err
+	}
+	m.buf = buf[:end]
+	return &m, nil
+}
+
+// Buf returns the flash map, including the header.
+func (m *PhoenixFlashMap) Buf() []byte {
+	return m.buf
+}
+
+// ParsePhoenixFlashMap looks for the Phoenix SCT flash map in the data of an
+// NVRAM FV and sets PhoenixFlashMap.
+func (fv *FirmwareVolume) ParsePhoenixFlashMap() error {
+	fv.PhoenixFlashMap = nil
+	if fv.DataOffset >= uint64(len(fv.buf)) {
+		return nil
+	}
+	i := bytes.Index(fv.buf[fv.DataOffset:], []byte(PhoenixFlashMapSignature))
+	if i < 0 {
+		return nil
+	}
+	offset := fv.DataOffset + uint64(i)
+	m, err := NewPhoenixFlashMap(fv.buf[offset:])
+	if err != nil {
+		return anomaly("unable to parse Phoenix flash map at offset %#x into FV: %v", offset, err)
+	}
+	m.Offset = offset
+	fv.PhoenixFlashMap = m
+	return nil
+}
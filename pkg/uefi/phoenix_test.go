@@ -0,0 +1,81 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// makePhoenixNVRAMFV returns an NVRAM FV, made from sampleFV, holding the
+// flash map at offset 0x100 of its data.
+func makePhoenixNVRAMFV(t *testing.T, flashMap []byte) []byte {
+	buf := append([]byte{}, sampleFV...)
+	copy(buf[16:], EVSA[:])
+	headerLen := binary.LittleEndian.Uint16(buf[48:])
+	binary.LittleEndian.PutUint16(buf[50:], 0)
+	sum, err := Checksum16(buf[:headerLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint16(buf[50:], 0-sum)
+	fv, err := NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(buf[fv.DataOffset:], bytes.Repeat([]byte{0xff}, len(buf)-int(fv.DataOffset)))
+	copy(buf[fv.DataOffset+0x100:], flashMap)
+	return buf
+}
+
+// makePhoenixFlashMap returns a flash map with a volume and a data block.
+func makePhoenixFlashMap(entries uint16) []byte {
+	buf := make([]byte, PhoenixFlashMapHeaderSize+2*PhoenixFlashMapEntrySize)
+	copy(buf, PhoenixFlashMapSignature)
+	binary.LittleEndian.PutUint16(buf[10:], entries)
+	e := buf[PhoenixFlashMapHeaderSize:]
+	copy(e, FFS2[:])
+	binary.LittleEndian.PutUint16(e[16:], PhoenixFlashMapVolume)
+	binary.LittleEndian.PutUint64(e[20:], 0xFFE00000)
+	binary.LittleEndian.PutUint32(e[28:], 0x100000)
+	e = e[PhoenixFlashMapEntrySize:]
+	copy(e, VariableStoreGUID[:])
+	binary.LittleEndian.PutUint16(e[16:], PhoenixFlashMapDataBlock)
+	binary.LittleEndian.PutUint64(e[20:], 0xFFD00000)
+	binary.LittleEndian.PutUint32(e[28:], 0x10000)
+	binary.LittleEndian.PutUint32(e[32:], 0x48)
+	return buf
+}
+
+func TestPhoenixFlashMap(t *testing.T) {
+	fv, err := NewFirmwareVolume(makePhoenixNVRAMFV(t, makePhoenixFlashMap(2)), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := fv.PhoenixFlashMap
+	if m == nil {
+		t.Fatal("expected a Phoenix flash map")
+	}
+	if m.Offset != fv.DataOffset+0x100 || len(m.Buf()) != PhoenixFlashMapHeaderSize+2*PhoenixFlashMapEntrySize {
+		t.Errorf("unexpected flash map at %#x of %#x bytes", m.Offset, len(m.Buf()))
+	}
+	if len(m.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d", len(m.Entries))
+	}
+	if e := m.Entries[0]; e.GUID != *FFS2 || e.DataType != PhoenixFlashMapVolume || e.PhysicalAddress != 0xFFE00000 || e.Size != 0x100000 {
+		t.Errorf("unexpected volume entry %+v", e)
+	}
+	if e := m.Entries[1]; e.GUID != *VariableStoreGUID || e.DataType != PhoenixFlashMapDataBlock || e.Offset != 0x48 {
+		t.Errorf("unexpected data block entry %+v", e)
+	}
+
+	// A flash map with too many entries is an anomaly.
+	defer SetParseMode(ParseStrict)
+	SetParseMode(ParseStrict)
+	if _, err := NewFirmwareVolume(makePhoenixNVRAMFV(t, makePhoenixFlashMap(0xffff)), 0, false); err == nil {
+		t.Error("expected an error for a truncated flash map")
+	}
+}
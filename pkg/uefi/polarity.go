@@ -0,0 +1,111 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PolarityMode controls how the erase polarity of FVs is determined. Some
+// vendor images have the ERASE_POLARITY attribute wrong, which makes every
+// file state and pad file look invalid.
+type PolarityMode int
+
+// Polarity modes.
+const (
+	// PolarityAttribute trusts the ERASE_POLARITY attribute. This is the
+	// default.
+	PolarityAttribute PolarityMode = iota
+	// PolarityInfer uses the dominant fill byte of the FV body, 0x00 or
+	// 0xFF, if it contradicts the attribute.
+	PolarityInfer
+	// Polarity00 and PolarityFF force the erase polarity of all FVs.
+	Polarity00
+	PolarityFF
+)
+
+var polarityModeNames = map[PolarityMode]string{
+	PolarityAttribute: "attribute",
+	PolarityInfer:     "infer",
+	Polarity00:        "0x00",
+	PolarityFF:        "0xff",
+}
+
+// String returns the name of the polarity mode.
+func (m PolarityMode) String() string {
+	if s, ok := polarityModeNames[m]; ok {
+		return s
+	}
+	return fmt.Sprintf("PolarityMode(%d)", int(m))
+}
+
+// ParsePolarityMode parses a polarity mode by its name.
+func ParsePolarityMode(s string) (PolarityMode, error) {
+	for m, name := range polarityModeNames {
+		if strings.EqualFold(s, name) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown erase polarity mode %q, expected attribute, infer, 0x00 or 0xff", s)
+}
+
+var polarityMode = PolarityAttribute
+
+// SetPolarityMode sets the polarity mode of all subsequent parsing.
+func SetPolarityMode(m PolarityMode) {
+	polarityMode = m
+}
+
+// fillPolarity returns the dominant fill byte of buf, 0x00 or 0xFF, and
+// whether there is one.
+func fillPolarity(buf []byte) (uint8, bool) {
+	var zeros, ones int
+	for _, b := range buf {
+		switch b {
+		case 0x00:
+			zeros++
+		case 0xFF:
+			ones++
+		}
+	}
+	switch {
+	case ones > zeros:
+		return 0xFF, true
+	case zeros > ones:
+		return 0x00, true
+	}
+	return 0, false
+}
+
+// applyPolarityMode sets the erase polarity override of a newly parsed FV
+// according to the polarity mode.
+func (fv *FirmwareVolume) applyPolarityMode() error {
+	var p uint8
+	switch polarityMode {
+	case Polarity00:
+		p = 0x00
+	case PolarityFF:
+		p = 0xFF
+	case PolarityInfer:
+		if fv.DataOffset >= uint64(len(fv.buf)) {
+			return anomaly("FV at offset %#x has its data at %#x, past its end, cannot infer the erase polarity",
+				fv.FVOffset, fv.DataOffset)
+		}
+		fill, ok := fillPolarity(fv.buf[fv.DataOffset:])
+		if !ok || fill == fv.GetErasePolarity() {
+			return nil
+		}
+		if err := anomaly("FV at offset %#x has erase polarity %#02x but is filled with %#02x, using %#02x",
+			fv.FVOffset, fv.GetErasePolarity(), fill, fill); err != nil {
+			return err
+		}
+		p = fill
+	default:
+		return nil
+	}
+	fv.ErasePolarityOverride = &p
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestPolarityMode(t *testing.T) {
+	// Clear the ERASE_POLARITY attribute of an FV erased to 0xFF.
+	buf := append([]byte{}, sampleFV...)
+	attr := binary.LittleEndian.Uint32(buf[44:])
+	binary.LittleEndian.PutUint32(buf[44:], attr&^uint32(FVAttributeErasePolarity))
+	binary.LittleEndian.PutUint16(buf[50:], 0)
+	sum, err := Checksum16(buf[:binary.LittleEndian.Uint16(buf[48:])])
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint16(buf[50:], 0-sum)
+
+	defer SetParseMode(ParseStrict)
+	defer SetPolarityMode(PolarityAttribute)
+	SetParseMode(ParsePermissive)
+	var tests = []struct {
+		mode     string
+		polarity uint8
+	}{
+		{"attribute", 0x00},
+		{"infer", 0xFF},
+		{"0xff", 0xFF},
+		{"0x00", 0x00},
+	}
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			m, err := ParsePolarityMode(test.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			SetPolarityMode(m)
+			fv, err := NewFirmwareVolume(buf, 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p := fv.GetErasePolarity(); p != test.polarity {
+				t.Errorf("expected erase polarity %#02x, got %#02x", test.polarity, p)
+			}
+			if fv.Attributes&FVAttributeErasePolarity != 0 {
+				t.Error("the attribute in the header was changed")
+			}
+			if test.polarity == 0xFF && fv.Files[1].State != "EFI_FILE_DATA_VALID" {
+				t.Errorf("expected a valid pad file, got %v", fv.Files[1].State)
+			}
+		})
+	}
+
+	if _, err := ParsePolarityMode("bogus"); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
+
+func TestPolarityModeDataPastEnd(t *testing.T) {
+	// The header is longer than the FV, so there is no data to infer the
+	// erase polarity from.
+	buf := append([]byte{}, sampleFV...)
+	binary.LittleEndian.PutUint64(buf[32:], 0x1000)
+	binary.LittleEndian.PutUint16(buf[48:], 0xF000)
+	binary.LittleEndian.PutUint16(buf[52:], 0) // no extended header
+
+	defer SetParseMode(ParseStrict)
+	defer SetPolarityMode(PolarityAttribute)
+	SetPolarityMode(PolarityInfer)
+	SetParseMode(ParsePermissive)
+	if _, err := NewFirmwareVolume(buf, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	SetParseMode(ParseStrict)
+	if _, err := NewFirmwareVolume(buf, 0, false); err == nil {
+		t.Error("expected an error for the data past the end of the FV")
+	}
+}
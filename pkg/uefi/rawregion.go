@@ -0,0 +1,71 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"errors"
+	"fmt"
+)
+
+// RawRegion represents a flash region without a dedicated type, such as the
+// secondary BIOS or the IE region. Its contents are kept as is.
+type RawRegion struct {
+	// holds the raw data
+	buf []byte
+	//Metadata for extraction and recovery
+	ExtractPath string
+	Location
+	// Index is the index of the region in the ifd region section.
+	Index int
+	// Name is the name of the region, if known.
+	Name string
+	// This is a pointer to the Region struct laid out in the ifd
+	Position *Region
+}
+
+// NewRawRegion returns a RawRegion for the region at index i of the ifd. It
+// also points to the Region struct uncovered in the ifd.
+func NewRawRegion(buf []byte, r *Region, i int) (*RawRegion, error) {
+	if i < 0 || i >= len(FlashRegionNames) {
+		return nil, fmt.Errorf("invalid region index %d", i)
+	}
+	rr := RawRegion{buf: buf, Position: r, Index: i, Name: FlashRegionNames[i]}
+	return &rr, nil
+}
+
+// Buf returns the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (rr *RawRegion) Buf() []byte {
+	return rr.buf
+}
+
+// SetBuf sets the buffer.
+// Used mostly for things interacting with the Firmware interface.
+func (rr *RawRegion) SetBuf(buf []byte) {
+	rr.buf = buf
+}
+
+// Apply calls the visitor on the RawRegion.
+func (rr *RawRegion) Apply(v Visitor) error {
+	return v.Visit(rr)
+}
+
+// ApplyChildren calls the visitor on each child node of RawRegion.
+func (rr *RawRegion) ApplyChildren(v Visitor) error {
+	return nil
+}
+
+// Validate Region
+func (rr *RawRegion) Validate() []error {
+	errs := make([]error, 0)
+	if rr.Position == nil {
+		errs = append(errs, errors.New("RawRegion position is nil"))
+		return errs
+	}
+	if !rr.Position.Valid() {
+		errs = append(errs, fmt.Errorf("RawRegion %d is not valid, region was %v", rr.Index, *rr.Position))
+	}
+	return errs
+}
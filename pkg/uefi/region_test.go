@@ -69,3 +69,9 @@ func TestRegion_EndOffset(t *testing.T) {
 		}
 	}
 }
+
+func TestECRegionValidateNilPosition(t *testing.T) {
+	if errs := (&ECRegion{}).Validate(); len(errs) != 1 {
+		t.Errorf("expected one error for an EC region without position, got %v", errs)
+	}
+}
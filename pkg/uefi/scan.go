@@ -0,0 +1,104 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// Types of the structures found by Scan.
+const (
+	CarvedFV        = "FV"
+	CarvedMicrocode = "Microcode"
+	CarvedME        = "ME"
+)
+
+// Carved is a firmware structure found by Scan.
+type Carved struct {
+	Type   string
+	Offset uint64
+	Length uint64
+	// FV is set for FVs.
+	FV *FirmwareVolume `json:"-"`
+
+	buf []byte
+}
+
+// Buf returns the bytes of the structure.
+func (c *Carved) Buf() []byte {
+	return c.buf
+}
+
+// Scan searches an arbitrary blob, e.g. a memory dump or an update payload,
+// for FVs, microcode updates and ME regions, identified by their FPT. Unlike
+// Parse it does not assume a flash image, the structures may be anywhere in
+// buf as long as they are naturally aligned: FVs to 8 bytes, microcode
+// updates and FPTs to 16 bytes. Structures found within another one, e.g.
+// microcode updates in an FV, are not reported separately.
+func Scan(buf []byte) []Carved {
+	var found []Carved
+	fvSig := []byte("_FVH")
+	for off := 0; off+len(fvSig) <= len(buf); {
+		var c *Carved
+		if off+40+len(fvSig) <= len(buf) && bytes.Equal(buf[off+40:off+40+len(fvSig)], fvSig) {
+			c = scanFV(buf, off)
+		}
+		if c == nil && off%16 == 0 {
+			if bytes.Equal(buf[off:off+len(FPTSignature)], FPTSignature) {
+				c = scanME(buf, off)
+			} else if off+24 <= len(buf) && binary.LittleEndian.Uint32(buf[off:]) == 1 &&
+				binary.LittleEndian.Uint32(buf[off+20:]) == 1 {
+				// Only candidates with the header and loader version of
+				// a microcode update are checksummed.
+				if size, err := MicrocodeSize(buf[off:]); err == nil {
+					c = &Carved{Type: CarvedMicrocode, Offset: uint64(off), Length: size}
+				}
+			}
+		}
+		if c == nil {
+			off += 8
+			continue
+		}
+		c.buf = buf[c.Offset : c.Offset+c.Length]
+		found = append(found, *c)
+		// Continue at the next aligned offset after the structure.
+		off = int(c.Offset+c.Length+7) &^ 7
+	}
+	return found
+}
+
+func scanFV(buf []byte, off int) *Carved {
+	fv, err := NewFirmwareVolume(buf[off:], uint64(off), false)
+	if err != nil {
+		return nil
+	}
+	return &Carved{Type: CarvedFV, Offset: uint64(off), Length: fv.Length, FV: fv}
+}
+
+// scanME returns the ME region with the FPT at off. It ends with the last
+// partition.
+func scanME(buf []byte, off int) *Carved {
+	fpt, err := NewFPT(buf[off:])
+	if err != nil {
+		return nil
+	}
+	// Partition offsets are relative to the start of the region, which is
+	// in front of the ROM bypass vector if there is one.
+	start := off
+	if off >= 0x10 && fpt.Header.HeaderLength == 0x30 {
+		start -= 0x10
+	}
+	end := uint64(off) + FPTHeaderLength + uint64(len(fpt.Entries))*FPTEntryLength
+	for _, e := range fpt.Entries {
+		if !e.Valid() {
+			continue
+		}
+		if pend := uint64(start) + uint64(e.Offset) + uint64(e.Length); pend > end && pend <= uint64(len(buf)) {
+			end = pend
+		}
+	}
+	return &Carved{Type: CarvedME, Offset: uint64(start), Length: end - uint64(start)}
+}
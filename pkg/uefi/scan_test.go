@@ -0,0 +1,60 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestScan(t *testing.T) {
+	microcode := make([]byte, 2048)
+	binary.LittleEndian.PutUint32(microcode[0:], 1)
+	binary.LittleEndian.PutUint32(microcode[0x14:], 1)
+	binary.LittleEndian.PutUint32(microcode[0x10:], 0xfffffffe) // checksum
+	me := makeMERegion(false)
+
+	// The structures are surrounded by junk, and a stray FV signature.
+	var blob []byte
+	blob = append(blob, bytes.Repeat([]byte("junk"), 0x40)...)
+	fvOffset := len(blob)
+	blob = append(blob, sampleFV...)
+	blob = append(blob, make([]byte, 0x30)...)
+	blob = append(blob, "_FVH"...)
+	blob = append(blob, make([]byte, 0x1c)...)
+	meOffset := len(blob)
+	blob = append(blob, me...)
+	microcodeOffset := len(blob)
+	blob = append(blob, microcode...)
+	blob = append(blob, bytes.Repeat([]byte{0xff}, 0x10)...)
+
+	found := Scan(blob)
+	want := []struct {
+		typez  string
+		offset int
+		buf    []byte
+	}{
+		{CarvedFV, fvOffset, sampleFV},
+		{CarvedME, meOffset, me},
+		{CarvedMicrocode, microcodeOffset, microcode},
+	}
+	if len(found) != len(want) {
+		t.Fatalf("expected %d structures, got %+v", len(want), found)
+	}
+	for i, w := range want {
+		c := found[i]
+		if c.Type != w.typez || c.Offset != uint64(w.offset) || !bytes.Equal(c.Buf(), w.buf) {
+			t.Errorf("expected %v at %#x, got %v at %#x of %#x bytes", w.typez, w.offset, c.Type, c.Offset, c.Length)
+		}
+	}
+	if found[0].FV == nil || len(found[0].FV.Files) != 3 {
+		t.Error("expected the FV to be parsed")
+	}
+
+	if found := Scan(bytes.Repeat([]byte("junk"), 0x100)); len(found) != 0 {
+		t.Errorf("expected nothing in junk, got %+v", found)
+	}
+}
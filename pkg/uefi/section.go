@@ -10,10 +10,10 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
+	"strconv"
+	"strings"
 	"unsafe"
 
-	"github.com/linuxboot/fiano/pkg/lzma"
 	"github.com/linuxboot/fiano/pkg/unicode"
 	"github.com/linuxboot/fiano/pkg/uuid"
 )
@@ -74,6 +74,21 @@ func (s SectionType) String() string {
 	return "UNKNOWN"
 }
 
+// ParseSectionType parses a section type given by its name, with or without
+// the EFI_SECTION_ prefix, or its number. Names are not case sensitive.
+func ParseSectionType(s string) (SectionType, error) {
+	name := strings.TrimPrefix(strings.ToUpper(s), "EFI_SECTION_")
+	for t, n := range sectionTypeNames {
+		if strings.TrimPrefix(n, "EFI_SECTION_") == name {
+			return t, nil
+		}
+	}
+	if n, err := strconv.ParseUint(s, 0, 8); err == nil {
+		return SectionType(n), nil
+	}
+	return 0, fmt.Errorf("unknown section type %q", s)
+}
+
 // GUIDEDSectionAttribute holds a GUIDED section attribute bitfield
 type GUIDEDSectionAttribute uint16
 
@@ -83,6 +98,49 @@ const (
 	GUIDEDSectionAuthStatusValid    GUIDEDSectionAttribute = 0x02
 )
 
+// guidedSectionAttributeJSON is the JSON form of GUIDEDSectionAttribute. Bits
+// without a name are kept in Reserved.
+type guidedSectionAttributeJSON struct {
+	ProcessingRequired bool   `json:"PROCESSING_REQUIRED"`
+	AuthStatusValid    bool   `json:"AUTH_STATUS_VALID"`
+	Reserved           uint16 `json:",omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler.
+func (a GUIDEDSectionAttribute) MarshalJSON() ([]byte, error) {
+	return json.Marshal(guidedSectionAttributeJSON{
+		ProcessingRequired: a&GUIDEDSectionProcessingRequired != 0,
+		AuthStatusValid:    a&GUIDEDSectionAuthStatusValid != 0,
+		Reserved:           uint16(a &^ (GUIDEDSectionProcessingRequired | GUIDEDSectionAuthStatusValid)),
+	})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. The attributes may also be given
+// as a number, as in JSON written by older versions.
+func (a *GUIDEDSectionAttribute) UnmarshalJSON(b []byte) error {
+	if b = bytes.TrimSpace(b); len(b) > 0 && b[0] != '{' {
+		var n uint16
+		if err := json.Unmarshal(b, &n); err != nil {
+			return err
+		}
+		*a = GUIDEDSectionAttribute(n)
+		return nil
+	}
+	var j guidedSectionAttributeJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	attr := GUIDEDSectionAttribute(j.Reserved)
+	if j.ProcessingRequired {
+		attr |= GUIDEDSectionProcessingRequired
+	}
+	if j.AuthStatusValid {
+		attr |= GUIDEDSectionAuthStatusValid
+	}
+	*a = attr
+	return nil
+}
+
 // Well-known GUIDs.
 var (
 	LZMAGUID    = *uuid.MustParse("EE4E5898-3914-4259-9D6E-DC7BD79403CF")
@@ -108,7 +166,7 @@ type SectionExtHeader struct {
 type SectionGUIDDefinedHeader struct {
 	GUID       uuid.UUID
 	DataOffset uint16
-	Attributes uint16
+	Attributes GUIDEDSectionAttribute
 }
 
 // SectionGUIDDefined contains the type specific fields for a
@@ -125,6 +183,17 @@ func (s *SectionGUIDDefined) GetBinHeaderLen() uint32 {
 	return uint32(unsafe.Sizeof(s.SectionGUIDDefinedHeader))
 }
 
+// SectionFreeformSubtypeGUID contains the type specific fields for a
+// EFI_SECTION_FREEFORM_SUBTYPE_GUID section.
+type SectionFreeformSubtypeGUID struct {
+	SubTypeGUID uuid.UUID
+}
+
+// GetBinHeaderLen returns the length of the binary typ specific header
+func (s *SectionFreeformSubtypeGUID) GetBinHeaderLen() uint32 {
+	return uint32(unsafe.Sizeof(*s))
+}
+
 // TypeHeader interface forces type specific headers to report their length
 type TypeHeader interface {
 	GetBinHeaderLen() uint32
@@ -137,7 +206,8 @@ type TypeSpecificHeader struct {
 }
 
 var headerTypes = map[SectionType]func() TypeHeader{
-	SectionTypeGUIDDefined: func() TypeHeader { return &SectionGUIDDefined{} },
+	SectionTypeGUIDDefined:         func() TypeHeader { return &SectionGUIDDefined{} },
+	SectionTypeFreeformSubtypeGUID: func() TypeHeader { return &SectionFreeformSubtypeGUID{} },
 }
 
 // UnmarshalJSON unmarshals a TypeSpecificHeader struct and correctly deduces the
@@ -191,6 +261,7 @@ type Section struct {
 	// Metadata for extraction and recovery
 	ExtractPath string
 	FileOrder   int `json:"-"`
+	Location
 
 	// Type specific fields
 	// TODO: It will be simpler if this was not an interface
@@ -202,8 +273,21 @@ type Section struct {
 	// For EFI_SECTION_DXE_DEPEX, EFI_SECTION_PEI_DEPEX, and EFI_SECTION_MM_DEPEX
 	DepEx []DepExOp `json:",omitempty"`
 
+	// For EFI_SECTION_PE32 with HII resources, the setup forms and strings
+	HII []*HIIPackageList `json:",omitempty"`
+
+	// For EFI_SECTION_RAW holding ACPI tables
+	ACPI []*ACPITable `json:",omitempty"`
+
+	// For EFI_SECTION_RAW holding EC firmware
+	EC *ECFirmware `json:",omitempty"`
+
 	// Encapsulated firmware
 	Encapsulated []*TypedFirmware `json:",omitempty"`
+
+	// ParseError is set if the encapsulated firmware could not be parsed,
+	// the section is kept as it is.
+	ParseError string `json:",omitempty"`
 }
 
 // Buf returns the buffer.
@@ -262,6 +346,12 @@ func (s *Section) GenSecHeader() error {
 		}
 		s.buf = append(tsh.Bytes(), s.buf...)
 	}
+	if s.Header.Type == SectionTypeFreeformSubtypeGUID && s.TypeSpecific != nil {
+		if ff, ok := s.TypeSpecific.Header.(*SectionFreeformSubtypeGUID); ok {
+			// prepend the subtype GUID to the payload
+			s.buf = append(append([]byte{}, ff.SubTypeGUID[:]...), s.buf...)
+		}
+	}
 
 	// Append common header
 	s.Header.Size = Write3Size(uint64(s.Header.ExtendedSize))
@@ -301,6 +391,9 @@ func (s *Section) Validate() []error {
 			sh.ExtendedSize, buflen))
 		return errs
 	}
+	if s.ParseError != "" {
+		errs = append(errs, errors.New(s.ParseError))
+	}
 
 	return errs
 }
@@ -338,6 +431,9 @@ func NewSection(buf []byte, fileOrder int) (*Section, error) {
 		return nil, fmt.Errorf("section size mismatch! Section has size %v, but buffer is %v bytes big",
 			s.Header.ExtendedSize, buflen)
 	}
+	if uint64(s.Header.ExtendedSize) < uint64(headerSize) {
+		return nil, fmt.Errorf("section size %v is smaller than its %v bytes header", s.Header.ExtendedSize, headerSize)
+	}
 	// Slice buffer to the correct size.
 	s.buf = buf[:s.Header.ExtendedSize]
 
@@ -349,23 +445,34 @@ func NewSection(buf []byte, fileOrder int) (*Section, error) {
 			return nil, err
 		}
 		s.TypeSpecific = &TypeSpecificHeader{Type: SectionTypeGUIDDefined, Header: typeSpec}
+		if typeSpec.DataOffset < uint16(headerSize)+uint16(typeSpec.GetBinHeaderLen()) ||
+			uint32(typeSpec.DataOffset) > s.Header.ExtendedSize {
+			return nil, fmt.Errorf("GUID defined section data offset %#x is out of range, section size is %#x",
+				typeSpec.DataOffset, s.Header.ExtendedSize)
+		}
 
 		// Determine how to interpret the section based on the GUID.
 		var encapBuf []byte
-		if typeSpec.Attributes&uint16(GUIDEDSectionProcessingRequired) != 0 {
+		if typeSpec.Attributes&GUIDEDSectionProcessingRequired == 0 {
+			// The data holds the encapsulated sections as they are. Only
+			// sections without GUID specific header data are parsed, as
+			// that data would not survive reassembly.
+			if uint64(typeSpec.DataOffset) == uint64(headerSize)+uint64(typeSpec.GetBinHeaderLen()) {
+				encapBuf = buf[typeSpec.DataOffset:s.Header.ExtendedSize]
+			}
+		} else {
 			var err error
-			switch typeSpec.GUID {
-			case LZMAGUID:
-				typeSpec.Compression = "LZMA"
-				encapBuf, err = lzma.Decode(buf[typeSpec.DataOffset:])
-			case LZMAX86GUID:
-				typeSpec.Compression = "LZMAX86"
-				encapBuf, err = lzma.DecodeX86(buf[typeSpec.DataOffset:])
-			default:
+			if c := CompressorFromGUID(typeSpec.GUID); c != nil {
+				typeSpec.Compression = c.Name()
+				encapBuf, err = c.Decode(s.buf[typeSpec.DataOffset:])
+			} else {
 				typeSpec.Compression = "UNKNOWN"
 			}
 			if err != nil {
-				log.Print(err)
+				if err := anomaly("unable to decode %v section: %v", typeSpec.Compression, err); err != nil {
+					return nil, err
+				}
+				s.ParseError = err.Error()
 				typeSpec.Compression = "UNKNOWN"
 				encapBuf = []byte{}
 			}
@@ -374,8 +481,13 @@ func NewSection(buf []byte, fileOrder int) (*Section, error) {
 		for i, offset := 0, uint64(0); offset < uint64(len(encapBuf)); i++ {
 			encapS, err := NewSection(encapBuf[offset:], i)
 			if err != nil {
-				return nil, fmt.Errorf("error parsing encapsulated section #%d at offset %d: %v",
-					i, offset, err)
+				// The section is kept as it is.
+				s.Encapsulated = nil
+				if err := anomaly("error parsing encapsulated section #%d at offset %d: %v", i, offset, err); err != nil {
+					return nil, err
+				}
+				s.ParseError = err.Error()
+				break
 			}
 			// Align to 4 bytes for now. The PI Spec doesn't say what alignment it should be
 			// but UEFITool aligns to 4 bytes, and this seems to work on everything I have.
@@ -383,26 +495,68 @@ func NewSection(buf []byte, fileOrder int) (*Section, error) {
 			s.Encapsulated = append(s.Encapsulated, MakeTyped(encapS))
 		}
 
+	case SectionTypeFreeformSubtypeGUID:
+		typeSpec := &SectionFreeformSubtypeGUID{}
+		if err := binary.Read(r, binary.LittleEndian, typeSpec); err != nil {
+			return nil, err
+		}
+		if uint64(s.Header.ExtendedSize) < uint64(headerSize)+uint64(typeSpec.GetBinHeaderLen()) {
+			return nil, fmt.Errorf("freeform subtype GUID section size %#x is too small for its header", s.Header.ExtendedSize)
+		}
+		s.TypeSpecific = &TypeSpecificHeader{Type: SectionTypeFreeformSubtypeGUID, Header: typeSpec}
+
 	case SectionTypeUserInterface:
 		s.Name = unicode.UCS2ToUTF8(s.buf[headerSize:])
 
 	case SectionTypeFirmwareVolumeImage:
 		fv, err := NewFirmwareVolume(s.buf[headerSize:], 0, true)
 		if err != nil {
-			return nil, err
+			// The section is kept as it is.
+			if err := anomaly("error parsing FV image section: %v", err); err != nil {
+				return nil, err
+			}
+			s.ParseError = err.Error()
+			break
 		}
 		s.Encapsulated = []*TypedFirmware{MakeTyped(fv)}
 
 	case SectionTypeDXEDepEx, SectionTypePEIDepEx, SectionMMDepEx:
 		var err error
 		if s.DepEx, err = parseDepEx(s.buf[headerSize:]); err != nil {
-			log.Println("warning:", err)
+			if err := anomaly("%v", err); err != nil {
+				return nil, err
+			}
+		}
+
+	case SectionTypeRaw:
+		s.ACPI = ParseACPITables(s.buf[headerSize:])
+		s.EC = IdentifyECFirmware(s.buf[headerSize:])
+
+	case SectionTypePE32:
+		var err error
+		if s.HII, err = PE32HIIPackageLists(s.buf[headerSize:]); err != nil {
+			s.HII = nil
+			if err := anomaly("error parsing HII resources: %v", err); err != nil {
+				return nil, err
+			}
 		}
 	}
 
 	return &s, nil
 }
 
+// NewUISection creates an EFI_SECTION_USER_INTERFACE section holding the name.
+func NewUISection(name string, fileOrder int) (*Section, error) {
+	s := &Section{FileOrder: fileOrder, Name: name}
+	s.Header.Type = SectionTypeUserInterface
+	s.Type = s.Header.Type.String()
+	s.buf = unicode.UTF8ToUCS2(name)
+	if err := s.GenSecHeader(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
 func parseDepEx(b []byte) ([]DepExOp, error) {
 	depEx := []DepExOp{}
 	r := bytes.NewBuffer(b)
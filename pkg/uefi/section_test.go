@@ -5,6 +5,7 @@
 package uefi
 
 import (
+	"encoding/json"
 	"fmt"
 	"reflect"
 	"testing"
@@ -21,6 +22,9 @@ var (
 	smallSec     = append([]byte{22, 0, 0, byte(SectionTypeRaw)}, make([]byte, 18)...) // 20 byte Section
 	linuxSec     = []byte{0x10, 0x00, 0x00, 0x15, 0x4c, 0x00, 0x69, 0x00,
 		0x6e, 0x00, 0x75, 0x00, 0x78, 0x00, 0x00, 0x00} // Linux UI section
+	headerSizeSec = []byte{2, 0, 0, byte(SectionTypeUserInterface)} // Section smaller than its header
+	dataOffsetSec = append(append([]byte{24, 0, 0, byte(SectionTypeGUIDDefined)}, LZMAGUID[:]...),
+		0xFF, 0xFF, 0x01, 0x00) // GUID defined section with its data past the end
 )
 
 func TestUISection(t *testing.T) {
@@ -61,6 +65,9 @@ func TestNewSection(t *testing.T) {
 		{"tinySec", tinySec, 0, ""},
 		{"smallSec", smallSec, 0, ""},
 		{"linuxSec", linuxSec, 0, ""},
+		{"headerSizeSec", headerSizeSec, 0, "section size 2 is smaller than its 4 bytes header"},
+		{"dataOffsetSec", dataOffsetSec, 0,
+			"GUID defined section data offset 0xffff is out of range, section size is 0x18"},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
@@ -200,3 +207,101 @@ func TestParseDepEx(t *testing.T) {
 		})
 	}
 }
+
+func TestFreeformSubtypeGUIDSection(t *testing.T) {
+	subtype := uuid.MustParse("7BB28B99-61BB-11D5-9A5D-0090273FC14D")
+	newSubtype := uuid.MustParse("0D1ED2F7-E92B-4562-92DD-5C82EC917EAB")
+	payload := []byte("logo goes here")
+	buf := append([]byte{byte(4 + 16 + len(payload)), 0, 0, byte(SectionTypeFreeformSubtypeGUID)}, subtype[:]...)
+	buf = append(buf, payload...)
+
+	s, err := NewSection(buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.TypeSpecific == nil {
+		t.Fatal("no type specific header")
+	}
+	ff, ok := s.TypeSpecific.Header.(*SectionFreeformSubtypeGUID)
+	if !ok {
+		t.Fatalf("wrong type specific header type %T", s.TypeSpecific.Header)
+	}
+	if ff.SubTypeGUID != *subtype {
+		t.Errorf("expected subtype GUID %v, got %v", subtype, ff.SubTypeGUID)
+	}
+
+	// Change the subtype and rebuild the section from the payload.
+	ff.SubTypeGUID = *newSubtype
+	s.SetBuf(payload)
+	if err := s.GenSecHeader(); err != nil {
+		t.Fatal(err)
+	}
+	ns, err := NewSection(s.Buf(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g := ns.TypeSpecific.Header.(*SectionFreeformSubtypeGUID).SubTypeGUID; g != *newSubtype {
+		t.Errorf("expected subtype GUID %v after rebuilding, got %v", newSubtype, g)
+	}
+	if !reflect.DeepEqual(ns.Buf()[4+16:], payload) {
+		t.Errorf("payload mismatch, got %q", ns.Buf()[4+16:])
+	}
+}
+
+// guidedSec returns a GUID defined section with the given attributes and
+// data, and extra bytes of GUID specific header data.
+func guidedSec(attr GUIDEDSectionAttribute, extra int, data []byte) []byte {
+	dataOffset := 24 + extra
+	size := dataOffset + len(data)
+	buf := []byte{byte(size), byte(size >> 8), 0, byte(SectionTypeGUIDDefined)}
+	buf = append(buf, FFGUID[:]...)
+	buf = append(buf, byte(dataOffset), 0, byte(attr), 0)
+	buf = append(buf, make([]byte, extra)...)
+	return append(buf, data...)
+}
+
+func TestGUIDDefinedSection(t *testing.T) {
+	var tests = []struct {
+		name   string
+		buf    []byte
+		encaps int
+	}{
+		{"direct", guidedSec(GUIDEDSectionAuthStatusValid, 0, linuxSec), 1},
+		{"guidData", guidedSec(GUIDEDSectionAuthStatusValid, 4, linuxSec), 0},
+		{"unknownProcessing", guidedSec(GUIDEDSectionProcessingRequired, 0, linuxSec), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, err := NewSection(test.buf, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(s.Encapsulated) != test.encaps {
+				t.Fatalf("got %d encapsulated sections, want %d", len(s.Encapsulated), test.encaps)
+			}
+			if test.encaps != 0 {
+				if ui, ok := s.Encapsulated[0].Value.(*Section); !ok || ui.Name != "Linux" {
+					t.Errorf("encapsulated UI section not parsed, got %v", s.Encapsulated[0].Value)
+				}
+			}
+		})
+	}
+}
+
+func TestGUIDEDSectionAttributeJSON(t *testing.T) {
+	attr := GUIDEDSectionAuthStatusValid | 0x10
+	b, err := json.Marshal(attr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"PROCESSING_REQUIRED":false,"AUTH_STATUS_VALID":true,"Reserved":16}`; string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+	var a GUIDEDSectionAttribute
+	if err := json.Unmarshal(b, &a); err != nil || a != attr {
+		t.Errorf("attributes changed from %#x to %#x: %v", attr, a, err)
+	}
+	if err := json.Unmarshal([]byte(`1`), &a); err != nil || a != GUIDEDSectionProcessingRequired {
+		t.Errorf("numeric attributes not accepted, got %#x: %v", a, err)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// SignatureListHeaderSize is the size of the EFI_SIGNATURE_LIST header.
+const SignatureListHeaderSize = 28
+
+// Secure boot variable vendor GUIDs
+var (
+	GlobalVariableGUID        = uuid.MustParse("8BE4DF61-93CA-11D2-AA0D-00E098032B8C")
+	ImageSecurityDatabaseGUID = uuid.MustParse("D719B2CB-3D3A-4596-A3BC-DAD00E67656F")
+)
+
+// Signature types
+var (
+	CertX509GUID    = uuid.MustParse("A5C059A1-94E4-4AA7-87B5-AB155C2BF072")
+	CertSHA256GUID  = uuid.MustParse("C1C41626-504C-4092-ACA9-41F936934328")
+	CertRSA2048GUID = uuid.MustParse("3C5766E8-269C-4E34-AA14-ED776E85B3B6")
+	CertSHA1GUID    = uuid.MustParse("826CA512-CF10-4AC9-B187-BE01496631BD")
+)
+
+// SignatureTypeNames maps signature type GUIDs to their names.
+var SignatureTypeNames = map[uuid.UUID]string{
+	*CertX509GUID:    "EFI_CERT_X509",
+	*CertSHA256GUID:  "EFI_CERT_SHA256",
+	*CertRSA2048GUID: "EFI_CERT_RSA2048",
+	*CertSHA1GUID:    "EFI_CERT_SHA1",
+}
+
+// signatureDatabases lists the variables holding signature lists.
+var signatureDatabases = map[uuid.UUID][]string{
+	*GlobalVariableGUID:        {"PK", "KEK", "PKDefault", "KEKDefault", "dbDefault", "dbxDefault"},
+	*ImageSecurityDatabaseGUID: {"db", "dbx", "dbt", "dbr"},
+}
+
+// IsSignatureDatabase returns whether the variable holds signature lists.
+func IsSignatureDatabase(vendor uuid.UUID, name string) bool {
+	for _, n := range signatureDatabases[vendor] {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SignatureListHeader represents an EFI_SIGNATURE_LIST header.
+type SignatureListHeader struct {
+	SignatureType       uuid.UUID
+	SignatureListSize   uint32
+	SignatureHeaderSize uint32
+	SignatureSize       uint32
+}
+
+// Signature represents an EFI_SIGNATURE_DATA entry.
+type Signature struct {
+	Owner uuid.UUID
+	// For certificates, these are filled in from the parsed certificate.
+	Subject string `json:",omitempty"`
+	Issuer  string `json:",omitempty"`
+	// For hashes, this is the hex encoded hash.
+	Hash string `json:",omitempty"`
+
+	Data []byte `json:"-"`
+}
+
+// SignatureList represents an EFI_SIGNATURE_LIST.
+type SignatureList struct {
+	Header     SignatureListHeader
+	Type       string
+	Signatures []*Signature
+}
+
+// Certificate parses the signature data as an X.509 certificate.
+func (s *Signature) Certificate() (*x509.Certificate, error) {
+	return x509.ParseCertificate(s.Data)
+}
+
+// ParseSignatureLists parses the concatenated signature lists found in a
+// secure boot variable.
+func ParseSignatureLists(buf []byte) ([]*SignatureList, error) {
+	var lists []*SignatureList
+	for offset := uint64(0); offset < uint64(len(buf)); {
+		if uint64(len(buf))-offset < SignatureListHeaderSize {
+			return nil, fmt.Errorf("signature list at offset %#x truncated, only %#x bytes left",
+				offset, uint64(len(buf))-offset)
+		}
+		sl := SignatureList{}
+		if err := binary.Read(bytes.NewReader(buf[offset:]), binary.LittleEndian, &sl.Header); err != nil {
+			return nil, err
+		}
+		h := sl.Header
+		listEnd := offset + uint64(h.SignatureListSize)
+		dataStart := offset + SignatureListHeaderSize + uint64(h.SignatureHeaderSize)
+		if listEnd > uint64(len(buf)) || dataStart > listEnd {
+			return nil, fmt.Errorf("signature list at offset %#x has invalid size %#x", offset, h.SignatureListSize)
+		}
+		// Each entry is an owner GUID followed by the signature.
+		if h.SignatureSize <= 16 || (listEnd-dataStart)%uint64(h.SignatureSize) != 0 {
+			return nil, fmt.Errorf("signature list at offset %#x has invalid signature size %#x", offset, h.SignatureSize)
+		}
+		if name, ok := SignatureTypeNames[h.SignatureType]; ok {
+			sl.Type = name
+		} else {
+			sl.Type = h.SignatureType.String()
+		}
+		for s := dataStart; s < listEnd; s += uint64(h.SignatureSize) {
+			sig := Signature{Data: buf[s+16 : s+uint64(h.SignatureSize)]}
+			copy(sig.Owner[:], buf[s:s+16])
+			if h.SignatureType == *CertX509GUID {
+				if cert, err := sig.Certificate(); err == nil {
+					sig.Subject = cert.Subject.String()
+					sig.Issuer = cert.Issuer.String()
+				}
+			} else if h.SignatureType != *CertRSA2048GUID {
+				sig.Hash = hex.EncodeToString(sig.Data)
+			}
+			sl.Signatures = append(sl.Signatures, &sig)
+		}
+		lists = append(lists, &sl)
+		offset = listEnd
+	}
+	return lists, nil
+}
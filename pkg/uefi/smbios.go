@@ -0,0 +1,209 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The SMBIOS drivers install default structures for the BIOS, the system,
+// the baseboard and the chassis, which hold the strings shown by dmidecode.
+// They are stored in the data of the drivers or in raw files and sections,
+// each one followed by its string set: NUL terminated strings, ended by
+// another NUL.
+
+// SMBIOS structure types with known string fields.
+const (
+	SMBIOSTypeBIOS      = 0
+	SMBIOSTypeSystem    = 1
+	SMBIOSTypeBaseboard = 2
+	SMBIOSTypeChassis   = 3
+)
+
+// smbiosType describes the string fields of a structure type.
+type smbiosType struct {
+	name string
+	// minLength is the length of the formatted area in SMBIOS 2.0.
+	minLength uint8
+	// fields are the offsets of the string fields.
+	fields map[string]uint8
+}
+
+var smbiosTypes = map[uint8]smbiosType{
+	SMBIOSTypeBIOS: {"BIOS", 0x12, map[string]uint8{
+		"Vendor":      0x04,
+		"Version":     0x05,
+		"ReleaseDate": 0x08,
+	}},
+	SMBIOSTypeSystem: {"System", 0x08, map[string]uint8{
+		"Manufacturer": 0x04,
+		"ProductName":  0x05,
+		"Version":      0x06,
+		"SerialNumber": 0x07,
+		"SKUNumber":    0x19,
+		"Family":       0x1a,
+	}},
+	SMBIOSTypeBaseboard: {"Baseboard", 0x08, map[string]uint8{
+		"Manufacturer":      0x04,
+		"Product":           0x05,
+		"Version":           0x06,
+		"SerialNumber":      0x07,
+		"AssetTag":          0x08,
+		"LocationInChassis": 0x0a,
+	}},
+	SMBIOSTypeChassis: {"Chassis", 0x09, map[string]uint8{
+		"Manufacturer": 0x04,
+		"Version":      0x06,
+		"SerialNumber": 0x07,
+		"AssetTag":     0x08,
+	}},
+}
+
+// SMBIOSStructure is an SMBIOS structure with its string set.
+type SMBIOSStructure struct {
+	Type     uint8
+	TypeName string
+	Handle   uint16
+	// Length of the formatted area.
+	Length uint8
+	// Offset of the structure in the buffer it was found in.
+	Offset uint64
+	// Fields are the string fields which are set, by name.
+	Fields map[string]string `json:",omitempty"`
+
+	buf []byte
+	// strings are the offsets of the strings in buf, the first string is
+	// number 1.
+	strings []int
+}
+
+// Buf returns the structure, including its string set.
+func (s *SMBIOSStructure) Buf() []byte {
+	return s.buf
+}
+
+// NewSMBIOSStructure parses a structure of a known type, buf may be longer
+// than the structure.
+func NewSMBIOSStructure(buf []byte) (*SMBIOSStructure, error) {
+	if len(buf) < 4 {
+		return nil, fmt.Errorf("SMBIOS structure header of %#x bytes is too short", len(buf))
+	}
+	s := &SMBIOSStructure{Type: buf[0], Length: buf[1], Handle: uint16(buf[2]) | uint16(buf[3])<<8}
+	t, ok := smbiosTypes[s.Type]
+	if !ok {
+		return nil, fmt.Errorf("unknown SMBIOS structure type %d", s.Type)
+	}
+	s.TypeName = t.name
+	if s.Length < t.minLength || int(s.Length)+2 > len(buf) {
+		return nil, fmt.Errorf("SMBIOS %v structure of %#x bytes is out of bounds", t.name, s.Length)
+	}
+
+	// The string set holds printable strings and ends with another NUL.
+	end := int(s.Length)
+	for buf[end] != 0 {
+		n := bytes.IndexByte(buf[end:], 0)
+		if n < 0 || end+n+1 >= len(buf) {
+			return nil, fmt.Errorf("SMBIOS %v structure has unterminated strings", t.name)
+		}
+		for _, c := range buf[end : end+n] {
+			if c < 0x20 || c > 0x7e {
+				return nil, fmt.Errorf("SMBIOS %v structure has a string with the byte %#02x", t.name, c)
+			}
+		}
+		s.strings = append(s.strings, end)
+		end += n + 1
+	}
+	end++
+	s.buf = buf[:end]
+
+	// The default structures have a vendor or manufacturer, at offset 4,
+	// and only the strings of their fields, which tells them from other
+	// data.
+	if buf[4] == 0 {
+		return nil, fmt.Errorf("SMBIOS %v structure has no vendor or manufacturer", t.name)
+	}
+	s.Fields = make(map[string]string)
+	referenced := make(map[int]bool)
+	for name, offset := range t.fields {
+		if offset >= s.Length || buf[offset] == 0 {
+			continue
+		}
+		n := int(buf[offset])
+		if n > len(s.strings) {
+			return nil, fmt.Errorf("SMBIOS %v structure field %v refers to string %d of %d", t.name, name, n, len(s.strings))
+		}
+		s.Fields[name] = s.str(n)
+		referenced[n] = true
+	}
+	if len(s.strings) == 0 || len(referenced) != len(s.strings) {
+		return nil, fmt.Errorf("SMBIOS %v structure has %d strings, %d of them in fields", t.name, len(s.strings), len(referenced))
+	}
+	return s, nil
+}
+
+// str returns string n.
+func (s *SMBIOSStructure) str(n int) string {
+	b := s.buf[s.strings[n-1]:]
+	return string(b[:bytes.IndexByte(b, 0)])
+}
+
+// FieldNames returns the names of the string fields of the type, sorted.
+func (s *SMBIOSStructure) FieldNames() []string {
+	var names []string
+	for name := range smbiosTypes[s.Type].fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FindSMBIOSStructures returns the structures of known types in buf.
+func FindSMBIOSStructures(buf []byte) []*SMBIOSStructure {
+	var structures []*SMBIOSStructure
+	for i := 0; i+4 <= len(buf); i++ {
+		if _, ok := smbiosTypes[buf[i]]; !ok {
+			continue
+		}
+		if s, err := NewSMBIOSStructure(buf[i:]); err == nil {
+			s.Offset = uint64(i)
+			structures = append(structures, s)
+			i += len(s.buf) - 1
+		}
+	}
+	return structures
+}
+
+// SetField sets a string field. The structure keeps its size so it can be
+// changed in place: the value may not be longer than the current string, a
+// shorter value is padded with spaces. Fields sharing the string change too.
+func (s *SMBIOSStructure) SetField(name, value string) error {
+	offset, ok := smbiosTypes[s.Type].fields[name]
+	if !ok {
+		return fmt.Errorf("SMBIOS %v structure has no field %v, expected one of %v", s.TypeName, name, strings.Join(s.FieldNames(), ", "))
+	}
+	if offset >= s.Length || s.buf[offset] == 0 {
+		return fmt.Errorf("SMBIOS %v structure field %v is not set, there is no string to change", s.TypeName, name)
+	}
+	n := int(s.buf[offset])
+	old := s.str(n)
+	if len(value) > len(old) {
+		return fmt.Errorf("SMBIOS %v %v %q has %d bytes, %q does not fit", s.TypeName, name, old, len(old), value)
+	}
+	for _, c := range []byte(value) {
+		if c < 0x20 || c > 0x7e {
+			return fmt.Errorf("SMBIOS strings must be printable ASCII, %q is not", value)
+		}
+	}
+	copy(s.buf[s.strings[n-1]:], value+strings.Repeat(" ", len(old)-len(value)))
+	for name, offset := range smbiosTypes[s.Type].fields {
+		if offset < s.Length && int(s.buf[offset]) == n {
+			s.Fields[name] = s.str(n)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package uefi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// smbiosStructure builds a structure with a formatted area of length bytes,
+// the string fields at the offsets and the strings.
+func smbiosStructure(typ, length uint8, fields map[uint8]uint8, strs ...string) []byte {
+	b := make([]byte, length)
+	b[0], b[1], b[2] = typ, length, 0x10
+	for offset, n := range fields {
+		b[offset] = n
+	}
+	for _, s := range strs {
+		b = append(b, s...)
+		b = append(b, 0)
+	}
+	return append(b, 0)
+}
+
+func TestFindSMBIOSStructures(t *testing.T) {
+	system := smbiosStructure(SMBIOSTypeSystem, 0x1b, map[uint8]uint8{4: 1, 5: 2, 7: 3, 0x1a: 2}, "LinuxBoot", "Fiano", "0123456789")
+	board := smbiosStructure(SMBIOSTypeBaseboard, 0x0f, map[uint8]uint8{4: 1, 5: 2}, "LinuxBoot", "Board")
+	// Strings which are not in fields, no manufacturer, and unknown types
+	// are not default structures.
+	extra := smbiosStructure(SMBIOSTypeChassis, 0x15, map[uint8]uint8{4: 1}, "LinuxBoot", "Other")
+	noVendor := smbiosStructure(SMBIOSTypeBIOS, 0x18, map[uint8]uint8{5: 1}, "1.0")
+	oem := smbiosStructure(0x80, 0x08, map[uint8]uint8{4: 1}, "OEM")
+	buf := bytes.Join([][]byte{{0xff}, system, extra, noVendor, oem, board}, nil)
+
+	structures := FindSMBIOSStructures(buf)
+	if len(structures) != 2 {
+		t.Fatalf("expected 2 structures, got %d", len(structures))
+	}
+	s := structures[0]
+	want := map[string]string{"Manufacturer": "LinuxBoot", "ProductName": "Fiano", "SerialNumber": "0123456789", "Family": "Fiano"}
+	if s.TypeName != "System" || s.Handle != 0x10 || s.Offset != 1 || !reflect.DeepEqual(s.Fields, want) || !bytes.Equal(s.Buf(), system) {
+		t.Errorf("unexpected system structure %+v", s)
+	}
+	if s := structures[1]; s.TypeName != "Baseboard" || s.Offset != uint64(len(buf)-len(board)) || s.Fields["Product"] != "Board" {
+		t.Errorf("unexpected baseboard structure %+v", s)
+	}
+}
+
+func TestSMBIOSSetField(t *testing.T) {
+	buf := smbiosStructure(SMBIOSTypeSystem, 0x1b, map[uint8]uint8{4: 1, 5: 2, 7: 3, 0x1a: 2}, "LinuxBoot", "Fiano", "0123456789")
+	s, err := NewSMBIOSStructure(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range map[string]string{
+		"SerialNumber": "01234567890",
+		"Version":      "1",
+		"Product":      "Fiano",
+		"Manufacturer": "Linux\x01",
+	} {
+		if err := s.SetField(name, value); err == nil {
+			t.Errorf("%v: expected an error", name)
+		}
+	}
+
+	if err := s.SetField("ProductName", "utk"); err != nil {
+		t.Fatal(err)
+	}
+	if s.Fields["ProductName"] != "utk  " || s.Fields["Family"] != "utk  " {
+		t.Errorf("expected the product name and family to change, got %v", s.Fields)
+	}
+	// The size does not change, the structure is parsed the same.
+	n, err := NewSMBIOSStructure(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(n.Buf()) != len(s.Buf()) || !reflect.DeepEqual(n.Fields, s.Fields) {
+		t.Errorf("expected %v, got %v", s.Fields, n.Fields)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -83,58 +84,204 @@ func (f *TypedFirmware) UnmarshalJSON(b []byte) error {
 type marshalFirmware struct {
 	FType           string
 	FirmwareElement json.RawMessage
+	// ExtractHashes holds the SHA-256 of the extracted binaries, by path.
+	ExtractHashes map[string]string `json:",omitempty"`
 }
 
 var firmwareTypes = map[string]func() Firmware{
 	"*uefi.BIOSRegion":      func() Firmware { return &BIOSRegion{} },
 	"*uefi.BIOSPadding":     func() Firmware { return &BIOSPadding{} },
+	"*uefi.Capsule":         func() Firmware { return &Capsule{} },
+	"*uefi.CapsuleFile":     func() Firmware { return &CapsuleFile{} },
 	"*uefi.File":            func() Firmware { return &File{} },
 	"*uefi.FirmwareVolume":  func() Firmware { return &FirmwareVolume{} },
+	"*uefi.ECRegion":        func() Firmware { return &ECRegion{} },
 	"*uefi.FlashDescriptor": func() Firmware { return &FlashDescriptor{} },
 	"*uefi.FlashImage":      func() Firmware { return &FlashImage{} },
+	"*uefi.FMPImage":        func() Firmware { return &FMPImage{} },
 	"*uefi.GBERegion":       func() Firmware { return &GBERegion{} },
+	"*uefi.IFlashFile":      func() Firmware { return &IFlashFile{} },
+	"*uefi.IFlashImage":     func() Firmware { return &IFlashImage{} },
 	"*uefi.MERegion":        func() Firmware { return &MERegion{} },
 	"*uefi.PDRegion":        func() Firmware { return &PDRegion{} },
+	"*uefi.RawRegion":       func() Firmware { return &RawRegion{} },
 	"*uefi.Section":         func() Firmware { return &Section{} },
 }
 
+// Location is the position of a node in the flash image. It is computed for
+// the JSON output, so structures can be found in hex dumps, and is ignored
+// when assembling.
+type Location struct {
+	// FlashOffset is the offset from the start of the image. Nodes within
+	// compressed data are not in the flash as such and have none.
+	FlashOffset string `json:",omitempty"`
+	FlashLength string `json:",omitempty"`
+}
+
+// SetLocation records the offset and length of the node.
+func (l *Location) SetLocation(offset uint64, inFlash bool, length uint64) {
+	l.FlashOffset = ""
+	if inFlash {
+		l.FlashOffset = fmt.Sprintf("%#x", offset)
+	}
+	l.FlashLength = fmt.Sprintf("%#x", length)
+}
+
 // MarshalFirmware marshals the firmware element to JSON, including the type information at the top.
 func MarshalFirmware(f Firmware) ([]byte, error) {
+	return MarshalSummary(f, nil)
+}
+
+// MarshalSummary marshals the firmware element like MarshalFirmware, along
+// with the SHA-256 hashes of the extracted binaries by their path.
+func MarshalSummary(f Firmware, hashes map[string]string) ([]byte, error) {
 	b, err := json.MarshalIndent(f, "", "    ")
 	if err != nil {
 		return nil, err
 	}
 
-	m := marshalFirmware{FType: reflect.TypeOf(f).String(), FirmwareElement: json.RawMessage(b)}
+	m := marshalFirmware{FType: reflect.TypeOf(f).String(), FirmwareElement: json.RawMessage(b), ExtractHashes: hashes}
 	return json.MarshalIndent(m, "", "    ")
 }
 
 // UnmarshalFirmware unmarshals the firmware element from JSON, using the type information at the top.
 func UnmarshalFirmware(b []byte) (Firmware, error) {
+	f, _, err := UnmarshalSummary(b)
+	return f, err
+}
+
+// UnmarshalSummary unmarshals the firmware element like UnmarshalFirmware, and
+// returns the hashes of the extracted binaries written by MarshalSummary.
+func UnmarshalSummary(b []byte) (Firmware, map[string]string, error) {
 	var m marshalFirmware
 	if err := json.Unmarshal(b, &m); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	factory, ok := firmwareTypes[m.FType]
 	if !ok {
-		return nil, fmt.Errorf("unknown Firmware type '%s', unable to unmarshal", m.FType)
+		return nil, nil, fmt.Errorf("unknown Firmware type '%s', unable to unmarshal", m.FType)
 	}
 	f := factory()
 	err := json.Unmarshal(m.FirmwareElement, &f)
-	return f, err
+	return f, m.ExtractHashes, err
+}
+
+// Container is the kind of input detected by DetectContainer.
+type Container int
+
+// Containers recognized by Parse.
+const (
+	ContainerUnknown Container = iota
+	// ContainerFlashImage is a full flash image with a flash descriptor.
+	ContainerFlashImage
+	// ContainerBIOSRegion is a bare BIOS region, or any other data holding
+	// FVs, e.g. a vendor update with an unknown header.
+	ContainerBIOSRegion
+	// ContainerFV is a single FV spanning the whole input.
+	ContainerFV
+	// ContainerCapsule is an EFI or vendor update capsule.
+	ContainerCapsule
+	// ContainerCapsuleFile is a capsule-on-disk file holding several
+	// capsules.
+	ContainerCapsuleFile
+	// ContainerIFlashFile is an Insyde H2O update file.
+	ContainerIFlashFile
+)
+
+var containerNames = map[Container]string{
+	ContainerUnknown:     "unknown",
+	ContainerFlashImage:  "flash image",
+	ContainerBIOSRegion:  "BIOS region",
+	ContainerFV:          "FV",
+	ContainerCapsule:     "capsule",
+	ContainerCapsuleFile: "capsule file",
+	ContainerIFlashFile:  "Insyde update file",
+}
+
+func (c Container) String() string {
+	return containerNames[c]
+}
+
+// DetectContainer returns the kind of input in buf from the headers at its
+// start, without parsing it.
+func DetectContainer(buf []byte) Container {
+	if isCapsuleFile(buf) {
+		return ContainerCapsuleFile
+	}
+	if IsCapsule(buf) {
+		return ContainerCapsule
+	}
+	if isIFlashFile(buf) {
+		return ContainerIFlashFile
+	}
+	if len(buf) >= FlashSignatureLength+16 {
+		if _, err := FindSignature(buf); err == nil {
+			return ContainerFlashImage
+		}
+	}
+	if FindFirmwareVolumeOffset(buf) == 0 && len(buf) >= FirmwareVolumeMinSize &&
+		binary.LittleEndian.Uint64(buf[32:]) == uint64(len(buf)) {
+		return ContainerFV
+	}
+	if FindFirmwareVolumeOffset(buf) >= 0 {
+		return ContainerBIOSRegion
+	}
+	return ContainerUnknown
 }
 
 // Parse exposes a high-level parser for generic firmware types. It does not
 // implement any parser itself, but it calls known parsers that implement the
-// Firmware interface.
+// Firmware interface, depending on the container detected in buf.
 func Parse(buf []byte) (Firmware, error) {
-	if _, err := FindSignature(buf); err == nil {
+	switch DetectContainer(buf) {
+	case ContainerCapsuleFile:
+		return NewCapsuleFile(buf)
+	case ContainerCapsule:
+		return NewCapsule(buf)
+	case ContainerIFlashFile:
+		return NewIFlashFile(buf)
+	case ContainerFlashImage:
 		// Intel rom.
 		return NewFlashImage(buf)
+	case ContainerFV:
+		fv, err := NewFirmwareVolume(buf, 0, false)
+		if err != nil {
+			return nil, err
+		}
+		Attributes.ErasePolarity = fv.GetErasePolarity()
+		return fv, nil
+	case ContainerBIOSRegion:
+		// Non intel image such as edk2's OVMF
+		// We don't know how to parse this header, so treat it as a large BIOSRegion
+		return NewBIOSRegion(buf, nil)
 	}
-	// Non intel image such as edk2's OVMF
-	// We don't know how to parse this header, so treat it as a large BIOSRegion
-	return NewBIOSRegion(buf, nil)
+	return nil, fmt.Errorf("unknown image format, no flash descriptor, capsule, Insyde update file or FV found; " +
+		"try scanning it for firmware structures")
+}
+
+// ParseReader reads an image from r and parses it like Parse. The tree refers
+// to the buffer read, so the image is held in memory once. If r tells its
+// size, like *os.File, *bytes.Reader or *io.SectionReader, the buffer is
+// allocated once for it.
+func ParseReader(r io.Reader) (Firmware, error) {
+	var size int64
+	switch r := r.(type) {
+	case interface{ Len() int }:
+		size = int64(r.Len())
+	case interface{ Size() int64 }:
+		size = r.Size()
+	case interface{ Stat() (os.FileInfo, error) }:
+		if fi, err := r.Stat(); err == nil && fi.Mode().IsRegular() {
+			size = fi.Size()
+		}
+	}
+	var buf bytes.Buffer
+	// ReadFrom reads until it is left with less than MinRead bytes of space.
+	buf.Grow(int(size) + bytes.MinRead)
+	if _, err := buf.ReadFrom(r); err != nil {
+		return nil, err
+	}
+	return Parse(buf.Bytes())
 }
 
 // ExtractBinary simply dumps the binary to a specified directory and filename.
@@ -153,7 +300,9 @@ func ExtractBinary(buf []byte, dirPath string, filename string) (string, error)
 		// Make sure we return "" since we don't want an invalid path to be serialized out.
 		return "", err
 	}
-	return fp, nil
+	// The path is serialized with forward slashes so the JSON is the same on
+	// all platforms.
+	return filepath.ToSlash(fp), nil
 }
 
 // Checksum8 does a 8 bit checksum of the slice passed in.
@@ -213,9 +362,19 @@ func Align8(val uint64) uint64 {
 	return Align(val, 8)
 }
 
-// Erase sets the buffer to be ErasePolarity
+// Erase sets the buffer to the erase polarity byte.
 func Erase(buf []byte, polarity byte) {
 	for j, blen := 0, len(buf); j < blen; j++ {
-		buf[j] = Attributes.ErasePolarity
+		buf[j] = polarity
+	}
+}
+
+// IsErased returns whether the buffer only contains erase polarity bytes.
+func IsErased(buf []byte, polarity byte) bool {
+	for _, b := range buf {
+		if b != polarity {
+			return false
+		}
 	}
+	return true
 }
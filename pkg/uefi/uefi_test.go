@@ -5,9 +5,12 @@
 package uefi
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
+	"io"
 	"testing"
+	"testing/iotest"
 )
 
 func TestUnmarshalTypedFirmware(t *testing.T) {
@@ -165,3 +168,31 @@ func TestAlign8(t *testing.T) {
 		}
 	}
 }
+
+func TestParseReader(t *testing.T) {
+	var tests = []struct {
+		name string
+		r    io.Reader
+	}{
+		{"sized", bytes.NewReader(sampleFV)},
+		{"section", io.NewSectionReader(bytes.NewReader(sampleFV), 0, int64(len(sampleFV)))},
+		{"stream", iotest.OneByteReader(bytes.NewReader(sampleFV))},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := ParseReader(test.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := f.(*FirmwareVolume); !ok {
+				t.Fatalf("expected an FV, got %T", f)
+			}
+			if !bytes.Equal(f.Buf(), sampleFV) {
+				t.Error("parsed buffer differs from the input")
+			}
+		})
+	}
+	if _, err := ParseReader(iotest.TimeoutReader(bytes.NewReader(sampleFV))); err == nil {
+		t.Error("expected the read error")
+	}
+}
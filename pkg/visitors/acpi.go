@@ -0,0 +1,225 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var acpiIndex = flag.Int("acpi-index", -1, "index of the table extracted or replaced by extract_acpi and replace_acpi among those with its signature, all by default")
+
+// ACPITableFile is an ACPI table of a raw file or of a raw section.
+type ACPITableFile struct {
+	File  uuid.UUID
+	Name  string `json:",omitempty"`
+	Table *uefi.ACPITable
+
+	file *uefi.File
+}
+
+// FindACPITables returns the ACPI tables with one of the signatures, or all
+// of them, parsed in the raw files and the raw sections, see
+// uefi.ParseACPITables. The tables are slices of the buffers of the image, so
+// they can be replaced in place.
+func FindACPITables(f uefi.Firmware, signatures ...string) ([]*ACPITableFile, error) {
+	files, err := allFiles(f)
+	if err != nil {
+		return nil, err
+	}
+	var tables []*ACPITableFile
+	add := func(file *uefi.File, acpi []*uefi.ACPITable) {
+		for _, t := range acpi {
+			match := len(signatures) == 0
+			for _, sig := range signatures {
+				match = match || t.Signature == sig
+			}
+			if match {
+				tables = append(tables, &ACPITableFile{File: file.Header.UUID, Name: fileName(file), Table: t, file: file})
+			}
+		}
+	}
+	for _, file := range files {
+		add(file, file.ACPI)
+		walkSections(file.Sections, func(s *uefi.Section) {
+			add(file, s.ACPI)
+		})
+	}
+	return tables, nil
+}
+
+// selectACPITable returns the tables with index, all of them if index is
+// negative.
+func selectACPITable(tables []*ACPITableFile, signature string, index int) ([]*ACPITableFile, error) {
+	if len(tables) == 0 {
+		return nil, fmt.Errorf("no %v table found", signature)
+	}
+	if index < 0 {
+		return tables, nil
+	}
+	if index >= len(tables) {
+		return nil, fmt.Errorf("no %v table %d, found %d", signature, index, len(tables))
+	}
+	return tables[index : index+1], nil
+}
+
+// ACPI prints the ACPI tables of the image as JSON.
+type ACPI struct {
+	W io.Writer
+
+	// Output
+	Tables []*ACPITableFile
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ACPI) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ACPI visitor to any Firmware type.
+func (v *ACPI) Visit(f uefi.Firmware) error {
+	var err error
+	if v.Tables, err = FindACPITables(f); err != nil {
+		return err
+	}
+	if len(v.Tables) == 0 {
+		return fmt.Errorf("no ACPI table found")
+	}
+	b, err := json.MarshalIndent(v.Tables, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(v.W, string(b))
+	return err
+}
+
+// ExtractACPI writes the ACPI table with Signature to OutFile. The table may
+// be stored several times, but all copies must be the same, unless one is
+// selected by Index.
+type ExtractACPI struct {
+	// Input
+	Signature string
+	// Index of the table among those with the signature, in the order of
+	// the acpi operation, or -1.
+	Index   int
+	OutFile string
+
+	// Output
+	Table *ACPITableFile
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ExtractACPI) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	return writeFile(v.OutFile, v.Table.Table.Buf(), 0666)
+}
+
+// Visit applies the ExtractACPI visitor to any Firmware type.
+func (v *ExtractACPI) Visit(f uefi.Firmware) error {
+	tables, err := FindACPITables(f, v.Signature)
+	if err != nil {
+		return err
+	}
+	if tables, err = selectACPITable(tables, v.Signature, v.Index); err != nil {
+		return err
+	}
+	for _, t := range tables[1:] {
+		if !bytes.Equal(t.Table.Buf(), tables[0].Table.Buf()) {
+			return fmt.Errorf("the %v tables of files %v and %v differ", v.Signature, tables[0].File, t.File)
+		}
+	}
+	v.Table = tables[0]
+	return nil
+}
+
+// ReplaceACPI replaces all the ACPI tables with the signature of Table, or
+// the one selected by Index. The new table must have the same length, its
+// checksum is fixed if needed. The checksums of the files are updated when
+// the image is assembled.
+type ReplaceACPI struct {
+	// Input
+	Table []byte
+	// Index of the table among those with the signature, in the order of
+	// the acpi operation, or -1.
+	Index int
+
+	// Output
+	Tables []*ACPITableFile
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ReplaceACPI) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ReplaceACPI visitor to any Firmware type.
+func (v *ReplaceACPI) Visit(f uefi.Firmware) error {
+	if len(v.Table) < uefi.ACPITableHeaderSize {
+		return fmt.Errorf("ACPI table of %#x bytes is shorter than its header", len(v.Table))
+	}
+	signature := string(v.Table[:4])
+	var err error
+	if v.Tables, err = FindACPITables(f, signature); err != nil {
+		return err
+	}
+	if v.Tables, err = selectACPITable(v.Tables, signature, v.Index); err != nil {
+		return err
+	}
+	var files []*uefi.File
+	for _, t := range v.Tables {
+		files = append(files, t.file)
+	}
+	if err := checkProtected("replace_acpi", files); err != nil {
+		return err
+	}
+	for _, t := range v.Tables {
+		if err := t.Table.Replace(v.Table); err != nil {
+			return fmt.Errorf("file %v: %v", t.File, err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "acpi",
+		Help: "Print the ACPI tables found in the raw files and the raw sections, like those of the ACPI table storage file, as JSON. The OEM activation tables, SLIC and MSDM, are found anywhere in the raw data.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &ACPI{W: os.Stdout}, nil
+		},
+	})
+	Register(CLI{
+		Name:  "extract_acpi",
+		Args:  []string{"SIGNATURE", "FILE"},
+		Help:  "Write the ACPI table with the given SIGNATURE, e.g. SLIC, to FILE. All copies of the table in the image must be the same, unless one is selected with -acpi-index.",
+		Flags: []string{"acpi-index"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &ExtractACPI{Signature: args[0], Index: *acpiIndex, OutFile: args[1]}, nil
+		},
+	})
+	Register(CLI{
+		Name:  "replace_acpi",
+		Args:  []string{"FILE"},
+		Help:  "Replace all copies of the ACPI table with the signature of the table in FILE, e.g. SLIC, or the one selected with -acpi-index. The tables must have the same length, the checksums of the table and the files are fixed.",
+		Flags: []string{"acpi-index"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			table, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &ReplaceACPI{Table: table, Index: *acpiIndex}, nil
+		},
+	})
+}
@@ -0,0 +1,152 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestACPI(t *testing.T) {
+	var b bytes.Buffer
+	v := &ACPI{W: &b}
+	if err := v.Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	// RamDiskDxe has an SSDT, the others are in the ACPI table storage file.
+	want := []string{"SSDT", "FACP", "FACS", "APIC", "DSDT", "SSDT"}
+	if len(v.Tables) != len(want) {
+		t.Fatalf("expected %d tables, got %d", len(want), len(v.Tables))
+	}
+	for i, tb := range v.Tables {
+		if tb.Table.Signature != want[i] {
+			t.Errorf("table %d: expected %v, got %v", i, want[i], tb.Table.Signature)
+		}
+	}
+	if v.Tables[0].Name != "RamDiskDxe" || v.Tables[1].File != *uefi.ACPITableStorageFileGUID {
+		t.Errorf("unexpected files %v and %v", v.Tables[0].Name, v.Tables[1].File)
+	}
+	var tables []ACPITableFile
+	if err := json.Unmarshal(b.Bytes(), &tables); err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != len(want) || tables[0].Table.OEMTableID != "RamDisk" {
+		t.Errorf("unexpected JSON %s", b.String())
+	}
+}
+
+func TestExtractReplaceACPI(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acpi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "facp.bin")
+
+	f := parseImage(t)
+	e := &ExtractACPI{Signature: "FACP", Index: -1, OutFile: out}
+	if err := e.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if e.Table.File != *uefi.ACPITableStorageFileGUID || e.Table.Table.OEMID != "OVMF" {
+		t.Fatalf("expected the FACP of OVMF in the ACPI table storage file, got %+v", e.Table.Table)
+	}
+	facp, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Change the OEM table ID and leave the checksum to the replacement.
+	copy(facp[16:], "FIANO   ")
+	r := &ReplaceACPI{Table: facp, Index: -1}
+	if err := r.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Tables) != 1 {
+		t.Fatalf("expected 1 table, got %d", len(r.Tables))
+	}
+
+	// The storage file is in a compressed FV, check it after reparsing.
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := uefi.Parse(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables, err := FindACPITables(reparsed, "FACP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) != 1 || tables[0].Table.OEMTableID != "FIANO" {
+		t.Fatalf("expected the FIANO FACP, got %v", tables)
+	}
+	var sum uint8
+	for _, c := range tables[0].Table.Buf() {
+		sum += c
+	}
+	if sum != 0 || bytes.Equal(tables[0].Table.Buf(), facp) {
+		t.Errorf("expected the checksum to be fixed, the bytes add up to %#x", sum)
+	}
+
+	for _, r := range []*ReplaceACPI{
+		{Table: []byte("FACP"), Index: -1},
+		{Table: facp[:uefi.ACPITableHeaderSize], Index: -1},
+		{Table: append([]byte("SLIC"), facp[4:]...), Index: -1},
+		{Table: facp, Index: 1},
+	} {
+		if err := r.Run(reparsed); err == nil {
+			t.Errorf("%q: expected an error", r.Table[:4])
+		}
+	}
+}
+
+func TestExtractACPIIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "acpi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "ssdt.bin")
+
+	// The two SSDTs differ, one has to be selected.
+	f := parseImage(t)
+	for _, index := range []int{-1, 2} {
+		if err := (&ExtractACPI{Signature: "SSDT", Index: index, OutFile: out}).Run(f); err == nil {
+			t.Errorf("%d: expected an error", index)
+		}
+	}
+	e := &ExtractACPI{Signature: "SSDT", Index: 1, OutFile: out}
+	if err := e.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	ssdt, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Table.File != *uefi.ACPITableStorageFileGUID || !bytes.Equal(ssdt, e.Table.Table.Buf()) {
+		t.Errorf("expected the SSDT of the ACPI table storage file, got %+v", e.Table.Table)
+	}
+
+	// Only the selected SSDT is replaced.
+	copy(ssdt[16:], "FIANO   ")
+	r := &ReplaceACPI{Table: ssdt, Index: 1}
+	if err := r.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	tables, err := FindACPITables(f, "SSDT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tables[0].Table.OEMTableID != "RamDisk" || tables[1].Table.OEMTableID != "FIANO" {
+		t.Errorf("expected the RamDisk and FIANO SSDTs, got %v and %v", tables[0].Table.OEMTableID, tables[1].Table.OEMTableID)
+	}
+}
@@ -5,18 +5,105 @@
 package visitors
 
 import (
-	"encoding/binary"
+	"bytes"
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/linuxboot/fiano/pkg/lzma"
 	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var (
+	guidedPassthrough = flag.Bool("guided-passthrough", false,
+		"keep GUID defined sections with an unknown processing GUID as they are, ignoring their encapsulated sections")
+	compact = flag.Bool("compact", false,
+		"drop deleted files, erased pad files and deleted variables when assembling, moving the rest to the start of their FV or store")
+	fvSize = flag.String("fv-size", "",
+		"comma separated list of FV=SIZE, forcing the FVs with the given name GUID or offset in the BIOS region to a size when saving")
+	lzmaBackend = flag.String("lzma-backend", string(lzma.BackendGo),
+		"LZMA implementation encoding the LZMA sections when saving, go or xz")
 )
 
 // Assemble reconstitutes the firmware tree assuming that the leaf node buffers are accurate
 type Assemble struct {
+	// FVSizes forces FVs to a size, see ParseFVSizes. The FVs are padded
+	// with the erase polarity, and assembling fails if the files do not fit.
+	FVSizes map[string]uint64
+	// LZMA encodes the LZMA sections. The zero Coder uses the pure Go
+	// implementation, like the parser.
+	LZMA lzma.Coder
+	// GuidedPassthrough keeps GUID defined sections with an unknown
+	// processing GUID as they are, ignoring their encapsulated sections.
+	GuidedPassthrough bool
+	// Compact drops deleted files, erased pad files and deleted variables,
+	// moving the rest to the start of their FV or store.
+	Compact bool
+
+	// noROMLayoutCheck is set by the visitors which update the AMI ROM
+	// layouts themselves.
+	noROMLayoutCheck bool
+}
+
+// ParseFVSizes parses a comma separated list of FV=SIZE. The FV is either
+// the name GUID of the FV, from its extended header, or its offset in the BIOS
+// region.
+func ParseFVSizes(list string) (map[string]uint64, error) {
+	sizes := make(map[string]uint64)
+	if list == "" {
+		return sizes, nil
+	}
+	for _, item := range strings.Split(list, ",") {
+		kv := strings.SplitN(strings.TrimSpace(item), "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("expected FV=SIZE, got %q", item)
+		}
+		size, err := strconv.ParseUint(kv[1], 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size of FV %v: %v", kv[0], err)
+		}
+		if g, err := uuid.Parse(kv[0]); err == nil {
+			sizes[g.String()] = size
+		} else if offset, err := strconv.ParseUint(kv[0], 0, 64); err == nil {
+			sizes[fmt.Sprintf("%#x", offset)] = size
+		} else {
+			return nil, fmt.Errorf("expected an FV name GUID or offset, got %q", kv[0])
+		}
+	}
+	return sizes, nil
+}
+
+// targetSize returns the size fv is forced to, if any.
+func (v *Assemble) targetSize(fv *uefi.FirmwareVolume) (uint64, bool) {
+	if fv.HasExtHeader() {
+		if size, ok := v.FVSizes[fv.FVName.String()]; ok {
+			return size, true
+		}
+	}
+	size, ok := v.FVSizes[fmt.Sprintf("%#x", fv.FVOffset)]
+	return size, ok
+}
+
+// overBudget returns an error for an FV which needs more than its target
+// size, listing its largest files.
+func overBudget(fv *uefi.FirmwareVolume, need uint64) error {
+	files := append([]*uefi.File{}, fv.Files...)
+	sort.SliceStable(files, func(i, j int) bool { return len(files[i].Buf()) > len(files[j].Buf()) })
+	if len(files) > 5 {
+		files = files[:5]
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "FV %v at %#x needs %#x bytes, %#x over its target size of %#x; largest files:",
+		fv.FVName, fv.FVOffset, need, need-fv.Length, fv.Length)
+	for _, file := range files {
+		fmt.Fprintf(&b, "\n\t%v %-20s %#x", file.Header.UUID, fileName(file), len(file.Buf()))
+	}
+	return errors.New(b.String())
 }
 
 // Run just applies the visitor.
@@ -43,7 +130,33 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 	switch f := f.(type) {
 
 	case *uefi.FirmwareVolume:
-		if len(f.Files) == 0 {
+		// An FV whose files are all dropped still has to be rebuilt.
+		hasFiles := len(f.Files) != 0
+		if v.Compact && hasFiles {
+			f.Files = compactFiles(f.Files)
+		}
+		if !hasFiles {
+			if _, ok := v.targetSize(f); ok {
+				return fmt.Errorf("FV %v at %#x has no files, it cannot be resized", f.FVName, f.FVOffset)
+			}
+			if vs := f.VariableStore; vs != nil {
+				if v.Compact {
+					if err = (&NVRAMGC{}).Run(vs); err != nil {
+						return err
+					}
+				}
+				// Only the variable store is rebuilt, the rest of the volume stays as is.
+				fBuf := f.Buf()
+				end := f.DataOffset + uint64(len(vs.Buf()))
+				if end > uint64(len(fBuf)) {
+					return fmt.Errorf("variable store does not fit into FV, store ends at %#x, FV is %#x bytes",
+						end, len(fBuf))
+				}
+				nb := append([]byte{}, fBuf...)
+				copy(nb[f.DataOffset:end], vs.Buf())
+				f.SetBuf(nb)
+				return f.GenFVHeader()
+			}
 			// No children, buffer should already contain data.
 			return nil
 		}
@@ -59,21 +172,69 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 				f.Length, fBufLen)
 		}
 
+		target, hasTarget := v.targetSize(f)
+		if hasTarget {
+			if err := setFVLength(f, target); err != nil {
+				return err
+			}
+			// Erased pad files only fill the gaps of the old layout, they
+			// are recreated where needed.
+			var kept []*uefi.File
+			for _, file := range f.Files {
+				if !isErasedPad(file) {
+					kept = append(kept, file)
+				}
+			}
+			f.Files = kept
+			// The files may overflow the target, which is reported below.
+			resizable := f.Resizable
+			f.Resizable = true
+			defer func() { f.Resizable = resizable }()
+		}
+
 		fileOffset := f.DataOffset
-		if f.DataOffset != fBufLen {
+		if f.DataOffset > fBufLen {
 			return fmt.Errorf("fv header buffer size mismatch with DataOffset! buflen was %#x, DataOffset was %#x",
 				fBufLen, f.DataOffset)
 		}
+		// A freshly parsed FV still holds the whole volume, keep only the header.
+		// The header is copied since the buffer may be a slice of the parent's buffer.
+		f.SetBuf(append([]byte{}, fBuf[:f.DataOffset]...))
 
-		for _, file := range f.Files {
+		for i, file := range f.Files {
 			fileBuf := file.Buf()
 			fileLen := uint64(len(fileBuf))
 			if fileLen == 0 {
 				log.Fatal(file.Header.UUID)
 			}
+			if (f.FFSVersion == 1 || f.FFSVersion == 2) && file.IsLarge() {
+				return fmt.Errorf("file %v is a large file, which FFS%d volumes cannot hold",
+					file.Header.UUID, f.FFSVersion)
+			}
 
 			// Pad to the 8 byte alignments.
 			alignedOffset := uefi.Align8(fileOffset)
+			if file.IsVTF() {
+				// The VTF must end exactly at the end of the FV, so it is placed there
+				// and the gap is filled with a pad file.
+				if i != len(f.Files)-1 {
+					return fmt.Errorf("volume top file %v is not the last file in the FV, refusing to move it",
+						file.Header.UUID)
+				}
+				vtfOffset, err := placeVTF(f, alignedOffset, fileLen)
+				if err != nil {
+					if hasTarget && alignedOffset+fileLen > f.Length {
+						return overBudget(f, alignedOffset+fileLen)
+					}
+					return err
+				}
+				alignedOffset = vtfOffset
+				if err = f.InsertFile(alignedOffset, fileBuf); err != nil {
+					return fmt.Errorf("File %s: %v", file.Header.UUID, err)
+				}
+				fileOffset = alignedOffset + fileLen
+				continue
+			}
 			// Read out the file alignment requirements
 			if alignBase := file.Header.Attributes.GetAlignment(); alignBase != 1 {
 				hl := file.HeaderLen()
@@ -106,6 +267,9 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 		}
 
 		newFVLen := uint64(len(f.Buf()))
+		if hasTarget && f.Length < newFVLen {
+			return overBudget(f, newFVLen)
+		}
 		if f.Length < newFVLen {
 			// We've expanded the FV, resize
 			if f.Blocks[0].Size == 0 {
@@ -126,27 +290,21 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			f.SetBuf(append(f.Buf(), emptyBuf...))
 		}
 
-		fBuf = f.Buf()
+		f.SetUsedSize(fileOffset)
 
-		// Write the length to the correct spot
-		// TODO: handle the whole header instead of doing this
-		binary.LittleEndian.PutUint64(fBuf[32:], f.Length)
-
-		// Write the block map count
-		binary.LittleEndian.PutUint32(fBuf[56:], f.Blocks[0].Count)
-		// Checksum the header again
-		// TODO: handle the whole header instead of doing this
-		// First we zero out the original checksum
-		binary.LittleEndian.PutUint16(fBuf[50:], 0)
-		sum, err := uefi.Checksum16(fBuf[:f.HeaderLen])
-		if err != nil {
+		// Rebuild the header from the JSON so changes to its fields are respected.
+		if err := f.GenFVHeader(); err != nil {
 			return err
 		}
-		newSum := 0 - sum
-		binary.LittleEndian.PutUint16(fBuf[50:], newSum)
-
-		// Save the buffer
-		f.SetBuf(fBuf)
+		if !f.AppleCRC32 {
+			return nil
+		}
+		// Apple volumes carry a CRC32 of the body which has to be kept up to
+		// date. The body starts with the extended header and its entries, so
+		// it is computed once they are written, and the header checksum is
+		// computed again for the new CRC32.
+		f.FixAppleCRC32(fileOffset)
+		return f.GenFVHeader()
 
 	case *uefi.File:
 		fh := &f.Header
@@ -159,13 +317,17 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			// the file header.
 
 			// Set state to valid based on erase polarity
-			// We really should redo the whole header
-			// TODO: Reconstruct header from JSON
-			fh.State = 0x07 ^ uefi.Attributes.ErasePolarity
+			fh.State = f.StateByte(uefi.Attributes.ErasePolarity)
 			fBuf = f.Buf()
-			fBuf[0x17] = fh.State
-			f.SetBuf(fBuf)
-			return nil
+			if uint64(len(fBuf)) < f.DataOffset {
+				return fmt.Errorf("file %v is %#x bytes, smaller than its header", fh.UUID, len(fBuf))
+			}
+			// Rewrite the header from the JSON so GUID changes are respected.
+			// The header buffer is rebuilt and the data copied, since the buffer
+			// may be a slice of the parent's buffer. The size is not part of the
+			// JSON, take it from the buffer.
+			f.SetSize(uint64(len(fBuf)), false)
+			return f.ChecksumAndAssemble(append([]byte{}, f.Data()...))
 		}
 
 		// Otherwise, we reconstruct the entire file from the sections and the
@@ -192,10 +354,14 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			fileData = append(fileData, sData...)
 		}
 
-		f.SetSize(uefi.FileHeaderMinLength+dLen, true)
+		tail := uint64(0)
+		if f.HasTail() {
+			tail = uefi.FileTailLength
+		}
+		f.SetSize(uefi.FileHeaderMinLength+dLen+tail, true)
 
 		// Set state to valid based on erase polarity
-		fh.State = 0x07 ^ uefi.Attributes.ErasePolarity
+		fh.State = f.StateByte(uefi.Attributes.ErasePolarity)
 
 		if err = f.ChecksumAndAssemble(fileData); err != nil {
 			return err
@@ -207,6 +373,14 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			// No children, buffer should already contain data.
 			return nil
 		}
+		if v.GuidedPassthrough && isUnknownGUIDed(f) {
+			// The original processed data is kept, the encapsulated
+			// sections cannot be encoded anyway.
+			if len(f.Buf()) == 0 {
+				return fmt.Errorf("no original data to pass through for guid defined section %v", f)
+			}
+			return nil
+		}
 
 		// Construct the section data
 		secData := []byte{}
@@ -227,22 +401,21 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 		switch f.Header.Type {
 		case uefi.SectionTypeGUIDDefined:
 			ts := f.TypeSpecific.Header.(*uefi.SectionGUIDDefined)
-			if ts.Attributes&uint16(uefi.GUIDEDSectionProcessingRequired) != 0 {
-				var fBuf []byte
-				switch ts.GUID {
-				case uefi.LZMAGUID:
-					fBuf, err = lzma.Encode(secData)
-					f.SetBuf(fBuf)
-					if err != nil {
-						return err
-					}
-				case uefi.LZMAX86GUID:
-					fBuf, err = lzma.EncodeX86(secData)
-					if err != nil {
-						return err
-					}
-				default:
-					return fmt.Errorf("unknown guid defined from section %v, should not have encapsulated sections", f)
+			if ts.Attributes&uefi.GUIDEDSectionProcessingRequired == 0 {
+				// The encapsulated sections are stored as they are.
+				f.SetBuf(secData)
+			} else {
+				c := uefi.CompressorFromGUID(ts.GUID)
+				if lc := uefi.LZMACompressor(ts.GUID, v.LZMA); lc != nil {
+					c = lc
+				}
+				if c == nil {
+					return fmt.Errorf("unknown guid defined from section %v, should not have encapsulated sections, "+
+						"use --guided-passthrough to keep the original data", f)
+				}
+				fBuf, err := c.Encode(secData)
+				if err != nil {
+					return err
 				}
 				f.SetBuf(fBuf)
 			}
@@ -256,7 +429,36 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 	case *uefi.FlashDescriptor:
 		err = f.ParseFlashDescriptor()
 
+	case *uefi.CapsuleFile:
+		f.AssembleCapsules()
+
+	case *uefi.Capsule:
+		if f.Payload != nil {
+			f.SetPayloadBuf(f.Payload.Value.Buf())
+		}
+		f.AssembleFMP()
+
+	case *uefi.FMPImage:
+		if f.Payload != nil {
+			f.SetPayloadBuf(f.Payload.Value.Buf())
+		}
+
+	case *uefi.IFlashFile:
+		f.AssembleImages()
+
+	case *uefi.IFlashImage:
+		if f.Payload != nil {
+			f.SetPayloadBuf(f.Payload.Value.Buf())
+		}
+
 	case *uefi.BIOSRegion:
+		// The FV holding the VTF has to stay at the top of the region.
+		for i, e := range f.Elements {
+			if fv, ok := e.Value.(*uefi.FirmwareVolume); ok && fv.HasVTF() && i != len(f.Elements)-1 {
+				return fmt.Errorf("FV at offset %#x contains the volume top file but is not at the top of the BIOS region",
+					fv.FVOffset)
+			}
+		}
 		fBuf := make([]byte, f.Length)
 		firstFV, err := f.FirstFV()
 		if err != nil {
@@ -264,19 +466,37 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 		}
 		uefi.Attributes.ErasePolarity = firstFV.GetErasePolarity()
 		uefi.Erase(fBuf, uefi.Attributes.ErasePolarity)
-		// Put the elements together
-		offset := uint64(0)
+		// Recompute the offsets in case elements changed size.
+		if err = layoutBIOSRegion(f); err != nil {
+			return err
+		}
+		// Put the elements together.
+		// Every element goes back to the offset it was found at, so padding and
+		// vendor data in between the FVs is preserved byte-for-byte.
+		var end uint64
 		for _, e := range f.Elements {
-			// copy the fv over the original
-			// TODO: handle different sizes.
-			// We'll have to FF out the new regions/ check for clashes
+			offset, err := uefi.ElementOffset(e.Value)
+			if err != nil {
+				return err
+			}
+			if offset < end {
+				return fmt.Errorf("%s at offset %#x overlaps the previous element which ends at %#x",
+					e.Type, offset, end)
+			}
 			ebuf := e.Value.Buf()
-			copy(fBuf[offset:offset+uint64(len(ebuf))], ebuf)
-			offset += uint64(len(ebuf))
+			end = offset + uint64(len(ebuf))
+			if end > f.Length {
+				return fmt.Errorf("%s at offset %#x ends at %#x, past the end of the %#x bytes BIOS region",
+					e.Type, offset, end, f.Length)
+			}
+			copy(fBuf[offset:end], ebuf)
 		}
 		// Set the buffer
 		f.SetBuf(fBuf)
 
+		if !v.noROMLayoutCheck {
+			checkROMLayouts(f)
+		}
 		return nil
 
 	case *uefi.FlashImage:
@@ -334,14 +554,60 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 			regions = append(regions, region{f.PD.Position, pdbuf})
 		}
 
+		// EC region
+		if f.IFD.Region.EC.Valid() {
+			if f.EC == nil {
+				// Not in JSON, error out since we don't have an ExtractPath.
+				return errors.New("no EC region unmarshalled from JSON, but EC region is present in IFD")
+			}
+			f.EC.Position = &f.IFD.Region.EC
+			ecbuf := f.EC.Buf()
+			regions = append(regions, region{f.EC.Position, ecbuf})
+		}
+
+		// Other regions
+		raw := make(map[int]*uefi.RawRegion)
+		for _, r := range f.Regions {
+			raw[r.Index] = r
+		}
+		for i, name := range uefi.FlashRegionNames {
+			p := f.IFD.Region.Region(i)
+			if i == uefi.RegionBIOS || i == uefi.RegionME || i == uefi.RegionGBE || i == uefi.RegionPD || i == uefi.RegionEC {
+				continue
+			}
+			r, ok := raw[i]
+			if p == nil || !p.Valid() {
+				if ok {
+					return fmt.Errorf("region %d (%v) in JSON, but not present in IFD", i, name)
+				}
+				continue
+			}
+			if !ok {
+				return fmt.Errorf("no region %d (%v) unmarshalled from JSON, but it is present in IFD", i, name)
+			}
+			r.Position = p
+			regions = append(regions, region{r.Position, r.Buf()})
+		}
+
 		// Sort regions so we can output the flash file correctly.
 		sort.Slice(regions, func(i, j int) bool { return regions[i].P.Base < regions[j].P.Base })
-		// append all slices together and return.
+		// The reset vector lives in the BIOS region, so it must be mapped at the top of flash.
+		if regions[len(regions)-1].P != f.BIOS.Position && biosHasVTF(f.BIOS) {
+			return errors.New("BIOS region contains the volume top file but is not the last region in flash")
+		}
+		// append all slices together and return. Space not covered by any
+		// region, e.g. that of a removed one, is erased.
 		fBuf := make([]byte, 0, 0)
 		fBuf = append(fBuf, ifdbuf...)
 		for _, r := range regions {
+			if gap := int(r.P.BaseOffset()) - len(fBuf); gap > 0 {
+				fBuf = append(fBuf, bytes.Repeat([]byte{0xff}, gap)...)
+			}
 			fBuf = append(fBuf, r.buf...)
 		}
+		if gap := len(f.Buf()) - len(fBuf); gap > 0 {
+			fBuf = append(fBuf, bytes.Repeat([]byte{0xff}, gap)...)
+		}
 
 		f.SetBuf(fBuf)
 		return nil
@@ -351,3 +617,89 @@ func (v *Assemble) Visit(f uefi.Firmware) error {
 	return err
 
 }
+
+// compactFiles drops the deleted files and the erased pad files, the way a
+// fault tolerant write reclaim would. The remaining files are laid out back
+// to back, pad files are only added back where alignment requires them.
+func compactFiles(files []*uefi.File) []*uefi.File {
+	var kept []*uefi.File
+	for _, file := range files {
+		if file.IsDeleted() {
+			log.Printf("compact: dropping %v file %v", file.State, file.Header.UUID)
+			continue
+		}
+		if isErasedPad(file) {
+			continue
+		}
+		kept = append(kept, file)
+	}
+	return kept
+}
+
+// setFVLength sets the length of fv and its block count, the length has to
+// be a multiple of the block size.
+func setFVLength(fv *uefi.FirmwareVolume, length uint64) error {
+	if len(fv.Blocks) == 0 || fv.Blocks[0].Size == 0 {
+		return fmt.Errorf("FV %v at %#x has no block size, it cannot be resized", fv.FVName, fv.FVOffset)
+	}
+	if bs := uint64(fv.Blocks[0].Size); length%bs != 0 || length < fv.DataOffset {
+		return fmt.Errorf("target size %#x of FV %v at %#x is not a multiple of its %#x bytes blocks or smaller than its header",
+			length, fv.FVName, fv.FVOffset, bs)
+	}
+	fv.Length = length
+	fv.Blocks[0].Count = uint32(length / uint64(fv.Blocks[0].Size))
+	return nil
+}
+
+// placeVTF returns the offset at which the volume top file has to be inserted
+// so that it ends exactly at the end of the FV. If there is a gap between the
+// end of the previous file and the VTF, a pad file is inserted to fill it.
+func placeVTF(fv *uefi.FirmwareVolume, offset uint64, vtfLen uint64) (uint64, error) {
+	if vtfLen > fv.Length {
+		return 0, fmt.Errorf("volume top file is %#x bytes, larger than the %#x bytes FV", vtfLen, fv.Length)
+	}
+	vtfOffset := fv.Length - vtfLen
+	if vtfOffset%8 != 0 {
+		return 0, fmt.Errorf("volume top file would start at unaligned offset %#x in the FV", vtfOffset)
+	}
+	if offset > vtfOffset {
+		return 0, fmt.Errorf("insufficient space in %#x bytes FV for the volume top file, files end at %#x, VTF must start at %#x",
+			fv.Length, offset, vtfOffset)
+	}
+	gap := vtfOffset - offset
+	if gap == 0 {
+		return vtfOffset, nil
+	}
+	if gap < uefi.FileHeaderMinLength {
+		return 0, fmt.Errorf("gap of %#x bytes before the volume top file is too small for a pad file", gap)
+	}
+	pfile, err := uefi.CreatePadFile(gap)
+	if err != nil {
+		return 0, err
+	}
+	if err = fv.InsertFile(offset, pfile.Buf()); err != nil {
+		return 0, fmt.Errorf("File %s: %v", pfile.Header.UUID, err)
+	}
+	return vtfOffset, nil
+}
+
+// biosHasVTF returns whether any FV in the BIOS region contains the volume top file.
+func biosHasVTF(br *uefi.BIOSRegion) bool {
+	for _, e := range br.Elements {
+		if fv, ok := e.Value.(*uefi.FirmwareVolume); ok && fv.HasVTF() {
+			return true
+		}
+	}
+	return false
+}
+
+// isUnknownGUIDed returns true for GUID defined sections whose processing GUID
+// has no registered Compressor, so their data cannot be regenerated.
+func isUnknownGUIDed(s *uefi.Section) bool {
+	if s.Header.Type != uefi.SectionTypeGUIDDefined || s.TypeSpecific == nil {
+		return false
+	}
+	ts, ok := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined)
+	return ok && ts.Attributes&uefi.GUIDEDSectionProcessingRequired != 0 &&
+		uefi.CompressorFromGUID(ts.GUID) == nil
+}
@@ -0,0 +1,570 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/lzma"
+	"github.com/linuxboot/fiano/pkg/testutil"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+func TestAssembleVTF(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	fv, err := uefi.NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vtf := fv.Files[len(fv.Files)-1]
+	if !vtf.IsVTF() {
+		t.Fatalf("expected last file of the sample FV to be the VTF, got %v", vtf.Header.UUID)
+	}
+	vtfBuf := append([]byte{}, vtf.Buf()...)
+
+	// Drop SEC core and the pad file, the VTF must still end at the top of the FV.
+	fv.Files = fv.Files[2:]
+	if err := (&Assemble{}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	nb := fv.Buf()
+	if uint64(len(nb)) != fv.Length {
+		t.Fatalf("FV length changed, expected %#x, got %#x", fv.Length, len(nb))
+	}
+	if !bytes.Equal(nb[len(nb)-len(vtfBuf):], vtfBuf) {
+		t.Errorf("volume top file was not placed at the end of the FV")
+	}
+}
+
+func TestAssemblePadData(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	fv, err := uefi.NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pad := fv.Files[1]; pad.Header.Type != uefi.FVFileTypePad || pad.PadData {
+		t.Fatalf("expected an empty pad file, got %v with data %v", pad.Header.Type, pad.PadData)
+	}
+
+	// Hide some data in the pad file.
+	buf := append([]byte{}, sampleFV...)
+	padOffset := uefi.Align8(fv.DataOffset + uint64(len(fv.Files[0].Buf())))
+	copy(buf[padOffset+fv.Files[1].DataOffset+0x100:], "hidden")
+	if fv, err = uefi.NewFirmwareVolume(buf, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if !fv.Files[1].PadData {
+		t.Error("expected the pad file to be flagged as holding data")
+	}
+	if err := (&Assemble{}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fv.Buf(), buf) {
+		t.Error("pad file data was not preserved")
+	}
+
+	// The pad file is not dropped by tightening.
+	if reclaimed, err := tightenFV(fv); err != nil || reclaimed != 0 {
+		t.Errorf("expected nothing to be reclaimed, got %#x, %v", reclaimed, err)
+	}
+}
+
+func TestAssembleDeletedFile(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	fv, err := uefi.NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fv.Files[1].State != "EFI_FILE_DATA_VALID" || fv.Files[1].IsDeleted() {
+		t.Fatalf("expected a valid pad file, got %v", fv.Files[1].State)
+	}
+
+	// Delete the pad file, the state is not part of the header checksum.
+	buf := append([]byte{}, sampleFV...)
+	padOffset := uefi.Align8(fv.DataOffset + uint64(len(fv.Files[0].Buf())))
+	buf[padOffset+23] = 0xe0
+	if fv, err = uefi.NewFirmwareVolume(buf, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if !fv.Files[1].IsDeleted() {
+		t.Fatalf("expected a deleted pad file, got %v", fv.Files[1].State)
+	}
+	if err := (&Assemble{}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fv.Buf(), buf) {
+		t.Error("deleted file state was not preserved")
+	}
+
+	var b bytes.Buffer
+	if err := (&Ls{Path: "/", W: &b, HideDeleted: true}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	if out := b.String(); strings.Contains(out, "\n1 ") || !strings.Contains(out, "\n2 ") {
+		t.Errorf("expected the deleted file to be hidden, got:\n%s", out)
+	}
+}
+
+func TestAssembleAppleCRC32(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	buf := append([]byte{}, sampleFV...)
+	headerLen := binary.LittleEndian.Uint16(buf[0x30:])
+	// Put the CRC32 of the body into the zero vector, as Apple does.
+	binary.LittleEndian.PutUint32(buf[8:], crc32.ChecksumIEEE(buf[headerLen:]))
+	binary.LittleEndian.PutUint16(buf[50:], 0)
+	sum, err := uefi.Checksum16(buf[:headerLen])
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint16(buf[50:], 0-sum)
+	fv, err := uefi.NewFirmwareVolume(buf, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fv.AppleCRC32 || !fv.HasExtHeader() {
+		t.Fatalf("expected an Apple FV with an extended header")
+	}
+
+	// The extended header is part of the body covered by the CRC32.
+	name := *uuid.MustParse("3A9E5C1D-7B2F-4D8E-A6C0-1F2E3D4C5B6A")
+	fv.FVName = name
+	if err := (&Assemble{}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	out := fv.Buf()
+	if !bytes.Equal(out[fv.ExtHeaderOffset:fv.ExtHeaderOffset+16], name[:]) {
+		t.Fatalf("the extended header was not written")
+	}
+	if got, want := binary.LittleEndian.Uint32(out[8:]), crc32.ChecksumIEEE(out[headerLen:]); got != want {
+		t.Errorf("Apple CRC32 is %#x, expected %#x", got, want)
+	}
+	if sum, err := uefi.Checksum16(out[:headerLen]); err != nil || sum != 0 {
+		t.Errorf("FV header checksum is wrong after the CRC32 update, sums to %#x (%v)", sum, err)
+	}
+}
+
+func TestAssembleCompact(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	// Delete the SEC core.
+	buf := append([]byte{}, sampleFV...)
+	fv, err := uefi.NewFirmwareVolume(buf, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf[fv.DataOffset+23] = 0xe0
+	if fv, err = uefi.NewFirmwareVolume(buf, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	vtf := fv.Files[2].Buf()
+
+	if err := (&Assemble{Compact: true}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	if len(fv.Buf()) != len(sampleFV) {
+		t.Fatalf("expected the FV to keep its length %#x, got %#x", len(sampleFV), len(fv.Buf()))
+	}
+	// Only the VTF is left, with a pad file in front of it.
+	nfv, err := uefi.NewFirmwareVolume(fv.Buf(), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nfv.Files) != 2 || !isErasedPad(nfv.Files[0]) || !bytes.Equal(nfv.Files[1].Buf(), vtf) {
+		t.Fatalf("expected a pad file and the VTF, got %d files", len(nfv.Files))
+	}
+	for _, file := range nfv.Files {
+		if file.IsDeleted() {
+			t.Errorf("deleted file %v was not dropped", file.Header.UUID)
+		}
+	}
+}
+
+func TestAssembleVTFNotLast(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	fv, err := uefi.NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Move the VTF in front of everything else.
+	last := len(fv.Files) - 1
+	fv.Files = append([]*uefi.File{fv.Files[last]}, fv.Files[:last]...)
+	if err := (&Assemble{}).Run(fv); err == nil {
+		t.Errorf("expected an error when the volume top file is not the last file")
+	}
+}
+
+func TestAssembleBIOSPadding(t *testing.T) {
+	image, err := ioutil.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The NVRAM FV is at the start of the OVMF image.
+	nvFV := image[:0x84000]
+
+	// Vendor data in front of, and in between the FVs.
+	head := bytes.Repeat([]byte{0x5a}, 0x1000)
+	middle := bytes.Repeat([]byte{0xa5, 0x00}, 0x400)
+	var orig []byte
+	for _, b := range [][]byte{head, nvFV, middle, sampleFV} {
+		orig = append(orig, b...)
+	}
+
+	br, err := uefi.NewBIOSRegion(append([]byte{}, orig...), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(br.Elements) != 4 {
+		t.Fatalf("expected padding, FV, padding and FV, got %d elements", len(br.Elements))
+	}
+	if errs := br.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected validation errors: %v", errs)
+	}
+	if err := (&Assemble{}).Run(br); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(br.Buf(), orig) {
+		t.Errorf("BIOS region padding was not preserved")
+	}
+}
+
+func TestAssembleBIOSLayout(t *testing.T) {
+	image, err := ioutil.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nvFV := image[:0x84000]
+	free := bytes.Repeat([]byte{0xff}, 0x10000)
+	var orig []byte
+	for _, b := range [][]byte{nvFV, free, sampleFV} {
+		orig = append(orig, b...)
+	}
+
+	var tests = []struct {
+		name   string
+		nvSize int
+		ok     bool
+	}{
+		{"same", len(nvFV), true},
+		{"shrink", 0x80000, true},
+		{"growIntoFreeSpace", len(nvFV) + 0x8000, true},
+		{"growIntoVTF", len(nvFV) + 0x18000, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			br, err := uefi.NewBIOSRegion(append([]byte{}, orig...), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The NVRAM FV is not parsed into files, resize its buffer directly.
+			nvBuf := bytes.Repeat([]byte{0xff}, test.nvSize)
+			copy(nvBuf, nvFV)
+			br.Elements[0].Value.SetBuf(nvBuf)
+
+			err = (&Assemble{}).Run(br)
+			if !test.ok {
+				if err == nil {
+					t.Fatal("expected a layout error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			nb := br.Buf()
+			if len(nb) != len(orig) {
+				t.Fatalf("BIOS region length changed from %#x to %#x", len(orig), len(nb))
+			}
+			if !bytes.Equal(nb[len(nb)-len(sampleFV):], sampleFV) {
+				t.Errorf("top FV was moved")
+			}
+			// The elements must still cover the region without gaps.
+			var expected uint64
+			for _, e := range br.Elements {
+				offset, err := uefi.ElementOffset(e.Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if offset != expected {
+					t.Errorf("%s at offset %#x, expected %#x", e.Type, offset, expected)
+				}
+				expected = offset + uint64(len(e.Value.Buf()))
+			}
+		})
+	}
+}
+
+func TestAssembleSectionlessFileSize(t *testing.T) {
+	fv, err := uefi.NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// File sizes are not part of the JSON, so they are lost on extraction.
+	for _, f := range fv.Files {
+		if len(f.Sections) == 0 {
+			f.Header.Size = [3]uint8{}
+			f.Header.ExtendedSize = 0
+		}
+	}
+	if err := (&Assemble{}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(fv.Buf(), sampleFV) {
+		t.Error("assembled FV differs from the original")
+	}
+}
+
+// ecImage returns a flash image with a 4KiB EC region in front of sampleFV as
+// the BIOS region.
+func ecImage() []byte {
+	return testutil.Image(map[int][]byte{
+		uefi.RegionEC:   bytes.Repeat([]byte{0xEC}, uefi.RegionBlockSize),
+		uefi.RegionBIOS: sampleFV,
+	})
+}
+
+func TestAssembleECRegion(t *testing.T) {
+	orig := ecImage()
+	f, err := uefi.NewFlashImage(append([]byte{}, orig...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.EC == nil || len(f.EC.Buf()) != uefi.RegionBlockSize {
+		t.Fatalf("expected a 4KiB EC region, got %v", f.EC)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Buf(), orig) {
+		t.Error("assembled image differs from the original")
+	}
+}
+
+func TestAssembleRawRegions(t *testing.T) {
+	regions := map[int][]byte{uefi.RegionBIOS: sampleFV}
+	for _, i := range []int{6, 10, 15} {
+		regions[i] = bytes.Repeat([]byte{byte(i)}, uefi.RegionBlockSize)
+	}
+	orig := testutil.Image(regions)
+	f, err := uefi.NewFlashImage(append([]byte{}, orig...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, r := range f.Regions {
+		names = append(names, r.Name)
+	}
+	if want := []string{"Secondary BIOS", "IE", "PTT"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("expected regions %v, got %v", want, names)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Buf(), orig) {
+		t.Error("assembled image differs from the original")
+	}
+
+	// Regions must not get lost.
+	f.Regions = f.Regions[1:]
+	if err := (&Assemble{}).Run(f); err == nil {
+		t.Error("expected an error for a region missing from the tree")
+	}
+}
+
+func TestAssembleGUIDDefinedSection(t *testing.T) {
+	ui := []byte{0x10, 0x00, 0x00, 0x15, 0x4c, 0x00, 0x69, 0x00,
+		0x6e, 0x00, 0x75, 0x00, 0x78, 0x00, 0x00, 0x00} // Linux UI section
+	// GUID defined section without processing required, holding the UI section.
+	orig := []byte{0x28, 0x00, 0x00, byte(uefi.SectionTypeGUIDDefined)}
+	orig = append(orig, uefi.FFGUID[:]...)
+	orig = append(orig, 0x18, 0x00, byte(uefi.GUIDEDSectionAuthStatusValid), 0x00)
+	orig = append(orig, ui...)
+	s, err := uefi.NewSection(append([]byte{}, orig...), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Encapsulated) != 1 {
+		t.Fatalf("expected one encapsulated section, got %d", len(s.Encapsulated))
+	}
+	if err := (&Assemble{}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.Buf(), orig) {
+		t.Errorf("assembled section differs from the original, got\n%x\nwant\n%x", s.Buf(), orig)
+	}
+}
+
+// xorCompressor is a trivial Compressor for testing the registry.
+type xorCompressor struct{}
+
+func (xorCompressor) Name() string { return "XOR" }
+
+func (xorCompressor) Decode(encodedData []byte) ([]byte, error) {
+	decoded := make([]byte, len(encodedData))
+	for i, b := range encodedData {
+		decoded[i] = b ^ 0x5a
+	}
+	return decoded, nil
+}
+
+func (c xorCompressor) Encode(decodedData []byte) ([]byte, error) {
+	return c.Decode(decodedData)
+}
+
+var xorGUID = *uuid.MustParse("5B6F0D8B-7D1A-4C8B-9A3E-0A6C1E2F3D4C")
+
+func init() {
+	uefi.RegisterCompressor(xorGUID, xorCompressor{})
+}
+
+func TestAssembleRegisteredCompressor(t *testing.T) {
+	ui := []byte{0x10, 0x00, 0x00, 0x15, 0x4c, 0x00, 0x69, 0x00,
+		0x6e, 0x00, 0x75, 0x00, 0x78, 0x00, 0x00, 0x00} // Linux UI section
+	encoded, _ := xorCompressor{}.Encode(ui)
+	orig := []byte{0x28, 0x00, 0x00, byte(uefi.SectionTypeGUIDDefined)}
+	orig = append(orig, xorGUID[:]...)
+	orig = append(orig, 0x18, 0x00, byte(uefi.GUIDEDSectionProcessingRequired), 0x00)
+	orig = append(orig, encoded...)
+	s, err := uefi.NewSection(append([]byte{}, orig...), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined).Compression; c != "XOR" {
+		t.Errorf("got compression %q, want XOR", c)
+	}
+	if len(s.Encapsulated) != 1 {
+		t.Fatalf("expected one encapsulated section, got %d", len(s.Encapsulated))
+	}
+	if err := (&Assemble{}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.Buf(), orig) {
+		t.Errorf("assembled section differs from the original, got\n%x\nwant\n%x", s.Buf(), orig)
+	}
+}
+
+func TestAssembleGUIDedPassthrough(t *testing.T) {
+	ui := []byte{0x10, 0x00, 0x00, 0x15, 0x4c, 0x00, 0x69, 0x00,
+		0x6e, 0x00, 0x75, 0x00, 0x78, 0x00, 0x00, 0x00} // Linux UI section
+	// GUID defined section with an unknown processing GUID.
+	orig := []byte{0x28, 0x00, 0x00, byte(uefi.SectionTypeGUIDDefined)}
+	orig = append(orig, uefi.FFGUID[:]...)
+	orig = append(orig, 0x18, 0x00, byte(uefi.GUIDEDSectionProcessingRequired), 0x00)
+	orig = append(orig, 0xde, 0xad, 0xbe, 0xef, 0xde, 0xad, 0xbe, 0xef,
+		0xde, 0xad, 0xbe, 0xef, 0xde, 0xad, 0xbe, 0xef)
+	s, err := uefi.NewSection(append([]byte{}, orig...), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Encapsulated) != 0 {
+		t.Fatalf("expected no encapsulated sections, got %d", len(s.Encapsulated))
+	}
+	// Encapsulated sections which cannot be encoded, e.g. from an edited JSON.
+	es, err := uefi.NewSection(ui, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Encapsulated = append(s.Encapsulated, uefi.MakeTyped(es))
+
+	if err := (&Assemble{}).Run(s); err == nil {
+		t.Fatal("expected an error without passthrough")
+	}
+	if err := (&Assemble{GuidedPassthrough: true}).Run(s); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.Buf(), orig) {
+		t.Errorf("passed through section differs from the original, got\n%x\nwant\n%x", s.Buf(), orig)
+	}
+}
+
+func TestAssembleLZMACoder(t *testing.T) {
+	ui := []byte{0x10, 0x00, 0x00, 0x15, 0x4c, 0x00, 0x69, 0x00,
+		0x6e, 0x00, 0x75, 0x00, 0x78, 0x00, 0x00, 0x00} // Linux UI section
+	xz, err := lzma.NewCoder(lzma.BackendXZ)
+	if err != nil {
+		t.Skipf("xz is not available: %v", err)
+	}
+	for _, c := range []lzma.Coder{{}, xz} {
+		s, err := uefi.NewSection(testutil.CompressedSection(uefi.LZMAGUID, ui), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := (&Assemble{LZMA: c}).Run(s); err != nil {
+			t.Fatalf("%v: %v", c.Backend, err)
+		}
+		want, err := c.Encode(ui)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasSuffix(s.Buf(), want) {
+			t.Errorf("%v: section not encoded by its coder, got\n%x\nwant suffix\n%x", c.Backend, s.Buf(), want)
+		}
+		ns, err := uefi.NewSection(append([]byte{}, s.Buf()...), 0)
+		if err != nil {
+			t.Fatalf("%v: unable to parse the assembled section: %v", c.Backend, err)
+		}
+		if len(ns.Encapsulated) != 1 || !bytes.Equal(ns.Encapsulated[0].Value.Buf(), ui) {
+			t.Errorf("%v: assembled section does not hold the UI section", c.Backend)
+		}
+	}
+}
+
+func TestAssembleFVSize(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	fv, err := uefi.NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vtf := append([]byte{}, fv.Files[2].Buf()...)
+
+	// The FV shrinks, the VTF stays at the top.
+	sizes, err := ParseFVSizes(fv.FVName.String() + "=0x10000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{FVSizes: sizes}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	nb := fv.Buf()
+	if len(nb) != 0x10000 || fv.Length != 0x10000 || fv.Blocks[0].Count != 0x10 {
+		t.Fatalf("expected a 0x10000 bytes FV of 16 blocks, got %#x bytes, %d blocks", len(nb), fv.Blocks[0].Count)
+	}
+	if !bytes.Equal(nb[len(nb)-len(vtf):], vtf) {
+		t.Error("volume top file was not placed at the end of the FV")
+	}
+	if _, err := uefi.NewFirmwareVolume(nb, 0, false); err != nil {
+		t.Errorf("unable to parse the resized FV: %v", err)
+	}
+
+	// The files do not fit.
+	err = (&Assemble{FVSizes: map[string]uint64{"0x0": 0x2000}}).Run(fv)
+	if err == nil || !strings.Contains(err.Error(), "over its target size of 0x2000") || !strings.Contains(err.Error(), "SecMain") {
+		t.Errorf("expected an over budget error listing SecMain, got %v", err)
+	}
+	if err := (&Assemble{FVSizes: map[string]uint64{"0x0": 0x10800}}).Run(fv); err == nil {
+		t.Error("expected an error for a size which is not a multiple of the block size")
+	}
+}
+
+func TestParseFVSizes(t *testing.T) {
+	sizes, err := ParseFVSizes("763bed0d-de9f-48f5-81f1-3e90e1b1a015=0x40000, 0x84000=262144")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]uint64{"763BED0D-DE9F-48F5-81F1-3E90E1B1A015": 0x40000, "0x84000": 0x40000}
+	if !reflect.DeepEqual(sizes, want) {
+		t.Errorf("expected %v, got %v", want, sizes)
+	}
+	for _, bad := range []string{"0x1000", "FV=0x1000", "0x0=big"} {
+		if _, err := ParseFVSizes(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
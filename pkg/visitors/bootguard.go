@@ -0,0 +1,163 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ResignBootGuard updates the IBB hash of the Boot Guard boot policy manifest
+// after the IBB was modified and re-signs it with BPMKey. The key manifest is
+// updated with the hash of BPMKey and re-signed with KMKey. Booting with
+// verified boot then requires the hash of KMKey to be fused into the chipset.
+// The keys have to be of the same size as the ones they replace.
+type ResignBootGuard struct {
+	// Input
+	KMKey  *rsa.PrivateKey
+	BPMKey *rsa.PrivateKey
+
+	// Output
+	KM  *uefi.KeyManifest
+	BPM *uefi.BootPolicyManifest
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ResignBootGuard) Run(f uefi.Firmware) error {
+	// Offsets are only accurate once the tree is assembled.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	image := f.Buf()
+	size := uint64(len(image))
+	fit, err := uefi.FindFIT(image)
+	if err != nil {
+		return err
+	}
+	var kmOffset, bpmOffset uint64
+	var km *uefi.KeyManifest
+	var bpm *uefi.BootPolicyManifest
+	for _, e := range fit.Entries {
+		switch e.Type() {
+		case uefi.FITTypeKeyManifest:
+			if km != nil {
+				continue
+			}
+			if kmOffset, err = uefi.AddressToOffset(e.Address, size); err != nil {
+				return err
+			}
+			if km, err = uefi.NewKeyManifest(image[kmOffset:]); err != nil {
+				return err
+			}
+		case uefi.FITTypeBootPolicy:
+			if bpm != nil {
+				continue
+			}
+			if bpmOffset, err = uefi.AddressToOffset(e.Address, size); err != nil {
+				return err
+			}
+			if bpm, err = uefi.NewBootPolicyManifest(image[bpmOffset:]); err != nil {
+				return err
+			}
+		}
+	}
+	if km == nil || bpm == nil {
+		return errors.New("the FIT has no Boot Guard key and boot policy manifests")
+	}
+	// The manifests are changed in copies, the image buffer is only changed
+	// by patching the tree.
+	if km, err = uefi.NewKeyManifest(append([]byte{}, km.Buf()...)); err != nil {
+		return err
+	}
+	if bpm, err = uefi.NewBootPolicyManifest(append([]byte{}, bpm.Buf()...)); err != nil {
+		return err
+	}
+
+	digest, err := bpm.IBBDigest(image)
+	if err != nil {
+		return err
+	}
+	if err := bpm.SetIBBHash(digest); err != nil {
+		return err
+	}
+	if err := bpm.Sign(v.BPMKey); err != nil {
+		return fmt.Errorf("unable to sign the boot policy manifest: %v", err)
+	}
+	bpKeyHash := bpm.KeySignature.KeyHash()
+	if err := km.SetBPKeyHash(bpKeyHash[:]); err != nil {
+		return err
+	}
+	if err := km.Sign(v.KMKey); err != nil {
+		return fmt.Errorf("unable to sign the key manifest: %v", err)
+	}
+
+	root := node{Firmware: f, InFlash: true}
+	if err := patchFlash(root, kmOffset, km.Buf()); err != nil {
+		return err
+	}
+	if err := patchFlash(root, bpmOffset, bpm.Buf()); err != nil {
+		return err
+	}
+	v.KM, v.BPM = km, bpm
+	return (&Assemble{}).Run(f)
+}
+
+// Visit applies the ResignBootGuard visitor to any Firmware type.
+func (v *ResignBootGuard) Visit(f uefi.Firmware) error {
+	return v.Run(f)
+}
+
+// readRSAKey reads an RSA private key from a PEM file in PKCS#1 or PKCS#8
+// format.
+func readRSAKey(path string) (*rsa.PrivateKey, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(buf)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data in %v", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the key in %v: %v", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("key in %v is not an RSA key", path)
+	}
+	return rsaKey, nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "resign_bootguard",
+		Args: []string{"KMKEY", "BPMKEY"},
+		Help: "Update the IBB hash of the Boot Guard boot policy manifest and re-sign it with the PEM RSA key BPMKEY, and re-sign the key manifest with KMKEY.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			kmKey, err := readRSAKey(args[0])
+			if err != nil {
+				return nil, err
+			}
+			bpmKey, err := readRSAKey(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return &ResignBootGuard{
+				KMKey:  kmKey,
+				BPMKey: bpmKey,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,166 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// bgKeySignature returns an unsigned key signature structure for a 2048 bit
+// key.
+func bgKeySignature() []byte {
+	b := new(bytes.Buffer)
+	b.Write([]byte{0x10})
+	binary.Write(b, binary.LittleEndian, []uint16{uefi.BGAlgRSA})
+	b.Write([]byte{0x10})
+	binary.Write(b, binary.LittleEndian, []uint16{2048})
+	binary.Write(b, binary.LittleEndian, uint32(65537))
+	b.Write(make([]byte, 256))
+	binary.Write(b, binary.LittleEndian, []uint16{uefi.BGAlgRSASSA})
+	b.Write([]byte{0x10})
+	binary.Write(b, binary.LittleEndian, []uint16{2048, uefi.BGAlgSHA256})
+	b.Write(make([]byte, 256))
+	return b.Bytes()
+}
+
+// addBootGuard puts an unsigned key manifest at 0x3d3000 and an unsigned boot
+// policy manifest hashing the last 4KiB at 0x3d3800 into the pad file of the
+// SEC FV, and a FIT pointing to them at 0x3d4000.
+func addBootGuard(t *testing.T, f uefi.Firmware) {
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	size := uint64(len(f.Buf()))
+
+	km := new(bytes.Buffer)
+	km.Write(uefi.KMStructureID)
+	km.Write([]byte{0x10, 0x10, 0, 1})
+	binary.Write(km, binary.LittleEndian, []uint16{uefi.BGAlgSHA256, 32})
+	km.Write(make([]byte, 32))
+	km.Write(bgKeySignature())
+
+	bpm := new(bytes.Buffer)
+	bpm.Write(uefi.BPMStructureID)
+	bpm.Write([]byte{0x10, 0x01, 1, 0, 0, 0, 0, 0})
+	bpm.Write(uefi.IBBStructureID)
+	bpm.Write(make([]byte, 47))
+	binary.Write(bpm, binary.LittleEndian, []uint16{uefi.BGAlgSHA256, 0})
+	binary.Write(bpm, binary.LittleEndian, uint32(0xfffffff0))
+	binary.Write(bpm, binary.LittleEndian, []uint16{uefi.BGAlgSHA256, 32})
+	bpm.Write(make([]byte, 32))
+	bpm.WriteByte(1)
+	binary.Write(bpm, binary.LittleEndian, uefi.IBBSegment{Base: 0xfffff000, Size: 0x1000})
+	bpm.Write(uefi.PMSGStructureID)
+	bpm.WriteByte(0x10)
+	bpm.Write(bgKeySignature())
+
+	fit, err := uefi.NewFITFromParts(0xfffd4000, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fit.Entries = append(fit.Entries,
+		uefi.FITEntry{Address: 0xfffd3000, Version: uefi.FITVersion, TypeCV: uint8(uefi.FITTypeKeyManifest)},
+		uefi.FITEntry{Address: 0xfffd3800, Version: uefi.FITVersion, TypeCV: uint8(uefi.FITTypeBootPolicy)})
+	fit.Entries[0].Size = uefi.Write3Size(uint64(len(fit.Entries)))
+	pointer := make([]byte, 8)
+	binary.LittleEndian.PutUint64(pointer, fit.Address)
+
+	root := node{Firmware: f, InFlash: true}
+	for _, p := range []struct {
+		offset uint64
+		data   []byte
+	}{
+		{0x3d3000, km.Bytes()},
+		{0x3d3800, bpm.Bytes()},
+		{0x3d4000, fit.Bytes()},
+		{size - uefi.FITPointerOffset, pointer},
+	} {
+		if err := patchFlash(root, p.offset, p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestResignBootGuard(t *testing.T) {
+	f := parseImage(t)
+	addBootGuard(t, f)
+
+	var keys [2]*rsa.PrivateKey
+	for i := range keys {
+		var err error
+		if keys[i], err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+			t.Fatal(err)
+		}
+	}
+	v := &ResignBootGuard{KMKey: keys[0], BPMKey: keys[1]}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &SecurityReport{W: ioutil.Discard}
+	if err := r.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if r.KMError != nil || r.BPMError != nil {
+		t.Fatalf("expected valid manifests, got %v and %v", r.KMError, r.BPMError)
+	}
+	// There is no ACM, so verified boot is not possible.
+	if r.BootGuard != BootGuardMeasured {
+		t.Errorf("expected measured boot without an ACM, got %q", r.BootGuard)
+	}
+	want, err := r.BPM.IBBDigest(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(r.BPM.IBBHash.Digest, want) {
+		t.Errorf("expected IBB hash %x, got %x", want, r.BPM.IBBHash.Digest)
+	}
+	if bpKey := r.BPM.KeySignature.KeyHash(); !bytes.Equal(r.KM.BPKeyHash.Digest, bpKey[:]) {
+		t.Errorf("expected BPM key hash %x in the KM, got %x", bpKey, r.KM.BPKeyHash.Digest)
+	}
+}
+
+func TestReadRSAKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "bootguard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for name, block := range map[string]*pem.Block{
+		"pkcs1.pem": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		"pkcs8.pem": {Type: "PRIVATE KEY", Bytes: pkcs8},
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := readRSAKey(path)
+		if err != nil {
+			t.Fatalf("%v: %v", name, err)
+		}
+		if got.N.Cmp(key.N) != 0 {
+			t.Errorf("%v: read a different key", name)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// ChipsecModule is an executable in the EFI whitelist of chipsec, as read by
+// its tools.uefi.whitelist module. The hashes are of the PE32, TE or PIC
+// image, without the section header.
+type ChipsecModule struct {
+	GUID   string `json:"guid"`
+	UI     string `json:"ui,omitempty"`
+	MD5    string `json:"md5"`
+	SHA1   string `json:"sha1"`
+	SHA256 string `json:"sha256"`
+}
+
+// ChipsecWhitelist writes the chipsec EFI whitelist of the executables of the
+// image, including those in compressed FVs, keyed by their SHA-256.
+type ChipsecWhitelist struct {
+	// Input
+	Path string
+
+	// Output
+	Modules map[string]ChipsecModule
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ChipsecWhitelist) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ChipsecWhitelist visitor to any Firmware type.
+func (v *ChipsecWhitelist) Visit(f uefi.Firmware) error {
+	v.Modules = make(map[string]ChipsecModule)
+	walkLeaves(node{Firmware: f, InFlash: true}, "", nil, func(n node, path string, file *uefi.File) {
+		s, ok := n.Firmware.(*uefi.Section)
+		if !ok || file == nil {
+			return
+		}
+		switch s.Header.Type {
+		case uefi.SectionTypePE32, uefi.SectionTypeTE, uefi.SectionTypePIC:
+		default:
+			return
+		}
+		image := sectionPayload(s)
+		sum1 := sha1.Sum(image)
+		sum5 := md5.Sum(image)
+		m := ChipsecModule{
+			GUID:   file.Header.UUID.String(),
+			UI:     fileName(file),
+			MD5:    hex.EncodeToString(sum5[:]),
+			SHA1:   hex.EncodeToString(sum1[:]),
+			SHA256: hashBuf(image),
+		}
+		v.Modules[m.SHA256] = m
+	})
+	b, err := json.MarshalIndent(v.Modules, "", "    ")
+	if err != nil {
+		return err
+	}
+	return writeFile(v.Path, append(b, '\n'), 0666)
+}
+
+func init() {
+	Register(CLI{
+		Name: "chipsec_whitelist",
+		Args: []string{"FILE"},
+		Help: "Write the EFI whitelist of chipsec's tools.uefi.whitelist module to FILE, with the GUID, UI name, MD5, SHA-1 and SHA-256 " +
+			"of each PE32, TE and PIC image, including those in compressed FVs.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &ChipsecWhitelist{
+				Path: args[0],
+			}, nil
+		},
+	})
+}
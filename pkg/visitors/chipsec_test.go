@@ -0,0 +1,57 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestChipsecWhitelist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chipsec")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "whitelist.json")
+
+	f := parseImage(t)
+	v := &ChipsecWhitelist{Path: path}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var modules map[string]ChipsecModule
+	if err := json.Unmarshal(b, &modules); err != nil {
+		t.Fatal(err)
+	}
+	if len(modules) != len(v.Modules) || len(modules) != 99 {
+		t.Fatalf("expected the modules of OVMF, got %d", len(modules))
+	}
+
+	// The hash of SecMain is that of its PE32 image.
+	file := find(t, f, testGUID)[0]
+	var image []byte
+	for _, s := range file.Sections {
+		if s.Header.Type == uefi.SectionTypePE32 {
+			image = sectionPayload(s)
+		}
+	}
+	m, ok := modules[hashBuf(image)]
+	if !ok {
+		t.Fatal("SecMain is missing")
+	}
+	if m.GUID != testGUID.String() || m.UI != "SecMain" || m.SHA256 != hashBuf(image) || len(m.MD5) != 32 || len(m.SHA1) != 40 {
+		t.Errorf("unexpected module %+v", m)
+	}
+}
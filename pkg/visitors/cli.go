@@ -8,34 +8,117 @@
 package visitors
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
-var visitorRegistry = map[string]visitorEntry{}
+var visitorRegistry = map[string]CLI{}
 
-type visitorEntry struct {
-	numArgs       int
-	createVisitor func([]string) (uefi.Visitor, error)
+// CLI describes how a Visitor is used from the command line.
+type CLI struct {
+	// Name is the operation, e.g. "extract".
+	Name string
+	// Args are the names of the arguments shown in the usage, e.g. FILE.
+	Args []string
+	// Help describes the operation, it is wrapped in the usage.
+	Help string
+	// Flags are the names of the command line flags changing the
+	// operation. They may also be given right after the operation name.
+	Flags []string
+	// Create creates the Visitor from the len(Args) arguments.
+	Create func(args []string) (uefi.Visitor, error)
 }
 
-// RegisterCLI registers a function `createVisitor` to be called when parsing
-// the arguments with `ParseCLI`. For a Visitor to be accessible from the
-// command line, it should have an init function which registers a
-// `createVisitor` function here.
+// Register registers a Visitor to be created when parsing the arguments with
+// `ParseCLI` and listed by `Usage`. For a Visitor to be accessible from the
+// command line, it should have an init function which registers itself here.
+func Register(c CLI) {
+	if _, ok := visitorRegistry[c.Name]; ok {
+		panic(fmt.Sprintf("two visitors registered the same name: '%s'", c.Name))
+	}
+	for _, name := range c.Flags {
+		if flag.Lookup(name) == nil {
+			panic(fmt.Sprintf("visitor '%s' uses the unknown flag '%s'", c.Name, name))
+		}
+	}
+	visitorRegistry[c.Name] = c
+}
+
+// RegisterCLI registers a function `createVisitor` taking numArgs arguments,
+// like Register but without help text.
 func RegisterCLI(name string, numArgs int, createVisitor func([]string) (uefi.Visitor, error)) {
-	if _, ok := visitorRegistry[name]; ok {
-		panic(fmt.Sprintf("two visitors registered the same name: '%s'", name))
+	args := make([]string, numArgs)
+	for i := range args {
+		args[i] = fmt.Sprintf("ARG%d", i+1)
 	}
-	visitorRegistry[name] = visitorEntry{
-		numArgs:       numArgs,
-		createVisitor: createVisitor,
+	Register(CLI{Name: name, Args: args, Create: createVisitor})
+}
+
+// parseFlags sets the flags of the operation given before its arguments, and
+// returns the remaining arguments.
+func (c *CLI) parseFlags(args []string) ([]string, error) {
+	if len(c.Flags) == 0 {
+		return args, nil
+	}
+	fs := flag.NewFlagSet(c.Name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	for _, name := range c.Flags {
+		f := flag.Lookup(name)
+		fs.Var(f.Value, f.Name, f.Usage)
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, fmt.Errorf("visitor '%s': %v", c.Name, err)
+	}
+	return fs.Args(), nil
+}
+
+// Usage writes the registered operations with their arguments, flags and help
+// text to w.
+func Usage(w io.Writer) {
+	names := make([]string, 0, len(visitorRegistry))
+	for name := range visitorRegistry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := visitorRegistry[name]
+		synopsis := []string{name}
+		for _, name := range c.Flags {
+			f := flag.Lookup(name)
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				synopsis = append(synopsis, fmt.Sprintf("[-%s]", name))
+			} else {
+				typ, _ := flag.UnquoteUsage(f)
+				synopsis = append(synopsis, fmt.Sprintf("[-%s %s]", name, typ))
+			}
+		}
+		synopsis = append(synopsis, c.Args...)
+		fmt.Fprintf(w, "  %s\n", strings.Join(synopsis, " "))
+		line := ""
+		for _, word := range strings.Fields(c.Help) {
+			if line != "" && len(line)+1+len(word) > 70 {
+				fmt.Fprintf(w, "    \t%s\n", line)
+				line = ""
+			}
+			if line != "" {
+				line += " "
+			}
+			line += word
+		}
+		if line != "" {
+			fmt.Fprintf(w, "    \t%s\n", line)
+		}
 	}
 }
 
 // ParseCLI constructs a list of visitors from the given CLI argument list.
-// TODO: display some type of help message
 func ParseCLI(args []string) ([]uefi.Visitor, error) {
 	visitors := []uefi.Visitor{}
 	for len(args) > 0 {
@@ -45,20 +128,71 @@ func ParseCLI(args []string) ([]uefi.Visitor, error) {
 		if !ok {
 			return []uefi.Visitor{}, fmt.Errorf("could not find visitor '%s'", cmd)
 		}
-		if o.numArgs > len(args) {
-			return []uefi.Visitor{}, fmt.Errorf("too few arguments for visitor '%s', got %d, expected %d",
-				cmd, len(args), o.numArgs)
+		var err error
+		if args, err = o.parseFlags(args); err != nil {
+			return []uefi.Visitor{}, err
+		}
+		if len(o.Args) > len(args) {
+			return []uefi.Visitor{}, fmt.Errorf("too few arguments for visitor '%s', got %d, expected %d (%s)",
+				cmd, len(args), len(o.Args), strings.Join(o.Args, " "))
 		}
-		visitor, err := o.createVisitor(args[:o.numArgs])
+		visitor, err := o.Create(args[:len(o.Args)])
 		if err != nil {
 			return []uefi.Visitor{}, err
 		}
 		visitors = append(visitors, visitor)
-		args = args[o.numArgs:]
+		args = args[len(o.Args):]
 	}
 	return visitors, nil
 }
 
+// VerifyError is returned when the image or an extracted directory fails an
+// integrity check, so callers can tell it apart from other failures.
+type VerifyError struct {
+	Err error
+}
+
+func (e *VerifyError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *VerifyError) Unwrap() error {
+	return e.Err
+}
+
+// WriteError is returned when an output file or directory cannot be
+// written, so callers can tell it apart from a missing or unreadable input.
+type WriteError struct {
+	Err error
+}
+
+func (e *WriteError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *WriteError) Unwrap() error {
+	return e.Err
+}
+
+// writeFile is ioutil.WriteFile returning a WriteError.
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if err := ioutil.WriteFile(path, data, perm); err != nil {
+		return &WriteError{err}
+	}
+	return nil
+}
+
+// createFile is os.Create returning a WriteError.
+func createFile(path string) (*os.File, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, &WriteError{err}
+	}
+	return file, nil
+}
+
 // ExecuteCLI applies each Visitor over the firmware in sequence.
 func ExecuteCLI(f uefi.Firmware, v []uefi.Visitor) error {
 	for i := range v {
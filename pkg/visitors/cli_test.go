@@ -0,0 +1,57 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseCLI(t *testing.T) {
+	defer func() { *ignoreCase = false }()
+	v, err := ParseCLI([]string{"find_name", "-ignore-case", "shell", "json", "ls", "/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(v) != 3 {
+		t.Fatalf("got %d visitors, want 3", len(v))
+	}
+	if !*ignoreCase {
+		t.Error("-ignore-case was not set")
+	}
+	if ls, ok := v[2].(*Ls); !ok || ls.Path != "/" {
+		t.Errorf("got %#v, want ls of /", v[2])
+	}
+
+	for _, args := range [][]string{
+		{"nonexistent"},
+		{"ls"},
+		{"find_name", "-force", "shell"},
+	} {
+		if _, err := ParseCLI(args); err == nil {
+			t.Errorf("expected an error for %v", args)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	var b bytes.Buffer
+	Usage(&b)
+	for _, s := range []string{
+		"  find_name [-ignore-case] REGEX\n",
+		"  extract [-force] [-remove] DIR\n",
+		"  json [-filter string]\n",
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("%q missing in the usage:\n%s", s, b.String())
+		}
+	}
+	for name, c := range visitorRegistry {
+		if c.Help == "" {
+			t.Errorf("visitor '%s' has no help text", name)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// CompressedSection is a compressed section found by CompressionReport.
+type CompressedSection struct {
+	// Path is the path of the section as in Ls, by child indices.
+	Path      string
+	File      *uefi.File
+	Section   *uefi.Section
+	Algorithm string
+	// Size is the size of the section, Expanded the size of the
+	// encapsulated sections once decompressed. Expanded is 0 if the
+	// section could not be decompressed.
+	Size     uint64
+	Expanded uint64
+}
+
+// FVCompression are the compressed sections in the files of an FV.
+type FVCompression struct {
+	// Path is the path of the FV as in Ls, by child indices.
+	Path     string
+	FV       *uefi.FirmwareVolume
+	Sections []CompressedSection
+	// Size and Expanded are the totals of the sections.
+	Size     uint64
+	Expanded uint64
+}
+
+// CompressionReport lists the compressed sections of each FV with their
+// algorithm and compression ratio, along with totals per FV and per
+// algorithm. Sections in a compressed FV count towards that FV. FVs without
+// compressed sections are not printed.
+type CompressionReport struct {
+	// Input
+	W io.Writer
+
+	// Output
+	FVs []*FVCompression
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *CompressionReport) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// ratio formats the ratio of the compressed to the expanded size.
+func ratio(size, expanded uint64) string {
+	if expanded == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%%", float64(size)*100/float64(expanded))
+}
+
+// Visit applies the CompressionReport visitor to any Firmware type.
+func (v *CompressionReport) Visit(f uefi.Firmware) error {
+	v.FVs = nil
+	v.collect(node{Firmware: f, InFlash: true}, "", nil, nil)
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	type total struct{ sections, size, expanded uint64 }
+	totals := make(map[string]*total)
+	for _, fv := range v.FVs {
+		if len(fv.Sections) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "FV %s %v: %d compressed sections, %#x bytes, %#x bytes expanded (%s)\n",
+			fv.Path, uefi.FVGUIDs[fv.FV.FileSystemGUID], len(fv.Sections), fv.Size, fv.Expanded, ratio(fv.Size, fv.Expanded))
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Path\tFile\tAlgorithm\tSize\tExpanded\tRatio\n")
+		for _, s := range fv.Sections {
+			guid, name, _ := nodeInfo(s.File)
+			if name == "" {
+				name = guid
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%#x\t%#x\t%s\n", s.Path, name, s.Algorithm, s.Size, s.Expanded, ratio(s.Size, s.Expanded))
+			t := totals[s.Algorithm]
+			if t == nil {
+				t = &total{}
+				totals[s.Algorithm] = t
+			}
+			t.sections++
+			t.size += s.Size
+			t.expanded += s.Expanded
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	var algorithms []string
+	for a := range totals {
+		algorithms = append(algorithms, a)
+	}
+	sort.Strings(algorithms)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Algorithm\tSections\tSize\tExpanded\tRatio\n")
+	for _, a := range algorithms {
+		t := totals[a]
+		fmt.Fprintf(tw, "%s\t%d\t%#x\t%#x\t%s\n", a, t.sections, t.size, t.expanded, ratio(t.size, t.expanded))
+	}
+	return tw.Flush()
+}
+
+// compressedSection returns the algorithm and expanded size of a compressed
+// section, or ok false if the section is not compressed.
+func compressedSection(s *uefi.Section) (algorithm string, expanded uint64, ok bool) {
+	switch s.Header.Type {
+	case uefi.SectionTypeGUIDDefined:
+		ts, isGUIDed := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined)
+		if !isGUIDed || ts.Attributes&uefi.GUIDEDSectionProcessingRequired == 0 {
+			return "", 0, false
+		}
+		algorithm = ts.Compression
+		if algorithm == "UNKNOWN" {
+			return ts.GUID.String(), 0, true
+		}
+		// The encapsulated sections are laid out as Assemble does.
+		for _, e := range s.Encapsulated {
+			expanded = uefi.Align4(expanded) + uint64(len(e.Value.Buf()))
+		}
+		return algorithm, expanded, true
+	case uefi.SectionTypeCompression:
+		// EFI_COMPRESSION_SECTION, which is not decompressed, but has the
+		// expanded size in its header.
+		buf := s.Buf()
+		hl := sectionHeaderLen(s)
+		if uint64(len(buf)) < hl+5 {
+			return "EFI", 0, true
+		}
+		algorithm = "EFI"
+		if buf[hl+4] == 0 {
+			algorithm = "none"
+		}
+		return algorithm, uint64(binary.LittleEndian.Uint32(buf[hl:])), true
+	}
+	return "", 0, false
+}
+
+// collect adds the FVs at and below n along with their compressed sections.
+func (v *CompressionReport) collect(n node, path string, fv *FVCompression, file *uefi.File) {
+	p := path
+	if p == "" {
+		p = "/"
+	}
+	switch f := n.Firmware.(type) {
+	case *uefi.FirmwareVolume:
+		fv = &FVCompression{Path: p, FV: f}
+		v.FVs = append(v.FVs, fv)
+	case *uefi.File:
+		file = f
+	case *uefi.Section:
+		if algorithm, expanded, ok := compressedSection(f); ok && fv != nil {
+			s := CompressedSection{
+				Path:      p,
+				File:      file,
+				Section:   f,
+				Algorithm: algorithm,
+				Size:      uint64(len(f.Buf())),
+				Expanded:  expanded,
+			}
+			fv.Sections = append(fv.Sections, s)
+			fv.Size += s.Size
+			fv.Expanded += s.Expanded
+		}
+	}
+	for i, c := range children(n) {
+		v.collect(c, fmt.Sprintf("%s/%d", path, i), fv, file)
+	}
+}
+
+func init() {
+	Register(CLI{
+		Name: "compression",
+		Help: "List the compressed sections of each FV with their algorithm, size, expanded size and ratio, along with totals per FV and per algorithm.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &CompressionReport{}, nil
+		},
+	})
+}
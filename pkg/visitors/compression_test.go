@@ -0,0 +1,47 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestCompressionReport(t *testing.T) {
+	f := parseImage(t)
+	v := &CompressionReport{W: ioutil.Discard}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var sections []CompressedSection
+	for _, fv := range v.FVs {
+		sections = append(sections, fv.Sections...)
+	}
+	if len(sections) != 1 {
+		t.Fatalf("expected the compressed DXE FV only, got %+v", sections)
+	}
+	s := sections[0]
+	if s.Path != "/1/0/0" || s.Algorithm != "LZMA" || s.Size != 0x12568f || s.Expanded != 0xae0090 {
+		t.Errorf("unexpected compressed section %+v", s)
+	}
+	if v.FVs[1].Path != "/1" || v.FVs[1].Size != s.Size {
+		t.Errorf("expected the section to count towards FV /1, got %+v", v.FVs[1])
+	}
+}
+
+func TestCompressedSectionEFI(t *testing.T) {
+	// An EFI_COMPRESSION_SECTION of 0x1000 bytes expanded.
+	buf := []byte{0x10, 0, 0, byte(uefi.SectionTypeCompression), 0, 0x10, 0, 0, 1, 0xaa, 0xbb, 0xcc, 0xdd, 0xee, 0xff, 0}
+	s, err := uefi.NewSection(buf, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	algorithm, expanded, ok := compressedSection(s)
+	if !ok || algorithm != "EFI" || expanded != 0x1000 {
+		t.Errorf("expected an EFI compressed section of 0x1000 bytes, got %v, %v, %#x", ok, algorithm, expanded)
+	}
+}
@@ -0,0 +1,124 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// CreateFV creates an empty FV and places it into the first free space of the
+// BIOS region it fits in, i.e. erased bytes in the padding between the FVs,
+// aligned to its block size.
+type CreateFV struct {
+	// Input
+	FileSystem uuid.UUID
+	Name       uuid.UUID
+	Size       uint64
+	BlockSize  uint64
+
+	// Output
+	Created *uefi.FirmwareVolume
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *CreateFV) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.Created == nil {
+		return fmt.Errorf("no BIOS region to create FV %v in", v.Name)
+	}
+	return nil
+}
+
+// Visit applies the CreateFV visitor to any Firmware type.
+func (v *CreateFV) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.BIOSRegion:
+		// The new FV has the erase polarity of the others, and a new name.
+		polarity := uint8(0xFF)
+		for i := len(f.Elements) - 1; i >= 0; i-- {
+			fv, ok := f.Elements[i].Value.(*uefi.FirmwareVolume)
+			if !ok {
+				continue
+			}
+			if fv.FVName == v.Name {
+				return fmt.Errorf("FV %v already exists at %#x", v.Name, fv.FVOffset)
+			}
+			polarity = fv.GetErasePolarity()
+		}
+		fv, err := uefi.CreateFirmwareVolume(v.FileSystem, v.Name, v.Size, v.BlockSize, polarity)
+		if err != nil {
+			return err
+		}
+		for i, e := range f.Elements {
+			bp, ok := e.Value.(*uefi.BIOSPadding)
+			if !ok {
+				continue
+			}
+			buf := bp.Buf()
+			end := bp.Offset + uint64(len(buf))
+			for start := uefi.Align(bp.Offset, v.BlockSize); start+v.Size <= end; start += v.BlockSize {
+				if !uefi.IsErased(buf[start-bp.Offset:start-bp.Offset+v.Size], polarity) {
+					continue
+				}
+				// The padding around the FV keeps its contents.
+				fv.FVOffset = start
+				var elements []*uefi.TypedFirmware
+				if start > bp.Offset {
+					before, _ := uefi.NewBIOSPadding(append([]byte{}, buf[:start-bp.Offset]...), bp.Offset)
+					elements = append(elements, uefi.MakeTyped(before))
+				}
+				elements = append(elements, uefi.MakeTyped(fv))
+				if start+v.Size < end {
+					after, _ := uefi.NewBIOSPadding(append([]byte{}, buf[start-bp.Offset+v.Size:]...), start+v.Size)
+					elements = append(elements, uefi.MakeTyped(after))
+				}
+				f.Elements = append(f.Elements[:i], append(elements, f.Elements[i+1:]...)...)
+				v.Created = fv
+				return nil
+			}
+		}
+		return fmt.Errorf("no erased free space of %#x bytes in the BIOS region for FV %v", v.Size, v.Name)
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	Register(CLI{
+		Name: "create_fv",
+		Args: []string{"(FFS2|FFS3)", "FVNAME", "SIZE", "BLOCKSIZE"},
+		Help: "Create an empty FV with the given filesystem, FVName GUID, size and block size, and place it into the first erased free space " +
+			"of the BIOS region which is large enough, aligned to the block size.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			fs, err := uefi.ParseFVGUID(args[0])
+			if err != nil {
+				return nil, err
+			}
+			name, err := uuid.Parse(args[1])
+			if err != nil {
+				return nil, err
+			}
+			size, err := strconv.ParseUint(args[2], 0, 64)
+			if err != nil {
+				return nil, err
+			}
+			blockSize, err := strconv.ParseUint(args[3], 0, 64)
+			if err != nil {
+				return nil, err
+			}
+			return &CreateFV{
+				FileSystem: *fs,
+				Name:       *name,
+				Size:       size,
+				BlockSize:  blockSize,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+func TestCreateFV(t *testing.T) {
+	// Vendor data, then free space in front of the SEC FV.
+	head := bytes.Repeat([]byte{0x5a}, 0x800)
+	free := bytes.Repeat([]byte{0xff}, 0x20000)
+	var orig []byte
+	for _, b := range [][]byte{head, free, sampleFV} {
+		orig = append(orig, b...)
+	}
+	name := uuid.MustParse("4F1C8A3D-2B6E-4C8A-9E3D-1A2B3C4D5E6F")
+
+	var tests = []struct {
+		name      string
+		size      uint64
+		blockSize uint64
+		offset    uint64
+		ok        bool
+	}{
+		{"aligned", 0x10000, 0x1000, 0x1000, true},
+		{"large blocks", 0x10000, 0x10000, 0x10000, true},
+		{"too large", 0x20000, 0x1000, 0, false},
+		{"not a multiple of the block size", 0x10800, 0x1000, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			br, err := uefi.NewBIOSRegion(append([]byte{}, orig...), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := &CreateFV{
+				FileSystem: *uefi.FFS2,
+				Name:       *name,
+				Size:       test.size,
+				BlockSize:  test.blockSize,
+			}
+			err = v.Run(br)
+			if !test.ok {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := (&Assemble{}).Run(br); err != nil {
+				t.Fatal(err)
+			}
+			nb := br.Buf()
+			if !bytes.Equal(nb[:len(head)], head) || !bytes.Equal(nb[len(nb)-len(sampleFV):], sampleFV) {
+				t.Error("the other BIOS region contents changed")
+			}
+
+			// The new FV is found when parsing the region again.
+			br, err = uefi.NewBIOSRegion(nb, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fv *uefi.FirmwareVolume
+			for _, e := range br.Elements {
+				if f, ok := e.Value.(*uefi.FirmwareVolume); ok && f.FVName == *name {
+					fv = f
+				}
+			}
+			if fv == nil {
+				t.Fatal("created FV not found")
+			}
+			if fv.FVOffset != test.offset || fv.Length != test.size || len(fv.Files) != 0 {
+				t.Errorf("expected an empty FV of %#x bytes at %#x, got %#x bytes at %#x with %d files",
+					test.size, test.offset, fv.Length, fv.FVOffset, len(fv.Files))
+			}
+			if fv.Blocks[0].Size != uint32(test.blockSize) || fv.GetErasePolarity() != 0xff {
+				t.Errorf("unexpected FV header %+v", fv.FirmwareVolumeFixedHeader)
+			}
+			for _, err := range fv.Validate() {
+				t.Error(err)
+			}
+
+			// A second FV with the same name is refused.
+			if err := v.Run(br); err == nil {
+				t.Error("expected an error for a duplicate FV name")
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// CSMFileGUIDs are files known to hold the CSM16 binary in a raw section
+// rather than a Compatibility16 section.
+var CSMFileGUIDs = map[uuid.UUID]string{
+	*uuid.MustParse("1547B4F3-3E8A-4FEF-81C8-328ED647AB1A"): "EDK2 Csm16",
+	*uuid.MustParse("A062CF1F-8473-4AA3-8793-600BC4FFE9A8"): "AMI CSM16",
+}
+
+// findCSM returns the sections holding the CSM16 binary and their files.
+func findCSM(f uefi.Firmware) ([]*uefi.Section, []*uefi.File, error) {
+	// Match all files, including those in nested volumes.
+	find := Find{
+		Predicate: func(f *uefi.File, name string) bool {
+			return true
+		},
+	}
+	if err := find.Run(f); err != nil {
+		return nil, nil, err
+	}
+	var matches []*uefi.Section
+	var files []*uefi.File
+	for _, file := range find.Matches {
+		_, known := CSMFileGUIDs[file.Header.UUID]
+		n := len(matches)
+		if err := file.ApplyChildren(&csmFinder{known: known, matches: &matches}); err != nil {
+			return nil, nil, err
+		}
+		for range matches[n:] {
+			files = append(files, file)
+		}
+	}
+	return matches, files, nil
+}
+
+// csmFinder collects Compatibility16 sections, and raw sections of known CSM
+// files, including those in encapsulated sections.
+type csmFinder struct {
+	known   bool
+	matches *[]*uefi.Section
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *csmFinder) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the csmFinder visitor to any Firmware type.
+func (v *csmFinder) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.Section:
+		if f.Header.Type == uefi.SectionTypeCompatibility16 || (v.known && f.Header.Type == uefi.SectionTypeRaw) {
+			*v.matches = append(*v.matches, f)
+			return nil
+		}
+		return f.ApplyChildren(v)
+	case *uefi.FirmwareVolume:
+		// Nested volumes are searched by findCSM itself.
+		return nil
+	}
+	return f.ApplyChildren(v)
+}
+
+// onlyCSM returns the single CSM16 section of the image and its file.
+func onlyCSM(f uefi.Firmware) (*uefi.Section, *uefi.File, error) {
+	matches, files, err := findCSM(f)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch len(matches) {
+	case 0:
+		return nil, nil, errors.New("no CSM16 binary found")
+	case 1:
+		return matches[0], files[0], nil
+	}
+	return nil, nil, fmt.Errorf("found %d CSM16 candidates, expected one", len(matches))
+}
+
+// ExtractCSM writes the CSM16 legacy BIOS binary to OutFile.
+type ExtractCSM struct {
+	// Input
+	OutFile string
+
+	// Output
+	Section *uefi.Section
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ExtractCSM) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ExtractCSM visitor to any Firmware type.
+func (v *ExtractCSM) Visit(f uefi.Firmware) error {
+	s, _, err := onlyCSM(f)
+	if err != nil {
+		return err
+	}
+	v.Section = s
+	return writeFile(v.OutFile, sectionPayload(s), 0666)
+}
+
+// ReplaceCSM replaces the CSM16 legacy BIOS binary with NewCSM.
+type ReplaceCSM struct {
+	// Input
+	NewCSM []byte
+
+	// Output
+	Section *uefi.Section
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ReplaceCSM) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ReplaceCSM visitor to any Firmware type.
+func (v *ReplaceCSM) Visit(f uefi.Firmware) error {
+	s, file, err := onlyCSM(f)
+	if err != nil {
+		return err
+	}
+	if err := checkProtected("replace_csm", []*uefi.File{file}); err != nil {
+		return err
+	}
+	v.Section = s
+	s.SetBuf(append([]byte{}, v.NewCSM...))
+	return s.GenSecHeader()
+}
+
+func init() {
+	Register(CLI{
+		Name: "extract_csm",
+		Args: []string{"FILE"},
+		Help: "Write the CSM16 legacy BIOS binary to FILE.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &ExtractCSM{
+				OutFile: args[0],
+			}, nil
+		},
+	})
+	Register(CLI{
+		Name: "replace_csm",
+		Args: []string{"FILE"},
+		Help: "Replace the CSM16 legacy BIOS binary with FILE.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			newCSM, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &ReplaceCSM{
+				NewCSM: newCSM,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestCSM(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	fv, err := uefi.NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := onlyCSM(fv); err == nil {
+		t.Fatal("expected no CSM in the sample FV")
+	}
+
+	// Add a Compatibility16 section to the SEC core, and drop the pad file to
+	// make space for it.
+	csm16 := bytes.Repeat([]byte{0xea, 0x5b, 0xe0, 0x00, 0xf0}, 16)
+	s, err := uefi.NewSection(append([]byte{byte(4 + len(csm16)), 0, 0, byte(uefi.SectionTypeCompatibility16)}, csm16...), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fv.Files[0].Sections = append(fv.Files[0].Sections, s)
+	fv.Files = append(fv.Files[:1], fv.Files[2:]...)
+
+	tmpDir, err := ioutil.TempDir("", "csm-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	out := filepath.Join(tmpDir, "csm16.bin")
+	if err := (&ExtractCSM{OutFile: out}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, csm16) {
+		t.Errorf("extracted CSM mismatch")
+	}
+
+	newCSM := bytes.Repeat([]byte{0xcb}, 0x100)
+	if err := (&ReplaceCSM{NewCSM: newCSM}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	nfv, err := uefi.NewFirmwareVolume(fv.Buf(), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns, _, err := onlyCSM(nfv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(sectionPayload(ns), newCSM) {
+		t.Errorf("CSM was not replaced")
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var depexCheck = flag.String("depex-check", "warn",
+	"handling of remaining modules whose depex needs a protocol only the removed files produce, off, warn or refuse")
+
+// Dependent is a remaining file whose dependency expression pushes a
+// protocol which only removed files were found to produce.
+type Dependent struct {
+	File      *uefi.File
+	Protocol  uuid.UUID
+	Producers []*uefi.File
+}
+
+func (d Dependent) String() string {
+	var producers []string
+	for _, p := range d.Producers {
+		producers = append(producers, fileLabel(p))
+	}
+	return fmt.Sprintf("%s depends on protocol %v, only found in %s", fileLabel(d.File), d.Protocol, strings.Join(producers, ", "))
+}
+
+// fileLabel returns the name and GUID of a file.
+func fileLabel(f *uefi.File) string {
+	if name := fileName(f); name != "" {
+		return fmt.Sprintf("%s (%v)", name, f.Header.UUID)
+	}
+	return f.Header.UUID.String()
+}
+
+// DependencyError is returned when removing files would leave dependents
+// which are never dispatched.
+type DependencyError struct {
+	Op         string
+	Dependents []Dependent
+}
+
+func (e *DependencyError) Error() string {
+	var lines []string
+	for _, d := range e.Dependents {
+		lines = append(lines, d.String())
+	}
+	return fmt.Sprintf("%s: refusing to remove files other modules depend on:\n\t%s", e.Op, strings.Join(lines, "\n\t"))
+}
+
+// allFiles returns the files of f, including the files of nested FVs.
+func allFiles(f uefi.Firmware) ([]*uefi.File, error) {
+	find := Find{Predicate: func(f *uefi.File, name string) bool {
+		return true
+	}}
+	err := find.Run(f)
+	return find.Matches, err
+}
+
+// walkSections calls fn for each section of the file, also the encapsulated
+// ones, but not the sections of files in nested FVs.
+func walkSections(sections []*uefi.Section, fn func(s *uefi.Section)) {
+	for _, s := range sections {
+		fn(s)
+		var encapsulated []*uefi.Section
+		for _, e := range s.Encapsulated {
+			if es, ok := e.Value.(*uefi.Section); ok {
+				encapsulated = append(encapsulated, es)
+			}
+		}
+		walkSections(encapsulated, fn)
+	}
+}
+
+// depexTypes are the dependency expression section types.
+var depexTypes = map[uefi.SectionType]bool{
+	uefi.SectionTypeDXEDepEx: true,
+	uefi.SectionTypePEIDepEx: true,
+	uefi.SectionMMDepEx:      true,
+}
+
+// holdsGUID returns whether the data of the file, except its dependency
+// expressions, holds the GUID.
+func holdsGUID(f *uefi.File, g uuid.UUID) bool {
+	if len(f.Sections) == 0 {
+		return bytes.Contains(f.Buf(), g[:])
+	}
+	found := false
+	walkSections(f.Sections, func(s *uefi.Section) {
+		if found || len(s.Encapsulated) != 0 || depexTypes[s.Header.Type] {
+			return
+		}
+		found = bytes.Contains(s.Buf(), g[:])
+	})
+	return found
+}
+
+// pushedGUIDs returns the protocol GUIDs pushed by the dependency expressions
+// of the file.
+func pushedGUIDs(f *uefi.File) []uuid.UUID {
+	var guids []uuid.UUID
+	walkSections(f.Sections, func(s *uefi.Section) {
+		for _, op := range s.DepEx {
+			if op.OpCode == "PUSH" && op.GUID != nil {
+				guids = append(guids, *op.GUID)
+			}
+		}
+	})
+	return guids
+}
+
+// FindDependents returns the files of f, other than the removed ones, whose
+// dependency expressions push a protocol which only removed files produce.
+// Producers are not recorded in the image, a file holding the GUID of the
+// protocol outside of its dependency expressions is assumed to produce it,
+// unless its dependency expression pushes it too. Protocols no removed file
+// holds are ignored, as are protocols also held by another remaining file,
+// which may only consume them.
+func FindDependents(f uefi.Firmware, removed []*uefi.File) ([]Dependent, error) {
+	// Files in FVs nested in removed files are removed too.
+	gone := map[*uefi.File]bool{}
+	var goneList []*uefi.File
+	for _, r := range removed {
+		nested, err := allFiles(r)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range append([]*uefi.File{r}, nested...) {
+			if !gone[n] {
+				gone[n] = true
+				goneList = append(goneList, n)
+			}
+		}
+	}
+	files, err := allFiles(f)
+	if err != nil {
+		return nil, err
+	}
+	var remaining []*uefi.File
+	for _, file := range files {
+		if !gone[file] {
+			remaining = append(remaining, file)
+		}
+	}
+
+	pushes := map[*uefi.File]map[uuid.UUID]bool{}
+	for _, file := range remaining {
+		pushes[file] = map[uuid.UUID]bool{}
+		for _, g := range pushedGUIDs(file) {
+			pushes[file][g] = true
+		}
+	}
+
+	producers := map[uuid.UUID][]*uefi.File{}
+	holders := map[uuid.UUID][]*uefi.File{}
+	var deps []Dependent
+	for _, file := range remaining {
+		seen := map[uuid.UUID]bool{}
+		for _, g := range pushedGUIDs(file) {
+			if seen[g] {
+				continue
+			}
+			seen[g] = true
+			p, ok := producers[g]
+			if !ok {
+				for _, r := range goneList {
+					if holdsGUID(r, g) {
+						p = append(p, r)
+					}
+				}
+				producers[g] = p
+				if len(p) != 0 {
+					for _, other := range remaining {
+						if !pushes[other][g] && holdsGUID(other, g) {
+							holders[g] = append(holders[g], other)
+						}
+					}
+				}
+			}
+			if len(p) != 0 && len(holders[g]) == 0 {
+				deps = append(deps, Dependent{File: file, Protocol: g, Producers: p})
+			}
+		}
+	}
+	return deps, nil
+}
+
+// checkDependents handles the dependents of the removed files according to
+// the -depex-check flag.
+func checkDependents(op string, f uefi.Firmware, removed []*uefi.File) error {
+	switch *depexCheck {
+	case "off":
+		return nil
+	case "warn", "refuse":
+	default:
+		return fmt.Errorf("%s: unknown -depex-check %q, expected off, warn or refuse", op, *depexCheck)
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	deps, err := FindDependents(f, removed)
+	if err != nil || len(deps) == 0 {
+		return err
+	}
+	if *depexCheck == "refuse" {
+		return &DependencyError{Op: op, Dependents: deps}
+	}
+	for _, d := range deps {
+		log.Printf("warning: %s: %v", op, d)
+	}
+	return nil
+}
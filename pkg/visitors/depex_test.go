@@ -0,0 +1,102 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// pcdProtocol is pushed by the depex of most OVMF drivers. Outside of
+// depexes, only PcdDxe and the Shell hold it.
+var pcdProtocol = *uuid.MustParse("13A3F0F6-264A-3EF0-F2E0-DEC512342F34")
+
+func namePredicate(names ...string) func(f *uefi.File, name string) bool {
+	return func(f *uefi.File, name string) bool {
+		for _, n := range names {
+			if name == n {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func TestFindDependents(t *testing.T) {
+	f := parseImage(t)
+	for _, test := range []struct {
+		names []string
+		want  bool
+	}{
+		{[]string{"PcdDxe"}, false},
+		{[]string{"Shell"}, false},
+		{[]string{"PcdDxe", "Shell"}, true},
+	} {
+		find := &Find{Predicate: namePredicate(test.names...)}
+		if err := find.Run(f); err != nil {
+			t.Fatal(err)
+		}
+		deps, err := FindDependents(f, find.Matches)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !test.want {
+			if len(deps) != 0 {
+				t.Errorf("removing %v: expected no dependents, got %v", test.names, deps)
+			}
+			continue
+		}
+		var runtime bool
+		for _, d := range deps {
+			if d.Protocol != pcdProtocol || len(d.Producers) != 2 {
+				t.Errorf("removing %v: unexpected dependent %v", test.names, d)
+			}
+			if fileName(d.File) == "PcdDxe" || fileName(d.File) == "Shell" {
+				t.Errorf("removing %v: removed file %v reported as dependent", test.names, d)
+			}
+			runtime = runtime || fileName(d.File) == "RuntimeDxe"
+		}
+		if !runtime {
+			t.Errorf("removing %v: expected RuntimeDxe to depend on the PCD protocol, got %v", test.names, deps)
+		}
+	}
+}
+
+func TestDepexCheck(t *testing.T) {
+	defer func(mode string) { *depexCheck = mode }(*depexCheck)
+
+	*depexCheck = "refuse"
+	f := parseImage(t)
+	err := (&Remove{Predicate: namePredicate("PcdDxe", "Shell")}).Run(f)
+	if derr, ok := err.(*DependencyError); !ok || derr.Op != "remove" {
+		t.Fatalf("expected a dependency error, got %v", err)
+	}
+	find := &Find{Predicate: namePredicate("PcdDxe", "Shell")}
+	if err := find.Run(f); err != nil || len(find.Matches) != 2 {
+		t.Errorf("expected the files to be kept, got %v", find.Matches)
+	}
+
+	// The list visitors check all removed files at once.
+	patterns, err := ParseRegexps("^PcdDxe$,^Shell$")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = (&DXEList{Patterns: patterns}).Run(f)
+	if _, ok := err.(*DependencyError); !ok {
+		t.Errorf("expected a dependency error, got %v", err)
+	}
+
+	*depexCheck = "warn"
+	if err := (&Remove{Predicate: namePredicate("PcdDxe", "Shell")}).Run(f); err != nil {
+		t.Error(err)
+	}
+
+	*depexCheck = "bogus"
+	if err := (&Remove{Predicate: namePredicate("Ip4Dxe")}).Run(f); err == nil {
+		t.Error("expected an error for an unknown mode")
+	}
+}
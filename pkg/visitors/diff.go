@@ -0,0 +1,296 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var diffJSON = flag.Bool("diff-json", false, "print the differences found by diff as JSON")
+
+// Diff compares the firmware with Other node by node. Unlike a binary diff,
+// it reports which nodes were added or removed, and for the others which of
+// their fields and whether their contents changed.
+type Diff struct {
+	// Input
+	Other uefi.Firmware
+	// W is where the differences are printed, if set.
+	W io.Writer
+	// JSON prints the differences as JSON instead of text.
+	JSON bool
+
+	// Output
+	Entries []DiffEntry
+}
+
+// DiffEntry is a node which differs between the trees.
+type DiffEntry struct {
+	// Path is the path of the node as in Ls, in the tree it is found in,
+	// or in the other tree for changed nodes.
+	Path string
+	// Change is "added", "removed" or "changed".
+	Change string
+	GUID   string `json:",omitempty"`
+	Name   string `json:",omitempty"`
+	Type   string `json:",omitempty"`
+	// Fields are the changed fields of the node itself, its children are
+	// compared separately.
+	Fields []DiffField `json:",omitempty"`
+	// OldHash and NewHash are the SHA-256 of the node contents, if they
+	// changed.
+	OldHash string `json:",omitempty"`
+	NewHash string `json:",omitempty"`
+}
+
+// DiffField is a changed field. Nested fields are separated by ".".
+type DiffField struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// diffSkipFields are the fields of the JSON which are not compared, as they
+// hold the children or depend on where the tree was extracted.
+var diffSkipFields = map[string]bool{
+	"IFD": true, "BIOS": true, "ME": true, "GBE": true, "PD": true, "EC": true, "Regions": true,
+	"Elements": true, "Files": true, "VariableStore": true, "Sections": true, "Encapsulated": true,
+	"ExtractPath": true, "FlashOffset": true, "FlashLength": true,
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Diff) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit compares the whole tree below f, it is only called for the root.
+func (v *Diff) Visit(f uefi.Firmware) error {
+	v.Entries = nil
+	if err := v.diff("", node{Firmware: f, InFlash: true}, node{Firmware: v.Other, InFlash: true}); err != nil {
+		return err
+	}
+	if v.W == nil {
+		return nil
+	}
+	if v.JSON {
+		b, err := json.MarshalIndent(v.Entries, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(v.W, string(b))
+		return err
+	}
+	return v.printText()
+}
+
+// diff compares the nodes a and b at the same path and then their children.
+func (v *Diff) diff(path string, a, b node) error {
+	fields, err := diffFields(a.Firmware, b.Firmware)
+	if err != nil {
+		return err
+	}
+	e := diffEntry(path, "changed", b.Firmware)
+	e.Fields = fields
+	if oldHash, newHash := hashBuf(a.Buf()), hashBuf(b.Buf()); oldHash != newHash {
+		e.OldHash, e.NewHash = oldHash, newHash
+	}
+	if len(e.Fields) != 0 || e.OldHash != "" {
+		v.Entries = append(v.Entries, e)
+	}
+
+	// Children are matched by GUID, or by type if they have none, and by
+	// their order among the children with the same GUID or type.
+	oldChildren, newChildren := children(a), children(b)
+	oldKeys, newKeys := childKeys(oldChildren), childKeys(newChildren)
+	newIndex := map[string]int{}
+	for i, k := range newKeys {
+		newIndex[k] = i
+	}
+	matched := map[string]bool{}
+	for i, k := range oldKeys {
+		j, ok := newIndex[k]
+		if !ok {
+			v.Entries = append(v.Entries, diffEntry(fmt.Sprintf("%s/%d", path, i), "removed", oldChildren[i].Firmware))
+			continue
+		}
+		matched[k] = true
+		if err := v.diff(fmt.Sprintf("%s/%d", path, j), oldChildren[i], newChildren[j]); err != nil {
+			return err
+		}
+	}
+	for j, k := range newKeys {
+		if !matched[k] {
+			v.Entries = append(v.Entries, diffEntry(fmt.Sprintf("%s/%d", path, j), "added", newChildren[j].Firmware))
+		}
+	}
+	return nil
+}
+
+func diffEntry(path, change string, f uefi.Firmware) DiffEntry {
+	if path == "" {
+		path = "/"
+	}
+	guid, name, typez := nodeInfo(f)
+	return DiffEntry{Path: path, Change: change, GUID: guid, Name: name, Type: typez}
+}
+
+// childKeys returns the keys matching the children between the trees.
+func childKeys(nodes []node) []string {
+	count := map[string]int{}
+	keys := make([]string, len(nodes))
+	for i, n := range nodes {
+		guid, _, typez := nodeInfo(n.Firmware)
+		k := guid
+		if k == "" {
+			k = typez
+		}
+		keys[i] = fmt.Sprintf("%s#%d", k, count[k])
+		count[k]++
+	}
+	return keys
+}
+
+func hashBuf(buf []byte) string {
+	sum := sha256.Sum256(buf)
+	return hex.EncodeToString(sum[:])
+}
+
+// diffFields compares the JSON of two nodes, without their children.
+func diffFields(a, b uefi.Firmware) ([]DiffField, error) {
+	oldFields, err := flatFields(a)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := flatFields(b)
+	if err != nil {
+		return nil, err
+	}
+	names := map[string]bool{}
+	for k := range oldFields {
+		names[k] = true
+	}
+	for k := range newFields {
+		names[k] = true
+	}
+	var fields []DiffField
+	for k := range names {
+		o, n := oldFields[k], newFields[k]
+		if !reflect.DeepEqual(o, n) {
+			fields = append(fields, DiffField{Field: k, Old: o, New: n})
+		}
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Field < fields[j].Field })
+	return fields, nil
+}
+
+// flatFields returns the scalar fields of the JSON of a node by their dotted
+// name.
+func flatFields(f uefi.Firmware) (map[string]interface{}, error) {
+	b, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	fields := map[string]interface{}{}
+	for k, val := range m {
+		if !diffSkipFields[k] {
+			flatten(k, val, fields)
+		}
+	}
+	return fields, nil
+}
+
+func flatten(prefix string, val interface{}, fields map[string]interface{}) {
+	switch val := val.(type) {
+	case map[string]interface{}:
+		for k, v := range val {
+			flatten(prefix+"."+k, v, fields)
+		}
+	case []interface{}:
+		for i, v := range val {
+			flatten(fmt.Sprintf("%s[%d]", prefix, i), v, fields)
+		}
+	default:
+		fields[prefix] = val
+	}
+}
+
+// printText prints the differences like a unified diff, with "-" for removed,
+// "+" for added and "~" for changed nodes.
+func (v *Diff) printText() error {
+	for _, e := range v.Entries {
+		prefix := map[string]string{"added": "+", "removed": "-", "changed": "~"}[e.Change]
+		desc := []string{}
+		for _, s := range []string{e.GUID, e.Name, e.Type} {
+			if s != "" {
+				desc = append(desc, s)
+			}
+		}
+		if _, err := fmt.Fprintf(v.W, "%s %s %s\n", prefix, e.Path, strings.Join(desc, " ")); err != nil {
+			return err
+		}
+		for _, f := range e.Fields {
+			if _, err := fmt.Fprintf(v.W, "    %s: %v -> %v\n", f.Field, diffValue(f.Old), diffValue(f.New)); err != nil {
+				return err
+			}
+		}
+		if e.OldHash != "" {
+			if _, err := fmt.Fprintf(v.W, "    sha256: %.16s -> %.16s\n", e.OldHash, e.NewHash); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func diffValue(val interface{}) string {
+	if val == nil {
+		return "(none)"
+	}
+	b, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprint(val)
+	}
+	return string(b)
+}
+
+func init() {
+	Register(CLI{
+		Name: "diff",
+		Args: []string{"IMAGE"},
+		Help: "Compare the image with IMAGE node by node, printing the added, removed and changed nodes " +
+			"with their changed fields and content hashes. With -diff-json the differences are printed as JSON.",
+		Flags: []string{"diff-json"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			image, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			other, err := uefi.Parse(image)
+			if err != nil {
+				return nil, err
+			}
+			return &Diff{
+				Other: other,
+				W:     os.Stdout,
+				JSON:  *diffJSON,
+			}, nil
+		},
+	})
+}
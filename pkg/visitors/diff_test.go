@@ -0,0 +1,89 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestDiff(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	parse := func() *uefi.FirmwareVolume {
+		fv, err := uefi.NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fv
+	}
+	orig := parse()
+
+	// Identical trees have no differences.
+	d := &Diff{Other: parse()}
+	if err := d.Run(orig); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Entries) != 0 {
+		t.Fatalf("expected no differences, got %+v", d.Entries)
+	}
+
+	modified := parse()
+	if err := (&SetName{Predicate: FindFileTypePredicate(uefi.FVFileTypeSECCore), Name: "Renamed"}).Run(modified); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Remove{Predicate: FindFileTypePredicate(uefi.FVFileTypeRaw)}).Run(modified); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(modified); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	d = &Diff{Other: modified, W: &out}
+	if err := d.Run(orig); err != nil {
+		t.Fatal(err)
+	}
+	changes := map[string]DiffEntry{}
+	for _, e := range d.Entries {
+		changes[e.Change+" "+e.Path] = e
+	}
+	if e, ok := changes["removed /2"]; !ok || e.Type != "EFI_FV_FILETYPE_RAW" {
+		t.Errorf("raw file not reported as removed: %+v", d.Entries)
+	}
+	ui, ok := changes["changed /0/1"]
+	if !ok {
+		t.Fatalf("UI section not reported as changed: %+v", d.Entries)
+	}
+	var nameChanged bool
+	for _, f := range ui.Fields {
+		if f.Field == "Name" && f.Old == "SecMain" && f.New == "Renamed" {
+			nameChanged = true
+		}
+	}
+	if !nameChanged || ui.OldHash == ui.NewHash {
+		t.Errorf("unexpected changes of the UI section: %+v", ui)
+	}
+	for _, s := range []string{"- /2 1BA0062E-C779-4582-8566-336AE8F78F09 EFI_FV_FILETYPE_RAW\n", `    Name: "SecMain" -> "Renamed"`} {
+		if !strings.Contains(out.String(), s) {
+			t.Errorf("%q missing in the output:\n%s", s, out.String())
+		}
+	}
+
+	out.Reset()
+	d.JSON = true
+	if err := d.Run(orig); err != nil {
+		t.Fatal(err)
+	}
+	var entries []DiffEntry
+	if err := json.Unmarshal(out.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(d.Entries) {
+		t.Errorf("got %d entries in the JSON, want %d", len(entries), len(d.Entries))
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Dump writes a file matching Predicate, or the payload of one of its
+// sections, to OutFile.
+type Dump struct {
+	// Input
+	Predicate func(f *uefi.File, name string) bool
+	// If DumpSection is set, the payload of the first section of SectionType
+	// is dumped instead of the whole file. Sections inside compressed sections
+	// are searched too, so the payload is decompressed.
+	DumpSection bool
+	SectionType uefi.SectionType
+	OutFile     string
+
+	// Output
+	Match *uefi.File
+	Buf   []byte
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Dump) Run(f uefi.Firmware) error {
+	find := Find{
+		Predicate: v.Predicate,
+	}
+	if err := find.Run(f); err != nil {
+		return err
+	}
+	if len(find.Matches) != 1 {
+		return fmt.Errorf("dump requires exactly one matching file, got %d", len(find.Matches))
+	}
+	v.Match = find.Matches[0]
+	if err := v.Match.Apply(v); err != nil {
+		return err
+	}
+	if v.Buf == nil {
+		return fmt.Errorf("file %v has no %v section", v.Match.Header.UUID, v.SectionType)
+	}
+	return writeFile(v.OutFile, v.Buf, 0666)
+}
+
+// Visit applies the Dump visitor to any Firmware type.
+func (v *Dump) Visit(f uefi.Firmware) error {
+	if v.Buf != nil {
+		// Already found.
+		return nil
+	}
+	switch f := f.(type) {
+	case *uefi.File:
+		if !v.DumpSection {
+			// The file is dumped with its header, like a .ffs file.
+			v.Buf = f.Buf()
+			return nil
+		}
+		return f.ApplyChildren(v)
+
+	case *uefi.Section:
+		if f.Header.Type == v.SectionType {
+			v.Buf = sectionPayload(f)
+			return nil
+		}
+		return f.ApplyChildren(v)
+
+	default:
+		// Only look inside the matched file.
+		return nil
+	}
+}
+
+// sectionPayload returns the data of a section without its headers.
+func sectionPayload(s *uefi.Section) []byte {
+	offset := sectionHeaderLen(s)
+	switch s.Header.Type {
+	case uefi.SectionTypeGUIDDefined:
+		if s.TypeSpecific == nil {
+			break
+		}
+		if gd, ok := s.TypeSpecific.Header.(*uefi.SectionGUIDDefined); ok {
+			offset = uint64(gd.DataOffset)
+		}
+	case uefi.SectionTypeFreeformSubtypeGUID:
+		offset += 16
+	}
+	buf := s.Buf()
+	if offset > uint64(len(buf)) {
+		return []byte{}
+	}
+	return buf[offset:]
+}
+
+func init() {
+	Register(CLI{
+		Name: "dump",
+		Args: []string{"(GUID|NAME)", "FILE"},
+		Help: "Write the one file which matches the given GUID or NAME, including its header, to FILE.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			searchRE, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &Dump{
+				Predicate: func(f *uefi.File, name string) bool {
+					return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
+				},
+				OutFile: args[1],
+			}, nil
+		},
+	})
+	Register(CLI{
+		Name: "dump_section",
+		Args: []string{"(GUID|NAME)", "TYPE", "FILE"},
+		Help: "Write the payload of the first section of TYPE (e.g. PE32) of the matching file to FILE. Compressed sections are searched.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			searchRE, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			t, err := uefi.ParseSectionType(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return &Dump{
+				Predicate: func(f *uefi.File, name string) bool {
+					return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
+				},
+				DumpSection: true,
+				SectionType: t,
+				OutFile:     args[2],
+			}, nil
+		},
+	})
+}
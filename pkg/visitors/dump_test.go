@@ -0,0 +1,65 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+func TestDump(t *testing.T) {
+	f := parseImage(t)
+	dxeCore := uuid.MustParse("D6A2CB7F-6A18-4E2F-B43B-9920A733700A")
+	byGUID := func(g *uuid.UUID) func(f *uefi.File, name string) bool {
+		return func(f *uefi.File, name string) bool {
+			return f.Header.UUID == *g
+		}
+	}
+
+	tmpDir, err := ioutil.TempDir("", "dump-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var tests = []struct {
+		name        string
+		dump        *Dump
+		checkPrefix []byte
+	}{
+		// A whole file starts with its GUID.
+		{"file", &Dump{Predicate: byGUID(testGUID)}, testGUID[:]},
+		// The DXE core is inside the compressed FV.
+		{"section", &Dump{Predicate: byGUID(dxeCore), DumpSection: true, SectionType: uefi.SectionTypePE32},
+			[]byte("MZ")},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.dump.OutFile = filepath.Join(tmpDir, test.name+".bin")
+			if err := test.dump.Run(f); err != nil {
+				t.Fatal(err)
+			}
+			buf, err := ioutil.ReadFile(test.dump.OutFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf, test.dump.Buf) || !bytes.HasPrefix(buf, test.checkPrefix) {
+				t.Errorf("unexpected dump, starts with %x", buf[:16])
+			}
+		})
+	}
+
+	missing := &Dump{Predicate: byGUID(testGUID), DumpSection: true, SectionType: uefi.SectionTypeTE,
+		OutFile: filepath.Join(tmpDir, "missing.bin")}
+	if err := missing.Run(f); err == nil {
+		t.Errorf("expected an error dumping a missing section")
+	}
+}
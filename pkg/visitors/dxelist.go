@@ -0,0 +1,144 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// readList calls parse with each line of the file at path. Blank lines and
+// lines starting with # are skipped.
+func readList(path string, parse func(line string) error) error {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := parse(line); err != nil {
+			return fmt.Errorf("%v:%d: %v", path, i+1, err)
+		}
+	}
+	return nil
+}
+
+// ReadPatternList reads a list of GUIDs or name patterns, one regular
+// expression per line. Blank lines and lines starting with # are ignored.
+func ReadPatternList(path string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	err := readList(path, func(line string) error {
+		re, err := regexp.Compile(line)
+		if err != nil {
+			return err
+		}
+		res = append(res, re)
+		return nil
+	})
+	return res, err
+}
+
+// DXEList removes the DXE drivers matching the patterns, or with Keep set,
+// all DXE drivers except those matching the patterns. The patterns match the
+// name or GUID of the files. Other file types, such as the DXE core, are
+// always kept.
+type DXEList struct {
+	// Input
+	Patterns []*regexp.Regexp
+	Keep     bool
+
+	// Output
+	Removed []*uefi.File
+	matched map[*regexp.Regexp]bool
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *DXEList) Run(f uefi.Firmware) error {
+	v.matched = map[*regexp.Regexp]bool{}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	for _, re := range v.Patterns {
+		if !v.matched[re] {
+			log.Printf("no DXE driver matches %q", re)
+		}
+	}
+	op := "remove_list"
+	if v.Keep {
+		op = "keep_list"
+	}
+	if err := checkProtected(op, v.Removed); err != nil {
+		return err
+	}
+	if err := checkDependents(op, f, v.Removed); err != nil {
+		return err
+	}
+	return f.Apply(&Remove{Matches: v.Removed})
+}
+
+// matches returns whether the file matches any of the patterns.
+func (v *DXEList) matches(f *uefi.File) bool {
+	name := fileName(f)
+	guid := f.Header.UUID.String()
+	found := false
+	for _, re := range v.Patterns {
+		if re.MatchString(name) || re.MatchString(guid) {
+			v.matched[re] = true
+			found = true
+		}
+	}
+	return found
+}
+
+// Visit applies the DXEList visitor to any Firmware type. It only collects
+// the files to remove, they are removed by Run.
+func (v *DXEList) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		for _, file := range f.Files {
+			if dxeDriverTypes[file.Header.Type] && v.matches(file) != v.Keep {
+				v.Removed = append(v.Removed, file)
+			}
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	Register(CLI{
+		Name:  "remove_list",
+		Args:  []string{"LIST"},
+		Help:  "Remove the DXE drivers whose name or GUID matches a line of the LIST file. Each line is a regular expression, blank lines and lines starting with # are ignored.",
+		Flags: []string{"depex-check"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			patterns, err := ReadPatternList(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &DXEList{Patterns: patterns}, nil
+		},
+	})
+	Register(CLI{
+		Name:  "keep_list",
+		Args:  []string{"LIST"},
+		Help:  "Remove all DXE drivers except those whose name or GUID matches a line of the LIST file, in the format of remove_list. Other file types are kept.",
+		Flags: []string{"depex-check"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			patterns, err := ReadPatternList(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &DXEList{Patterns: patterns, Keep: true}, nil
+		},
+	})
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func writeList(t *testing.T, list string) string {
+	tmp, err := ioutil.TempFile("", "list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+	if _, err := tmp.WriteString(list); err != nil {
+		t.Fatal(err)
+	}
+	return tmp.Name()
+}
+
+// dxeDrivers returns the names of the DXE drivers in f.
+func dxeDrivers(t *testing.T, f uefi.Firmware) []string {
+	find := &Find{Predicate: func(f *uefi.File, name string) bool {
+		return dxeDriverTypes[f.Header.Type]
+	}}
+	if err := find.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range find.Matches {
+		names = append(names, fileName(f))
+	}
+	return names
+}
+
+func TestDXEList(t *testing.T) {
+	list := writeList(t, `# Network drivers
+^Ip4Dxe$
+  ^Udp4Dxe$
+
+7C04A583-9E3E-4F1C-AD65-E05268D0B4D1
+`)
+	defer os.Remove(list)
+	patterns, err := ReadPatternList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(patterns) != 3 {
+		t.Fatalf("expected 3 patterns, got %v", patterns)
+	}
+
+	f := parseImage(t)
+	all := dxeDrivers(t, f)
+	remove := &DXEList{Patterns: patterns}
+	if err := remove.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(remove.Removed) != 3 {
+		t.Errorf("expected Ip4Dxe, Udp4Dxe and the Shell to be removed, got %v", remove.Removed)
+	}
+	if left := dxeDrivers(t, f); len(left) != len(all)-3 {
+		t.Errorf("expected %d drivers left, got %d", len(all)-3, len(left))
+	}
+
+	f = parseImage(t)
+	keep := &DXEList{Patterns: patterns, Keep: true}
+	if err := keep.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if left := dxeDrivers(t, f); strings.Join(left, ",") != "Shell,Ip4Dxe,Udp4Dxe" {
+		t.Errorf("expected 3 drivers kept, got %v", left)
+	}
+	if core := (&Find{Predicate: FindFileTypePredicate(uefi.FVFileTypeDXECore)}); core.Run(f) != nil || len(core.Matches) != 1 {
+		t.Error("expected the DXE core to be kept")
+	}
+}
+
+func TestReadPatternListError(t *testing.T) {
+	list := writeList(t, "Shell\n(unclosed\n")
+	defer os.Remove(list)
+	if _, err := ReadPatternList(list); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Errorf("expected an error on line 2, got %v", err)
+	}
+}
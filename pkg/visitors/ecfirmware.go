@@ -0,0 +1,258 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var ecIndex = flag.Int("ec-index", -1, "index of the EC firmware extracted or replaced by extract_ec and replace_ec, in the order of the ec operation, needed if there are several")
+
+// ECFirmwareNode is EC firmware found in the BIOS region, in the padding, in
+// a raw file or in a raw section.
+type ECFirmwareNode struct {
+	Vendor string
+	// Path is the path of the node holding the firmware, see ls.
+	Path string
+	// File and Name are those of the file holding the firmware, if any.
+	File *uuid.UUID `json:",omitempty"`
+	Name string     `json:",omitempty"`
+	// Offset is the offset of the firmware in the image, if it is not in a
+	// compressed section.
+	Offset  uint64
+	InFlash bool
+	Size    uint64
+
+	node uefi.Firmware
+	file *uefi.File
+	ec   *uefi.ECFirmware
+}
+
+// Buf returns the EC firmware.
+func (n *ECFirmwareNode) Buf() []byte {
+	switch f := n.node.(type) {
+	case *uefi.BIOSPadding:
+		return f.Buf()[n.ec.Offset:]
+	case *uefi.File:
+		return f.Data()
+	case *uefi.Section:
+		return sectionPayload(f)
+	}
+	return nil
+}
+
+// replace replaces the EC firmware with buf, of the same vendor. Firmware in
+// the padding may not grow, the rest of its space is erased. Firmware in
+// files and sections may change size.
+func (n *ECFirmwareNode) replace(buf []byte) error {
+	ec := uefi.IdentifyECFirmware(buf)
+	if ec == nil || ec.Vendor != n.Vendor {
+		return fmt.Errorf("the new firmware is not %v EC firmware", n.Vendor)
+	}
+	switch f := n.node.(type) {
+	case *uefi.BIOSPadding:
+		old := n.Buf()
+		if len(buf) > len(old) {
+			return fmt.Errorf("the new EC firmware of %#x bytes does not fit into the %#x bytes of the padding", len(buf), len(old))
+		}
+		uefi.Erase(old[copy(old, buf):], uefi.Attributes.ErasePolarity)
+	case *uefi.File:
+		fBuf := append(append([]byte{}, f.Buf()[:f.DataOffset]...), buf...)
+		if f.HasTail() {
+			// The tail is rewritten by Assemble.
+			fBuf = append(fBuf, make([]byte, uefi.FileTailLength)...)
+		}
+		f.SetBuf(fBuf)
+		f.EC = ec
+	case *uefi.Section:
+		f.SetBuf(append([]byte{}, buf...))
+		if err := f.GenSecHeader(); err != nil {
+			return err
+		}
+		f.EC = ec
+	}
+	n.Size = uint64(len(buf))
+	return nil
+}
+
+// FindECFirmware returns the EC firmware of the image.
+func FindECFirmware(f uefi.Firmware) []*ECFirmwareNode {
+	var nodes []*ECFirmwareNode
+	var walk func(n node, path string, file *uefi.File)
+	walk = func(n node, path string, file *uefi.File) {
+		var ec *uefi.ECFirmware
+		switch f := n.Firmware.(type) {
+		case *uefi.BIOSPadding:
+			ec = f.EC
+		case *uefi.File:
+			ec, file = f.EC, f
+		case *uefi.Section:
+			ec = f.EC
+		}
+		if ec != nil {
+			en := &ECFirmwareNode{Vendor: ec.Vendor, Path: path, InFlash: n.InFlash, node: n.Firmware, ec: ec}
+			if file != nil {
+				en.File, en.Name, en.file = &file.Header.UUID, fileName(file), file
+			}
+			en.Size = uint64(len(en.Buf()))
+			if n.InFlash {
+				en.Offset = n.Offset + uint64(len(n.Buf())) - en.Size
+			}
+			nodes = append(nodes, en)
+		}
+		for i, c := range children(n) {
+			walk(c, fmt.Sprintf("%s/%d", path, i), file)
+		}
+	}
+	walk(node{Firmware: f, InFlash: true}, "", nil)
+	return nodes
+}
+
+// selectECFirmware returns the EC firmware with index, or the only one if
+// index is negative.
+func selectECFirmware(nodes []*ECFirmwareNode, index int) (*ECFirmwareNode, error) {
+	switch {
+	case len(nodes) == 0:
+		return nil, fmt.Errorf("no EC firmware found")
+	case index >= len(nodes):
+		return nil, fmt.Errorf("no EC firmware %d, found %d", index, len(nodes))
+	case index >= 0:
+		return nodes[index], nil
+	case len(nodes) > 1:
+		return nil, fmt.Errorf("found %d EC firmwares, select one with -ec-index", len(nodes))
+	}
+	return nodes[0], nil
+}
+
+// ECFirmware prints the EC firmware of the image as JSON.
+type ECFirmware struct {
+	W io.Writer
+
+	// Output
+	Firmware []*ECFirmwareNode
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ECFirmware) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ECFirmware visitor to any Firmware type.
+func (v *ECFirmware) Visit(f uefi.Firmware) error {
+	v.Firmware = FindECFirmware(f)
+	if len(v.Firmware) == 0 {
+		return fmt.Errorf("no EC firmware found")
+	}
+	b, err := json.MarshalIndent(v.Firmware, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(v.W, string(b))
+	return err
+}
+
+// ExtractEC writes the EC firmware selected by Index to OutFile.
+type ExtractEC struct {
+	// Input
+	// Index of the firmware, in the order of the ec operation, or -1 if
+	// there is only one.
+	Index   int
+	OutFile string
+
+	// Output
+	Firmware *ECFirmwareNode
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ExtractEC) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	return writeFile(v.OutFile, v.Firmware.Buf(), 0666)
+}
+
+// Visit applies the ExtractEC visitor to any Firmware type.
+func (v *ExtractEC) Visit(f uefi.Firmware) error {
+	var err error
+	v.Firmware, err = selectECFirmware(FindECFirmware(f), v.Index)
+	return err
+}
+
+// ReplaceEC replaces the EC firmware selected by Index with Firmware, which
+// must be EC firmware of the same vendor. The image has to be assembled
+// afterwards.
+type ReplaceEC struct {
+	// Input
+	// Index of the firmware, in the order of the ec operation, or -1 if
+	// there is only one.
+	Index    int
+	Firmware []byte
+
+	// Output
+	Replaced *ECFirmwareNode
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ReplaceEC) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ReplaceEC visitor to any Firmware type.
+func (v *ReplaceEC) Visit(f uefi.Firmware) error {
+	n, err := selectECFirmware(FindECFirmware(f), v.Index)
+	if err != nil {
+		return err
+	}
+	if n.file != nil {
+		if err := checkProtected("replace_ec", []*uefi.File{n.file}); err != nil {
+			return err
+		}
+	}
+	if err := n.replace(v.Firmware); err != nil {
+		return fmt.Errorf("EC firmware at %v: %v", n.Path, err)
+	}
+	v.Replaced = n
+	return nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "ec",
+		Help: "Print the EC firmware found in the BIOS region, in the padding between the FVs, in raw files and in raw sections, as JSON. It is identified by the signatures of ITE, Microchip and Nuvoton controllers.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &ECFirmware{W: os.Stdout}, nil
+		},
+	})
+	Register(CLI{
+		Name:  "extract_ec",
+		Args:  []string{"FILE"},
+		Help:  "Write the EC firmware to FILE. If there are several, one is selected with -ec-index.",
+		Flags: []string{"ec-index"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &ExtractEC{Index: *ecIndex, OutFile: args[0]}, nil
+		},
+	})
+	Register(CLI{
+		Name:  "replace_ec",
+		Args:  []string{"FILE"},
+		Help:  "Replace the EC firmware with the EC firmware of the same vendor in FILE. If there are several, one is selected with -ec-index. Firmware in the padding between the FVs may not grow.",
+		Flags: []string{"ec-index"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			fw, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &ReplaceEC{Index: *ecIndex, Firmware: fw}, nil
+		},
+	})
+}
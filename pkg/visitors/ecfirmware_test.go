@@ -0,0 +1,113 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/testutil"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+func TestECFirmware(t *testing.T) {
+	fv, err := ioutil.ReadFile("../../integration/roms/ovmfSECFV.fv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	padding := bytes.Repeat([]byte{0xff}, 0x3000)
+	copy(padding[0x1000:], testutil.ITEFirmware(0x800, 0x12))
+	// The SEC FV holds the VTF, it stays at the top.
+	br, err := uefi.NewBIOSRegion(append(padding, fv...), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	v := &ECFirmware{W: &b}
+	if err := v.Run(br); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Firmware) != 1 {
+		t.Fatalf("expected EC firmware, got %+v", v.Firmware)
+	}
+	ec := v.Firmware[0]
+	offset := uint64(0x1000)
+	if ec.Vendor != "ITE" || ec.Path != "/0" || !ec.InFlash || ec.Offset != offset || ec.Size != 0x2000 {
+		t.Errorf("unexpected EC firmware %+v", ec)
+	}
+
+	tmpDir, err := ioutil.TempDir("", "ec-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	out := filepath.Join(tmpDir, "ec.bin")
+	if err := (&ExtractEC{Index: -1, OutFile: out}).Run(br); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ioutil.ReadFile(out); err != nil || !bytes.Equal(got, padding[offset:]) {
+		t.Errorf("unexpected extracted EC firmware, %v", err)
+	}
+
+	// Replacements are checked and the rest of the padding is erased.
+	if err := (&ReplaceEC{Index: -1, Firmware: testutil.ITEFirmware(0x3000, 0x34)}).Run(br); err == nil {
+		t.Error("expected an error for EC firmware larger than the padding")
+	}
+	if err := (&ReplaceEC{Index: 0, Firmware: []byte{0x5e, 0x4d, 0x3b, 0x2a}}).Run(br); err == nil {
+		t.Error("expected an error for EC firmware of another vendor")
+	}
+	ite := testutil.ITEFirmware(0x1000, 0x34)
+	if err := (&ReplaceEC{Index: -1, Firmware: ite}).Run(br); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(br); err != nil {
+		t.Fatal(err)
+	}
+	want := append(ite, bytes.Repeat([]byte{0xff}, 0x1000)...)
+	if !bytes.Equal(br.Buf()[offset:offset+0x2000], want) {
+		t.Error("unexpected EC firmware after the replacement")
+	}
+	if err := (&ExtractEC{Index: 1, OutFile: out}).Run(br); err == nil {
+		t.Error("expected an error for a missing EC firmware")
+	}
+}
+
+func TestReplaceECTailFile(t *testing.T) {
+	// A raw file of an FFS2 volume, whose large file bit marks a tail.
+	buf := testutil.RawFile(*uuid.MustParse("7A3E9C1B-5D2F-4E8A-B6C4-0F1E2D3C4B5A"), testutil.ITEFirmware(0x100, 0x12))
+	buf = append(buf, 0, 0)
+	buf[0x13] |= 0x01
+	buf[0x14] += uefi.FileTailLength
+	f, err := uefi.NewFFSFile(buf, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.HasTail() || f.EC == nil {
+		t.Fatalf("expected a tail file with EC firmware")
+	}
+
+	ite := testutil.ITEFirmware(0x200, 0x34)
+	if err := (&ReplaceEC{Index: 0, Firmware: ite}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	nf, err := uefi.NewFFSFile(f.Buf(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(nf.Data(), ite) {
+		t.Errorf("expected the whole EC firmware before the tail, got %#x bytes", len(nf.Data()))
+	}
+	if errs := nf.Validate(); len(errs) != 0 {
+		t.Errorf("file does not validate after the replacement: %v", errs)
+	}
+}
@@ -0,0 +1,131 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !js
+// +build !js
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Exec runs an external program on all files matching Predicate, so custom
+// transformations can be scripted without writing Go.
+//
+// The program gets the JSON of the file on the first line of its stdin,
+// followed by the file including its header, as written by `dump`. It writes
+// the new file to stdout, or nothing to keep the file as it is. The new file
+// is parsed again and replaces the matched one.
+type Exec struct {
+	// Input
+	Predicate func(f *uefi.File, name string) bool
+	// Command is the program and its arguments.
+	Command []string
+
+	// Output
+	Matches []*uefi.File
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Exec) Run(f uefi.Firmware) error {
+	if len(v.Command) == 0 {
+		return errors.New("no program to execute")
+	}
+	find := Find{
+		Predicate: v.Predicate,
+	}
+	if err := find.Run(f); err != nil {
+		return err
+	}
+
+	// Use this list of matches when running the program.
+	v.Matches = find.Matches
+	if err := checkProtected("exec", v.Matches); err != nil {
+		return err
+	}
+	return f.Apply(v)
+}
+
+// Visit applies the Exec visitor to any Firmware type.
+func (v *Exec) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		for i, file := range f.Files {
+			for _, m := range v.Matches {
+				if file != m {
+					continue
+				}
+				newFile, err := v.exec(file)
+				if err != nil {
+					return err
+				}
+				f.Files[i] = newFile
+			}
+		}
+	}
+
+	return f.ApplyChildren(v)
+}
+
+// exec runs the program on the file and returns the new file.
+func (v *Exec) exec(f *uefi.File) (*uefi.File, error) {
+	// Make sure the buffer includes earlier modifications.
+	if err := f.Apply(&Assemble{}); err != nil {
+		return nil, err
+	}
+	j, err := json.Marshal(f)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(v.Command[0], v.Command[1:]...)
+	cmd.Stdin = io.MultiReader(bytes.NewReader(j), strings.NewReader("\n"), bytes.NewReader(f.Buf()))
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%v failed on file %v: %v", v.Command[0], f.Header.UUID, err)
+	}
+	if out.Len() == 0 {
+		return f, nil
+	}
+	newFile, err := uefi.NewFile(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("output of %v for file %v is not a valid file: %v", v.Command[0], f.Header.UUID, err)
+	}
+	return newFile, nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "exec",
+		Args: []string{"(GUID|NAME)", "COMMAND"},
+		Help: "Run COMMAND, split at spaces, on the files which match the given GUID or NAME. " +
+			"It gets the JSON of the file on the first line of its stdin, followed by the file with its header. " +
+			"The file is replaced by the output of COMMAND, unless it is empty.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			searchRE, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &Exec{
+				Predicate: func(f *uefi.File, name string) bool {
+					return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
+				},
+				Command: strings.Fields(args[1]),
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,92 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !js
+// +build !js
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestExec(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "exec-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	jsonFile := filepath.Join(tmpDir, "file.json")
+	otherFile := filepath.Join(tmpDir, "other.ffs")
+
+	uefi.Attributes.ErasePolarity = 0xFF
+	var tests = []struct {
+		name    string
+		command []string
+		ok      bool
+		// Index of the file expected in place of the SEC core, or -1 if
+		// it is left as it is.
+		want int
+	}{
+		{"passthrough", []string{"sh", "-c", "read -r j; printf '%s' \"$j\" > " + jsonFile + "; cat"}, true, -1},
+		{"noOutput", []string{"true"}, true, -1},
+		{"replace", []string{"sh", "-c", "cat > /dev/null; cat " + otherFile}, true, 1},
+		{"garbage", []string{"echo", "hello"}, false, 0},
+		{"failure", []string{"false"}, false, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv, err := uefi.NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(otherFile, fv.Files[1].Buf(), 0666); err != nil {
+				t.Fatal(err)
+			}
+			var sec int
+			for i, f := range fv.Files {
+				if f.Header.Type == uefi.FVFileTypeSECCore {
+					sec = i
+				}
+			}
+			orig := fv.Files[sec]
+			origBuf := append([]byte{}, orig.Buf()...)
+
+			e := &Exec{Predicate: FindFileTypePredicate(uefi.FVFileTypeSECCore), Command: test.command}
+			err = e.Run(fv)
+			if !test.ok {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := fv.Files[sec]
+			want := origBuf
+			if test.want >= 0 {
+				want = fv.Files[test.want].Buf()
+			}
+			if !bytes.Equal(got.Buf(), want) {
+				t.Errorf("file was not replaced as expected")
+			}
+		})
+	}
+
+	j, err := ioutil.ReadFile(jsonFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(j), `"Type":"EFI_FV_FILETYPE_SECURITY_CORE"`) {
+		t.Errorf("unexpected JSON passed to the program: %s", j)
+	}
+}
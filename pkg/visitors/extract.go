@@ -5,6 +5,8 @@
 package visitors
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"flag"
 	"fmt"
@@ -13,6 +15,7 @@ import (
 	"path/filepath"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
 )
 
 var (
@@ -20,10 +23,16 @@ var (
 	remove = flag.Bool("remove", false, "remove existing directory before extracting")
 )
 
-// Extract extracts any Firmware node to DirPath
+// Extract extracts any Firmware node to DirPath. The layout only depends on
+// the image, so extracted trees of different versions can be compared.
 type Extract struct {
 	DirPath string
-	Index   *uint64
+
+	// fileCount counts the files of the current FV by GUID, to tell apart
+	// files with the same GUID.
+	fileCount map[uuid.UUID]int
+	// hashes collects the SHA-256 of the extracted binaries by path.
+	hashes map[string]string
 }
 
 // Run wraps Visit and performs some setup and teardown tasks.
@@ -50,7 +59,7 @@ func (v *Extract) Run(f uefi.Firmware) error {
 
 	// Create the directory if it does not exist.
 	if err := os.MkdirAll(v.DirPath, 0755); err != nil {
-		return err
+		return &WriteError{err}
 	}
 
 	// Change working directory so we can use relative paths.
@@ -59,17 +68,17 @@ func (v *Extract) Run(f uefi.Firmware) error {
 		return err
 	}
 
-	var fileIndex uint64
-	if err := f.Apply(&Extract{DirPath: ".", Index: &fileIndex}); err != nil {
+	hashes := map[string]string{}
+	if err := f.Apply(&Extract{DirPath: ".", hashes: hashes}); err != nil {
 		return err
 	}
 
 	// Output summary json.
-	json, err := uefi.MarshalFirmware(f)
+	json, err := uefi.MarshalSummary(f, hashes)
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile("summary.json", json, 0666)
+	return writeFile("summary.json", json, 0666)
 }
 
 // Visit applies the Extract visitor to any Firmware type.
@@ -83,54 +92,98 @@ func (v *Extract) Visit(f uefi.Firmware) error {
 
 	case *uefi.FirmwareVolume:
 		v2.DirPath = filepath.Join(v.DirPath, fmt.Sprintf("%#x", f.FVOffset))
+		v2.fileCount = map[uuid.UUID]int{}
 		if len(f.Files) == 0 {
-			f.ExtractPath, err = uefi.ExtractBinary(f.Buf(), v2.DirPath, "fv.bin")
+			f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "fv.bin")
 		} else {
-			f.ExtractPath, err = uefi.ExtractBinary(f.Buf()[:f.DataOffset], v2.DirPath, "fvh.bin")
+			f.ExtractPath, err = v.extractBinary(f.Buf()[:f.DataOffset], v2.DirPath, "fvh.bin")
 		}
 
 	case *uefi.File:
-		// For files we use the GUID as the folder name.
-		v2.DirPath = filepath.Join(v.DirPath, f.Header.UUID.String())
-		// Crappy hack to make unique ids unique
-		v2.DirPath = filepath.Join(v2.DirPath, fmt.Sprint(*v.Index))
-		*v.Index++
+		// For files we use the GUID as the folder name, and the number of
+		// files with the same GUID before it in the FV as the subfolder.
+		// Unlike a global counter, this does not change when files are
+		// added or removed elsewhere.
+		var n int
+		if v.fileCount != nil {
+			n = v.fileCount[f.Header.UUID]
+			v.fileCount[f.Header.UUID]++
+		}
+		v2.DirPath = filepath.Join(v.DirPath, f.Header.UUID.String(), fmt.Sprint(n))
 		if len(f.Sections) == 0 {
-			f.ExtractPath, err = uefi.ExtractBinary(f.Buf(), v2.DirPath, fmt.Sprintf("%v.ffs", f.Header.UUID))
+			f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, fmt.Sprintf("%v.ffs", f.Header.UUID))
 		}
 
 	case *uefi.Section:
 		// For sections we use the file order as the folder name.
 		v2.DirPath = filepath.Join(v.DirPath, fmt.Sprint(f.FileOrder))
-		if len(f.Encapsulated) == 0 {
-			f.ExtractPath, err = uefi.ExtractBinary(f.Buf(), v2.DirPath, fmt.Sprintf("%v.sec", f.FileOrder))
+		if f.Header.Type == uefi.SectionTypeFreeformSubtypeGUID && f.TypeSpecific != nil {
+			// Only the payload is extracted, the subtype GUID is kept in the JSON.
+			f.ExtractPath, err = v.extractBinary(sectionPayload(f), v2.DirPath, fmt.Sprintf("%v.bin", f.FileOrder))
+		} else if len(f.Encapsulated) == 0 || isUnknownGUIDed(f) {
+			// Sections which cannot be regenerated from their encapsulated
+			// sections are kept whole for --guided-passthrough.
+			f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, fmt.Sprintf("%v.sec", f.FileOrder))
 		}
 
+	case *uefi.CapsuleFile:
+		// The file is kept whole, the capsules are put back together when
+		// assembling.
+		v2.DirPath = filepath.Join(v.DirPath, "capsules")
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "capsulefile.bin")
+
+	case *uefi.Capsule:
+		// The capsule is kept whole, the payload is replaced when assembling.
+		v2.DirPath = filepath.Join(v.DirPath, fmt.Sprintf("capsule_%#x", f.Offset))
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "capsule.bin")
+
+	case *uefi.FMPImage:
+		v2.DirPath = filepath.Join(v.DirPath, fmt.Sprintf("fmp%d", f.Index))
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "fmpimage.bin")
+
+	case *uefi.IFlashFile:
+		// The file is kept whole, with the code of the flash tool, the
+		// images are put back when assembling.
+		v2.DirPath = filepath.Join(v.DirPath, "iflash")
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "iflashfile.bin")
+
+	case *uefi.IFlashImage:
+		v2.DirPath = filepath.Join(v.DirPath, fmt.Sprintf("iflash_%#x", f.Offset))
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "iflashimage.bin")
+
 	case *uefi.FlashDescriptor:
 		v2.DirPath = filepath.Join(v.DirPath, "ifd")
-		f.ExtractPath, err = uefi.ExtractBinary(f.Buf(), v2.DirPath, "flashdescriptor.bin")
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "flashdescriptor.bin")
 
 	case *uefi.BIOSRegion:
 		v2.DirPath = filepath.Join(v.DirPath, "bios")
 		if len(f.Elements) == 0 {
-			f.ExtractPath, err = uefi.ExtractBinary(f.Buf(), v2.DirPath, "biosregion.bin")
+			f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "biosregion.bin")
 		}
 
 	case *uefi.GBERegion:
 		v2.DirPath = filepath.Join(v.DirPath, "gbe")
-		f.ExtractPath, err = uefi.ExtractBinary(f.Buf(), v2.DirPath, "gberegion.bin")
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "gberegion.bin")
 
 	case *uefi.MERegion:
 		v2.DirPath = filepath.Join(v.DirPath, "me")
-		f.ExtractPath, err = uefi.ExtractBinary(f.Buf(), v2.DirPath, "meregion.bin")
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "meregion.bin")
 
 	case *uefi.PDRegion:
 		v2.DirPath = filepath.Join(v.DirPath, "pd")
-		f.ExtractPath, err = uefi.ExtractBinary(f.Buf(), v2.DirPath, "pdregion.bin")
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "pdregion.bin")
+
+	case *uefi.ECRegion:
+		v2.DirPath = filepath.Join(v.DirPath, "ec")
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "ecregion.bin")
+
+	case *uefi.RawRegion:
+		v2.DirPath = filepath.Join(v.DirPath, fmt.Sprintf("region%d", f.Index))
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "region.bin")
 
 	case *uefi.BIOSPadding:
 		v2.DirPath = filepath.Join(v.DirPath, fmt.Sprintf("biospad_%#x", f.Offset))
-		f.ExtractPath, err = uefi.ExtractBinary(f.Buf(), v2.DirPath, "pad.bin")
+		f.ExtractPath, err = v.extractBinary(f.Buf(), v2.DirPath, "pad.bin")
 	}
 	if err != nil {
 		return err
@@ -139,12 +192,30 @@ func (v *Extract) Visit(f uefi.Firmware) error {
 	return f.ApplyChildren(&v2)
 }
 
+// extractBinary writes the binary like uefi.ExtractBinary and records its
+// hash, so modifications are noticed when the tree is read back.
+func (v *Extract) extractBinary(buf []byte, dirPath string, filename string) (string, error) {
+	path, err := uefi.ExtractBinary(buf, dirPath, filename)
+	if err != nil {
+		return "", &WriteError{err}
+	}
+	if v.hashes != nil {
+		sum := sha256.Sum256(buf)
+		v.hashes[path] = hex.EncodeToString(sum[:])
+	}
+	return path, nil
+}
+
 func init() {
-	var fileIndex uint64
-	RegisterCLI("extract", 1, func(args []string) (uefi.Visitor, error) {
-		return &Extract{
-			DirPath: args[0],
-			Index:   &fileIndex,
-		}, nil
+	Register(CLI{
+		Name:  "extract",
+		Args:  []string{"DIR"},
+		Help:  "Extract the BIOS to the given directory. Operations are applied left-to-right, so only the operations to the left are included. The SHA-256 of the extracted files is recorded in summary.json and checked when reading the directory.",
+		Flags: []string{"force", "remove"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &Extract{
+				DirPath: args[0],
+			}, nil
+		},
 	})
 }
@@ -5,9 +5,14 @@
 package visitors
 
 import (
+	"bytes"
+	"encoding/binary"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
@@ -93,8 +98,7 @@ func TestExtractAssembleFile(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Unable to parse file object %v, got %v", test.origBuf, err.Error())
 			}
-			var fIndex uint64
-			if err = f.Apply(&Extract{DirPath: tmpDir, Index: &fIndex}); err != nil {
+			if err = f.Apply(&Extract{DirPath: tmpDir}); err != nil {
 				t.Fatalf("Unable to extract file %v, got %v", test.origBuf, err.Error())
 			}
 			if err = f.Apply(&ParseDir{DirPath: tmpDir}); err != nil {
@@ -139,8 +143,7 @@ func TestExtractAssembleFV(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Unable to parse file object %v, got %v", test.origBuf, err.Error())
 			}
-			var fIndex uint64
-			if err = fv.Apply(&Extract{DirPath: tmpDir, Index: &fIndex}); err != nil {
+			if err = fv.Apply(&Extract{DirPath: tmpDir}); err != nil {
 				t.Fatalf("Unable to extract file %v, got %v", test.origBuf, err.Error())
 			}
 			if err = fv.Apply(&ParseDir{DirPath: tmpDir}); err != nil {
@@ -188,8 +191,7 @@ func TestExtractAssembleSection(t *testing.T) {
 			if err != nil {
 				t.Fatalf("Unable to parse section object %v, got %v", test.buf, err.Error())
 			}
-			var fIndex uint64
-			if err = s.Apply(&Extract{DirPath: tmpDir, Index: &fIndex}); err != nil {
+			if err = s.Apply(&Extract{DirPath: tmpDir}); err != nil {
 				t.Fatalf("Unable to extract section %v, got %v", test.buf, err.Error())
 			}
 			if err = s.Apply(&ParseDir{DirPath: tmpDir}); err != nil {
@@ -210,3 +212,179 @@ func TestExtractAssembleSection(t *testing.T) {
 		})
 	}
 }
+
+// extractPaths extracts the image to dir and returns the paths of the files
+// written for the nodes under prefix, relative to dir.
+func extractPaths(t *testing.T, f uefi.Firmware, dir, prefix string) []string {
+	if err := f.Apply(&Extract{DirPath: dir}); err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	err := filepath.Walk(filepath.Join(dir, prefix), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		paths = append(paths, filepath.ToSlash(rel))
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths
+}
+
+func TestExtractStablePaths(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "extract-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	f := parseImage(t)
+	secFV := "bios/0x3cc000"
+	orig := extractPaths(t, f, filepath.Join(tmpDir, "orig"), secFV)
+	if len(orig) == 0 {
+		t.Fatal("nothing extracted for the SEC FV")
+	}
+	for _, p := range orig {
+		if strings.Contains(p, `\`) {
+			t.Errorf("path %q is not normalized", p)
+		}
+	}
+
+	// Removing a file from another FV must not rename anything in the SEC FV.
+	n, err := resolvePath(f, "/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fv := n.Firmware.(*uefi.FirmwareVolume)
+	fv.Files = fv.Files[1:]
+	removed := extractPaths(t, f, filepath.Join(tmpDir, "removed"), secFV)
+	if !reflect.DeepEqual(orig, removed) {
+		t.Errorf("extracted paths changed from\n%v\nto\n%v", orig, removed)
+	}
+}
+
+func TestParseDirHashes(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "extract-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	uefi.Attributes.ErasePolarity = 0xFF
+	fv, err := uefi.NewFirmwareVolume(sampleFV, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := map[string]string{}
+	if err = fv.Apply(&Extract{DirPath: tmpDir, hashes: hashes}); err != nil {
+		t.Fatal(err)
+	}
+	if hashes[fv.ExtractPath] == "" {
+		t.Fatalf("no hash recorded for %v", fv.ExtractPath)
+	}
+	if err = fv.Apply(&ParseDir{DirPath: tmpDir, hashes: hashes}); err != nil {
+		t.Fatalf("unmodified tree rejected: %v", err)
+	}
+
+	// Truncate one of the extracted binaries.
+	if err = ioutil.WriteFile(filepath.FromSlash(fv.ExtractPath), []byte{0}, 0666); err != nil {
+		t.Fatal(err)
+	}
+	err = fv.Apply(&ParseDir{DirPath: tmpDir, hashes: hashes})
+	if err == nil || !strings.Contains(err.Error(), "modified after extraction") {
+		t.Errorf("expected a hash mismatch error, got %v", err)
+	}
+
+	// The check can be turned off for damaged images.
+	if err = fv.Apply(&ParseDir{DirPath: tmpDir, SkipHashes: true, hashes: hashes}); err != nil {
+		t.Errorf("expected no error with SkipHashes, got %v", err)
+	}
+	uefi.SetParseMode(uefi.ParsePermissive)
+	defer uefi.SetParseMode(uefi.ParseStrict)
+	if err = fv.Apply(&ParseDir{DirPath: tmpDir, hashes: hashes}); err != nil {
+		t.Errorf("expected no error in permissive mode, got %v", err)
+	}
+}
+
+func TestExtractAssembleCapsule(t *testing.T) {
+	capsule := make([]byte, 0x40)
+	copy(capsule, uefi.EFICapsuleGUID[:])
+	capsule[16] = 0x40
+	binary.LittleEndian.PutUint32(capsule[24:], uint32(0x40+len(sampleFV)))
+	capsule = append(capsule, sampleFV...)
+	capsule = append(capsule, "signature"...)
+
+	tmpDir, err := ioutil.TempDir("", "capsule-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	f, err := uefi.Parse(append([]byte{}, capsule...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := resolvePath(f, "/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.Firmware.(*uefi.FirmwareVolume); !ok || n.Offset != 0x40 {
+		t.Fatalf("expected the FV at 0x40 in the capsule, got %T at %#x", n.Firmware, n.Offset)
+	}
+	if err := f.Apply(&Extract{DirPath: tmpDir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Apply(&ParseDir{DirPath: tmpDir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Buf(), capsule) {
+		t.Error("reassembled capsule differs from the original")
+	}
+}
+
+func TestExtractAssembleIFlash(t *testing.T) {
+	image := make([]byte, uefi.IFlashHeaderSize)
+	copy(image, uefi.IFlashSignature+uefi.IFlashTagBIOSImage)
+	binary.LittleEndian.PutUint32(image[16:], uint32(len(sampleFV)+8))
+	binary.LittleEndian.PutUint32(image[20:], uint32(len(sampleFV)))
+	image = append(image, sampleFV...)
+	image = append(image, "padding!"...)
+	update := append(append([]byte("MZ flash tool"), image...), "trailer"...)
+
+	tmpDir, err := ioutil.TempDir("", "iflash-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	f, err := uefi.Parse(append([]byte{}, update...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := resolvePath(f, "/0/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset := uint64(len("MZ flash tool") + uefi.IFlashHeaderSize)
+	if _, ok := n.Firmware.(*uefi.FirmwareVolume); !ok || n.Offset != offset {
+		t.Fatalf("expected the FV at %#x in the update file, got %T at %#x", offset, n.Firmware, n.Offset)
+	}
+	if err := f.Apply(&Extract{DirPath: tmpDir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Apply(&ParseDir{DirPath: tmpDir}); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Apply(&Assemble{}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Buf(), update) {
+		t.Error("reassembled update file differs from the original")
+	}
+}
@@ -6,6 +6,7 @@ package visitors
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"regexp"
@@ -13,6 +14,8 @@ import (
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
+var ignoreCase = flag.Bool("ignore-case", false, "case insensitive name matching for find_name")
+
 // Find a firmware file given its name or GUID.
 type Find struct {
 	// Input
@@ -37,6 +40,13 @@ func (v *Find) Visit(f uefi.Firmware) error {
 	switch f := f.(type) {
 
 	case *uefi.File:
+		// Files without sections, such as raw or pad files, have no name.
+		if len(f.Sections) == 0 {
+			if v.Predicate(f, "") {
+				v.Matches = append(v.Matches, f)
+			}
+			return nil
+		}
 		// Clone the visitor so the `currentFile` is passed only to descendents.
 		v2 := &Find{
 			Predicate:   v.Predicate,
@@ -58,24 +68,87 @@ func (v *Find) Visit(f uefi.Firmware) error {
 	}
 }
 
-func init() {
-	RegisterCLI("find", 1, func(args []string) (uefi.Visitor, error) {
-		searchRE, err := regexp.Compile(args[0])
+// FindFileTypePredicate is a predicate which matches files of the given type.
+func FindFileTypePredicate(t uefi.FVFileType) func(f *uefi.File, name string) bool {
+	return func(f *uefi.File, name string) bool {
+		return f.Header.Type == t
+	}
+}
+
+// FindNamePredicate returns a predicate which matches files whose UI section
+// name matches the regular expression.
+func FindNamePredicate(pattern string, ignoreCase bool) (func(f *uefi.File, name string) bool, error) {
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	nameRE, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return func(f *uefi.File, name string) bool {
+		return name != "" && nameRE.MatchString(name)
+	}, nil
+}
+
+// printMatch wraps a predicate so matching files are dumped as JSON.
+func printMatch(pred func(f *uefi.File, name string) bool) func(f *uefi.File, name string) bool {
+	return func(f *uefi.File, name string) bool {
+		if !pred(f, name) {
+			return false
+		}
+		b, err := json.MarshalIndent(f, "", "\t")
 		if err != nil {
-			return nil, err
+			log.Fatal(err)
 		}
-		return &Find{
-			Predicate: func(f *uefi.File, name string) bool {
-				if searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String()) {
-					b, err := json.MarshalIndent(f, "", "\t")
-					if err != nil {
-						log.Fatal(err)
-					}
-					fmt.Println(string(b))
-					return true
-				}
-				return false
-			},
-		}, nil
+		fmt.Println(string(b))
+		return true
+	}
+}
+
+func init() {
+	Register(CLI{
+		Name: "find",
+		Args: []string{"(GUID|NAME)"},
+		Help: "Dump the JSON of one or more files. The file is found by a regex match to its GUID or name in the UI section.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			searchRE, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &Find{
+				Predicate: printMatch(func(f *uefi.File, name string) bool {
+					return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
+				}),
+			}, nil
+		},
+	})
+	Register(CLI{
+		Name: "find_type",
+		Args: []string{"TYPE"},
+		Help: "Dump the JSON of all files of the given type, e.g. DRIVER, PEIM, APPLICATION, SMM or RAW.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			t, err := uefi.ParseFVFileType(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &Find{
+				Predicate: printMatch(FindFileTypePredicate(t)),
+			}, nil
+		},
+	})
+	Register(CLI{
+		Name:  "find_name",
+		Args:  []string{"REGEX"},
+		Help:  "Dump the JSON of all files whose UI section name matches REGEX. GUIDs are not matched.",
+		Flags: []string{"ignore-case"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			pred, err := FindNamePredicate(args[0], *ignoreCase)
+			if err != nil {
+				return nil, err
+			}
+			return &Find{
+				Predicate: printMatch(pred),
+			}, nil
+		},
 	})
 }
@@ -48,3 +48,62 @@ func TestFind(t *testing.T) {
 		t.Fatalf("got %d matches; expected 1", len(results))
 	}
 }
+
+func TestFindFileType(t *testing.T) {
+	f := parseImage(t)
+	var tests = []struct {
+		fileType uefi.FVFileType
+		minCount int
+	}{
+		{uefi.FVFileTypeSECCore, 1},
+		// The VTF has no sections.
+		{uefi.FVFileTypeRaw, 1},
+		{uefi.FVFileTypeDriver, 10},
+	}
+	for _, test := range tests {
+		t.Run(test.fileType.String(), func(t *testing.T) {
+			find := &Find{Predicate: FindFileTypePredicate(test.fileType)}
+			if err := find.Run(f); err != nil {
+				t.Fatal(err)
+			}
+			if len(find.Matches) < test.minCount {
+				t.Errorf("got %d matches, expected at least %d", len(find.Matches), test.minCount)
+			}
+			for _, m := range find.Matches {
+				if m.Header.Type != test.fileType {
+					t.Errorf("file %v has type %v", m.Header.UUID, m.Header.Type)
+				}
+			}
+		})
+	}
+}
+
+func TestFindName(t *testing.T) {
+	f := parseImage(t)
+	var tests = []struct {
+		pattern    string
+		ignoreCase bool
+		count      int
+	}{
+		{"^SecMain$", false, 1},
+		{"^secmain$", false, 0},
+		{"^secmain$", true, 1},
+		// Only UI names are matched, not GUIDs.
+		{"^DF1CCEF6", false, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.pattern, func(t *testing.T) {
+			pred, err := FindNamePredicate(test.pattern, test.ignoreCase)
+			if err != nil {
+				t.Fatal(err)
+			}
+			find := &Find{Predicate: pred}
+			if err := find.Run(f); err != nil {
+				t.Fatal(err)
+			}
+			if len(find.Matches) != test.count {
+				t.Errorf("got %d matches, expected %d", len(find.Matches), test.count)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// GenerateFIT builds a FIT from the microcode updates and the startup ACM
+// found in the image, writes it at Address and points the FIT pointer at it.
+// The image is assumed to be mapped right below 4GiB. The table has to go
+// into erased space, or replace the current table, within a single file,
+// section or padding, e.g. a pad file.
+type GenerateFIT struct {
+	// Input
+	// Address is the memory address of the new table. If it is 0, the
+	// current table is replaced.
+	Address uint64
+
+	// Output
+	FIT *uefi.FIT
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *GenerateFIT) Run(f uefi.Firmware) error {
+	// Offsets are only accurate once the tree is assembled.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	image := f.Buf()
+	size := uint64(len(image))
+	if size < uefi.FITPointerOffset {
+		return fmt.Errorf("image of %#x bytes is too small for a FIT pointer", size)
+	}
+	old, oldErr := uefi.FindFIT(image)
+	addr := v.Address
+	if addr == 0 {
+		if oldErr != nil {
+			return fmt.Errorf("no FIT address given and no FIT to replace: %v", oldErr)
+		}
+		addr = old.Address
+	}
+
+	var microcode []uint64
+	var acm uint64
+	for off := uint64(0); off+uefi.FITAlignment <= size; off += uefi.FITAlignment {
+		// The header version is checked first, parsing each header is slow.
+		if binary.LittleEndian.Uint32(image[off:]) == 1 {
+			if n, err := uefi.MicrocodeSize(image[off:]); err == nil {
+				microcode = append(microcode, uefi.OffsetToAddress(off, size))
+				off += uefi.Align(n, uefi.FITAlignment) - uefi.FITAlignment
+				continue
+			}
+		}
+		if acm == 0 && off%uefi.ACMAlignment == 0 && uefi.IsACM(image[off:]) {
+			acm = uefi.OffsetToAddress(off, size)
+		}
+	}
+	fit, err := uefi.NewFITFromParts(addr, microcode, acm)
+	if err != nil {
+		return err
+	}
+	table := fit.Bytes()
+
+	offset, err := uefi.AddressToOffset(addr, size)
+	if err != nil {
+		return err
+	}
+	end := offset + uint64(len(table))
+	if end > size-uefi.FITPointerOffset {
+		return fmt.Errorf("FIT of %#x bytes at %#x overlaps the FIT pointer", len(table), addr)
+	}
+	// The space has to be erased, except for what the current table holds.
+	var oldStart, oldEnd uint64
+	if oldErr == nil {
+		oldStart, _ = uefi.AddressToOffset(old.Address, size)
+		oldEnd = oldStart + uint64(len(old.Entries))*uefi.FITEntryLength
+	}
+	erased := erasedBytes(1)[0]
+	for i := offset; i < end; i++ {
+		if (i < oldStart || i >= oldEnd) && image[i] != erased {
+			return fmt.Errorf("FIT of %#x bytes at %#x overwrites data at %#x",
+				len(table), addr, uefi.OffsetToAddress(i, size))
+		}
+	}
+
+	root := node{Firmware: f, InFlash: true}
+	if oldErr == nil && oldStart != offset {
+		// Erase the old table so it is not mistaken for the current one.
+		if err := patchFlash(root, oldStart, erasedBytes(oldEnd-oldStart)); err != nil {
+			return err
+		}
+	}
+	if err := patchFlash(root, offset, table); err != nil {
+		return err
+	}
+	pointer := make([]byte, 8)
+	binary.LittleEndian.PutUint64(pointer, addr)
+	if err := patchFlash(root, size-uefi.FITPointerOffset, pointer); err != nil {
+		return err
+	}
+	v.FIT = fit
+	return (&Assemble{}).Run(f)
+}
+
+// Visit applies the GenerateFIT visitor to any Firmware type.
+func (v *GenerateFIT) Visit(f uefi.Firmware) error {
+	return v.Run(f)
+}
+
+// erasedBytes returns n bytes of erased flash.
+func erasedBytes(n uint64) []byte {
+	buf := make([]byte, n)
+	uefi.Erase(buf, uefi.Attributes.ErasePolarity)
+	return buf
+}
+
+// patchFlash overwrites the bytes at the given image offset in the leaf node
+// holding them, so they survive reassembly. Data which spans several nodes,
+// or lies in headers or free space between them, cannot be patched.
+func patchFlash(n node, offset uint64, data []byte) error {
+	end := offset + uint64(len(data))
+	nodes := children(n)
+	for _, c := range nodes {
+		if c.InFlash && offset >= c.Offset && end <= c.Offset+uint64(len(c.Buf())) {
+			return patchFlash(c, offset, data)
+		}
+	}
+	if len(nodes) != 0 {
+		return fmt.Errorf("%#x bytes at offset %#x are not within a single file, section or padding",
+			len(data), offset)
+	}
+	buf := append([]byte{}, n.Buf()...)
+	copy(buf[offset-n.Offset:], data)
+	n.SetBuf(buf)
+	return nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "generate_fit",
+		Args: []string{"ADDRESS"},
+		Help: "Build a FIT from the microcode updates and the startup ACM found in the image, write it to the erased space at the memory ADDRESS and set the FIT pointer. An ADDRESS of 0 replaces the current FIT.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			addr, err := strconv.ParseUint(args[0], 0, 32)
+			if err != nil {
+				return nil, err
+			}
+			return &GenerateFIT{
+				Address: addr,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,98 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// makeMicrocode returns a microcode update of the default size.
+func makeMicrocode() []byte {
+	buf := make([]byte, 2048)
+	binary.LittleEndian.PutUint32(buf[0:], 1)
+	binary.LittleEndian.PutUint32(buf[0x0c:], 0x906ea)
+	binary.LittleEndian.PutUint32(buf[0x14:], 1)
+	for i := 0x30; i < len(buf); i++ {
+		buf[i] = byte(i)
+	}
+	var sum uint32
+	for i := 0; i < len(buf); i += 4 {
+		sum += binary.LittleEndian.Uint32(buf[i:])
+	}
+	binary.LittleEndian.PutUint32(buf[0x10:], 0-sum)
+	return buf
+}
+
+// addFITParts puts a microcode update at 0x3d2000 and the header of an ACM
+// at 0x3d3000 into the pad file of the SEC FV.
+func addFITParts(t *testing.T, f uefi.Firmware) {
+	n, err := resolvePath(f, "/2/1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pad := n.Firmware.(*uefi.File)
+	buf := append([]byte{}, pad.Buf()...)
+	copy(buf[0x3d2000-n.Offset:], makeMicrocode())
+	acm := buf[0x3d3000-n.Offset:]
+	binary.LittleEndian.PutUint16(acm[0:], 2)
+	binary.LittleEndian.PutUint32(acm[0x10:], 0x8086)
+	pad.SetBuf(buf)
+}
+
+func TestGenerateFIT(t *testing.T) {
+	f := parseImage(t)
+	addFITParts(t, f)
+
+	if err := (&GenerateFIT{}).Run(f); err == nil {
+		t.Error("expected an error without an address or FIT to replace")
+	}
+	if err := (&GenerateFIT{Address: 0xfffd0008}).Run(f); err == nil {
+		t.Error("expected an error for a misaligned FIT")
+	}
+	if err := (&GenerateFIT{Address: 0xfffd2000}).Run(f); err == nil {
+		t.Error("expected an error for a FIT overwriting the microcode")
+	}
+
+	v := &GenerateFIT{Address: 0xfffd4000}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	fit, err := uefi.FindFIT(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fit.Address != 0xfffd4000 || len(fit.Entries) != 3 {
+		t.Fatalf("unexpected FIT %+v", fit)
+	}
+	for i, e := range []struct {
+		typez uefi.FITEntryType
+		addr  uint64
+	}{
+		{uefi.FITTypeHeader, binary.LittleEndian.Uint64(uefi.FITSignature)},
+		{uefi.FITTypeMicrocode, 0xfffd2000},
+		{uefi.FITTypeStartupACM, 0xfffd3000},
+	} {
+		if got := fit.Entries[i]; got.Type() != e.typez || got.Address != e.addr {
+			t.Errorf("entry %d: expected %v at %#x, got %v at %#x", i, e.typez, e.addr, got.Type(), got.Address)
+		}
+	}
+
+	// The table survives a reparse and can be regenerated in place.
+	f, err = uefi.Parse(append([]byte{}, f.Buf()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := append([]byte{}, f.Buf()...)
+	if err := (&GenerateFIT{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Buf(), orig) {
+		t.Error("regenerating the FIT in place changed the image")
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Layout of the flash map, in pixels.
+const (
+	flashMapWidth     = 1200
+	flashMapRowHeight = 40
+	flashMapMargin    = 10
+	// flashMapMarkers is the height of the area for the FIT markers.
+	flashMapMarkers = 60
+)
+
+// Colors of the flash map.
+var flashMapColors = map[string]string{
+	"region": "#6a9fd4",
+	"fv":     "#8cc68c",
+	"file":   "#f0c36a",
+	"free":   "#e0e0e0",
+	"data":   "#c09090",
+}
+
+// FlashMap renders the physical layout of the image, its regions, FVs,
+// files and free space, as an SVG drawn to scale. The FIT and the locations
+// of its entries, such as microcode updates and the startup ACM, are marked.
+// Nodes inside compressed sections have no place in flash and are not
+// shown.
+type FlashMap struct {
+	// Input
+	Path string
+	// W is written to instead of Path if set.
+	W io.Writer
+
+	// Output
+	Boxes   []FlashMapBox
+	Markers []FlashMapBox
+}
+
+// FlashMapBox is an area of the flash map.
+type FlashMapBox struct {
+	Label  string
+	Kind   string
+	Depth  int
+	Offset uint64
+	Size   uint64
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *FlashMap) Run(f uefi.Firmware) error {
+	// Offsets are only accurate once the tree is assembled.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	return f.Apply(v)
+}
+
+// Visit applies the FlashMap visitor to any Firmware type.
+func (v *FlashMap) Visit(f uefi.Firmware) error {
+	v.Boxes, v.Markers = nil, nil
+	for _, c := range children(node{Firmware: f, InFlash: true}) {
+		v.addBoxes(c, 0)
+	}
+	image := f.Buf()
+	if fit, err := uefi.FindFIT(image); err == nil {
+		if offset, err := uefi.AddressToOffset(fit.Address, uint64(len(image))); err == nil {
+			v.Markers = append(v.Markers, FlashMapBox{Label: "FIT", Offset: offset, Size: uint64(len(fit.Entries)) * 16})
+		}
+		for _, e := range fit.Entries[1:] {
+			if offset, err := uefi.AddressToOffset(e.Address, uint64(len(image))); err == nil {
+				v.Markers = append(v.Markers, FlashMapBox{Label: e.Type().String(), Offset: offset, Size: uefi.Read3Size(e.Size) * 16})
+			}
+		}
+	}
+
+	w := v.W
+	if w == nil {
+		file, err := createFile(v.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return v.render(w, uint64(len(image)))
+}
+
+// addBoxes adds n and the nodes below it, down to the files.
+func (v *FlashMap) addBoxes(n node, depth int) {
+	if !n.InFlash {
+		return
+	}
+	guid, name, typez := nodeInfo(n.Firmware)
+	label := name
+	if label == "" {
+		// Files without a name are known by their GUID.
+		if label = typez; guid != "" {
+			if _, ok := n.Firmware.(*uefi.File); ok {
+				label = guid
+			}
+		}
+	}
+	box := FlashMapBox{Label: label, Kind: "region", Depth: depth, Offset: n.Offset, Size: uint64(len(n.Buf()))}
+	switch f := n.Firmware.(type) {
+	case *uefi.BIOSPadding:
+		box.Kind = "data"
+		if uefi.IsErased(f.Buf(), uefi.Attributes.ErasePolarity) {
+			box.Kind, box.Label = "free", "free"
+		}
+	case *uefi.FirmwareVolume:
+		box.Kind = "fv"
+		uefi.Attributes.ErasePolarity = f.GetErasePolarity()
+		if len(f.Files) != 0 {
+			v.Boxes = append(v.Boxes, box)
+			end := n.Offset + f.DataOffset
+			for _, c := range children(n) {
+				v.addBoxes(c, depth+1)
+				end = c.Offset + uint64(len(c.Buf()))
+			}
+			if tail := f.Buf()[end-n.Offset:]; len(tail) != 0 && uefi.IsErased(tail, f.GetErasePolarity()) {
+				v.Boxes = append(v.Boxes, FlashMapBox{Label: "free", Kind: "free", Depth: depth + 1, Offset: end, Size: uint64(len(tail))})
+			}
+			return
+		}
+	case *uefi.File:
+		box.Kind = "file"
+		if f.Header.Type == uefi.FVFileTypePad {
+			box.Kind, box.Label = "data", "pad data"
+			if isErasedPad(f) {
+				box.Kind, box.Label = "free", "free"
+			}
+		}
+		// Sections are not shown.
+		v.Boxes = append(v.Boxes, box)
+		return
+	}
+	v.Boxes = append(v.Boxes, box)
+	for _, c := range children(n) {
+		v.addBoxes(c, depth+1)
+	}
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// render writes the SVG for an image of the given size.
+func (v *FlashMap) render(w io.Writer, size uint64) error {
+	depth := 0
+	for _, b := range v.Boxes {
+		if b.Depth+1 > depth {
+			depth = b.Depth + 1
+		}
+	}
+	scale := float64(flashMapWidth) / float64(size)
+	height := 2*flashMapMargin + depth*flashMapRowHeight + flashMapMarkers
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="sans-serif" font-size="10">`+"\n",
+		flashMapWidth+2*flashMapMargin, height)
+	for _, box := range v.Boxes {
+		x := flashMapMargin + float64(box.Offset)*scale
+		width := float64(box.Size) * scale
+		if width < 1 {
+			width = 1
+		}
+		y := flashMapMargin + box.Depth*flashMapRowHeight
+		title := fmt.Sprintf("%s at %#x, %#x bytes", box.Label, box.Offset, box.Size)
+		fmt.Fprintf(&b, `<g><title>%s</title><rect x="%.2f" y="%d" width="%.2f" height="%d" fill="%s" stroke="#fff" stroke-width="0.5"/>`,
+			xmlEscape(title), x, y, width, flashMapRowHeight-4, flashMapColors[box.Kind])
+		// Only label the boxes which have room for it.
+		if width > float64(6*len(box.Label)) {
+			fmt.Fprintf(&b, `<text x="%.2f" y="%d">%s</text>`, x+2, y+flashMapRowHeight/2, xmlEscape(box.Label))
+		}
+		fmt.Fprintf(&b, "</g>\n")
+	}
+	top := flashMapMargin + depth*flashMapRowHeight
+	for i, m := range v.Markers {
+		x := flashMapMargin + float64(m.Offset)*scale
+		title := fmt.Sprintf("%s at %#x, %#x bytes", m.Label, m.Offset, m.Size)
+		fmt.Fprintf(&b, `<g><title>%s</title><line x1="%.2f" y1="%d" x2="%.2f" y2="%d" stroke="#c00"/>`+
+			`<text x="%.2f" y="%d" fill="#c00">%s</text></g>`+"\n",
+			xmlEscape(title), x, flashMapMargin, x, top+10*(i%4)+10, x+2, top+10*(i%4)+10, xmlEscape(m.Label))
+	}
+	fmt.Fprintf(&b, "</svg>\n")
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+func init() {
+	Register(CLI{
+		Name: "flash_map",
+		Args: []string{"FILE"},
+		Help: "Write an SVG map of the physical layout of the image to FILE, drawn to scale, with the regions, FVs, files, free space and the locations of the FIT entries.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &FlashMap{
+				Path: args[0],
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestFlashMap(t *testing.T) {
+	f := parseImage(t)
+	addFITParts(t, f)
+	if err := (&GenerateFIT{Address: 0xfffd4000}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	v := &FlashMap{W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// The pad file after the SEC core holds the FIT parts now.
+	var sec, pad, free bool
+	for _, box := range v.Boxes {
+		switch {
+		case box.Label == "SecMain":
+			sec = box.Kind == "file" && box.Depth == 1 && box.Offset == 0x3cc078
+		case box.Kind == "data" && box.Offset == 0x3d1638:
+			pad = true
+		case box.Kind == "free" && box.Offset == 0x1e725a:
+			free = true
+		}
+	}
+	if !sec || !pad || !free {
+		t.Errorf("expected the SEC core, the pad file and the free space of the DXE FV, got %+v", v.Boxes)
+	}
+	var labels []string
+	for _, m := range v.Markers {
+		labels = append(labels, m.Label)
+	}
+	if got := strings.Join(labels, ","); got != "FIT,Microcode,StartupACM" {
+		t.Errorf("expected the FIT, microcode and ACM markers, got %v", got)
+	}
+	if out := b.String(); !strings.HasPrefix(out, "<svg") || !strings.Contains(out, "<title>StartupACM at 0x3d3000") {
+		t.Errorf("unexpected SVG:\n%s", out)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var regionFile = flag.String("region-file", "", "file with the contents of the region added by add_region, it is padded with erased bytes")
+
+// AddRegion defines a region which is unused in the flash descriptor, e.g. a
+// PD or EC region, in space no other region uses, and adds it to the image.
+type AddRegion struct {
+	// Input
+	Index  int
+	Region uefi.Region
+	// Buf is the contents of the region, it is padded with erased bytes.
+	Buf []byte
+
+	// Output
+	Image *uefi.FlashImage
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *AddRegion) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.Image == nil {
+		return errors.New("no flash image to add a region to")
+	}
+	return (&Assemble{}).Run(f)
+}
+
+// Visit applies the AddRegion visitor to any Firmware type.
+func (v *AddRegion) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FlashImage:
+		v.Image = f
+		return f.AddRegion(v.Index, v.Region, v.Buf)
+	}
+	return f.ApplyChildren(v)
+}
+
+// RemoveRegion marks a region unused in the flash descriptor and removes it
+// from the image. Its space is erased.
+type RemoveRegion struct {
+	// Input
+	Index int
+
+	// Output
+	Image *uefi.FlashImage
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *RemoveRegion) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.Image == nil {
+		return errors.New("no flash image to remove a region from")
+	}
+	return (&Assemble{}).Run(f)
+}
+
+// Visit applies the RemoveRegion visitor to any Firmware type.
+func (v *RemoveRegion) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FlashImage:
+		v.Image = f
+		return f.RemoveRegion(v.Index)
+	}
+	return f.ApplyChildren(v)
+}
+
+// blockRegion returns the region covering size bytes at offset, which have
+// to be multiples of the region block size.
+func blockRegion(offset, size uint64) (uefi.Region, error) {
+	if offset%uefi.RegionBlockSize != 0 || size%uefi.RegionBlockSize != 0 || size == 0 {
+		return uefi.Region{}, fmt.Errorf("offset %#x and size %#x of the region are not multiples of %#x",
+			offset, size, uefi.RegionBlockSize)
+	}
+	if end := (offset + size) / uefi.RegionBlockSize; end > 0x8000 {
+		return uefi.Region{}, fmt.Errorf("region end %#x is beyond the descriptor limit", offset+size)
+	}
+	return uefi.Region{
+		Base:  uint16(offset / uefi.RegionBlockSize),
+		Limit: uint16((offset+size)/uefi.RegionBlockSize - 1),
+	}, nil
+}
+
+func init() {
+	Register(CLI{
+		Name:  "add_region",
+		Args:  []string{"REGION", "OFFSET", "SIZE"},
+		Help:  "Define the unused region REGION, given by name or index, at OFFSET in the flash descriptor with SIZE bytes, and add it to the image. The space must not be used by another region. The region is erased, or holds the contents of -region-file.",
+		Flags: []string{"region-file"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			i, err := regionIndex(args[0])
+			if err != nil {
+				return nil, err
+			}
+			offset, err := strconv.ParseUint(args[1], 0, 64)
+			if err != nil {
+				return nil, err
+			}
+			size, err := strconv.ParseUint(args[2], 0, 64)
+			if err != nil {
+				return nil, err
+			}
+			r, err := blockRegion(offset, size)
+			if err != nil {
+				return nil, err
+			}
+			var buf []byte
+			if *regionFile != "" {
+				if buf, err = ioutil.ReadFile(*regionFile); err != nil {
+					return nil, err
+				}
+			}
+			return &AddRegion{Index: i, Region: r, Buf: buf}, nil
+		},
+	})
+	Register(CLI{
+		Name: "remove_region",
+		Args: []string{"REGION"},
+		Help: "Mark the region REGION, given by name or index, unused in the flash descriptor and remove it from the image. Its space is erased.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			i, err := regionIndex(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &RemoveRegion{Index: i}, nil
+		},
+	})
+}
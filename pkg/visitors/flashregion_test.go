@@ -0,0 +1,66 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestAddRemoveRegion(t *testing.T) {
+	orig := ecImage()
+	f, err := uefi.NewFlashImage(append([]byte{}, orig...))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&RemoveRegion{Index: uefi.RegionEC}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(f.Buf()) != len(orig) {
+		t.Fatalf("expected the image to keep its %#x bytes, got %#x", len(orig), len(f.Buf()))
+	}
+	if !uefi.IsErased(f.Buf()[uefi.RegionBlockSize:2*uefi.RegionBlockSize], 0xFF) {
+		t.Error("expected the space of the removed region to be erased")
+	}
+	g, err := uefi.NewFlashImage(append([]byte{}, f.Buf()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.EC != nil || g.IFD.Region.EC.Valid() {
+		t.Errorf("expected no EC region, got %v", &g.IFD.Region.EC)
+	}
+	if err := (&RemoveRegion{Index: uefi.RegionEC}).Run(f); err == nil {
+		t.Error("expected an error removing an unused region")
+	}
+
+	// Put a PD region where the EC region was.
+	add := &AddRegion{Index: uefi.RegionPD, Region: uefi.Region{Base: 1, Limit: 1}, Buf: []byte("PD")}
+	if err := add.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	g, err = uefi.NewFlashImage(append([]byte{}, f.Buf()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.PD == nil || len(g.PD.Buf()) != uefi.RegionBlockSize || !bytes.HasPrefix(g.PD.Buf(), []byte("PD\xff")) {
+		t.Fatalf("expected a 4KiB PD region starting with its data, got %v", g.PD)
+	}
+	if !bytes.Equal(g.BIOS.Buf(), sampleFV) {
+		t.Error("BIOS region changed")
+	}
+
+	// The space is taken now.
+	add = &AddRegion{Index: 10, Region: uefi.Region{Base: 1, Limit: 1}}
+	if err := add.Run(f); err == nil {
+		t.Error("expected an error for overlapping regions")
+	}
+	add = &AddRegion{Index: uefi.RegionBIOS, Region: uefi.Region{Base: 1, Limit: 1}}
+	if err := add.Run(f); err == nil {
+		t.Error("expected an error adding the BIOS region")
+	}
+}
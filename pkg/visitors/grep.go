@@ -0,0 +1,169 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var grepType = flag.String("grep-type", "string", "type of the grep pattern, string, hex or regex")
+
+// GrepMatch is a match found by Grep.
+type GrepMatch struct {
+	// Path is the path of the node as in Ls, by child indices.
+	Path string
+	Node uefi.Firmware
+	// File is the file holding the node, if any.
+	File *uefi.File
+	// Offset is the offset of the match in the buffer of the node.
+	Offset uint64
+	// FlashOffset is the offset of the match in the image. It is only
+	// meaningful if InFlash is set, data in compressed sections has no
+	// flash offset.
+	FlashOffset uint64
+	InFlash     bool
+}
+
+// Grep searches the buffers of the leaf nodes, including the decompressed
+// ones, for a pattern.
+type Grep struct {
+	// Input
+	// Match returns the offsets of the matches in buf.
+	Match func(buf []byte) []int
+	W     io.Writer
+
+	// Output
+	Matches []GrepMatch
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Grep) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the Grep visitor to any Firmware type.
+func (v *Grep) Visit(f uefi.Firmware) error {
+	v.Matches = nil
+	walkLeaves(node{Firmware: f, InFlash: true}, "", nil, func(n node, path string, file *uefi.File) {
+		for _, off := range v.Match(n.Buf()) {
+			v.Matches = append(v.Matches, GrepMatch{
+				Path:        path,
+				Node:        n.Firmware,
+				File:        file,
+				Offset:      uint64(off),
+				FlashOffset: n.Offset + uint64(off),
+				InFlash:     n.InFlash,
+			})
+		}
+	})
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Path\tFile\tName\tType\tOffset\tFlash offset\n")
+	for _, m := range v.Matches {
+		var guid, name string
+		if m.File != nil {
+			guid, name, _ = nodeInfo(m.File)
+		}
+		_, _, typez := nodeInfo(m.Node)
+		flashOffset := "-"
+		if m.InFlash {
+			flashOffset = fmt.Sprintf("%#x", m.FlashOffset)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%#x\t%s\n", m.Path, guid, name, typez, m.Offset, flashOffset)
+	}
+	return tw.Flush()
+}
+
+// GrepBytes returns a matcher for a byte pattern. Overlapping matches are
+// reported.
+func GrepBytes(pattern []byte) func(buf []byte) []int {
+	return func(buf []byte) []int {
+		if len(pattern) == 0 {
+			return nil
+		}
+		var offsets []int
+		for start := 0; ; {
+			i := bytes.Index(buf[start:], pattern)
+			if i < 0 {
+				return offsets
+			}
+			offsets = append(offsets, start+i)
+			start += i + 1
+		}
+	}
+}
+
+// GrepRegexp returns a matcher for a regular expression.
+func GrepRegexp(re *regexp.Regexp) func(buf []byte) []int {
+	return func(buf []byte) []int {
+		var offsets []int
+		for _, m := range re.FindAllIndex(buf, -1) {
+			offsets = append(offsets, m[0])
+		}
+		return offsets
+	}
+}
+
+// ParseGrepPattern returns a matcher for the pattern of the type, string,
+// hex or regex. Strings and regexes are matched case insensitively if
+// ignoreCase is set.
+func ParseGrepPattern(pattern, typez string, ignoreCase bool) (func(buf []byte) []int, error) {
+	switch strings.ToLower(typez) {
+	case "string":
+		if !ignoreCase {
+			return GrepBytes([]byte(pattern)), nil
+		}
+		pattern = regexp.QuoteMeta(pattern)
+	case "hex":
+		b, err := hex.DecodeString(strings.Replace(pattern, " ", "", -1))
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex pattern %q: %v", pattern, err)
+		}
+		return GrepBytes(b), nil
+	case "regex":
+	default:
+		return nil, fmt.Errorf("unknown grep pattern type %q, expected string, hex or regex", typez)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return GrepRegexp(re), nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "grep",
+		Args: []string{"PATTERN"},
+		Help: "Search the leaf nodes, including decompressed sections, for PATTERN and print the path, file and offset of each match. " +
+			"With -grep-type, PATTERN is a string (default), hex bytes or a regex.",
+		Flags: []string{"grep-type", "ignore-case"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			match, err := ParseGrepPattern(args[0], *grepType, *ignoreCase)
+			if err != nil {
+				return nil, err
+			}
+			return &Grep{
+				Match: match,
+			}, nil
+		},
+	})
+}
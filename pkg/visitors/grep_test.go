@@ -0,0 +1,64 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestGrep(t *testing.T) {
+	f := parseImage(t)
+
+	// Strings in compressed drivers are found.
+	match, err := ParseGrepPattern("dxecore", "string", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := &Grep{Match: match, W: ioutil.Discard}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Matches) == 0 {
+		t.Fatal("expected matches in DxeCore")
+	}
+	m := v.Matches[0]
+	if m.Path != "/1/0/0/3/0/1/0" || m.InFlash || m.File == nil || fileName(m.File) != "DxeCore" {
+		t.Errorf("unexpected match %+v", m)
+	}
+
+	// Matches in flash have a flash offset.
+	if match, err = ParseGrepPattern("4d 5a", "hex", false); err != nil {
+		t.Fatal(err)
+	}
+	v = &Grep{Match: match, W: ioutil.Discard}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	var inFlash int
+	for _, m := range v.Matches {
+		if !m.InFlash {
+			continue
+		}
+		inFlash++
+		if !bytes.Equal(f.Buf()[m.FlashOffset:m.FlashOffset+2], []byte("MZ")) {
+			t.Errorf("no match at flash offset %#x of %v", m.FlashOffset, m.Path)
+		}
+	}
+	if inFlash == 0 {
+		t.Error("expected matches in flash")
+	}
+
+	for _, test := range []struct{ pattern, typez string }{
+		{"zz", "hex"},
+		{"(", "regex"},
+		{"x", "bogus"},
+	} {
+		if _, err := ParseGrepPattern(test.pattern, test.typez, false); err == nil {
+			t.Errorf("expected an error for %v pattern %q", test.typez, test.pattern)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// HashDB is a database of the SHA-256 of the modules, i.e. the files, of a
+// known-good image. Files in compressed FVs are included, pad files are not.
+type HashDB struct {
+	Modules []HashDBModule
+}
+
+// HashDBModule is a module in a HashDB.
+type HashDBModule struct {
+	GUID   string
+	Name   string `json:",omitempty"`
+	Type   string
+	SHA256 string
+}
+
+// HashDBChange is a module which was added, removed or modified compared to
+// a HashDB.
+type HashDBChange struct {
+	// Change is "added", "removed" or "modified".
+	Change string
+	GUID   string
+	Name   string `json:",omitempty"`
+	// Old and New are the hashes of the modules with the GUID in the
+	// database and in the image.
+	Old []string `json:",omitempty"`
+	New []string `json:",omitempty"`
+}
+
+// NewHashDB returns the hashes of the modules of f.
+func NewHashDB(f uefi.Firmware) *HashDB {
+	db := &HashDB{}
+	var walk func(n node)
+	walk = func(n node) {
+		if file, ok := n.Firmware.(*uefi.File); ok && file.Header.Type != uefi.FVFileTypePad {
+			guid, name, typez := nodeInfo(file)
+			db.Modules = append(db.Modules, HashDBModule{GUID: guid, Name: name, Type: typez, SHA256: hashBuf(file.Buf())})
+		}
+		for _, c := range children(n) {
+			walk(c)
+		}
+	}
+	walk(node{Firmware: f, InFlash: true})
+	return db
+}
+
+// hashesByGUID returns the sorted hashes of the modules by GUID, along with
+// the names of the modules.
+func (db *HashDB) hashesByGUID() (map[string][]string, map[string]string) {
+	hashes := make(map[string][]string)
+	names := make(map[string]string)
+	for _, m := range db.Modules {
+		g := strings.ToUpper(m.GUID)
+		hashes[g] = append(hashes[g], strings.ToLower(m.SHA256))
+		if m.Name != "" {
+			names[g] = m.Name
+		}
+	}
+	for _, h := range hashes {
+		sort.Strings(h)
+	}
+	return hashes, names
+}
+
+// Compare returns the modules of the image which are not in db, the modules
+// of db which are not in the image, and the modules whose hashes differ.
+// Several modules with the same GUID are compared as a set.
+func (db *HashDB) Compare(image *HashDB) []HashDBChange {
+	oldHashes, oldNames := db.hashesByGUID()
+	newHashes, newNames := image.hashesByGUID()
+	var changes []HashDBChange
+	for g, o := range oldHashes {
+		n, ok := newHashes[g]
+		switch {
+		case !ok:
+			changes = append(changes, HashDBChange{Change: "removed", GUID: g, Name: oldNames[g], Old: o})
+		case strings.Join(o, ",") != strings.Join(n, ","):
+			name := newNames[g]
+			if name == "" {
+				name = oldNames[g]
+			}
+			changes = append(changes, HashDBChange{Change: "modified", GUID: g, Name: name, Old: o, New: n})
+		}
+	}
+	for g, n := range newHashes {
+		if _, ok := oldHashes[g]; !ok {
+			changes = append(changes, HashDBChange{Change: "added", GUID: g, Name: newNames[g], New: n})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Change != changes[j].Change {
+			return changes[i].Change < changes[j].Change
+		}
+		return changes[i].GUID < changes[j].GUID
+	})
+	return changes
+}
+
+// HashDBCreate writes the HashDB of the image to a file, to be checked
+// against with HashDBCheck.
+type HashDBCreate struct {
+	// Input
+	Path string
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *HashDBCreate) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the HashDBCreate visitor to any Firmware type.
+func (v *HashDBCreate) Visit(f uefi.Firmware) error {
+	b, err := json.MarshalIndent(NewHashDB(f), "", "\t")
+	if err != nil {
+		return err
+	}
+	return writeFile(v.Path, append(b, '\n'), 0666)
+}
+
+// HashDBCheck compares the hashes of the modules of the image with a HashDB
+// and prints the added, removed and modified modules. It fails with a
+// VerifyError if there are any.
+type HashDBCheck struct {
+	// Input
+	DB *HashDB
+	W  io.Writer
+
+	// Output
+	Changes []HashDBChange
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *HashDBCheck) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the HashDBCheck visitor to any Firmware type.
+func (v *HashDBCheck) Visit(f uefi.Firmware) error {
+	v.Changes = v.DB.Compare(NewHashDB(f))
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	for _, c := range v.Changes {
+		prefix := map[string]string{"added": "+", "removed": "-", "modified": "~"}[c.Change]
+		fmt.Fprintf(w, "%s %s %s\n", prefix, c.GUID, c.Name)
+	}
+	if len(v.Changes) != 0 {
+		return &VerifyError{fmt.Errorf("%d modules differ from the hash database", len(v.Changes))}
+	}
+	return nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "hashdb_create",
+		Args: []string{"FILE"},
+		Help: "Write the SHA-256 of every module of the image, including those in compressed FVs, to the JSON hash database FILE.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &HashDBCreate{
+				Path: args[0],
+			}, nil
+		},
+	})
+	Register(CLI{
+		Name: "hashdb_check",
+		Args: []string{"FILE"},
+		Help: "Compare the modules of the image with the hash database FILE and print the added (+), removed (-) and modified (~) ones. " +
+			"Fails with an integrity check error if there are any.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			b, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			var db HashDB
+			if err := json.Unmarshal(b, &db); err != nil {
+				return nil, fmt.Errorf("unable to read hash database %v: %v", args[0], err)
+			}
+			return &HashDBCheck{
+				DB: &db,
+			}, nil
+		},
+	})
+}
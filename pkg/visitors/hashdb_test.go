@@ -0,0 +1,73 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestHashDB(t *testing.T) {
+	f := parseImage(t)
+	db := NewHashDB(f)
+	tmpDir, err := ioutil.TempDir("", "hashdb-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, "hashes.json")
+	if err := (&HashDBCreate{Path: path}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	vs, err := ParseCLI([]string{"hashdb_check", path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := vs[0].(*HashDBCheck)
+	check.W = ioutil.Discard
+	if err := check.Run(f); err != nil || len(check.Changes) != 0 {
+		t.Fatalf("expected no changes against the own database, got %v, %+v", err, check.Changes)
+	}
+
+	// Modify the SEC core, remove the VTF and add a module to the database.
+	n, err := resolvePath(f, "/2/SecMain")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sec := n.Firmware.(*uefi.File)
+	buf := append([]byte{}, sec.Buf()...)
+	buf[len(buf)-1] ^= 0xff
+	sec.SetBuf(buf)
+	fv := f.(*uefi.BIOSRegion).Elements[2].Value.(*uefi.FirmwareVolume)
+	fv.Files = fv.Files[:2]
+	db.Modules = append(db.Modules, HashDBModule{GUID: "11111111-2222-3333-4444-555555555555", Type: "EFI_FV_FILETYPE_DRIVER", SHA256: "00"})
+
+	v := &HashDBCheck{DB: db, W: ioutil.Discard}
+	err = v.Run(f)
+	if _, ok := err.(*VerifyError); !ok {
+		t.Errorf("expected a verify error, got %v", err)
+	}
+	var got []string
+	for _, c := range v.Changes {
+		got = append(got, c.Change+" "+c.GUID)
+	}
+	want := []string{
+		"modified DF1CCEF6-F301-4A63-9661-FC6030DCC880",
+		"removed 11111111-2222-3333-4444-555555555555",
+		"removed 1BA0062E-C779-4582-8566-336AE8F78F09",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("expected %v, got %v", want[i], got[i])
+		}
+	}
+}
@@ -6,17 +6,39 @@ package visitors
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
-// JSON prints any Firmware node as JSON.
-type JSON struct{}
+var jsonFilter = flag.String("filter", "", "path of the nodes printed by json, e.g. /bios/1 or /bios/*/SecMain")
+
+// JSON prints any Firmware node as JSON. Each node carries its offset from
+// the start of the image and its length.
+type JSON struct {
+	// Filter is a path as in Ls, selecting the nodes to print instead of
+	// the whole tree. All the children matching a component are selected,
+	// and "*" matches any child.
+	Filter string
+}
 
 // Run wraps Visit and performs some setup and teardown tasks.
 func (v *JSON) Run(f uefi.Firmware) error {
-	return f.Apply(v)
+	setLocations(node{Firmware: f, InFlash: true})
+	if v.Filter == "" {
+		return f.Apply(v)
+	}
+	nodes := matchPath(f, v.Filter)
+	if len(nodes) == 0 {
+		return fmt.Errorf("no node matches %q", v.Filter)
+	}
+	for _, n := range nodes {
+		if err := n.Firmware.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // Visit applies the JSON visitor to any Firmware type.
@@ -30,7 +52,13 @@ func (v *JSON) Visit(f uefi.Firmware) error {
 }
 
 func init() {
-	RegisterCLI("json", 0, func(args []string) (uefi.Visitor, error) {
-		return &JSON{}, nil
+	Register(CLI{
+		Name: "json",
+		Help: "Dump the entire parsed image (excluding binary data) as JSON to stdout. Each node includes its offset in the image and its length. " +
+			"With -filter, only the nodes at the given path are dumped, one document each.",
+		Flags: []string{"filter"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &JSON{Filter: *jsonFilter}, nil
+		},
 	})
 }
@@ -0,0 +1,85 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestSetLocations(t *testing.T) {
+	f := parseImage(t)
+	setLocations(node{Firmware: f, InFlash: true})
+
+	var tests = []struct {
+		path   string
+		offset string
+		length string
+	}{
+		{"/", "0x0", "0x400000"},
+		{"/1", "0x84000", "0x348000"},
+		{"/2/SecMain", "0x3cc078", ""},
+		// Sections in compressed data have no flash offset.
+		{"/1/0/0/0", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			n, err := resolvePath(f, test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := json.Marshal(n.Firmware)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var loc uefi.Location
+			if err := json.Unmarshal(b, &loc); err != nil {
+				t.Fatal(err)
+			}
+			if loc.FlashOffset != test.offset {
+				t.Errorf("FlashOffset is %q, want %q", loc.FlashOffset, test.offset)
+			}
+			if loc.FlashLength == "" || (test.length != "" && loc.FlashLength != test.length) {
+				t.Errorf("FlashLength is %q, want %q", loc.FlashLength, test.length)
+			}
+		})
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"FlashOffset":"0x3cc000"`) {
+		t.Errorf("SEC FV offset missing in the JSON")
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	f := parseImage(t)
+	var tests = []struct {
+		path  string
+		count int
+	}{
+		{"/", 1},
+		{"/1", 1},
+		{"/*", 3},
+		{"/*/SecMain", 1},
+		{"/*/EFI_FV_FILETYPE_FFS_PAD", 1},
+		{"/9", 0},
+		{"/*/nonexistent", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			if nodes := matchPath(f, test.path); len(nodes) != test.count {
+				t.Errorf("got %d nodes, want %d", len(nodes), test.count)
+			}
+		})
+	}
+	if err := (&JSON{Filter: "/9"}).Run(f); err == nil {
+		t.Error("expected an error for a filter without match")
+	}
+}
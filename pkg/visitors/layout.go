@@ -0,0 +1,113 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// erasedPadding creates a BIOSPadding element of the given size holding only
+// erase polarity bytes.
+func erasedPadding(offset, size uint64) *uefi.TypedFirmware {
+	buf := make([]byte, size)
+	uefi.Erase(buf, uefi.Attributes.ErasePolarity)
+	bp, _ := uefi.NewBIOSPadding(buf, offset)
+	return uefi.MakeTyped(bp)
+}
+
+// layoutBIOSRegion recomputes the offsets of the BIOS region elements after
+// some of them changed size.
+//
+// Elements stay at their original offset whenever possible. When an element
+// shrinks, the freed space is filled with erased padding. When an element
+// grows into its successor, the successor is shifted up if it is free space
+// (which then shrinks) or a firmware volume which does not contain the VTF.
+// Padding which holds data and the FV containing the VTF are never moved; if
+// they are in the way, a report of all the clashes is returned as an error.
+func layoutBIOSRegion(br *uefi.BIOSRegion) error {
+	polarity := uefi.Attributes.ErasePolarity
+	var (
+		elements []*uefi.TypedFirmware
+		clashes  []string
+		end      uint64
+	)
+	// prev tracks the previously placed element for the clash report.
+	prev := "start of region"
+	addGap := func(offset uint64) {
+		if offset <= end {
+			return
+		}
+		// Merge with the previous element if it is free space already.
+		if n := len(elements); n > 0 {
+			if bp, ok := elements[n-1].Value.(*uefi.BIOSPadding); ok && uefi.IsErased(bp.Buf(), polarity) {
+				buf := make([]byte, offset-bp.Offset)
+				uefi.Erase(buf, polarity)
+				bp.SetBuf(buf)
+				end = offset
+				return
+			}
+		}
+		elements = append(elements, erasedPadding(end, offset-end))
+		end = offset
+	}
+
+	for _, e := range br.Elements {
+		offset, err := uefi.ElementOffset(e.Value)
+		if err != nil {
+			return err
+		}
+		size := uint64(len(e.Value.Buf()))
+		desc := fmt.Sprintf("%s [%#x, %#x)", e.Type, offset, offset+size)
+
+		switch f := e.Value.(type) {
+		case *uefi.BIOSPadding:
+			if offset < end {
+				if !uefi.IsErased(f.Buf(), polarity) {
+					clashes = append(clashes, fmt.Sprintf("%s overlaps %s, which contains data", prev, desc))
+					break
+				}
+				// Free space absorbs the overflow.
+				overlap := end - offset
+				if overlap >= size {
+					continue
+				}
+				f.SetBuf(f.Buf()[overlap:])
+				f.Offset = end
+				offset = end
+				size -= overlap
+			}
+		case *uefi.FirmwareVolume:
+			if offset < end {
+				if f.HasVTF() {
+					clashes = append(clashes, fmt.Sprintf("%s overlaps %s, which contains the volume top file and cannot move",
+						prev, desc))
+					break
+				}
+				log.Printf("moving FV %v from %#x to %#x", f.FileSystemGUID, offset, end)
+				f.FVOffset = end
+				offset = end
+			}
+		}
+		addGap(offset)
+		elements = append(elements, e)
+		if offset+size > end {
+			end = offset + size
+		}
+		prev = fmt.Sprintf("%s [%#x, %#x)", e.Type, offset, offset+size)
+	}
+	if end > br.Length {
+		clashes = append(clashes, fmt.Sprintf("%s extends past the end of the %#x bytes BIOS region", prev, br.Length))
+	}
+	if len(clashes) != 0 {
+		return fmt.Errorf("unable to lay out BIOS region:\n\t%s", strings.Join(clashes, "\n\t"))
+	}
+	addGap(br.Length)
+	br.Elements = elements
+	return nil
+}
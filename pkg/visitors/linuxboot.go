@@ -0,0 +1,225 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/lzma"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var (
+	linuxbootRemove = flag.String("linuxboot-remove", "",
+		"comma separated regular expressions of the DXE drivers removed by linuxboot, matched against the name or GUID")
+	linuxbootInitramfs = flag.String("linuxboot-initramfs", "", "initramfs added by linuxboot next to the kernel")
+)
+
+// GUIDs of the files added by LinuxBoot. The kernel is an APPLICATION with a
+// PE32 section, the initramfs a FREEFORM file with a RAW section.
+const (
+	LinuxBootKernelGUID    = "6E5AE52D-03F3-4043-8E2D-6D8B5C6A3320"
+	LinuxBootInitramfsGUID = "BE98ECE0-F7CA-4485-8374-1D24B96B0688"
+)
+
+// dxeDriverTypes are the file types of the DXE drivers which may be removed
+// by linuxboot and the DXE lists.
+var dxeDriverTypes = map[uefi.FVFileType]bool{
+	uefi.FVFileTypeDriver:         true,
+	uefi.FVFileTypeApplication:    true,
+	uefi.FVFileTypeSMM:            true,
+	uefi.FVFileTypeCombinedSMMDXE: true,
+}
+
+// LinuxBoot performs the LinuxBoot flow in one step. It removes the matching
+// DXE drivers from the DXE FV, the FV holding the DXE core, drops deleted files
+// and pad files to make room, adds the kernel and initramfs to the DXE FV and
+// saves the image.
+type LinuxBoot struct {
+	// Input
+	// Remove matches the names or GUIDs of the drivers to remove.
+	Remove        []*regexp.Regexp
+	KernelPath    string
+	InitramfsPath string
+	OutPath       string
+	// FVSizes forces FVs to a size, see Assemble.
+	FVSizes map[string]uint64
+	// LZMA encodes the LZMA sections, see Assemble.
+	LZMA lzma.Coder
+	// GuidedPassthrough and Compact are passed to Assemble.
+	GuidedPassthrough bool
+	Compact           bool
+
+	// Output
+	DXEFV   *uefi.FirmwareVolume
+	Removed []*uefi.File
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *LinuxBoot) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	fv := v.DXEFV
+	if fv == nil {
+		return fmt.Errorf("no FV with a DXE core in the image")
+	}
+
+	var kept []*uefi.File
+	for _, file := range fv.Files {
+		if v.removed(file) {
+			v.Removed = append(v.Removed, file)
+			continue
+		}
+		kept = append(kept, file)
+	}
+	for _, re := range v.Remove {
+		if !v.matched(re) {
+			log.Printf("linuxboot: no DXE driver matches %q", re)
+		}
+	}
+	if err := checkProtected("linuxboot", v.Removed); err != nil {
+		return err
+	}
+	if err := checkDependents("linuxboot", f, v.Removed); err != nil {
+		return err
+	}
+	kept = compactFiles(kept)
+
+	added := []ManifestFile{{
+		GUID:     LinuxBootKernelGUID,
+		Type:     "APPLICATION",
+		Name:     "LinuxBoot",
+		Sections: []ManifestSection{{Type: "PE32", Path: v.KernelPath}},
+	}}
+	if v.InitramfsPath != "" {
+		added = append(added, ManifestFile{
+			GUID:     LinuxBootInitramfsGUID,
+			Type:     "FREEFORM",
+			Name:     "Initramfs",
+			Sections: []ManifestSection{{Type: "RAW", Path: v.InitramfsPath}},
+		})
+	}
+	var vtf []*uefi.File
+	if n := len(kept); n != 0 && kept[n-1].IsVTF() {
+		kept, vtf = kept[:n-1], kept[n-1:]
+	}
+	m := &Manifest{}
+	for i := range added {
+		file, err := m.buildFile(&added[i])
+		if err != nil {
+			return fmt.Errorf("linuxboot: %v", err)
+		}
+		kept = append(kept, file)
+	}
+	fv.Files = append(kept, vtf...)
+
+	return (&Save{
+		DirPath:           v.OutPath,
+		FVSizes:           v.FVSizes,
+		LZMA:              v.LZMA,
+		GuidedPassthrough: v.GuidedPassthrough,
+		Compact:           v.Compact,
+	}).Run(f)
+}
+
+// removed returns whether the file is a driver to remove, or a file added by
+// an earlier run, which is replaced.
+func (v *LinuxBoot) removed(f *uefi.File) bool {
+	guid := strings.ToUpper(f.Header.UUID.String())
+	if guid == LinuxBootKernelGUID || guid == LinuxBootInitramfsGUID {
+		return true
+	}
+	if !dxeDriverTypes[f.Header.Type] {
+		return false
+	}
+	name := fileName(f)
+	for _, re := range v.Remove {
+		if re.MatchString(name) || re.MatchString(f.Header.UUID.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// matched returns whether re matched any of the removed drivers.
+func (v *LinuxBoot) matched(re *regexp.Regexp) bool {
+	for _, f := range v.Removed {
+		if re.MatchString(fileName(f)) || re.MatchString(f.Header.UUID.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Visit applies the LinuxBoot visitor to any Firmware type.
+func (v *LinuxBoot) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		if v.DXEFV != nil {
+			return nil
+		}
+		for _, file := range f.Files {
+			if file.Header.Type == uefi.FVFileTypeDXECore {
+				v.DXEFV = f
+				return nil
+			}
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+// ParseRegexps parses a comma separated list of regular expressions.
+func ParseRegexps(s string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, expr := range strings.Split(s, ",") {
+		if expr = strings.TrimSpace(expr); expr == "" {
+			continue
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+func init() {
+	Register(CLI{
+		Name:  "linuxboot",
+		Args:  []string{"KERNEL", "FILE"},
+		Help:  "Remove the DXE drivers matching -linuxboot-remove from the FV holding the DXE core, drop deleted and pad files, add KERNEL as a LinuxBoot application and the optional initramfs to that FV, and save the image to FILE.",
+		Flags: []string{"linuxboot-remove", "linuxboot-initramfs", "depex-check", "guided-passthrough", "compact", "fv-size", "lzma-backend"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			remove, err := ParseRegexps(*linuxbootRemove)
+			if err != nil {
+				return nil, err
+			}
+			sizes, err := ParseFVSizes(*fvSize)
+			if err != nil {
+				return nil, err
+			}
+			coder, err := lzma.NewCoder(lzma.Backend(*lzmaBackend))
+			if err != nil {
+				return nil, err
+			}
+			return &LinuxBoot{
+				Remove:            remove,
+				KernelPath:        args[0],
+				InitramfsPath:     *linuxbootInitramfs,
+				OutPath:           args[1],
+				FVSizes:           sizes,
+				LZMA:              coder,
+				GuidedPassthrough: *guidedPassthrough,
+				Compact:           *compact,
+			}, nil
+		},
+	})
+}
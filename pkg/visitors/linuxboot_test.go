@@ -0,0 +1,99 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+func TestLinuxBoot(t *testing.T) {
+	f := parseImage(t)
+	dir, err := ioutil.TempDir("", "linuxboot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kernel := append([]byte("MZ"), bytes.Repeat([]byte{0x90}, 0x1000)...)
+	initramfs := []byte("070701 initramfs")
+	for name, buf := range map[string][]byte{"bzImage": kernel, "initramfs": initramfs} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), buf, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	remove, err := ParseRegexps("^Shell$, ^Ip4Dxe$,NoSuchDriver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.rom")
+	lb := &LinuxBoot{
+		Remove:        remove,
+		KernelPath:    filepath.Join(dir, "bzImage"),
+		InitramfsPath: filepath.Join(dir, "initramfs"),
+		OutPath:       out,
+	}
+	if err := lb.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(lb.Removed) != 2 || lb.DXEFV == nil {
+		t.Fatalf("expected 2 drivers removed from the DXE FV, got %v", lb.Removed)
+	}
+
+	buf, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved, err := uefi.Parse(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := &Find{Predicate: func(f *uefi.File, name string) bool {
+		return name == "Shell" || name == "Ip4Dxe"
+	}}
+	if err := names.Run(saved); err != nil {
+		t.Fatal(err)
+	}
+	if len(names.Matches) != 0 {
+		t.Errorf("expected Shell and Ip4Dxe to be removed, got %v", names.Matches)
+	}
+	k := find(t, saved, uuid.MustParse(LinuxBootKernelGUID))
+	if len(k) != 1 || fileName(k[0]) != "LinuxBoot" || k[0].Header.Type != uefi.FVFileTypeApplication {
+		t.Fatalf("expected the kernel application, got %v", k)
+	}
+	if !bytes.Equal(k[0].Sections[0].Buf()[4:], kernel) {
+		t.Error("kernel does not match")
+	}
+	if i := find(t, saved, uuid.MustParse(LinuxBootInitramfsGUID)); len(i) != 1 || !bytes.Equal(i[0].Sections[0].Buf()[4:], initramfs) {
+		t.Errorf("expected the initramfs, got %v", i)
+	}
+
+	// Running again replaces the files.
+	if err := (&LinuxBoot{KernelPath: filepath.Join(dir, "bzImage"), OutPath: out}).Run(saved); err != nil {
+		t.Fatal(err)
+	}
+	if k := find(t, saved, uuid.MustParse(LinuxBootKernelGUID)); len(k) != 1 {
+		t.Errorf("expected a single kernel, got %v", k)
+	}
+	if i := find(t, saved, uuid.MustParse(LinuxBootInitramfsGUID)); len(i) != 0 {
+		t.Errorf("expected the initramfs to be dropped, got %v", i)
+	}
+}
+
+func TestLinuxBootNoDXECore(t *testing.T) {
+	fv, err := uefi.NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&LinuxBoot{KernelPath: "bzImage", OutPath: "out.rom"}).Run(fv); err == nil {
+		t.Error("expected an error")
+	}
+}
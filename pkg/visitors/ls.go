@@ -0,0 +1,81 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var hideDeleted = flag.Bool("hide-deleted", false, "hide deleted files from ls and table, they are kept in the image")
+
+// Ls lists the children of the node at the given path along with their flash
+// offsets and sizes.
+type Ls struct {
+	// Input
+	Path string
+	W    io.Writer
+	// HideDeleted skips deleted files, the indices of the other children
+	// are unchanged.
+	HideDeleted bool
+
+	// Private
+	node node
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Ls) Run(f uefi.Firmware) error {
+	n, err := resolvePath(f, v.Path)
+	if err != nil {
+		return err
+	}
+	v.node = n
+	return n.Apply(v)
+}
+
+// Visit applies the Ls visitor to any Firmware type.
+func (v *Ls) Visit(f uefi.Firmware) error {
+	if v.node.Firmware != f {
+		v.node = node{Firmware: f, InFlash: true}
+	}
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Index\tGUID\tName\tType\tOffset\tSize\n")
+	for i, c := range children(v.node) {
+		if file, ok := c.Firmware.(*uefi.File); ok && v.HideDeleted && file.IsDeleted() {
+			continue
+		}
+		guid, name, typez := nodeInfo(c.Firmware)
+		offset := "-"
+		if c.InFlash {
+			offset = fmt.Sprintf("%#x", c.Offset)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%#x\n", i, guid, name, typez, offset, len(c.Buf()))
+	}
+	return tw.Flush()
+}
+
+func init() {
+	Register(CLI{
+		Name:  "ls",
+		Args:  []string{"PATH"},
+		Help:  "List the children of the node at PATH with their GUID, name, type, flash offset and size. PATH is a \"/\" separated list of child indices, GUIDs, names or types, e.g. /bios/0.",
+		Flags: []string{"hide-deleted"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &Ls{
+				Path:        args[0],
+				HideDeleted: *hideDeleted,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestResolvePath(t *testing.T) {
+	f := parseImage(t)
+
+	var tests = []struct {
+		path    string
+		offset  uint64
+		size    int
+		inFlash bool
+	}{
+		{"/", 0, 0x400000, true},
+		{"/1", 0x84000, 0x348000, true},
+		{"/NVRAM_EVSA/NVRAM", 0x48, 0x3ffb8, true},
+		{"/2/SecMain", 0x3cc078, 0x55be, true},
+		{"/2/1ba0062e-c779-4582-8566-336ae8f78f09", 0x400000 - 0x2f8, 0x2f8, true},
+		{"/1/0/0/1", 0x7c, 0xe0004, false},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			n, err := resolvePath(f, test.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n.Offset != test.offset || len(n.Buf()) != test.size || n.InFlash != test.inFlash {
+				t.Errorf("expected offset %#x, size %#x, in flash %v, got %#x, %#x, %v",
+					test.offset, test.size, test.inFlash, n.Offset, len(n.Buf()), n.InFlash)
+			}
+		})
+	}
+
+	if _, err := resolvePath(f, "/5"); err == nil {
+		t.Errorf("expected an error for a missing node")
+	}
+}
+
+func TestLs(t *testing.T) {
+	f := parseImage(t)
+	var b bytes.Buffer
+	if err := (&Ls{Path: "/2", W: &b}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a header and 3 files, got:\n%s", b.String())
+	}
+	if !strings.Contains(lines[1], "SecMain") || !strings.Contains(lines[1], "0x3cc078") {
+		t.Errorf("unexpected listing of SEC core: %q", lines[1])
+	}
+}
@@ -0,0 +1,254 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/unicode"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var (
+	lvfsGUID    = flag.String("lvfs-guid", "", "comma separated device GUIDs for lvfs_metainfo, instead of those of the FMP capsule")
+	lvfsVersion = flag.String("lvfs-version", "", "release version for lvfs_metainfo, instead of the one found in the image")
+	lvfsPayload = flag.String("lvfs-payload", "firmware.bin", "file name of the payload in the cabinet for lvfs_metainfo")
+)
+
+// LVFSMetainfo writes the AppStream metainfo needed to publish the image, or
+// the capsule, on the LVFS for fwupd. The device GUIDs are the image type IDs
+// of an FMP capsule and the version is the most common one of the version
+// sections, or else the version of the ME firmware. Both can be given
+// instead.
+type LVFSMetainfo struct {
+	// Input
+	ID   string
+	Path string
+	// GUIDs and Version are found in the image if not set.
+	GUIDs   []string
+	Version string
+	// Payload is the file name of the image in the cabinet archive.
+	Payload string
+	// W is written to instead of Path if set.
+	W io.Writer
+}
+
+type lvfsComponent struct {
+	XMLName         xml.Name       `xml:"component"`
+	Type            string         `xml:"type,attr"`
+	ID              string         `xml:"id"`
+	Name            string         `xml:"name"`
+	Summary         string         `xml:"summary"`
+	Provides        []lvfsFirmware `xml:"provides>firmware"`
+	MetadataLicense string         `xml:"metadata_license"`
+	ProjectLicense  string         `xml:"project_license"`
+	Releases        []lvfsRelease  `xml:"releases>release"`
+	Custom          []lvfsValue    `xml:"custom>value"`
+}
+
+type lvfsFirmware struct {
+	Type string `xml:"type,attr"`
+	GUID string `xml:",chardata"`
+}
+
+type lvfsRelease struct {
+	Version     string         `xml:"version,attr"`
+	Checksums   []lvfsChecksum `xml:"checksum"`
+	Description string         `xml:"description>p"`
+}
+
+type lvfsChecksum struct {
+	Type     string `xml:"type,attr"`
+	Filename string `xml:"filename,attr"`
+	Target   string `xml:"target,attr"`
+	Value    string `xml:",chardata"`
+}
+
+type lvfsValue struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *LVFSMetainfo) Run(f uefi.Firmware) error {
+	// The checksums are of the assembled image.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	return f.Apply(v)
+}
+
+// Visit applies the LVFSMetainfo visitor to any Firmware type.
+func (v *LVFSMetainfo) Visit(f uefi.Firmware) error {
+	root := node{Firmware: f, InFlash: true}
+	guids := v.GUIDs
+	if len(guids) == 0 {
+		guids = lvfsGUIDs(root)
+	}
+	if len(guids) == 0 {
+		return fmt.Errorf("no FMP capsule to take the device GUIDs from, use -lvfs-guid")
+	}
+	version := v.Version
+	if version == "" {
+		version = imageVersion(root)
+	}
+	if version == "" {
+		return fmt.Errorf("no version section or ME version in the image, use -lvfs-version")
+	}
+	payload := v.Payload
+	if payload == "" {
+		payload = "firmware.bin"
+	}
+
+	sum1 := sha1.Sum(f.Buf())
+	sum256 := sha256.Sum256(f.Buf())
+	c := lvfsComponent{
+		Type:            "firmware",
+		ID:              v.ID,
+		Name:            v.ID,
+		Summary:         "Firmware for " + v.ID,
+		MetadataLicense: "CC0-1.0",
+		ProjectLicense:  "proprietary",
+		Releases: []lvfsRelease{{
+			Version: version,
+			Checksums: []lvfsChecksum{
+				{Type: "sha1", Filename: payload, Target: "content", Value: hex.EncodeToString(sum1[:])},
+				{Type: "sha256", Filename: payload, Target: "content", Value: hex.EncodeToString(sum256[:])},
+			},
+			Description: "Firmware version " + version + ".",
+		}},
+		Custom: []lvfsValue{{Key: "LVFS::VersionFormat", Value: versionFormat(version)}},
+	}
+	for _, g := range guids {
+		c.Provides = append(c.Provides, lvfsFirmware{Type: "flashed", GUID: strings.ToLower(g)})
+	}
+	b, err := xml.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	out := append([]byte(xml.Header), b...)
+	out = append(out, '\n')
+	if v.W != nil {
+		_, err := v.W.Write(out)
+		return err
+	}
+	file, err := createFile(v.Path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(out); err != nil {
+		return &WriteError{err}
+	}
+	return nil
+}
+
+// lvfsGUIDs returns the image type IDs of the FMP capsules below n.
+func lvfsGUIDs(n node) []string {
+	var guids []string
+	if img, ok := n.Firmware.(*uefi.FMPImage); ok {
+		guids = append(guids, img.Header.UpdateImageTypeID.String())
+	}
+	for _, c := range children(n) {
+		guids = append(guids, lvfsGUIDs(c)...)
+	}
+	return guids
+}
+
+// imageVersion returns the most common version string of the version
+// sections below n, or the ME version if there are none.
+func imageVersion(n node) string {
+	count := make(map[string]int)
+	var versions []string
+	var me string
+	var walk func(n node)
+	walk = func(n node) {
+		switch f := n.Firmware.(type) {
+		case *uefi.Section:
+			// EFI_VERSION_SECTION, a build number and a UCS-2 string.
+			if buf, hl := f.Buf(), sectionHeaderLen(f); f.Header.Type == uefi.SectionTypeVersion && uint64(len(buf)) >= hl+4 {
+				if s := strings.TrimRight(unicode.UCS2ToUTF8(buf[hl+2:]), "\x00"); s != "" {
+					if count[s] == 0 {
+						versions = append(versions, s)
+					}
+					count[s]++
+				}
+			}
+		case *uefi.MERegion:
+			if f.Version != nil && me == "" {
+				me = f.Version.String()
+			}
+		}
+		for _, c := range children(n) {
+			walk(c)
+		}
+	}
+	walk(n)
+	best := me
+	max := 0
+	for _, s := range versions {
+		if count[s] > max {
+			best, max = s, count[s]
+		}
+	}
+	return best
+}
+
+// versionFormat returns the LVFS version format of a version string.
+func versionFormat(version string) string {
+	parts := strings.Split(version, ".")
+	for _, p := range parts {
+		if p == "" || strings.Trim(p, "0123456789") != "" {
+			return "plain"
+		}
+	}
+	switch len(parts) {
+	case 2:
+		return "pair"
+	case 3:
+		return "triplet"
+	case 4:
+		return "quad"
+	}
+	return "plain"
+}
+
+func init() {
+	Register(CLI{
+		Name: "lvfs_metainfo",
+		Args: []string{"ID", "FILE"},
+		Help: "Write the AppStream metainfo for publishing the image on the LVFS to FILE, with the component ID, the device GUIDs, " +
+			"the version and the checksums of the assembled image. The GUIDs are the image type IDs of an FMP capsule, the version " +
+			"is taken from the version sections or the ME manifest. Use -lvfs-guid and -lvfs-version otherwise.",
+		Flags: []string{"lvfs-guid", "lvfs-version", "lvfs-payload"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			var guids []string
+			if *lvfsGUID != "" {
+				for _, s := range strings.Split(*lvfsGUID, ",") {
+					g, err := uuid.Parse(strings.TrimSpace(s))
+					if err != nil {
+						return nil, err
+					}
+					guids = append(guids, g.String())
+				}
+			}
+			return &LVFSMetainfo{
+				ID:      args[0],
+				Path:    args[1],
+				GUIDs:   guids,
+				Version: *lvfsVersion,
+				Payload: *lvfsPayload,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+func TestLVFSMetainfo(t *testing.T) {
+	f := parseImage(t)
+	if err := (&LVFSMetainfo{ID: "org.tianocore.OVMF", W: &bytes.Buffer{}}).Run(f); err == nil {
+		t.Error("expected an error without device GUIDs")
+	}
+
+	var buf bytes.Buffer
+	v := &LVFSMetainfo{
+		ID:    "org.tianocore.OVMF",
+		GUIDs: []string{"11111111-2222-3333-4444-555555555555"},
+		W:     &buf,
+	}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(f.Buf())
+	out := buf.String()
+	for _, want := range []string{
+		`<id>org.tianocore.OVMF</id>`,
+		`<firmware type="flashed">11111111-2222-3333-4444-555555555555</firmware>`,
+		`<release version="1.0">`,
+		`filename="firmware.bin" target="content">` + hex.EncodeToString(sum[:]) + `</checksum>`,
+		`<value key="LVFS::VersionFormat">pair</value>`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestLVFSGUIDs(t *testing.T) {
+	guid := uuid.MustParse("AAAAAAAA-BBBB-CCCC-DDDD-EEEEEEEEEEEE")
+	c := &uefi.Capsule{FMPImages: []*uefi.FMPImage{{Header: uefi.FMPImageHeader{UpdateImageTypeID: *guid}}}}
+	got := lvfsGUIDs(node{Firmware: c})
+	if len(got) != 1 || got[0] != guid.String() {
+		t.Errorf("expected %v, got %v", guid, got)
+	}
+}
+
+func TestVersionFormat(t *testing.T) {
+	for version, want := range map[string]string{
+		"1.0":        "pair",
+		"1.2.3":      "triplet",
+		"11.8.50.1":  "quad",
+		"v1.2":       "plain",
+		"2018":       "plain",
+		"1..2":       "plain",
+		"1.2.3.4.5":  "plain",
+		"N1ET52W":    "plain",
+		"0.0.0.0001": "quad",
+	} {
+		if got := versionFormat(version); got != want {
+			t.Errorf("%q: expected %v, got %v", version, want, got)
+		}
+	}
+}
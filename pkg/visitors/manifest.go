@@ -0,0 +1,504 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/unicode"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// Manifest describes an image to build from scratch, so the layout of the
+// firmware can be kept under version control instead of a script of edits.
+// Paths are relative to the directory of the manifest, they use "/" as the
+// separator.
+type Manifest struct {
+	// Descriptor is the path of the flash descriptor. If it is set, a
+	// flash image is built with the regions where the descriptor puts
+	// them. Otherwise, if there are Regions, a descriptor is generated
+	// for the smallest flash chip which holds them after each other and
+	// the BIOS region at the top. Without either the image is a bare BIOS
+	// region.
+	Descriptor string `json:",omitempty"`
+	// Regions are the paths of the binaries of the regions other than
+	// BIOS, by name as in uefi.FlashRegionNames or by index. They are
+	// padded with 0xFF to the size of the region.
+	Regions map[string]string `json:",omitempty"`
+	// Size is the size of the BIOS region. It defaults to the size given
+	// by the descriptor, or to the end of the last FV.
+	Size uint64 `json:",omitempty"`
+	// FVs are placed in the BIOS region in order, the space between
+	// them is erased.
+	FVs []ManifestFV
+
+	dir string
+}
+
+// ManifestFV describes an FV. All FVs have an erase polarity of 0xFF.
+type ManifestFV struct {
+	// Name is the FVName GUID.
+	Name string
+	// FileSystem is FFS2 (default) or FFS3.
+	FileSystem string `json:",omitempty"`
+	// Offset is the offset of the FV in the BIOS region, by default it
+	// follows the previous FV. It is ignored for FVs in sections.
+	Offset *uint64 `json:",omitempty"`
+	// Size is the size of the FV. By default the FV is as large as its
+	// files, rounded up to the block size.
+	Size uint64 `json:",omitempty"`
+	// BlockSize defaults to 0x1000.
+	BlockSize uint64         `json:",omitempty"`
+	Files     []ManifestFile `json:",omitempty"`
+	// Dir is a directory of files which are added after Files, in the
+	// order of their names: complete FFS files ending in .ffs, as built by
+	// EDK2, and PE32 images ending in .efi. The images are named GUID.efi
+	// or NAME_GUID.efi, they are put into files of EFIType, DRIVER by
+	// default, with a user interface section holding NAME. Other files
+	// are ignored. The volume top file is added last.
+	Dir     string `json:",omitempty"`
+	EFIType string `json:",omitempty"`
+}
+
+// ManifestFile describes a file of an FV.
+type ManifestFile struct {
+	GUID string
+	// Type is the file type, as accepted by uefi.ParseFVFileType.
+	Type string
+	// Name adds a user interface section with the name, which is not
+	// compressed.
+	Name string `json:",omitempty"`
+	// Alignment is the alignment of the file data.
+	Alignment uint64 `json:",omitempty"`
+	// Compression is empty, LZMA or LZMAX86. The sections are wrapped in
+	// a GUID defined section compressed with it.
+	Compression string `json:",omitempty"`
+	// Path is the file data, for files without sections such as raw files.
+	Path     string            `json:",omitempty"`
+	Sections []ManifestSection `json:",omitempty"`
+}
+
+// ManifestSection describes a leaf section of a file.
+type ManifestSection struct {
+	// Type is the section type, as accepted by uefi.ParseSectionType.
+	Type string
+	// Path is the section data.
+	Path string `json:",omitempty"`
+	// Text is the string of user interface and version sections.
+	Text string `json:",omitempty"`
+	// FV is the FV of firmware volume image sections.
+	FV *ManifestFV `json:",omitempty"`
+}
+
+// compressionGUIDs maps the compressions of ManifestFile to the GUIDs of
+// their GUID defined sections.
+var compressionGUIDs = map[string]uuid.UUID{
+	"LZMA":    uefi.LZMAGUID,
+	"LZMAX86": uefi.LZMAX86GUID,
+}
+
+// LayoutFile is the manifest of a directory to build an image from.
+const LayoutFile = "layout.json"
+
+// ReadManifest reads a JSON manifest.
+func ReadManifest(path string) (*Manifest, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil, fmt.Errorf("manifest %v: %v", path, err)
+	}
+	m.dir = filepath.Dir(path)
+	return &m, nil
+}
+
+// read reads a file given by a path relative to the manifest.
+func (m *Manifest) read(path string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(m.dir, filepath.FromSlash(path)))
+}
+
+// Build builds and parses the image.
+func (m *Manifest) Build() (uefi.Firmware, error) {
+	if m.Descriptor == "" && len(m.Regions) == 0 {
+		bios, err := m.buildBIOS(m.Size)
+		if err != nil {
+			return nil, err
+		}
+		return uefi.Parse(bios)
+	}
+
+	regions := make(map[int][]byte)
+	for name, path := range m.Regions {
+		i, err := regionIndex(name)
+		if err != nil {
+			return nil, err
+		}
+		if regions[i], err = m.read(path); err != nil {
+			return nil, err
+		}
+	}
+	var desc, bios []byte
+	var err error
+	if m.Descriptor != "" {
+		if desc, err = m.read(m.Descriptor); err != nil {
+			return nil, err
+		}
+	} else {
+		// The descriptor is generated to fit the regions.
+		if bios, err = m.buildBIOS(m.Size); err != nil {
+			return nil, err
+		}
+		if desc, err = createDescriptor(regions, uint64(len(bios))); err != nil {
+			return nil, err
+		}
+	}
+	fd := &uefi.FlashDescriptor{}
+	fd.SetBuf(desc)
+	if err := fd.ParseFlashDescriptor(); err != nil {
+		return nil, fmt.Errorf("descriptor %v: %v", m.Descriptor, err)
+	}
+	var end uint32 = uefi.FlashDescriptorLength
+	for i := range uefi.FlashRegionNames {
+		if r := fd.Region.Region(i); r != nil && r.Valid() && r.EndOffset() > end {
+			end = r.EndOffset()
+		}
+	}
+	image := make([]byte, end)
+	uefi.Erase(image, 0xFF)
+	copy(image, desc)
+
+	for i, buf := range regions {
+		r := fd.Region.Region(i)
+		if r == nil || !r.Valid() {
+			return nil, fmt.Errorf("region %v is not in the descriptor", uefi.FlashRegionNames[i])
+		}
+		if size := r.EndOffset() - r.BaseOffset(); uint64(len(buf)) > uint64(size) {
+			return nil, fmt.Errorf("region %v of %#x bytes does not fit into its %#x bytes",
+				uefi.FlashRegionNames[i], len(buf), size)
+		}
+		copy(image[r.BaseOffset():], buf)
+	}
+	if !fd.Region.BIOS.Valid() {
+		return nil, fmt.Errorf("no BIOS region in descriptor %v", m.Descriptor)
+	}
+	biosOffset := uint64(fd.Region.BIOS.BaseOffset())
+	size := uint64(fd.Region.BIOS.EndOffset()) - biosOffset
+	if bios == nil {
+		if m.Size != 0 && m.Size != size {
+			return nil, fmt.Errorf("BIOS region size %#x differs from the %#x bytes of the descriptor", m.Size, size)
+		}
+		if bios, err = m.buildBIOS(size); err != nil {
+			return nil, err
+		}
+	}
+	copy(image[biosOffset:], bios)
+	return uefi.Parse(image)
+}
+
+// createDescriptor creates a descriptor for the smallest flash chip which
+// holds the regions, in the order of their index after the descriptor, and
+// the BIOS region at the top.
+func createDescriptor(regions map[int][]byte, biosSize uint64) ([]byte, error) {
+	var indices []int
+	for i := range regions {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	layout := make(map[int]uefi.Region)
+	offset := uint64(uefi.FlashDescriptorLength)
+	for _, i := range indices {
+		size := uefi.Align(uint64(len(regions[i])), uefi.RegionBlockSize)
+		if size == 0 {
+			return nil, fmt.Errorf("region %v is empty", uefi.FlashRegionNames[i])
+		}
+		layout[i] = uefi.Region{Base: uint16(offset / uefi.RegionBlockSize), Limit: uint16((offset+size)/uefi.RegionBlockSize - 1)}
+		offset += size
+	}
+	if biosSize == 0 || biosSize%uefi.RegionBlockSize != 0 {
+		return nil, fmt.Errorf("BIOS region size %#x is not a multiple of %#x", biosSize, uefi.RegionBlockSize)
+	}
+	size := uint64(512 * 1024)
+	for size < offset+biosSize {
+		size <<= 1
+	}
+	base := size - biosSize
+	layout[uefi.RegionBIOS] = uefi.Region{Base: uint16(base / uefi.RegionBlockSize), Limit: uint16(size/uefi.RegionBlockSize - 1)}
+	return uefi.CreateFlashDescriptor(size, layout)
+}
+
+// regionIndex returns the index of the region given by its name or index.
+func regionIndex(name string) (int, error) {
+	for i, n := range uefi.FlashRegionNames {
+		if strings.EqualFold(n, name) && i != uefi.RegionDescriptor && i != uefi.RegionBIOS {
+			return i, nil
+		}
+	}
+	if i, err := strconv.Atoi(name); err == nil && i > uefi.RegionBIOS && i < len(uefi.FlashRegionNames) {
+		return i, nil
+	}
+	return 0, fmt.Errorf("unknown region %q, expected a name or index of a region other than descriptor and BIOS", name)
+}
+
+// buildBIOS builds the BIOS region of the given size, or of the size of its
+// FVs if size is 0.
+func (m *Manifest) buildBIOS(size uint64) ([]byte, error) {
+	var bios []byte
+	for _, mfv := range m.FVs {
+		fv, err := m.buildFV(&mfv)
+		if err != nil {
+			return nil, err
+		}
+		offset := uint64(len(bios))
+		if mfv.Offset != nil {
+			if *mfv.Offset < offset {
+				return nil, fmt.Errorf("FV %v at %#x overlaps the previous FV, which ends at %#x", mfv.Name, *mfv.Offset, offset)
+			}
+			offset = *mfv.Offset
+		}
+		for uint64(len(bios)) < offset {
+			bios = append(bios, 0xFF)
+		}
+		bios = append(bios, fv.Buf()...)
+	}
+	if size == 0 {
+		size = uint64(len(bios))
+	}
+	if uint64(len(bios)) > size {
+		return nil, fmt.Errorf("FVs end at %#x, past the end of the %#x bytes BIOS region", len(bios), size)
+	}
+	for uint64(len(bios)) < size {
+		bios = append(bios, 0xFF)
+	}
+	return bios, nil
+}
+
+// buildFV builds and assembles an FV.
+func (m *Manifest) buildFV(mfv *ManifestFV) (*uefi.FirmwareVolume, error) {
+	fileSystem := "FFS2"
+	if mfv.FileSystem != "" {
+		fileSystem = mfv.FileSystem
+	}
+	fs, err := uefi.ParseFVGUID(fileSystem)
+	if err != nil {
+		return nil, err
+	}
+	blockSize := mfv.BlockSize
+	if blockSize == 0 {
+		blockSize = 0x1000
+	}
+	size := mfv.Size
+	if size == 0 {
+		size = blockSize
+	}
+	name, err := uuid.Parse(mfv.Name)
+	if err != nil {
+		return nil, fmt.Errorf("FV %v: %v", mfv.Name, err)
+	}
+	fv, err := uefi.CreateFirmwareVolume(*fs, *name, size, blockSize, 0xFF)
+	if err != nil {
+		return nil, fmt.Errorf("FV %v: %v", mfv.Name, err)
+	}
+	fv.Resizable = mfv.Size == 0
+	uefi.Attributes.ErasePolarity = 0xFF
+	for i := range mfv.Files {
+		f, err := m.buildFile(&mfv.Files[i])
+		if err != nil {
+			return nil, fmt.Errorf("FV %v: file %v: %v", mfv.Name, mfv.Files[i].GUID, err)
+		}
+		fv.Files = append(fv.Files, f)
+	}
+	if mfv.Dir != "" {
+		files, err := m.dirFiles(mfv)
+		if err != nil {
+			return nil, fmt.Errorf("FV %v: %v", mfv.Name, err)
+		}
+		fv.Files = append(fv.Files, files...)
+	}
+	if err := (&Assemble{}).Run(fv); err != nil {
+		return nil, fmt.Errorf("FV %v: %v", mfv.Name, err)
+	}
+	return fv, nil
+}
+
+// dirFiles reads the .ffs and .efi files of the directory of an FV.
+func (m *Manifest) dirFiles(mfv *ManifestFV) ([]*uefi.File, error) {
+	infos, err := ioutil.ReadDir(filepath.Join(m.dir, filepath.FromSlash(mfv.Dir)))
+	if err != nil {
+		return nil, err
+	}
+	efiType := "DRIVER"
+	if mfv.EFIType != "" {
+		efiType = mfv.EFIType
+	}
+	var files []*uefi.File
+	var vtf *uefi.File
+	for _, fi := range infos {
+		name := fi.Name()
+		rel := path.Join(mfv.Dir, name)
+		var f *uefi.File
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".ffs":
+			buf, err := m.read(rel)
+			if err != nil {
+				return nil, err
+			}
+			if f, err = uefi.NewFile(buf); err != nil {
+				return nil, fmt.Errorf("%v: %v", rel, err)
+			}
+			if f == nil {
+				return nil, fmt.Errorf("%v: not an FFS file", rel)
+			}
+		case ".efi":
+			base := strings.TrimSuffix(name, filepath.Ext(name))
+			mf := ManifestFile{GUID: base, Type: efiType, Sections: []ManifestSection{{Type: "PE32", Path: rel}}}
+			if i := len(base) - 37; i > 0 && (base[i] == '_' || base[i] == '.') {
+				mf.Name, mf.GUID = base[:i], base[i+1:]
+			}
+			if f, err = m.buildFile(&mf); err != nil {
+				return nil, fmt.Errorf("%v: %v", rel, err)
+			}
+		default:
+			continue
+		}
+		if f.IsVTF() {
+			vtf = f
+			continue
+		}
+		files = append(files, f)
+	}
+	if vtf != nil {
+		files = append(files, vtf)
+	}
+	return files, nil
+}
+
+// buildFile builds a file, its sections are assembled along with the FV.
+func (m *Manifest) buildFile(mf *ManifestFile) (*uefi.File, error) {
+	guid, err := uuid.Parse(mf.GUID)
+	if err != nil {
+		return nil, err
+	}
+	t, err := uefi.ParseFVFileType(mf.Type)
+	if err != nil {
+		return nil, err
+	}
+	f := &uefi.File{}
+	f.Header.UUID = *guid
+	f.Header.Type = t
+	f.Type = t.String()
+	if mf.Alignment != 0 {
+		if err := f.SetAlignment(mf.Alignment); err != nil {
+			return nil, err
+		}
+	}
+
+	if mf.Path != "" {
+		if len(mf.Sections) != 0 || mf.Name != "" || mf.Compression != "" {
+			return nil, fmt.Errorf("files with a path cannot have sections")
+		}
+		data, err := m.read(mf.Path)
+		if err != nil {
+			return nil, err
+		}
+		f.SetSize(uefi.FileHeaderMinLength+uint64(len(data)), true)
+		f.Header.State = f.StateByte(uefi.Attributes.ErasePolarity)
+		f.DataOffset = f.HeaderLen()
+		return f, f.ChecksumAndAssemble(data)
+	}
+
+	var sections []*uefi.Section
+	for i := range mf.Sections {
+		s, err := m.buildSection(&mf.Sections[i])
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, s)
+	}
+	if len(sections) == 0 {
+		return nil, fmt.Errorf("no sections and no path")
+	}
+	if mf.Compression != "" {
+		guid, ok := compressionGUIDs[strings.ToUpper(mf.Compression)]
+		if !ok {
+			return nil, fmt.Errorf("unknown compression %q, expected LZMA or LZMAX86", mf.Compression)
+		}
+		c := &uefi.Section{}
+		c.Header.Type = uefi.SectionTypeGUIDDefined
+		c.Type = c.Header.Type.String()
+		c.TypeSpecific = &uefi.TypeSpecificHeader{
+			Type: uefi.SectionTypeGUIDDefined,
+			Header: &uefi.SectionGUIDDefined{
+				SectionGUIDDefinedHeader: uefi.SectionGUIDDefinedHeader{
+					GUID:       guid,
+					Attributes: uefi.GUIDEDSectionProcessingRequired,
+				},
+			},
+		}
+		for _, s := range sections {
+			c.Encapsulated = append(c.Encapsulated, uefi.MakeTyped(s))
+		}
+		sections = []*uefi.Section{c}
+	}
+	if mf.Name != "" {
+		s, err := uefi.NewUISection(mf.Name, len(sections))
+		if err != nil {
+			return nil, err
+		}
+		sections = append(sections, s)
+	}
+	f.Sections = sections
+	return f, nil
+}
+
+// buildSection builds a leaf section.
+func (m *Manifest) buildSection(ms *ManifestSection) (*uefi.Section, error) {
+	t, err := uefi.ParseSectionType(ms.Type)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	switch {
+	case t == uefi.SectionTypeUserInterface:
+		data = unicode.UTF8ToUCS2(ms.Text)
+	case t == uefi.SectionTypeVersion:
+		// The build number is not set.
+		data = append(make([]byte, 2), unicode.UTF8ToUCS2(ms.Text)...)
+	case t == uefi.SectionTypeFirmwareVolumeImage && ms.FV != nil:
+		fv, err := m.buildFV(ms.FV)
+		if err != nil {
+			return nil, err
+		}
+		data = fv.Buf()
+	case t == uefi.SectionTypeCompression || t == uefi.SectionTypeGUIDDefined || t == uefi.SectionTypeFreeformSubtypeGUID:
+		return nil, fmt.Errorf("%v sections are not supported, use the Compression of the file", t)
+	case ms.Path == "":
+		return nil, fmt.Errorf("%v section has no path", t)
+	default:
+		if data, err = m.read(ms.Path); err != nil {
+			return nil, err
+		}
+	}
+	s := &uefi.Section{}
+	s.Header.Type = t
+	s.Type = t.String()
+	s.SetBuf(data)
+	if err := s.GenSecHeader(); err != nil {
+		return nil, err
+	}
+	if t == uefi.SectionTypeUserInterface {
+		s.Name = ms.Text
+	}
+	return s, nil
+}
@@ -0,0 +1,259 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/testutil"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+const testManifest = `{
+	"Size": 262144,
+	"FVs": [
+		{
+			"Name": "00000000-1111-2222-3333-444444444444",
+			"Files": [
+				{
+					"GUID": "11111111-2222-3333-4444-555555555555",
+					"Type": "DRIVER",
+					"Name": "TestDriver",
+					"Compression": "LZMA",
+					"Sections": [
+						{"Type": "PE32", "Path": "driver.efi"},
+						{"Type": "VERSION", "Text": "1.0"}
+					]
+				},
+				{
+					"GUID": "22222222-3333-4444-5555-666666666666",
+					"Type": "FREEFORM",
+					"Alignment": 4096,
+					"Sections": [{"Type": "RAW", "Path": "blob.bin"}]
+				},
+				{
+					"GUID": "33333333-4444-5555-6666-777777777777",
+					"Type": "FV",
+					"Sections": [{
+						"Type": "FIRMWARE_VOLUME_IMAGE",
+						"FV": {
+							"Name": "44444444-5555-6666-7777-888888888888",
+							"FileSystem": "FFS3",
+							"Files": [{"GUID": "55555555-6666-7777-8888-999999999999", "Type": "RAW", "Path": "blob.bin"}]
+						}
+					}]
+				}
+			]
+		},
+		{
+			"Name": "66666666-7777-8888-9999-AAAAAAAAAAAA",
+			"Offset": 196608,
+			"Size": 65536,
+			"Files": [{"GUID": "1BA0062E-C779-4582-8566-336AE8F78F09", "Type": "RAW", "Path": "vtf.bin"}]
+		}
+	]
+}`
+
+func writeManifest(t *testing.T, manifest string) string {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"manifest.json": manifest,
+		"driver.efi":    "MZ" + string(bytes.Repeat([]byte{0x90}, 0x3fe)),
+		"blob.bin":      "blob",
+		"vtf.bin":       string(bytes.Repeat([]byte{0xcc}, 0x100)),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestManifestBuild(t *testing.T) {
+	dir := writeManifest(t, testManifest)
+	defer os.RemoveAll(dir)
+
+	m, err := ReadManifest(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := m.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, ok := f.(*uefi.BIOSRegion)
+	if !ok {
+		t.Fatalf("expected a BIOS region, got %T", f)
+	}
+	if len(br.Buf()) != 0x40000 || len(br.Elements) != 3 {
+		t.Fatalf("expected FV, padding and FV in 0x40000 bytes, got %d elements in %#x bytes", len(br.Elements), len(br.Buf()))
+	}
+	for _, err := range br.Validate() {
+		t.Error(err)
+	}
+	top, ok := br.Elements[2].Value.(*uefi.FirmwareVolume)
+	if !ok || top.FVOffset != 0x30000 || !top.HasVTF() {
+		t.Errorf("expected the FV with the VTF at the top, got %v", br.Elements[2].Value)
+	}
+
+	driver := find(t, f, uuid.MustParse("11111111-2222-3333-4444-555555555555"))
+	if len(driver) != 1 || fileName(driver[0]) != "TestDriver" || driver[0].Type != "EFI_FV_FILETYPE_DRIVER" {
+		t.Fatalf("expected the driver, got %v", driver)
+	}
+	guided := driver[0].Sections[0]
+	if ts, ok := guided.TypeSpecific.Header.(*uefi.SectionGUIDDefined); !ok || ts.Compression != "LZMA" || len(guided.Encapsulated) != 2 {
+		t.Errorf("expected 2 LZMA compressed sections, got %v", guided)
+	}
+	freeform := find(t, f, uuid.MustParse("22222222-3333-4444-5555-666666666666"))
+	if len(freeform) != 1 || freeform[0].Header.Attributes.GetAlignment() != 0x1000 {
+		t.Fatalf("expected the 4KiB aligned file, got %v", freeform)
+	}
+	fv := br.Elements[0].Value.(*uefi.FirmwareVolume)
+	if offset := bytes.Index(fv.Buf(), freeform[0].Buf()) + int(freeform[0].DataOffset); offset%0x1000 != 0 {
+		t.Errorf("file data at %#x is not aligned", offset)
+	}
+	if nested := find(t, f, uuid.MustParse("55555555-6666-7777-8888-999999999999")); len(nested) != 1 {
+		t.Errorf("expected the file in the nested FV, got %v", nested)
+	}
+}
+
+func TestManifestErrors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		manifest string
+	}{
+		{"FV overflows the region", `{"Size": 4096, "FVs": [{"Name": "00000000-1111-2222-3333-444444444444", "Size": 8192, "Files": []}]}`},
+		{"overlapping FVs", `{"FVs": [{"Name": "00000000-1111-2222-3333-444444444444", "Files": []},
+			{"Name": "00000000-1111-2222-3333-555555555555", "Offset": 0, "Files": []}]}`},
+		{"unknown file type", `{"FVs": [{"Name": "00000000-1111-2222-3333-444444444444",
+			"Files": [{"GUID": "11111111-2222-3333-4444-555555555555", "Type": "BOGUS", "Path": "blob.bin"}]}]}`},
+		{"missing section data", `{"FVs": [{"Name": "00000000-1111-2222-3333-444444444444",
+			"Files": [{"GUID": "11111111-2222-3333-4444-555555555555", "Type": "RAW", "Sections": [{"Type": "RAW"}]}]}]}`},
+		{"regions without descriptor", `{"Regions": {"EC": "blob.bin"}, "FVs": []}`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir := writeManifest(t, test.manifest)
+			defer os.RemoveAll(dir)
+			m, err := ReadManifest(filepath.Join(dir, "manifest.json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.Build(); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestManifestFlashImage(t *testing.T) {
+	dir := writeManifest(t, `{
+		"Descriptor": "descriptor.bin",
+		"Regions": {"EC": "ec.bin"},
+		"FVs": [{"Name": "00000000-1111-2222-3333-444444444444", "Size": 65536,
+			"Files": [{"GUID": "1BA0062E-C779-4582-8566-336AE8F78F09", "Type": "RAW", "Path": "vtf.bin"}]}]
+	}`)
+	defer os.RemoveAll(dir)
+	// The descriptor has a 4KiB EC region and a 64KiB BIOS region.
+	ifd := testutil.Descriptor(map[int]uefi.Region{
+		uefi.RegionDescriptor: {},
+		uefi.RegionEC:         {Base: 1, Limit: 1},
+		uefi.RegionBIOS:       {Base: 2, Limit: 0x11},
+	})
+	for name, buf := range map[string][]byte{"descriptor.bin": ifd, "ec.bin": []byte("ec")} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), buf, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := ReadManifest(filepath.Join(dir, "manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := m.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		t.Fatalf("expected a flash image, got %T", f)
+	}
+	if len(fi.Buf()) != 0x12000 || !bytes.HasPrefix(fi.EC.Buf(), []byte("ec\xff")) {
+		t.Errorf("unexpected flash image of %#x bytes", len(fi.Buf()))
+	}
+	if fv, ok := fi.BIOS.Elements[0].Value.(*uefi.FirmwareVolume); !ok || fv.Length != 0x10000 || !fv.HasVTF() {
+		t.Errorf("expected the 64KiB FV in the BIOS region, got %v", fi.BIOS.Elements[0].Value)
+	}
+}
+
+func TestManifestDir(t *testing.T) {
+	dir := writeManifest(t, "")
+	defer os.RemoveAll(dir)
+	sec, err := uefi.NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The volume top file comes first by name, but is placed last.
+	files := map[string][]byte{
+		"fv/0-vtf.ffs":     sec.Files[2].Buf(),
+		"fv/1-secmain.ffs": sec.Files[0].Buf(),
+		"fv/Shell_7C04A583-9E3E-4F1C-AD65-E05268D0B4D1.efi": []byte("MZ shell"),
+		"fv/README": []byte("ignored"),
+		"me.bin":    bytes.Repeat([]byte{0x4d}, 0x1800),
+		LayoutFile: []byte(`{
+			"Regions": {"Intel ME": "me.bin"},
+			"FVs": [{"Name": "763BED0D-DE9F-48F5-81F1-3E90E1B1A015", "Size": 262144, "Dir": "fv"}]
+		}`),
+	}
+	if err := os.Mkdir(filepath.Join(dir, "fv"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	for name, buf := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(name)), buf, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := ReadManifest(filepath.Join(dir, LayoutFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := m.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, ok := f.(*uefi.FlashImage)
+	if !ok {
+		t.Fatalf("expected a flash image, got %T", f)
+	}
+	// The generated descriptor puts the 8KiB ME region after it and the
+	// BIOS region at the top of a 512KiB chip.
+	if len(fi.Buf()) != 512*1024 || fi.IFD.Region.ME.BaseOffset() != 0x1000 || fi.IFD.Region.ME.EndOffset() != 0x3000 ||
+		fi.IFD.Region.BIOS.BaseOffset() != 0x40000 {
+		t.Fatalf("unexpected layout of %#x bytes: %v", len(fi.Buf()), fi.IFD.Region)
+	}
+	if !bytes.HasPrefix(fi.ME.Buf(), files["me.bin"]) {
+		t.Error("ME region was not copied")
+	}
+	fv, ok := fi.BIOS.Elements[0].Value.(*uefi.FirmwareVolume)
+	if !ok || len(fv.Files) < 3 {
+		t.Fatalf("expected the FV in the BIOS region, got %v", fi.BIOS.Elements[0].Value)
+	}
+	if fv.Files[0].Header.UUID != *testGUID || fileName(fv.Files[1]) != "Shell" || !fv.Files[len(fv.Files)-1].IsVTF() {
+		t.Errorf("expected SecMain, Shell and the VTF, got %v", fv.Files)
+	}
+	if !bytes.HasSuffix(fi.Buf(), sec.Files[2].Buf()) {
+		t.Error("expected the VTF at the top of the flash")
+	}
+}
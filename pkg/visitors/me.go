@@ -0,0 +1,218 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// MEInfo prints the ME firmware version and SKU, and the ME partitions.
+type MEInfo struct {
+	// Input
+	W io.Writer
+
+	// Output
+	ME *uefi.MERegion
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *MEInfo) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.ME == nil {
+		return errors.New("no ME region found")
+	}
+	return nil
+}
+
+// Visit applies the MEInfo visitor to any Firmware type.
+func (v *MEInfo) Visit(f uefi.Firmware) error {
+	me, ok := f.(*uefi.MERegion)
+	if !ok {
+		return f.ApplyChildren(v)
+	}
+	v.ME = me
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	if me.Version != nil {
+		signing := "production"
+		if me.Version.Debug {
+			signing = "debug"
+		}
+		fmt.Fprintf(w, "ME firmware version %v (%s), date %08x\n", me.Version, signing, me.Version.Date)
+		if me.Version.SKU != nil {
+			fmt.Fprintf(w, "SKU attributes %x\n", me.Version.SKU)
+		}
+	} else {
+		fmt.Fprintln(w, "ME firmware version unknown")
+	}
+	if me.MFS != nil {
+		system, data, spare := me.MFS.Count()
+		fmt.Fprintf(w, "MFS pages: %d system, %d data, %d spare\n", system, data, spare)
+	}
+	if me.FPT == nil {
+		return nil
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Partition\tOffset\tLength\n")
+	for _, e := range me.FPT.Entries {
+		fmt.Fprintf(tw, "%s\t%#x\t%#x\n", e.PartitionName(), e.Offset, e.Length)
+	}
+	return tw.Flush()
+}
+
+// findME returns the ME region of the image.
+func findME(f uefi.Firmware) (*uefi.MERegion, error) {
+	info := MEInfo{W: ioutil.Discard}
+	if err := info.Run(f); err != nil {
+		return nil, err
+	}
+	if info.ME.FPT == nil {
+		return nil, errors.New("ME region has no partition table")
+	}
+	return info.ME, nil
+}
+
+// ExtractMEPartition writes the named ME partition to OutFile.
+type ExtractMEPartition struct {
+	// Input
+	Name    string
+	OutFile string
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ExtractMEPartition) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ExtractMEPartition visitor to any Firmware type.
+func (v *ExtractMEPartition) Visit(f uefi.Firmware) error {
+	me, err := findME(f)
+	if err != nil {
+		return err
+	}
+	part, err := me.Partition(v.Name)
+	if err != nil {
+		return err
+	}
+	return writeFile(v.OutFile, part, 0666)
+}
+
+// ReplaceMEPartition replaces the named ME partition with NewPartition and
+// updates the partition table. The partition is not re-signed.
+type ReplaceMEPartition struct {
+	// Input
+	Name         string
+	NewPartition []byte
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ReplaceMEPartition) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ReplaceMEPartition visitor to any Firmware type.
+func (v *ReplaceMEPartition) Visit(f uefi.Firmware) error {
+	me, err := findME(f)
+	if err != nil {
+		return err
+	}
+	return me.ReplacePartition(v.Name, v.NewPartition)
+}
+
+// MEMFS lists the files and configuration records of the ME file system.
+type MEMFS struct {
+	// Input
+	W io.Writer
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *MEMFS) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the MEMFS visitor to any Firmware type.
+func (v *MEMFS) Visit(f uefi.Firmware) error {
+	me, err := findME(f)
+	if err != nil {
+		return err
+	}
+	if me.MFS == nil || me.MFS.Volume == nil {
+		return errors.New("ME region has no parsable MFS")
+	}
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "File\tSize\n")
+	for _, file := range me.MFS.Files {
+		fmt.Fprintf(tw, "%d\t%#x\n", file.Index, file.Size)
+	}
+	if len(me.MFS.Config) != 0 {
+		fmt.Fprintf(tw, "\nRecord\tMode\tOptions\tSize\tData\n")
+	}
+	for _, r := range me.MFS.Config {
+		data := fmt.Sprintf("%x", r.Data)
+		if len(r.Data) > 16 {
+			data = fmt.Sprintf("%x...", r.Data[:16])
+		}
+		fmt.Fprintf(tw, "%s\t%#o\t%#x\t%#x\t%s\n", r.Path, r.Header.Mode, r.Header.Options, r.Header.Size, data)
+	}
+	return tw.Flush()
+}
+
+func init() {
+	Register(CLI{
+		Name: "me_info",
+		Help: "Print the ME firmware version, SKU and partitions.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &MEInfo{}, nil
+		},
+	})
+	Register(CLI{
+		Name: "me_mfs",
+		Help: "List the files and configuration records of the ME file system.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &MEMFS{}, nil
+		},
+	})
+	Register(CLI{
+		Name: "extract_me_partition",
+		Args: []string{"NAME", "FILE"},
+		Help: "Write the ME partition NAME to FILE.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &ExtractMEPartition{
+				Name:    args[0],
+				OutFile: args[1],
+			}, nil
+		},
+	})
+	Register(CLI{
+		Name: "replace_me_partition",
+		Args: []string{"NAME", "FILE"},
+		Help: "Replace the ME partition NAME with the contents of FILE and update the FPT. The partition is not re-signed.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			newPartition, err := ioutil.ReadFile(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return &ReplaceMEPartition{
+				Name:         args[0],
+				NewPartition: newPartition,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// NVRAMGC compacts variable stores the way the variable driver reclaims
+// space: deleted and incomplete variables are dropped, the remaining ones are
+// packed at the start of the store and the store header is marked healthy.
+type NVRAMGC struct {
+	// Output
+	Removed []*uefi.Variable
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *NVRAMGC) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the NVRAMGC visitor to any Firmware type.
+func (v *NVRAMGC) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		uefi.Attributes.ErasePolarity = f.GetErasePolarity()
+
+	case *uefi.VariableStore:
+		// A variable in deleted transition is only kept if the update never made it.
+		added := make(map[string]bool)
+		key := func(va *uefi.Variable) string {
+			return va.Header.VendorGUID.String() + ":" + va.Name
+		}
+		for _, va := range f.Variables {
+			if va.Header.State == uefi.VarAdded {
+				added[key(va)] = true
+			}
+		}
+		var live, transition []*uefi.Variable
+		for _, va := range f.Variables {
+			switch {
+			case va.Header.State == uefi.VarAdded:
+				live = append(live, va)
+			case va.Header.State.Valid() && !added[key(va)]:
+				live = append(live, va)
+				transition = append(transition, va)
+			default:
+				v.Removed = append(v.Removed, va)
+			}
+		}
+		f.Variables = live
+		f.Header.Format = uefi.VariableStoreFormatted
+		f.Header.State = uefi.VariableStoreHealthy
+		if err := f.Rebuild(); err != nil {
+			return err
+		}
+		// Only touch the state once the variables live in the new buffer.
+		for _, va := range transition {
+			va.SetState(uefi.VarAdded)
+		}
+	}
+
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	Register(CLI{
+		Name: "nvram_gc",
+		Help: "Compact the NVRAM variable stores, dropping deleted variables and resetting the free space.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &NVRAMGC{}, nil
+		},
+	})
+}
@@ -0,0 +1,71 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/testutil"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var testVendorGUID = uuid.MustParse("8BE4DF61-93CA-11D2-AA0D-00E098032B8C")
+
+// testVariable returns a variable of the test vendor, whose data is its name
+// followed by " data".
+func testVariable(name string, state uefi.VariableState) []byte {
+	h := uefi.VariableHeader{State: state, VendorGUID: *testVendorGUID}
+	return testutil.Variable(h, name, []byte(name+" data"))
+}
+
+func TestNVRAMGC(t *testing.T) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	transition := uefi.VarAdded & uefi.VarInDeletedTransition
+	vars := [][]byte{
+		testVariable("Added", uefi.VarAdded),
+		testVariable("Deleted", uefi.VarAdded&uefi.VarDeleted),
+		testVariable("Incomplete", uefi.VarHeaderValidOnly),
+		testVariable("Interrupted", transition),
+		testVariable("Updated", transition),
+		testVariable("Updated", uefi.VarAdded),
+	}
+	orig := testutil.VariableStore(0x1000, vars...)
+	vs, err := uefi.NewVariableStore(append([]byte{}, orig...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vs.Variables) != len(vars) {
+		t.Fatalf("expected %d variables, got %d", len(vars), len(vs.Variables))
+	}
+
+	gc := &NVRAMGC{}
+	if err := gc.Run(vs); err != nil {
+		t.Fatal(err)
+	}
+	if len(gc.Removed) != 3 {
+		t.Errorf("expected 3 variables to be removed, got %d", len(gc.Removed))
+	}
+	expected := testutil.VariableStore(0x1000, vars[0], testVariable("Interrupted", uefi.VarAdded), vars[5])
+	if !bytes.Equal(vs.Buf(), expected) {
+		t.Errorf("compacted store mismatch")
+	}
+
+	// The compacted store parses back to the same variables.
+	nvs, err := uefi.NewVariableStore(vs.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nvs.Variables) != 3 || nvs.FreeSpaceOffset != vs.FreeSpaceOffset {
+		t.Errorf("expected 3 variables and free space at %#x, got %d and %#x", vs.FreeSpaceOffset,
+			len(nvs.Variables), nvs.FreeSpaceOffset)
+	}
+	for _, v := range nvs.Variables {
+		if v.Header.State != uefi.VarAdded {
+			t.Errorf("variable %v has state %v, expected %v", v.Name, v.Header.State, uefi.VarAdded)
+		}
+	}
+}
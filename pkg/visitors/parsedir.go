@@ -5,9 +5,13 @@
 package visitors
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
@@ -15,6 +19,14 @@ import (
 // ParseDir creates the firmware tree and reads the binaries from the provided directory
 type ParseDir struct {
 	DirPath string
+	// SkipHashes disables the check of the binaries against their hashes in
+	// the summary JSON, e.g. for damaged or hand edited extractions. They
+	// are not checked in the permissive parse mode either.
+	SkipHashes bool
+
+	// hashes are the SHA-256 of the extracted binaries by path, from the
+	// summary JSON.
+	hashes map[string]string
 }
 
 // Run is not actually implemented cause we can't fit the interface
@@ -38,12 +50,12 @@ func (v *ParseDir) Parse() (uefi.Firmware, error) {
 	if err != nil {
 		return nil, err
 	}
-	f, err := uefi.UnmarshalFirmware(jsonbuf)
+	f, hashes, err := uefi.UnmarshalSummary(jsonbuf)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = f.Apply(&ParseDir{DirPath: "."}); err != nil {
+	if err = f.Apply(&ParseDir{DirPath: ".", SkipHashes: v.SkipHashes, hashes: hashes}); err != nil {
 		return nil, err
 	}
 
@@ -54,11 +66,23 @@ func (v *ParseDir) Parse() (uefi.Firmware, error) {
 	return f, nil
 }
 
-func readBuf(ExtractPath string) ([]byte, error) {
-	if ExtractPath != "" {
-		return ioutil.ReadFile(ExtractPath)
+// readBuf reads an extracted binary and checks it against its hash from the
+// summary JSON, if there is one.
+func (v *ParseDir) readBuf(ExtractPath string) ([]byte, error) {
+	if ExtractPath == "" {
+		return nil, nil
 	}
-	return nil, nil
+	buf, err := ioutil.ReadFile(filepath.FromSlash(ExtractPath))
+	if err != nil {
+		return nil, err
+	}
+	if want, ok := v.hashes[ExtractPath]; ok && !v.SkipHashes && uefi.CurrentParseMode() != uefi.ParsePermissive {
+		if sum := sha256.Sum256(buf); hex.EncodeToString(sum[:]) != want {
+			return nil, &VerifyError{fmt.Errorf("%v was modified after extraction, its SHA-256 does not match summary.json; "+
+				"remove it from ExtractHashes or use the permissive parse mode to use it anyway", ExtractPath)}
+		}
+	}
+	return buf, nil
 }
 
 // Visit applies the ParseDir visitor to any Firmware type.
@@ -68,31 +92,77 @@ func (v *ParseDir) Visit(f uefi.Firmware) error {
 	switch f := f.(type) {
 
 	case *uefi.FirmwareVolume:
-		fBuf, err = readBuf(f.ExtractPath)
+		fBuf, err = v.readBuf(f.ExtractPath)
+		if err == nil && f.VariableStore != nil && f.DataOffset < uint64(len(fBuf)) {
+			// The variable store is not extracted by itself, reparse it from the volume.
+			f.VariableStore, err = uefi.NewVariableStore(fBuf[f.DataOffset:])
+		}
+		if err == nil && f.FTWWorkingBlock != nil {
+			// Likewise the FTW working block.
+			f.SetBuf(fBuf)
+			err = f.ParseFTW()
+		}
+		if err == nil && f.PhoenixFlashMap != nil {
+			// And the Phoenix flash map.
+			f.SetBuf(fBuf)
+			err = f.ParsePhoenixFlashMap()
+		}
 
 	case *uefi.File:
-		fBuf, err = readBuf(f.ExtractPath)
+		fBuf, err = v.readBuf(f.ExtractPath)
 
 	case *uefi.Section:
-		fBuf, err = readBuf(f.ExtractPath)
+		fBuf, err = v.readBuf(f.ExtractPath)
+		if err == nil && f.Header.Type == uefi.SectionTypeFreeformSubtypeGUID && f.TypeSpecific != nil {
+			// Only the payload was extracted, rebuild the headers from the JSON.
+			f.SetBuf(fBuf)
+			if err = f.GenSecHeader(); err == nil {
+				fBuf = f.Buf()
+			}
+		}
+
+	case *uefi.CapsuleFile:
+		fBuf, err = v.readBuf(f.ExtractPath)
+
+	case *uefi.Capsule:
+		fBuf, err = v.readBuf(f.ExtractPath)
+
+	case *uefi.FMPImage:
+		fBuf, err = v.readBuf(f.ExtractPath)
+
+	case *uefi.IFlashFile:
+		fBuf, err = v.readBuf(f.ExtractPath)
+
+	case *uefi.IFlashImage:
+		fBuf, err = v.readBuf(f.ExtractPath)
 
 	case *uefi.FlashDescriptor:
-		fBuf, err = readBuf(f.ExtractPath)
+		fBuf, err = v.readBuf(f.ExtractPath)
 
 	case *uefi.BIOSRegion:
-		fBuf, err = readBuf(f.ExtractPath)
+		fBuf, err = v.readBuf(f.ExtractPath)
 
 	case *uefi.GBERegion:
-		fBuf, err = readBuf(f.ExtractPath)
+		fBuf, err = v.readBuf(f.ExtractPath)
 
 	case *uefi.MERegion:
-		fBuf, err = readBuf(f.ExtractPath)
+		fBuf, err = v.readBuf(f.ExtractPath)
 
 	case *uefi.PDRegion:
-		fBuf, err = readBuf(f.ExtractPath)
+		fBuf, err = v.readBuf(f.ExtractPath)
+
+	case *uefi.ECRegion:
+		fBuf, err = v.readBuf(f.ExtractPath)
+
+	case *uefi.RawRegion:
+		fBuf, err = v.readBuf(f.ExtractPath)
 
 	case *uefi.BIOSPadding:
-		fBuf, err = readBuf(f.ExtractPath)
+		fBuf, err = v.readBuf(f.ExtractPath)
+
+	case *uefi.VariableStore:
+		// Parsed along with the volume.
+		fBuf = f.Buf()
 	}
 
 	if err != nil {
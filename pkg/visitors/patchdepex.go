@@ -0,0 +1,105 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// PatchDepEx rewrites the dependency expressions of the files matching
+// Predicate, e.g. to force the dispatch of a module during bring-up.
+type PatchDepEx struct {
+	// Input
+	Predicate func(f *uefi.File, name string) bool
+	// Remove is the GUID whose terms are removed from the expressions, see
+	// uefi.DepExWithout. If nil, the expressions are replaced with TRUE.
+	Remove *uuid.UUID
+
+	// Output
+	Matches []*uefi.File
+	// Patched are the rewritten sections.
+	Patched []*uefi.Section
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *PatchDepEx) Run(f uefi.Firmware) error {
+	find := Find{
+		Predicate: v.Predicate,
+	}
+	if err := find.Run(f); err != nil {
+		return err
+	}
+	v.Matches = find.Matches
+	if err := checkProtected("patch_depex", v.Matches); err != nil {
+		return err
+	}
+	for _, m := range v.Matches {
+		if err := m.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Visit applies the PatchDepEx visitor to any Firmware type.
+func (v *PatchDepEx) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		patched := len(v.Patched)
+		var err error
+		walkSections(f.Sections, func(s *uefi.Section) {
+			if err != nil || !depexTypes[s.Header.Type] {
+				return
+			}
+			ops := []uefi.DepExOp{{OpCode: "TRUE"}, {OpCode: "END"}}
+			if v.Remove != nil {
+				if ops, err = uefi.DepExWithout(s.DepEx, *v.Remove); err != nil {
+					err = fmt.Errorf("file %v: %v", f.Header.UUID, err)
+					return
+				}
+			}
+			if err = s.SetDepEx(ops); err != nil {
+				return
+			}
+			v.Patched = append(v.Patched, s)
+		})
+		if err == nil && len(v.Patched) == patched {
+			log.Printf("patch_depex: file %v has no dependency expression", f.Header.UUID)
+		}
+		return err
+	}
+	return nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "patch_depex",
+		Args: []string{"(GUID|NAME)", "(TRUE|GUID)"},
+		Help: "Rewrite the dependency expressions of the files which match the given GUID or NAME. With TRUE, they are replaced with TRUE, with a GUID, the terms pushing or ordering against it are treated as satisfied.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			searchRE, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			v := &PatchDepEx{
+				Predicate: func(f *uefi.File, name string) bool {
+					return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
+				},
+			}
+			if !strings.EqualFold(args[1], "TRUE") {
+				if v.Remove, err = uuid.Parse(args[1]); err != nil {
+					return nil, err
+				}
+			}
+			return v, nil
+		},
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// fileDepEx returns the dependency expression of the file.
+func fileDepEx(f *uefi.File) []uefi.DepExOp {
+	var ops []uefi.DepExOp
+	walkSections(f.Sections, func(s *uefi.Section) {
+		if depexTypes[s.Header.Type] {
+			ops = s.DepEx
+		}
+	})
+	return ops
+}
+
+func TestPatchDepEx(t *testing.T) {
+	runtime := uuid.MustParse("B601F8C4-43B7-4784-95B1-F4226CB40CEE")
+	var tests = []struct {
+		name   string
+		remove *uuid.UUID
+		want   func(old []uefi.DepExOp) []uefi.DepExOp
+	}{
+		{"true", nil, func(old []uefi.DepExOp) []uefi.DepExOp {
+			return []uefi.DepExOp{{OpCode: "TRUE"}, {OpCode: "END"}}
+		}},
+		{"remove", &pcdProtocol, func(old []uefi.DepExOp) []uefi.DepExOp {
+			ops, err := uefi.DepExWithout(old, pcdProtocol)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return ops
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f := parseImage(t)
+			old := fileDepEx(find(t, f, runtime)[0])
+			if len(old) < 3 {
+				t.Fatalf("expected RuntimeDxe to have a depex, got %v", old)
+			}
+			want := test.want(old)
+			if reflect.DeepEqual(want, old) {
+				t.Fatalf("expected the depex %v to change", old)
+			}
+			for _, op := range want {
+				if op.GUID != nil && *op.GUID == pcdProtocol {
+					t.Fatalf("PCD protocol left in %v", want)
+				}
+			}
+
+			p := &PatchDepEx{
+				Predicate: func(f *uefi.File, name string) bool {
+					return name == "RuntimeDxe"
+				},
+				Remove: test.remove,
+			}
+			if err := p.Run(f); err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Matches) != 1 || len(p.Patched) != 1 {
+				t.Fatalf("expected one patched section, got %d in %d files", len(p.Patched), len(p.Matches))
+			}
+
+			// The depex is in a compressed FV, check it after reparsing.
+			if err := (&Assemble{}).Run(f); err != nil {
+				t.Fatal(err)
+			}
+			reparsed, err := uefi.Parse(f.Buf())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fileDepEx(find(t, reparsed, runtime)[0]); !reflect.DeepEqual(got, want) {
+				t.Errorf("expected %v, got %v", want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// node is a firmware node along with its location in the flash image.
+type node struct {
+	uefi.Firmware
+	// Offset is the offset from the start of the image. It is only meaningful
+	// if InFlash is set, nodes inside compressed sections have no flash offset.
+	Offset  uint64
+	InFlash bool
+}
+
+// nodeInfo returns the GUID, name and type of a node for display.
+func nodeInfo(f uefi.Firmware) (guid, name, typez string) {
+	switch f := f.(type) {
+	case *uefi.FlashImage:
+		return "", "", "Image"
+	case *uefi.CapsuleFile:
+		return "", "", "CapsuleFile"
+	case *uefi.Capsule:
+		return f.Header.GUID.String(), f.Vendor, "Capsule"
+	case *uefi.FMPImage:
+		return f.Header.UpdateImageTypeID.String(), "", "FMP"
+	case *uefi.IFlashFile:
+		return "", "", "IFlashFile"
+	case *uefi.IFlashImage:
+		return "", f.Tag, "IFlash"
+	case *uefi.FlashDescriptor:
+		return "", "", "IFD"
+	case *uefi.BIOSRegion:
+		return "", "", "BIOS"
+	case *uefi.MERegion:
+		return "", "", "ME"
+	case *uefi.GBERegion:
+		return "", "", "GBE"
+	case *uefi.PDRegion:
+		return "", "", "PD"
+	case *uefi.ECRegion:
+		return "", "", "EC"
+	case *uefi.RawRegion:
+		return "", f.Name, fmt.Sprintf("Region%d", f.Index)
+	case *uefi.BIOSPadding:
+		return "", "", "BIOS Pad"
+	case *uefi.FirmwareVolume:
+		return f.FileSystemGUID.String(), uefi.FVGUIDs[f.FileSystemGUID], "FV"
+	case *uefi.File:
+		return f.Header.UUID.String(), fileName(f), f.Header.Type.String()
+	case *uefi.Section:
+		return "", f.Name, f.Type
+	case *uefi.VariableStore:
+		return f.Header.Signature.String(), "", "NVRAM"
+	}
+	return "", "", fmt.Sprintf("%T", f)
+}
+
+// fileName returns the name from the UI section of a file, if there is one.
+func fileName(f *uefi.File) string {
+	for _, s := range f.Sections {
+		if s.Header.Type == uefi.SectionTypeUserInterface {
+			return s.Name
+		}
+	}
+	return ""
+}
+
+// sectionHeaderLen returns the length of the common section header.
+func sectionHeaderLen(s *uefi.Section) uint64 {
+	if s.Header.Size == [3]uint8{0xFF, 0xFF, 0xFF} {
+		return 8
+	}
+	return 4
+}
+
+// children returns the child nodes of n, following the layout the parser
+// uses.
+func children(n node) []node {
+	var nodes []node
+	add := func(f uefi.Firmware, offset uint64, inFlash bool) {
+		nodes = append(nodes, node{Firmware: f, Offset: offset, InFlash: inFlash})
+	}
+	switch f := n.Firmware.(type) {
+	case *uefi.FlashImage:
+		add(&f.IFD, 0, n.InFlash)
+		if f.BIOS != nil {
+			add(f.BIOS, uint64(f.BIOS.Position.BaseOffset()), n.InFlash)
+		}
+		if f.ME != nil {
+			add(f.ME, uint64(f.ME.Position.BaseOffset()), n.InFlash)
+		}
+		if f.GBE != nil {
+			add(f.GBE, uint64(f.GBE.Position.BaseOffset()), n.InFlash)
+		}
+		if f.PD != nil {
+			add(f.PD, uint64(f.PD.Position.BaseOffset()), n.InFlash)
+		}
+		if f.EC != nil {
+			add(f.EC, uint64(f.EC.Position.BaseOffset()), n.InFlash)
+		}
+		for _, r := range f.Regions {
+			add(r, uint64(r.Position.BaseOffset()), n.InFlash)
+		}
+	case *uefi.CapsuleFile:
+		for _, c := range f.Capsules {
+			add(c, n.Offset+c.Offset, n.InFlash)
+		}
+	case *uefi.Capsule:
+		if f.Payload != nil {
+			add(f.Payload.Value, n.Offset+f.PayloadOffset, n.InFlash)
+		}
+		for _, img := range f.FMPImages {
+			add(img, n.Offset+img.Offset, n.InFlash)
+		}
+	case *uefi.FMPImage:
+		if f.Payload != nil {
+			add(f.Payload.Value, n.Offset+f.HeaderLen+f.AuthLen, n.InFlash)
+		}
+	case *uefi.IFlashFile:
+		for _, img := range f.Images {
+			add(img, n.Offset+img.Offset, n.InFlash)
+		}
+	case *uefi.IFlashImage:
+		if f.Payload != nil {
+			add(f.Payload.Value, n.Offset+uefi.IFlashHeaderSize, n.InFlash)
+		}
+	case *uefi.BIOSRegion:
+		for _, e := range f.Elements {
+			offset, _ := uefi.ElementOffset(e.Value)
+			add(e.Value, n.Offset+offset, n.InFlash)
+		}
+	case *uefi.FirmwareVolume:
+		offset := f.DataOffset
+		for _, file := range f.Files {
+			offset = uefi.Align8(offset)
+			add(file, n.Offset+offset, n.InFlash)
+			offset += uint64(len(file.Buf()))
+		}
+		if f.VariableStore != nil {
+			add(f.VariableStore, n.Offset+f.DataOffset, n.InFlash)
+		}
+	case *uefi.File:
+		offset := f.DataOffset
+		for _, s := range f.Sections {
+			add(s, n.Offset+offset, n.InFlash)
+			offset = uefi.Align4(offset + uint64(len(s.Buf())))
+		}
+	case *uefi.Section:
+		if f.Header.Type == uefi.SectionTypeFirmwareVolumeImage {
+			for _, e := range f.Encapsulated {
+				add(e.Value, n.Offset+sectionHeaderLen(f), n.InFlash)
+			}
+			break
+		}
+		// Anything else is encapsulated in decompressed data.
+		var offset uint64
+		for _, e := range f.Encapsulated {
+			add(e.Value, offset, false)
+			offset = uefi.Align4(offset + uint64(len(e.Value.Buf())))
+		}
+	}
+	return nodes
+}
+
+// walkLeaves calls fn on each leaf node below n, including the decompressed
+// ones, along with its path by child indices and the file holding it, if any.
+func walkLeaves(n node, path string, file *uefi.File, fn func(n node, path string, file *uefi.File)) {
+	if f, ok := n.Firmware.(*uefi.File); ok {
+		file = f
+	}
+	cs := children(n)
+	if len(cs) == 0 {
+		if path == "" {
+			path = "/"
+		}
+		fn(n, path, file)
+		return
+	}
+	for i, c := range cs {
+		walkLeaves(c, fmt.Sprintf("%s/%d", path, i), file, fn)
+	}
+}
+
+// setLocations records the flash offset and length of n and all the nodes
+// below it, for the JSON output.
+func setLocations(n node) {
+	if l, ok := n.Firmware.(interface {
+		SetLocation(offset uint64, inFlash bool, length uint64)
+	}); ok {
+		l.SetLocation(n.Offset, n.InFlash, uint64(len(n.Buf())))
+	}
+	for _, c := range children(n) {
+		setLocations(c)
+	}
+}
+
+// matchesComponent checks if the path component selects the node.
+func matchesComponent(f uefi.Firmware, component string) bool {
+	guid, name, typez := nodeInfo(f)
+	for _, s := range []string{guid, name, typez} {
+		if s != "" && strings.EqualFold(s, component) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath finds the node described by the path. The path is a list of
+// components separated by "/", each selecting a child of the previous node by
+// index, GUID, name or type (e.g. "BIOS/0/3" or "bios/FFF12B8D-7696-4C8B-A985-2747075B4F50").
+// The first matching child is selected.
+func resolvePath(root uefi.Firmware, path string) (node, error) {
+	n := node{Firmware: root, InFlash: true}
+	for _, c := range strings.Split(path, "/") {
+		if c == "" {
+			continue
+		}
+		nodes := children(n)
+		found := false
+		if i, err := strconv.Atoi(c); err == nil && i >= 0 && i < len(nodes) {
+			n, found = nodes[i], true
+		} else {
+			for _, child := range nodes {
+				if matchesComponent(child.Firmware, c) {
+					n, found = child, true
+					break
+				}
+			}
+		}
+		if !found {
+			return node{}, fmt.Errorf("no node %q in path %q", c, path)
+		}
+	}
+	return n, nil
+}
+
+// matchPath finds all the nodes described by the path. Unlike resolvePath,
+// every child matching a GUID, name or type is selected, and "*" selects all
+// the children.
+func matchPath(root uefi.Firmware, path string) []node {
+	nodes := []node{{Firmware: root, InFlash: true}}
+	for _, c := range strings.Split(path, "/") {
+		if c == "" {
+			continue
+		}
+		var next []node
+		for _, n := range nodes {
+			cs := children(n)
+			if i, err := strconv.Atoi(c); err == nil {
+				if i >= 0 && i < len(cs) {
+					next = append(next, cs[i])
+				}
+				continue
+			}
+			for _, child := range cs {
+				if c == "*" || matchesComponent(child.Firmware, c) {
+					next = append(next, child)
+				}
+			}
+		}
+		nodes = next
+	}
+	return nodes
+}
@@ -0,0 +1,191 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// PCDDatabaseFile is a PCD database found in the raw section of a file.
+type PCDDatabaseFile struct {
+	// Phase is PEI for the database of a PEIM, DXE otherwise.
+	Phase    string
+	File     uuid.UUID
+	Name     string `json:",omitempty"`
+	Database *uefi.PCDDatabase
+
+	file    *uefi.File
+	section *uefi.Section
+}
+
+// FindPCDDatabases returns the PCD databases of the image, the PEI databases
+// first. The DXE token numbers follow the PEI ones.
+func FindPCDDatabases(f uefi.Firmware) ([]*PCDDatabaseFile, error) {
+	files, err := allFiles(f)
+	if err != nil {
+		return nil, err
+	}
+	var pei, dxe []*PCDDatabaseFile
+	for _, file := range files {
+		var dbs []*PCDDatabaseFile
+		walkSections(file.Sections, func(s *uefi.Section) {
+			if err != nil || s.Header.Type != uefi.SectionTypeRaw {
+				return
+			}
+			data := sectionPayload(s)
+			if !uefi.IsPCDDatabase(data) {
+				return
+			}
+			var db *uefi.PCDDatabase
+			if db, err = uefi.NewPCDDatabase(data); err != nil {
+				err = fmt.Errorf("PCD database of file %v: %v", file.Header.UUID, err)
+				return
+			}
+			dbs = append(dbs, &PCDDatabaseFile{File: file.Header.UUID, Name: fileName(file), Database: db, file: file, section: s})
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, db := range dbs {
+			if file.Header.Type == uefi.FVFileTypePEIM {
+				db.Phase = "PEI"
+				pei = append(pei, db)
+			} else {
+				db.Phase = "DXE"
+				dxe = append(dxe, db)
+			}
+		}
+	}
+
+	base := 0
+	for _, db := range pei {
+		if err := db.Database.SetTokenBase(0); err != nil {
+			return nil, err
+		}
+		if n := len(db.Database.Tokens); n > base {
+			base = n
+		}
+	}
+	for _, db := range dxe {
+		if err := db.Database.SetTokenBase(base); err != nil {
+			return nil, err
+		}
+	}
+	return append(pei, dxe...), nil
+}
+
+// save writes the database back into its section, its size does not change.
+func (db *PCDDatabaseFile) save() {
+	copy(sectionPayload(db.section), db.Database.Buf())
+}
+
+// PCD prints the PCD databases of the image as JSON.
+type PCD struct {
+	W io.Writer
+
+	// Output
+	Databases []*PCDDatabaseFile
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *PCD) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the PCD visitor to any Firmware type.
+func (v *PCD) Visit(f uefi.Firmware) error {
+	var err error
+	if v.Databases, err = FindPCDDatabases(f); err != nil {
+		return err
+	}
+	if len(v.Databases) == 0 {
+		return fmt.Errorf("no PCD database found")
+	}
+	b, err := json.MarshalIndent(v.Databases, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(v.W, string(b))
+	return err
+}
+
+// SetPCD sets the default value of a PCD in its database. Only initialized
+// DATA PCDs of a fixed size can be set.
+type SetPCD struct {
+	// Input
+	// PCD is the TokenSpaceGuidCName.PcdCName or the token number.
+	PCD   string
+	Value uint64
+
+	// Output
+	Token *uefi.PCDToken
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SetPCD) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the SetPCD visitor to any Firmware type.
+func (v *SetPCD) Visit(f uefi.Firmware) error {
+	dbs, err := FindPCDDatabases(f)
+	if err != nil {
+		return err
+	}
+	number, numErr := strconv.Atoi(v.PCD)
+	for _, db := range dbs {
+		for _, t := range db.Database.Tokens {
+			if (numErr == nil && t.TokenNumber == number) || (t.Name != "" && t.Name == v.PCD) {
+				if err := checkProtected("set_pcd", []*uefi.File{db.file}); err != nil {
+					return err
+				}
+				if err := db.Database.SetValue(t, v.Value); err != nil {
+					return err
+				}
+				db.save()
+				v.Token = t
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("no PCD %v in the PCD databases", v.PCD)
+}
+
+func init() {
+	Register(CLI{
+		Name: "pcd",
+		Help: "Print the PEI and DXE PCD databases as JSON, with the token numbers, types and default values of the dynamic PCDs.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &PCD{W: os.Stdout}, nil
+		},
+	})
+	Register(CLI{
+		Name: "set_pcd",
+		Args: []string{"(NAME|TOKEN)", "VALUE"},
+		Help: "Set the default value of a DATA PCD of a fixed size in the PCD database. The PCD is given by its TokenSpaceGuidCName.PcdCName, if the database has names, or its token number. Uninitialized PCDs are not stored and cannot be set.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			value, err := strconv.ParseUint(strings.TrimSpace(args[1]), 0, 64)
+			if err != nil {
+				switch strings.ToUpper(args[1]) {
+				case "TRUE":
+					value = 1
+				case "FALSE":
+					value = 0
+				default:
+					return nil, err
+				}
+			}
+			return &SetPCD{PCD: args[0], Value: value}, nil
+		},
+	})
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestPCD(t *testing.T) {
+	f := parseImage(t)
+	var b bytes.Buffer
+	v := &PCD{W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// OVMF only has the DXE database.
+	if len(v.Databases) != 1 || v.Databases[0].Phase != "DXE" || v.Databases[0].Name != "PcdDxe" {
+		t.Fatalf("expected the DXE database of PcdDxe, got %v", v.Databases)
+	}
+	var dbs []PCDDatabaseFile
+	if err := json.Unmarshal(b.Bytes(), &dbs); err != nil {
+		t.Fatal(err)
+	}
+	if len(dbs) != 1 || len(dbs[0].Database.Tokens) != len(v.Databases[0].Database.Tokens) {
+		t.Errorf("unexpected JSON %s", b.String())
+	}
+}
+
+func TestSetPCD(t *testing.T) {
+	f := parseImage(t)
+	s := &SetPCD{PCD: "2", Value: 0x400}
+	if err := s.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if s.Token.DatumType != "UINT32" || s.Token.Value != "0x400" {
+		t.Fatalf("unexpected token %+v", s.Token)
+	}
+
+	// The database is in a compressed FV, check it after reparsing.
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := uefi.Parse(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbs, err := FindPCDDatabases(reparsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := dbs[0].Database.Tokens[1].Value; got != "0x400" {
+		t.Errorf("expected 0x400, got %v", got)
+	}
+
+	for _, s := range []*SetPCD{
+		{PCD: "2", Value: 0x100000000},
+		{PCD: "6", Value: 2},
+		{PCD: "1000", Value: 0},
+	} {
+		if err := s.Run(reparsed); err == nil {
+			t.Errorf("%v: expected an error", s.PCD)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// PDInfo prints the blobs of the PD region.
+type PDInfo struct {
+	// Input
+	W io.Writer
+
+	// Output
+	PD *uefi.PDRegion
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *PDInfo) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.PD == nil {
+		return errors.New("no PD region found")
+	}
+	return nil
+}
+
+// Visit applies the PDInfo visitor to any Firmware type.
+func (v *PDInfo) Visit(f uefi.Firmware) error {
+	pd, ok := f.(*uefi.PDRegion)
+	if !ok {
+		return f.ApplyChildren(v)
+	}
+	v.PD = pd
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Blob\tOffset\tLength\tType\tName\n")
+	for i, b := range pd.Blobs {
+		fmt.Fprintf(tw, "%d\t%#x\t%#x\t%s\t%s\n", i, b.Offset, b.Length, b.Type, b.Name)
+	}
+	return tw.Flush()
+}
+
+// findPD returns the PD region of the image.
+func findPD(f uefi.Firmware) (*uefi.PDRegion, error) {
+	info := PDInfo{W: ioutil.Discard}
+	if err := info.Run(f); err != nil {
+		return nil, err
+	}
+	return info.PD, nil
+}
+
+// ExtractPDBlob writes a blob of the PD region to OutFile.
+type ExtractPDBlob struct {
+	// Input
+	Index   int
+	OutFile string
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ExtractPDBlob) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ExtractPDBlob visitor to any Firmware type.
+func (v *ExtractPDBlob) Visit(f uefi.Firmware) error {
+	pd, err := findPD(f)
+	if err != nil {
+		return err
+	}
+	blob, err := pd.Blob(v.Index)
+	if err != nil {
+		return err
+	}
+	return writeFile(v.OutFile, blob, 0666)
+}
+
+// ReplacePDBlob replaces a blob of the PD region with NewBlob.
+type ReplacePDBlob struct {
+	// Input
+	Index   int
+	NewBlob []byte
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ReplacePDBlob) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the ReplacePDBlob visitor to any Firmware type.
+func (v *ReplacePDBlob) Visit(f uefi.Firmware) error {
+	pd, err := findPD(f)
+	if err != nil {
+		return err
+	}
+	old, err := pd.Blob(v.Index)
+	if err != nil {
+		return err
+	}
+	if pd.Blobs[v.Index].Type == uefi.PDBlobFV {
+		fv, err := uefi.NewFirmwareVolume(append([]byte{}, old...), 0, false)
+		if err != nil {
+			return err
+		}
+		if err := checkProtectedFV("replace_pd_blob", fv); err != nil {
+			return err
+		}
+	}
+	return pd.ReplaceBlob(v.Index, v.NewBlob)
+}
+
+func init() {
+	Register(CLI{
+		Name: "pd_info",
+		Help: "Print the blobs of the PD region, split at the erased blocks in between them: FVs, CSME code partition directories, EC firmware and other data.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &PDInfo{}, nil
+		},
+	})
+	Register(CLI{
+		Name: "extract_pd_blob",
+		Args: []string{"INDEX", "FILE"},
+		Help: "Write the blob INDEX of the PD region, as listed by pd_info, to FILE.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			i, err := strconv.Atoi(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &ExtractPDBlob{
+				Index:   i,
+				OutFile: args[1],
+			}, nil
+		},
+	})
+	Register(CLI{
+		Name: "replace_pd_blob",
+		Args: []string{"INDEX", "FILE"},
+		Help: "Replace the blob INDEX of the PD region with the contents of FILE. It may grow into the erased space up to the next blob.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			i, err := strconv.Atoi(args[0])
+			if err != nil {
+				return nil, err
+			}
+			newBlob, err := ioutil.ReadFile(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return &ReplacePDBlob{
+				Index:   i,
+				NewBlob: newBlob,
+			}, nil
+		},
+	})
+}
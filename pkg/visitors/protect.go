@@ -0,0 +1,98 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// protectedGUIDs are the files which the visitors removing, replacing or
+// patching files and FVs refuse to touch, e.g. silicon init drivers the board
+// does not boot without.
+var protectedGUIDs = map[uuid.UUID]bool{}
+
+// SetProtected sets the GUIDs of the protected files, replacing any earlier
+// ones.
+func SetProtected(guids []uuid.UUID) {
+	protectedGUIDs = map[uuid.UUID]bool{}
+	for _, g := range guids {
+		protectedGUIDs[g] = true
+	}
+}
+
+// ParseGUIDList parses a comma separated list of GUIDs.
+func ParseGUIDList(list string) ([]uuid.UUID, error) {
+	var guids []uuid.UUID
+	for _, s := range strings.Split(list, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		g, err := uuid.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		guids = append(guids, *g)
+	}
+	return guids, nil
+}
+
+// ReadGUIDList reads a list of GUIDs, one per line. Blank lines and lines
+// starting with # are ignored, as are names following the GUID on a line.
+func ReadGUIDList(path string) ([]uuid.UUID, error) {
+	var guids []uuid.UUID
+	err := readList(path, func(line string) error {
+		g, err := uuid.Parse(strings.Fields(line)[0])
+		if err != nil {
+			return err
+		}
+		guids = append(guids, *g)
+		return nil
+	})
+	return guids, err
+}
+
+// ProtectedError is returned when a visitor would remove, replace or patch a
+// protected file.
+type ProtectedError struct {
+	Op   string
+	File *uefi.File
+}
+
+func (e *ProtectedError) Error() string {
+	name := fileName(e.File)
+	if name == "" {
+		name = "file"
+	}
+	return fmt.Sprintf("%s: refusing to touch protected %s %v", e.Op, name, e.File.Header.UUID)
+}
+
+// checkProtected returns a ProtectedError for the first protected file.
+func checkProtected(op string, files []*uefi.File) error {
+	for _, f := range files {
+		if protectedGUIDs[f.Header.UUID] {
+			return &ProtectedError{Op: op, File: f}
+		}
+	}
+	return nil
+}
+
+// checkProtectedFV returns a ProtectedError if the FV holds a protected file,
+// also in nested FVs.
+func checkProtectedFV(op string, fv *uefi.FirmwareVolume) error {
+	if len(protectedGUIDs) == 0 {
+		return nil
+	}
+	find := Find{Predicate: func(f *uefi.File, name string) bool {
+		return protectedGUIDs[f.Header.UUID]
+	}}
+	if err := find.Run(fv); err != nil {
+		return err
+	}
+	return checkProtected(op, find.Matches)
+}
@@ -0,0 +1,189 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/pecoff"
+	"github.com/linuxboot/fiano/pkg/testutil"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+func TestReadGUIDList(t *testing.T) {
+	list := writeList(t, `# Silicon init
+7C04A583-9E3E-4F1C-AD65-E05268D0B4D1 Shell
+
+D6A2CB7F-6A18-4E2F-B43B-9920A733700A
+`)
+	defer os.Remove(list)
+	guids, err := ReadGUIDList(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(guids) != 2 || guids[1] != *uuid.MustParse("D6A2CB7F-6A18-4E2F-B43B-9920A733700A") {
+		t.Errorf("unexpected GUIDs %v", guids)
+	}
+
+	bad := writeList(t, "Shell\n")
+	defer os.Remove(bad)
+	if _, err := ReadGUIDList(bad); err == nil {
+		t.Error("expected an error for a name")
+	}
+}
+
+// movedSecMain returns the OVMF image with SecMain linked elsewhere, so
+// rebase has to change it.
+func movedSecMain(t *testing.T) uefi.Firmware {
+	f := parseImage(t)
+	n, err := resolvePath(f, "/2/SecMain/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := n.Firmware.(*uefi.Section)
+	buf := append([]byte{}, s.Buf()...)
+	img, err := pecoff.Parse(buf[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := img.Rebase(0x100000); err != nil {
+		t.Fatal(err)
+	}
+	s.SetBuf(buf)
+	return f
+}
+
+// parseFV returns a parsed FV built by testutil.
+func parseFV(t *testing.T, files ...[]byte) uefi.Firmware {
+	fv, err := uefi.NewFirmwareVolume(testutil.FV(0x2000, files...), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fv
+}
+
+func TestProtected(t *testing.T) {
+	defer SetProtected(nil)
+	shell := uuid.MustParse("7C04A583-9E3E-4F1C-AD65-E05268D0B4D1")
+	pcdDxe := uuid.MustParse("80CF7257-87AB-47F9-A3FE-D50B76D89541")
+	smbiosDxe := uuid.MustParse("4110465D-5FF3-4F4B-B580-24ED0D06747A")
+
+	// A UEFITool dump of OVMF with a changed Shell.
+	tmpDir, err := ioutil.TempDir("", "protect-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	if err := (&UEFIToolExport{DirPath: tmpDir}).Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	body, err := filepath.Glob(filepath.Join(tmpDir, "*", "*", "*", "*", "*", "* Shell", "* PE32 section", "body.bin"))
+	if err != nil || len(body) != 1 {
+		t.Fatalf("expected the PE32 section of the Shell, got %v: %v", body, err)
+	}
+	if err := ioutil.WriteFile(body[0], []byte("new Shell"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	csm := func(t *testing.T) uefi.Firmware {
+		return parseFV(t, testutil.File(*testGUID, uefi.FVFileTypeFreeForm,
+			testutil.Section(uefi.SectionTypeCompatibility16, bytes.Repeat([]byte{0xcb}, 0x100))))
+	}
+	ec := func(t *testing.T) uefi.Firmware {
+		return parseFV(t, testutil.RawFile(*testGUID, testutil.ITEFirmware(0x400, 0x12)))
+	}
+	pd := func(t *testing.T) uefi.Firmware {
+		pd, err := uefi.NewPDRegion(testutil.FV(0x2000, testutil.RawFile(*testGUID, []byte("PD"))), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return pd
+	}
+	setup := func(t *testing.T) uefi.Firmware {
+		fv, _ := setupImage(t)
+		return fv
+	}
+
+	shellRE := regexp.MustCompile("^Shell$")
+	byName := func(f *uefi.File, name string) bool {
+		return shellRE.MatchString(name)
+	}
+	var tests = []struct {
+		name    string
+		guid    *uuid.UUID
+		image   func(t *testing.T) uefi.Firmware
+		visitor interface{ Run(uefi.Firmware) error }
+	}{
+		{"remove", shell, parseImage, &Remove{Predicate: byName}},
+		{"replace_pe32", shell, parseImage, &ReplacePE32{Predicate: byName, NewPE32: []byte("MZ")}},
+		{"rename_guid", shell, parseImage, &RenameGUID{Predicate: byName, NewGUID: *testGUID}},
+		{"remove_list", shell, parseImage, &DXEList{Patterns: []*regexp.Regexp{shellRE}}},
+		{"keep_list", shell, parseImage, &DXEList{Keep: true}},
+		{"set_name", shell, parseImage, &SetName{Predicate: byName, Name: "NotShell"}},
+		{"patch_depex", shell, parseImage, &PatchDepEx{Predicate: byName}},
+		{"strip_pe32", shell, parseImage, &StripPE32{}},
+		{"uefitool_import", shell, parseImage, &UEFIToolImport{DirPath: tmpDir}},
+		{"rebase", testGUID, movedSecMain, &Rebase{}},
+		{"replace_acpi", uefi.ACPITableStorageFileGUID, parseImage, &ReplaceACPI{Table: append([]byte("FACP"), make([]byte, 0x70)...), Index: -1}},
+		{"set_pcd", pcdDxe, parseImage, &SetPCD{PCD: "2", Value: 0x400}},
+		{"set_smbios", smbiosDxe, parseImage, &SetSMBIOS{Type: "BIOS", Field: "Vendor", Value: "LinuxBoot"}},
+		{"replace_csm", testGUID, csm, &ReplaceCSM{NewCSM: []byte{0xcb}}},
+		{"replace_ec", testGUID, ec, &ReplaceEC{Index: -1, Firmware: testutil.ITEFirmware(0x400, 0x34)}},
+		{"replace_pd_blob", testGUID, pd, &ReplacePDBlob{Index: 0, NewBlob: []byte("new")}},
+		{"setup_var", &setupFormsGUID, setup, &SetupVar{VarStore: "Setup", Offset: 2, Value: 1}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			SetProtected([]uuid.UUID{*test.guid})
+			f := test.image(t)
+			orig := snapshot(t, f)
+			err := test.visitor.Run(f)
+			if perr, ok := err.(*ProtectedError); !ok || perr.File.Header.UUID != *test.guid {
+				t.Fatalf("expected %v to be protected, got %v", test.guid, err)
+			}
+			if !bytes.Equal(snapshot(t, f), orig) {
+				t.Error("the image was changed")
+			}
+		})
+	}
+
+	// The FV holding the Shell is nested in the main FV.
+	SetProtected([]uuid.UUID{*shell})
+	br, err := uefi.NewBIOSRegion(append([]byte{}, parseImage(t).Buf()...), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, _ := ParseFVSelector("1")
+	if _, ok := (&RemoveFV{Selector: sel}).Run(br).(*ProtectedError); !ok {
+		t.Error("expected the FV to be protected")
+	}
+
+	// Other files may still be removed.
+	f := parseImage(t)
+	ip4 := func(f *uefi.File, name string) bool {
+		return name == "Ip4Dxe"
+	}
+	if err := (&Remove{Predicate: ip4}).Run(f); err != nil {
+		t.Error(err)
+	}
+}
+
+// snapshot returns the assembled image, or the variable store of the setup
+// image, which cannot be assembled.
+func snapshot(t *testing.T, f uefi.Firmware) []byte {
+	if fv, ok := f.(*uefi.FirmwareVolume); ok && fv.VariableStore != nil {
+		return append([]byte{}, fv.VariableStore.Buf()...)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte{}, f.Buf()...)
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/linuxboot/fiano/pkg/pecoff"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// xipFileTypes are the file types whose images execute in place from flash.
+var xipFileTypes = map[uefi.FVFileType]bool{
+	uefi.FVFileTypeSECCore:            true,
+	uefi.FVFileTypePEICore:            true,
+	uefi.FVFileTypePEIM:               true,
+	uefi.FVFileTypeCombinedPEIMDriver: true,
+}
+
+// RebasedImage describes an image moved by the Rebase visitor.
+type RebasedImage struct {
+	File    *uefi.File
+	OldBase uint64
+	NewBase uint64
+}
+
+// Rebase relocates the PE32 and TE images of execute in place modules to
+// their current flash address, e.g. after their firmware volume was moved.
+// The image is assumed to be mapped right below 4GiB. Images in compressed
+// sections are not executed in place and are left alone.
+type Rebase struct {
+	// Output
+	Rebased []RebasedImage
+
+	// Private
+	top uint64
+	// The rebased nodes, which are only written once none of their files
+	// turned out to be protected.
+	bufs map[uefi.Firmware][]byte
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Rebase) Run(f uefi.Firmware) error {
+	// Offsets are only accurate once the tree is assembled.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	v.top = 1 << 32
+	v.Rebased = nil
+	v.bufs = make(map[uefi.Firmware][]byte)
+	if err := v.rebase(node{Firmware: f, InFlash: true}, uint64(len(f.Buf()))); err != nil {
+		return err
+	}
+	if len(v.Rebased) == 0 {
+		return nil
+	}
+	var files []*uefi.File
+	for _, r := range v.Rebased {
+		files = append(files, r.File)
+	}
+	if err := checkProtected("rebase", files); err != nil {
+		return err
+	}
+	for n, buf := range v.bufs {
+		n.SetBuf(buf)
+	}
+	// Update the file checksums.
+	return (&Assemble{}).Run(f)
+}
+
+// Visit applies the Rebase visitor to any Firmware type.
+func (v *Rebase) Visit(f uefi.Firmware) error {
+	return v.Run(f)
+}
+
+func (v *Rebase) rebase(n node, size uint64) error {
+	if !n.InFlash {
+		return nil
+	}
+	f, ok := n.Firmware.(*uefi.File)
+	if !ok || !xipFileTypes[f.Header.Type] {
+		for _, c := range children(n) {
+			if err := v.rebase(c, size); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	base := v.top - size + n.Offset
+	if len(f.Sections) != 0 {
+		for _, c := range children(n) {
+			s := c.Firmware.(*uefi.Section)
+			buf := append([]byte{}, s.Buf()...)
+			changed, err := v.rebaseSection(f, buf, sectionHeaderLen(s), base-n.Offset+c.Offset)
+			if err != nil {
+				return err
+			}
+			if changed {
+				v.bufs[s] = buf
+			}
+		}
+		return nil
+	}
+
+	// Sections of unparsed files, such as PEIMs, are walked in the file buffer.
+	buf := append([]byte{}, f.Buf()...)
+	changed := false
+	for i, offset := 0, f.DataOffset; offset < uint64(len(buf)); i++ {
+		s, err := uefi.NewSection(buf[offset:], i)
+		if err != nil {
+			return fmt.Errorf("error parsing sections of file %v: %v", f.Header.UUID, err)
+		}
+		secLen := uint64(len(s.Buf()))
+		c, err := v.rebaseSection(f, buf[offset:offset+secLen], sectionHeaderLen(s), base+offset)
+		if err != nil {
+			return err
+		}
+		changed = changed || c
+		offset = uefi.Align4(offset + secLen)
+	}
+	if changed {
+		v.bufs[f] = buf
+	}
+	return nil
+}
+
+// rebaseSection rebases the image of a PE32 or TE section in buf, which is at
+// addr in memory. It returns whether the image was changed.
+func (v *Rebase) rebaseSection(f *uefi.File, buf []byte, headerLen uint64, addr uint64) (bool, error) {
+	switch uefi.SectionType(buf[3]) {
+	case uefi.SectionTypePE32, uefi.SectionTypeTE:
+	default:
+		return false, nil
+	}
+	img, err := pecoff.Parse(buf[headerLen:])
+	if err != nil {
+		log.Printf("not rebasing image of file %v: %v", f.Header.UUID, err)
+		return false, nil
+	}
+	oldBase := img.ImageBase()
+	newBase := img.BaseForAddress(addr + headerLen)
+	if oldBase == newBase {
+		return false, nil
+	}
+	if err := img.Rebase(newBase); err != nil {
+		return false, fmt.Errorf("unable to rebase image of file %v from %#x to %#x: %v",
+			f.Header.UUID, oldBase, newBase, err)
+	}
+	v.Rebased = append(v.Rebased, RebasedImage{File: f, OldBase: oldBase, NewBase: newBase})
+	return true, nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "rebase",
+		Help: "Rebase the PE32 and TE images of execute in place modules (SEC, PEI core and PEIMs) to their flash address, e.g. after replace_fv moved their FV.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &Rebase{}, nil
+		},
+	})
+}
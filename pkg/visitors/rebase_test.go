@@ -0,0 +1,56 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/pecoff"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestRebase(t *testing.T) {
+	f := parseImage(t)
+
+	// OVMF is linked at its flash addresses.
+	v := &Rebase{}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Rebased) != 0 {
+		t.Fatalf("expected no images to be rebased, got %d", len(v.Rebased))
+	}
+	orig := append([]byte{}, f.Buf()...)
+
+	// Move SecMain elsewhere and have it rebased back.
+	n, err := resolvePath(f, "/2/SecMain/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := n.Firmware.(*uefi.Section)
+	buf := append([]byte{}, s.Buf()...)
+	img, err := pecoff.Parse(buf[4:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := img.Rebase(0x100000); err != nil {
+		t.Fatal(err)
+	}
+	s.SetBuf(buf)
+
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Rebased) != 1 {
+		t.Fatalf("expected one image to be rebased, got %d", len(v.Rebased))
+	}
+	if r := v.Rebased[0]; r.OldBase != 0x100000 || r.NewBase != 0xfffcc094 {
+		t.Errorf("expected rebase from 0x100000 to 0xfffcc094, got %#x to %#x", r.OldBase, r.NewBase)
+	}
+	if !bytes.Equal(f.Buf(), orig) {
+		t.Error("rebased image differs from the original")
+	}
+}
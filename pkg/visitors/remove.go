@@ -31,6 +31,12 @@ func (v *Remove) Run(f uefi.Firmware) error {
 
 	// Use this list of matches when removing files.
 	v.Matches = find.Matches
+	if err := checkProtected("remove", v.Matches); err != nil {
+		return err
+	}
+	if err := checkDependents("remove", f, v.Matches); err != nil {
+		return err
+	}
 	return f.Apply(v)
 }
 
@@ -51,15 +57,21 @@ func (v *Remove) Visit(f uefi.Firmware) error {
 }
 
 func init() {
-	RegisterCLI("remove", 1, func(args []string) (uefi.Visitor, error) {
-		searchRE, err := regexp.Compile(args[0])
-		if err != nil {
-			return nil, err
-		}
-		return &Remove{
-			Predicate: func(f *uefi.File, name string) bool {
-				return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
-			},
-		}, nil
+	Register(CLI{
+		Name:  "remove",
+		Args:  []string{"(GUID|NAME)"},
+		Help:  "Remove the first file which matches the given GUID or NAME. The same matching rules and exit status are used as find. Remaining modules depending on protocols only the removed files produce are reported, see -depex-check.",
+		Flags: []string{"depex-check"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			searchRE, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &Remove{
+				Predicate: func(f *uefi.File, name string) bool {
+					return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
+				},
+			}, nil
+		},
 	})
 }
@@ -0,0 +1,72 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// RemoveFV removes a firmware volume from the BIOS region and erases its
+// extent. All other elements keep their offsets.
+type RemoveFV struct {
+	// Input
+	Selector FVSelector
+
+	// Output
+	Removed *uefi.FirmwareVolume
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *RemoveFV) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.Removed == nil {
+		return fmt.Errorf("no BIOS region to remove FV %v from", v.Selector)
+	}
+	return nil
+}
+
+// Visit applies the RemoveFV visitor to any Firmware type.
+func (v *RemoveFV) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.BIOSRegion:
+		i, fv, err := v.Selector.find(f)
+		if err != nil {
+			return err
+		}
+		if err := checkProtectedFV("remove_fv", fv); err != nil {
+			return err
+		}
+		if fv.HasVTF() {
+			log.Printf("warning: removing FV %v which contains the volume top file, the image will not boot", v.Selector)
+		}
+		uefi.Attributes.ErasePolarity = fv.GetErasePolarity()
+		f.Elements[i] = erasedPadding(fv.FVOffset, uint64(len(fv.Buf())))
+		v.Removed = fv
+		return nil
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	Register(CLI{
+		Name: "remove_fv",
+		Args: []string{"(INDEX|FVNAME)"},
+		Help: "Remove an FV of the BIOS region, selected as in replace_fv, and erase its extent.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			sel, err := ParseFVSelector(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &RemoveFV{
+				Selector: sel,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,47 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestRemoveFV(t *testing.T) {
+	image, err := ioutil.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	br, err := uefi.NewBIOSRegion(append([]byte{}, image...), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sel, err := ParseFVSelector("0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := (&RemoveFV{Selector: sel}).Run(br); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := br.Elements[0].Value.(*uefi.BIOSPadding); !ok {
+		t.Fatalf("expected the FV to be replaced by padding, got %s", br.Elements[0].Type)
+	}
+	if err := (&Assemble{}).Run(br); err != nil {
+		t.Fatal(err)
+	}
+	nb := br.Buf()
+	if len(nb) != len(image) {
+		t.Fatalf("image length changed from %#x to %#x", len(image), len(nb))
+	}
+	if !uefi.IsErased(nb[:0x84000], 0xff) {
+		t.Errorf("removed FV is not erased")
+	}
+	if !bytes.Equal(nb[0x3cc000:], image[0x3cc000:]) {
+		t.Errorf("top FV changed")
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"flag"
+	"regexp"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var updateApriori = flag.Bool("update-apriori", true, "update apriori files when renaming file GUIDs")
+
+// RenameGUID changes the GUID of all files matching Predicate.
+type RenameGUID struct {
+	// Input
+	Predicate func(f *uefi.File, name string) bool
+	NewGUID   uuid.UUID
+	// If set, references to the old GUIDs in the PEI and DXE apriori files
+	// are replaced with the new GUID.
+	UpdateApriori bool
+
+	// Output
+	Matches []*uefi.File
+
+	// Private
+	oldGUIDs map[uuid.UUID]bool
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *RenameGUID) Run(f uefi.Firmware) error {
+	// First run "find" to generate a list of matches to rename.
+	find := Find{
+		Predicate: v.Predicate,
+	}
+	if err := find.Run(f); err != nil {
+		return err
+	}
+
+	v.Matches = find.Matches
+	if err := checkProtected("rename_guid", v.Matches); err != nil {
+		return err
+	}
+	v.oldGUIDs = make(map[uuid.UUID]bool)
+	for _, m := range v.Matches {
+		v.oldGUIDs[m.Header.UUID] = true
+		m.Header.UUID = v.NewGUID
+	}
+	if !v.UpdateApriori || len(v.Matches) == 0 {
+		return nil
+	}
+	return f.Apply(v)
+}
+
+// Visit applies the RenameGUID visitor to any Firmware type.
+func (v *RenameGUID) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		if f.Header.UUID != *uefi.PEIAprioriGUID && f.Header.UUID != *uefi.DXEAprioriGUID {
+			return f.ApplyChildren(v)
+		}
+		for _, s := range f.Sections {
+			if s.Header.Type != uefi.SectionTypeRaw {
+				continue
+			}
+			// The raw section is a plain list of GUIDs.
+			buf := append([]byte{}, s.Buf()...)
+			for i := sectionHeaderLen(s); i+16 <= uint64(len(buf)); i += 16 {
+				var g uuid.UUID
+				copy(g[:], buf[i:])
+				if v.oldGUIDs[g] {
+					copy(buf[i:], v.NewGUID[:])
+				}
+			}
+			s.SetBuf(buf)
+		}
+		return nil
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	Register(CLI{
+		Name:  "rename_guid",
+		Args:  []string{"(GUID|NAME)", "NEWGUID"},
+		Help:  "Change the GUID of the files which match the given GUID or NAME. Apriori files are updated unless -update-apriori=false is passed.",
+		Flags: []string{"update-apriori"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			searchRE, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			newGUID, err := uuid.Parse(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return &RenameGUID{
+				Predicate: func(f *uefi.File, name string) bool {
+					return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
+				},
+				NewGUID:       *newGUID,
+				UpdateApriori: *updateApriori,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// aprioriGUIDs returns the GUIDs listed in the raw section of an apriori file.
+func aprioriGUIDs(t *testing.T, f *uefi.File) []uuid.UUID {
+	var guids []uuid.UUID
+	for _, s := range f.Sections {
+		if s.Header.Type != uefi.SectionTypeRaw {
+			continue
+		}
+		buf := s.Buf()[sectionHeaderLen(s):]
+		for i := 0; i+16 <= len(buf); i += 16 {
+			var g uuid.UUID
+			copy(g[:], buf[i:])
+			guids = append(guids, g)
+		}
+	}
+	return guids
+}
+
+func TestRenameGUID(t *testing.T) {
+	newGUID := uuid.MustParse("0D1ED2F7-E92B-4562-92DD-5C82EC917EAB")
+	f := parseImage(t)
+	apriori := find(t, f, uefi.DXEAprioriGUID)
+	if len(apriori) != 1 {
+		t.Fatalf("expected one DXE apriori file, got %d", len(apriori))
+	}
+	guids := aprioriGUIDs(t, apriori[0])
+	if len(guids) == 0 {
+		t.Fatal("DXE apriori file is empty")
+	}
+	oldGUID := guids[0]
+
+	rename := &RenameGUID{
+		Predicate: func(f *uefi.File, name string) bool {
+			return f.Header.UUID == oldGUID
+		},
+		NewGUID:       *newGUID,
+		UpdateApriori: true,
+	}
+	if err := rename.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(rename.Matches) != 1 {
+		t.Fatalf("expected one file to be renamed, got %d", len(rename.Matches))
+	}
+	if g := aprioriGUIDs(t, apriori[0]); g[0] != *newGUID || len(g) != len(guids) {
+		t.Errorf("apriori file not updated, got %v", g)
+	}
+
+	// The new GUID must survive assembling and parsing the image again.
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	nf, err := uefi.Parse(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(find(t, nf, newGUID)); n != 1 {
+		t.Errorf("expected one file with the new GUID, got %d", n)
+	}
+	if n := len(find(t, nf, &oldGUID)); n != 0 {
+		t.Errorf("expected no file with the old GUID, got %d", n)
+	}
+}
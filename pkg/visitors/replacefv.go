@@ -0,0 +1,142 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// FVSelector selects a firmware volume of the BIOS region, either by its
+// index among the FVs of the region or by the FVName GUID of its extended
+// header.
+type FVSelector struct {
+	Index int
+	Name  *uuid.UUID
+}
+
+// ParseFVSelector parses an FV index or FVName GUID.
+func ParseFVSelector(s string) (FVSelector, error) {
+	if i, err := strconv.Atoi(s); err == nil {
+		if i < 0 {
+			return FVSelector{}, fmt.Errorf("FV index must not be negative, got %d", i)
+		}
+		return FVSelector{Index: i}, nil
+	}
+	g, err := uuid.Parse(s)
+	if err != nil {
+		return FVSelector{}, fmt.Errorf("%q is neither an FV index nor an FVName GUID", s)
+	}
+	return FVSelector{Name: g}, nil
+}
+
+func (s FVSelector) String() string {
+	if s.Name != nil {
+		return s.Name.String()
+	}
+	return fmt.Sprintf("#%d", s.Index)
+}
+
+// find returns the index of the selected FV in the BIOS region elements.
+func (s FVSelector) find(br *uefi.BIOSRegion) (int, *uefi.FirmwareVolume, error) {
+	n := 0
+	for i, e := range br.Elements {
+		fv, ok := e.Value.(*uefi.FirmwareVolume)
+		if !ok {
+			continue
+		}
+		if (s.Name != nil && fv.FVName == *s.Name) || (s.Name == nil && n == s.Index) {
+			return i, fv, nil
+		}
+		n++
+	}
+	return 0, nil, fmt.Errorf("no FV %v in the BIOS region", s)
+}
+
+// ReplaceFV replaces a firmware volume of the BIOS region with a new FV of
+// equal or smaller size. The remainder of the old extent is erased.
+type ReplaceFV struct {
+	// Input
+	Selector FVSelector
+	NewFV    []byte
+
+	// Output
+	Replaced *uefi.FirmwareVolume
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ReplaceFV) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.Replaced == nil {
+		return fmt.Errorf("no BIOS region to replace FV %v in", v.Selector)
+	}
+	return nil
+}
+
+// Visit applies the ReplaceFV visitor to any Firmware type.
+func (v *ReplaceFV) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.BIOSRegion:
+		i, old, err := v.Selector.find(f)
+		if err != nil {
+			return err
+		}
+		if err := checkProtectedFV("replace_fv", old); err != nil {
+			return err
+		}
+		oldLen := uint64(len(old.Buf()))
+		newLen := uint64(len(v.NewFV))
+		if newLen > oldLen {
+			return fmt.Errorf("new FV is %#x bytes, larger than the %#x bytes of FV %v", newLen, oldLen, v.Selector)
+		}
+		offset := old.FVOffset
+		if old.HasVTF() {
+			// The volume top file has to stay at the top of the flash.
+			offset += oldLen - newLen
+		}
+		fv, err := uefi.NewFirmwareVolume(v.NewFV, offset, false)
+		if err != nil {
+			return err
+		}
+		if uint64(len(fv.Buf())) != newLen {
+			return fmt.Errorf("new FV header has length %#x, but the image is %#x bytes", len(fv.Buf()), newLen)
+		}
+		f.Elements[i] = uefi.MakeTyped(fv)
+		v.Replaced = old
+
+		// Fill the remainder of the old extent.
+		uefi.Attributes.ErasePolarity = old.GetErasePolarity()
+		return layoutBIOSRegion(f)
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	Register(CLI{
+		Name: "replace_fv",
+		Args: []string{"(INDEX|FVNAME)", "FILE"},
+		Help: "Replace an FV of the BIOS region, selected by its index or the FVName GUID of its extended header, with the FV in FILE. The new FV must not be larger, the remainder is erased.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			sel, err := ParseFVSelector(args[0])
+			if err != nil {
+				return nil, err
+			}
+			newFV, err := ioutil.ReadFile(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return &ReplaceFV{
+				Selector: sel,
+				NewFV:    newFV,
+			}, nil
+		},
+	})
+}
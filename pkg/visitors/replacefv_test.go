@@ -0,0 +1,81 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestReplaceFV(t *testing.T) {
+	image, err := ioutil.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The NVRAM FV is used as a replacement for the main FV, since it does not
+	// contain the volume top file.
+	nvFV := image[:0x84000]
+	var tests = []struct {
+		name     string
+		selector string
+		newFV    []byte
+		ok       bool
+	}{
+		{"smaller", "1", nvFV, true},
+		{"byName", "48db5e17-707c-472d-91cd-1613e7ef51b0", nvFV, true},
+		{"tooLarge", "0", image[0x84000:0x3cc000], false},
+		{"missing", "3", nvFV, false},
+		{"missingName", "0d1ed2f7-e92b-4562-92dd-5c82ec917eab", nvFV, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sel, err := ParseFVSelector(test.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			br, err := uefi.NewBIOSRegion(append([]byte{}, image...), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			oldFV, _ := br.Elements[1].Value.(*uefi.FirmwareVolume)
+			rfv := &ReplaceFV{Selector: sel, NewFV: test.newFV}
+			err = rfv.Run(br)
+			if !test.ok {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rfv.Replaced != oldFV {
+				t.Errorf("wrong FV replaced")
+			}
+			if err := (&Assemble{}).Run(br); err != nil {
+				t.Fatal(err)
+			}
+			nb := br.Buf()
+			if len(nb) != len(image) {
+				t.Fatalf("image length changed from %#x to %#x", len(image), len(nb))
+			}
+			if !bytes.Equal(nb[:0x84000], nvFV) {
+				t.Errorf("first FV changed")
+			}
+			if !bytes.Equal(nb[0x84000:0x84000+len(nvFV)], nvFV) {
+				t.Errorf("new FV not at the offset of the old FV")
+			}
+			if !uefi.IsErased(nb[0x84000+len(nvFV):0x3cc000], 0xff) {
+				t.Errorf("remainder of the old FV is not erased")
+			}
+			if !bytes.Equal(nb[0x3cc000:], image[0x3cc000:]) {
+				t.Errorf("top FV changed")
+			}
+		})
+	}
+}
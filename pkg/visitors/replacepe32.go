@@ -33,6 +33,9 @@ func (v *ReplacePE32) Run(f uefi.Firmware) error {
 
 	// Use this list of matches for replacing sections.
 	v.Matches = find.Matches
+	if err := checkProtected("replace_pe32", v.Matches); err != nil {
+		return err
+	}
 	for _, m := range v.Matches {
 		if err := m.Apply(v); err != nil {
 			return err
@@ -63,24 +66,29 @@ func (v *ReplacePE32) Visit(f uefi.Firmware) error {
 }
 
 func init() {
-	RegisterCLI("replace_pe32", 2, func(args []string) (uefi.Visitor, error) {
-		searchRE, err := regexp.Compile(args[0])
-		if err != nil {
-			return nil, err
-		}
+	Register(CLI{
+		Name: "replace_pe32",
+		Args: []string{"(GUID|NAME)", "FILE"},
+		Help: "Replace the PE32 section of the files which match the given GUID or NAME with the contents of FILE. The same matching rules and exit status are used as find.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			searchRE, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, err
+			}
 
-		filename := args[1]
-		newPE32, err := ioutil.ReadFile(filename)
-		if err != nil {
-			return nil, err
-		}
+			filename := args[1]
+			newPE32, err := ioutil.ReadFile(filename)
+			if err != nil {
+				return nil, err
+			}
 
-		// Find all the matching files and replace their inner PE32s.
-		return &ReplacePE32{
-			Predicate: func(f *uefi.File, name string) bool {
-				return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
-			},
-			NewPE32: newPE32,
-		}, nil
+			// Find all the matching files and replace their inner PE32s.
+			return &ReplacePE32{
+				Predicate: func(f *uefi.File, name string) bool {
+					return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
+				},
+				NewPE32: newPE32,
+			}, nil
+		},
 	})
 }
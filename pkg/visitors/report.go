@@ -0,0 +1,282 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// Report renders the parsed tree, the region map, the free space of the FVs
+// and the verification results into a single HTML file, which needs nothing
+// but a browser to be read.
+type Report struct {
+	// Input
+	Path string
+	// W is written to instead of Path if set.
+	W io.Writer
+}
+
+// reportNode is a node of the tree in the report.
+type reportNode struct {
+	Path     string
+	GUID     string
+	Name     string
+	Type     string
+	Offset   string
+	Size     int
+	Children []reportNode
+}
+
+// reportSegment is an element of a region map.
+type reportSegment struct {
+	Type   string
+	Offset uint64
+	Size   uint64
+	Free   bool
+	// Left and Width place the segment in the map, in percent.
+	Left, Width float64
+}
+
+// reportMap is the map of the regions of a flash image or of the elements of
+// a BIOS region.
+type reportMap struct {
+	Title    string
+	Segments []reportSegment
+}
+
+// reportFree is the free space of an FV.
+type reportFree struct {
+	Path   string
+	Name   string
+	Offset string
+	Size   uint64
+	Free   uint64
+	// Percent is the free space in percent of the size.
+	Percent float64
+}
+
+type reportData struct {
+	Type     string
+	Size     int
+	Maps     []reportMap
+	Free     []reportFree
+	Errors   []string
+	Security string
+	Tree     reportNode
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Firmware report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: 2px 8px; text-align: left; font-family: monospace; }
+.map { position: relative; height: 2em; border: 1px solid #888; margin-bottom: 0.5em; }
+.seg { position: absolute; top: 0; height: 100%; overflow: hidden; background: #6a9fd4; border-right: 1px solid #fff; font-size: 0.7em; }
+.seg.free { background: #ddd; }
+.error { color: #b00; }
+details { margin-left: 1.5em; }
+summary { font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>Firmware report</h1>
+<p>{{.Type}}, {{printf "%#x" .Size}} bytes.</p>
+
+<h2>Region map</h2>
+{{range .Maps}}<h3>{{.Title}}</h3>
+<div class="map">{{range .Segments}}<div class="seg{{if .Free}} free{{end}}" style="left: {{printf "%.3f" .Left}}%; width: {{printf "%.3f" .Width}}%" title="{{.Type}} at {{printf "%#x" .Offset}}, {{printf "%#x" .Size}} bytes">{{.Type}}</div>{{end}}</div>
+<table>
+<tr><th>Type</th><th>Offset</th><th>Size</th></tr>
+{{range .Segments}}<tr><td>{{.Type}}{{if .Free}} (free){{end}}</td><td>{{printf "%#x" .Offset}}</td><td>{{printf "%#x" .Size}}</td></tr>
+{{end}}</table>
+{{else}}<p>No regions.</p>
+{{end}}
+<h2>Free space</h2>
+{{if .Free}}<table>
+<tr><th>Path</th><th>FV</th><th>Offset</th><th>Size</th><th>Free</th><th>%</th></tr>
+{{range .Free}}<tr><td>{{.Path}}</td><td>{{.Name}}</td><td>{{.Offset}}</td><td>{{printf "%#x" .Size}}</td><td>{{printf "%#x" .Free}}</td><td>{{printf "%.1f" .Percent}}</td></tr>
+{{end}}</table>
+{{else}}<p>No FVs.</p>
+{{end}}
+<h2>Verification</h2>
+{{if .Errors}}<ul>
+{{range .Errors}}<li class="error">{{.}}</li>
+{{end}}</ul>
+{{else}}<p>No errors.</p>
+{{end}}<pre>{{.Security}}</pre>
+
+<h2>Tree</h2>
+{{template "node" .Tree}}
+</body>
+</html>
+{{define "node"}}<details{{if not .Children}} class="leaf"{{end}}>
+<summary>{{.Path}} {{.Type}}{{if .Name}} {{.Name}}{{end}}{{if .GUID}} {{.GUID}}{{end}} at {{.Offset}}, {{printf "%#x" .Size}} bytes</summary>
+{{range .Children}}{{template "node" .}}{{end}}</details>
+{{end}}`))
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Report) Run(f uefi.Firmware) error {
+	// Offsets are only accurate once the tree is assembled.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	return f.Apply(v)
+}
+
+// Visit applies the Report visitor to any Firmware type.
+func (v *Report) Visit(f uefi.Firmware) error {
+	root := node{Firmware: f, InFlash: true}
+	_, _, typez := nodeInfo(f)
+	data := reportData{
+		Type: typez,
+		Size: len(f.Buf()),
+		Tree: reportTree(root, "/"),
+	}
+	reportMaps(root, &data)
+	reportFreeSpace(root, "", &data)
+	for _, err := range f.Validate() {
+		data.Errors = append(data.Errors, err.Error())
+	}
+	var security bytes.Buffer
+	if err := (&SecurityReport{W: &security}).Run(f); err != nil {
+		fmt.Fprintf(&security, "security report failed: %v\n", err)
+	}
+	data.Security = security.String()
+
+	w := v.W
+	if w == nil {
+		file, err := createFile(v.Path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	return reportTemplate.Execute(w, data)
+}
+
+// reportOffset formats the flash offset of a node.
+func reportOffset(n node) string {
+	if !n.InFlash {
+		return "-"
+	}
+	return fmt.Sprintf("%#x", n.Offset)
+}
+
+func reportTree(n node, path string) reportNode {
+	guid, name, typez := nodeInfo(n.Firmware)
+	rn := reportNode{
+		Path:   path,
+		GUID:   guid,
+		Name:   name,
+		Type:   typez,
+		Offset: reportOffset(n),
+		Size:   len(n.Buf()),
+	}
+	if path == "/" {
+		path = ""
+	}
+	for i, c := range children(n) {
+		rn.Children = append(rn.Children, reportTree(c, fmt.Sprintf("%s/%d", path, i)))
+	}
+	return rn
+}
+
+// reportMaps adds the region map of a flash image, and the element map of
+// the BIOS region.
+func reportMaps(n node, data *reportData) {
+	var title string
+	switch n.Firmware.(type) {
+	case *uefi.FlashImage:
+		title = "Flash image"
+	case *uefi.BIOSRegion:
+		title = "BIOS region"
+	default:
+		return
+	}
+	m := reportMap{Title: title}
+	total := float64(len(n.Buf()))
+	var bios []node
+	for _, c := range children(n) {
+		_, _, typez := nodeInfo(c.Firmware)
+		s := reportSegment{Type: typez, Offset: c.Offset, Size: uint64(len(c.Buf()))}
+		if bp, ok := c.Firmware.(*uefi.BIOSPadding); ok {
+			s.Free = uefi.IsErased(bp.Buf(), uefi.Attributes.ErasePolarity)
+		}
+		if total != 0 {
+			s.Left = float64(c.Offset-n.Offset) * 100 / total
+			s.Width = float64(s.Size) * 100 / total
+		}
+		m.Segments = append(m.Segments, s)
+		if _, ok := c.Firmware.(*uefi.BIOSRegion); ok {
+			bios = append(bios, c)
+		}
+	}
+	data.Maps = append(data.Maps, m)
+	for _, b := range bios {
+		reportMaps(b, data)
+	}
+}
+
+// reportFreeSpace adds the free space of the FVs below n: erased pad files
+// and the erased space after the last file.
+func reportFreeSpace(n node, path string, data *reportData) {
+	if fv, ok := n.Firmware.(*uefi.FirmwareVolume); ok && len(fv.Files) != 0 {
+		polarity := fv.GetErasePolarity()
+		uefi.Attributes.ErasePolarity = polarity
+		var free uint64
+		end := n.Offset + fv.DataOffset
+		for _, c := range children(n) {
+			file, ok := c.Firmware.(*uefi.File)
+			if !ok {
+				continue
+			}
+			if isErasedPad(file) {
+				free += uint64(len(file.Buf()))
+			}
+			end = c.Offset + uint64(len(file.Buf()))
+		}
+		if tail := fv.Buf()[end-n.Offset:]; uefi.IsErased(tail, polarity) {
+			free += uint64(len(tail))
+		}
+		p := path
+		if p == "" {
+			p = "/"
+		}
+		data.Free = append(data.Free, reportFree{
+			Path:    p,
+			Name:    uefi.FVGUIDs[fv.FileSystemGUID],
+			Offset:  reportOffset(n),
+			Size:    uint64(len(fv.Buf())),
+			Free:    free,
+			Percent: float64(free) * 100 / float64(len(fv.Buf())),
+		})
+	}
+	for i, c := range children(n) {
+		reportFreeSpace(c, fmt.Sprintf("%s/%d", path, i), data)
+	}
+}
+
+func init() {
+	Register(CLI{
+		Name: "report",
+		Args: []string{"FILE"},
+		Help: "Write an HTML report of the image to FILE, with the region map, the free space of the FVs, the verification results and the tree.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &Report{
+				Path: args[0],
+			}, nil
+		},
+	})
+}
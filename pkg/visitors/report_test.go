@@ -0,0 +1,32 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	f := parseImage(t)
+	var b bytes.Buffer
+	if err := (&Report{W: &b}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"<html>",
+		"<h3>BIOS region</h3>",
+		// The free space of the FV holding the SEC core.
+		"<tr><td>/2</td><td>FFS2</td><td>0x3cc000</td><td>0x34000</td>",
+		"No errors.",
+		"/2/0 EFI_FV_FILETYPE_SECURITY_CORE SecMain",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("expected %q in the report", want)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// ROMLayoutArea is an area of an AMI ROM layout. FV areas are matched with
+// the FVs of the BIOS region.
+type ROMLayoutArea struct {
+	uefi.ROMArea
+	// FVName is the FVName GUID of the FV described by an FV area.
+	FVName *uuid.UUID `json:",omitempty"`
+	// Stale is set for FV areas which do not describe an FV of the BIOS
+	// region, e.g. after the FV was resized.
+	Stale bool `json:",omitempty"`
+}
+
+// ROMLayoutFile is an AMI ROM layout found in a file.
+type ROMLayoutFile struct {
+	File   uuid.UUID
+	Name   string `json:",omitempty"`
+	Areas  []*ROMLayoutArea
+	Layout *uefi.ROMLayout `json:"-"`
+}
+
+// FindROMLayouts returns the AMI ROM layouts of the BIOS region found in the
+// PE32, TE and raw sections and in the raw files. The layouts are slices of
+// the buffers of the image, so they can be changed in place. The FV offsets
+// are those of the last assembly.
+func FindROMLayouts(br *uefi.BIOSRegion) ([]*ROMLayoutFile, error) {
+	files, err := allFiles(br)
+	if err != nil {
+		return nil, err
+	}
+	fvs := map[uint64]*uefi.FirmwareVolume{}
+	for _, e := range br.Elements {
+		if fv, ok := e.Value.(*uefi.FirmwareVolume); ok {
+			fvs[fv.FVOffset] = fv
+		}
+	}
+	size := uint64(len(br.Buf()))
+	var layouts []*ROMLayoutFile
+	add := func(file *uefi.File, buf []byte) {
+		for _, l := range uefi.FindROMLayouts(buf, size) {
+			lf := &ROMLayoutFile{File: file.Header.UUID, Name: fileName(file), Layout: l}
+			for _, a := range l.Areas {
+				area := &ROMLayoutArea{ROMArea: a}
+				if a.Type == uefi.ROMAreaTypeFV {
+					fv, ok := fvs[uint64(a.Offset)]
+					if ok && uint64(len(fv.Buf())) == uint64(a.Size) {
+						name := fv.FVName
+						area.FVName = &name
+					} else {
+						area.Stale = true
+					}
+				}
+				lf.Areas = append(lf.Areas, area)
+			}
+			layouts = append(layouts, lf)
+		}
+	}
+	for _, file := range files {
+		if file.Header.Type == uefi.FVFileTypeRaw && len(file.Sections) == 0 {
+			if buf := file.Buf(); uint64(len(buf)) > file.DataOffset {
+				add(file, buf[file.DataOffset:])
+			}
+			continue
+		}
+		walkSections(file.Sections, func(s *uefi.Section) {
+			switch s.Header.Type {
+			case uefi.SectionTypePE32, uefi.SectionTypeTE, uefi.SectionTypeRaw:
+				add(file, sectionPayload(s))
+			}
+		})
+	}
+	return layouts, nil
+}
+
+// checkROMLayouts warns about the stale FV areas of the AMI ROM layouts of
+// an assembled BIOS region, which break the AMI flash tools until they are
+// fixed by UpdateROMLayout.
+func checkROMLayouts(br *uefi.BIOSRegion) {
+	layouts, err := FindROMLayouts(br)
+	if err != nil {
+		log.Printf("unable to check the AMI ROM layouts: %v", err)
+		return
+	}
+	for _, l := range layouts {
+		for i, a := range l.Areas {
+			if a.Stale {
+				log.Printf("warning: FV area %d of the AMI ROM layout of file %v does not describe an FV at offset %#x, see update_rom_layout",
+					i, l.File, a.Offset)
+			}
+		}
+	}
+}
+
+// ROMLayout prints the AMI ROM layouts of the image as JSON, with the FVs
+// described by their FV areas. The image is assembled first, so the areas
+// are checked against the current FVs.
+type ROMLayout struct {
+	W io.Writer
+
+	// Output
+	Layouts []*ROMLayoutFile
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *ROMLayout) Run(f uefi.Firmware) error {
+	// Offsets are only accurate once the tree is assembled.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if len(v.Layouts) == 0 {
+		return fmt.Errorf("no AMI ROM layout found")
+	}
+	b, err := json.MarshalIndent(v.Layouts, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(v.W, string(b))
+	return err
+}
+
+// Visit applies the ROMLayout visitor to any Firmware type.
+func (v *ROMLayout) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.BIOSRegion:
+		layouts, err := FindROMLayouts(f)
+		v.Layouts = append(v.Layouts, layouts...)
+		return err
+	}
+	return f.ApplyChildren(v)
+}
+
+// UpdateROMLayout updates the stale FV areas of the AMI ROM layouts after FVs
+// were resized, so the AMI flash tools still find them. An FV area is
+// updated to the size of the FV now starting at its offset. FVs which moved
+// cannot be told apart from removed ones, their areas are an error.
+type UpdateROMLayout struct {
+	// Output
+	Updated []*ROMLayoutArea
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *UpdateROMLayout) Run(f uefi.Firmware) error {
+	if err := (&Assemble{noROMLayoutCheck: true}).Run(f); err != nil {
+		return err
+	}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	// Put the updated layouts into the image.
+	return (&Assemble{}).Run(f)
+}
+
+// Visit applies the UpdateROMLayout visitor to any Firmware type.
+func (v *UpdateROMLayout) Visit(f uefi.Firmware) error {
+	br, ok := f.(*uefi.BIOSRegion)
+	if !ok {
+		return f.ApplyChildren(v)
+	}
+	layouts, err := FindROMLayouts(br)
+	if err != nil {
+		return err
+	}
+	if len(layouts) == 0 {
+		return fmt.Errorf("no AMI ROM layout found")
+	}
+	fvs := map[uint64]*uefi.FirmwareVolume{}
+	for _, e := range br.Elements {
+		if fv, ok := e.Value.(*uefi.FirmwareVolume); ok {
+			fvs[fv.FVOffset] = fv
+		}
+	}
+	for _, l := range layouts {
+		for i, a := range l.Areas {
+			if !a.Stale {
+				continue
+			}
+			fv, ok := fvs[uint64(a.Offset)]
+			if !ok {
+				return fmt.Errorf("ROM layout of file %v: no FV at offset %#x of FV area %d anymore", l.File, a.Offset, i)
+			}
+			a.Size = uint32(len(fv.Buf()))
+			name := fv.FVName
+			a.FVName, a.Stale = &name, false
+			l.Layout.SetArea(i, a.ROMArea)
+			v.Updated = append(v.Updated, a)
+		}
+	}
+	return nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "rom_layout",
+		Help: "Print the AMI ROM layouts, the ROM_AREA descriptors used by the AMI flash tools, as JSON. FV areas are matched with the FVs of the BIOS region, those which do not describe one anymore are marked Stale.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &ROMLayout{W: os.Stdout}, nil
+		},
+	})
+	Register(CLI{
+		Name: "update_rom_layout",
+		Help: "Update the sizes of the stale FV areas of the AMI ROM layouts to those of the FVs at their offsets, after FVs were resized. FVs which moved are an error.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &UpdateROMLayout{}, nil
+		},
+	})
+}
@@ -0,0 +1,118 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// addROMLayout writes a ROM layout of the FVs of OVMF, followed by a raw
+// area, over the start of the DSDT in the ACPI table storage file.
+func addROMLayout(t *testing.T, f uefi.Firmware) {
+	br := f.(*uefi.BIOSRegion)
+	size := uint64(len(br.Buf()))
+	var b bytes.Buffer
+	for _, e := range br.Elements {
+		if fv, ok := e.Value.(*uefi.FirmwareVolume); ok {
+			binary.Write(&b, binary.LittleEndian, uefi.ROMArea{Address: uefi.OffsetToAddress(fv.FVOffset, size),
+				Offset: uint32(fv.FVOffset), Size: uint32(len(fv.Buf())), Type: uefi.ROMAreaTypeFV})
+		}
+	}
+	binary.Write(&b, binary.LittleEndian, uefi.ROMArea{Address: uefi.OffsetToAddress(0x1000, size),
+		Offset: 0x1000, Size: 0x1000, Type: uefi.ROMAreaTypeRaw, Attributes: 0x10})
+	binary.Write(&b, binary.LittleEndian, uefi.ROMArea{})
+
+	tables, err := FindACPITables(f, "DSDT")
+	if err != nil || len(tables) != 1 {
+		t.Fatalf("expected the DSDT, got %v, %v", tables, err)
+	}
+	copy(tables[0].Table.Buf(), b.Bytes())
+}
+
+func TestROMLayout(t *testing.T) {
+	f := parseImage(t)
+	addROMLayout(t, f)
+
+	var b bytes.Buffer
+	v := &ROMLayout{W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.Layouts) != 1 || len(v.Layouts[0].Areas) != 4 {
+		t.Fatalf("expected a layout of 4 areas, got %+v", v.Layouts)
+	}
+	for i, a := range v.Layouts[0].Areas[:3] {
+		if a.Stale || a.FVName == nil {
+			t.Errorf("expected FV area %d to match an FV, got %+v", i, a)
+		}
+	}
+	if a := v.Layouts[0].Areas[3]; a.Type != uefi.ROMAreaTypeRaw || a.Stale || a.FVName != nil {
+		t.Errorf("unexpected raw area %+v", a)
+	}
+
+	// The main FV keeps its offset when it shrinks, its area is updated.
+	sel, err := ParseFVSelector("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tfv := &TightenFV{Selector: sel}
+	if err := tfv.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	layouts, err := FindROMLayouts(f.(*uefi.BIOSRegion))
+	if err != nil || len(layouts) != 1 || !layouts[0].Areas[1].Stale {
+		t.Fatalf("expected the area of the tightened FV to be stale, got %+v, %v", layouts, err)
+	}
+	// Assembling the image warns about it.
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	err = (&Assemble{}).Run(f)
+	log.SetOutput(os.Stderr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(logBuf.String(), "FV area 1 of the AMI ROM layout") {
+		t.Errorf("expected a warning about the stale area, got %q", logBuf.String())
+	}
+	u := &UpdateROMLayout{}
+	if err := u.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(u.Updated) != 1 || u.Updated[0].Size != uint32(tfv.Tightened.Length) {
+		t.Fatalf("expected the area to be updated to %#x bytes, got %+v", tfv.Tightened.Length, u.Updated)
+	}
+
+	// The update is kept in the image.
+	nf, err := uefi.Parse(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if layouts, err = FindROMLayouts(nf.(*uefi.BIOSRegion)); err != nil || len(layouts) != 1 {
+		t.Fatalf("expected a layout, got %+v, %v", layouts, err)
+	}
+	for i, a := range layouts[0].Areas {
+		if a.Stale {
+			t.Errorf("area %d is still stale: %+v", i, a)
+		}
+	}
+
+	// The SEC FV shrinks from the bottom, its area cannot be updated.
+	if sel, err = ParseFVSelector("2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&TightenFV{Selector: sel}).Run(nf); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&UpdateROMLayout{}).Run(nf); err == nil {
+		t.Error("expected an error for a moved FV")
+	}
+}
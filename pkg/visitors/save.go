@@ -5,14 +5,20 @@
 package visitors
 
 import (
-	"io/ioutil"
-
+	"github.com/linuxboot/fiano/pkg/lzma"
 	"github.com/linuxboot/fiano/pkg/uefi"
 )
 
 // Save calls Assemble, then outputs the top image to a file.
 type Save struct {
 	DirPath string
+	// FVSizes forces FVs to a size, see Assemble.
+	FVSizes map[string]uint64
+	// LZMA encodes the LZMA sections, see Assemble.
+	LZMA lzma.Coder
+	// GuidedPassthrough and Compact are passed to Assemble.
+	GuidedPassthrough bool
+	Compact           bool
 }
 
 // Run just applies the visitor.
@@ -23,16 +29,41 @@ func (v *Save) Run(f uefi.Firmware) error {
 // Visit calls the assemble visitor to make sure everything is reconstructed.
 // It then outputs the top level buffer to a file.
 func (v *Save) Visit(f uefi.Firmware) error {
-	a := &Assemble{}
+	a := &Assemble{
+		FVSizes:           v.FVSizes,
+		LZMA:              v.LZMA,
+		GuidedPassthrough: v.GuidedPassthrough,
+		Compact:           v.Compact,
+	}
 	// Assemble the binary to make sure the top level buffer is correct
-	f.Apply(a)
-	return ioutil.WriteFile(v.DirPath, f.Buf(), 0666)
+	if err := f.Apply(a); err != nil {
+		return err
+	}
+	return writeFile(v.DirPath, f.Buf(), 0666)
 }
 
 func init() {
-	RegisterCLI("save", 1, func(args []string) (uefi.Visitor, error) {
-		return &Save{
-			DirPath: args[0],
-		}, nil
+	Register(CLI{
+		Name:  "save",
+		Args:  []string{"FILE"},
+		Help:  "Save the current state of the image to the given file. Operations are applied left-to-right, so only the operations to the left are included in the new image.",
+		Flags: []string{"guided-passthrough", "compact", "fv-size", "lzma-backend"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			sizes, err := ParseFVSizes(*fvSize)
+			if err != nil {
+				return nil, err
+			}
+			coder, err := lzma.NewCoder(lzma.Backend(*lzmaBackend))
+			if err != nil {
+				return nil, err
+			}
+			return &Save{
+				DirPath:           args[0],
+				FVSizes:           sizes,
+				LZMA:              coder,
+				GuidedPassthrough: *guidedPassthrough,
+				Compact:           *compact,
+			}, nil
+		},
 	})
 }
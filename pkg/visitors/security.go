@@ -0,0 +1,253 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var acmKeys = flag.String("acm-keys", "", "JSON file with the hashes of the ACM signing keys to trust, see uefi.LoadACMKeys")
+
+// Boot Guard profiles the image is set up for.
+const (
+	BootGuardNone     = "neither"
+	BootGuardMeasured = "measured boot"
+	BootGuardVerified = "verified boot"
+)
+
+// SecurityReport prints the FIT of the image, the signature status of the
+// startup ACM and the Boot Guard setup.
+type SecurityReport struct {
+	// Input
+	W io.Writer
+
+	// Output
+	FIT      *uefi.FIT
+	FITError error
+	ACM      *uefi.ACM
+	// ACMError is set if the ACM cannot be parsed or its signature does not
+	// verify.
+	ACMError error
+	// KM and BPM are the Boot Guard manifests. The errors are set if a
+	// manifest cannot be parsed or its signature does not verify.
+	KM       *uefi.KeyManifest
+	KMError  error
+	BPM      *uefi.BootPolicyManifest
+	BPMError error
+	// BootGuard is the Boot Guard profile the image is set up for, by the
+	// manifests and the straps.
+	BootGuard string
+	// Straps are the descriptor straps with a known meaning.
+	Straps []uefi.StrapField
+	// StrapProfile is the Boot Guard profile selected by the straps, nil if
+	// the straps of the chipset do not tell it, see uefi.AddStrapField.
+	StrapProfile *uefi.BootGuardProfile
+	// BootGuardConflict says how the straps and the manifests disagree, if
+	// they do.
+	BootGuardConflict string
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SecurityReport) Run(f uefi.Firmware) error {
+	// Offsets are only accurate once the tree is assembled.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	image := f.Buf()
+	v.FIT, v.FITError = uefi.FindFIT(image)
+	v.ACM, v.ACMError = nil, nil
+	v.KM, v.KMError = nil, nil
+	v.BPM, v.BPMError = nil, nil
+	v.Straps, v.StrapProfile = nil, nil
+	if v.FIT != nil {
+		// Only the first entry of each type is used.
+		seen := make(map[uefi.FITEntryType]bool)
+		for _, e := range v.FIT.Entries {
+			t := e.Type()
+			if seen[t] {
+				continue
+			}
+			seen[t] = true
+			offset, err := uefi.AddressToOffset(e.Address, uint64(len(image)))
+			switch t {
+			case uefi.FITTypeStartupACM:
+				if v.ACMError = err; err == nil {
+					if v.ACM, v.ACMError = uefi.NewACM(image[offset:]); v.ACMError == nil {
+						v.ACMError = v.ACM.Verify()
+					}
+				}
+			case uefi.FITTypeKeyManifest:
+				if v.KMError = err; err == nil {
+					if v.KM, v.KMError = uefi.NewKeyManifest(image[offset:]); v.KMError == nil {
+						v.KMError = v.KM.Verify()
+					}
+				}
+			case uefi.FITTypeBootPolicy:
+				if v.BPMError = err; err == nil {
+					if v.BPM, v.BPMError = uefi.NewBootPolicyManifest(image[offset:]); v.BPMError == nil {
+						v.BPMError = v.BPM.Verify()
+					}
+				}
+			}
+		}
+	}
+	if fi, ok := f.(*uefi.FlashImage); ok && fi.IFD.Straps != nil {
+		v.Straps = fi.IFD.Straps.Fields
+		v.StrapProfile = fi.IFD.Straps.BootGuardProfile
+	}
+	v.BootGuard, v.BootGuardConflict = v.bootGuardProfile()
+	return f.Apply(v)
+}
+
+// bootGuardProfile returns the Boot Guard profile the image is set up for,
+// combining the manifests with the profile the straps select before it is
+// fused, and how they disagree. Straps disabling Boot Guard win over the
+// manifests. Straps selecting verified boot need manifests allowing it,
+// otherwise the image boots with what the manifests allow, if at all.
+func (v *SecurityReport) bootGuardProfile() (string, string) {
+	manifests := v.manifestProfile()
+	p := v.StrapProfile
+	switch {
+	case p == nil:
+		return manifests, ""
+	case !p.Verified() && !p.Measured():
+		if manifests != BootGuardNone {
+			return BootGuardNone, fmt.Sprintf("the straps select the %v profile, the manifests are not used", *p)
+		}
+		return BootGuardNone, ""
+	case p.Verified() && manifests != BootGuardVerified:
+		return manifests, fmt.Sprintf("the straps select the %v profile, but the manifests do not allow verified boot", *p)
+	}
+	return manifests, ""
+}
+
+// manifestProfile returns the Boot Guard profile the manifests allow: verified
+// boot needs the complete chain from the key manifest to the IBB hash,
+// anything less in the manifests only allows measured boot.
+func (v *SecurityReport) manifestProfile() string {
+	if v.KM == nil && v.KMError == nil && v.BPM == nil && v.BPMError == nil {
+		// There are no manifest entries in the FIT.
+		return BootGuardNone
+	}
+	if v.ACM == nil || v.KM == nil || v.BPM == nil || v.KMError != nil || v.BPMError != nil {
+		return BootGuardMeasured
+	}
+	bpKey := v.BPM.KeySignature.KeyHash()
+	if !bytes.Equal(v.KM.BPKeyHash.Digest, bpKey[:]) || len(v.BPM.IBBHash.Digest) == 0 {
+		return BootGuardMeasured
+	}
+	for _, b := range v.BPM.IBBHash.Digest {
+		if b != 0 {
+			return BootGuardVerified
+		}
+	}
+	return BootGuardMeasured
+}
+
+// errorStatus describes a verification result.
+func errorStatus(err error) string {
+	if err != nil {
+		return fmt.Sprintf("invalid (%v)", err)
+	}
+	return "valid"
+}
+
+// Visit applies the SecurityReport visitor to any Firmware type.
+func (v *SecurityReport) Visit(f uefi.Firmware) error {
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	if v.FIT == nil {
+		fmt.Fprintf(w, "No FIT: %v\n", v.FITError)
+	} else {
+		fmt.Fprintf(w, "FIT at %#x\n", v.FIT.Address)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "Type\tAddress\tSize\tVersion\n")
+		for _, e := range v.FIT.Entries[1:] {
+			fmt.Fprintf(tw, "%v\t%#x\t%#x\t%#x\n", e.Type(), e.Address, uefi.Read3Size(e.Size)*16, e.Version)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case v.ACM == nil && v.ACMError == nil:
+		fmt.Fprintln(w, "No startup ACM")
+	case v.ACM == nil:
+		fmt.Fprintf(w, "Startup ACM: %v\n", v.ACMError)
+	default:
+		signing, known := v.ACM.Signing()
+		source := "by its key"
+		if !known {
+			source = "by its header, the key is unknown"
+		}
+		fmt.Fprintf(w, "Startup ACM: %s signed (%s), signature %s\n", signing, source, errorStatus(v.ACMError))
+		fmt.Fprintf(w, "ACM key %x (%s)\n", v.ACM.KeyHash(), v.ACM.KeyName())
+		if known && v.ACM.Debug() != (signing == "debug") {
+			fmt.Fprintf(w, "ACM header does not match its %s key\n", signing)
+		}
+	}
+
+	if v.StrapProfile != nil {
+		fmt.Fprintf(w, "Boot Guard: %s (from the FIT manifests and the %v profile of the straps)\n", v.BootGuard, *v.StrapProfile)
+	} else {
+		fmt.Fprintf(w, "Boot Guard: %s (from the FIT manifests, the straps do not tell the profile)\n", v.BootGuard)
+	}
+	if v.BootGuardConflict != "" {
+		fmt.Fprintf(w, "Boot Guard conflict: %s\n", v.BootGuardConflict)
+	}
+	switch {
+	case v.KM != nil:
+		fmt.Fprintf(w, "Key manifest: ID %d, SVN %d, signature %s\n", v.KM.ID, v.KM.SVN, errorStatus(v.KMError))
+		fmt.Fprintf(w, "KM key hash %x\n", v.KM.KeySignature.KeyHash())
+		fmt.Fprintf(w, "BPM key hash in KM %x\n", v.KM.BPKeyHash.Digest)
+	case v.KMError != nil:
+		fmt.Fprintf(w, "Key manifest: %v\n", v.KMError)
+	}
+	switch {
+	case v.BPM != nil:
+		fmt.Fprintf(w, "Boot policy manifest: SVN %d, ACM SVN %d, signature %s\n",
+			v.BPM.SVN, v.BPM.ACMSVN, errorStatus(v.BPMError))
+		fmt.Fprintf(w, "BPM key hash %x\n", v.BPM.KeySignature.KeyHash())
+		fmt.Fprintf(w, "IBB hash %x, entry point %#x, %d segments\n",
+			v.BPM.IBBHash.Digest, v.BPM.EntryPoint, len(v.BPM.IBBSegments))
+	case v.BPMError != nil:
+		fmt.Fprintf(w, "Boot policy manifest: %v\n", v.BPMError)
+	}
+	for _, s := range v.Straps {
+		fmt.Fprintf(w, "Strap %v\n", s)
+	}
+	return nil
+}
+
+func init() {
+	Register(CLI{
+		Name:  "security",
+		Help:  "Print the FIT and whether the signature of the startup ACM is valid and production or debug, with the hash of its key. The keys given by -acm-keys are known by name, and tell production and debug keys apart. Also print the Boot Guard manifests, their key hashes and whether the image is set up for measured or verified boot, by the manifests and the Boot Guard profile of the straps, if it is known for the chipset.",
+		Flags: []string{"acm-keys"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			if *acmKeys != "" {
+				f, err := os.Open(*acmKeys)
+				if err != nil {
+					return nil, err
+				}
+				defer f.Close()
+				if err := uefi.LoadACMKeys(f); err != nil {
+					return nil, fmt.Errorf("%v: %v", *acmKeys, err)
+				}
+			}
+			return &SecurityReport{}, nil
+		},
+	})
+}
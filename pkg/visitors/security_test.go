@@ -0,0 +1,132 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/testutil"
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestSecurityReport(t *testing.T) {
+	f := parseImage(t)
+
+	var b bytes.Buffer
+	v := &SecurityReport{W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if v.FIT != nil || !strings.HasPrefix(b.String(), "No FIT") {
+		t.Errorf("expected no FIT in OVMF, got %q", b.String())
+	}
+	if v.BootGuard != BootGuardNone {
+		t.Errorf("expected no Boot Guard, got %q", v.BootGuard)
+	}
+
+	addFITParts(t, f)
+	if err := (&GenerateFIT{Address: 0xfffd4000}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	b.Reset()
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if v.FIT == nil || len(v.FIT.Entries) != 3 {
+		t.Fatalf("expected a FIT of 3 entries, got %+v (%v)", v.FIT, v.FITError)
+	}
+	// The ACM is only a header, so it does not parse.
+	if v.ACM != nil || v.ACMError == nil {
+		t.Errorf("expected an ACM error, got %+v", v.ACM)
+	}
+	if !strings.Contains(b.String(), "Startup ACM: ") {
+		t.Errorf("expected the ACM status in the report, got %q", b.String())
+	}
+}
+
+func TestSecurityReportBootGuard(t *testing.T) {
+	f := parseImage(t)
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	size := uint64(len(f.Buf()))
+
+	// A FIT pointing to a key manifest which does not parse.
+	fit, err := uefi.NewFITFromParts(0xfffd4000, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fit.Entries = append(fit.Entries, uefi.FITEntry{Address: 0xfffd3000, Version: uefi.FITVersion, TypeCV: uint8(uefi.FITTypeKeyManifest)})
+	fit.Entries[0].Size = uefi.Write3Size(uint64(len(fit.Entries)))
+	root := node{Firmware: f, InFlash: true}
+	pointer := make([]byte, 8)
+	binary.LittleEndian.PutUint64(pointer, fit.Address)
+	for _, p := range []struct {
+		offset uint64
+		data   []byte
+	}{
+		{0x3d3000, uefi.KMStructureID},
+		{0x3d4000, fit.Bytes()},
+		{size - uefi.FITPointerOffset, pointer},
+	} {
+		if err := patchFlash(root, p.offset, p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var b bytes.Buffer
+	v := &SecurityReport{W: &b}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if v.KM != nil || v.KMError == nil {
+		t.Errorf("expected a key manifest error, got %+v", v.KM)
+	}
+	if v.BootGuard != BootGuardMeasured {
+		t.Errorf("expected measured boot only, got %q", v.BootGuard)
+	}
+	if !strings.Contains(b.String(), "Boot Guard: measured boot") {
+		t.Errorf("expected the Boot Guard profile in the report, got %q", b.String())
+	}
+	if !strings.Contains(b.String(), "straps do not tell the profile") {
+		t.Errorf("expected the report to say the straps do not tell the profile, got %q", b.String())
+	}
+
+	// The same BIOS region in a flash image, whose straps select a profile.
+	// The Boot Guard profile field is not public, pretend to know it.
+	uefi.AddStrapField(uefi.ChipsetPCH, uefi.StrapField{Name: uefi.StrapBootGuardProfile, Strap: 15, Shift: 4, Width: 3})
+	image := testutil.Image(map[int][]byte{uefi.RegionBIOS: f.Buf()})
+	image[0x1a], image[0x1b] = 0x10, 16 // FLMAP1: 16 PCH straps at 0x100
+	for _, test := range []struct {
+		profile   uefi.BootGuardProfile
+		bootGuard string
+		report    string
+	}{
+		{uefi.BootGuardProfileFVME, BootGuardMeasured, "Boot Guard conflict: the straps select the FVME profile, but the manifests do not allow verified boot"},
+		{uefi.BootGuardProfileNoFVME, BootGuardNone, "Boot Guard conflict: the straps select the No_FVME profile, the manifests are not used"},
+	} {
+		binary.LittleEndian.PutUint32(image[0x13c:], uint32(test.profile)<<4) // PCHSTRP15
+		fi, err := uefi.Parse(append([]byte{}, image...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b.Reset()
+		if err := v.Run(fi); err != nil {
+			t.Fatal(err)
+		}
+		if v.BootGuard != test.bootGuard {
+			t.Errorf("%v: expected %q by the straps and manifests, got %q", test.profile, test.bootGuard, v.BootGuard)
+		}
+		if want := "Boot Guard: " + test.bootGuard + " (from the FIT manifests and the " + test.profile.String() + " profile of the straps)"; !strings.Contains(b.String(), want) {
+			t.Errorf("expected %q in the report, got %q", want, b.String())
+		}
+		if !strings.Contains(b.String(), test.report) {
+			t.Errorf("expected %q in the report, got %q", test.report, b.String())
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// SetName sets the UI section of all files matching Predicate to Name. A UI
+// section is added to files which do not have one.
+type SetName struct {
+	// Input
+	Predicate func(f *uefi.File, name string) bool
+	Name      string
+
+	// Output
+	Matches []*uefi.File
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SetName) Run(f uefi.Firmware) error {
+	// First run "find" to generate a list of matches to rename.
+	find := Find{
+		Predicate: v.Predicate,
+	}
+	if err := find.Run(f); err != nil {
+		return err
+	}
+
+	v.Matches = find.Matches
+	if err := checkProtected("set_name", v.Matches); err != nil {
+		return err
+	}
+	for _, m := range v.Matches {
+		if err := m.Apply(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Visit applies the SetName visitor to any Firmware type.
+func (v *SetName) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.File:
+		if _, ok := uefi.SupportedFiles[f.Header.Type]; !ok {
+			return fmt.Errorf("file %v of type %v does not hold sections, cannot set its name",
+				f.Header.UUID, f.Header.Type)
+		}
+		for i, s := range f.Sections {
+			if s.Header.Type == uefi.SectionTypeUserInterface {
+				ui, err := uefi.NewUISection(v.Name, s.FileOrder)
+				if err != nil {
+					return err
+				}
+				f.Sections[i] = ui
+				return nil
+			}
+		}
+		ui, err := uefi.NewUISection(v.Name, len(f.Sections))
+		if err != nil {
+			return err
+		}
+		f.Sections = append(f.Sections, ui)
+	}
+	// Must be applied to a File to have any effect.
+	return nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "set_name",
+		Args: []string{"(GUID|NAME)", "NEWNAME"},
+		Help: "Set the UI section of the files which match the given GUID or NAME, adding one if needed.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			searchRE, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &SetName{
+				Predicate: func(f *uefi.File, name string) bool {
+					return searchRE.MatchString(name) || searchRE.MatchString(f.Header.UUID.String())
+				},
+				Name: args[1],
+			}, nil
+		},
+	})
+}
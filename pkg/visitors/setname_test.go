@@ -0,0 +1,71 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestSetName(t *testing.T) {
+	var tests = []struct {
+		name     string
+		dropUI   bool
+		fileType uefi.FVFileType
+	}{
+		{"replace", false, uefi.FVFileTypeSECCore},
+		{"add", true, uefi.FVFileTypeSECCore},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			uefi.Attributes.ErasePolarity = 0xFF
+			fv, err := uefi.NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The FV is full, drop the pad file so the SEC core can grow. Assemble
+			// pads up to the VTF again.
+			fv.Files = append(fv.Files[:1], fv.Files[2:]...)
+			if test.dropUI {
+				sec := fv.Files[0]
+				for i, s := range sec.Sections {
+					if s.Header.Type == uefi.SectionTypeUserInterface {
+						sec.Sections = append(sec.Sections[:i], sec.Sections[i+1:]...)
+						break
+					}
+				}
+			}
+			sn := &SetName{Predicate: FindFileTypePredicate(test.fileType), Name: "RenamedSec"}
+			if err := sn.Run(fv); err != nil {
+				t.Fatal(err)
+			}
+			if len(sn.Matches) != 1 {
+				t.Fatalf("expected 1 match, got %d", len(sn.Matches))
+			}
+			if err := (&Assemble{}).Run(fv); err != nil {
+				t.Fatal(err)
+			}
+			nfv, err := uefi.NewFirmwareVolume(fv.Buf(), 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name := fileName(nfv.Files[0]); name != "RenamedSec" {
+				t.Errorf("expected name RenamedSec, got %q", name)
+			}
+		})
+	}
+}
+
+func TestSetNameNoSections(t *testing.T) {
+	fv, err := uefi.NewFirmwareVolume(append([]byte{}, sampleFV...), 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sn := &SetName{Predicate: FindFileTypePredicate(uefi.FVFileTypeRaw), Name: "VTF"}
+	if err := sn.Run(fv); err == nil {
+		t.Errorf("expected an error when naming a raw file")
+	}
+}
@@ -0,0 +1,230 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// SetupVar sets a Setup question, like setup_var in the GRUB shell, in the
+// image: the value is written into the variables of its var store in the
+// NVRAM, also the AMI NVRAM and StdDefaults, and set as the default in the
+// IFR of the form.
+type SetupVar struct {
+	// Input
+	// Prompt is the prompt of the question, matched without case. If
+	// empty, the question is at Offset of the var store named VarStore.
+	Prompt   string
+	VarStore string
+	Offset   uint16
+	Value    uint64
+
+	// Output
+	// Questions are the questions whose default was set.
+	Questions []*uefi.HIIQuestion
+	// Variables are the variables which were changed.
+	Variables []*uefi.Variable
+	// NVars are the AMI NVAR entries which were changed.
+	NVars []*uefi.NVar
+
+	// The location of the value.
+	name string
+	guid *uuid.UUID
+	size int
+}
+
+// setupVarLocation is the location of the value of a question.
+type setupVarLocation struct {
+	name   string
+	guid   uuid.UUID
+	offset uint16
+	size   int
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SetupVar) Run(f uefi.Firmware) error {
+	files, err := allFiles(f)
+	if err != nil {
+		return err
+	}
+	var questions []*uefi.HIIQuestion
+	// The files holding the questions and the NVAR entries to be changed.
+	var changed []*uefi.File
+	locations := make(map[setupVarLocation]bool)
+	for _, file := range files {
+		n := len(questions)
+		walkSections(file.Sections, func(s *uefi.Section) {
+			for _, l := range s.HII {
+				for _, fs := range l.FormSets {
+					v.findQuestions(fs, &questions, locations)
+				}
+			}
+		})
+		if len(questions) > n {
+			changed = append(changed, file)
+		}
+	}
+
+	v.name, v.guid, v.size = v.VarStore, nil, 1
+	switch {
+	case len(locations) > 1:
+		return fmt.Errorf("setup question %v is ambiguous, it is stored in %d locations", v.question(), len(locations))
+	case len(locations) == 1:
+		for l := range locations {
+			g := l.guid
+			v.name, v.guid, v.Offset, v.size = l.name, &g, l.offset, l.size
+		}
+	case v.Prompt != "":
+		return fmt.Errorf("no setup question %v", v.question())
+	}
+	if v.size < 8 && v.Value>>(8*uint(v.size)) != 0 {
+		return fmt.Errorf("value %#x does not fit into the %d bytes of setup question %v", v.Value, v.size, v.question())
+	}
+	for _, file := range files {
+		if file.NVarStore == nil {
+			continue
+		}
+		for _, nv := range file.NVarStore.Entries {
+			if v.matchNVar(nv) {
+				changed = append(changed, file)
+				break
+			}
+		}
+	}
+	if err := checkProtected("setup_var", changed); err != nil {
+		return err
+	}
+
+	for _, q := range questions {
+		if err := q.SetDefault(v.Value); err != nil {
+			// The value is checked against the options.
+			if len(q.Options) > 0 {
+				return err
+			}
+			continue
+		}
+		v.Questions = append(v.Questions, q)
+	}
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if len(v.Questions) == 0 && len(v.Variables) == 0 && len(v.NVars) == 0 {
+		return fmt.Errorf("setup question %v has no default and no variable in the NVRAM", v.question())
+	}
+	return nil
+}
+
+// question describes the question being set.
+func (v *SetupVar) question() string {
+	if v.Prompt != "" {
+		return fmt.Sprintf("%q", v.Prompt)
+	}
+	return fmt.Sprintf("%v:%#x", v.VarStore, v.Offset)
+}
+
+// findQuestions appends the questions of the form set matching v and the
+// locations of their values.
+func (v *SetupVar) findQuestions(fs *uefi.HIIFormSet, questions *[]*uefi.HIIQuestion, locations map[setupVarLocation]bool) {
+	stores := make(map[uint16]*uefi.HIIVarStore)
+	for _, vs := range fs.VarStores {
+		// Name/value var stores do not have offsets.
+		if vs.Type != "NAME_VALUE" {
+			stores[vs.ID] = vs
+		}
+	}
+	for _, form := range fs.Forms {
+		for _, q := range form.Questions {
+			vs, ok := stores[q.VarStoreID]
+			if !ok || q.Size == 0 {
+				continue
+			}
+			if v.Prompt != "" && !strings.EqualFold(strings.TrimSpace(q.Prompt), strings.TrimSpace(v.Prompt)) {
+				continue
+			}
+			if v.Prompt == "" && (vs.Name != v.VarStore || q.VarOffset != v.Offset) {
+				continue
+			}
+			*questions = append(*questions, q)
+			locations[setupVarLocation{name: vs.Name, guid: vs.GUID, offset: q.VarOffset, size: q.Size}] = true
+		}
+	}
+}
+
+// matchNVar returns whether the NVAR entry holds the value.
+func (v *SetupVar) matchNVar(nv *uefi.NVar) bool {
+	return nv.Valid() && nv.Name == v.name && (v.guid == nil || nv.GUID == *v.guid)
+}
+
+// Visit applies the SetupVar visitor to any Firmware type.
+func (v *SetupVar) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.VariableStore:
+		for _, va := range f.Variables {
+			if !va.Header.State.Valid() || va.Name != v.name || (v.guid != nil && va.Header.VendorGUID != *v.guid) {
+				continue
+			}
+			data := va.Data()
+			if int(v.Offset)+v.size > len(data) {
+				return fmt.Errorf("setup question %v at %#x does not fit into the %#x bytes of variable %v",
+					v.question(), v.Offset, len(data), va.Name)
+			}
+			for i := 0; i < v.size; i++ {
+				data[int(v.Offset)+i] = byte(v.Value >> (8 * uint(i)))
+			}
+			v.Variables = append(v.Variables, va)
+		}
+		return nil
+
+	case *uefi.File:
+		if f.NVarStore != nil {
+			buf := make([]byte, v.size)
+			for i := range buf {
+				buf[i] = byte(v.Value >> (8 * uint(i)))
+			}
+			for _, nv := range f.NVarStore.Entries {
+				if !v.matchNVar(nv) {
+					continue
+				}
+				if err := nv.SetData(int(v.Offset), buf); err != nil {
+					return fmt.Errorf("setup question %v: %v", v.question(), err)
+				}
+				v.NVars = append(v.NVars, nv)
+			}
+		}
+	}
+	return f.ApplyChildren(v)
+}
+
+// parseSetupVar parses a question given as PROMPT or VARSTORE:OFFSET.
+func parseSetupVar(s string) *SetupVar {
+	if i := strings.LastIndex(s, ":"); i > 0 {
+		if offset, err := strconv.ParseUint(s[i+1:], 0, 16); err == nil {
+			return &SetupVar{VarStore: s[:i], Offset: uint16(offset)}
+		}
+	}
+	return &SetupVar{Prompt: s}
+}
+
+func init() {
+	Register(CLI{
+		Name: "setup_var",
+		Args: []string{"(PROMPT|VARSTORE:OFFSET)", "VALUE"},
+		Help: "Set a Setup question, given by its prompt or the offset in its var store, e.g. Setup:0x1a. The value is written into the variables of the var store in the NVRAM, also the AMI NVRAM and StdDefaults, and becomes the default of the question in the HII forms.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			value, err := strconv.ParseUint(args[1], 0, 64)
+			if err != nil {
+				return nil, err
+			}
+			v := parseSetupVar(args[0])
+			v.Value = value
+			return v, nil
+		},
+	})
+}
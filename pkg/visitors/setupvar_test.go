@@ -0,0 +1,203 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/testutil"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// setupPackageList builds an HII package list with a Setup form set of a
+// ONE_OF at offset 2, a NUMERIC with a default at offset 4 and a CHECKBOX
+// at offset 8 of the Setup var store.
+func setupPackageList(t *testing.T) *uefi.HIIPackageList {
+	var b bytes.Buffer
+	w := func(v ...interface{}) {
+		for _, f := range v {
+			if err := binary.Write(&b, binary.LittleEndian, f); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	op := func(code uint8, scope bool, fields ...interface{}) {
+		var f bytes.Buffer
+		for _, v := range fields {
+			binary.Write(&f, binary.LittleEndian, v)
+		}
+		l := uint8(f.Len() + 2)
+		if scope {
+			l |= 0x80
+		}
+		w(code, l, f.Bytes())
+	}
+	question := func(prompt, offset uint16, fields ...interface{}) []interface{} {
+		return append([]interface{}{prompt, uint16(0), prompt, uint16(1), offset, uint8(0)}, fields...)
+	}
+
+	// Strings 1 to 4.
+	var strs bytes.Buffer
+	strs.WriteByte(0x16)
+	binary.Write(&strs, binary.LittleEndian, uint16(4))
+	for _, s := range []string{"Setup", "Boot Mode", "Timeout", "Fast Boot"} {
+		for _, c := range s + "\x00" {
+			strs.Write([]byte{byte(c), 0})
+		}
+	}
+	strs.WriteByte(0)
+	lang := []byte("en-US\x00")
+	w(uint32(46+len(lang)+strs.Len())|uefi.HIIPackageTypeStrings<<24, uint32(46), uint32(46+len(lang)), make([]byte, 34), lang, strs.Bytes())
+
+	forms := b.Len()
+	w(uint32(0))
+	op(0x0E, true, *testVendorGUID, uint16(1), uint16(0), uint8(0))
+	op(0x24, false, *testVendorGUID, uint16(1), uint16(10), []byte("Setup\x00"))
+	op(0x01, true, uint16(1), uint16(1))
+	op(0x05, true, question(2, 2, uint8(0), uint8(0), uint8(2), uint8(1))...)
+	op(0x09, false, uint16(2), uint8(0x10), uint8(0), uint8(0))
+	op(0x09, false, uint16(2), uint8(0), uint8(0), uint8(1))
+	op(0x09, false, uint16(2), uint8(0), uint8(0), uint8(2))
+	op(0x29, false)
+	op(0x07, true, question(3, 4, uint8(1), uint16(0), uint16(60), uint16(1))...)
+	op(0x5B, false, uint16(0), uint8(1), uint16(5))
+	op(0x29, false)
+	op(0x06, false, question(4, 8, uint8(0))...)
+	op(0x29, false)
+	op(0x29, false)
+	binary.LittleEndian.PutUint32(b.Bytes()[forms:], uint32(b.Len()-forms)|uefi.HIIPackageTypeForms<<24)
+	w(uint32(4) | uefi.HIIPackageTypeEnd<<24)
+
+	list := make([]byte, 20)
+	binary.LittleEndian.PutUint32(list[16:], uint32(20+b.Len()))
+	l, err := uefi.NewHIIPackageList(append(list, b.Bytes()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l
+}
+
+var setupFormsGUID = *uuid.MustParse("5C0C0E4A-8D1F-4B7B-9E7A-6B1D2F3C4D5E")
+
+// setupImage returns an FV with the Setup forms and a Setup variable, whose
+// data is "Setup data".
+func setupImage(t *testing.T) (*uefi.FirmwareVolume, *uefi.HIIPackageList) {
+	uefi.Attributes.ErasePolarity = 0xFF
+	vs, err := uefi.NewVariableStore(testutil.VariableStore(0x1000,
+		testVariable("Setup", uefi.VarAdded), testVariable("Other", uefi.VarAdded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := setupPackageList(t)
+	fv := &uefi.FirmwareVolume{
+		Files: []*uefi.File{{
+			Header:   uefi.FileHeaderExtended{FileHeader: uefi.FileHeader{UUID: setupFormsGUID}},
+			Sections: []*uefi.Section{{HII: []*uefi.HIIPackageList{l}}},
+		}},
+		VariableStore: vs,
+	}
+	return fv, l
+}
+
+func TestSetupVar(t *testing.T) {
+	var tests = []struct {
+		name     string
+		question string
+		value    uint64
+		want     string
+		index    int
+	}{
+		{"prompt", "boot mode", 1, "Se\x01up data", 0},
+		{"offset", "Setup:4", 0x6162, "Setubadata", 1},
+		{"checkbox", "Fast Boot", 1, "Setup da\x01a", 2},
+		{"noQuestion", "Setup:0x9", 0x41, "Setup datA", -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv, l := setupImage(t)
+			v := parseSetupVar(test.question)
+			v.Value = test.value
+			if err := v.Run(fv); err != nil {
+				t.Fatal(err)
+			}
+			if len(v.Variables) != 1 {
+				t.Fatalf("expected the Setup variable to change, got %d variables", len(v.Variables))
+			}
+			if got := string(fv.VariableStore.Variables[0].Data()); got != test.want {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+			if test.index < 0 {
+				if len(v.Questions) != 0 {
+					t.Errorf("expected no question, got %v", v.Questions)
+				}
+				return
+			}
+			q := l.FormSets[0].Forms[0].Questions[test.index]
+			if len(v.Questions) != 1 || v.Questions[0] != q {
+				t.Fatalf("expected the question %q, got %v", q.Prompt, v.Questions)
+			}
+			if q.Default == nil || *q.Default != test.value {
+				t.Errorf("expected the default %#x, got %v", test.value, q.Default)
+			}
+		})
+	}
+}
+
+func TestSetupVarErrors(t *testing.T) {
+	var tests = []struct {
+		name     string
+		question string
+		value    uint64
+	}{
+		{"noOption", "Boot Mode", 3},
+		{"tooLarge", "Timeout", 0x10000},
+		{"noPrompt", "Quiet Boot", 1},
+		{"noVariable", "Other:0x20", 1},
+		{"noVarStore", "Missing:0", 1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fv, _ := setupImage(t)
+			v := parseSetupVar(test.question)
+			v.Value = test.value
+			if err := v.Run(fv); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestSetupVarNVar(t *testing.T) {
+	// An AMI NVRAM with the Setup variable, whose data is "Setup data".
+	body := append(append(append([]byte{}, testVendorGUID[:]...), "Setup\x00"...), "Setup data"...)
+	entry := append([]byte("NVAR\x00\x00\xff\xff\xff"), uefi.NVarValid|uefi.NVarASCIIName|uefi.NVarGUID)
+	binary.LittleEndian.PutUint16(entry[4:], uint16(uefi.NVarHeaderSize+len(body)))
+	nvram, err := uefi.NewNVarStore(append(entry, body...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := setupPackageList(t)
+	fv := &uefi.FirmwareVolume{
+		Files: []*uefi.File{
+			{Sections: []*uefi.Section{{HII: []*uefi.HIIPackageList{l}}}},
+			{NVarStore: nvram},
+		},
+	}
+
+	v := parseSetupVar("Timeout")
+	v.Value = 0x6162
+	if err := v.Run(fv); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.NVars) != 1 || len(v.Questions) != 1 {
+		t.Fatalf("expected an NVAR and a question, got %d NVARs and %d questions", len(v.NVars), len(v.Questions))
+	}
+	if got := string(nvram.Entries[0].Data()); got != "Setubadata" {
+		t.Errorf("expected %q, got %q", "Setubadata", got)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var sizesSort = flag.String("sizes-sort", "compressed", "size the files are ordered by in sizes, compressed or uncompressed")
+
+// FileSize is the footprint of a file.
+type FileSize struct {
+	File *uefi.File
+	// Size is the size of the file in its FV, Uncompressed the size of its
+	// leaf sections once decompressed.
+	Size         uint64
+	Uncompressed uint64
+}
+
+// FVSizes are the sizes of the files of an FV.
+type FVSizes struct {
+	// Path is the path of the FV as in Ls, by child indices.
+	Path  string
+	FV    *uefi.FirmwareVolume
+	Files []FileSize
+	// Size and Uncompressed are the totals of the files.
+	Size         uint64
+	Uncompressed uint64
+}
+
+// Sizes lists the files of each FV ordered by their footprint, largest first,
+// with cumulative totals, to see which files are worth removing when
+// minimizing an image. Pad files are not listed.
+type Sizes struct {
+	// Input
+	// Uncompressed orders the files by their uncompressed size instead of
+	// their size in the FV.
+	Uncompressed bool
+	W            io.Writer
+
+	// Output
+	FVs []FVSizes
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Sizes) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the Sizes visitor to any Firmware type.
+func (v *Sizes) Visit(f uefi.Firmware) error {
+	v.FVs = nil
+	v.collect(node{Firmware: f, InFlash: true}, "")
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	for _, fv := range v.FVs {
+		fmt.Fprintf(w, "FV %s %v: %d files, %#x bytes, %#x bytes uncompressed\n",
+			fv.Path, uefi.FVGUIDs[fv.FV.FileSystemGUID], len(fv.Files), fv.Size, fv.Uncompressed)
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(tw, "GUID\tName\tType\tSize\tUncompressed\tCumulative\t%%\n")
+		total := fv.Size
+		if v.Uncompressed {
+			total = fv.Uncompressed
+		}
+		var cumulative uint64
+		for _, fs := range fv.Files {
+			if v.Uncompressed {
+				cumulative += fs.Uncompressed
+			} else {
+				cumulative += fs.Size
+			}
+			percent := 0.0
+			if total != 0 {
+				percent = float64(cumulative) * 100 / float64(total)
+			}
+			guid, name, typez := nodeInfo(fs.File)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%#x\t%#x\t%#x\t%.1f\n", guid, name, typez, fs.Size, fs.Uncompressed, cumulative, percent)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// collect adds the FVs at and below n.
+func (v *Sizes) collect(n node, path string) {
+	cs := children(n)
+	if fv, ok := n.Firmware.(*uefi.FirmwareVolume); ok && len(fv.Files) != 0 {
+		p := path
+		if p == "" {
+			p = "/"
+		}
+		fvs := FVSizes{Path: p, FV: fv}
+		uefi.Attributes.ErasePolarity = fv.GetErasePolarity()
+		for _, c := range cs {
+			file, ok := c.Firmware.(*uefi.File)
+			if !ok || file.Header.Type == uefi.FVFileTypePad {
+				continue
+			}
+			fs := FileSize{File: file, Size: uint64(len(file.Buf()))}
+			walkLeaves(c, "", nil, func(l node, _ string, _ *uefi.File) {
+				fs.Uncompressed += uint64(len(l.Buf()))
+			})
+			fvs.Files = append(fvs.Files, fs)
+			fvs.Size += fs.Size
+			fvs.Uncompressed += fs.Uncompressed
+		}
+		sort.SliceStable(fvs.Files, func(i, j int) bool {
+			if v.Uncompressed {
+				return fvs.Files[i].Uncompressed > fvs.Files[j].Uncompressed
+			}
+			return fvs.Files[i].Size > fvs.Files[j].Size
+		})
+		v.FVs = append(v.FVs, fvs)
+	}
+	for i, c := range cs {
+		v.collect(c, fmt.Sprintf("%s/%d", path, i))
+	}
+}
+
+func init() {
+	Register(CLI{
+		Name: "sizes",
+		Help: "List the files of each FV ordered by size, largest first, with their uncompressed size and cumulative totals. " +
+			"With -sizes-sort uncompressed, they are ordered by their uncompressed size.",
+		Flags: []string{"sizes-sort"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			switch *sizesSort {
+			case "compressed", "uncompressed":
+			default:
+				return nil, fmt.Errorf("unknown size %q to sort by, expected compressed or uncompressed", *sizesSort)
+			}
+			return &Sizes{
+				Uncompressed: *sizesSort == "uncompressed",
+			}, nil
+		},
+	})
+}
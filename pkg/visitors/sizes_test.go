@@ -0,0 +1,47 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestSizes(t *testing.T) {
+	f := parseImage(t)
+	for _, uncompressed := range []bool{false, true} {
+		v := &Sizes{Uncompressed: uncompressed, W: ioutil.Discard}
+		if err := v.Run(f); err != nil {
+			t.Fatal(err)
+		}
+		if len(v.FVs) != 4 {
+			t.Fatalf("expected 4 FVs with files, got %d", len(v.FVs))
+		}
+		// The compressed FV holding the DXE FV.
+		outer := v.FVs[0]
+		if outer.Path != "/1" || len(outer.Files) != 1 || outer.Files[0].Uncompressed <= outer.Files[0].Size {
+			t.Errorf("unexpected sizes of the outer FV %+v", outer)
+		}
+		dxe := v.FVs[2]
+		if dxe.Path != "/1/0/0/3/0" || fileName(dxe.Files[0].File) != "Shell" {
+			t.Fatalf("expected the shell to be the largest DXE file, got %+v", dxe.Files[0])
+		}
+		var size, prev uint64
+		for i, fs := range dxe.Files {
+			key := fs.Size
+			if uncompressed {
+				key = fs.Uncompressed
+			}
+			if i != 0 && key > prev {
+				t.Errorf("file %d of %#x bytes is larger than the one before it", i, key)
+			}
+			prev = key
+			size += fs.Size
+		}
+		if size != dxe.Size {
+			t.Errorf("expected a total of %#x bytes, got %#x", size, dxe.Size)
+		}
+	}
+}
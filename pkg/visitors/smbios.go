@@ -0,0 +1,155 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// SMBIOSStructureFile is a default SMBIOS structure found in a file.
+type SMBIOSStructureFile struct {
+	File      uuid.UUID
+	Name      string `json:",omitempty"`
+	Structure *uefi.SMBIOSStructure
+
+	file *uefi.File
+}
+
+// FindSMBIOSStructures returns the default SMBIOS structures of the BIOS, the
+// system, the baseboard and the chassis found in the PE32, TE and raw
+// sections and in the raw files. The structures are slices of the buffers of
+// the image, so they can be changed in place.
+func FindSMBIOSStructures(f uefi.Firmware) ([]*SMBIOSStructureFile, error) {
+	files, err := allFiles(f)
+	if err != nil {
+		return nil, err
+	}
+	var structures []*SMBIOSStructureFile
+	add := func(file *uefi.File, buf []byte) {
+		for _, s := range uefi.FindSMBIOSStructures(buf) {
+			structures = append(structures, &SMBIOSStructureFile{File: file.Header.UUID, Name: fileName(file), Structure: s, file: file})
+		}
+	}
+	for _, file := range files {
+		if file.Header.Type == uefi.FVFileTypeRaw && len(file.Sections) == 0 {
+			if buf := file.Buf(); uint64(len(buf)) > file.DataOffset {
+				add(file, buf[file.DataOffset:])
+			}
+			continue
+		}
+		walkSections(file.Sections, func(s *uefi.Section) {
+			switch s.Header.Type {
+			case uefi.SectionTypePE32, uefi.SectionTypeTE, uefi.SectionTypeRaw:
+				add(file, sectionPayload(s))
+			}
+		})
+	}
+	return structures, nil
+}
+
+// SMBIOS prints the default SMBIOS structures of the image as JSON.
+type SMBIOS struct {
+	W io.Writer
+
+	// Output
+	Structures []*SMBIOSStructureFile
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SMBIOS) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the SMBIOS visitor to any Firmware type.
+func (v *SMBIOS) Visit(f uefi.Firmware) error {
+	var err error
+	if v.Structures, err = FindSMBIOSStructures(f); err != nil {
+		return err
+	}
+	if len(v.Structures) == 0 {
+		return fmt.Errorf("no SMBIOS structure found")
+	}
+	b, err := json.MarshalIndent(v.Structures, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(v.W, string(b))
+	return err
+}
+
+// SetSMBIOS sets a string field in all the default SMBIOS structures of its
+// type, e.g. the serial number of the system. The value may not be longer
+// than the current string, since the structures are changed in place.
+type SetSMBIOS struct {
+	// Input
+	// Type is the name of the structure type, e.g. System, without case.
+	Type  string
+	Field string
+	Value string
+
+	// Output
+	Structures []*SMBIOSStructureFile
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SetSMBIOS) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the SetSMBIOS visitor to any Firmware type.
+func (v *SetSMBIOS) Visit(f uefi.Firmware) error {
+	structures, err := FindSMBIOSStructures(f)
+	if err != nil {
+		return err
+	}
+	var files []*uefi.File
+	for _, s := range structures {
+		if strings.EqualFold(s.Structure.TypeName, v.Type) {
+			v.Structures = append(v.Structures, s)
+			files = append(files, s.file)
+		}
+	}
+	if len(v.Structures) == 0 {
+		return fmt.Errorf("no SMBIOS %v structure found", v.Type)
+	}
+	if err := checkProtected("set_smbios", files); err != nil {
+		return err
+	}
+	for _, s := range v.Structures {
+		if err := s.Structure.SetField(v.Field, v.Value); err != nil {
+			return fmt.Errorf("file %v: %v", s.File, err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "smbios",
+		Help: "Print the default SMBIOS structures of the BIOS, the system, the baseboard and the chassis, found in the data of the drivers and in the raw files, with their strings as JSON.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &SMBIOS{W: os.Stdout}, nil
+		},
+	})
+	Register(CLI{
+		Name: "set_smbios",
+		Args: []string{"TYPE.FIELD", "VALUE"},
+		Help: "Set a string field of the default SMBIOS structures, e.g. System.SerialNumber. The types are BIOS, System, Baseboard and Chassis. The structures are changed in place, so the value may not be longer than the current string, a shorter one is padded with spaces.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			i := strings.Index(args[0], ".")
+			if i < 0 {
+				return nil, fmt.Errorf("expected TYPE.FIELD, e.g. System.SerialNumber, got %q", args[0])
+			}
+			return &SetSMBIOS{Type: args[0][:i], Field: args[0][i+1:], Value: args[1]}, nil
+		},
+	})
+}
@@ -0,0 +1,74 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestSMBIOS(t *testing.T) {
+	var b bytes.Buffer
+	v := &SMBIOS{W: &b}
+	if err := v.Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	// OVMF only has the BIOS information in SmbiosPlatformDxe.
+	if len(v.Structures) != 1 || v.Structures[0].Name != "SmbiosPlatformDxe" {
+		t.Fatalf("expected the structure of SmbiosPlatformDxe, got %v", v.Structures)
+	}
+	s := v.Structures[0].Structure
+	if s.TypeName != "BIOS" || s.Fields["Vendor"] != "EFI Development Kit II / OVMF" || s.Fields["ReleaseDate"] != "02/06/2015" {
+		t.Errorf("unexpected structure %+v", s)
+	}
+	var structures []SMBIOSStructureFile
+	if err := json.Unmarshal(b.Bytes(), &structures); err != nil {
+		t.Fatal(err)
+	}
+	if len(structures) != 1 || structures[0].Structure.Fields["Version"] != "0.0.0" {
+		t.Errorf("unexpected JSON %s", b.String())
+	}
+}
+
+func TestSetSMBIOS(t *testing.T) {
+	f := parseImage(t)
+	s := &SetSMBIOS{Type: "bios", Field: "Vendor", Value: "LinuxBoot"}
+	if err := s.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Structures) != 1 {
+		t.Fatalf("expected 1 structure, got %d", len(s.Structures))
+	}
+
+	// The driver is in a compressed FV, check it after reparsing.
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	reparsed, err := uefi.Parse(f.Buf())
+	if err != nil {
+		t.Fatal(err)
+	}
+	structures, err := FindSMBIOSStructures(reparsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "LinuxBoot                    "
+	if len(structures) != 1 || structures[0].Structure.Fields["Vendor"] != want {
+		t.Fatalf("expected the vendor %q, got %v", want, structures)
+	}
+
+	for _, s := range []*SetSMBIOS{
+		{Type: "System", Field: "SerialNumber", Value: "1"},
+		{Type: "BIOS", Field: "Version", Value: "0.0.0.0"},
+		{Type: "BIOS", Field: "SerialNumber", Value: "1"},
+	} {
+		if err := s.Run(reparsed); err == nil {
+			t.Errorf("%v.%v: expected an error", s.Type, s.Field)
+		}
+	}
+}
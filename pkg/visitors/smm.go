@@ -0,0 +1,85 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// smmFileTypes are the file types only loaded into SMM.
+var smmFileTypes = map[uefi.FVFileType]bool{
+	uefi.FVFileTypeSMM:               true,
+	uefi.FVFileTypeCombinedSMMDXE:    true,
+	uefi.FVFileTypeSMMCore:           true,
+	uefi.FVFileTypeSMMStandalone:     true,
+	uefi.FVFileTypeSMMCoreStandalone: true,
+}
+
+// IsSMMModule returns whether the file is an SMM module, either by its type
+// or because it has an SMM dependency expression.
+func IsSMMModule(f *uefi.File) bool {
+	if smmFileTypes[f.Header.Type] {
+		return true
+	}
+	for _, s := range f.Sections {
+		if s.Header.Type == uefi.SectionMMDepEx {
+			return true
+		}
+	}
+	return false
+}
+
+// SMMReport lists all SMM modules with their GUIDs, names, types and sizes.
+type SMMReport struct {
+	// Input
+	W io.Writer
+
+	// Output
+	Modules []*uefi.File
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *SMMReport) Run(f uefi.Firmware) error {
+	find := Find{
+		Predicate: func(f *uefi.File, name string) bool {
+			return IsSMMModule(f)
+		},
+	}
+	if err := find.Run(f); err != nil {
+		return err
+	}
+	v.Modules = find.Matches
+	return f.Apply(v)
+}
+
+// Visit applies the SMMReport visitor to any Firmware type.
+func (v *SMMReport) Visit(f uefi.Firmware) error {
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "GUID\tName\tType\tSize\n")
+	for _, m := range v.Modules {
+		fmt.Fprintf(tw, "%v\t%s\t%v\t%d\n", m.Header.UUID, fileName(m), m.Header.Type, m.Header.ExtendedSize)
+	}
+	fmt.Fprintf(tw, "%d SMM modules\n", len(v.Modules))
+	return tw.Flush()
+}
+
+func init() {
+	Register(CLI{
+		Name: "smm",
+		Help: "List all SMM modules with their GUIDs, names, types and sizes.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &SMMReport{}, nil
+		},
+	})
+}
@@ -0,0 +1,49 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestIsSMMModule(t *testing.T) {
+	withSection := func(ft uefi.FVFileType, st uefi.SectionType) *uefi.File {
+		f := &uefi.File{}
+		f.Header.Type = ft
+		s := &uefi.Section{}
+		s.Header.Type = st
+		f.Sections = []*uefi.Section{s}
+		return f
+	}
+	var tests = []struct {
+		name string
+		file *uefi.File
+		smm  bool
+	}{
+		{"smmType", withSection(uefi.FVFileTypeSMM, uefi.SectionTypePE32), true},
+		{"combined", withSection(uefi.FVFileTypeCombinedSMMDXE, uefi.SectionTypePE32), true},
+		{"mmDepEx", withSection(uefi.FVFileTypeDriver, uefi.SectionMMDepEx), true},
+		{"dxeDriver", withSection(uefi.FVFileTypeDriver, uefi.SectionTypeDXEDepEx), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if smm := IsSMMModule(test.file); smm != test.smm {
+				t.Errorf("expected %v, got %v", test.smm, smm)
+			}
+		})
+	}
+
+	// OVMF is built without SMM support.
+	report := &SMMReport{W: ioutil.Discard}
+	if err := report.Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Modules) != 0 {
+		t.Errorf("expected no SMM modules in OVMF, got %d", len(report.Modules))
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+var stringsMinLen = flag.Int("strings-min-len", 4, "minimum length of the strings printed by strings")
+
+// FoundString is a string found by Strings.
+type FoundString struct {
+	// Path is the path of the node as in Ls, by child indices.
+	Path string
+	Node uefi.Firmware
+	// File is the file holding the node, if any.
+	File *uefi.File
+	// Offset is the offset of the string in the buffer of the node.
+	Offset uint64
+	// UTF16 is set for UTF-16LE strings, otherwise the string is ASCII.
+	UTF16 bool
+	Value string
+}
+
+// Strings extracts the printable ASCII and UTF-16LE strings from the leaf
+// nodes, including the decompressed ones. Most strings in firmware, such as
+// setup strings and device paths, are UTF-16 and are missed when looking for
+// ASCII strings only.
+type Strings struct {
+	// Input
+	MinLen int
+	W      io.Writer
+
+	// Output
+	Strings []FoundString
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *Strings) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the Strings visitor to any Firmware type.
+func (v *Strings) Visit(f uefi.Firmware) error {
+	v.Strings = nil
+	walkLeaves(node{Firmware: f, InFlash: true}, "", nil, func(n node, path string, file *uefi.File) {
+		for _, s := range findStrings(n.Buf(), v.MinLen) {
+			s.Path, s.Node, s.File = path, n.Firmware, file
+			v.Strings = append(v.Strings, s)
+		}
+	})
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Path\tFile\tEncoding\tOffset\tString\n")
+	for _, s := range v.Strings {
+		source := ""
+		if s.File != nil {
+			guid, name, _ := nodeInfo(s.File)
+			if source = name; source == "" {
+				source = guid
+			}
+		}
+		encoding := "ascii"
+		if s.UTF16 {
+			encoding = "utf16"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%#x\t%s\n", s.Path, source, encoding, s.Offset, s.Value)
+	}
+	return tw.Flush()
+}
+
+func isPrintable(b byte) bool {
+	return b >= 0x20 && b < 0x7f
+}
+
+// findStrings returns the ASCII and UTF-16LE strings of at least minLen
+// characters in buf, ordered by offset. UTF-16 strings are only found at even
+// offsets.
+func findStrings(buf []byte, minLen int) []FoundString {
+	if minLen < 1 {
+		minLen = 1
+	}
+	var found []FoundString
+	// ASCII runs.
+	start := -1
+	for i := 0; i <= len(buf); i++ {
+		if i < len(buf) && isPrintable(buf[i]) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 && i-start >= minLen {
+			found = append(found, FoundString{Offset: uint64(start), Value: string(buf[start:i])})
+		}
+		start = -1
+	}
+	// UTF-16LE runs, restricted to the printable ASCII range.
+	var utf16 []FoundString
+	var chars []byte
+	for i := 0; i <= len(buf); i += 2 {
+		if i+1 < len(buf) && isPrintable(buf[i]) && buf[i+1] == 0 {
+			if chars == nil {
+				start = i
+			}
+			chars = append(chars, buf[i])
+			continue
+		}
+		if len(chars) >= minLen {
+			utf16 = append(utf16, FoundString{Offset: uint64(start), UTF16: true, Value: string(chars)})
+		}
+		chars = nil
+	}
+	return mergeStrings(found, utf16)
+}
+
+// mergeStrings merges two lists of strings ordered by offset.
+func mergeStrings(a, b []FoundString) []FoundString {
+	merged := make([]FoundString, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0].Offset <= b[0].Offset {
+			merged, a = append(merged, a[0]), a[1:]
+		} else {
+			merged, b = append(merged, b[0]), b[1:]
+		}
+	}
+	return append(append(merged, a...), b...)
+}
+
+func init() {
+	Register(CLI{
+		Name: "strings",
+		Help: "Print the printable ASCII and UTF-16LE strings of the leaf nodes, including decompressed sections, " +
+			"with the path, file and offset of each. The minimum length is set with -strings-min-len.",
+		Flags: []string{"strings-min-len"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &Strings{
+				MinLen: *stringsMinLen,
+			}, nil
+		},
+	})
+}
@@ -0,0 +1,38 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestFindStrings(t *testing.T) {
+	buf := []byte("\x00\x01Hello\xffS\x00e\x00t\x00u\x00p\x00\x00\x00world")
+	want := []FoundString{
+		{Offset: 2, Value: "Hello"},
+		{Offset: 8, UTF16: true, Value: "Setup"},
+		{Offset: 20, Value: "world"},
+	}
+	if got := findStrings(buf, 4); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestStrings(t *testing.T) {
+	f := parseImage(t)
+	v := &Strings{MinLen: 6, W: ioutil.Discard}
+	if err := v.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	// The UI section of the PEI core.
+	for _, s := range v.Strings {
+		if s.UTF16 && s.Value == "PeiCore" && s.File != nil && fileName(s.File) == "PeiCore" {
+			return
+		}
+	}
+	t.Error("expected the UTF-16 name of the PEI core")
+}
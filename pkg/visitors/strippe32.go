@@ -0,0 +1,153 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/pecoff"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+var keepRelocs = flag.String("keep-relocs", "", "comma separated list of file GUIDs whose relocations are not stripped by strip_pe32")
+
+// StripPE32 strips the debug data from all PE32 sections, including those in
+// compressed sections, which are recompressed on assembly.
+//
+// Relocations are also stripped from execute in place modules in flash, as
+// those run at their image base. Modules which are shadowed to memory or
+// rebased later need their relocations and must be listed in KeepRelocs.
+// Other modules are loaded at arbitrary addresses and always keep them.
+type StripPE32 struct {
+	// Input
+	StripRelocs bool
+	KeepRelocs  map[uuid.UUID]bool
+
+	// Output
+	Stripped []*uefi.File
+	// Saved is the number of bytes removed from the images. Zeroed debug data
+	// is not included.
+	Saved int
+
+	// The stripped images, which are only written once none of their files
+	// turned out to be protected.
+	images map[*uefi.Section][]byte
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *StripPE32) Run(f uefi.Firmware) error {
+	// Offsets are only accurate once the tree is assembled.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	v.Stripped = nil
+	v.Saved = 0
+	v.images = make(map[*uefi.Section][]byte)
+	if err := v.strip(node{Firmware: f, InFlash: true}, nil); err != nil {
+		return err
+	}
+	if len(v.Stripped) == 0 {
+		return nil
+	}
+	if err := checkProtected("strip_pe32", v.Stripped); err != nil {
+		return err
+	}
+	for s, buf := range v.images {
+		s.SetBuf(buf)
+		if err := s.GenSecHeader(); err != nil {
+			return err
+		}
+	}
+	return (&Assemble{}).Run(f)
+}
+
+// Visit applies the StripPE32 visitor to any Firmware type.
+func (v *StripPE32) Visit(f uefi.Firmware) error {
+	return v.Run(f)
+}
+
+func (v *StripPE32) strip(n node, file *uefi.File) error {
+	if f, ok := n.Firmware.(*uefi.File); ok {
+		file = f
+	}
+	if s, ok := n.Firmware.(*uefi.Section); ok && s.Header.Type == uefi.SectionTypePE32 && file != nil {
+		return v.stripSection(s, file, n.InFlash && xipFileTypes[file.Header.Type])
+	}
+	for _, c := range children(n) {
+		if err := v.strip(c, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *StripPE32) stripSection(s *uefi.Section, f *uefi.File, xip bool) error {
+	hl := sectionHeaderLen(s)
+	img, err := pecoff.Parse(append([]byte{}, s.Buf()[hl:]...))
+	if err != nil {
+		log.Printf("not stripping image of file %v: %v", f.Header.UUID, err)
+		return nil
+	}
+	n, err := img.StripDebug()
+	if err != nil {
+		return fmt.Errorf("unable to strip debug data of file %v: %v", f.Header.UUID, err)
+	}
+	changed := n != 0
+	buf := img.Buf()
+	if v.StripRelocs && xip && !v.KeepRelocs[f.Header.UUID] && img.DataDirectory(pecoff.DirectoryBaseReloc).Size != 0 {
+		oldLen := len(buf)
+		if buf, err = img.StripRelocs(); err != nil {
+			return fmt.Errorf("unable to strip relocations of file %v: %v", f.Header.UUID, err)
+		}
+		v.Saved += oldLen - len(buf)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	v.images[s] = buf
+	if len(v.Stripped) == 0 || v.Stripped[len(v.Stripped)-1] != f {
+		v.Stripped = append(v.Stripped, f)
+	}
+	return nil
+}
+
+// parseGUIDList parses a comma separated list of GUIDs.
+func parseGUIDList(s string) (map[uuid.UUID]bool, error) {
+	guids := make(map[uuid.UUID]bool)
+	for _, g := range strings.Split(s, ",") {
+		if g = strings.TrimSpace(g); g == "" {
+			continue
+		}
+		u, err := uuid.Parse(g)
+		if err != nil {
+			return nil, err
+		}
+		guids[*u] = true
+	}
+	return guids, nil
+}
+
+func init() {
+	Register(CLI{
+		Name:  "strip_pe32",
+		Help:  "Strip the debug data of all PE32 images and the relocations of execute in place modules in flash.",
+		Flags: []string{"keep-relocs"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			keep, err := parseGUIDList(*keepRelocs)
+			if err != nil {
+				return nil, err
+			}
+			return &StripPE32{
+				StripRelocs: true,
+				KeepRelocs:  keep,
+			}, nil
+		},
+	})
+}
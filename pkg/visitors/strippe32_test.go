@@ -0,0 +1,61 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/pecoff"
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+func TestStripPE32(t *testing.T) {
+	secMain := *uuid.MustParse("DF1CCEF6-F301-4A63-9661-FC6030DCC880")
+	var tests = []struct {
+		name       string
+		keepRelocs map[uuid.UUID]bool
+		relocs     bool
+	}{
+		{"stripRelocs", nil, false},
+		{"keepRelocs", map[uuid.UUID]bool{secMain: true}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			f, err := uefi.NewFirmwareVolume(sampleFV, 0, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v := &StripPE32{StripRelocs: true, KeepRelocs: test.keepRelocs}
+			if err := v.Run(f); err != nil {
+				t.Fatal(err)
+			}
+			if len(v.Stripped) != 1 || v.Stripped[0].Header.UUID != secMain {
+				t.Fatalf("expected SecMain to be stripped, got %v", v.Stripped)
+			}
+			if test.relocs != (v.Saved == 0) {
+				t.Errorf("unexpected number of bytes saved: %d", v.Saved)
+			}
+
+			n, err := resolvePath(f, "/SecMain/0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			img, err := pecoff.Parse(n.Firmware.Buf()[4:])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if dd := img.DataDirectory(pecoff.DirectoryDebug); dd.Size != 0 {
+				t.Errorf("expected no debug directory, got %+v", dd)
+			}
+			if relocs := img.DataDirectory(pecoff.DirectoryBaseReloc).Size != 0; relocs != test.relocs {
+				t.Errorf("expected relocations %v, got %v", test.relocs, relocs)
+			}
+			if _, err := uefi.NewFirmwareVolume(f.Buf(), 0, false); err != nil {
+				t.Errorf("stripped FV does not parse: %v", err)
+			}
+		})
+	}
+}
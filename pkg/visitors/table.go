@@ -15,8 +15,10 @@ import (
 
 // Table prints the GUIDS, types and sizes as a compact table.
 type Table struct {
-	W      *tabwriter.Writer
-	indent int
+	W *tabwriter.Writer
+	// HideDeleted skips deleted files along with their sections.
+	HideDeleted bool
+	indent      int
 }
 
 // Run wraps Visit and performs some setup and teardown tasks.
@@ -29,9 +31,22 @@ func (v *Table) Visit(f uefi.Firmware) error {
 	switch f := f.(type) {
 	case *uefi.FlashImage:
 		return v.printRow(f, "Image", "", "", "")
+	case *uefi.CapsuleFile:
+		return v.printRow(f, "CapsuleFile", "", "", len(f.Buf()))
+	case *uefi.Capsule:
+		return v.printRow(f, "Capsule", f.Header.GUID.String(), f.Vendor, len(f.Buf()))
+	case *uefi.FMPImage:
+		return v.printRow(f, "FMP", f.Header.UpdateImageTypeID.String(), "", f.Header.UpdateImageSize)
+	case *uefi.IFlashFile:
+		return v.printRow(f, "IFlashFile", "", "", len(f.Buf()))
+	case *uefi.IFlashImage:
+		return v.printRow(f, "IFlash", "", f.Tag, f.Header.ImageSize)
 	case *uefi.FirmwareVolume:
 		return v.printRow(f, "FV", f.FileSystemGUID.String(), "", f.Length)
 	case *uefi.File:
+		if v.HideDeleted && f.IsDeleted() {
+			return nil
+		}
 		// TODO: make name part of the file node
 		return v.printRow(f, "File", f.Header.UUID.String(), f.Header.Type, f.Header.ExtendedSize)
 	case *uefi.Section:
@@ -41,13 +56,23 @@ func (v *Table) Visit(f uefi.Firmware) error {
 	case *uefi.BIOSRegion:
 		return v.printRow(f, "BIOS", "", "", "")
 	case *uefi.BIOSPadding:
-		return v.printRow(f, "BIOS Pad", "", "", fmt.Sprintf("%d", len(f.Buf())))
+		name := ""
+		if f.EC != nil {
+			name = f.EC.Vendor + " EC firmware"
+		}
+		return v.printRow(f, "BIOS Pad", name, "", fmt.Sprintf("%d", len(f.Buf())))
 	case *uefi.MERegion:
 		return v.printRow(f, "ME", "", "", "")
 	case *uefi.GBERegion:
 		return v.printRow(f, "GBE", "", "", "")
 	case *uefi.PDRegion:
 		return v.printRow(f, "PD", "", "", "")
+	case *uefi.ECRegion:
+		return v.printRow(f, "EC", "", "", "")
+	case *uefi.RawRegion:
+		return v.printRow(f, fmt.Sprintf("Region%d", f.Index), f.Name, "", "")
+	case *uefi.VariableStore:
+		return v.printRow(f, "NVRAM", f.Header.Signature.String(), fmt.Sprintf("%d vars", len(f.Variables)), f.Header.Size)
 	default:
 		return v.printRow(f, fmt.Sprintf("%T", f), "", "", "")
 	}
@@ -70,7 +95,12 @@ func (v *Table) printRow(f uefi.Firmware, node, name, typez, size interface{}) e
 }
 
 func init() {
-	RegisterCLI("table", 0, func(args []string) (uefi.Visitor, error) {
-		return &Table{}, nil
+	Register(CLI{
+		Name:  "table",
+		Help:  "Dump GUIDs and sizes to a compact table. This is only for human consumption and the format may change without notice.",
+		Flags: []string{"hide-deleted"},
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &Table{HideDeleted: *hideDeleted}, nil
+		},
 	})
 }
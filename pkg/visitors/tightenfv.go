@@ -0,0 +1,134 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"fmt"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// TightenFV shrinks a firmware volume of the BIOS region to the smallest
+// block aligned size holding its files. Erased pad files at the end of the
+// volume, or right before the volume top file, are dropped. The reclaimed
+// space becomes free space of the BIOS region. An FV holding the VTF keeps
+// its end at the top of the region and shrinks from the bottom.
+type TightenFV struct {
+	// Input
+	Selector FVSelector
+
+	// Output
+	Tightened *uefi.FirmwareVolume
+	Reclaimed uint64
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *TightenFV) Run(f uefi.Firmware) error {
+	// File lengths are only accurate once the tree is assembled.
+	if err := (&Assemble{}).Run(f); err != nil {
+		return err
+	}
+	v.Tightened, v.Reclaimed = nil, 0
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.Tightened == nil {
+		return fmt.Errorf("no BIOS region to tighten FV %v in", v.Selector)
+	}
+	return (&Assemble{}).Run(f)
+}
+
+// Visit applies the TightenFV visitor to any Firmware type.
+func (v *TightenFV) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.BIOSRegion:
+		_, fv, err := v.Selector.find(f)
+		if err != nil {
+			return err
+		}
+		reclaimed, err := tightenFV(fv)
+		if err != nil {
+			return fmt.Errorf("unable to tighten FV %v: %v", v.Selector, err)
+		}
+		if fv.HasVTF() {
+			fv.FVOffset += reclaimed
+		}
+		v.Tightened, v.Reclaimed = fv, reclaimed
+		return nil
+	}
+	return f.ApplyChildren(v)
+}
+
+// isErasedPad returns whether the file is a pad file without data.
+func isErasedPad(f *uefi.File) bool {
+	return f.Header.Type == uefi.FVFileTypePad && uefi.IsErased(f.Data(), uefi.Attributes.ErasePolarity)
+}
+
+// tightenFV drops the trailing erased pad files and sets the length of the FV
+// to the smallest multiple of the block size holding the files. It returns
+// the number of bytes reclaimed.
+func tightenFV(fv *uefi.FirmwareVolume) (uint64, error) {
+	if len(fv.Files) == 0 {
+		return 0, fmt.Errorf("FV has no parsed files")
+	}
+	if len(fv.Blocks) == 0 || fv.Blocks[0].Size == 0 {
+		return 0, fmt.Errorf("FV has no block size")
+	}
+	uefi.Attributes.ErasePolarity = fv.GetErasePolarity()
+	files := fv.Files
+	var vtf *uefi.File
+	if last := files[len(files)-1]; last.IsVTF() {
+		vtf, files = last, files[:len(files)-1]
+	}
+	for len(files) > 0 && isErasedPad(files[len(files)-1]) {
+		files = files[:len(files)-1]
+	}
+
+	// Files are laid out back to back, as Assemble does.
+	end := fv.DataOffset
+	for _, file := range files {
+		end = uefi.Align8(end) + uint64(len(file.Buf()))
+	}
+	blockSize := uint64(fv.Blocks[0].Size)
+	length := uefi.Align(end, blockSize)
+	if vtf != nil {
+		end = uefi.Align8(end)
+		vtfLen := uint64(len(vtf.Buf()))
+		length = uefi.Align(end+vtfLen, blockSize)
+		// The gap before the VTF has to be empty or big enough for a pad file.
+		if gap := length - vtfLen - end; gap != 0 && gap < uefi.FileHeaderMinLength {
+			length += blockSize
+		}
+		files = append(files, vtf)
+	}
+	if length >= fv.Length {
+		return 0, nil
+	}
+	reclaimed := fv.Length - length
+	// Keep only the header, Assemble rebuilds the rest from the files.
+	fv.SetBuf(append([]byte{}, fv.Buf()[:fv.DataOffset]...))
+	fv.Files = files
+	fv.Length = length
+	// Right now we assume there's only one block entry, as Assemble does.
+	fv.Blocks[0].Count = uint32(length / blockSize)
+	return reclaimed, nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "tighten_fv",
+		Args: []string{"(INDEX|FVNAME)"},
+		Help: "Shrink an FV of the BIOS region, selected as in replace_fv, to the smallest block aligned size holding its files. The reclaimed space is erased.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			sel, err := ParseFVSelector(args[0])
+			if err != nil {
+				return nil, err
+			}
+			return &TightenFV{
+				Selector: sel,
+			}, nil
+		},
+	})
+}
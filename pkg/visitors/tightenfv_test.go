@@ -0,0 +1,90 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestTightenFV(t *testing.T) {
+	image, err := ioutil.ReadFile("../../integration/roms/OVMF.rom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		name     string
+		selector string
+		start    uint64
+		end      uint64
+		ok       bool
+	}{
+		// The SEC FV holds the VTF, it shrinks from the bottom.
+		{"vtf", "2", 0x3cc000, 0x400000, true},
+		{"main", "1", 0x84000, 0x3cc000, true},
+		// The NVRAM FV has no files.
+		{"nvram", "0", 0, 0x84000, false},
+		{"missing", "3", 0, 0, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sel, err := ParseFVSelector(test.selector)
+			if err != nil {
+				t.Fatal(err)
+			}
+			br, err := uefi.NewBIOSRegion(append([]byte{}, image...), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tfv := &TightenFV{Selector: sel}
+			err = tfv.Run(br)
+			if !test.ok {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tfv.Reclaimed == 0 {
+				t.Fatal("no space reclaimed")
+			}
+			fv := tfv.Tightened
+			if fv.Length != test.end-test.start-tfv.Reclaimed {
+				t.Errorf("FV is %#x bytes, expected %#x", fv.Length, test.end-test.start-tfv.Reclaimed)
+			}
+			if fv.Length%uint64(fv.Blocks[0].Size) != 0 {
+				t.Errorf("FV length %#x is not a multiple of the block size %#x", fv.Length, fv.Blocks[0].Size)
+			}
+
+			nb := br.Buf()
+			if len(nb) != len(image) {
+				t.Fatalf("image length changed from %#x to %#x", len(image), len(nb))
+			}
+			fvStart, freeStart, freeEnd := test.start, test.start+fv.Length, test.end
+			if fv.HasVTF() {
+				fvStart, freeStart, freeEnd = test.end-fv.Length, test.start, test.end-fv.Length
+			}
+			if fv.FVOffset != fvStart {
+				t.Errorf("FV at %#x, expected %#x", fv.FVOffset, fvStart)
+			}
+			if !uefi.IsErased(nb[freeStart:freeEnd], 0xff) {
+				t.Errorf("reclaimed space is not erased")
+			}
+			// Other FVs are reassembled, which recompresses their sections, so
+			// only the NVRAM FV is compared.
+			if !bytes.Equal(nb[:0x84000], image[:0x84000]) {
+				t.Errorf("NVRAM FV changed")
+			}
+			if _, err := uefi.NewBIOSRegion(nb, nil); err != nil {
+				t.Errorf("tightened image does not parse: %v", err)
+			}
+		})
+	}
+}
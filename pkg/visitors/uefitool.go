@@ -0,0 +1,432 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+	"github.com/linuxboot/fiano/pkg/uuid"
+)
+
+// The UEFIExtract dump layout has a directory per node, named by the index of
+// the node in its parent and its name, e.g. "3 DxeCore". Each directory holds
+// the header of the node in header.bin, the body in body.bin and a
+// description in info.txt. The dump of an image is accompanied by a report,
+// a table of all the nodes.
+
+// uefiToolReportHeader is the header of the UEFIExtract report table.
+const uefiToolReportHeader = "      Type       |        Subtype        |   Base   |   Size   |  CRC32   |   Name \n"
+
+// UEFIToolExport writes any Firmware node in the dump layout of UEFIExtract,
+// for tools which read it, e.g. UEFIReplace based scripts.
+type UEFIToolExport struct {
+	DirPath string
+	// ReportPath is the path of the report, it is not written if empty.
+	ReportPath string
+
+	report io.Writer
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *UEFIToolExport) Run(f uefi.Firmware) error {
+	if v.ReportPath != "" {
+		r, err := createFile(v.ReportPath)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		if _, err := io.WriteString(r, uefiToolReportHeader); err != nil {
+			return &WriteError{err}
+		}
+		v.report = r
+	}
+	return v.export(node{Firmware: f, InFlash: true}, v.DirPath, 0)
+}
+
+// Visit applies the UEFIToolExport visitor to any Firmware type.
+func (v *UEFIToolExport) Visit(f uefi.Firmware) error {
+	return v.export(node{Firmware: f, InFlash: true}, v.DirPath, 0)
+}
+
+// export writes n to dir and its children to the numbered directories below.
+func (v *UEFIToolExport) export(n node, dir string, depth int) error {
+	header, body := uefiToolSplit(n.Firmware)
+	typez, subtype := uefiToolType(n.Firmware)
+	name := uefiToolName(n.Firmware)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return &WriteError{err}
+	}
+	if len(header) != 0 {
+		if err := writeFile(filepath.Join(dir, "header.bin"), header, 0644); err != nil {
+			return err
+		}
+	}
+	if err := writeFile(filepath.Join(dir, "body.bin"), body, 0644); err != nil {
+		return err
+	}
+	info := fmt.Sprintf("Type: %s\nSubtype: %s\n", typez, subtype)
+	if f, ok := n.Firmware.(*uefi.File); ok {
+		info += fmt.Sprintf("File GUID: %v\n", f.Header.UUID)
+	}
+	if name != "" {
+		info += fmt.Sprintf("Name: %s\n", name)
+	}
+	info += fmt.Sprintf("Full size: %Xh (%d)\nHeader size: %Xh (%d)\nBody size: %Xh (%d)\n",
+		len(n.Buf()), len(n.Buf()), len(header), len(header), len(body), len(body))
+	if err := writeFile(filepath.Join(dir, "info.txt"), []byte(info), 0644); err != nil {
+		return err
+	}
+	if v.report != nil {
+		base := "N/A     "
+		if n.InFlash {
+			base = fmt.Sprintf("%08X", n.Offset)
+		}
+		if _, err := fmt.Fprintf(v.report, " %-16s| %-22s| %s | %08X | %08X | %s %s\n", typez, subtype, base,
+			len(n.Buf()), crc32.ChecksumIEEE(n.Buf()), strings.Repeat("-", depth), name); err != nil {
+			return &WriteError{err}
+		}
+	}
+	for i, c := range children(n) {
+		cname := uefiToolName(c.Firmware)
+		if cname == "" {
+			ctype, csubtype := uefiToolType(c.Firmware)
+			cname = csubtype + " " + strings.ToLower(ctype)
+		}
+		cname = strings.NewReplacer("/", "_", "\\", "_").Replace(cname)
+		if err := v.export(c, filepath.Join(dir, fmt.Sprintf("%d %s", i, cname)), depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uefiToolSplit returns the header and body of a node as UEFIExtract splits
+// them. Nodes without a header are all body.
+func uefiToolSplit(f uefi.Firmware) (header, body []byte) {
+	buf := f.Buf()
+	var hl uint64
+	switch f := f.(type) {
+	case *uefi.FirmwareVolume:
+		hl = f.DataOffset
+	case *uefi.File:
+		hl = f.DataOffset
+	case *uefi.Section:
+		hl = uint64(len(buf) - len(sectionPayload(f)))
+	}
+	if hl > uint64(len(buf)) {
+		hl = uint64(len(buf))
+	}
+	return buf[:hl], buf[hl:]
+}
+
+// uefiToolType returns the type and subtype of a node for the UEFIExtract
+// report.
+func uefiToolType(f uefi.Firmware) (typez, subtype string) {
+	_, name, t := nodeInfo(f)
+	switch f := f.(type) {
+	case *uefi.FlashImage:
+		return "Image", "Intel"
+	case *uefi.CapsuleFile, *uefi.Capsule:
+		return "Capsule", name
+	case *uefi.FMPImage:
+		return "Image", "FMP"
+	case *uefi.IFlashFile:
+		return "Image", "Insyde"
+	case *uefi.IFlashImage:
+		return "Image", name
+	case *uefi.FlashDescriptor:
+		return "Region", "Descriptor"
+	case *uefi.BIOSRegion, *uefi.MERegion, *uefi.GBERegion, *uefi.PDRegion, *uefi.ECRegion, *uefi.RawRegion:
+		return "Region", t
+	case *uefi.BIOSPadding:
+		return "Padding", "Non-empty"
+	case *uefi.FirmwareVolume:
+		if name == "" {
+			name = "Unknown"
+		}
+		return "Volume", name
+	case *uefi.File:
+		return "File", strings.TrimPrefix(f.Type, "EFI_FV_FILETYPE_")
+	case *uefi.Section:
+		return "Section", strings.TrimPrefix(f.Type, "EFI_SECTION_")
+	case *uefi.VariableStore:
+		return "NVRAM", "Store"
+	}
+	return t, ""
+}
+
+// uefiToolName returns the name of a node in the dump, which is the name of
+// files and the GUID of FVs.
+func uefiToolName(f uefi.Firmware) string {
+	guid, name, _ := nodeInfo(f)
+	if name != "" {
+		return name
+	}
+	return guid
+}
+
+// UEFIToolImport reads back the leaf nodes of a dump in the layout of
+// UEFIExtract, and replaces the sections and raw files whose body.bin was
+// changed. Files are found by their GUID, and by their order if several have
+// the same GUID. Sections are found by their index in the file.
+type UEFIToolImport struct {
+	DirPath string
+
+	// Output
+	Replaced []string
+}
+
+// uefiToolLeaf is the body of a leaf node of the dump, along with the indices
+// of the sections leading to it from its file.
+type uefiToolLeaf struct {
+	path []int
+	body []byte
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *UEFIToolImport) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the UEFIToolImport visitor to any Firmware type.
+func (v *UEFIToolImport) Visit(f uefi.Firmware) error {
+	dump := make(map[uuid.UUID][][]uefiToolLeaf)
+	if err := readUEFIToolDump(v.DirPath, dump); err != nil {
+		return err
+	}
+	seen := make(map[uuid.UUID]int)
+	var files []*uefi.File
+	var walk func(n node)
+	walk = func(n node) {
+		if file, ok := n.Firmware.(*uefi.File); ok {
+			files = append(files, file)
+		}
+		for _, c := range children(n) {
+			walk(c)
+		}
+	}
+	walk(node{Firmware: f, InFlash: true})
+
+	// Nothing is replaced if one of the changed files is protected.
+	var changes []*uefiToolChange
+	var changed []*uefi.File
+	for _, file := range files {
+		g := file.Header.UUID
+		i := seen[g]
+		seen[g]++
+		if i >= len(dump[g]) {
+			continue
+		}
+		for _, leaf := range dump[g][i] {
+			c, err := importLeaf(file, leaf)
+			if err != nil {
+				return fmt.Errorf("file %v: %v", g, err)
+			}
+			if c != nil {
+				changes = append(changes, c)
+				changed = append(changed, file)
+			}
+		}
+	}
+	if err := checkProtected("uefitool_import", changed); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if err := c.replace(); err != nil {
+			return fmt.Errorf("file %v: %v", c.file.Header.UUID, err)
+		}
+		v.Replaced = append(v.Replaced, fmt.Sprintf("%v%s", c.file.Header.UUID, c.path))
+	}
+	for _, r := range v.Replaced {
+		log.Printf("replaced %s", r)
+	}
+	return nil
+}
+
+// uefiToolChange is a node of a file whose body changed in the dump.
+type uefiToolChange struct {
+	file    *uefi.File
+	path    string
+	replace func() error
+}
+
+// importLeaf returns the change of the section of file at the path of leaf,
+// or of the data of the file if it has no sections, or nil if its body did
+// not change.
+func importLeaf(file *uefi.File, leaf uefiToolLeaf) (*uefiToolChange, error) {
+	if len(leaf.path) == 0 {
+		if len(file.Sections) != 0 || bytes.Equal(file.Data(), leaf.body) {
+			return nil, nil
+		}
+		return &uefiToolChange{file: file, path: "/", replace: func() error {
+			buf := append(append([]byte{}, file.Buf()[:file.DataOffset]...), leaf.body...)
+			if file.HasTail() {
+				// The tail is rewritten by Assemble.
+				buf = append(buf, make([]byte, uefi.FileTailLength)...)
+			}
+			file.SetBuf(buf)
+			return nil
+		}}, nil
+	}
+	var s *uefi.Section
+	sections := file.Sections
+	var path string
+	for _, i := range leaf.path {
+		if i >= len(sections) {
+			return nil, fmt.Errorf("no section %s/%d", path, i)
+		}
+		s = sections[i]
+		path += fmt.Sprintf("/%d", i)
+		sections = nil
+		for _, e := range s.Encapsulated {
+			if es, ok := e.Value.(*uefi.Section); ok {
+				sections = append(sections, es)
+			}
+		}
+	}
+	if len(s.Encapsulated) != 0 || bytes.Equal(sectionPayload(s), leaf.body) {
+		return nil, nil
+	}
+	return &uefiToolChange{file: file, path: path, replace: func() error {
+		s.SetBuf(append([]byte{}, leaf.body...))
+		return s.GenSecHeader()
+	}}, nil
+}
+
+// readUEFIToolDump collects the leaves of the files in the dump at dir, by
+// file GUID and in the order of the files.
+func readUEFIToolDump(dir string, dump map[uuid.UUID][][]uefiToolLeaf) error {
+	info, err := ioutil.ReadFile(filepath.Join(dir, "info.txt"))
+	if err != nil {
+		return err
+	}
+	if g := infoFileGUID(info); g != nil {
+		// The files in FV image sections come after the file.
+		i := len(dump[*g])
+		dump[*g] = append(dump[*g], nil)
+		var leaves []uefiToolLeaf
+		if err := readUEFIToolLeaves(dir, nil, &leaves, dump); err != nil {
+			return err
+		}
+		dump[*g][i] = leaves
+		return nil
+	}
+	subdirs, err := uefiToolSubdirs(dir)
+	if err != nil {
+		return err
+	}
+	for _, d := range subdirs {
+		if err := readUEFIToolDump(d.path, dump); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readUEFIToolLeaves collects the leaves below dir, a file or a section. FVs
+// in sections are read like the dump.
+func readUEFIToolLeaves(dir string, path []int, leaves *[]uefiToolLeaf, dump map[uuid.UUID][][]uefiToolLeaf) error {
+	info, err := ioutil.ReadFile(filepath.Join(dir, "info.txt"))
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(string(info), "Type: Volume\n") {
+		return readUEFIToolDump(dir, dump)
+	}
+	subdirs, err := uefiToolSubdirs(dir)
+	if err != nil {
+		return err
+	}
+	if len(subdirs) == 0 {
+		body, err := ioutil.ReadFile(filepath.Join(dir, "body.bin"))
+		if err != nil {
+			return err
+		}
+		*leaves = append(*leaves, uefiToolLeaf{path: path, body: body})
+		return nil
+	}
+	for _, d := range subdirs {
+		p := append(append([]int{}, path...), d.index)
+		if err := readUEFIToolLeaves(d.path, p, leaves, dump); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type uefiToolDir struct {
+	index int
+	path  string
+}
+
+// uefiToolSubdirs returns the numbered directories of the children in dir,
+// in the order of their index.
+func uefiToolSubdirs(dir string) ([]uefiToolDir, error) {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var dirs []uefiToolDir
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		index, err := strconv.Atoi(strings.SplitN(fi.Name(), " ", 2)[0])
+		if err != nil {
+			continue
+		}
+		dirs = append(dirs, uefiToolDir{index: index, path: filepath.Join(dir, fi.Name())})
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].index < dirs[j].index })
+	return dirs, nil
+}
+
+// infoFileGUID returns the file GUID of an info.txt, or nil if it does not
+// describe a file.
+func infoFileGUID(info []byte) *uuid.UUID {
+	for _, line := range strings.Split(string(info), "\n") {
+		if strings.HasPrefix(line, "File GUID:") {
+			g, err := uuid.Parse(strings.TrimSpace(strings.TrimPrefix(line, "File GUID:")))
+			if err != nil {
+				return nil
+			}
+			return g
+		}
+	}
+	return nil
+}
+
+func init() {
+	Register(CLI{
+		Name: "uefitool_export",
+		Args: []string{"DIR"},
+		Help: "Write the image to DIR in the dump layout of UEFIExtract, a directory per node with header.bin, body.bin and info.txt, " +
+			"along with the report table in DIR.report.txt.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			dir := strings.TrimRight(args[0], "/")
+			return &UEFIToolExport{DirPath: dir, ReportPath: dir + ".report.txt"}, nil
+		},
+	})
+	Register(CLI{
+		Name: "uefitool_import",
+		Args: []string{"DIR"},
+		Help: "Read back a dump in the layout of UEFIExtract from DIR, and replace the sections and raw files whose body.bin was modified. " +
+			"Files are matched by GUID, sections by their index in the file. Use save to write the image.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &UEFIToolImport{DirPath: args[0]}, nil
+		},
+	})
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+func TestUEFIToolExportImport(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "uefitool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	dir := filepath.Join(tmp, "OVMF.dump")
+	report := filepath.Join(tmp, "OVMF.report.txt")
+
+	f := parseImage(t)
+	if err := (&UEFIToolExport{DirPath: dir, ReportPath: report}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	r, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(r), uefiToolReportHeader) || !strings.Contains(string(r), "| -- SecMain\n") {
+		t.Errorf("unexpected report\n%s", r)
+	}
+	info, err := ioutil.ReadFile(filepath.Join(dir, "2 FFS2", "0 SecMain", "info.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(info), "File GUID: DF1CCEF6-F301-4A63-9661-FC6030DCC880\n") {
+		t.Errorf("unexpected info.txt\n%s", info)
+	}
+
+	// Nothing is replaced from an unmodified dump.
+	imp := &UEFIToolImport{DirPath: dir}
+	if err := imp.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(imp.Replaced) != 0 {
+		t.Errorf("expected no replacements, got %v", imp.Replaced)
+	}
+
+	// Modify a PE32 section in flash and one in the compressed FV.
+	secMain := filepath.Join(dir, "2 FFS2", "0 SecMain", "0 PE32 section", "body.bin")
+	peiCore, err := filepath.Glob(filepath.Join(dir, "*", "*", "*", "*", "*", "* PeiCore", "* PE32 section", "body.bin"))
+	if err != nil || len(peiCore) != 1 {
+		t.Fatalf("expected the PE32 section of PeiCore, got %v: %v", peiCore, err)
+	}
+	for _, p := range []string{secMain, peiCore[0]} {
+		if err := ioutil.WriteFile(p, []byte("new PE32 of "+p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	imp = &UEFIToolImport{DirPath: dir}
+	if err := imp.Run(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(imp.Replaced) != 2 {
+		t.Fatalf("expected 2 replacements, got %v", imp.Replaced)
+	}
+	if err := (&Assemble{}).Run(f); err != nil {
+		t.Fatal(err)
+	}
+	f, err = uefi.Parse(append([]byte{}, f.Buf()...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, p := range map[string]string{"SecMain": secMain, "PeiCore": peiCore[0]} {
+		find := &Find{
+			Predicate: func(f *uefi.File, n string) bool {
+				return n == name
+			},
+		}
+		if err := find.Run(f); err != nil {
+			t.Fatal(err)
+		}
+		if len(find.Matches) != 1 {
+			t.Fatalf("expected one %v, got %d", name, len(find.Matches))
+		}
+		var got []byte
+		for _, s := range find.Matches[0].Sections {
+			if s.Header.Type == uefi.SectionTypePE32 {
+				got = sectionPayload(s)
+			}
+		}
+		if !bytes.Equal(got, []byte("new PE32 of "+p)) {
+			t.Errorf("%v: PE32 section not replaced, got %q", name, got)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// UFPJSON prints any Firmware node as JSON in the structure of the object
+// tree of uefi-firmware-parser, so scripts written against its output keep
+// working. Each object has the class name of the Python object, its offset
+// from the start of its parent, or of the decompressed data for objects in
+// compressed sections, its size and the child objects.
+type UFPJSON struct {
+	// W is written to instead of os.Stdout if set.
+	W io.Writer
+}
+
+// ufpObject is an object of the uefi-firmware-parser tree.
+type ufpObject struct {
+	Class      string       `json:"class"`
+	GUID       string       `json:"guid,omitempty"`
+	Name       string       `json:"name,omitempty"`
+	Type       *uint8       `json:"type,omitempty"`
+	Attributes *uint32      `json:"attributes,omitempty"`
+	State      *uint8       `json:"state,omitempty"`
+	Offset     uint64       `json:"offset"`
+	Size       uint64       `json:"size"`
+	Objects    []*ufpObject `json:"objects,omitempty"`
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *UFPJSON) Run(f uefi.Firmware) error {
+	return f.Apply(v)
+}
+
+// Visit applies the UFPJSON visitor to any Firmware type.
+func (v *UFPJSON) Visit(f uefi.Firmware) error {
+	w := v.W
+	if w == nil {
+		w = os.Stdout
+	}
+	b, err := json.MarshalIndent(ufpTree(node{Firmware: f, InFlash: true}, 0), "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// ufpTree returns the object of n and its children. offset is the offset of
+// n from the start of its parent.
+func ufpTree(n node, offset uint64) *ufpObject {
+	o := &ufpObject{Offset: offset, Size: uint64(len(n.Buf()))}
+	u8 := func(x uint8) *uint8 { return &x }
+	u32 := func(x uint32) *uint32 { return &x }
+	switch f := n.Firmware.(type) {
+	case *uefi.FlashImage:
+		o.Class = "FlashDescriptor"
+	case *uefi.FlashDescriptor:
+		o.Class = "FlashRegion"
+		o.Name = "descriptor"
+	case *uefi.BIOSRegion, *uefi.MERegion, *uefi.GBERegion, *uefi.PDRegion, *uefi.ECRegion, *uefi.RawRegion:
+		_, name, typez := nodeInfo(f)
+		if name == "" {
+			name = typez
+		}
+		o.Class = "FlashRegion"
+		o.Name = strings.ToLower(name)
+	case *uefi.Capsule:
+		o.Class = "FirmwareCapsule"
+		o.GUID = f.Header.GUID.String()
+	case *uefi.FirmwareVolume:
+		o.Class = "FirmwareVolume"
+		o.GUID = f.FileSystemGUID.String()
+		o.Attributes = u32(uint32(f.Attributes))
+	case *uefi.File:
+		o.Class = "FirmwareFile"
+		o.GUID = f.Header.UUID.String()
+		o.Name = fileName(f)
+		o.Type = u8(uint8(f.Header.Type))
+		o.Attributes = u32(uint32(f.Header.Attributes))
+		o.State = u8(f.Header.State)
+	case *uefi.Section:
+		o.Class = "FirmwareFileSystemSection"
+		o.Type = u8(uint8(f.Header.Type))
+		switch f.Header.Type {
+		case uefi.SectionTypeCompression:
+			o.Class = "CompressedSection"
+		case uefi.SectionTypeGUIDDefined:
+			o.Class = "GuidDefinedSection"
+			if f.TypeSpecific != nil {
+				if gd, ok := f.TypeSpecific.Header.(*uefi.SectionGUIDDefined); ok {
+					o.GUID = gd.GUID.String()
+				}
+			}
+		case uefi.SectionTypeUserInterface:
+			o.Name = f.Name
+		}
+	case *uefi.VariableStore:
+		o.Class = "VariableStore"
+		o.GUID = f.Header.Signature.String()
+		for _, va := range f.Variables {
+			o.Objects = append(o.Objects, &ufpObject{
+				Class:      "Variable",
+				GUID:       va.Header.VendorGUID.String(),
+				Name:       va.Name,
+				Attributes: u32(va.Header.Attributes),
+				Offset:     va.Offset,
+				Size:       uint64(len(va.Buf())),
+			})
+		}
+	default:
+		_, _, o.Class = nodeInfo(f)
+	}
+	// The objects in compressed sections are located in the decompressed
+	// data.
+	s, decompressed := n.Firmware.(*uefi.Section)
+	decompressed = decompressed && s.Header.Type != uefi.SectionTypeFirmwareVolumeImage
+	for _, c := range children(n) {
+		off := c.Offset - n.Offset
+		if decompressed {
+			off = c.Offset
+		}
+		o.Objects = append(o.Objects, ufpTree(c, off))
+	}
+	return o
+}
+
+func init() {
+	Register(CLI{
+		Name: "ufp_json",
+		Help: "Dump the image as JSON in the object structure of uefi-firmware-parser: the class, GUID, name, type, offset in the parent and size " +
+			"of each object, with the child objects.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			return &UFPJSON{}, nil
+		},
+	})
+}
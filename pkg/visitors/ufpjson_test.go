@@ -0,0 +1,45 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+)
+
+func TestUFPJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&UFPJSON{W: &buf}).Run(parseImage(t)); err != nil {
+		t.Fatal(err)
+	}
+	var root ufpObject
+	if err := json.Unmarshal(buf.Bytes(), &root); err != nil {
+		t.Fatal(err)
+	}
+	if root.Class != "FlashRegion" || root.Name != "bios" || len(root.Objects) != 3 {
+		t.Fatalf("unexpected root %+v", root)
+	}
+
+	// The SEC FV and SecMain, relative to their parents.
+	fv := root.Objects[2]
+	if fv.Class != "FirmwareVolume" || fv.Offset != 0x3cc000 || len(fv.Objects) != 3 {
+		t.Fatalf("unexpected FV %+v", fv)
+	}
+	sec := fv.Objects[0]
+	if sec.Class != "FirmwareFile" || sec.Name != "SecMain" || sec.GUID != "DF1CCEF6-F301-4A63-9661-FC6030DCC880" ||
+		sec.Offset != 0x78 || sec.Type == nil || *sec.Type != 3 {
+		t.Errorf("unexpected file %+v", sec)
+	}
+
+	// The objects in the LZMA section are located in the decompressed data.
+	lzma := root.Objects[1].Objects[0].Objects[0]
+	if lzma.Class != "GuidDefinedSection" || lzma.GUID != "EE4E5898-3914-4259-9D6E-DC7BD79403CF" {
+		t.Fatalf("unexpected section %+v", lzma)
+	}
+	if len(lzma.Objects) != 4 || lzma.Objects[0].Offset != 0 || lzma.Objects[1].Offset != 0x7c {
+		t.Errorf("unexpected decompressed sections %+v", lzma.Objects)
+	}
+}
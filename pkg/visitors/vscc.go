@@ -0,0 +1,70 @@
+// Copyright 2018 the LinuxBoot Authors. All rights reserved
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package visitors
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/linuxboot/fiano/pkg/uefi"
+)
+
+// AddVSCC adds an entry for a replacement flash chip to the VSCC table of the
+// flash descriptor.
+type AddVSCC struct {
+	// Input
+	JEDECID uint32
+	VSCC    uint32
+
+	// Output
+	Entry *uefi.VSCCEntry
+}
+
+// Run wraps Visit and performs some setup and teardown tasks.
+func (v *AddVSCC) Run(f uefi.Firmware) error {
+	if err := f.Apply(v); err != nil {
+		return err
+	}
+	if v.Entry == nil {
+		return errors.New("no flash descriptor to add a VSCC entry to")
+	}
+	return nil
+}
+
+// Visit applies the AddVSCC visitor to any Firmware type.
+func (v *AddVSCC) Visit(f uefi.Firmware) error {
+	switch f := f.(type) {
+	case *uefi.FlashDescriptor:
+		e := uefi.NewVSCCEntry(v.JEDECID, v.VSCC)
+		if err := f.AddVSCCEntry(e); err != nil {
+			return err
+		}
+		v.Entry = &e
+		return nil
+	}
+	return f.ApplyChildren(v)
+}
+
+func init() {
+	Register(CLI{
+		Name: "add_vscc",
+		Args: []string{"JEDECID", "VSCC"},
+		Help: "Add an entry for a replacement SPI flash chip to the VSCC table of the flash descriptor.",
+		Create: func(args []string) (uefi.Visitor, error) {
+			jedecID, err := strconv.ParseUint(args[0], 0, 24)
+			if err != nil {
+				return nil, err
+			}
+			vscc, err := strconv.ParseUint(args[1], 0, 32)
+			if err != nil {
+				return nil, err
+			}
+			return &AddVSCC{
+				JEDECID: uint32(jedecID),
+				VSCC:    uint32(vscc),
+			}, nil
+		},
+	})
+}